@@ -35,11 +35,40 @@ const (
 
 	// NetBoxIPCRDName is the full name of the CRD.
 	NetBoxIPCRDName = NetBoxIPPlural + "." + GroupName
+
+	// NetBoxIPControllerConfigKind is the kind of the controller configuration CRD.
+	NetBoxIPControllerConfigKind = "NetBoxIPControllerConfig"
+
+	// NetBoxIPControllerConfigPlural is the plural form of the controller configuration CRD.
+	NetBoxIPControllerConfigPlural = "netboxipcontrollerconfigs"
+
+	// NetBoxIPControllerConfigCRDName is the full name of the controller configuration CRD.
+	NetBoxIPControllerConfigCRDName = NetBoxIPControllerConfigPlural + "." + GroupName
+
+	// NetBoxIPClaimKind is the kind of the IP claim CRD.
+	NetBoxIPClaimKind = "NetBoxIPClaim"
+
+	// NetBoxIPClaimPlural is the plural form of the IP claim CRD.
+	NetBoxIPClaimPlural = "netboxipclaims"
+
+	// NetBoxIPClaimCRDName is the full name of the IP claim CRD.
+	NetBoxIPClaimCRDName = NetBoxIPClaimPlural + "." + GroupName
 )
 
 var (
 	// NetBoxIPShortNames is the list of short names for the CRD.
-	NetBoxIPShortNames = []string{"netboxip"}
+	NetBoxIPShortNames = []string{"netboxip", "nbip"}
+
+	// NetBoxIPCategories is the list of categories of the CRD, which
+	// kubectl lists resources of, e.g. with `kubectl get netbox`.
+	NetBoxIPCategories = []string{"netbox"}
+
+	// ImmutableAddressRule is the validation rule of the NetBoxIP spec
+	// added by WithImmutableAddress.
+	ImmutableAddressRule = apiextensionsv1.ValidationRule{
+		Rule:    "self.address == oldSelf.address",
+		Message: "address is immutable: delete and re-create the NetBoxIP to change it",
+	}
 
 	// NetBoxIPCRD is the full custom resource definition.
 	NetBoxIPCRD = &apiextensionsv1.CustomResourceDefinition{
@@ -53,12 +82,18 @@ var (
 				Plural:     NetBoxIPPlural,
 				Kind:       NetBoxIPKind,
 				ShortNames: NetBoxIPShortNames,
+				Categories: NetBoxIPCategories,
 			},
 			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
 				Name:    "v1beta1",
 				Served:  true,
 				Storage: true,
 				Schema:  v1beta1.NetBoxIPValidationSchema,
+				// the status is written separately, so that
+				// writing it does not change the generation
+				Subresources: &apiextensionsv1.CustomResourceSubresources{
+					Status: &apiextensionsv1.CustomResourceSubresourceStatus{},
+				},
 				AdditionalPrinterColumns: []apiextensionsv1.CustomResourceColumnDefinition{
 					{
 						Name:     "address",
@@ -73,4 +108,87 @@ var (
 			}},
 		},
 	}
+
+	// NetBoxIPClaimCRD is the full custom resource definition
+	// of claims of available IPs in NetBox prefixes.
+	NetBoxIPClaimCRD = &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: NetBoxIPClaimCRDName,
+		},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: GroupName,
+			Scope: apiextensionsv1.NamespaceScoped,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Plural:     NetBoxIPClaimPlural,
+				Kind:       NetBoxIPClaimKind,
+				ShortNames: []string{"netboxipclaim", "nbipclaim"},
+				Categories: NetBoxIPCategories,
+			},
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
+				Name:    "v1beta1",
+				Served:  true,
+				Storage: true,
+				Schema:  v1beta1.NetBoxIPClaimValidationSchema,
+				Subresources: &apiextensionsv1.CustomResourceSubresources{
+					Status: &apiextensionsv1.CustomResourceSubresourceStatus{},
+				},
+				AdditionalPrinterColumns: []apiextensionsv1.CustomResourceColumnDefinition{
+					{
+						Name:     "prefix",
+						Type:     "string",
+						JSONPath: ".spec.prefix",
+					}, {
+						Name:     "address",
+						Type:     "string",
+						JSONPath: ".status.address",
+					},
+				},
+			}},
+		},
+	}
+
+	// NetBoxIPControllerConfigCRD is the full custom resource definition
+	// of the cluster-scoped controller configuration.
+	NetBoxIPControllerConfigCRD = &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: NetBoxIPControllerConfigCRDName,
+		},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: GroupName,
+			Scope: apiextensionsv1.ClusterScoped,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Plural: NetBoxIPControllerConfigPlural,
+				Kind:   NetBoxIPControllerConfigKind,
+			},
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
+				Name:    "v1beta1",
+				Served:  true,
+				Storage: true,
+				Schema:  v1beta1.NetBoxIPControllerConfigValidationSchema,
+			}},
+		},
+	}
 )
+
+// WithCategories returns a copy of the CRD, which is in
+// the given categories in addition to its own.
+func WithCategories(crd *apiextensionsv1.CustomResourceDefinition, categories ...string) *apiextensionsv1.CustomResourceDefinition {
+	crd = crd.DeepCopy()
+	crd.Spec.Names.Categories = append(crd.Spec.Names.Categories, categories...)
+	return crd
+}
+
+// WithImmutableAddress returns a copy of the NetBoxIP CRD, whose spec
+// has the ImmutableAddressRule, so that the API server rejects changes
+// of the address of NetBoxIPs, which must be deleted and re-created
+// instead. Requires CRD validation rules, i.e. Kubernetes 1.25 or later.
+func WithImmutableAddress(crd *apiextensionsv1.CustomResourceDefinition) *apiextensionsv1.CustomResourceDefinition {
+	crd = crd.DeepCopy()
+	for _, version := range crd.Spec.Versions {
+		schema := version.Schema.OpenAPIV3Schema
+		spec := schema.Properties["spec"]
+		spec.XValidations = append(spec.XValidations, ImmutableAddressRule)
+		schema.Properties["spec"] = spec
+	}
+	return crd
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWithImmutableAddress(t *testing.T) {
+	crd := WithImmutableAddress(NetBoxIPCRD)
+
+	rules := crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["spec"].XValidations
+	if len(rules) != 1 || rules[0] != ImmutableAddressRule {
+		t.Errorf("want the immutable address rule, got %+v", rules)
+	}
+	// the registered CRD must not be changed
+	if rules := NetBoxIPCRD.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["spec"].XValidations; len(rules) != 0 {
+		t.Errorf("want no rules in the CRD, got %+v", rules)
+	}
+	if diff := cmp.Diff(NetBoxIPCRD.Spec.Names, crd.Spec.Names); diff != "" {
+		t.Errorf("names (-want, +got)\n%s", diff)
+	}
+}
+
+func TestImmutableAddressRule(t *testing.T) {
+	env, err := cel.NewEnv(
+		cel.Variable("self", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("oldSelf", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast, issues := env.Compile(ImmutableAddressRule.Rule)
+	if issues.Err() != nil {
+		t.Fatalf("compiling rule: %s", issues.Err())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		oldSpec  map[string]interface{}
+		spec     map[string]interface{}
+		expected bool
+	}{{
+		name:     "address unchanged",
+		oldSpec:  map[string]interface{}{"address": "10.0.0.1", "dnsName": "foo"},
+		spec:     map[string]interface{}{"address": "10.0.0.1", "dnsName": "bar"},
+		expected: true,
+	}, {
+		name:     "address changed",
+		oldSpec:  map[string]interface{}{"address": "10.0.0.1"},
+		spec:     map[string]interface{}{"address": "10.0.0.2"},
+		expected: false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, _, err := program.Eval(map[string]interface{}{"self": test.spec, "oldSelf": test.oldSpec})
+			if err != nil {
+				t.Fatalf("evaluating rule: %s", err)
+			}
+			if out.Value() != test.expected {
+				t.Errorf("want %v, got %v", test.expected, out.Value())
+			}
+		})
+	}
+}
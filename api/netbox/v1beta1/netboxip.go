@@ -19,6 +19,7 @@ package v1beta1
 import (
 	"fmt"
 	"net/netip"
+	"strings"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -38,14 +39,55 @@ type NetBoxIP struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
 	Spec NetBoxIPSpec `json:"spec"`
+	// Status is written by the controllers together with the spec.
+	Status NetBoxIPStatus `json:"status,omitempty"`
 }
 
 // NetBoxIPSpec defines the custom fields of the NetBoxIP resource.
 type NetBoxIPSpec struct {
-	Address     netip.Addr `json:"address"`
-	DNSName     string     `json:"dnsName"`
-	Tags        []Tag      `json:"tags,omitempty"`
-	Description string     `json:"description,omitempty"`
+	Address netip.Addr `json:"address"`
+	// PrefixLength is the prefix length the address is published with,
+	// e.g. 24 for 10.0.0.0/24. If not set, it is the length of the
+	// address, i.e. 32 for IPv4 and 128 for IPv6 addresses.
+	PrefixLength int32  `json:"prefixLength,omitempty"`
+	DNSName      string `json:"dnsName,omitempty"`
+	Tags         []Tag  `json:"tags,omitempty"`
+	Description  string `json:"description,omitempty"`
+	// Tenant is the slug of the NetBox tenant the IP belongs to.
+	Tenant string `json:"tenant,omitempty"`
+	// VRF is the name of the NetBox VRF the IP belongs to.
+	VRF string `json:"vrf,omitempty"`
+	// AssignedObject is the NetBox interface the IP is assigned to, if any.
+	AssignedObject *AssignedObject `json:"assignedObject,omitempty"`
+	// CustomFields are the values of NetBox text custom fields of the IP,
+	// by field name. An empty value clears the field.
+	CustomFields map[string]string `json:"customFields,omitempty"`
+	// NATInside is the name of the NetBoxIP in the same namespace whose IP
+	// is the inside address of this IP in NetBox, e.g. the cluster IP of the
+	// service that a load balancer address forwards to.
+	NATInside string `json:"natInside,omitempty"`
+}
+
+// Kinds of NetBox interfaces that IPs can be assigned to.
+const (
+	AssignedObjectKindInterface   = "Interface"
+	AssignedObjectKindVMInterface = "VMInterface"
+)
+
+// AssignedObject references a NetBox interface, either by ID, or by
+// the names of the interface and its device or virtual machine.
+type AssignedObject struct {
+	// Kind is Interface for device interfaces,
+	// or VMInterface for virtual machine interfaces.
+	Kind string `json:"kind"`
+	ID   int64  `json:"id,omitempty"`
+	// Name is the name of the interface, used together with Parent,
+	// the name of its device or virtual machine, if ID is not set.
+	Name   string `json:"name,omitempty"`
+	Parent string `json:"parent,omitempty"`
+	// MACAddress, if set, is the MAC address of the interface, which is
+	// created by the controller on its parent if it does not exist.
+	MACAddress string `json:"macAddress,omitempty"`
 }
 
 // DeepCopyInto is normally an autogenerated deepcopy function,
@@ -64,6 +106,18 @@ func (spec *NetBoxIPSpec) DeepCopyInto(out *NetBoxIPSpec) {
 		*out = make([]Tag, len(*in))
 		copy(*out, *in)
 	}
+	if spec.AssignedObject != nil {
+		in, out := &spec.AssignedObject, &out.AssignedObject
+		*out = new(AssignedObject)
+		**out = **in
+	}
+	if spec.CustomFields != nil {
+		in, out := &spec.CustomFields, &out.CustomFields
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // Changed returns true if the two NetBoxIP specs differ.
@@ -81,6 +135,75 @@ func (spec NetBoxIPSpec) Changed(spec2 NetBoxIPSpec) bool {
 	)
 }
 
+// MaxAddressHistory is the maximum number of
+// previous addresses kept in the status of a NetBoxIP.
+const MaxAddressHistory = 10
+
+// NetBoxIPStatus defines the observed state of the NetBoxIP resource.
+type NetBoxIPStatus struct {
+	// ObservedGeneration is the generation of the NetBoxIP last
+	// reconciled by the controller, whether or not its IP was synced.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// SyncedGeneration is the generation of the NetBoxIP last synced
+	// to NetBox: the current spec has been applied to NetBox once it
+	// is equal to metadata.generation, not only accepted by the API server.
+	SyncedGeneration int64 `json:"syncedGeneration,omitempty"`
+	// NetBoxID is the ID of the IP in NetBox, or in the IPAM system in use,
+	// as of the last time it was created or updated.
+	NetBoxID int64 `json:"netboxID,omitempty"`
+	// NetBoxURL is the URL of the IP in the web UI of NetBox.
+	NetBoxURL string `json:"netboxURL,omitempty"`
+	// NetBoxLastUpdated is the last updated time of the IP in NetBox
+	// as of the last time it was synced. The IP is not updated if it
+	// has been changed in NetBox since, until the conflict is reported.
+	NetBoxLastUpdated string `json:"netboxLastUpdated,omitempty"`
+	// History lists the previous addresses of the NetBoxIP, most recent
+	// first. NetBox only has the current address.
+	History []AddressHistoryEntry `json:"history,omitempty"`
+}
+
+// AddressHistoryEntry is a previous address of a NetBoxIP,
+// with the times it was the current address from and until.
+type AddressHistoryEntry struct {
+	Address netip.Addr  `json:"address"`
+	Since   metav1.Time `json:"since"`
+	Until   metav1.Time `json:"until"`
+}
+
+// DeepCopyInto copies the receiver, writing into out. It is added
+// explicitly for the same reason as NetBoxIPSpec.DeepCopyInto.
+func (status *NetBoxIPStatus) DeepCopyInto(out *NetBoxIPStatus) {
+	*out = *status
+	if status.History != nil {
+		in, out := &status.History, &out.History
+		*out = make([]AddressHistoryEntry, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// RecordAddress adds the current address of the NetBoxIP, replaced at the
+// given time, to the front of its history, dropping the oldest addresses
+// beyond MaxAddressHistory.
+func (ip *NetBoxIP) RecordAddress(until metav1.Time) {
+	if !ip.Spec.Address.IsValid() {
+		return
+	}
+
+	since := ip.CreationTimestamp
+	if len(ip.Status.History) > 0 {
+		since = ip.Status.History[0].Until
+	}
+	history := append([]AddressHistoryEntry{{
+		Address: ip.Spec.Address,
+		Since:   since,
+		Until:   until,
+	}}, ip.Status.History...)
+	if len(history) > MaxAddressHistory {
+		history = history[:MaxAddressHistory]
+	}
+	ip.Status.History = history
+}
+
 // Tag is a NetBox tag.
 type Tag struct {
 	Name string `json:"name"`
@@ -98,11 +221,43 @@ type NetBoxIPList struct {
 	Items []NetBoxIP `json:"items"`
 }
 
+// DescriptionMaxLength is the maximum length of
+// an IP description, as limited by NetBox.
+const DescriptionMaxLength = 200
+
 var (
 	dnsLabelRegexp = "[a-zA-Z0-9][a-zA-Z0-9-]{0,62}"
 	dnsNameRegexp  = fmt.Sprintf("^(%s\\.)*%s$", dnsLabelRegexp, dnsLabelRegexp)
 
-	tagSlugRegexp = "^[-a-zA-Z0-9_]+$"
+	// addresses as accepted by netip.ParseAddr, without zones
+	ipv4OctetRegexp = "(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])"
+	ipv4Regexp      = fmt.Sprintf("(%s\\.){3}%s", ipv4OctetRegexp, ipv4OctetRegexp)
+	ipv6GroupRegexp = "[0-9a-fA-F]{1,4}"
+	// h stands for a group, and v4 for an embedded IPv4 address
+	ipv6Regexp = strings.NewReplacer("h", ipv6GroupRegexp, "v4", ipv4Regexp).Replace(strings.Join([]string{
+		"(h:){7}h",
+		"(h:){1,7}:",
+		"(h:){1,6}:h",
+		"(h:){1,5}(:h){1,2}",
+		"(h:){1,4}(:h){1,3}",
+		"(h:){1,3}(:h){1,4}",
+		"(h:){1,2}(:h){1,5}",
+		"h:(:h){1,6}",
+		":((:h){1,7}|:)",
+		// with an embedded IPv4 address in place of the last two groups
+		"(h:){6}v4",
+		"(h:){1,5}:v4",
+		"(h:){1,4}(:h){1}:v4",
+		"(h:){1,3}(:h){1,2}:v4",
+		"(h:){1,2}(:h){1,3}:v4",
+		"h:(:h){1,4}:v4",
+		"::(h:){0,5}v4",
+	}, "|"))
+	addressRegexp = fmt.Sprintf("^(%s|%s)$", ipv4Regexp, ipv6Regexp)
+
+	tagSlugRegexp    = "^[-a-zA-Z0-9_]+$"
+	tenantSlugRegexp = "^[-a-zA-Z0-9_]+$"
+	macAddressRegexp = "^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$"
 )
 
 var tagSchema = &apiextensionsv1.JSONSchemaProps{
@@ -122,6 +277,62 @@ var tagSchema = &apiextensionsv1.JSONSchemaProps{
 	},
 }
 
+var assignedObjectSchema = apiextensionsv1.JSONSchemaProps{
+	Type:     "object",
+	Required: []string{"kind"},
+	Properties: map[string]apiextensionsv1.JSONSchemaProps{
+		"kind": apiextensionsv1.JSONSchemaProps{
+			Type: "string",
+			Enum: []apiextensionsv1.JSON{
+				{Raw: []byte(`"` + AssignedObjectKindInterface + `"`)},
+				{Raw: []byte(`"` + AssignedObjectKindVMInterface + `"`)},
+			},
+		},
+		"id": apiextensionsv1.JSONSchemaProps{
+			Type:    "integer",
+			Minimum: pointer.Float64(1),
+		},
+		"name": apiextensionsv1.JSONSchemaProps{
+			Type:      "string",
+			MinLength: pointer.Int64(1),
+			MaxLength: pointer.Int64(64),
+		},
+		"parent": apiextensionsv1.JSONSchemaProps{
+			Type:      "string",
+			MinLength: pointer.Int64(1),
+			MaxLength: pointer.Int64(64),
+		},
+		"macAddress": apiextensionsv1.JSONSchemaProps{
+			Type:    "string",
+			Pattern: macAddressRegexp,
+		},
+	},
+	// either the ID, or the names of the interface and its parent
+	OneOf: []apiextensionsv1.JSONSchemaProps{
+		{Required: []string{"id"}},
+		{Required: []string{"name", "parent"}},
+	},
+}
+
+var addressHistoryEntrySchema = &apiextensionsv1.JSONSchemaProps{
+	Type:     "object",
+	Required: []string{"address"},
+	Properties: map[string]apiextensionsv1.JSONSchemaProps{
+		"address": apiextensionsv1.JSONSchemaProps{
+			Type:      "string",
+			MinLength: pointer.Int64(1),
+		},
+		"since": apiextensionsv1.JSONSchemaProps{
+			Type:   "string",
+			Format: "date-time",
+		},
+		"until": apiextensionsv1.JSONSchemaProps{
+			Type:   "string",
+			Format: "date-time",
+		},
+	},
+}
+
 // NetBoxIPValidationSchema is the validation schema for NetBoxIP resource.
 var NetBoxIPValidationSchema = &apiextensionsv1.CustomResourceValidation{
 	OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{Type: "object",
@@ -131,15 +342,31 @@ var NetBoxIPValidationSchema = &apiextensionsv1.CustomResourceValidation{
 					"address": apiextensionsv1.JSONSchemaProps{
 						Type:      "string",
 						MinLength: pointer.Int64(1),
-						// actual validation happens when unmarshaling, here we only
-						// make sure the addess is not empty (empty addresses will not
-						// produce an error when unmarshaled)
+						// the longest address is an IPv6 address with
+						// an embedded IPv4 address
+						MaxLength: pointer.Int64(45),
+						// addresses that the controller could not unmarshal
+						// are rejected on admission, rather than making the
+						// NetBoxIP impossible to reconcile or even list
+						XValidations: apiextensionsv1.ValidationRules{{
+							Rule:    fmt.Sprintf("self.matches(r'%s')", addressRegexp),
+							Message: "must be a valid IPv4 or IPv6 address",
+						}},
+					},
+					"prefixLength": apiextensionsv1.JSONSchemaProps{
+						Type: "integer",
+						// the maximum for IPv4 addresses is enforced by the controller
+						Minimum: pointer.Float64(0),
+						Maximum: pointer.Float64(128),
 					},
 					"dnsName": apiextensionsv1.JSONSchemaProps{
 						Type:      "string",
 						MinLength: pointer.Int64(1),
 						MaxLength: pointer.Int64(253),
-						Pattern:   dnsNameRegexp,
+						XValidations: apiextensionsv1.ValidationRules{{
+							Rule:    fmt.Sprintf("self.matches(r'%s')", dnsNameRegexp),
+							Message: "must be a valid DNS name",
+						}},
 					},
 					"tags": apiextensionsv1.JSONSchemaProps{
 						Type: "array",
@@ -148,9 +375,62 @@ var NetBoxIPValidationSchema = &apiextensionsv1.CustomResourceValidation{
 						},
 					},
 					"description": apiextensionsv1.JSONSchemaProps{
+						Type:      "string",
+						MaxLength: pointer.Int64(DescriptionMaxLength),
+					},
+					"tenant": apiextensionsv1.JSONSchemaProps{
+						Type:      "string",
+						MaxLength: pointer.Int64(100),
+						Pattern:   tenantSlugRegexp,
+					},
+					"vrf": apiextensionsv1.JSONSchemaProps{
 						Type: "string",
 						// limit set by NetBox
-						MaxLength: pointer.Int64(200),
+						MinLength: pointer.Int64(1),
+						MaxLength: pointer.Int64(100),
+					},
+					"assignedObject": assignedObjectSchema,
+					"customFields": apiextensionsv1.JSONSchemaProps{
+						Type: "object",
+						AdditionalProperties: &apiextensionsv1.JSONSchemaPropsOrBool{
+							Allows: true,
+							Schema: &apiextensionsv1.JSONSchemaProps{Type: "string"},
+						},
+					},
+					"natInside": apiextensionsv1.JSONSchemaProps{
+						Type:      "string",
+						MinLength: pointer.Int64(1),
+						// limit set by Kubernetes for object names
+						MaxLength: pointer.Int64(253),
+					},
+				},
+			},
+			"status": apiextensionsv1.JSONSchemaProps{Type: "object",
+				Properties: map[string]apiextensionsv1.JSONSchemaProps{
+					"observedGeneration": apiextensionsv1.JSONSchemaProps{
+						Type:   "integer",
+						Format: "int64",
+					},
+					"syncedGeneration": apiextensionsv1.JSONSchemaProps{
+						Type:   "integer",
+						Format: "int64",
+					},
+					"netboxID": apiextensionsv1.JSONSchemaProps{
+						Type:   "integer",
+						Format: "int64",
+					},
+					"netboxURL": apiextensionsv1.JSONSchemaProps{
+						Type: "string",
+					},
+					"netboxLastUpdated": apiextensionsv1.JSONSchemaProps{
+						Type: "string",
+					},
+					"history": apiextensionsv1.JSONSchemaProps{
+						Type:     "array",
+						MaxItems: pointer.Int64(MaxAddressHistory),
+						Items: &apiextensionsv1.JSONSchemaPropsOrArray{
+							Schema: addressHistoryEntrySchema,
+						},
 					},
 				},
 			},
@@ -17,14 +17,18 @@ limitations under the License.
 package v1beta1
 
 import (
+	"encoding/json"
 	"net/netip"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/cel-go/cel"
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiservervalidation "k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 func TestValidationSchema(t *testing.T) {
@@ -56,6 +60,40 @@ func TestValidationSchema(t *testing.T) {
 			DNSName: "!?not.valid.dns.",
 		},
 		valid: false,
+	}, {
+		name: "dns name with trailing dot",
+		netboxIPSpec: NetBoxIPSpec{
+			Address: netip.AddrFrom4([4]byte{8, 8, 8, 8}),
+			DNSName: "valid.dns.",
+		},
+		valid: false,
+	}, {
+		name: "valid with IPv6 address",
+		netboxIPSpec: NetBoxIPSpec{
+			Address: netip.MustParseAddr("2001:db8::1"),
+			DNSName: "valid.dns",
+		},
+		valid: true,
+	}, {
+		name: "valid without dns name",
+		netboxIPSpec: NetBoxIPSpec{
+			Address: netip.AddrFrom4([4]byte{8, 8, 8, 8}),
+		},
+		valid: true,
+	}, {
+		name: "with prefix length",
+		netboxIPSpec: NetBoxIPSpec{
+			Address:      netip.AddrFrom4([4]byte{10, 0, 0, 0}),
+			PrefixLength: 24,
+		},
+		valid: true,
+	}, {
+		name: "prefix length too long",
+		netboxIPSpec: NetBoxIPSpec{
+			Address:      netip.MustParseAddr("1:2::"),
+			PrefixLength: 129,
+		},
+		valid: false,
 	}, {
 		name: "description too long",
 		netboxIPSpec: NetBoxIPSpec{
@@ -86,6 +124,38 @@ func TestValidationSchema(t *testing.T) {
 			}},
 		},
 		valid: true,
+	}, {
+		name: "assigned object by ID",
+		netboxIPSpec: NetBoxIPSpec{
+			Address:        netip.AddrFrom4([4]byte{8, 8, 8, 8}),
+			DNSName:        "foo",
+			AssignedObject: &AssignedObject{Kind: AssignedObjectKindInterface, ID: 12},
+		},
+		valid: true,
+	}, {
+		name: "assigned object by name",
+		netboxIPSpec: NetBoxIPSpec{
+			Address:        netip.AddrFrom4([4]byte{8, 8, 8, 8}),
+			DNSName:        "foo",
+			AssignedObject: &AssignedObject{Kind: AssignedObjectKindVMInterface, Name: "eth0", Parent: "vm-1"},
+		},
+		valid: true,
+	}, {
+		name: "assigned object without parent",
+		netboxIPSpec: NetBoxIPSpec{
+			Address:        netip.AddrFrom4([4]byte{8, 8, 8, 8}),
+			DNSName:        "foo",
+			AssignedObject: &AssignedObject{Kind: AssignedObjectKindInterface, Name: "eth0"},
+		},
+		valid: false,
+	}, {
+		name: "assigned object of unknown kind",
+		netboxIPSpec: NetBoxIPSpec{
+			Address:        netip.AddrFrom4([4]byte{8, 8, 8, 8}),
+			DNSName:        "foo",
+			AssignedObject: &AssignedObject{Kind: "Device", ID: 12},
+		},
+		valid: false,
 	}, {
 		name: "valid with single-domain dns",
 		netboxIPSpec: NetBoxIPSpec{
@@ -104,6 +174,10 @@ func TestValidationSchema(t *testing.T) {
 			}
 
 			err := apiservervalidation.ValidateCustomResource(nil, ip, validator)
+			if err == nil {
+				// the validator does not evaluate CEL rules
+				err = validateRules(t, field.NewPath(""), NetBoxIPValidationSchema.OpenAPIV3Schema, toUnstructured(t, ip))
+			}
 			t.Log(err)
 			if err != nil && test.valid {
 				t.Errorf("want no error, got %q\n", err)
@@ -113,3 +187,144 @@ func TestValidationSchema(t *testing.T) {
 		})
 	}
 }
+
+func TestAddressValidation(t *testing.T) {
+	tests := []string{
+		"",
+		"8.8.8.8",
+		"255.255.255.255",
+		"0.0.0.0",
+		"256.0.0.1",
+		"01.2.3.4",
+		"1.2.3",
+		"1.2.3.4.5",
+		"1.2.3.4/24",
+		"::",
+		"::1",
+		"1::",
+		"2001:db8::1",
+		"2001:DB8:0:0:0:0:0:1",
+		"1:2:3:4:5:6:7:8",
+		"1:2:3:4:5:6:7::",
+		"::2:3:4:5:6:7:8",
+		"1:2:3:4:5:6:7:8:9",
+		"1:2:3:4:5:6:7",
+		"1::2::3",
+		"12345::",
+		"::ffff:10.0.0.1",
+		"64:ff9b::192.0.2.33",
+		"1:2:3:4:5:6:1.2.3.4",
+		"1:2:3:4:5::1.2.3.4",
+		"1:2:3:4:5:6:7:1.2.3.4",
+		"1::2:3:4:5:1.2.3.4",
+		"1::2:3:4:5:6:1.2.3.4",
+		"::1.2.3.4",
+		"::1.2.3.256",
+		"fe80::1%eth0",
+		"foo",
+		"8.8.8.8 ",
+	}
+
+	schema := NetBoxIPValidationSchema.OpenAPIV3Schema.Properties["spec"].Properties["address"]
+	for _, address := range tests {
+		t.Run(address, func(t *testing.T) {
+			// valid addresses are those that can be unmarshaled
+			var addr netip.Addr
+			valid := addr.UnmarshalText([]byte(address)) == nil && address != "" && addr.Zone() == ""
+
+			errs := validateRules(t, field.NewPath("address"), &schema, address)
+			if len(errs) > 0 && valid {
+				t.Errorf("want no error, got %q", errs)
+			} else if len(errs) == 0 && !valid {
+				t.Error("want error, got nil")
+			}
+		})
+	}
+}
+
+// validateRules evaluates the CEL rules of schema and
+// its properties on creation of an object with value.
+func validateRules(t *testing.T, fldPath *field.Path, schema *apiextensionsv1.JSONSchemaProps, value interface{}) field.ErrorList {
+	t.Helper()
+
+	var errs field.ErrorList
+	for _, rule := range schema.XValidations {
+		if strings.Contains(rule.Rule, "oldSelf") {
+			// transition rules are not evaluated on creation
+			continue
+		}
+		env, err := cel.NewEnv(cel.Variable("self", cel.DynType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast, issues := env.Compile(rule.Rule)
+		if issues.Err() != nil {
+			t.Fatalf("compiling rule %q: %s", rule.Rule, issues.Err())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _, err := program.Eval(map[string]interface{}{"self": value})
+		if err != nil {
+			t.Fatalf("evaluating rule %q: %s", rule.Rule, err)
+		}
+		if out.Value() != true {
+			errs = append(errs, field.Invalid(fldPath, value, rule.Message))
+		}
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		for name, property := range schema.Properties {
+			if v, ok := object[name]; ok {
+				property := property
+				errs = append(errs, validateRules(t, fldPath.Child(name), &property, v)...)
+			}
+		}
+	}
+	return errs
+}
+
+func toUnstructured(t *testing.T, obj interface{}) map[string]interface{} {
+	t.Helper()
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u map[string]interface{}
+	if err := json.Unmarshal(data, &u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestRecordAddress(t *testing.T) {
+	created := metav1.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	ip := &NetBoxIP{
+		ObjectMeta: metav1.ObjectMeta{CreationTimestamp: created},
+		Spec:       NetBoxIPSpec{Address: netip.AddrFrom4([4]byte{10, 0, 0, 1})},
+	}
+
+	var times []metav1.Time
+	for i := 0; i < MaxAddressHistory+2; i++ {
+		times = append(times, metav1.NewTime(created.Add(time.Duration(i+1)*time.Hour)))
+		ip.RecordAddress(times[i])
+		ip.Spec.Address = netip.AddrFrom4([4]byte{10, 0, 0, byte(i + 2)})
+	}
+
+	if len(ip.Status.History) != MaxAddressHistory {
+		t.Fatalf("want %d addresses in history, got %d", MaxAddressHistory, len(ip.Status.History))
+	}
+	latest := ip.Status.History[0]
+	if latest.Address != netip.AddrFrom4([4]byte{10, 0, 0, MaxAddressHistory + 2}) ||
+		!latest.Since.Equal(&times[MaxAddressHistory]) ||
+		!latest.Until.Equal(&times[MaxAddressHistory+1]) {
+		t.Errorf("unexpected latest history entry %+v", latest)
+	}
+	// the first two addresses were dropped
+	oldest := ip.Status.History[MaxAddressHistory-1]
+	if oldest.Address != netip.AddrFrom4([4]byte{10, 0, 0, 3}) {
+		t.Errorf("want oldest address 10.0.0.3 in history, got %s", oldest.Address)
+	}
+}
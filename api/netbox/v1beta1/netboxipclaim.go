@@ -0,0 +1,184 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+	"net/netip"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:deepcopy-gen=true
+
+// NetBoxIPClaim requests an available IP from a NetBox prefix,
+// which the controller allocates and records in its status.
+type NetBoxIPClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NetBoxIPClaimSpec   `json:"spec"`
+	Status NetBoxIPClaimStatus `json:"status,omitempty"`
+}
+
+// NetBoxIPClaimSpec defines the custom fields of the NetBoxIPClaim resource.
+type NetBoxIPClaimSpec struct {
+	// Prefix is the NetBox prefix that the IP is allocated from.
+	// It is immutable, and so is VRF.
+	Prefix netip.Prefix `json:"prefix"`
+	// VRF is the name of the NetBox VRF of the prefix, if any.
+	VRF         string `json:"vrf,omitempty"`
+	DNSName     string `json:"dnsName,omitempty"`
+	Tags        []Tag  `json:"tags,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Tenant is the slug of the NetBox tenant the IP belongs to.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// DeepCopyInto copies the receiver, writing into out. It is added
+// explicitly for the same reason as NetBoxIPSpec.DeepCopyInto.
+func (spec *NetBoxIPClaimSpec) DeepCopyInto(out *NetBoxIPClaimSpec) {
+	*out = *spec
+	if spec.Tags != nil {
+		in, out := &spec.Tags, &out.Tags
+		*out = make([]Tag, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// NetBoxIPClaimStatus defines the observed state of the NetBoxIPClaim resource.
+type NetBoxIPClaimStatus struct {
+	// Address is the allocated address, which is kept
+	// until the NetBoxIPClaim is deleted.
+	Address netip.Addr `json:"address,omitempty"`
+	// PrefixLength is the prefix length of the allocated address,
+	// i.e. that of the prefix it was allocated from.
+	PrefixLength int32 `json:"prefixLength,omitempty"`
+	// NetBoxID is the ID of the IP in NetBox.
+	NetBoxID int64 `json:"netboxID,omitempty"`
+	// NetBoxURL is the URL of the IP in the web UI of NetBox.
+	NetBoxURL string `json:"netboxURL,omitempty"`
+}
+
+// DeepCopyInto copies the receiver, writing into out. It is added
+// explicitly for the same reason as NetBoxIPSpec.DeepCopyInto.
+func (status *NetBoxIPClaimStatus) DeepCopyInto(out *NetBoxIPClaimStatus) {
+	*out = *status
+}
+
+// Allocated returns true if an address has been allocated for the claim.
+func (claim *NetBoxIPClaim) Allocated() bool {
+	return claim.Status.Address.IsValid()
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:deepcopy-gen=true
+
+// NetBoxIPClaimList represents a list of custom NetBoxIPClaim resources.
+type NetBoxIPClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:",inline"`
+
+	Items []NetBoxIPClaim `json:"items"`
+}
+
+var (
+	// prefixes as accepted by netip.ParsePrefix
+	ipv4PrefixRegexp = fmt.Sprintf("%s/(3[0-2]|[12]?[0-9])", ipv4Regexp)
+	ipv6PrefixRegexp = fmt.Sprintf("(%s)/(12[0-8]|1[01][0-9]|[1-9]?[0-9])", ipv6Regexp)
+	prefixRegexp     = fmt.Sprintf("^(%s|%s)$", ipv4PrefixRegexp, ipv6PrefixRegexp)
+)
+
+// NetBoxIPClaimValidationSchema is the validation schema for NetBoxIPClaim resource.
+var NetBoxIPClaimValidationSchema = &apiextensionsv1.CustomResourceValidation{
+	OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{Type: "object",
+		Properties: map[string]apiextensionsv1.JSONSchemaProps{
+			"spec": apiextensionsv1.JSONSchemaProps{Type: "object",
+				Required: []string{"prefix"},
+				// the allocated address is only valid in the prefix and VRF it
+				// was allocated from, so changing either requires a new claim
+				XValidations: apiextensionsv1.ValidationRules{{
+					Rule:    "self.prefix == oldSelf.prefix && has(self.vrf) == has(oldSelf.vrf) && (!has(self.vrf) || self.vrf == oldSelf.vrf)",
+					Message: "prefix and vrf are immutable: delete and re-create the NetBoxIPClaim to change them",
+				}},
+				Properties: map[string]apiextensionsv1.JSONSchemaProps{
+					"prefix": apiextensionsv1.JSONSchemaProps{
+						Type:      "string",
+						MinLength: pointer.Int64(1),
+						// an IPv6 address with an embedded IPv4 address, and /128
+						MaxLength: pointer.Int64(49),
+						XValidations: apiextensionsv1.ValidationRules{{
+							Rule:    fmt.Sprintf("self.matches(r'%s')", prefixRegexp),
+							Message: "must be a valid IPv4 or IPv6 prefix",
+						}},
+					},
+					"vrf": apiextensionsv1.JSONSchemaProps{
+						Type:      "string",
+						MinLength: pointer.Int64(1),
+						MaxLength: pointer.Int64(100),
+					},
+					"dnsName": apiextensionsv1.JSONSchemaProps{
+						Type:      "string",
+						MinLength: pointer.Int64(1),
+						MaxLength: pointer.Int64(253),
+						XValidations: apiextensionsv1.ValidationRules{{
+							Rule:    fmt.Sprintf("self.matches(r'%s')", dnsNameRegexp),
+							Message: "must be a valid DNS name",
+						}},
+					},
+					"tags": apiextensionsv1.JSONSchemaProps{
+						Type: "array",
+						Items: &apiextensionsv1.JSONSchemaPropsOrArray{
+							Schema: tagSchema,
+						},
+					},
+					"description": apiextensionsv1.JSONSchemaProps{
+						Type:      "string",
+						MaxLength: pointer.Int64(DescriptionMaxLength),
+					},
+					"tenant": apiextensionsv1.JSONSchemaProps{
+						Type:      "string",
+						MaxLength: pointer.Int64(100),
+						Pattern:   tenantSlugRegexp,
+					},
+				},
+			},
+			"status": apiextensionsv1.JSONSchemaProps{Type: "object",
+				Properties: map[string]apiextensionsv1.JSONSchemaProps{
+					"address": apiextensionsv1.JSONSchemaProps{
+						Type: "string",
+					},
+					"prefixLength": apiextensionsv1.JSONSchemaProps{
+						Type:    "integer",
+						Minimum: pointer.Float64(0),
+						Maximum: pointer.Float64(128),
+					},
+					"netboxID": apiextensionsv1.JSONSchemaProps{
+						Type:   "integer",
+						Format: "int64",
+					},
+					"netboxURL": apiextensionsv1.JSONSchemaProps{
+						Type: "string",
+					},
+				},
+			},
+		},
+	},
+}
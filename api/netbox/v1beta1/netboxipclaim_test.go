@@ -0,0 +1,188 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiservervalidation "k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestClaimValidationSchema(t *testing.T) {
+	var schema apiextensions.CustomResourceValidation
+	if err := apiextensionsv1.Convert_v1_CustomResourceValidation_To_apiextensions_CustomResourceValidation(NetBoxIPClaimValidationSchema, &schema, nil); err != nil {
+		t.Errorf("converting CRD validation: %q\n", err)
+	}
+	validator, _, err := apiservervalidation.NewSchemaValidator(schema.OpenAPIV3Schema)
+	if err != nil {
+		t.Errorf("creating validator: %q\n", err)
+	}
+
+	tests := []struct {
+		name  string
+		spec  map[string]interface{}
+		valid bool
+	}{{
+		name:  "missing prefix",
+		spec:  map[string]interface{}{"dnsName": "foo"},
+		valid: false,
+	}, {
+		name:  "IPv4 prefix",
+		spec:  map[string]interface{}{"prefix": "10.0.0.0/24", "vrf": "blue"},
+		valid: true,
+	}, {
+		name:  "IPv6 prefix",
+		spec:  map[string]interface{}{"prefix": "2001:db8::/64", "dnsName": "foo.example.com"},
+		valid: true,
+	}, {
+		name:  "address without prefix length",
+		spec:  map[string]interface{}{"prefix": "10.0.0.0"},
+		valid: false,
+	}, {
+		name:  "prefix length too long",
+		spec:  map[string]interface{}{"prefix": "10.0.0.0/33"},
+		valid: false,
+	}, {
+		name: "with tags",
+		spec: map[string]interface{}{
+			"prefix": "10.0.0.0/24",
+			"tags":   []interface{}{map[string]interface{}{"name": "good", "slug": "good"}},
+		},
+		valid: true,
+	}, {
+		name:  "invalid dns name",
+		spec:  map[string]interface{}{"prefix": "10.0.0.0/24", "dnsName": "!?not.valid.dns."},
+		valid: false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claim := map[string]interface{}{"spec": test.spec}
+
+			err := apiservervalidation.ValidateCustomResource(nil, claim, validator)
+			if err == nil {
+				err = validateRules(t, field.NewPath(""), NetBoxIPClaimValidationSchema.OpenAPIV3Schema, claim)
+			}
+			t.Log(err)
+			if err != nil && test.valid {
+				t.Errorf("want no error, got %q\n", err)
+			} else if err == nil && !test.valid {
+				t.Error("want error, nil")
+			}
+		})
+	}
+}
+
+func TestPrefixValidation(t *testing.T) {
+	program := compileRule(t, NetBoxIPClaimValidationSchema.OpenAPIV3Schema.Properties["spec"].Properties["prefix"].XValidations[0].Rule)
+
+	for _, prefix := range []string{
+		"10.0.0.0/8",
+		"10.0.0.1/32",
+		"0.0.0.0/0",
+		"2001:db8::/32",
+		"::/0",
+		"::ffff:10.0.0.0/104",
+		"2001:db8::1/128",
+		"10.0.0.0",
+		"10.0.0.0/",
+		"10.0.0.0/33",
+		"2001:db8::/129",
+		"10.0.0.0/08",
+		"fe80::/10%eth0",
+	} {
+		t.Run(prefix, func(t *testing.T) {
+			_, err := netip.ParsePrefix(prefix)
+			out, _, evalErr := program.Eval(map[string]interface{}{"self": prefix})
+			if evalErr != nil {
+				t.Fatal(evalErr)
+			}
+			if out.Value() != (err == nil) {
+				t.Errorf("want %v, got %v", err == nil, out.Value())
+			}
+		})
+	}
+}
+
+func TestClaimImmutabilityRule(t *testing.T) {
+	program := compileRule(t, NetBoxIPClaimValidationSchema.OpenAPIV3Schema.Properties["spec"].XValidations[0].Rule)
+
+	tests := []struct {
+		name     string
+		oldSpec  map[string]interface{}
+		spec     map[string]interface{}
+		expected bool
+	}{{
+		name:     "unchanged",
+		oldSpec:  map[string]interface{}{"prefix": "10.0.0.0/24", "vrf": "blue", "dnsName": "foo"},
+		spec:     map[string]interface{}{"prefix": "10.0.0.0/24", "vrf": "blue", "dnsName": "bar"},
+		expected: true,
+	}, {
+		name:     "prefix changed",
+		oldSpec:  map[string]interface{}{"prefix": "10.0.0.0/24"},
+		spec:     map[string]interface{}{"prefix": "10.0.1.0/24"},
+		expected: false,
+	}, {
+		name:     "VRF added",
+		oldSpec:  map[string]interface{}{"prefix": "10.0.0.0/24"},
+		spec:     map[string]interface{}{"prefix": "10.0.0.0/24", "vrf": "blue"},
+		expected: false,
+	}, {
+		name:     "VRF changed",
+		oldSpec:  map[string]interface{}{"prefix": "10.0.0.0/24", "vrf": "blue"},
+		spec:     map[string]interface{}{"prefix": "10.0.0.0/24", "vrf": "red"},
+		expected: false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, _, err := program.Eval(map[string]interface{}{"self": test.spec, "oldSelf": test.oldSpec})
+			if err != nil {
+				t.Fatalf("evaluating rule: %s", err)
+			}
+			if out.Value() != test.expected {
+				t.Errorf("want %v, got %v", test.expected, out.Value())
+			}
+		})
+	}
+}
+
+func compileRule(t *testing.T, rule string) cel.Program {
+	t.Helper()
+
+	env, err := cel.NewEnv(
+		cel.Variable("self", cel.DynType),
+		cel.Variable("oldSelf", cel.DynType),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast, issues := env.Compile(rule)
+	if issues.Err() != nil {
+		t.Fatalf("compiling rule %q: %s", rule, issues.Err())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
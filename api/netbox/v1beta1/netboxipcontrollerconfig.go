@@ -0,0 +1,127 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:deepcopy-gen=true
+
+// NetBoxIPControllerConfig is a cluster-scoped resource carrying
+// configuration of the controller that can be changed at runtime.
+type NetBoxIPControllerConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NetBoxIPControllerConfigSpec `json:"spec"`
+}
+
+// NetBoxIPControllerConfigSpec defines the custom fields of the
+// NetBoxIPControllerConfig resource. Fields left empty keep the
+// values set by the controller's flags.
+type NetBoxIPControllerConfigSpec struct {
+	Pods     PublishConfig `json:"pods,omitempty"`
+	Services PublishConfig `json:"services,omitempty"`
+	// NetBoxQPS and NetBoxBurst configure the rate limiter
+	// of the requests to NetBox.
+	NetBoxQPS   float64 `json:"netboxQPS,omitempty"`
+	NetBoxBurst int     `json:"netboxBurst,omitempty"`
+}
+
+// PublishConfig configures how IPs of a single kind of objects are published.
+type PublishConfig struct {
+	// Tags are the names of the tags added to every IP.
+	Tags []string `json:"tags,omitempty"`
+	// PublishLabels are the labels that mark objects whose IPs are published,
+	// and are added to the IP description.
+	PublishLabels []string `json:"publishLabels,omitempty"`
+	// Namespaces, if not empty, are the only namespaces whose IPs are published.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// ExcludedNamespaces are the namespaces whose IPs are never published.
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:deepcopy-gen=true
+
+// NetBoxIPControllerConfigList represents a list of
+// custom NetBoxIPControllerConfig resources.
+type NetBoxIPControllerConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:",inline"`
+
+	Items []NetBoxIPControllerConfig `json:"items"`
+}
+
+var stringListSchema = apiextensionsv1.JSONSchemaProps{
+	Type: "array",
+	Items: &apiextensionsv1.JSONSchemaPropsOrArray{
+		Schema: &apiextensionsv1.JSONSchemaProps{
+			Type:      "string",
+			MinLength: pointer.Int64(1),
+		},
+	},
+}
+
+var publishConfigSchema = apiextensionsv1.JSONSchemaProps{
+	Type: "object",
+	Properties: map[string]apiextensionsv1.JSONSchemaProps{
+		"tags": apiextensionsv1.JSONSchemaProps{
+			Type: "array",
+			Items: &apiextensionsv1.JSONSchemaPropsOrArray{
+				Schema: &apiextensionsv1.JSONSchemaProps{
+					Type:      "string",
+					MinLength: pointer.Int64(1),
+					MaxLength: pointer.Int64(100),
+					// tags are created with slugs equal to their names
+					Pattern: tagSlugRegexp,
+				},
+			},
+		},
+		// label names are validated by the controller
+		"publishLabels":      stringListSchema,
+		"namespaces":         stringListSchema,
+		"excludedNamespaces": stringListSchema,
+	},
+}
+
+// NetBoxIPControllerConfigValidationSchema is the validation schema
+// for NetBoxIPControllerConfig resource.
+var NetBoxIPControllerConfigValidationSchema = &apiextensionsv1.CustomResourceValidation{
+	OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{Type: "object",
+		Properties: map[string]apiextensionsv1.JSONSchemaProps{
+			"spec": apiextensionsv1.JSONSchemaProps{Type: "object",
+				Properties: map[string]apiextensionsv1.JSONSchemaProps{
+					"pods":     publishConfigSchema,
+					"services": publishConfigSchema,
+					"netboxQPS": apiextensionsv1.JSONSchemaProps{
+						Type:    "number",
+						Minimum: pointer.Float64(0),
+					},
+					"netboxBurst": apiextensionsv1.JSONSchemaProps{
+						Type:    "integer",
+						Minimum: pointer.Float64(0),
+					},
+				},
+			},
+		},
+	},
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiservervalidation "k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestControllerConfigValidationSchema(t *testing.T) {
+	var schema apiextensions.CustomResourceValidation
+	if err := apiextensionsv1.Convert_v1_CustomResourceValidation_To_apiextensions_CustomResourceValidation(NetBoxIPControllerConfigValidationSchema, &schema, nil); err != nil {
+		t.Errorf("converting CRD validation: %q\n", err)
+	}
+	validator, _, err := apiservervalidation.NewSchemaValidator(schema.OpenAPIV3Schema)
+	if err != nil {
+		t.Errorf("creating validator: %q\n", err)
+	}
+
+	tests := []struct {
+		name  string
+		spec  NetBoxIPControllerConfigSpec
+		valid bool
+	}{{
+		name:  "empty",
+		spec:  NetBoxIPControllerConfigSpec{},
+		valid: true,
+	}, {
+		name: "valid",
+		spec: NetBoxIPControllerConfigSpec{
+			Pods: PublishConfig{
+				Tags:               []string{"k8s-pod"},
+				PublishLabels:      []string{"app"},
+				ExcludedNamespaces: []string{"kube-system"},
+			},
+			Services: PublishConfig{
+				Namespaces: []string{"team-a"},
+			},
+			NetBoxQPS:   0.5,
+			NetBoxBurst: 10,
+		},
+		valid: true,
+	}, {
+		name: "invalid tag",
+		spec: NetBoxIPControllerConfigSpec{
+			Pods: PublishConfig{Tags: []string{"~bad~"}},
+		},
+		valid: false,
+	}, {
+		name: "empty namespace",
+		spec: NetBoxIPControllerConfigSpec{
+			Services: PublishConfig{Namespaces: []string{""}},
+		},
+		valid: false,
+	}, {
+		name:  "negative burst",
+		spec:  NetBoxIPControllerConfigSpec{NetBoxBurst: -1},
+		valid: false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &NetBoxIPControllerConfig{
+				TypeMeta:   metav1.TypeMeta{},
+				ObjectMeta: metav1.ObjectMeta{},
+				Spec:       test.spec,
+			}
+
+			err := apiservervalidation.ValidateCustomResource(nil, config, validator)
+			if err != nil && test.valid {
+				t.Errorf("want no error, got %q\n", err)
+			} else if err == nil && !test.valid {
+				t.Error("want error, nil")
+			}
+		})
+	}
+}
@@ -25,7 +25,7 @@ var (
 	// SchemeGroupVersion is the group version used to register netbox objects.
 	SchemeGroupVersion = schema.GroupVersion{Group: "netbox.digitalocean.com", Version: "v1beta1"}
 
-	schemeBuilder = (&scheme.Builder{GroupVersion: SchemeGroupVersion}).Register(&NetBoxIP{}, &NetBoxIPList{})
+	schemeBuilder = (&scheme.Builder{GroupVersion: SchemeGroupVersion}).Register(&NetBoxIP{}, &NetBoxIPList{}, &NetBoxIPClaim{}, &NetBoxIPClaimList{}, &NetBoxIPControllerConfig{}, &NetBoxIPControllerConfigList{})
 
 	// AddToScheme is the default scheme applier.
 	AddToScheme = schemeBuilder.AddToScheme
@@ -31,6 +31,7 @@ func (in *NetBoxIP) DeepCopyInto(out *NetBoxIP) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -52,6 +53,165 @@ func (in *NetBoxIP) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetBoxIPClaim) DeepCopyInto(out *NetBoxIPClaim) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetBoxIPClaim.
+func (in *NetBoxIPClaim) DeepCopy() *NetBoxIPClaim {
+	if in == nil {
+		return nil
+	}
+	out := new(NetBoxIPClaim)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NetBoxIPClaim) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetBoxIPClaimList) DeepCopyInto(out *NetBoxIPClaimList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NetBoxIPClaim, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetBoxIPClaimList.
+func (in *NetBoxIPClaimList) DeepCopy() *NetBoxIPClaimList {
+	if in == nil {
+		return nil
+	}
+	out := new(NetBoxIPClaimList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NetBoxIPClaimList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetBoxIPClaimSpec.
+func (in *NetBoxIPClaimSpec) DeepCopy() *NetBoxIPClaimSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NetBoxIPClaimSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetBoxIPClaimStatus.
+func (in *NetBoxIPClaimStatus) DeepCopy() *NetBoxIPClaimStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NetBoxIPClaimStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetBoxIPControllerConfig) DeepCopyInto(out *NetBoxIPControllerConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetBoxIPControllerConfig.
+func (in *NetBoxIPControllerConfig) DeepCopy() *NetBoxIPControllerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NetBoxIPControllerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NetBoxIPControllerConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetBoxIPControllerConfigList) DeepCopyInto(out *NetBoxIPControllerConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NetBoxIPControllerConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetBoxIPControllerConfigList.
+func (in *NetBoxIPControllerConfigList) DeepCopy() *NetBoxIPControllerConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(NetBoxIPControllerConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NetBoxIPControllerConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetBoxIPControllerConfigSpec) DeepCopyInto(out *NetBoxIPControllerConfigSpec) {
+	*out = *in
+	in.Pods.DeepCopyInto(&out.Pods)
+	in.Services.DeepCopyInto(&out.Services)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetBoxIPControllerConfigSpec.
+func (in *NetBoxIPControllerConfigSpec) DeepCopy() *NetBoxIPControllerConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NetBoxIPControllerConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NetBoxIPList) DeepCopyInto(out *NetBoxIPList) {
 	*out = *in
@@ -95,6 +255,32 @@ func (in *NetBoxIPSpec) DeepCopy() *NetBoxIPSpec {
 	return out
 }
 
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetBoxIPStatus.
+func (in *NetBoxIPStatus) DeepCopy() *NetBoxIPStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NetBoxIPStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AssignedObject) DeepCopyInto(out *AssignedObject) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AssignedObject.
+func (in *AssignedObject) DeepCopy() *AssignedObject {
+	if in == nil {
+		return nil
+	}
+	out := new(AssignedObject)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Tag) DeepCopyInto(out *Tag) {
 	*out = *in
@@ -110,3 +296,39 @@ func (in *Tag) DeepCopy() *Tag {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PublishConfig) DeepCopyInto(out *PublishConfig) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PublishLabels != nil {
+		in, out := &in.PublishLabels, &out.PublishLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludedNamespaces != nil {
+		in, out := &in.ExcludedNamespaces, &out.ExcludedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PublishConfig.
+func (in *PublishConfig) DeepCopy() *PublishConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(PublishConfig)
+	in.DeepCopyInto(out)
+	return out
+}
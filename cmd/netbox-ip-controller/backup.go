@@ -0,0 +1,219 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+)
+
+const (
+	flagFile = "file"
+)
+
+// Names of the files in backup archives.
+const (
+	backupNetBoxIPsFile = "netboxips.json"
+	backupRecordsFile   = "netbox-ips.json"
+)
+
+// backupArchive is the content of a backup archive: a gzipped tarball
+// of the NetBoxIP objects, and of the IPs managed by the controller in NetBox,
+// each as a JSON file.
+type backupArchive struct {
+	NetBoxIPs []v1beta1.NetBoxIP
+	// Records are the IPs in NetBox, with their IDs and
+	// custom fields, and with the UIDs of their NetBoxIPs.
+	Records []netbox.IPAddress
+}
+
+func newBackupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Exports all NetBoxIPs, and the IPs in NetBox created by the controller, to an archive file.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			v := viper.New()
+			v.AutomaticEnv()
+			v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+			if err := v.BindPFlags(cmd.Flags()); err != nil {
+				return fmt.Errorf("binding flags: %w", err)
+			}
+			file := v.GetString(flagFile)
+			if file == "" {
+				return fmt.Errorf("%s is required", flagFile)
+			}
+
+			defer globalCfg.logger.Sync()
+
+			scheme := runtime.NewScheme()
+			if err := v1beta1.AddToScheme(scheme); err != nil {
+				return err
+			}
+			kubeClient, err := client.New(globalCfg.kubeConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("creating k8s client: %w", err)
+			}
+
+			netboxClient, closeAudit, err := newNetBoxClient(globalCfg, nil)
+			if err != nil {
+				return fmt.Errorf("creating netbox client: %w", err)
+			}
+			defer closeAudit()
+
+			ctx := signals.SetupSignalHandler()
+			archive, err := backup(ctx, globalCfg.logger, kubeClient, netboxClient)
+			if err != nil {
+				return err
+			}
+
+			f, err := os.Create(file)
+			if err != nil {
+				return fmt.Errorf("creating backup file: %w", err)
+			}
+			if err := writeBackup(f, archive, time.Now()); err != nil {
+				f.Close()
+				return fmt.Errorf("writing backup file: %w", err)
+			}
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("writing backup file: %w", err)
+			}
+
+			globalCfg.logger.Info("backed up NetBoxIPs and IPs in NetBox", log.String("file", file),
+				log.Int("netboxips", len(archive.NetBoxIPs)), log.Int("records", len(archive.Records)))
+			return nil
+		},
+	}
+
+	cmd.Flags().String(flagFile, "", "path of the archive file to write")
+
+	return cmd
+}
+
+// backup returns the NetBoxIPs, and the IPs managed by the controller in
+// NetBox. IPAM backends that cannot list IPs only have their NetBoxIPs
+// backed up.
+func backup(ctx context.Context, logger *log.Logger, kubeClient client.Client, netboxClient netbox.Client) (*backupArchive, error) {
+	archive := &backupArchive{}
+	err := listNetBoxIPs(ctx, kubeClient, func(ip *v1beta1.NetBoxIP) {
+		ip.ManagedFields = nil
+		archive.NetBoxIPs = append(archive.NetBoxIPs, *ip)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := netboxClient.ListIPs(ctx, netbox.IPFilter{Managed: true})
+	if err != nil {
+		return nil, fmt.Errorf("listing IPs in NetBox: %w", err)
+	}
+	archive.Records = records
+
+	return archive, nil
+}
+
+// writeBackup writes archive to w, with files modified at time.
+func writeBackup(w io.Writer, archive *backupArchive, modTime time.Time) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, file := range []struct {
+		name    string
+		content interface{}
+	}{
+		{name: backupNetBoxIPsFile, content: archive.NetBoxIPs},
+		{name: backupRecordsFile, content: archive.Records},
+	} {
+		data, err := json.MarshalIndent(file.content, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshaling %s: %w", file.name, err)
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    file.name,
+			Mode:    0o600,
+			Size:    int64(len(data)),
+			ModTime: modTime,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// readBackup reads an archive written by writeBackup from r.
+func readBackup(r io.Reader) (*backupArchive, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	archive := &backupArchive{}
+	var found int
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		var content interface{}
+		switch header.Name {
+		case backupNetBoxIPsFile:
+			content = &archive.NetBoxIPs
+		case backupRecordsFile:
+			content = &archive.Records
+		default:
+			continue
+		}
+		if err := json.NewDecoder(tr).Decode(content); err != nil {
+			return nil, fmt.Errorf("unmarshaling %s: %w", header.Name, err)
+		}
+		found++
+	}
+
+	if found < 2 {
+		return nil, fmt.Errorf("not a backup archive: %s and %s are required", backupNetBoxIPsFile, backupRecordsFile)
+	}
+	return archive, nil
+}
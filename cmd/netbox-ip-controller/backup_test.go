@@ -0,0 +1,89 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	log "go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestBackup(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(&v1beta1.NetBoxIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-foo", Namespace: "test", UID: "foo"},
+		Spec: v1beta1.NetBoxIPSpec{
+			Address: netip.MustParseAddr("192.168.0.1"),
+			DNSName: "foo.test",
+		},
+	}).Build()
+
+	netboxClient := netbox.NewFakeClient(nil, map[netbox.UID]netbox.IPAddress{
+		"foo": {
+			ID:           1,
+			UID:          "foo",
+			Address:      netbox.IP(netip.MustParseAddr("192.168.0.1")),
+			DNSName:      "foo.test",
+			CustomFields: map[string]string{"k8s_selector": "app=foo"},
+		},
+		// not managed by the controller
+		"": {ID: 2, Address: netbox.IP(netip.MustParseAddr("192.168.0.2"))},
+	})
+
+	archive, err := backup(context.Background(), log.L(), kubeClient, netboxClient)
+	if err != nil {
+		t.Fatalf("backing up: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeBackup(&buf, archive, time.Now()); err != nil {
+		t.Fatalf("writing backup: %s", err)
+	}
+	restored, err := readBackup(&buf)
+	if err != nil {
+		t.Fatalf("reading backup: %s", err)
+	}
+
+	if len(restored.NetBoxIPs) != 1 || restored.NetBoxIPs[0].UID != "foo" {
+		t.Errorf("expected the NetBoxIP to be backed up, got %v", restored.NetBoxIPs)
+	}
+	if diff := cmp.Diff(archive.Records, restored.Records, cmp.Comparer(func(a, b netbox.IP) bool { return a == b })); diff != "" {
+		t.Errorf("IPs in NetBox (-want, +got)\n%s", diff)
+	}
+	if len(restored.Records) != 1 {
+		t.Errorf("expected 1 IP managed by the controller, got %d", len(restored.Records))
+	}
+}
+
+func TestReadBackupInvalid(t *testing.T) {
+	if _, err := readBackup(bytes.NewBufferString("foo")); err == nil {
+		t.Error("expected error but got none")
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+)
+
+const (
+	flagBranch = "branch"
+
+	branchFlagUsage = "if true, the changes to NetBox are staged in a new branch of the NetBox branching plugin, which is merged once all of them are made"
+)
+
+// inBranch calls fn, with a context whose changes to NetBox are staged
+// in a new branch that is merged once fn returns, if branch is true.
+// If fn fails, the branch is left unmerged, so that the changes that were
+// made can be reviewed, and merged or discarded in NetBox.
+func inBranch(ctx context.Context, logger *log.Logger, netboxClient netbox.Client, branch bool, command string, now time.Time, fn func(ctx context.Context) error) error {
+	if !branch {
+		return fn(ctx)
+	}
+
+	brancher, ok := netboxClient.(netbox.Brancher)
+	if !ok {
+		return errors.New("staging changes in a branch is only supported with the NetBox IPAM backend")
+	}
+
+	name := fmt.Sprintf("netbox-ip-controller-%s-%s", command, now.UTC().Format("20060102-150405"))
+	b, err := brancher.CreateBranch(ctx, name)
+	if err != nil {
+		return fmt.Errorf("creating branch in NetBox: %w", err)
+	}
+
+	if err := fn(netbox.WithBranch(ctx, b)); err != nil {
+		logger.Warn("not merging branch in NetBox: some changes failed", log.String("branch", b.Name))
+		return err
+	}
+
+	if err := brancher.MergeBranch(ctx, b); err != nil {
+		return fmt.Errorf("merging branch in NetBox: %w", err)
+	}
+	return nil
+}
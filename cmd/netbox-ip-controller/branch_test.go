@@ -0,0 +1,102 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+)
+
+// fakeBrancher records the branches that are created and merged.
+type fakeBrancher struct {
+	netbox.Client
+	calls []string
+}
+
+func (b *fakeBrancher) CreateBranch(_ context.Context, name string) (*netbox.Branch, error) {
+	b.calls = append(b.calls, "create "+name)
+	return &netbox.Branch{ID: 1, Name: name, SchemaID: "abc123"}, nil
+}
+
+func (b *fakeBrancher) MergeBranch(_ context.Context, branch *netbox.Branch) error {
+	b.calls = append(b.calls, "merge "+branch.Name)
+	return nil
+}
+
+func TestInBranch(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	name := "netbox-ip-controller-clean-20240301-123000"
+
+	tests := []struct {
+		name          string
+		branch        bool
+		fnErr         error
+		expectedCalls []string
+		expectedError bool
+	}{{
+		name: "without branch",
+	}, {
+		name:          "merged",
+		branch:        true,
+		expectedCalls: []string{"create " + name, "fn", "merge " + name},
+	}, {
+		name:          "changes failed",
+		branch:        true,
+		fnErr:         errors.New("failed"),
+		expectedCalls: []string{"create " + name, "fn"},
+		expectedError: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			brancher := &fakeBrancher{Client: netbox.NewFakeClient(nil, nil)}
+			err := inBranch(context.Background(), log.NewNop(), brancher, test.branch, "clean", now, func(ctx context.Context) error {
+				if test.branch {
+					brancher.calls = append(brancher.calls, "fn")
+				}
+				return test.fnErr
+			})
+			if (err != nil) != test.expectedError {
+				t.Errorf("want error: %t, got %v", test.expectedError, err)
+			}
+			if fmt.Sprint(brancher.calls) != fmt.Sprint(test.expectedCalls) {
+				t.Errorf("want calls %q, got %q", test.expectedCalls, brancher.calls)
+			}
+		})
+	}
+}
+
+func TestInBranchUnsupported(t *testing.T) {
+	called := false
+	err := inBranch(context.Background(), log.NewNop(), netbox.NewFakeClient(nil, nil), true, "prune", time.Now(), func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	if err == nil {
+		t.Error("want error, got nil")
+	}
+	if called {
+		t.Error("want no changes made without a branch")
+	}
+}
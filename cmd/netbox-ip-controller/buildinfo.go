@@ -0,0 +1,95 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// version is the version of the controller, which
+// is set with -ldflags "-X main.version=<version>".
+var version string
+
+// secretFlags are the flags left out of the config hash,
+// so that it does not reveal anything about secrets.
+var secretFlags = map[string]bool{
+	flagNetBoxToken:         true,
+	flagNetBoxOAuthSecret:   true,
+	flagPHPIPAMToken:        true,
+	flagInfobloxPassword:    true,
+	flagNetBoxWebhookSecret: true,
+}
+
+// buildInfo returns the version and VCS revision of the
+// controller, and the Go version it was built with. Those
+// that are unknown are "unknown".
+func buildInfo() (string, string, string) {
+	v, revision := version, ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	if v == "" {
+		v = "unknown"
+	}
+	if revision == "" {
+		revision = "unknown"
+	}
+	return v, revision, runtime.Version()
+}
+
+// configHash returns a fingerprint of the values of the flags of cmd,
+// whether they are set with flags or environment variables, which
+// differs between differently configured controllers. Secrets
+// are left out.
+func configHash(cmd *cobra.Command) (string, error) {
+	v := viper.New()
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	if err := v.BindPFlags(cmd.Flags()); err != nil {
+		return "", fmt.Errorf("binding flags: %w", err)
+	}
+
+	var names []string
+	for _, name := range v.AllKeys() {
+		if !secretFlags[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%q\n", name, v.GetString(name))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
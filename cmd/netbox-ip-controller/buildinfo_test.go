@@ -0,0 +1,82 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestConfigHash(t *testing.T) {
+	hash := func(t *testing.T, flags, envvars map[string]string) string {
+		cmd := &cobra.Command{}
+		registerGlobalFlags(cmd)
+		registerRootFlags(cmd)
+		// merges the global flags, like executing the command
+		if err := cmd.ParseFlags(nil); err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range envvars {
+			t.Setenv(key, value)
+		}
+		for key, value := range flags {
+			if err := cmd.Flags().Set(key, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		h, err := configHash(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	base := hash(t, map[string]string{"pod-ip-tags": "a,b", "netbox-token": "secret"}, nil)
+
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		envvars map[string]string
+		same    bool
+	}{{
+		name:  "same flags",
+		flags: map[string]string{"pod-ip-tags": "a,b", "netbox-token": "secret"},
+		same:  true,
+	}, {
+		name:    "same config from env vars",
+		envvars: map[string]string{"POD_IP_TAGS": "a,b"},
+		same:    true,
+	}, {
+		name:  "different secret",
+		flags: map[string]string{"pod-ip-tags": "a,b", "netbox-token": "other"},
+		same:  true,
+	}, {
+		name:  "different flag",
+		flags: map[string]string{"pod-ip-tags": "a", "netbox-token": "secret"},
+		same:  false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := hash(t, test.flags, test.envvars)
+			if (h == base) != test.same {
+				t.Errorf("expected same hash: %t, got %s and %s", test.same, base, h)
+			}
+		})
+	}
+}
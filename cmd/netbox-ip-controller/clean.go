@@ -18,7 +18,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
 	"time"
 
 	netboxctrl "github.com/digitalocean/netbox-ip-controller"
@@ -28,6 +32,7 @@ import (
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	log "go.uber.org/zap"
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
@@ -40,18 +45,74 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 )
 
+const (
+	flagNetBoxOnly  = "netbox-only"
+	flagTag         = "tag"
+	flagConcurrency = "concurrency"
+)
+
 func newCleanCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "clean",
 		Short: "Removes all custom resources created by the controller, and all IPs created in NetBox.",
 		RunE: func(cmd *cobra.Command, _ []string) error {
+			v := viper.New()
+			v.AutomaticEnv()
+			v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+			if err := v.BindPFlags(cmd.Flags()); err != nil {
+				return fmt.Errorf("binding flags: %w", err)
+			}
+			allowedPrefixes, err := parseAllowedPrefixes(v.GetString(flagAllowedPrefixes))
+			if err != nil {
+				return err
+			}
+
+			if concurrency := v.GetInt(flagConcurrency); concurrency < 1 {
+				return fmt.Errorf("%s value %d is invalid: must be at least 1", flagConcurrency, concurrency)
+			}
+
 			ctx := signals.SetupSignalHandler()
-			return clean(ctx, globalCfg)
+			return clean(ctx, globalCfg, cleanOptions{
+				allowedPrefixes: allowedPrefixes,
+				netboxOnly:      v.GetBool(flagNetBoxOnly),
+				tags:            sanitizedStringSlice(v.GetString(flagTag)),
+				concurrency:     v.GetInt(flagConcurrency),
+				branch:          v.GetBool(flagBranch),
+			})
 		},
 	}
+
+	cmd.Flags().String(flagAllowedPrefixes, "", "comma-separated list of CIDRs; if set, IPs in NetBox outside of these prefixes are not deleted")
+	cmd.Flags().String(flagTag, "", "comma-separated list of tags; if set, only the IPs with any of these tags, e.g. k8s-pod, and their NetBoxIPs are deleted, and the CRD is kept")
+	cmd.Flags().Int(flagConcurrency, 1, "number of NetBoxIPs cleaned at the same time; requests to NetBox are still limited by --netbox-qps and --netbox-burst")
+	cmd.Flags().Bool(flagNetBoxOnly, false, "if true, only the IPs in NetBox are deleted, and the finalizers of NetBoxIPs are removed, but the NetBoxIPs and the CRD are kept")
+	cmd.Flags().Bool(flagBranch, false, branchFlagUsage)
+
+	return cmd
 }
 
-func clean(ctx context.Context, cfg *globalConfig) error {
+// cleanOptions select what clean deletes.
+type cleanOptions struct {
+	// IPs outside of allowedPrefixes, if any, are not deleted from NetBox.
+	allowedPrefixes []netip.Prefix
+	// if true, NetBoxIPs and the CRD are kept, e.g. when the
+	// cluster is to be published to a new NetBox instance
+	netboxOnly bool
+	// if not empty, only NetBoxIPs with any of these tags, by
+	// name or slug, are cleaned, and the CRD is kept
+	tags []string
+	// number of NetBoxIPs cleaned at the same time
+	concurrency int
+	// if true, the IPs are deleted from NetBox in a branch,
+	// which is merged once all NetBoxIPs are cleaned
+	branch bool
+}
+
+// clean deletes the IPs of all NetBoxIPs from NetBox, unless they are
+// outside of the allowed prefixes, and then deletes the NetBoxIPs and the CRD.
+// With opts.netboxOnly, it only removes the finalizers of the NetBoxIPs.
+// With opts.tags, it only cleans the NetBoxIPs with any of the tags.
+func clean(ctx context.Context, cfg *globalConfig, opts cleanOptions) error {
 	defer cfg.logger.Sync()
 
 	scheme := runtime.NewScheme()
@@ -63,37 +124,99 @@ func clean(ctx context.Context, cfg *globalConfig) error {
 		return fmt.Errorf("creating k8s client: %w", err)
 	}
 
-	netboxClientOpts := []netbox.ClientOption{
-		netbox.WithRateLimiter(cfg.netboxQPS, cfg.netboxBurst),
-		netbox.WithLogger(cfg.logger),
+	netboxClient, closeAudit, err := newNetBoxClient(cfg, opts.allowedPrefixes)
+	if err != nil {
+		return fmt.Errorf("creating netbox client: %w", err)
+	}
+	defer closeAudit()
+
+	var ips []v1beta1.NetBoxIP
+	err = listNetBoxIPs(ctx, kubeClient, func(ip *v1beta1.NetBoxIP) {
+		if len(opts.tags) > 0 && !hasAnyTag(ip, opts.tags) {
+			cfg.logger.Debug("not cleaning netboxip: no matching tag", log.String("uid", string(ip.UID)))
+			return
+		}
+		ip.ManagedFields = nil
+		ips = append(ips, *ip)
+	})
+	if err != nil {
+		return err
+	}
+
+	concurrency := opts.concurrency
+	if concurrency < 1 {
+		// one at a time, unless configured otherwise
+		concurrency = 1
+	}
+	progress := newCleanProgress(cfg.logger, len(ips), time.Now())
+
+	err = inBranch(ctx, cfg.logger, netboxClient, opts.branch, "clean", time.Now(), func(ctx context.Context) error {
+		var mu sync.Mutex
+		var errs multierror.Error
+		var wg sync.WaitGroup
+		queue := make(chan v1beta1.NetBoxIP)
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for ip := range queue {
+					err := cleanIP(ctx, cfg.logger, kubeClient, netboxClient, ip, opts)
+					if err != nil {
+						mu.Lock()
+						multierror.Append(&errs, err)
+						mu.Unlock()
+					}
+					progress.add(time.Now())
+				}
+			}()
+		}
+		for _, ip := range ips {
+			queue <- ip
+		}
+		close(queue)
+		wg.Wait()
+		progress.report(time.Now())
+
+		return errs.ErrorOrNil()
+	})
+	if err != nil {
+		return err
 	}
-	if cfg.netboxCACertPath != "" {
-		netboxClientOpts = append(netboxClientOpts, netbox.WithCARootCert(cfg.netboxCACertPath))
+	if opts.netboxOnly || len(opts.tags) > 0 {
+		// the CRD is still needed by NetBoxIPs that are kept
+		return nil
 	}
 
-	netboxClient, err := netbox.NewClient(cfg.netboxAPIURL, cfg.netboxToken, netboxClientOpts...)
+	extensionsClient, err := apiextensionsclient.NewForConfig(cfg.kubeConfig)
 	if err != nil {
-		return fmt.Errorf("creating netbox client: %w", err)
+		return fmt.Errorf("creating API extensions client: %w", err)
 	}
 
-	var netboxipList v1beta1.NetBoxIPList
-	if err := kubeClient.List(ctx, &netboxipList); err != nil {
-		return fmt.Errorf("listing netboxips: %w", err)
+	if err := extensionsClient.ApiextensionsV1().CustomResourceDefinitions().Delete(ctx, crd.NetBoxIPCRDName, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("deleting NetBoxIP custom resource: %w", err)
 	}
 
+	return nil
+}
+
+// cleanIP deletes the IP of a NetBoxIP from NetBox, unless it is outside of
+// the allowed prefixes, and then removes its finalizer, and deletes it
+// unless opts.netboxOnly is set.
+func cleanIP(ctx context.Context, logger *log.Logger, kubeClient client.Client, netboxClient netbox.Client, ip v1beta1.NetBoxIP, opts cleanOptions) error {
+	ll := logger.With(log.String("uid", string(ip.UID)), log.Any("ip", ip.Spec.Address))
+
 	backoff1min := wait.Backoff{
 		Duration: 1 * time.Second,
 		Factor:   1,
 		Steps:    60,
 	}
 
-	var errs multierror.Error
-	for _, ip := range netboxipList.Items {
-		ll := cfg.logger.With(log.String("uid", string(ip.UID)), log.Any("ip", ip.Spec.Address))
-
+	if !prefixesContain(opts.allowedPrefixes, ip.Spec.Address) {
+		ll.Warn("not deleting IP from NetBox: outside of the allowed prefixes")
+	} else {
 		err := retry.OnError(
 			backoff1min,
-			func(err error) bool { return true },
+			func(err error) bool { return !errors.Is(err, netbox.ErrDisallowedIP) },
 			func() error {
 				if err := netboxClient.DeleteIP(ctx, netbox.UID(ip.UID)); err != nil {
 					ll.Error("deleting IP from NetBox", log.Error(err))
@@ -102,50 +225,149 @@ func clean(ctx context.Context, cfg *globalConfig) error {
 
 				return nil
 			})
-		ll.Info("deleted from NetBox")
+		if errors.Is(err, netbox.ErrDisallowedIP) {
+			ll.Warn("not deleting IP from NetBox: outside of the allowed prefixes in NetBox")
+		} else {
+			ll.Info("deleted from NetBox")
+		}
+	}
 
-		err = retry.OnError(
-			backoff1min,
-			func(err error) bool { return true },
-			func() error {
-				err = kubeClient.Get(ctx, client.ObjectKey{Namespace: ip.Namespace, Name: ip.Name}, &ip)
-				if kubeerrors.IsNotFound(err) {
-					// something must've deleted this object by now
-					return nil
-				} else if err != nil {
-					ll.Error("retrieving current version of netboxip", log.Error(err))
-					return fmt.Errorf("retrieving current version of netboxip: %w", err)
-				}
+	return retry.OnError(
+		backoff1min,
+		func(err error) bool { return true },
+		func() error {
+			err := kubeClient.Get(ctx, client.ObjectKey{Namespace: ip.Namespace, Name: ip.Name}, &ip)
+			if kubeerrors.IsNotFound(err) {
+				// something must've deleted this object by now
+				return nil
+			} else if err != nil {
+				ll.Error("retrieving current version of netboxip", log.Error(err))
+				return fmt.Errorf("retrieving current version of netboxip: %w", err)
+			}
 
-				controllerutil.RemoveFinalizer(&ip, netboxctrl.IPFinalizer)
-				if err := kubeClient.Update(ctx, &ip); err != nil {
-					ll.Error("removing finalizer", log.Error(err))
-					return fmt.Errorf("removing finalizer: %w", err)
-				}
-				if err := kubeClient.Delete(ctx, &ip); err != nil {
-					ll.Error("deleting netboxip", log.Error(err))
-					return fmt.Errorf("deleting netboxip: %w", err)
-				}
-				ll.Info("netboxip deleted")
+			controllerutil.RemoveFinalizer(&ip, netboxctrl.IPFinalizer)
+			if err := kubeClient.Update(ctx, &ip); err != nil {
+				ll.Error("removing finalizer", log.Error(err))
+				return fmt.Errorf("removing finalizer: %w", err)
+			}
+			if opts.netboxOnly {
 				return nil
-			})
-		if err != nil {
-			multierror.Append(&errs, err)
-		}
+			}
+			if err := kubeClient.Delete(ctx, &ip); err != nil {
+				ll.Error("deleting netboxip", log.Error(err))
+				return fmt.Errorf("deleting netboxip: %w", err)
+			}
+			ll.Info("netboxip deleted")
+			return nil
+		})
+}
+
+// cleanProgressInterval is the minimum interval between progress reports of clean.
+const cleanProgressInterval = 10 * time.Second
+
+// cleanProgress reports how many of the NetBoxIPs have been cleaned,
+// and estimates when cleaning will be done.
+type cleanProgress struct {
+	logger *log.Logger
+	total  int
+	start  time.Time
+
+	mu       sync.Mutex
+	done     int
+	reported time.Time
+}
+
+func newCleanProgress(logger *log.Logger, total int, start time.Time) *cleanProgress {
+	return &cleanProgress{
+		logger:   logger,
+		total:    total,
+		start:    start,
+		reported: start,
 	}
+}
 
-	if errs.ErrorOrNil() != nil {
-		return &errs
+// add counts a cleaned NetBoxIP, and reports the progress
+// if it has not been reported for a while.
+func (p *cleanProgress) add(now time.Time) {
+	p.mu.Lock()
+	p.done++
+	due := now.Sub(p.reported) >= cleanProgressInterval
+	p.mu.Unlock()
+
+	if due {
+		p.report(now)
 	}
+}
 
-	extensionsClient, err := apiextensionsclient.NewForConfig(cfg.kubeConfig)
-	if err != nil {
-		return fmt.Errorf("creating API extensions client: %w", err)
+// report logs the progress.
+func (p *cleanProgress) report(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reported = now
+	p.logger.Info("cleaning netboxips", log.Int("cleaned", p.done), log.Int("total", p.total), log.Duration("eta", p.eta(now)))
+}
+
+// eta estimates how long cleaning the remaining NetBoxIPs will take,
+// from the average time that cleaning one took so far.
+func (p *cleanProgress) eta(now time.Time) time.Duration {
+	if p.done == 0 {
+		return 0
 	}
+	perIP := now.Sub(p.start) / time.Duration(p.done)
+	return perIP * time.Duration(p.total-p.done)
+}
 
-	if err := extensionsClient.ApiextensionsV1().CustomResourceDefinitions().Delete(ctx, crd.NetBoxIPCRDName, metav1.DeleteOptions{}); err != nil {
-		return fmt.Errorf("deleting NetBoxIP custom resource: %w", err)
+// listPageSize is the number of NetBoxIPs listed per request, so that
+// listing the NetBoxIPs of large clusters does not make the API server
+// build, and the command hold, a single response with all of them.
+const listPageSize = 500
+
+// listNetBoxIPs lists the NetBoxIPs page by page, and calls fn with every
+// one of them, which keeps only what it needs. The pages are listed one
+// right after another, since the continue token of the first one expires
+// after a few minutes; slow work, like requests to NetBox, has to wait
+// until all NetBoxIPs are listed.
+func listNetBoxIPs(ctx context.Context, kubeClient client.Client, fn func(ip *v1beta1.NetBoxIP), opts ...client.ListOption) error {
+	var continueToken string
+	for {
+		var page v1beta1.NetBoxIPList
+		pageOpts := append([]client.ListOption{client.Limit(listPageSize), client.Continue(continueToken)}, opts...)
+		if err := kubeClient.List(ctx, &page, pageOpts...); err != nil {
+			return fmt.Errorf("listing netboxips: %w", err)
+		}
+		for i := range page.Items {
+			fn(&page.Items[i])
+		}
+		if page.Continue == "" {
+			return nil
+		}
+		continueToken = page.Continue
 	}
+}
 
-	return nil
+// hasAnyTag returns true if the NetBoxIP has any
+// of the given tags, matched by name or slug.
+func hasAnyTag(ip *v1beta1.NetBoxIP, tags []string) bool {
+	for _, tag := range ip.Spec.Tags {
+		for _, t := range tags {
+			if tag.Name == t || tag.Slug == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// prefixesContain returns true if there are no prefixes,
+// or if the address is within one of them.
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
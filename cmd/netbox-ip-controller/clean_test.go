@@ -0,0 +1,145 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+
+	log "go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// pagedNetBoxIPs pages lists of NetBoxIPs like the API server, which the
+// fake client does not, with the offset of the next page as continue token.
+func pagedNetBoxIPs(pages *int) interceptor.Funcs {
+	return interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			var listOpts client.ListOptions
+			listOpts.ApplyOptions(opts)
+			if err := c.List(ctx, list, client.InNamespace(listOpts.Namespace)); err != nil {
+				return err
+			}
+
+			ips := list.(*v1beta1.NetBoxIPList)
+			sort.Slice(ips.Items, func(i, j int) bool { return ips.Items[i].Name < ips.Items[j].Name })
+			offset := 0
+			if listOpts.Continue != "" {
+				offset, _ = strconv.Atoi(listOpts.Continue)
+			}
+			end := len(ips.Items)
+			if listOpts.Limit > 0 && offset+int(listOpts.Limit) < end {
+				end = offset + int(listOpts.Limit)
+				ips.Continue = strconv.Itoa(end)
+			}
+			ips.Items = ips.Items[offset:end]
+			*pages++
+			return nil
+		},
+	}
+}
+
+func TestListNetBoxIPs(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	builder := fakeclient.NewClientBuilder().WithScheme(scheme)
+	for i := 0; i < listPageSize*2+1; i++ {
+		builder = builder.WithObjects(&v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod-%04d", i), Namespace: "test"},
+			Spec:       v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("192.168.0.1")},
+		})
+	}
+	var pages int
+	kubeClient := builder.WithInterceptorFuncs(pagedNetBoxIPs(&pages)).Build()
+
+	seen := make(map[string]bool)
+	err := listNetBoxIPs(context.Background(), kubeClient, func(ip *v1beta1.NetBoxIP) {
+		seen[ip.Name] = true
+	}, client.InNamespace("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != listPageSize*2+1 {
+		t.Errorf("want %d netboxips, got %d", listPageSize*2+1, len(seen))
+	}
+	if pages != 3 {
+		t.Errorf("want 3 pages, got %d", pages)
+	}
+}
+
+func TestHasAnyTag(t *testing.T) {
+	ip := &v1beta1.NetBoxIP{
+		Spec: v1beta1.NetBoxIPSpec{
+			Tags: []v1beta1.Tag{{Name: "kubernetes", Slug: "kubernetes"}, {Name: "K8s Pod", Slug: "k8s-pod"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		tags     []string
+		expected bool
+	}{{
+		name:     "by slug",
+		tags:     []string{"k8s-pod"},
+		expected: true,
+	}, {
+		name:     "by name",
+		tags:     []string{"k8s-service", "K8s Pod"},
+		expected: true,
+	}, {
+		name:     "no matching tag",
+		tags:     []string{"k8s-service"},
+		expected: false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := hasAnyTag(ip, test.tags); actual != test.expected {
+				t.Errorf("want %t, got %t", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCleanProgressETA(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	progress := newCleanProgress(log.L(), 10, start)
+
+	if eta := progress.eta(start); eta != 0 {
+		t.Errorf("expected no ETA before any NetBoxIP is cleaned, got %s", eta)
+	}
+
+	for i := 0; i < 4; i++ {
+		progress.add(start.Add(time.Duration(i+1) * time.Second))
+	}
+	// 4 cleaned in 8s, 6 remaining
+	if eta := progress.eta(start.Add(8 * time.Second)); eta != 12*time.Second {
+		t.Errorf("expected ETA of 12s, got %s", eta)
+	}
+}
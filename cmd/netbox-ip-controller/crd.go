@@ -0,0 +1,262 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	crd "github.com/digitalocean/netbox-ip-controller/api/netbox"
+
+	"github.com/spf13/cobra"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	flagOutput                  = "output"
+	flagRBAC                    = "rbac"
+	flagServiceAccountNamespace = "service-account-namespace"
+	flagCRDCategoryAll          = "crd-category-all"
+	flagCRDImmutableAddress     = "crd-immutable-address"
+
+	// name of the ClusterRole, ClusterRoleBinding and ServiceAccount of the controller
+	controllerName = "netbox-ip-controller"
+)
+
+type crdConfig struct {
+	output                  string
+	rbac                    bool
+	serviceAccountNamespace string
+	categoryAll             bool
+	immutableAddress        bool
+}
+
+func newCRDCommand() *cobra.Command {
+	cfg := &crdConfig{}
+
+	cmd := &cobra.Command{
+		Use:   "crd",
+		Short: "Prints the NetBoxIP and NetBoxIPControllerConfig CRDs, as registered by the controller, and optionally the RBAC manifests for running the controller with --skip-crd-registration.",
+		// unlike other commands, does not need to connect to NetBox or Kubernetes
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return printManifests(cmd.OutOrStdout(), cfg)
+		},
+	}
+
+	cmd.Flags().StringVarP(&cfg.output, flagOutput, "o", "yaml", "output format: yaml or json")
+	cmd.Flags().BoolVar(&cfg.rbac, flagRBAC, false, "if true, also print the ClusterRole and ClusterRoleBinding required by the controller running with --skip-crd-registration")
+	cmd.Flags().StringVar(&cfg.serviceAccountNamespace, flagServiceAccountNamespace, "default", "namespace of the netbox-ip-controller service account, bound to the ClusterRole")
+	cmd.Flags().BoolVar(&cfg.categoryAll, flagCRDCategoryAll, false, "if true, the NetBoxIP CRD is in the all category, so NetBoxIPs are listed by kubectl get all")
+	cmd.Flags().BoolVar(&cfg.immutableAddress, flagCRDImmutableAddress, false, "if true, the NetBoxIP CRD has a validation rule that rejects changes of the address of NetBoxIPs")
+
+	return cmd
+}
+
+// printManifests writes the manifests to w, as a stream of YAML documents,
+// or a JSON List.
+func printManifests(w io.Writer, cfg *crdConfig) error {
+	manifests, err := manifests(cfg)
+	if err != nil {
+		return err
+	}
+
+	switch cfg.output {
+	case "yaml":
+		for _, m := range manifests {
+			b, err := yaml.Marshal(m)
+			if err != nil {
+				return fmt.Errorf("marshaling manifest: %w", err)
+			}
+			if _, err := fmt.Fprintf(w, "---\n%s", b); err != nil {
+				return err
+			}
+		}
+	case "json":
+		list := map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "List",
+			"items":      manifests,
+		}
+		b, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshaling manifests: %w", err)
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%s value %q is invalid: must be yaml or json", flagOutput, cfg.output)
+	}
+	return nil
+}
+
+// netboxIPCRD returns the NetBoxIP CRD, which is in the all category
+// as well, if categoryAll is true, and whose addresses are immutable,
+// if immutableAddress is true.
+func netboxIPCRD(categoryAll, immutableAddress bool) *apiextensionsv1.CustomResourceDefinition {
+	c := crd.NetBoxIPCRD
+	if categoryAll {
+		c = crd.WithCategories(c, "all")
+	}
+	if immutableAddress {
+		c = crd.WithImmutableAddress(c)
+	}
+	return c
+}
+
+// manifests returns the manifests to be printed, as generic objects
+// without the fields that are only set by the API server.
+func manifests(cfg *crdConfig) ([]map[string]interface{}, error) {
+	var objs []interface{}
+	for _, c := range []*apiextensionsv1.CustomResourceDefinition{netboxIPCRD(cfg.categoryAll, cfg.immutableAddress), crd.NetBoxIPControllerConfigCRD, crd.NetBoxIPClaimCRD} {
+		c = c.DeepCopy()
+		c.APIVersion = apiextensionsv1.SchemeGroupVersion.String()
+		c.Kind = "CustomResourceDefinition"
+		objs = append(objs, c)
+	}
+	if cfg.rbac {
+		objs = append(objs, clusterRole(), clusterRoleBinding(cfg.serviceAccountNamespace))
+	}
+
+	var manifests []map[string]interface{}
+	for _, obj := range objs {
+		b, err := json.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling manifest: %w", err)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("unmarshaling manifest: %w", err)
+		}
+
+		delete(m, "status")
+		if metadata, ok := m["metadata"].(map[string]interface{}); ok {
+			delete(metadata, "creationTimestamp")
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
+
+// clusterRole returns the ClusterRole required by the controller
+// running with --skip-crd-registration.
+func clusterRole() *rbacv1.ClusterRole {
+	return &rbacv1.ClusterRole{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "ClusterRole",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: controllerName,
+		},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{crd.GroupName},
+			Resources: []string{crd.NetBoxIPPlural},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+		}, {
+			APIGroups: []string{crd.GroupName},
+			Resources: []string{crd.NetBoxIPPlural + "/status"},
+			Verbs:     []string{"get", "update", "patch"},
+		}, {
+			// only required with --controller-config
+			APIGroups: []string{crd.GroupName},
+			Resources: []string{crd.NetBoxIPControllerConfigPlural},
+			Verbs:     []string{"get", "list", "watch"},
+		}, {
+			// only required with --ip-claims
+			APIGroups: []string{crd.GroupName},
+			Resources: []string{crd.NetBoxIPClaimPlural},
+			Verbs:     []string{"get", "list", "watch", "update", "patch"},
+		}, {
+			APIGroups: []string{crd.GroupName},
+			Resources: []string{crd.NetBoxIPClaimPlural + "/status"},
+			Verbs:     []string{"get", "update", "patch"},
+		}, {
+			APIGroups: []string{""},
+			Resources: []string{"services", "pods", "namespaces"},
+			Verbs:     []string{"get", "list", "watch"},
+		}, {
+			// only required with --namespace-prefix-parent, to set the
+			// finalizer and prefix annotation of namespaces
+			APIGroups: []string{""},
+			Resources: []string{"namespaces"},
+			Verbs:     []string{"update", "patch"},
+		}, {
+			// only required with --service-load-balancer-ip-pool,
+			// to write allocated IPs to the annotations of services
+			APIGroups: []string{""},
+			Resources: []string{"services"},
+			Verbs:     []string{"patch"},
+		}, {
+			// only required with --service-node-port-services,
+			// and with --node-device-match other than name
+			APIGroups: []string{""},
+			Resources: []string{"nodes"},
+			Verbs:     []string{"get", "list", "watch"},
+		}, {
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"create", "patch"},
+		}, {
+			// only required with --pod-owner-kinds and --pod-job-policy=tag,
+			// to resolve the controllers of pods; other kinds may be added as needed
+			APIGroups: []string{"apps"},
+			Resources: []string{"replicasets", "deployments", "statefulsets", "daemonsets"},
+			Verbs:     []string{"get", "list", "watch"},
+		}, {
+			APIGroups: []string{"batch"},
+			Resources: []string{"jobs", "cronjobs"},
+			Verbs:     []string{"get", "list", "watch"},
+		}, {
+			// only required with --dns-endpoints
+			APIGroups: []string{"externaldns.k8s.io"},
+			Resources: []string{"dnsendpoints"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "delete"},
+		}},
+	}
+}
+
+// clusterRoleBinding binds the controller's ClusterRole to
+// its service account in the given namespace.
+func clusterRoleBinding(namespace string) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "ClusterRoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: controllerName,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     controllerName,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      controllerName,
+			Namespace: namespace,
+		}},
+	}
+}
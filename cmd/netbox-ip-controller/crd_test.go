@@ -0,0 +1,129 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	crd "github.com/digitalocean/netbox-ip-controller/api/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestPrintManifests(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           crdConfig
+		expectedKinds []string
+	}{{
+		name:          "CRD as YAML",
+		cfg:           crdConfig{output: "yaml"},
+		expectedKinds: []string{"CustomResourceDefinition", "CustomResourceDefinition", "CustomResourceDefinition"},
+	}, {
+		name:          "CRD and RBAC as YAML",
+		cfg:           crdConfig{output: "yaml", rbac: true, serviceAccountNamespace: "netbox"},
+		expectedKinds: []string{"CustomResourceDefinition", "CustomResourceDefinition", "CustomResourceDefinition", "ClusterRole", "ClusterRoleBinding"},
+	}, {
+		name:          "CRD and RBAC as JSON",
+		cfg:           crdConfig{output: "json", rbac: true, serviceAccountNamespace: "netbox"},
+		expectedKinds: []string{"CustomResourceDefinition", "CustomResourceDefinition", "CustomResourceDefinition", "ClusterRole", "ClusterRoleBinding"},
+	}, {
+		name:          "CRD in the all category",
+		cfg:           crdConfig{output: "yaml", categoryAll: true},
+		expectedKinds: []string{"CustomResourceDefinition", "CustomResourceDefinition", "CustomResourceDefinition"},
+	}, {
+		name:          "CRD with immutable addresses",
+		cfg:           crdConfig{output: "yaml", immutableAddress: true},
+		expectedKinds: []string{"CustomResourceDefinition", "CustomResourceDefinition", "CustomResourceDefinition"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := printManifests(&buf, &test.cfg); err != nil {
+				t.Fatalf("printing manifests: %s", err)
+			}
+
+			var docs []string
+			if test.cfg.output == "json" {
+				var list struct {
+					Items []json.RawMessage `json:"items"`
+				}
+				if err := json.Unmarshal(buf.Bytes(), &list); err != nil {
+					t.Fatalf("unmarshaling list: %s", err)
+				}
+				for _, item := range list.Items {
+					docs = append(docs, string(item))
+				}
+			} else {
+				docs = strings.Split(strings.TrimPrefix(buf.String(), "---\n"), "---\n")
+			}
+
+			var kinds []string
+			for _, doc := range docs {
+				var obj map[string]interface{}
+				if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+					t.Fatalf("unmarshaling manifest: %s", err)
+				}
+				kinds = append(kinds, obj["kind"].(string))
+
+				if _, ok := obj["status"]; ok {
+					t.Errorf("want no status in %s manifest", obj["kind"])
+				}
+			}
+			if diff := cmp.Diff(test.expectedKinds, kinds); diff != "" {
+				t.Errorf("kinds (-want, +got)\n%s", diff)
+			}
+
+			// the CRDs must be exactly the ones registered by the controller
+			for i, expectedCRD := range []*apiextensionsv1.CustomResourceDefinition{netboxIPCRD(test.cfg.categoryAll, test.cfg.immutableAddress), crd.NetBoxIPControllerConfigCRD, crd.NetBoxIPClaimCRD} {
+				var printedCRD apiextensionsv1.CustomResourceDefinition
+				if err := yaml.UnmarshalStrict([]byte(docs[i]), &printedCRD); err != nil {
+					t.Fatalf("unmarshaling CRD: %s", err)
+				}
+				if diff := cmp.Diff(expectedCRD.Spec, printedCRD.Spec); diff != "" {
+					t.Errorf("%s CRD spec (-want, +got)\n%s", expectedCRD.Name, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestNetBoxIPCRDCategories(t *testing.T) {
+	if diff := cmp.Diff([]string{"netbox"}, netboxIPCRD(false, false).Spec.Names.Categories); diff != "" {
+		t.Errorf("categories (-want, +got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"netbox", "all"}, netboxIPCRD(true, false).Spec.Names.Categories); diff != "" {
+		t.Errorf("categories with the all category (-want, +got)\n%s", diff)
+	}
+	// the registered CRD must not be changed
+	if diff := cmp.Diff([]string{"netbox"}, crd.NetBoxIPCRD.Spec.Names.Categories); diff != "" {
+		t.Errorf("categories of the CRD (-want, +got)\n%s", diff)
+	}
+}
+
+func TestPrintManifestsInvalidOutput(t *testing.T) {
+	err := printManifests(&bytes.Buffer{}, &crdConfig{output: "toml"})
+	if err := expectError(flagOutput, err); err != nil {
+		t.Error(err)
+	}
+}
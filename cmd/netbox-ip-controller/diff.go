@@ -0,0 +1,152 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+)
+
+func newDiffCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Prints the differences between NetBoxIPs and their IPs in NetBox, e.g. to review drift before a resync.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			v := viper.New()
+			v.AutomaticEnv()
+			v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+			if err := v.BindPFlags(cmd.Flags()); err != nil {
+				return fmt.Errorf("binding flags: %w", err)
+			}
+
+			defer globalCfg.logger.Sync()
+
+			scheme := runtime.NewScheme()
+			if err := v1beta1.AddToScheme(scheme); err != nil {
+				return err
+			}
+			kubeClient, err := client.New(globalCfg.kubeConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("creating k8s client: %w", err)
+			}
+
+			netboxClient, closeAudit, err := newNetBoxClient(globalCfg, nil)
+			if err != nil {
+				return fmt.Errorf("creating netbox client: %w", err)
+			}
+			defer closeAudit()
+
+			ctx := signals.SetupSignalHandler()
+			return diff(ctx, globalCfg.logger, cmd.OutOrStdout(), kubeClient, netboxClient, v.GetString(flagNamespace))
+		},
+	}
+
+	cmd.Flags().String(flagNamespace, "", "if set, only the NetBoxIPs in this namespace are compared")
+
+	return cmd
+}
+
+// diffedIP has the fields of IPs that are compared by diff.
+type diffedIP struct {
+	Address     string
+	DNSName     string
+	Tags        []string
+	Description string
+}
+
+// diff writes the differences between the NetBoxIPs, in the given namespace
+// if not empty, and their IPs in NetBox to w, in the format of cmp.Diff.
+func diff(ctx context.Context, logger *log.Logger, w io.Writer, kubeClient client.Client, netboxClient netbox.Client, namespace string) error {
+	var ips []v1beta1.NetBoxIP
+	err := listNetBoxIPs(ctx, kubeClient, func(ip *v1beta1.NetBoxIP) {
+		ip.ManagedFields = nil
+		ips = append(ips, *ip)
+	}, client.InNamespace(namespace))
+	if err != nil {
+		return err
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		return client.ObjectKeyFromObject(&ips[i]).String() < client.ObjectKeyFromObject(&ips[j]).String()
+	})
+
+	// NetBoxIPs with the same address in the same VRF may share a single
+	// IP in NetBox, stored with a UID of its own, which is not compared
+	addresses := make(map[string]int)
+	for _, ip := range ips {
+		addresses[ip.Spec.Address.String()+"|"+ip.Spec.VRF]++
+	}
+
+	var drifted int
+	for _, ip := range ips {
+		stored, err := netboxClient.GetIP(ctx, netbox.UID(ip.UID))
+		if err != nil {
+			return fmt.Errorf("getting IP %s from NetBox: %w", ip.UID, err)
+		}
+
+		if stored == nil && addresses[ip.Spec.Address.String()+"|"+ip.Spec.VRF] > 1 {
+			continue
+		}
+		if stored == nil {
+			fmt.Fprintf(w, "%s/%s (%s): missing from NetBox\n", ip.Namespace, ip.Name, ip.Spec.Address)
+			drifted++
+			continue
+		}
+
+		spec := diffedIP{
+			Address:     ip.Spec.Address.String(),
+			DNSName:     ip.Spec.DNSName,
+			Description: ip.Spec.Description,
+		}
+		for _, tag := range ip.Spec.Tags {
+			spec.Tags = append(spec.Tags, tag.Name)
+		}
+		sort.Strings(spec.Tags)
+
+		live := diffedIP{
+			Address:     netip.Addr(stored.Address).String(),
+			DNSName:     stored.DNSName,
+			Description: stored.Description,
+		}
+		for _, tag := range stored.Tags {
+			live.Tags = append(live.Tags, tag.Name)
+		}
+		sort.Strings(live.Tags)
+
+		if d := cmp.Diff(spec, live); d != "" {
+			fmt.Fprintf(w, "%s/%s (%s) (-netboxip, +netbox):\n%s\n", ip.Namespace, ip.Name, ip.Spec.Address, d)
+			drifted++
+		}
+	}
+
+	logger.Info("compared netboxips with NetBox", log.Int("count", len(ips)), log.Int("drifted", drifted))
+	return nil
+}
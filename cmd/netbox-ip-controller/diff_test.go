@@ -0,0 +1,92 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDiff(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	netboxIP := func(name string, addr string) *v1beta1.NetBoxIP {
+		return &v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test", UID: types.UID(name)},
+			Spec: v1beta1.NetBoxIPSpec{
+				Address:     netip.MustParseAddr(addr),
+				DNSName:     name + ".test",
+				Tags:        []v1beta1.Tag{{Name: "kubernetes", Slug: "kubernetes"}},
+				Description: "app: " + name,
+			},
+		}
+	}
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(
+		netboxIP("in-sync", "192.168.0.1"),
+		netboxIP("drifted", "192.168.0.2"),
+		netboxIP("missing", "192.168.0.3"),
+		netboxIP("shared-1", "192.168.0.4"),
+		netboxIP("shared-2", "192.168.0.4"),
+	).Build()
+
+	netboxClient := netbox.NewFakeClient(nil, map[netbox.UID]netbox.IPAddress{
+		"in-sync": {
+			UID:         "in-sync",
+			Address:     netbox.IP(netip.MustParseAddr("192.168.0.1")),
+			DNSName:     "in-sync.test",
+			Tags:        []netbox.Tag{{ID: 1, Name: "kubernetes", Slug: "kubernetes"}},
+			Description: "app: in-sync",
+		},
+		"drifted": {
+			UID:         "drifted",
+			Address:     netbox.IP(netip.MustParseAddr("192.168.0.2")),
+			DNSName:     "edited.test",
+			Tags:        []netbox.Tag{{ID: 1, Name: "kubernetes", Slug: "kubernetes"}},
+			Description: "app: drifted",
+		},
+	})
+
+	var out bytes.Buffer
+	if err := diff(context.Background(), log.L(), &out, kubeClient, netboxClient, ""); err != nil {
+		t.Fatalf("diffing: %s", err)
+	}
+
+	report := out.String()
+	for _, expected := range []string{"test/drifted", `"edited.test"`, "test/missing (192.168.0.3): missing from NetBox"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected %q in report:\n%s", expected, report)
+		}
+	}
+	for _, unexpected := range []string{"test/in-sync", "test/shared"} {
+		if strings.Contains(report, unexpected) {
+			t.Errorf("unexpected %q in report:\n%s", unexpected, report)
+		}
+	}
+}
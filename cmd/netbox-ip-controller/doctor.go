@@ -0,0 +1,254 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	crd "github.com/digitalocean/netbox-ip-controller/api/netbox"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+)
+
+// Results of doctor checks.
+const (
+	checkPass = "PASS"
+	// checkWarn is the result of checks of things that
+	// the controller fixes itself when it starts.
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+	checkSkip = "SKIP"
+)
+
+// check is a single diagnostic of the doctor command. It returns a short
+// description of what was found, and an error if the check failed.
+type check struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// warning is returned by checks of things that the
+// controller fixes itself when it starts.
+type warning struct {
+	msg string
+}
+
+func (w *warning) Error() string {
+	return w.msg
+}
+
+// errSkipped is returned by checks that do not apply.
+var errSkipped = errors.New("not supported by the IPAM backend")
+
+func newDoctorCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Checks access to Kubernetes and NetBox, and their configuration for the controller.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			v := viper.New()
+			v.AutomaticEnv()
+			v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+			if err := v.BindPFlags(cmd.Flags()); err != nil {
+				return fmt.Errorf("binding flags: %w", err)
+			}
+			defer globalCfg.logger.Sync()
+
+			extensionsClient, err := apiextensionsclient.NewForConfig(globalCfg.kubeConfig)
+			if err != nil {
+				return fmt.Errorf("creating API extensions client: %w", err)
+			}
+			netboxClient, closeAudit, err := newNetBoxClient(globalCfg, nil)
+			if err != nil {
+				return fmt.Errorf("creating netbox client: %w", err)
+			}
+			defer closeAudit()
+
+			var tags []string
+			tags = append(tags, sanitizedStringSlice(v.GetString(flagPodIPTags))...)
+			tags = append(tags, sanitizedStringSlice(v.GetString(flagServiceIPTags))...)
+			if clusterTag := strings.TrimSpace(v.GetString(flagClusterTag)); clusterTag != "" {
+				tags = append(tags, clusterTag)
+			}
+
+			checks := []check{{
+				name: "Kubernetes API",
+				run: func(ctx context.Context) (string, error) {
+					version, err := extensionsClient.Discovery().ServerVersion()
+					if err != nil {
+						return "", err
+					}
+					return "reachable, version " + version.GitVersion, nil
+				},
+			}, {
+				name: "NetBoxIP CRD",
+				run: func(ctx context.Context) (string, error) {
+					return checkCRD(ctx, extensionsClient)
+				},
+			}}
+			checks = append(checks, netboxChecks(netboxClient, tags)...)
+
+			ctx := signals.SetupSignalHandler()
+			return doctor(ctx, cmd.OutOrStdout(), checks)
+		},
+	}
+
+	cmd.Flags().String(flagPodIPTags, "kubernetes,k8s-pod", "comma-separated list of tags added to pod IPs, which are checked")
+	cmd.Flags().String(flagServiceIPTags, "kubernetes,k8s-service", "comma-separated list of tags added to service IPs, which are checked")
+	cmd.Flags().String(flagClusterTag, "", "name of the cluster, which is checked as a tag")
+
+	return cmd
+}
+
+// doctor runs the checks, and prints the result of each. It returns
+// an error if any failed, but not if there were only warnings.
+func doctor(ctx context.Context, w io.Writer, checks []check) error {
+	var failed int
+	for _, c := range checks {
+		msg, err := c.run(ctx)
+		result := checkPass
+		var warn *warning
+		switch {
+		case errors.Is(err, errSkipped):
+			result = checkSkip
+		case errors.As(err, &warn):
+			result = checkWarn
+		case err != nil:
+			result = checkFail
+			failed++
+		}
+		if err != nil {
+			msg = err.Error()
+		}
+		fmt.Fprintf(w, "[%s] %s: %s\n", result, c.name, msg)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// checkCRD checks that the NetBoxIP CRD exists, and
+// that it matches the one of this version of the controller.
+func checkCRD(ctx context.Context, extensionsClient apiextensionsclient.Interface) (string, error) {
+	existing, err := extensionsClient.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, crd.NetBoxIPCRDName, metav1.GetOptions{})
+	if kubeerrors.IsNotFound(err) {
+		return "", errors.New("not found: it is registered when the controller starts, unless CRD registration is disabled")
+	} else if err != nil {
+		return "", err
+	}
+
+	immutable := crd.WithImmutableAddress(crd.NetBoxIPCRD)
+	for i, version := range crd.NetBoxIPCRD.Spec.Versions {
+		var found bool
+		for _, existingVersion := range existing.Spec.Versions {
+			if existingVersion.Name != version.Name {
+				continue
+			}
+			found = true
+			if !existingVersion.Served {
+				return "", fmt.Errorf("version %s is not served", version.Name)
+			}
+			// the address may have been made immutable with --crd-immutable-address
+			if !equality.Semantic.DeepEqual(existingVersion.Schema, version.Schema) &&
+				!equality.Semantic.DeepEqual(existingVersion.Schema, immutable.Spec.Versions[i].Schema) {
+				return "", fmt.Errorf("schema of version %s differs from the one of this version of the controller", version.Name)
+			}
+			if existingVersion.Subresources == nil || existingVersion.Subresources.Status == nil {
+				return "", fmt.Errorf("version %s has no status subresource", version.Name)
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("version %s is missing", version.Name)
+		}
+	}
+	return "found, up to date", nil
+}
+
+// netboxChecks returns the checks of NetBox, and of the given tags in it.
+func netboxChecks(netboxClient netbox.Client, tags []string) []check {
+	diagnoser, _ := netboxClient.(netbox.Diagnoser)
+
+	checks := []check{{
+		name: "NetBox API",
+		run: func(ctx context.Context) (string, error) {
+			if diagnoser == nil {
+				return "", errSkipped
+			}
+			version, err := diagnoser.Version(ctx)
+			if err != nil {
+				return "", err
+			}
+			return "reachable, version " + version, nil
+		},
+	}, {
+		name: "NetBox token permissions",
+		run: func(ctx context.Context) (string, error) {
+			if diagnoser == nil {
+				return "", errSkipped
+			}
+			canWrite, err := diagnoser.CanWriteIPs(ctx)
+			if err != nil {
+				return "", err
+			}
+			if !canWrite {
+				return "", errors.New("not allowed to create IP addresses")
+			}
+			return "allowed to create IP addresses", nil
+		},
+	}, {
+		name: "NetBox UID custom field",
+		run: func(ctx context.Context) (string, error) {
+			if diagnoser == nil {
+				return "", errSkipped
+			}
+			if err := diagnoser.CheckUIDField(ctx); err != nil {
+				return "", err
+			}
+			return "found, up to date", nil
+		},
+	}}
+
+	for _, tag := range tags {
+		tag := tag
+		checks = append(checks, check{
+			name: fmt.Sprintf("NetBox tag %q", tag),
+			run: func(ctx context.Context) (string, error) {
+				existing, err := netboxClient.GetTag(ctx, tag)
+				if err != nil {
+					return "", err
+				}
+				if existing == nil {
+					return "", &warning{msg: "not found: it is created when the controller publishes the first IP with it"}
+				}
+				return "found", nil
+			},
+		})
+	}
+	return checks
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	crd "github.com/digitalocean/netbox-ip-controller/api/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	fakeextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestDoctor(t *testing.T) {
+	result := func(msg string, err error) func(context.Context) (string, error) {
+		return func(context.Context) (string, error) { return msg, err }
+	}
+
+	tests := []struct {
+		name           string
+		checks         []check
+		expectedReport string
+		expectedErr    bool
+	}{{
+		name: "passing and skipped checks",
+		checks: []check{
+			{name: "foo", run: result("fine", nil)},
+			{name: "bar", run: result("", errSkipped)},
+		},
+		expectedReport: "[PASS] foo: fine\n[SKIP] bar: not supported by the IPAM backend\n",
+	}, {
+		name: "warnings",
+		checks: []check{
+			{name: "foo", run: result("", &warning{msg: "missing"})},
+		},
+		expectedReport: "[WARN] foo: missing\n",
+	}, {
+		name: "failures",
+		checks: []check{
+			{name: "foo", run: result("", errors.New("broken"))},
+			{name: "bar", run: result("fine", nil)},
+		},
+		expectedReport: "[FAIL] foo: broken\n[PASS] bar: fine\n",
+		expectedErr:    true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var report bytes.Buffer
+			err := doctor(context.Background(), &report, test.checks)
+			if test.expectedErr && err == nil {
+				t.Error("expected error but got none")
+			} else if !test.expectedErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			if diff := cmp.Diff(test.expectedReport, report.String()); diff != "" {
+				t.Errorf("report (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckCRD(t *testing.T) {
+	outdated := crd.NetBoxIPCRD.DeepCopy()
+	outdated.Spec.Versions[0].Schema = nil
+	withoutStatus := crd.NetBoxIPCRD.DeepCopy()
+	withoutStatus.Spec.Versions[0].Subresources = nil
+
+	tests := []struct {
+		name              string
+		existing          []runtime.Object
+		expectedErrSubstr string
+	}{{
+		name:     "up to date",
+		existing: []runtime.Object{crd.NetBoxIPCRD.DeepCopy()},
+	}, {
+		name:     "up to date with immutable addresses",
+		existing: []runtime.Object{crd.WithImmutableAddress(crd.NetBoxIPCRD)},
+	}, {
+		name:              "missing",
+		expectedErrSubstr: "not found",
+	}, {
+		name:              "outdated",
+		existing:          []runtime.Object{outdated},
+		expectedErrSubstr: "differs",
+	}, {
+		name:              "without status subresource",
+		existing:          []runtime.Object{withoutStatus},
+		expectedErrSubstr: "no status subresource",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			extensionsClient := fakeextensionsclient.NewSimpleClientset(test.existing...)
+
+			_, err := checkCRD(context.Background(), extensionsClient)
+			if test.expectedErrSubstr == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if test.expectedErrSubstr != "" && (err == nil || !strings.Contains(err.Error(), test.expectedErrSubstr)) {
+				t.Errorf("expected error containing %q, got %v", test.expectedErrSubstr, err)
+			}
+		})
+	}
+}
@@ -386,7 +386,7 @@ func TestClean(t *testing.T) {
 			t.Fatalf("creating netboxip: %q\n", err)
 		}
 
-		_, err = env.NetboxClient.UpsertIP(context.Background(), &netbox.IPAddress{
+		_, _, err = env.NetboxClient.UpsertIP(context.Background(), &netbox.IPAddress{
 			UID:     netbox.UID(ip.UID),
 			DNSName: name,
 			Address: netbox.IP(addr),
@@ -408,7 +408,7 @@ func TestClean(t *testing.T) {
 		logger:       logger,
 	}
 	ctx := context.Background()
-	if err := clean(ctx, cfg); err != nil {
+	if err := clean(ctx, cfg, cleanOptions{}); err != nil {
 		t.Error(err)
 	}
 
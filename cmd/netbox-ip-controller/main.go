@@ -22,7 +22,15 @@ import (
 
 func main() {
 	rootCmd := newRootCommand()
+	rootCmd.AddCommand(newBackupCommand())
 	rootCmd.AddCommand(newCleanCommand())
+	rootCmd.AddCommand(newCRDCommand())
+	rootCmd.AddCommand(newDiffCommand())
+	rootCmd.AddCommand(newDoctorCommand())
+	rootCmd.AddCommand(newPruneCommand())
+	rootCmd.AddCommand(newRestoreCommand())
+	rootCmd.AddCommand(newResyncCommand())
+	rootCmd.AddCommand(newUninstallCommand())
 
 	cobra.CheckErr(rootCmd.Execute())
 }
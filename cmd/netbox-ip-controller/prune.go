@@ -0,0 +1,216 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+)
+
+const (
+	flagDryRun    = "dry-run"
+	flagPruneTags = "tags"
+	flagKeepTags  = "keep-tags"
+)
+
+func newPruneCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Removes IPs from NetBox that were created by the controller, but whose NetBoxIPs no longer exist.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			v := viper.New()
+			v.AutomaticEnv()
+			v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+			if err := v.BindPFlags(cmd.Flags()); err != nil {
+				return fmt.Errorf("binding flags: %w", err)
+			}
+			allowedPrefixes, err := parseAllowedPrefixes(v.GetString(flagAllowedPrefixes))
+			if err != nil {
+				return err
+			}
+
+			defer globalCfg.logger.Sync()
+
+			scheme := runtime.NewScheme()
+			if err := v1beta1.AddToScheme(scheme); err != nil {
+				return err
+			}
+			if err := corev1.AddToScheme(scheme); err != nil {
+				return err
+			}
+			kubeClient, err := client.New(globalCfg.kubeConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("creating k8s client: %w", err)
+			}
+
+			netboxClient, closeAudit, err := newNetBoxClient(globalCfg, allowedPrefixes)
+			if err != nil {
+				return fmt.Errorf("creating netbox client: %w", err)
+			}
+			defer closeAudit()
+
+			ctx := signals.SetupSignalHandler()
+			dryRun := v.GetBool(flagDryRun)
+			// nothing is changed in a dry run
+			branch := v.GetBool(flagBranch) && !dryRun
+			return inBranch(ctx, globalCfg.logger, netboxClient, branch, "prune", time.Now(), func(ctx context.Context) error {
+				if err := prune(ctx, globalCfg.logger, kubeClient, netboxClient, dryRun); err != nil {
+					return err
+				}
+				if !v.GetBool(flagPruneTags) {
+					return nil
+				}
+				return pruneTags(ctx, globalCfg.logger, netboxClient, sanitizedStringSlice(v.GetString(flagKeepTags)), dryRun)
+			})
+		},
+	}
+
+	cmd.Flags().String(flagAllowedPrefixes, "", "comma-separated list of CIDRs; if set, IPs in NetBox outside of these prefixes are not deleted")
+	cmd.Flags().Bool(flagDryRun, false, "if true, the IPs and tags that would be deleted are only logged")
+	cmd.Flags().Bool(flagPruneTags, false, "if true, tags created by the controller that no object in NetBox is tagged with are deleted as well")
+	cmd.Flags().String(flagKeepTags, "", "comma-separated list of tags that are not deleted by --tags even if unused, e.g. the tags the controller is configured with")
+	cmd.Flags().Bool(flagBranch, false, branchFlagUsage)
+
+	return cmd
+}
+
+// prune deletes the IPs managed by the controller from NetBox whose
+// NetBoxIPs, NetBoxIPClaims or services no longer exist, e.g. because they were deleted while the
+// controller was not running, and their finalizers were removed.
+func prune(ctx context.Context, logger *log.Logger, kubeClient client.Client, netboxClient netbox.Client, dryRun bool) error {
+	// IPs are listed before NetBoxIPs, so that the NetBoxIPs of IPs
+	// created in the meantime are listed as well
+	ips, err := netboxClient.ListIPs(ctx, netbox.IPFilter{Managed: true})
+	if err != nil {
+		return fmt.Errorf("listing IPs in NetBox: %w", err)
+	}
+
+	existing := make(map[netbox.UID]bool)
+	err = listNetBoxIPs(ctx, kubeClient, func(ip *v1beta1.NetBoxIP) {
+		existing[netbox.UID(ip.UID)] = true
+	})
+	if err != nil {
+		return err
+	}
+	// IPs allocated for NetBoxIPClaims have the UIDs of the claims, whose
+	// CRD is only registered if the controller runs with --ip-claims
+	var claimList v1beta1.NetBoxIPClaimList
+	if err := kubeClient.List(ctx, &claimList); err != nil && !meta.IsNoMatchError(err) {
+		return fmt.Errorf("listing netboxipclaims: %w", err)
+	}
+	for _, claim := range claimList.Items {
+		existing[netbox.UID(claim.UID)] = true
+	}
+	// IPs allocated for LoadBalancer services have the UIDs of the
+	// services, until they are taken over by the NetBoxIPs of their addresses
+	var serviceList corev1.ServiceList
+	if err := kubeClient.List(ctx, &serviceList); err != nil {
+		return fmt.Errorf("listing services: %w", err)
+	}
+	for _, svc := range serviceList.Items {
+		existing[netbox.UID(svc.UID)] = true
+	}
+
+	var pruned int
+	var errs multierror.Error
+	for _, ip := range ips {
+		if existing[ip.UID] {
+			continue
+		}
+
+		ll := logger.With(log.String("uid", string(ip.UID)), log.Int64("id", ip.ID), log.Any("ip", ip.Address))
+		if dryRun {
+			ll.Info("would delete orphaned IP from NetBox")
+			pruned++
+			continue
+		}
+		if err := netboxClient.DeleteIP(ctx, ip.UID); errors.Is(err, netbox.ErrDisallowedIP) {
+			ll.Warn("not deleting orphaned IP from NetBox: outside of the allowed prefixes")
+			continue
+		} else if err != nil {
+			ll.Error("deleting orphaned IP from NetBox", log.Error(err))
+			multierror.Append(&errs, fmt.Errorf("deleting IP %s from NetBox: %w", ip.UID, err))
+			continue
+		}
+		ll.Info("deleted orphaned IP from NetBox")
+		pruned++
+	}
+
+	logger.Info("pruned orphaned IPs", log.Int("count", pruned), log.Bool("dryRun", dryRun))
+	return errs.ErrorOrNil()
+}
+
+// pruneTags deletes the tags created by the controller that no object
+// in NetBox is tagged with anymore, e.g. because the tags the controller
+// applies were changed, except for the ones in keep.
+func pruneTags(ctx context.Context, logger *log.Logger, netboxClient netbox.Client, keep []string, dryRun bool) error {
+	collector, ok := netboxClient.(netbox.TagCollector)
+	if !ok {
+		return errors.New("pruning tags is only supported with the NetBox IPAM backend")
+	}
+
+	tags, err := collector.ListUnusedTags(ctx)
+	if err != nil {
+		return fmt.Errorf("listing unused tags in NetBox: %w", err)
+	}
+
+	kept := make(map[string]bool)
+	for _, tag := range keep {
+		kept[tag] = true
+	}
+
+	var pruned int
+	var errs multierror.Error
+	for _, tag := range tags {
+		if kept[tag.Name] {
+			continue
+		}
+
+		ll := logger.With(log.String("tag", tag.Name), log.Int64("id", tag.ID))
+		if dryRun {
+			ll.Info("would delete unused tag from NetBox")
+			pruned++
+			continue
+		}
+		if err := collector.DeleteTag(ctx, tag); err != nil {
+			ll.Error("deleting unused tag from NetBox", log.Error(err))
+			multierror.Append(&errs, fmt.Errorf("deleting tag %s from NetBox: %w", tag.Name, err))
+			continue
+		}
+		ll.Info("deleted unused tag from NetBox")
+		pruned++
+	}
+
+	logger.Info("pruned unused tags", log.Int("count", pruned), log.Bool("dryRun", dryRun))
+	return errs.ErrorOrNil()
+}
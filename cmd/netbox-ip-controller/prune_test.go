@@ -0,0 +1,136 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPrune(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+	corev1.AddToScheme(scheme)
+
+	tests := []struct {
+		name        string
+		dryRun      bool
+		expectedIPs []netbox.UID
+	}{{
+		name:        "delete orphaned IPs",
+		expectedIPs: []netbox.UID{"", "allocated", "claimed", "live"},
+	}, {
+		name:        "dry run",
+		dryRun:      true,
+		expectedIPs: []netbox.UID{"", "allocated", "claimed", "live", "orphaned"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(&v1beta1.NetBoxIP{
+				ObjectMeta: metav1.ObjectMeta{Name: "live", Namespace: "test", UID: "live"},
+				Spec:       v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("192.168.0.1")},
+			}, &v1beta1.NetBoxIPClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claimed", Namespace: "test", UID: "claimed"},
+				Spec:       v1beta1.NetBoxIPClaimSpec{Prefix: netip.MustParsePrefix("192.168.0.0/24")},
+			}, &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "allocated", Namespace: "test", UID: "allocated"},
+			}).Build()
+
+			ips := map[netbox.UID]netbox.IPAddress{
+				"live":     {ID: 1, UID: "live", Address: netbox.IP(netip.MustParseAddr("192.168.0.1"))},
+				"orphaned": {ID: 2, UID: "orphaned", Address: netbox.IP(netip.MustParseAddr("192.168.0.2"))},
+				"claimed":  {ID: 4, UID: "claimed", Address: netbox.IP(netip.MustParseAddr("192.168.0.4"))},
+				// allocated for a service, but not yet taken over by a NetBoxIP
+				"allocated": {ID: 5, UID: "allocated", Address: netbox.IP(netip.MustParseAddr("192.168.0.5"))},
+				// not managed by the controller
+				"": {ID: 3, Address: netbox.IP(netip.MustParseAddr("192.168.0.3"))},
+			}
+			netboxClient := netbox.NewFakeClient(nil, ips)
+
+			if err := prune(context.Background(), log.L(), kubeClient, netboxClient, test.dryRun); err != nil {
+				t.Fatalf("pruning: %q", err)
+			}
+
+			var actual []netbox.UID
+			for uid := range ips {
+				actual = append(actual, uid)
+			}
+			sort.Slice(actual, func(i, j int) bool { return actual[i] < actual[j] })
+
+			if diff := cmp.Diff(test.expectedIPs, actual); diff != "" {
+				t.Errorf("IPs in NetBox (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPruneTags(t *testing.T) {
+	tests := []struct {
+		name         string
+		dryRun       bool
+		expectedTags []string
+	}{{
+		name:         "delete unused tags",
+		expectedTags: []string{"foreign", "kept", "used"},
+	}, {
+		name:         "dry run",
+		dryRun:       true,
+		expectedTags: []string{"foreign", "kept", "unused", "used"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// tags that were not created by the controller are never deleted
+			tags := map[string]netbox.Tag{"foreign": {Name: "foreign", Slug: "foreign"}}
+			ips := make(map[netbox.UID]netbox.IPAddress)
+			netboxClient := netbox.NewFakeClient(tags, ips)
+			for _, tag := range []string{"kept", "unused", "used"} {
+				if _, err := netboxClient.CreateTag(context.Background(), tag); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ips["live"] = netbox.IPAddress{ID: 1, UID: "live", Tags: []netbox.Tag{tags["used"]}}
+
+			if err := pruneTags(context.Background(), log.L(), netboxClient, []string{"kept"}, test.dryRun); err != nil {
+				t.Fatalf("pruning tags: %q", err)
+			}
+
+			var actual []string
+			for name := range tags {
+				actual = append(actual, name)
+			}
+			sort.Strings(actual)
+
+			if diff := cmp.Diff(test.expectedTags, actual); diff != "" {
+				t.Errorf("tags in NetBox (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
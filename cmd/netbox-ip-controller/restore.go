@@ -0,0 +1,226 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "go.uber.org/zap"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+)
+
+const (
+	flagNetBoxRecords = "netbox-records"
+)
+
+func newRestoreCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Re-creates the NetBoxIPs, and optionally the IPs in NetBox, from an archive written by backup that no longer exist.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			v := viper.New()
+			v.AutomaticEnv()
+			v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+			if err := v.BindPFlags(cmd.Flags()); err != nil {
+				return fmt.Errorf("binding flags: %w", err)
+			}
+			file := v.GetString(flagFile)
+			if file == "" {
+				return fmt.Errorf("%s is required", flagFile)
+			}
+			allowedPrefixes, err := parseAllowedPrefixes(v.GetString(flagAllowedPrefixes))
+			if err != nil {
+				return err
+			}
+
+			defer globalCfg.logger.Sync()
+
+			f, err := os.Open(file)
+			if err != nil {
+				return fmt.Errorf("opening backup file: %w", err)
+			}
+			archive, err := readBackup(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("reading backup file: %w", err)
+			}
+
+			scheme := runtime.NewScheme()
+			if err := v1beta1.AddToScheme(scheme); err != nil {
+				return err
+			}
+			kubeClient, err := client.New(globalCfg.kubeConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("creating k8s client: %w", err)
+			}
+
+			netboxClient, closeAudit, err := newNetBoxClient(globalCfg, allowedPrefixes)
+			if err != nil {
+				return fmt.Errorf("creating netbox client: %w", err)
+			}
+			defer closeAudit()
+
+			ctx := signals.SetupSignalHandler()
+			return inBranch(ctx, globalCfg.logger, netboxClient, v.GetBool(flagBranch), "restore", time.Now(), func(ctx context.Context) error {
+				return restore(ctx, globalCfg.logger, kubeClient, netboxClient, archive, v.GetBool(flagNetBoxRecords))
+			})
+		},
+	}
+
+	cmd.Flags().String(flagFile, "", "path of the archive file written by backup")
+	cmd.Flags().Bool(flagNetBoxRecords, false, "if true, IPs in NetBox that no longer exist are re-created as well")
+	cmd.Flags().String(flagAllowedPrefixes, "", "comma-separated list of CIDRs; if set, IPs in NetBox outside of these prefixes are not written")
+	cmd.Flags().Bool(flagBranch, false, branchFlagUsage)
+
+	return cmd
+}
+
+// restore re-creates the NetBoxIPs in archive that no longer exist, and, if
+// restoreRecords is true, the IPs in NetBox. NetBoxIPs are matched by name, and
+// IPs in NetBox by UID. Since re-created NetBoxIPs get new UIDs, IPs in NetBox
+// that have the UIDs of their predecessors are taken over by them.
+func restore(ctx context.Context, logger *log.Logger, kubeClient client.Client, netboxClient netbox.Client, archive *backupArchive, restoreRecords bool) error {
+	var errs multierror.Error
+
+	// current UIDs of the NetBoxIPs, by their UIDs in the archive
+	uids := make(map[netbox.UID]netbox.UID)
+	var restoredNetBoxIPs int
+	for _, ip := range archive.NetBoxIPs {
+		ll := logger.With(log.String("namespace", ip.Namespace), log.String("name", ip.Name))
+
+		var existing v1beta1.NetBoxIP
+		if err := kubeClient.Get(ctx, client.ObjectKeyFromObject(&ip), &existing); err == nil {
+			uids[netbox.UID(ip.UID)] = netbox.UID(existing.UID)
+			continue
+		} else if !kubeerrors.IsNotFound(err) {
+			multierror.Append(&errs, fmt.Errorf("getting netboxip %s/%s: %w", ip.Namespace, ip.Name, err))
+			continue
+		}
+
+		restored := &v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        ip.Name,
+				Namespace:   ip.Namespace,
+				Labels:      ip.Labels,
+				Annotations: ip.Annotations,
+				// NetBoxIPs whose owners no longer exist
+				// are deleted by the garbage collector
+				OwnerReferences: ip.OwnerReferences,
+				Finalizers:      ip.Finalizers,
+			},
+			Spec: ip.Spec,
+		}
+		if err := kubeClient.Create(ctx, restored); err != nil {
+			ll.Error("re-creating netboxip", log.Error(err))
+			multierror.Append(&errs, fmt.Errorf("creating netboxip %s/%s: %w", ip.Namespace, ip.Name, err))
+			continue
+		}
+		ll.Info("re-created netboxip")
+		uids[netbox.UID(ip.UID)] = netbox.UID(restored.UID)
+		restoredNetBoxIPs++
+	}
+
+	var restoredRecords int
+	for _, record := range archive.Records {
+		uid := record.UID
+		if current, ok := uids[record.UID]; ok {
+			uid = current
+		}
+		ll := logger.With(log.String("uid", string(uid)), log.Any("ip", record.Address))
+
+		existing, err := netboxClient.GetIP(ctx, uid)
+		if err != nil {
+			multierror.Append(&errs, fmt.Errorf("getting IP %s from NetBox: %w", uid, err))
+			continue
+		}
+		if existing != nil {
+			continue
+		}
+
+		if uid != record.UID {
+			predecessor, err := netboxClient.GetIP(ctx, record.UID)
+			if err != nil {
+				multierror.Append(&errs, fmt.Errorf("getting IP %s from NetBox: %w", record.UID, err))
+				continue
+			}
+			if predecessor != nil {
+				predecessor.UID = uid
+				predecessor.TakeOverUID = record.UID
+				if _, _, err := netboxClient.UpsertIP(ctx, predecessor); errors.Is(err, netbox.ErrDisallowedIP) {
+					ll.Warn("not taking over IP in NetBox: outside of the allowed prefixes")
+					continue
+				} else if err != nil {
+					ll.Error("taking over IP in NetBox", log.Error(err))
+					multierror.Append(&errs, fmt.Errorf("taking over IP %s in NetBox: %w", record.UID, err))
+					continue
+				}
+				ll.Info("took over IP in NetBox", log.String("from", string(record.UID)))
+				continue
+			}
+		}
+
+		if !restoreRecords {
+			continue
+		}
+		var tagNames []string
+		for _, tag := range record.Tags {
+			tagNames = append(tagNames, tag.Name)
+		}
+		tags, err := ctrl.EnsureTags(ctx, netboxClient, ll, tagNames)
+		if err != nil {
+			multierror.Append(&errs, err)
+			continue
+		}
+
+		restored := record
+		restored.ID = 0
+		restored.UID = uid
+		restored.Tags = nil
+		for _, tag := range tags {
+			restored.Tags = append(restored.Tags, netbox.Tag{Name: tag.Name, Slug: tag.Slug})
+		}
+		if _, _, err := netboxClient.UpsertIP(ctx, &restored); errors.Is(err, netbox.ErrDisallowedIP) {
+			ll.Warn("not re-creating IP in NetBox: outside of the allowed prefixes")
+			continue
+		} else if err != nil {
+			ll.Error("re-creating IP in NetBox", log.Error(err))
+			multierror.Append(&errs, fmt.Errorf("creating IP %s in NetBox: %w", uid, err))
+			continue
+		}
+		ll.Info("re-created IP in NetBox")
+		restoredRecords++
+	}
+
+	logger.Info("restored from backup", log.Int("netboxips", restoredNetBoxIPs), log.Int("records", restoredRecords))
+	return errs.ErrorOrNil()
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// restoredUID sets the UID of created objects, which the fake client does not.
+var restoredUID = interceptor.Funcs{
+	Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+		obj.SetUID("restored")
+		return c.Create(ctx, obj, opts...)
+	},
+}
+
+func TestRestore(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	netboxIP := func(name string, uid types.UID) v1beta1.NetBoxIP {
+		return v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test", UID: uid},
+			Spec:       v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("192.168.0.1")},
+		}
+	}
+	record := func(uid netbox.UID, addr string) netbox.IPAddress {
+		return netbox.IPAddress{
+			ID:      1,
+			UID:     uid,
+			Address: netbox.IP(netip.MustParseAddr(addr)),
+			Tags:    []netbox.Tag{{ID: 1, Name: "kubernetes", Slug: "kubernetes"}},
+		}
+	}
+
+	archive := &backupArchive{
+		NetBoxIPs: []v1beta1.NetBoxIP{netboxIP("existing", "existing"), netboxIP("deleted", "old")},
+		Records: []netbox.IPAddress{
+			record("existing", "192.168.0.1"),
+			record("old", "192.168.0.2"),
+			record("lost", "192.168.0.3"),
+		},
+	}
+
+	tests := []struct {
+		name           string
+		restoreRecords bool
+		existingIPs    []netbox.UID
+		expectedIPs    []netbox.UID
+	}{{
+		name:        "without NetBox records",
+		existingIPs: []netbox.UID{"existing", "old"},
+		// the IP of the re-created NetBoxIP is taken over
+		expectedIPs: []netbox.UID{"existing", "restored"},
+	}, {
+		name:           "with NetBox records",
+		restoreRecords: true,
+		existingIPs:    []netbox.UID{"existing"},
+		expectedIPs:    []netbox.UID{"existing", "restored", "lost"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			existing := netboxIP("existing", "existing")
+			kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(&existing).
+				WithInterceptorFuncs(restoredUID).Build()
+
+			ips := make(map[netbox.UID]netbox.IPAddress)
+			for _, uid := range test.existingIPs {
+				ips[uid] = netbox.IPAddress{UID: uid}
+			}
+			netboxClient := netbox.NewFakeClient(nil, ips)
+
+			if err := restore(context.Background(), log.L(), kubeClient, netboxClient, archive, test.restoreRecords); err != nil {
+				t.Fatalf("restoring: %s", err)
+			}
+
+			var restored v1beta1.NetBoxIP
+			if err := kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "test", Name: "deleted"}, &restored); err != nil {
+				t.Errorf("getting re-created netboxip: %s", err)
+			}
+
+			if len(ips) != len(test.expectedIPs) {
+				t.Errorf("expected %d IPs in NetBox, got %d", len(test.expectedIPs), len(ips))
+			}
+			for _, uid := range test.expectedIPs {
+				if _, ok := ips[uid]; !ok {
+					t.Errorf("expected IP %s in NetBox", uid)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "go.uber.org/zap"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+)
+
+const (
+	flagNamespace = "namespace"
+)
+
+func newResyncCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resync",
+		Short: "Makes the running controller upsert the IPs of all NetBoxIPs in NetBox again, e.g. after NetBox was restored from a backup.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			v := viper.New()
+			v.AutomaticEnv()
+			v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+			if err := v.BindPFlags(cmd.Flags()); err != nil {
+				return fmt.Errorf("binding flags: %w", err)
+			}
+
+			defer globalCfg.logger.Sync()
+
+			scheme := runtime.NewScheme()
+			if err := v1beta1.AddToScheme(scheme); err != nil {
+				return err
+			}
+			kubeClient, err := client.New(globalCfg.kubeConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("creating k8s client: %w", err)
+			}
+
+			ctx := signals.SetupSignalHandler()
+			return resync(ctx, globalCfg.logger, kubeClient, v.GetString(flagNamespace), time.Now())
+		},
+	}
+
+	cmd.Flags().String(flagNamespace, "", "if set, only the NetBoxIPs in this namespace are resynced")
+
+	return cmd
+}
+
+// resync sets ResyncAnnotation of every NetBoxIP, in the given namespace
+// if not empty, to now, which makes the controller reconcile them, and
+// thereby upsert their IPs in NetBox, even if they have not changed.
+func resync(ctx context.Context, logger *log.Logger, kubeClient client.Client, namespace string, now time.Time) error {
+	var ips []v1beta1.NetBoxIP
+	err := listNetBoxIPs(ctx, kubeClient, func(ip *v1beta1.NetBoxIP) {
+		ip.ManagedFields = nil
+		ips = append(ips, *ip)
+	}, client.InNamespace(namespace))
+	if err != nil {
+		return err
+	}
+
+	var resynced int
+	var errs multierror.Error
+	for i := range ips {
+		ip := &ips[i]
+		ll := logger.With(log.String("namespace", ip.Namespace), log.String("name", ip.Name))
+
+		// a merge patch does not conflict with concurrent
+		// updates of the NetBoxIP by the controller
+		patch := client.MergeFrom(ip.DeepCopy())
+		if ip.Annotations == nil {
+			ip.Annotations = make(map[string]string)
+		}
+		ip.Annotations[netboxctrl.ResyncAnnotation] = now.UTC().Format(time.RFC3339)
+		if err := kubeClient.Patch(ctx, ip, patch); kubeerrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			ll.Error("annotating netboxip", log.Error(err))
+			multierror.Append(&errs, fmt.Errorf("annotating netboxip %s/%s: %w", ip.Namespace, ip.Name, err))
+			continue
+		}
+		resynced++
+	}
+
+	logger.Info("resynced netboxips", log.Int("count", resynced))
+	return errs.ErrorOrNil()
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+
+	"github.com/google/go-cmp/cmp"
+	log "go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestResync(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	netboxIP := func(namespace, name string) *v1beta1.NetBoxIP {
+		return &v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Annotations: map[string]string{"foo": "bar"},
+			},
+			Spec: v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("192.168.0.1")},
+		}
+	}
+
+	tests := []struct {
+		name             string
+		namespace        string
+		expectedResynced []string
+	}{{
+		name:             "all namespaces",
+		expectedResynced: []string{"a/ip", "b/ip"},
+	}, {
+		name:             "single namespace",
+		namespace:        "b",
+		expectedResynced: []string{"b/ip"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(
+				netboxIP("a", "ip"),
+				netboxIP("b", "ip"),
+			).Build()
+
+			if err := resync(context.Background(), log.L(), kubeClient, test.namespace, now); err != nil {
+				t.Fatalf("resyncing: %q", err)
+			}
+
+			var ips v1beta1.NetBoxIPList
+			if err := kubeClient.List(context.Background(), &ips); err != nil {
+				t.Fatalf("listing netboxips: %q", err)
+			}
+			var resynced []string
+			for _, ip := range ips.Items {
+				if ip.Annotations["foo"] != "bar" {
+					t.Errorf("want other annotations of %s/%s kept, got %v", ip.Namespace, ip.Name, ip.Annotations)
+				}
+				if ip.Annotations[netboxctrl.ResyncAnnotation] == "2022-06-01T12:00:00Z" {
+					resynced = append(resynced, client.ObjectKeyFromObject(&ip).String())
+				}
+			}
+
+			if diff := cmp.Diff(test.expectedResynced, resynced); diff != "" {
+				t.Errorf("resynced netboxips (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -18,17 +18,34 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
 	crd "github.com/digitalocean/netbox-ip-controller/api/netbox"
 	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
 	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	claimctrl "github.com/digitalocean/netbox-ip-controller/internal/controller/claim"
+	configctrl "github.com/digitalocean/netbox-ip-controller/internal/controller/config"
+	nsctrl "github.com/digitalocean/netbox-ip-controller/internal/controller/namespace"
 	netboxipctrl "github.com/digitalocean/netbox-ip-controller/internal/controller/netbox-ip"
 	podctrl "github.com/digitalocean/netbox-ip-controller/internal/controller/pod"
 	svcctrl "github.com/digitalocean/netbox-ip-controller/internal/controller/service"
 	"github.com/digitalocean/netbox-ip-controller/internal/crdregistration"
+	"github.com/digitalocean/netbox-ip-controller/internal/infoblox"
+	"github.com/digitalocean/netbox-ip-controller/internal/metrics"
 	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+	"github.com/digitalocean/netbox-ip-controller/internal/phpipam"
+	"github.com/digitalocean/netbox-ip-controller/internal/timing"
+	"github.com/digitalocean/netbox-ip-controller/internal/webhook"
 
 	"github.com/go-logr/zapr"
 	"github.com/spf13/cobra"
@@ -40,6 +57,7 @@ import (
 	kubescheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
@@ -64,6 +82,106 @@ const (
 	flagDebug                = "debug"
 	flagNetboxCACertPath     = "netbox-ca-cert-path"
 	flagDualStackIP          = "dual-stack-ip"
+	flagNetBoxOAuthTokenURL  = "netbox-oauth-token-url"
+	flagNetBoxOAuthClientID  = "netbox-oauth-client-id"
+	flagNetBoxOAuthSecret    = "netbox-oauth-client-secret"
+	flagNetBoxOAuthScopes    = "netbox-oauth-scopes"
+	flagSkipCRDRegistration  = "skip-crd-registration"
+	flagNetBoxTLSMinVersion  = "netbox-tls-min-version"
+	flagNetBoxTLSCiphers     = "netbox-tls-ciphers"
+	flagNetBoxTLSServerName  = "netbox-tls-server-name"
+	flagRedactFields         = "redact-fields"
+	flagAuditLogPath         = "audit-log-path"
+	flagAllowedPrefixes      = "allowed-prefixes"
+	flagTenantMappingPath    = "tenant-mapping-path"
+	flagClusterTag           = "cluster-tag"
+	flagUIDPrefix            = "uid-prefix"
+	flagIPAMBackend          = "ipam-backend"
+	flagPHPIPAMAPIURL        = "phpipam-api-url"
+	flagPHPIPAMAppID         = "phpipam-app-id"
+	flagPHPIPAMToken         = "phpipam-token"
+	flagPHPIPAMSubnetIDs     = "phpipam-subnet-ids"
+	flagInfobloxAPIURL       = "infoblox-api-url"
+	flagInfobloxUsername     = "infoblox-username"
+	flagInfobloxPassword     = "infoblox-password"
+	flagInfobloxNetworkView  = "infoblox-network-view"
+	flagWebhookURL           = "webhook-url"
+	flagWebhookTimeout       = "webhook-timeout"
+	flagDNSEndpoints         = "dns-endpoints"
+	flagNetBoxWebhookAddr    = "netbox-webhook-addr"
+	flagNetBoxWebhookSecret  = "netbox-webhook-secret"
+	flagNetBoxIPMetricsLimit = "netboxip-metrics-limit"
+	flagControllerConfig     = "controller-config"
+	flagIPClaims             = "ip-claims"
+	flagDeletionPolicy       = "deletion-policy"
+	flagCompletedPodIPTTL    = "completed-pod-ip-ttl"
+	flagDeletionDebounce     = "deletion-debounce"
+	flagDuplicateIPStrategy  = "duplicate-ip-strategy"
+	flagAdoptionPolicy       = "adoption-policy"
+	flagPodOmitDNSName       = "pod-omit-dns-name"
+	flagServiceOmitDNSName   = "service-omit-dns-name"
+	flagDescriptionStrategy  = "description-strategy"
+	flagRequeueInterval      = "requeue-interval"
+	flagPodExcludeOwnerKinds = "pod-exclude-owner-kinds"
+	flagPodSkipStaticPods    = "pod-skip-static-pods"
+	flagPodOwnerKinds        = "pod-owner-kinds"
+	flagPodRequireReady      = "pod-require-ready"
+	flagPodNotReadyGrace     = "pod-not-ready-grace-period"
+	flagPodJobPolicy         = "pod-job-policy"
+	flagPodJobTag            = "pod-job-tag"
+	flagAnnotationOverrides  = "annotation-overrides"
+	flagSharedAddresses      = "shared-addresses"
+	flagPodCustomFields      = "pod-custom-field-annotations"
+	flagPodMACAddresses      = "pod-mac-addresses"
+	flagNodeDeviceMatch      = "node-device-match"
+	flagNodeInterface        = "node-interface"
+	flagPublishIPv4          = "publish-ipv4"
+	flagPublishIPv6          = "publish-ipv6"
+	flagServiceLBIPs         = "service-load-balancer-ips"
+	flagServiceLBNAT         = "service-load-balancer-nat"
+	flagServiceLBIPPool      = "service-load-balancer-ip-pool"
+	flagServiceLBIPAnnot     = "service-load-balancer-ip-annotation"
+	flagServiceLBResolve     = "service-resolve-load-balancer-hostnames"
+	flagServiceLBRefresh     = "service-load-balancer-hostname-refresh"
+	flagServiceNodePorts     = "service-node-port-services"
+	flagServiceExtNames      = "service-external-names"
+	flagServiceExtRefresh    = "service-external-name-refresh"
+	flagServiceNSDomains     = "service-namespace-cluster-domains"
+	flagServiceVIPs          = "service-vip-annotations"
+	flagServiceVIPTag        = "service-vip-tag"
+	flagServiceClusterIPTags = "service-cluster-ip-tags"
+	flagServiceLBIPTags      = "service-load-balancer-ip-tags"
+	flagServiceSelectorField = "service-selector-field"
+	flagServicePortsField    = "service-ports-field"
+	flagNSMetricsLimit       = "namespace-metrics-limit"
+	flagNSPrefixParent       = "namespace-prefix-parent"
+	flagNSPrefixLength       = "namespace-prefix-length"
+	flagNetBoxLogBodies      = "netbox-log-body-limit"
+	flagNetBoxLookupTTL      = "netbox-lookup-cache-ttl"
+	flagNetBoxTimeout        = "netbox-request-timeout"
+	flagNetBoxUIDField       = "netbox-uid-field-name"
+	flagNetBoxPreviousUID    = "netbox-previous-uid-field-name"
+	flagSkipUIDFieldMigrate  = "skip-netbox-uid-field-migration"
+	flagMetricsTLSCertPath   = "metrics-tls-cert-path"
+	flagMetricsTLSKeyPath    = "metrics-tls-key-path"
+	flagMetricsClientCAPath  = "metrics-tls-client-ca-path"
+	flagEventDedupWindow     = "event-dedup-window"
+	flagSlowReconcile        = "slow-reconcile-threshold"
+	flagShardIndex           = "shard-index"
+	flagShardCount           = "shard-count"
+	flagServiceIPPriority    = "service-ip-priority"
+	flagQueueMaxSize         = "queue-max-size"
+	flagQueueOverflowPolicy  = "queue-overflow-policy"
+	flagQueueParkDelay       = "queue-park-delay"
+	flagControllerRateLimits = "netbox-controller-rate-limits"
+	flagTagRefreshInterval   = "netbox-tag-refresh-interval"
+)
+
+// Supported IPAM backends.
+const (
+	ipamBackendNetBox   = "netbox"
+	ipamBackendPHPIPAM  = "phpipam"
+	ipamBackendInfoblox = "infoblox"
 )
 
 type globalConfig struct {
@@ -74,19 +192,138 @@ type globalConfig struct {
 	netboxBurst      int
 	logger           *log.Logger
 	netboxCACertPath string
-	dualStackIP      bool
+	netboxOAuth      netbox.ClientCredentialsConfig
+	netboxTLSVersion string
+	netboxTLSCiphers []string
+	netboxTLSName    string
+	redactFields     []string
+	auditLogPath     string
+	uidPrefix        string
+	duplicateIPs     string
+	adoptionPolicy   string
+	ipamBackend      string
+	phpipamAPIURL    string
+	phpipamAppID     string
+	phpipamToken     string
+	phpipamSubnetIDs []int64
+	infobloxAPIURL   string
+	infobloxUsername string
+	infobloxPassword string
+	infobloxView     string
+	netboxBodyLimit  int
+	netboxLookupTTL  time.Duration
+	netboxTimeout    time.Duration
+	uidField         string
+	previousUIDField string
+	// if true, an outdated UID field definition in NetBox is not updated
+	skipUIDFieldMigration bool
 }
 
 var globalCfg = &globalConfig{}
 
+// tags are created with slugs equal to their names, so they
+// must be valid NetBox slugs
+var tagRegexp = regexp.MustCompile("^[-a-zA-Z0-9_]+$")
+
+var kindRegexp = regexp.MustCompile("^[A-Z][a-zA-Z0-9]*$")
+
+var customFieldRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]{1,50}$")
+
+// rateBudgetControllers are the controllers that may have
+// a NetBox rate budget of their own.
+var rateBudgetControllers = []string{
+	podctrl.ControllerName,
+	svcctrl.ControllerName,
+	netboxipctrl.ControllerName,
+	netboxipctrl.ServiceControllerName,
+	claimctrl.ControllerName,
+	nsctrl.ControllerName,
+}
+
+// rateBudget is the rate limit of requests to NetBox of a controller.
+type rateBudget struct {
+	qps   rate.Limit
+	burst int
+}
+
+var uidPrefixRegexp = regexp.MustCompile("^[-a-zA-Z0-9_.]+$")
+
 type rootConfig struct {
-	metricsAddr    string
-	readyCheckAddr string
-	podTags        []string
-	serviceTags    []string
-	podLabels      map[string]bool
-	serviceLabels  map[string]bool
-	clusterDomain  string
+	metricsAddr          string
+	readyCheckAddr       string
+	podTags              []string
+	serviceTags          []string
+	podLabels            map[string]bool
+	serviceLabels        map[string]bool
+	clusterDomain        string
+	skipCRDRegistration  bool
+	crdCategoryAll       bool
+	crdImmutableAddress  bool
+	allowedPrefixes      []netip.Prefix
+	tenantMappingPath    string
+	clusterTag           string
+	webhookURL           string
+	webhookTimeout       time.Duration
+	dnsEndpoints         bool
+	netboxWebhookAddr    string
+	netboxWebhookSecret  string
+	netboxIPMetricsLimit int
+	controllerConfig     string
+	ipClaims             bool
+	deletionPolicy       string
+	completedPodIPTTL    time.Duration
+	deletionDebounce     time.Duration
+	podOmitDNSName       bool
+	serviceOmitDNSName   bool
+	descriptionStrategy  string
+	requeueInterval      time.Duration
+	podExcludeOwnerKinds []string
+	podSkipStaticPods    bool
+	podOwnerKinds        []string
+	podRequireReady      bool
+	podNotReadyGrace     time.Duration
+	podJobPolicy         string
+	podJobTag            string
+	annotationOverrides  bool
+	sharedAddresses      bool
+	podCustomFields      map[string]string
+	podMACAddresses      bool
+	nodeDeviceMatch      string
+	nodeInterface        string
+	publishIPv4          bool
+	publishIPv6          bool
+	serviceLBIPs         bool
+	serviceLBNAT         bool
+	serviceLBIPPool      netip.Prefix
+	serviceLBIPAnnot     string
+	serviceLBResolve     bool
+	serviceLBRefresh     time.Duration
+	serviceNodePorts     bool
+	serviceExtNames      bool
+	serviceExtRefresh    time.Duration
+	serviceNSDomains     bool
+	serviceVIPs          bool
+	serviceVIPTag        string
+	serviceClusterIPTags []string
+	serviceLBIPTags      []string
+	serviceSelectorField string
+	servicePortsField    string
+	nsMetricsLimit       int
+	nsPrefixParent       netip.Prefix
+	nsPrefixLength       int
+	metricsTLSCert       string
+	metricsTLSKey        string
+	metricsClientCA      string
+	eventDedupWindow     time.Duration
+	slowReconcile        time.Duration
+	shardIndex           int
+	shardCount           int
+	serviceIPPriority    bool
+	queueMaxSize         int
+	queueOverflowPolicy  string
+	queueParkDelay       time.Duration
+	rateBudgets          map[string]rateBudget
+	tagRefreshInterval   time.Duration
 }
 
 func newRootCommand() *cobra.Command {
@@ -106,6 +343,13 @@ to be published.`,
 			return cfg.setup(cmd)
 		},
 		RunE: func(cmd *cobra.Command, _ []string) error {
+			hash, err := configHash(cmd)
+			if err != nil {
+				return err
+			}
+			metrics.SetBuildInfo(buildInfo())
+			metrics.SetConfigHash(hash)
+
 			ctx := signals.SetupSignalHandler()
 			return run(ctx, globalCfg, cfg)
 		},
@@ -127,19 +371,115 @@ func registerGlobalFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().Float64(flagNetBoxQPS, 100.0, "average allowable requests per second to NetBox API, i.e., the rate limiter's token bucket refill rate per second")
 	cmd.PersistentFlags().Int(flagNetBoxBurst, 1, "maximum allowable burst of requests to NetBox API, i.e. the rate limiter's token bucket size")
 	cmd.PersistentFlags().Bool(flagDebug, false, "turn on debug logging")
-	cmd.PersistentFlags().String(flagNetboxCACertPath, "", "absolute path to a file containing a PEM-encoded root certificate to verify NetBox server's certificate")
-	cmd.PersistentFlags().Bool(flagDualStackIP, false, "if true, both IPv4 and IPv6 addresses will be registered in netbox for dual stack pods and services")
+	cmd.PersistentFlags().String(flagNetboxCACertPath, "", "absolute path to a file, or a directory of .pem/.crt/.cer files, containing PEM-encoded root certificates to verify NetBox server's certificate; reloaded automatically on change")
+	cmd.PersistentFlags().Bool(flagDualStackIP, false, "")
+	cmd.PersistentFlags().MarkDeprecated(flagDualStackIP, fmt.Sprintf("IPs of both families are published by default; see --%s and --%s", flagPublishIPv4, flagPublishIPv6))
+	cmd.PersistentFlags().String(flagNetBoxOAuthTokenURL, "", "URL of the OAuth2/OIDC token endpoint; if set, requests to NetBox are authenticated with a bearer token acquired using the client credentials grant instead of the NetBox token")
+	cmd.PersistentFlags().String(flagNetBoxOAuthClientID, "", "OAuth2 client ID to use with the client credentials grant")
+	cmd.PersistentFlags().String(flagNetBoxOAuthSecret, "", "OAuth2 client secret to use with the client credentials grant")
+	cmd.PersistentFlags().String(flagNetBoxOAuthScopes, "", "comma-separated list of OAuth2 scopes to request with the client credentials grant")
+	cmd.PersistentFlags().String(flagNetBoxTLSMinVersion, "", "minimum TLS version to use for connections to NetBox (1.0, 1.1, 1.2 or 1.3); defaults to the Go default")
+	cmd.PersistentFlags().String(flagNetBoxTLSCiphers, "", "comma-separated list of TLS 1.0-1.2 cipher suites allowed for connections to NetBox, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256; defaults to the Go default")
+	cmd.PersistentFlags().String(flagRedactFields, "", "comma-separated list of additional header, JSON field and query parameter names whose values are redacted from logs and errors")
+	cmd.PersistentFlags().String(flagAuditLogPath, "", "path to a file to which every create, update and delete operation performed against NetBox is appended as a line of JSON; use \"-\" for stdout")
+	cmd.PersistentFlags().String(flagUIDPrefix, "", "cluster-scoped prefix of UIDs stored in NetBox, which are then stored as <prefix>/<uid>; prevents UID collisions when several clusters publish IPs into the same NetBox")
+	cmd.PersistentFlags().String(flagDuplicateIPStrategy, netbox.DuplicateStrategyFail, "what to do about several IPs with the same UID in NetBox: fail, until they are removed manually; adopt-oldest, which uses the oldest one; or merge-and-delete-duplicates, which also merges the others into it, and then removes them according to the deletion policy")
+	cmd.PersistentFlags().String(flagAdoptionPolicy, netbox.AdoptionPolicyDuplicate, "what to do when an IP is created, whose address already exists in NetBox, but is not managed by the controller: duplicate, which creates another IP; skip, which does not create the IP; adopt, which takes ownership of the existing IP; or adopt-matching, which does so only if the existing IP also has the same DNS name, merging its tags")
+	cmd.PersistentFlags().String(flagNetBoxTLSServerName, "", "if set, NetBox server's certificate must be valid for this name instead of the host in the NetBox API URL")
+	cmd.PersistentFlags().String(flagIPAMBackend, ipamBackendNetBox, "IPAM system to publish IPs to: netbox, phpipam or infoblox")
+	cmd.PersistentFlags().String(flagPHPIPAMAPIURL, "", "URL of the phpIPAM API server to connect to (scheme://host:port/api), without the app ID; required with the phpipam backend")
+	cmd.PersistentFlags().String(flagPHPIPAMAppID, "", "ID of the phpIPAM API app; required with the phpipam backend")
+	cmd.PersistentFlags().String(flagPHPIPAMToken, "", "app code of the phpIPAM API app, used as the API token; required with the phpipam backend")
+	cmd.PersistentFlags().String(flagPHPIPAMSubnetIDs, "", "comma-separated list of IDs of phpIPAM subnets in which IPs are created; required with the phpipam backend")
+	cmd.PersistentFlags().String(flagInfobloxAPIURL, "", "versioned URL of the Infoblox WAPI to connect to (scheme://host:port/wapi/v2.11); required with the infoblox backend")
+	cmd.PersistentFlags().String(flagInfobloxUsername, "", "Infoblox username to use for authentication; required with the infoblox backend")
+	cmd.PersistentFlags().String(flagInfobloxPassword, "", "Infoblox password to use for authentication; required with the infoblox backend")
+	cmd.PersistentFlags().String(flagInfobloxNetworkView, "", "Infoblox network view in which host records are created; defaults to the default network view")
+	cmd.PersistentFlags().Int(flagNetBoxLogBodies, 0, "if greater than 0, bodies of requests to NetBox and of its responses are logged at debug level, with secrets redacted, truncated to this many bytes")
+	cmd.PersistentFlags().Duration(flagNetBoxLookupTTL, netbox.DefaultLookupCacheTTL, "how long the NetBox IDs of VRFs and tenants, looked up by name and slug, are cached; 0 disables caching")
+	cmd.PersistentFlags().Duration(flagNetBoxTimeout, netbox.DefaultRequestTimeout, "how long an attempt of a request to NetBox may take before it fails, and is retried if possible; 0 disables the timeout")
+	cmd.PersistentFlags().String(flagNetBoxUIDField, netbox.UIDCustomFieldName, "name of the NetBox custom field that UIDs are stored in; prevents collisions when several tools, or generations of the controller, share a NetBox")
+	cmd.PersistentFlags().String(flagNetBoxPreviousUID, "", "if set, name of the NetBox custom field that UIDs were stored in before; UIDs are moved from it to the current UID field when their IPs are reconciled")
+	cmd.PersistentFlags().Bool(flagSkipUIDFieldMigrate, false, "if true, the validation regex, label and content types of an existing NetBox UID custom field are not updated when they differ from the ones the controller would create it with")
 }
 
 // register flags relevant for the root command itself, but not its children
 func registerRootFlags(cmd *cobra.Command) {
 	cmd.Flags().String(flagMetricsAddr, ":8001", "the address on which to serve metrics")
+	cmd.Flags().String(flagMetricsTLSCertPath, "", "path to a PEM-encoded certificate; if set, metrics are served over TLS")
+	cmd.Flags().String(flagMetricsTLSKeyPath, "", "path to the PEM-encoded private key of the metrics certificate; required with metrics-tls-cert-path")
+	cmd.Flags().String(flagMetricsClientCAPath, "", "path to PEM-encoded root certificates; if set, clients scraping metrics must present a certificate signed by one of them")
 	cmd.Flags().String(flagPodIPTags, "kubernetes,k8s-pod", "comma-separated list of tags to add to pod IPs in NetBox")
 	cmd.Flags().String(flagServiceIPTags, "kubernetes,k8s-service", "comma-separated list of tags to add to service IPs in NetBox")
 	cmd.Flags().String(flagPodPublishLabels, "app", "comma-separated list of pod labels that should be added to the IP description in NetBox")
 	cmd.Flags().String(flagServicePublishLabels, "app", "comma-separated list of service labels that should be added to the IP description in NetBox")
 	cmd.Flags().String(flagClusterDomain, "cluster.local", "domain name of the cluster")
+	cmd.Flags().Bool(flagPublishIPv4, true, "if true, IPv4 pod and cluster IPs are published")
+	cmd.Flags().Bool(flagPublishIPv6, true, "if true, IPv6 pod and cluster IPs are published")
 	cmd.Flags().String(flagReadyCheckAddr, ":5001", "address for the controller manager to serve a readiness check endpoint on")
+	cmd.Flags().String(flagAllowedPrefixes, "", "comma-separated list of CIDRs; if set, the controller refuses to create, update, release, deprecate or delete IPs in NetBox outside of these prefixes")
+	cmd.Flags().String(flagClusterTag, "", "name of the cluster, added as a tag to every IP in NetBox and included in IP descriptions; useful when several clusters publish IPs into the same NetBox")
+	cmd.Flags().String(flagTenantMappingPath, "", "path to a YAML file mapping namespaces to NetBox tenants and, optionally, tenant-specific NetBox API tokens")
+	cmd.Flags().String(flagWebhookURL, "", "URL to which a JSON event is POSTed whenever an IP is created, updated or deleted in NetBox")
+	cmd.Flags().Duration(flagWebhookTimeout, 10*time.Second, "timeout of a single attempt to deliver an event to the webhook URL")
+	cmd.Flags().Duration(flagSlowReconcile, 5*time.Second, "reconciliations taking longer than this log a warning with the time spent waiting for the NetBox rate limiter, NetBox and Kubernetes; 0 disables the warning")
+	cmd.Flags().Int(flagShardIndex, 0, "index of this replica out of shard-count replicas, which reconciles objects in the namespaces whose names hash to it")
+	cmd.Flags().Int(flagShardCount, 1, "number of replicas that namespaces are split between by the hash of their names; each replica must be run with a different shard-index")
+	cmd.Flags().Bool(flagServiceIPPriority, false, "if true, NetBoxIPs of services are reconciled with a workqueue of their own, so that they are not delayed by a backlog of NetBoxIPs of pods, e.g. during a resync after a NetBox outage")
+	cmd.Flags().Int(flagQueueMaxSize, 0, "if greater than 0, the maximum number of events waiting in the workqueue of each controller; events beyond it are handled according to --queue-overflow-policy")
+	cmd.Flags().String(flagQueueOverflowPolicy, ctrl.QueueOverflowPark, fmt.Sprintf("what happens to events beyond --queue-max-size: %s adds them to the workqueue after --queue-park-delay, and %s drops them until the object changes again or the next periodic resync", ctrl.QueueOverflowPark, ctrl.QueueOverflowShed))
+	cmd.Flags().Duration(flagQueueParkDelay, time.Minute, "with --queue-overflow-policy=park, how long events beyond --queue-max-size are parked before being added to the workqueue")
+	cmd.Flags().String(flagControllerRateLimits, "", fmt.Sprintf("comma-separated <controller>=<qps>:<burst> rate limits of requests to NetBox of controllers that do not share the --%s and --%s budget; controllers are %s", flagNetBoxQPS, flagNetBoxBurst, strings.Join(rateBudgetControllers, ", ")))
+	cmd.Flags().Duration(flagTagRefreshInterval, 10*time.Minute, "how often the tags of the controller are looked up again in NetBox, so that IPs are published with their current slugs, and deleted tags are created again; 0 disables refreshing")
+	cmd.Flags().Duration(flagEventDedupWindow, 5*time.Minute, "window in which repeated Kubernetes events of the same object, reason and message are collapsed into a single event with a count; 0 disables collapsing")
+	cmd.Flags().Bool(flagDNSEndpoints, false, "if true, an external-dns DNSEndpoint resource is created for every published IP with a DNS name; requires the DNSEndpoint CRD to be installed")
+	cmd.Flags().String(flagNetBoxWebhookAddr, "", "if set, the address on which to receive NetBox webhooks for IP address changes, so that manual changes in NetBox are reverted right away")
+	cmd.Flags().String(flagNetBoxWebhookSecret, "", "secret of the NetBox webhooks, used to validate their signatures; required with netbox-webhook-addr")
+	cmd.Flags().Int(flagNetBoxIPMetricsLimit, 0, "if greater than 0, the netbox_ip_info metric describing the sync state of each NetBoxIP is exported for at most this many NetBoxIPs")
+	cmd.Flags().Int(flagNSMetricsLimit, metrics.DefaultNamespaceLimit, "maximum number of namespaces for which the netbox_ip_published metric is exported; IPs in other namespaces are counted with namespace=\"_other\"")
+	cmd.Flags().String(flagDeletionPolicy, ctrl.DeletionPolicyDelete, "what happens to the IP in NetBox when its pod or service is deleted: delete; retain, which keeps the IP, but clears its UID so that it is no longer managed by the controller; or deprecate, which also sets the status of the IP to deprecated and appends the time of deletion to its description")
+	cmd.Flags().String(flagPodExcludeOwnerKinds, "", "comma-separated list of kinds of controllers, e.g. DaemonSet, whose pods' IPs are not published")
+	cmd.Flags().String(flagPodOwnerKinds, "", "comma-separated list of kinds of controllers, e.g. StatefulSet,Deployment; if set, only IPs of pods controlled by them, directly or through other controllers, are published")
+	cmd.Flags().Bool(flagPodRequireReady, false, "if true, IPs of pods are only published once the pods are ready")
+	cmd.Flags().Duration(flagPodNotReadyGrace, 5*time.Minute, "with --pod-require-ready, how long IPs of pods that stop being ready are kept before they are removed")
+	cmd.Flags().String(flagPodJobPolicy, ctrl.JobPolicyPublish, fmt.Sprintf("how IPs of pods controlled by Jobs are published: %s, %s or %s", ctrl.JobPolicyPublish, ctrl.JobPolicySkip, ctrl.JobPolicyTag))
+	cmd.Flags().String(flagPodJobTag, "job", "with --pod-job-policy=tag, the tag added to IPs of pods controlled by Jobs")
+	cmd.Flags().Bool(flagAnnotationOverrides, false, fmt.Sprintf("if true, the tenant and VRF of pod IPs may be set with the %s and %s pod annotations", netboxctrl.TenantAnnotation, netboxctrl.VRFAnnotation))
+	cmd.Flags().String(flagPodCustomFields, "", "comma-separated list of NetBox custom fields of pod IPs, and the pod annotations that their values are taken from, e.g. cost_center=example.com/cost-center")
+	cmd.Flags().Bool(flagPodMACAddresses, false, "if true, pod IPs are assigned to NetBox interfaces with the MAC addresses of the pod's network interfaces, as reported by the CNI in the k8s.v1.cni.cncf.io/network-status or k8s.ovn.org/pod-networks annotation, which are created on the device named after the node")
+	cmd.Flags().String(flagNodeDeviceMatch, ctrl.NodeDeviceMatchName, fmt.Sprintf("how nodes are matched to the NetBox devices that interfaces of their pods are assigned on: %s, the device named after the node; %s, the device whose %s custom field is the provider ID of the node; %s, the device whose serial number is the system UUID of the node; or %s<key>, the device named after the value of the node label with the given key", ctrl.NodeDeviceMatchName, ctrl.NodeDeviceMatchProviderID, ctrl.ProviderIDCustomField, ctrl.NodeDeviceMatchSerial, ctrl.NodeDeviceMatchLabelPrefix))
+	cmd.Flags().String(flagNodeInterface, "", "if set, IPs of host network pods, which are the IPs of their nodes, are assigned to the interface with this name of the NetBox devices of the nodes, see --node-device-match")
+	cmd.Flags().Bool(flagSharedAddresses, false, "if true, IPs of host network pods are published, and IPs with the same address and VRF are published as a single IP in NetBox with merged tags and descriptions")
+	cmd.Flags().Bool(flagPodSkipStaticPods, false, "if true, IPs of static pods are not published")
+	cmd.Flags().Bool(flagPodOmitDNSName, false, "if true, pod IPs are published without a DNS name")
+	cmd.Flags().Bool(flagServiceOmitDNSName, false, "if true, service IPs are published without a DNS name")
+	cmd.Flags().Bool(flagServiceLBIPs, false, "if true, the addresses of load balancer ingress points of LoadBalancer services are published")
+	cmd.Flags().Bool(flagServiceLBNAT, false, "with --service-load-balancer-ips or --service-vip-annotations, if true, the IPs of load balancer addresses in NetBox have the cluster IPs of their services as their NAT inside addresses; only supported with the netbox IPAM backend")
+	cmd.Flags().String(flagServiceLBIPPool, "", fmt.Sprintf("with --service-vip-annotations, if set, a CIDR of a prefix in NetBox from which an IP is allocated for every LoadBalancer service with the %s annotation set to true, and written to its --service-load-balancer-ip-annotation; only supported with the netbox IPAM backend", netboxctrl.AllocateLoadBalancerIPAnnotation))
+	cmd.Flags().String(flagServiceLBIPAnnot, "metallb.universe.tf/loadBalancerIPs", fmt.Sprintf("with --service-load-balancer-ip-pool, the annotation that allocated IPs are written to: %s", strings.Join(ctrl.VIPAnnotations, " or ")))
+	cmd.Flags().Bool(flagServiceLBResolve, false, "with --service-load-balancer-ips, if true, load balancer ingress points that only have a hostname, e.g. on AWS, are resolved and their addresses published; otherwise, they are skipped")
+	cmd.Flags().Duration(flagServiceLBRefresh, 5*time.Minute, "with --service-resolve-load-balancer-hostnames, how often load balancer hostnames are resolved again")
+	cmd.Flags().Bool(flagServiceNodePorts, false, "if true, the node ports of NodePort and LoadBalancer services are published as NetBox services on the NetBox IPs of the nodes; only supported with the netbox IPAM backend")
+	cmd.Flags().Bool(flagServiceExtNames, false, "if true, the addresses that the targets of ExternalName services resolve to are published, with the target in the description")
+	cmd.Flags().Duration(flagServiceExtRefresh, 5*time.Minute, "with --service-external-names, how often the targets of ExternalName services are resolved again")
+	cmd.Flags().Bool(flagServiceNSDomains, false, fmt.Sprintf("if true, the cluster domain in the DNS names of services is overridden by the %s annotation of their namespace, if set", netboxctrl.ClusterDomainAnnotation))
+	cmd.Flags().Bool(flagServiceVIPs, false, "if true, the addresses that LoadBalancer services request with the kube-vip.io/loadbalancerIPs or metallb.universe.tf/loadBalancerIPs annotations are published before they are assigned, so that they are reserved in NetBox")
+	cmd.Flags().String(flagServiceClusterIPTags, "", "comma-separated list of tags to add to cluster IPs of services in NetBox, in addition to --service-ip-tags")
+	cmd.Flags().String(flagServiceLBIPTags, "", "comma-separated list of tags to add to load balancer addresses of services in NetBox, in addition to --service-ip-tags")
+	cmd.Flags().String(flagServiceSelectorField, "", "if set, the NetBox custom field of service IPs that the selector of the service is written to, e.g. app=foo")
+	cmd.Flags().String(flagServicePortsField, "", "if set, the NetBox custom field of service IPs that the ports of the service are written to, e.g. 80/TCP,443/TCP")
+	cmd.Flags().String(flagServiceVIPTag, "vip", "with --service-vip-annotations, the tag added to IPs of addresses requested with annotations")
+	cmd.Flags().String(flagNSPrefixParent, "", fmt.Sprintf("if set, a CIDR of a prefix in NetBox from which a child prefix is allocated for every namespace with the %s annotation, which is recorded in its %s annotation; only supported with the netbox IPAM backend", netboxctrl.AllocatePrefixAnnotation, netboxctrl.PrefixAnnotation))
+	cmd.Flags().Int(flagNSPrefixLength, 24, fmt.Sprintf("with --namespace-prefix-parent, the length of prefixes allocated for namespaces whose %s annotation is true rather than a prefix length", netboxctrl.AllocatePrefixAnnotation))
+	cmd.Flags().String(flagDescriptionStrategy, ctrl.DescriptionStrategyTruncate, fmt.Sprintf("how descriptions longer than NetBox allows are shortened: %s, %s or %s", ctrl.DescriptionStrategyTruncate, ctrl.DescriptionStrategyDropLabels, ctrl.DescriptionStrategyHashSuffix))
+	cmd.Flags().Duration(flagRequeueInterval, 0, "if greater than 0, how often every pod, service and NetBoxIP is reconciled even if it does not change, so that changes made in NetBox are reverted")
+	cmd.Flags().Duration(flagDeletionDebounce, 0, "how long IPs are kept in NetBox after their pod or service is deleted, during which an object with the same address takes over the IP, which is then updated rather than deleted and created again; by default, IPs are removed right away")
+	cmd.Flags().Duration(flagCompletedPodIPTTL, 0, "how long the IPs of succeeded or failed pods, e.g. of jobs, are kept in NetBox after the pods complete; by default, they are deleted right away")
+	cmd.Flags().String(flagControllerConfig, "", "if set, the name of the cluster-scoped NetBoxIPControllerConfig resource that is watched for tags, publish labels, namespace filters and NetBox rate limits, which then override the corresponding flags without a restart")
+	cmd.Flags().Bool(flagIPClaims, false, "if true, the NetBoxIPClaim CRD is registered, and an available IP is allocated in NetBox for every NetBoxIPClaim from the prefix it claims; only supported with the netbox IPAM backend")
+	cmd.Flags().Bool(flagSkipCRDRegistration, false, "if true, the controller will not create or update the NetBoxIP CRD, which must then be installed separately")
+	cmd.Flags().Bool(flagCRDCategoryAll, false, "if true, the NetBoxIP CRD is registered in the all category, so NetBoxIPs are listed by kubectl get all")
+	cmd.Flags().Bool(flagCRDImmutableAddress, false, "if true, the NetBoxIP CRD is registered with a validation rule that rejects changes of the address of NetBoxIPs, which are then deleted and re-created instead; requires Kubernetes 1.25 or later")
 }
 
 func (cfg *globalConfig) setup(cmd *cobra.Command) error {
@@ -167,7 +507,42 @@ func (cfg *globalConfig) setup(cmd *cobra.Command) error {
 	cfg.netboxQPS = rate.Limit(v.GetFloat64(flagNetBoxQPS))
 	cfg.netboxBurst = v.GetInt(flagNetBoxBurst)
 	cfg.netboxCACertPath = v.GetString(flagNetboxCACertPath)
-	cfg.dualStackIP = v.GetBool(flagDualStackIP)
+	cfg.netboxOAuth = netbox.ClientCredentialsConfig{
+		TokenURL:     v.GetString(flagNetBoxOAuthTokenURL),
+		ClientID:     v.GetString(flagNetBoxOAuthClientID),
+		ClientSecret: v.GetString(flagNetBoxOAuthSecret),
+		Scopes:       sanitizedStringSlice(v.GetString(flagNetBoxOAuthScopes)),
+	}
+	cfg.netboxTLSVersion = v.GetString(flagNetBoxTLSMinVersion)
+	cfg.netboxTLSCiphers = sanitizedStringSlice(v.GetString(flagNetBoxTLSCiphers))
+	cfg.netboxTLSName = v.GetString(flagNetBoxTLSServerName)
+	cfg.redactFields = sanitizedStringSlice(v.GetString(flagRedactFields))
+	cfg.auditLogPath = v.GetString(flagAuditLogPath)
+	cfg.uidPrefix = v.GetString(flagUIDPrefix)
+	cfg.duplicateIPs = v.GetString(flagDuplicateIPStrategy)
+	cfg.adoptionPolicy = v.GetString(flagAdoptionPolicy)
+	cfg.ipamBackend = v.GetString(flagIPAMBackend)
+	cfg.phpipamAPIURL = v.GetString(flagPHPIPAMAPIURL)
+	cfg.phpipamAppID = v.GetString(flagPHPIPAMAppID)
+	cfg.phpipamToken = v.GetString(flagPHPIPAMToken)
+	cfg.infobloxAPIURL = v.GetString(flagInfobloxAPIURL)
+	cfg.infobloxUsername = v.GetString(flagInfobloxUsername)
+	cfg.infobloxPassword = v.GetString(flagInfobloxPassword)
+	cfg.infobloxView = v.GetString(flagInfobloxNetworkView)
+	cfg.netboxBodyLimit = v.GetInt(flagNetBoxLogBodies)
+	cfg.netboxLookupTTL = v.GetDuration(flagNetBoxLookupTTL)
+	cfg.netboxTimeout = v.GetDuration(flagNetBoxTimeout)
+	cfg.uidField = v.GetString(flagNetBoxUIDField)
+	cfg.previousUIDField = v.GetString(flagNetBoxPreviousUID)
+	cfg.skipUIDFieldMigration = v.GetBool(flagSkipUIDFieldMigrate)
+	cfg.phpipamSubnetIDs = nil
+	for _, id := range sanitizedStringSlice(v.GetString(flagPHPIPAMSubnetIDs)) {
+		subnetID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s value %q is invalid: %w", flagPHPIPAMSubnetIDs, id, err)
+		}
+		cfg.phpipamSubnetIDs = append(cfg.phpipamSubnetIDs, subnetID)
+	}
 
 	err = cfg.validate()
 	if err != nil {
@@ -186,13 +561,154 @@ func (cfg *globalConfig) setup(cmd *cobra.Command) error {
 	return nil
 }
 
+// isUIDField returns true if field is, or was, the custom field that UIDs are
+// stored in, and so must not be written to by other flags.
+func (cfg *globalConfig) isUIDField(field string) bool {
+	return field == netbox.UIDCustomFieldName || field == cfg.uidField || field == cfg.previousUIDField
+}
+
+// netboxOnlyFlag returns the name of a global flag that is set, but is only
+// supported with the NetBox backend, or "" if there is none.
+func (cfg *globalConfig) netboxOnlyFlag() string {
+	switch {
+	case cfg.uidPrefix != "":
+		return flagUIDPrefix
+	case cfg.auditLogPath != "":
+		return flagAuditLogPath
+	case cfg.duplicateIPs != "" && cfg.duplicateIPs != netbox.DuplicateStrategyFail:
+		return flagDuplicateIPStrategy
+	case cfg.adoptionPolicy != "" && cfg.adoptionPolicy != netbox.AdoptionPolicyDuplicate:
+		return flagAdoptionPolicy
+	case cfg.uidField != "" && cfg.uidField != netbox.UIDCustomFieldName:
+		return flagNetBoxUIDField
+	case cfg.previousUIDField != "":
+		return flagNetBoxPreviousUID
+	case cfg.skipUIDFieldMigration:
+		return flagSkipUIDFieldMigrate
+	case cfg.netboxCACertPath != "":
+		return flagNetboxCACertPath
+	case cfg.netboxTLSVersion != "":
+		return flagNetBoxTLSMinVersion
+	case len(cfg.netboxTLSCiphers) > 0:
+		return flagNetBoxTLSCiphers
+	case cfg.netboxTLSName != "":
+		return flagNetBoxTLSServerName
+	case cfg.netboxOAuth.TokenURL != "":
+		return flagNetBoxOAuthTokenURL
+	case len(cfg.redactFields) > 0:
+		return flagRedactFields
+	case cfg.netboxTimeout != 0 && cfg.netboxTimeout != netbox.DefaultRequestTimeout:
+		return flagNetBoxTimeout
+	}
+	return ""
+}
+
 func (cfg *globalConfig) validate() error {
+	switch cfg.ipamBackend {
+	case "", ipamBackendNetBox:
+	case ipamBackendPHPIPAM:
+		return cfg.validatePHPIPAM()
+	case ipamBackendInfoblox:
+		return cfg.validateInfoblox()
+	default:
+		return fmt.Errorf("%s value %q is invalid: must be %s, %s or %s", flagIPAMBackend, cfg.ipamBackend, ipamBackendNetBox, ipamBackendPHPIPAM, ipamBackendInfoblox)
+	}
+
 	if cfg.netboxAPIURL == "" {
 		return fmt.Errorf("%s was not provided", flagNetBoxAPIURL)
 	}
-	if cfg.netboxToken == "" {
+	if cfg.netboxOAuth.TokenURL != "" {
+		if cfg.netboxOAuth.ClientID == "" {
+			return fmt.Errorf("%s was not provided, but is required with %s", flagNetBoxOAuthClientID, flagNetBoxOAuthTokenURL)
+		}
+	} else if cfg.netboxToken == "" {
 		return fmt.Errorf("%s was not provided", flagNetBoxToken)
 	}
+	if err := cfg.validateRateLimit(); err != nil {
+		return err
+	}
+	if cfg.netboxTLSVersion != "" {
+		if _, err := netbox.TLSVersion(cfg.netboxTLSVersion); err != nil {
+			return fmt.Errorf("%s value is invalid: %w", flagNetBoxTLSMinVersion, err)
+		}
+	}
+	if cfg.uidPrefix != "" && !uidPrefixRegexp.MatchString(cfg.uidPrefix) {
+		return fmt.Errorf("%s value %q is invalid: may only contain letters, digits, dashes, underscores and dots", flagUIDPrefix, cfg.uidPrefix)
+	}
+	if _, err := netbox.CipherSuites(cfg.netboxTLSCiphers); err != nil {
+		return fmt.Errorf("%s value is invalid: %w", flagNetBoxTLSCiphers, err)
+	}
+	if cfg.netboxBodyLimit < 0 {
+		return fmt.Errorf("%s value %d is invalid: must not be negative", flagNetBoxLogBodies, cfg.netboxBodyLimit)
+	}
+	if cfg.netboxLookupTTL < 0 {
+		return fmt.Errorf("%s value %s is invalid: must not be negative", flagNetBoxLookupTTL, cfg.netboxLookupTTL)
+	}
+	if cfg.netboxTimeout < 0 {
+		return fmt.Errorf("%s value %s is invalid: must not be negative", flagNetBoxTimeout, cfg.netboxTimeout)
+	}
+	if cfg.uidField != "" && !customFieldRegexp.MatchString(cfg.uidField) {
+		return fmt.Errorf("%s value %q is invalid: must be the name of a custom field", flagNetBoxUIDField, cfg.uidField)
+	}
+	if cfg.previousUIDField != "" {
+		if !customFieldRegexp.MatchString(cfg.previousUIDField) {
+			return fmt.Errorf("%s value %q is invalid: must be the name of a custom field", flagNetBoxPreviousUID, cfg.previousUIDField)
+		}
+		if cfg.previousUIDField == cfg.uidField || (cfg.uidField == "" && cfg.previousUIDField == netbox.UIDCustomFieldName) {
+			return fmt.Errorf("%s value %q is invalid: must differ from %s", flagNetBoxPreviousUID, cfg.previousUIDField, flagNetBoxUIDField)
+		}
+	}
+	switch cfg.duplicateIPs {
+	case "", netbox.DuplicateStrategyFail, netbox.DuplicateStrategyAdoptOldest, netbox.DuplicateStrategyMerge:
+	default:
+		return fmt.Errorf("%s value %q is invalid: must be %s, %s or %s", flagDuplicateIPStrategy, cfg.duplicateIPs,
+			netbox.DuplicateStrategyFail, netbox.DuplicateStrategyAdoptOldest, netbox.DuplicateStrategyMerge)
+	}
+	switch cfg.adoptionPolicy {
+	case "", netbox.AdoptionPolicyDuplicate, netbox.AdoptionPolicySkip, netbox.AdoptionPolicyAdopt, netbox.AdoptionPolicyAdoptMatching:
+	default:
+		return fmt.Errorf("%s value %q is invalid: must be %s, %s, %s or %s", flagAdoptionPolicy, cfg.adoptionPolicy,
+			netbox.AdoptionPolicyDuplicate, netbox.AdoptionPolicySkip, netbox.AdoptionPolicyAdopt, netbox.AdoptionPolicyAdoptMatching)
+	}
+	return nil
+}
+
+func (cfg *globalConfig) validatePHPIPAM() error {
+	if cfg.phpipamAPIURL == "" {
+		return fmt.Errorf("%s was not provided, but is required with the %s backend", flagPHPIPAMAPIURL, ipamBackendPHPIPAM)
+	}
+	if cfg.phpipamAppID == "" {
+		return fmt.Errorf("%s was not provided, but is required with the %s backend", flagPHPIPAMAppID, ipamBackendPHPIPAM)
+	}
+	if cfg.phpipamToken == "" {
+		return fmt.Errorf("%s was not provided, but is required with the %s backend", flagPHPIPAMToken, ipamBackendPHPIPAM)
+	}
+	if len(cfg.phpipamSubnetIDs) == 0 {
+		return fmt.Errorf("%s was not provided, but is required with the %s backend", flagPHPIPAMSubnetIDs, ipamBackendPHPIPAM)
+	}
+	if flag := cfg.netboxOnlyFlag(); flag != "" {
+		return fmt.Errorf("%s is not supported with the %s backend", flag, ipamBackendPHPIPAM)
+	}
+	return cfg.validateRateLimit()
+}
+
+func (cfg *globalConfig) validateInfoblox() error {
+	if cfg.infobloxAPIURL == "" {
+		return fmt.Errorf("%s was not provided, but is required with the %s backend", flagInfobloxAPIURL, ipamBackendInfoblox)
+	}
+	if cfg.infobloxUsername == "" {
+		return fmt.Errorf("%s was not provided, but is required with the %s backend", flagInfobloxUsername, ipamBackendInfoblox)
+	}
+	if cfg.infobloxPassword == "" {
+		return fmt.Errorf("%s was not provided, but is required with the %s backend", flagInfobloxPassword, ipamBackendInfoblox)
+	}
+	if flag := cfg.netboxOnlyFlag(); flag != "" {
+		return fmt.Errorf("%s is not supported with the %s backend", flag, ipamBackendInfoblox)
+	}
+	return cfg.validateRateLimit()
+}
+
+func (cfg *globalConfig) validateRateLimit() error {
 	if cfg.netboxQPS <= 0 {
 		return fmt.Errorf("%s value %f is invalid: must be greater than 0", flagNetBoxQPS, cfg.netboxQPS)
 	}
@@ -220,6 +736,161 @@ func kubeConfig(kubeconfigFile string) (*rest.Config, error) {
 	return rc, nil
 }
 
+// netboxClientDeps are the dependencies that may be shared by several
+// clients of the IPAM backend, e.g. the clients of different tenants.
+type netboxClientDeps struct {
+	// limiter limits the requests of the clients, and its limits may be changed at runtime
+	limiter *rate.Limiter
+	// caPool, if not nil, is used instead of loading the configured CA certificates
+	caPool *netbox.CAPool
+	// auditSink, if not nil, records the changes made by the clients
+	auditSink netbox.AuditSink
+	// allowedPrefixes, if not empty, are the only ranges in which the
+	// clients may create, update, release, deprecate or delete IPs
+	allowedPrefixes []netip.Prefix
+	// deletionPolicy, if set, is what the clients do with merged duplicates
+	deletionPolicy string
+}
+
+// newNetBoxClientDeps creates the dependencies of clients configured with the global flags.
+// The returned function flushes and closes the audit log, and must be called once the
+// clients are no longer used.
+func newNetBoxClientDeps(cfg *globalConfig) (netboxClientDeps, func(), error) {
+	deps := netboxClientDeps{
+		limiter: rate.NewLimiter(cfg.netboxQPS, cfg.netboxBurst),
+	}
+	closeAudit := func() {}
+	if cfg.auditLogPath != "" {
+		w := os.Stdout
+		if cfg.auditLogPath != "-" {
+			f, err := os.OpenFile(cfg.auditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+			if err != nil {
+				return deps, closeAudit, fmt.Errorf("opening audit log: %w", err)
+			}
+			w = f
+			closeAudit = func() {
+				if err := f.Sync(); err != nil {
+					cfg.logger.Error("failed to sync audit log", log.Error(err))
+				}
+				if err := f.Close(); err != nil {
+					cfg.logger.Error("failed to close audit log", log.Error(err))
+				}
+			}
+		}
+		deps.auditSink = netbox.NewJSONAuditSink(w)
+	}
+	return deps, closeAudit, nil
+}
+
+// newNetBoxClient creates a client of the IPAM backend configured with the global flags,
+// which refuses to write IPs outside of the allowed prefixes, if there are any.
+// The returned function closes the audit log, like the one returned by newNetBoxClientDeps.
+func newNetBoxClient(cfg *globalConfig, allowedPrefixes []netip.Prefix) (netbox.Client, func(), error) {
+	deps, closeAudit, err := newNetBoxClientDeps(cfg)
+	if err != nil {
+		return nil, closeAudit, err
+	}
+	deps.allowedPrefixes = allowedPrefixes
+	client, err := newNetBoxClientWithDeps(cfg, deps)
+	if err != nil {
+		closeAudit()
+		return nil, func() {}, err
+	}
+	return client, closeAudit, nil
+}
+
+// newNetBoxClientWithDeps is like newNetBoxClient, but the client uses the given dependencies.
+func newNetBoxClientWithDeps(cfg *globalConfig, deps netboxClientDeps) (netbox.Client, error) {
+	limiter := deps.limiter
+	switch cfg.ipamBackend {
+	case ipamBackendPHPIPAM:
+		if len(deps.allowedPrefixes) > 0 {
+			return nil, fmt.Errorf("%s is not supported with the %s backend", flagAllowedPrefixes, ipamBackendPHPIPAM)
+		}
+		return phpipam.NewClient(cfg.phpipamAPIURL, cfg.phpipamAppID, cfg.phpipamToken, cfg.phpipamSubnetIDs,
+			phpipam.WithSharedRateLimiter(limiter),
+			phpipam.WithLogger(cfg.logger),
+		)
+	case ipamBackendInfoblox:
+		if len(deps.allowedPrefixes) > 0 {
+			return nil, fmt.Errorf("%s is not supported with the %s backend", flagAllowedPrefixes, ipamBackendInfoblox)
+		}
+		return infoblox.NewClient(cfg.infobloxAPIURL, cfg.infobloxUsername, cfg.infobloxPassword,
+			infoblox.WithSharedRateLimiter(limiter),
+			infoblox.WithLogger(cfg.logger),
+			infoblox.WithNetworkView(cfg.infobloxView),
+		)
+	}
+
+	clientOpts := []netbox.ClientOption{
+		netbox.WithSharedRateLimiter(limiter),
+		netbox.WithLogger(cfg.logger),
+		netbox.WithSensitiveFields(cfg.redactFields...),
+		netbox.WithLookupCacheTTL(cfg.netboxLookupTTL),
+		netbox.WithRequestTimeout(cfg.netboxTimeout),
+	}
+	if cfg.uidField != "" {
+		clientOpts = append(clientOpts, netbox.WithUIDFieldName(cfg.uidField))
+	}
+	if cfg.previousUIDField != "" {
+		clientOpts = append(clientOpts, netbox.WithPreviousUIDFieldName(cfg.previousUIDField))
+	}
+	if cfg.skipUIDFieldMigration {
+		clientOpts = append(clientOpts, netbox.WithoutUIDFieldMigration())
+	}
+	if deps.caPool != nil {
+		clientOpts = append(clientOpts, netbox.WithCAPool(deps.caPool))
+	} else if cfg.netboxCACertPath != "" {
+		clientOpts = append(clientOpts, netbox.WithCARootCert(cfg.netboxCACertPath))
+	}
+	if cfg.netboxTLSVersion != "" {
+		version, err := netbox.TLSVersion(cfg.netboxTLSVersion)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts = append(clientOpts, netbox.WithTLSMinVersion(version))
+	}
+	if len(cfg.netboxTLSCiphers) > 0 {
+		ciphers, err := netbox.CipherSuites(cfg.netboxTLSCiphers)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts = append(clientOpts, netbox.WithTLSCipherSuites(ciphers))
+	}
+	if cfg.netboxTLSName != "" {
+		clientOpts = append(clientOpts, netbox.WithTLSServerName(cfg.netboxTLSName))
+	}
+	if cfg.uidPrefix != "" {
+		clientOpts = append(clientOpts, netbox.WithUIDPrefix(cfg.uidPrefix))
+	}
+	if cfg.duplicateIPs != "" {
+		clientOpts = append(clientOpts, netbox.WithDuplicateStrategy(cfg.duplicateIPs))
+	}
+	if cfg.adoptionPolicy != "" {
+		clientOpts = append(clientOpts, netbox.WithAdoptionPolicy(cfg.adoptionPolicy))
+	}
+	if cfg.netboxBodyLimit > 0 {
+		clientOpts = append(clientOpts, netbox.WithBodyLogging(cfg.netboxBodyLimit))
+	}
+	if deps.auditSink != nil {
+		clientOpts = append(clientOpts, netbox.WithAuditSink(deps.auditSink))
+	}
+	if len(deps.allowedPrefixes) > 0 {
+		clientOpts = append(clientOpts, netbox.WithAllowedPrefixes(deps.allowedPrefixes))
+	}
+	if deps.deletionPolicy != "" {
+		clientOpts = append(clientOpts, netbox.WithDeletionPolicy(deps.deletionPolicy))
+	}
+	if cfg.netboxOAuth.TokenURL != "" {
+		auth, err := netbox.NewClientCredentialsAuth(cfg.netboxOAuth)
+		if err != nil {
+			return nil, fmt.Errorf("setting up OAuth2 authentication: %w", err)
+		}
+		clientOpts = append(clientOpts, netbox.WithAuthProvider(auth))
+	}
+	return netbox.NewClient(cfg.netboxAPIURL, cfg.netboxToken, clientOpts...)
+}
+
 func (cfg *rootConfig) setup(cmd *cobra.Command) error {
 	v := viper.New()
 	v.AutomaticEnv()
@@ -230,11 +901,78 @@ func (cfg *rootConfig) setup(cmd *cobra.Command) error {
 	}
 
 	cfg.metricsAddr = v.GetString(flagMetricsAddr)
+	cfg.metricsTLSCert = v.GetString(flagMetricsTLSCertPath)
+	cfg.metricsTLSKey = v.GetString(flagMetricsTLSKeyPath)
+	cfg.metricsClientCA = v.GetString(flagMetricsClientCAPath)
 	cfg.clusterDomain = v.GetString(flagClusterDomain)
+	cfg.publishIPv4 = v.GetBool(flagPublishIPv4)
+	cfg.publishIPv6 = v.GetBool(flagPublishIPv6)
 	cfg.readyCheckAddr = v.GetString(flagReadyCheckAddr)
+	cfg.skipCRDRegistration = v.GetBool(flagSkipCRDRegistration)
+	cfg.crdCategoryAll = v.GetBool(flagCRDCategoryAll)
+	cfg.crdImmutableAddress = v.GetBool(flagCRDImmutableAddress)
+	cfg.tenantMappingPath = v.GetString(flagTenantMappingPath)
+	cfg.clusterTag = strings.TrimSpace(v.GetString(flagClusterTag))
+	cfg.webhookURL = v.GetString(flagWebhookURL)
+	cfg.webhookTimeout = v.GetDuration(flagWebhookTimeout)
+	cfg.eventDedupWindow = v.GetDuration(flagEventDedupWindow)
+	cfg.slowReconcile = v.GetDuration(flagSlowReconcile)
+	cfg.tagRefreshInterval = v.GetDuration(flagTagRefreshInterval)
+	cfg.shardIndex = v.GetInt(flagShardIndex)
+	cfg.shardCount = v.GetInt(flagShardCount)
+	cfg.serviceIPPriority = v.GetBool(flagServiceIPPriority)
+	cfg.queueMaxSize = v.GetInt(flagQueueMaxSize)
+	cfg.queueOverflowPolicy = v.GetString(flagQueueOverflowPolicy)
+	cfg.queueParkDelay = v.GetDuration(flagQueueParkDelay)
+	cfg.dnsEndpoints = v.GetBool(flagDNSEndpoints)
+	cfg.netboxWebhookAddr = v.GetString(flagNetBoxWebhookAddr)
+	cfg.netboxWebhookSecret = v.GetString(flagNetBoxWebhookSecret)
+	cfg.netboxIPMetricsLimit = v.GetInt(flagNetBoxIPMetricsLimit)
+	cfg.nsMetricsLimit = v.GetInt(flagNSMetricsLimit)
+	cfg.nsPrefixLength = v.GetInt(flagNSPrefixLength)
+	cfg.controllerConfig = v.GetString(flagControllerConfig)
+	cfg.ipClaims = v.GetBool(flagIPClaims)
+	cfg.deletionPolicy = v.GetString(flagDeletionPolicy)
+	cfg.completedPodIPTTL = v.GetDuration(flagCompletedPodIPTTL)
+	cfg.deletionDebounce = v.GetDuration(flagDeletionDebounce)
+	cfg.podOmitDNSName = v.GetBool(flagPodOmitDNSName)
+	cfg.serviceOmitDNSName = v.GetBool(flagServiceOmitDNSName)
+	cfg.descriptionStrategy = v.GetString(flagDescriptionStrategy)
+	cfg.requeueInterval = v.GetDuration(flagRequeueInterval)
+	cfg.podExcludeOwnerKinds = sanitizedStringSlice(v.GetString(flagPodExcludeOwnerKinds))
+	cfg.podSkipStaticPods = v.GetBool(flagPodSkipStaticPods)
+	cfg.podOwnerKinds = sanitizedStringSlice(v.GetString(flagPodOwnerKinds))
+	cfg.podRequireReady = v.GetBool(flagPodRequireReady)
+	cfg.podNotReadyGrace = v.GetDuration(flagPodNotReadyGrace)
+	cfg.podJobPolicy = v.GetString(flagPodJobPolicy)
+	cfg.podJobTag = strings.TrimSpace(v.GetString(flagPodJobTag))
+	cfg.annotationOverrides = v.GetBool(flagAnnotationOverrides)
+	cfg.sharedAddresses = v.GetBool(flagSharedAddresses)
+	cfg.podMACAddresses = v.GetBool(flagPodMACAddresses)
+	cfg.nodeDeviceMatch = strings.TrimSpace(v.GetString(flagNodeDeviceMatch))
+	cfg.nodeInterface = strings.TrimSpace(v.GetString(flagNodeInterface))
+	cfg.serviceLBIPs = v.GetBool(flagServiceLBIPs)
+	cfg.serviceLBNAT = v.GetBool(flagServiceLBNAT)
+	cfg.serviceLBIPAnnot = strings.TrimSpace(v.GetString(flagServiceLBIPAnnot))
+	cfg.serviceLBResolve = v.GetBool(flagServiceLBResolve)
+	cfg.serviceLBRefresh = v.GetDuration(flagServiceLBRefresh)
+	cfg.serviceNodePorts = v.GetBool(flagServiceNodePorts)
+	cfg.serviceExtNames = v.GetBool(flagServiceExtNames)
+	cfg.serviceExtRefresh = v.GetDuration(flagServiceExtRefresh)
+	cfg.serviceNSDomains = v.GetBool(flagServiceNSDomains)
+	cfg.serviceVIPs = v.GetBool(flagServiceVIPs)
+	cfg.serviceVIPTag = strings.TrimSpace(v.GetString(flagServiceVIPTag))
 
 	cfg.podTags = sanitizedStringSlice(v.GetString(flagPodIPTags))
 	cfg.serviceTags = sanitizedStringSlice(v.GetString(flagServiceIPTags))
+	cfg.serviceClusterIPTags = sanitizedStringSlice(v.GetString(flagServiceClusterIPTags))
+	cfg.serviceLBIPTags = sanitizedStringSlice(v.GetString(flagServiceLBIPTags))
+	cfg.serviceSelectorField = strings.TrimSpace(v.GetString(flagServiceSelectorField))
+	cfg.servicePortsField = strings.TrimSpace(v.GetString(flagServicePortsField))
+	if cfg.clusterTag != "" {
+		cfg.podTags = append(cfg.podTags, cfg.clusterTag)
+		cfg.serviceTags = append(cfg.serviceTags, cfg.clusterTag)
+	}
 
 	cfg.podLabels = make(map[string]bool)
 	for _, l := range sanitizedStringSlice(v.GetString(flagPodPublishLabels)) {
@@ -245,7 +983,63 @@ func (cfg *rootConfig) setup(cmd *cobra.Command) error {
 		cfg.serviceLabels[l] = true
 	}
 
-	err := cfg.validate()
+	for _, f := range sanitizedStringSlice(v.GetString(flagPodCustomFields)) {
+		field, annotation, ok := strings.Cut(f, "=")
+		if !ok {
+			return fmt.Errorf("%s value %q is invalid: must be <custom field>=<annotation>", flagPodCustomFields, f)
+		}
+		if cfg.podCustomFields == nil {
+			cfg.podCustomFields = make(map[string]string)
+		}
+		cfg.podCustomFields[strings.TrimSpace(field)] = strings.TrimSpace(annotation)
+	}
+
+	for _, l := range sanitizedStringSlice(v.GetString(flagControllerRateLimits)) {
+		name, limit, ok := strings.Cut(l, "=")
+		qps, burst, ok2 := strings.Cut(limit, ":")
+		if !ok || !ok2 {
+			return fmt.Errorf("%s value %q is invalid: must be <controller>=<qps>:<burst>", flagControllerRateLimits, l)
+		}
+		var budget rateBudget
+		q, err := strconv.ParseFloat(strings.TrimSpace(qps), 64)
+		if err != nil {
+			return fmt.Errorf("%s value %q is invalid: %w", flagControllerRateLimits, l, err)
+		}
+		budget.qps = rate.Limit(q)
+		if budget.burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil {
+			return fmt.Errorf("%s value %q is invalid: %w", flagControllerRateLimits, l, err)
+		}
+		if cfg.rateBudgets == nil {
+			cfg.rateBudgets = make(map[string]rateBudget)
+		}
+		cfg.rateBudgets[strings.TrimSpace(name)] = budget
+	}
+
+	allowedPrefixes, err := parseAllowedPrefixes(v.GetString(flagAllowedPrefixes))
+	if err != nil {
+		return err
+	}
+	cfg.allowedPrefixes = allowedPrefixes
+
+	cfg.serviceLBIPPool = netip.Prefix{}
+	if pool := strings.TrimSpace(v.GetString(flagServiceLBIPPool)); pool != "" {
+		prefix, err := netip.ParsePrefix(pool)
+		if err != nil {
+			return fmt.Errorf("%s value %q is not a valid CIDR: %w", flagServiceLBIPPool, pool, err)
+		}
+		cfg.serviceLBIPPool = prefix.Masked()
+	}
+
+	cfg.nsPrefixParent = netip.Prefix{}
+	if parent := strings.TrimSpace(v.GetString(flagNSPrefixParent)); parent != "" {
+		prefix, err := netip.ParsePrefix(parent)
+		if err != nil {
+			return fmt.Errorf("%s value %q is not a valid CIDR: %w", flagNSPrefixParent, parent, err)
+		}
+		cfg.nsPrefixParent = prefix.Masked()
+	}
+
+	err = cfg.validate()
 	if err != nil {
 		return err
 	}
@@ -254,6 +1048,173 @@ func (cfg *rootConfig) setup(cmd *cobra.Command) error {
 }
 
 func (cfg *rootConfig) validate() error {
+	if cfg.clusterTag != "" && !tagRegexp.MatchString(cfg.clusterTag) {
+		return fmt.Errorf("%s value %q is invalid: may only contain letters, digits, dashes and underscores", flagClusterTag, cfg.clusterTag)
+	}
+	if cfg.netboxIPMetricsLimit < 0 {
+		return fmt.Errorf("%s value %d is invalid: must not be negative", flagNetBoxIPMetricsLimit, cfg.netboxIPMetricsLimit)
+	}
+	if cfg.nsMetricsLimit < 0 {
+		return fmt.Errorf("%s value %d is invalid: must not be negative", flagNSMetricsLimit, cfg.nsMetricsLimit)
+	}
+	if cfg.netboxWebhookAddr != "" && cfg.netboxWebhookSecret == "" {
+		return fmt.Errorf("%s was not provided, but is required with %s", flagNetBoxWebhookSecret, flagNetBoxWebhookAddr)
+	}
+	if cfg.metricsTLSCert != "" && cfg.metricsTLSKey == "" {
+		return fmt.Errorf("%s was not provided, but is required with %s", flagMetricsTLSKeyPath, flagMetricsTLSCertPath)
+	}
+	if cfg.metricsTLSCert == "" && (cfg.metricsTLSKey != "" || cfg.metricsClientCA != "") {
+		return fmt.Errorf("%s was not provided, but is required with %s and %s", flagMetricsTLSCertPath, flagMetricsTLSKeyPath, flagMetricsClientCAPath)
+	}
+	if cfg.completedPodIPTTL < 0 {
+		return fmt.Errorf("%s value %s is invalid: must not be negative", flagCompletedPodIPTTL, cfg.completedPodIPTTL)
+	}
+	if cfg.deletionDebounce < 0 {
+		return fmt.Errorf("%s value %s is invalid: must not be negative", flagDeletionDebounce, cfg.deletionDebounce)
+	}
+	if cfg.eventDedupWindow < 0 {
+		return fmt.Errorf("%s value %s is invalid: must not be negative", flagEventDedupWindow, cfg.eventDedupWindow)
+	}
+	if cfg.slowReconcile < 0 {
+		return fmt.Errorf("%s value %s is invalid: must not be negative", flagSlowReconcile, cfg.slowReconcile)
+	}
+	if cfg.tagRefreshInterval < 0 {
+		return fmt.Errorf("%s value %s is invalid: must not be negative", flagTagRefreshInterval, cfg.tagRefreshInterval)
+	}
+	if cfg.shardCount < 1 {
+		return fmt.Errorf("%s value %d is invalid: must be at least 1", flagShardCount, cfg.shardCount)
+	}
+	if cfg.shardIndex < 0 || cfg.shardIndex >= cfg.shardCount {
+		return fmt.Errorf("%s value %d is invalid: must be between 0 and %s - 1", flagShardIndex, cfg.shardIndex, flagShardCount)
+	}
+	if cfg.shardCount > 1 && cfg.sharedAddresses {
+		// NetBoxIPs sharing an address may be in namespaces of different shards
+		return fmt.Errorf("%s is not supported with %s greater than 1", flagSharedAddresses, flagShardCount)
+	}
+	if cfg.serviceIPPriority && cfg.sharedAddresses {
+		// NetBoxIPs of pods and services sharing an address
+		// must not be reconciled concurrently
+		return fmt.Errorf("%s is not supported with %s", flagSharedAddresses, flagServiceIPPriority)
+	}
+	if cfg.shardCount > 1 && cfg.netboxWebhookAddr != "" {
+		// a NetBox webhook is delivered to a single replica
+		return fmt.Errorf("%s is not supported with %s greater than 1", flagNetBoxWebhookAddr, flagShardCount)
+	}
+	for _, kind := range cfg.podExcludeOwnerKinds {
+		if !kindRegexp.MatchString(kind) {
+			return fmt.Errorf("%s value %q is invalid: must be a kind, e.g. DaemonSet", flagPodExcludeOwnerKinds, kind)
+		}
+	}
+	for name, budget := range cfg.rateBudgets {
+		known := false
+		for _, controller := range rateBudgetControllers {
+			known = known || controller == name
+		}
+		if !known {
+			return fmt.Errorf("%s value %q is invalid: must be one of %s", flagControllerRateLimits, name, strings.Join(rateBudgetControllers, ", "))
+		}
+		if budget.qps <= 0 || budget.burst < 1 {
+			return fmt.Errorf("%s value for %s is invalid: qps must be greater than 0 and burst at least 1", flagControllerRateLimits, name)
+		}
+		if name == netboxipctrl.ServiceControllerName && !cfg.serviceIPPriority {
+			return fmt.Errorf("%s value for %s requires %s", flagControllerRateLimits, name, flagServiceIPPriority)
+		}
+	}
+	for field, annotation := range cfg.podCustomFields {
+		if !customFieldRegexp.MatchString(field) || globalCfg.isUIDField(field) {
+			return fmt.Errorf("%s value %q is invalid: must be the name of a custom field", flagPodCustomFields, field)
+		}
+		if err := validateLabel(annotation); err != nil {
+			return fmt.Errorf("%s value %q is not a valid kubernetes annotation: %w", flagPodCustomFields, annotation, err)
+		}
+	}
+	for flag, field := range map[string]string{flagServiceSelectorField: cfg.serviceSelectorField, flagServicePortsField: cfg.servicePortsField} {
+		if field != "" && (!customFieldRegexp.MatchString(field) || globalCfg.isUIDField(field)) {
+			return fmt.Errorf("%s value %q is invalid: must be the name of a custom field", flag, field)
+		}
+	}
+	for _, kind := range cfg.podOwnerKinds {
+		if !kindRegexp.MatchString(kind) {
+			return fmt.Errorf("%s value %q is invalid: must be a kind, e.g. StatefulSet", flagPodOwnerKinds, kind)
+		}
+	}
+	switch cfg.podJobPolicy {
+	case ctrl.JobPolicyPublish, ctrl.JobPolicySkip:
+	case ctrl.JobPolicyTag:
+		if !tagRegexp.MatchString(cfg.podJobTag) {
+			return fmt.Errorf("%s value %q is invalid: must be a valid NetBox tag", flagPodJobTag, cfg.podJobTag)
+		}
+	default:
+		return fmt.Errorf("%s value %q is invalid: must be %s, %s or %s", flagPodJobPolicy, cfg.podJobPolicy, ctrl.JobPolicyPublish, ctrl.JobPolicySkip, ctrl.JobPolicyTag)
+	}
+	switch {
+	case cfg.nodeDeviceMatch == ctrl.NodeDeviceMatchName, cfg.nodeDeviceMatch == ctrl.NodeDeviceMatchProviderID, cfg.nodeDeviceMatch == ctrl.NodeDeviceMatchSerial:
+	case strings.HasPrefix(cfg.nodeDeviceMatch, ctrl.NodeDeviceMatchLabelPrefix) && cfg.nodeDeviceMatch != ctrl.NodeDeviceMatchLabelPrefix:
+	default:
+		return fmt.Errorf("%s value %q is invalid: must be %s, %s, %s or %s<key>", flagNodeDeviceMatch, cfg.nodeDeviceMatch,
+			ctrl.NodeDeviceMatchName, ctrl.NodeDeviceMatchProviderID, ctrl.NodeDeviceMatchSerial, ctrl.NodeDeviceMatchLabelPrefix)
+	}
+	if len(cfg.nodeInterface) > 64 {
+		return fmt.Errorf("%s value %q is invalid: must be at most 64 characters long", flagNodeInterface, cfg.nodeInterface)
+	}
+	if cfg.serviceVIPs && !tagRegexp.MatchString(cfg.serviceVIPTag) {
+		return fmt.Errorf("%s value %q is invalid: must be a valid NetBox tag", flagServiceVIPTag, cfg.serviceVIPTag)
+	}
+	if !cfg.publishIPv4 && !cfg.publishIPv6 {
+		return fmt.Errorf("%s and %s must not both be false", flagPublishIPv4, flagPublishIPv6)
+	}
+	if cfg.podNotReadyGrace < 0 {
+		return fmt.Errorf("%s value %s is invalid: must not be negative", flagPodNotReadyGrace, cfg.podNotReadyGrace)
+	}
+	if cfg.serviceLBNAT && !cfg.serviceLBIPs && !cfg.serviceVIPs {
+		return fmt.Errorf("%s or %s is required with %s", flagServiceLBIPs, flagServiceVIPs, flagServiceLBNAT)
+	}
+	if cfg.serviceLBIPPool.IsValid() {
+		if !cfg.serviceVIPs {
+			return fmt.Errorf("%s was not provided, but is required with %s", flagServiceVIPs, flagServiceLBIPPool)
+		}
+		valid := false
+		for _, annotation := range ctrl.VIPAnnotations {
+			if annotation == cfg.serviceLBIPAnnot {
+				valid = true
+			}
+		}
+		if !valid {
+			return fmt.Errorf("%s value %q is invalid: must be %s", flagServiceLBIPAnnot, cfg.serviceLBIPAnnot, strings.Join(ctrl.VIPAnnotations, " or "))
+		}
+	}
+	if cfg.serviceLBResolve {
+		if !cfg.serviceLBIPs {
+			return fmt.Errorf("%s was not provided, but is required with %s", flagServiceLBIPs, flagServiceLBResolve)
+		}
+		if cfg.serviceLBRefresh <= 0 {
+			return fmt.Errorf("%s value %s is invalid: must be greater than 0", flagServiceLBRefresh, cfg.serviceLBRefresh)
+		}
+	}
+	if cfg.nsPrefixParent.IsValid() && (cfg.nsPrefixLength <= cfg.nsPrefixParent.Bits() || cfg.nsPrefixLength > cfg.nsPrefixParent.Addr().BitLen()) {
+		return fmt.Errorf("%s value %d is invalid: must be longer than /%d of %s", flagNSPrefixLength, cfg.nsPrefixLength, cfg.nsPrefixParent.Bits(), flagNSPrefixParent)
+	}
+	if cfg.requeueInterval < 0 {
+		return fmt.Errorf("%s value %s is invalid: must not be negative", flagRequeueInterval, cfg.requeueInterval)
+	}
+	switch cfg.deletionPolicy {
+	case ctrl.DeletionPolicyDelete, ctrl.DeletionPolicyRetain, ctrl.DeletionPolicyDeprecate:
+	default:
+		return fmt.Errorf("%s value %q is invalid: must be %s, %s or %s", flagDeletionPolicy, cfg.deletionPolicy, ctrl.DeletionPolicyDelete, ctrl.DeletionPolicyRetain, ctrl.DeletionPolicyDeprecate)
+	}
+	switch cfg.descriptionStrategy {
+	case ctrl.DescriptionStrategyTruncate, ctrl.DescriptionStrategyDropLabels, ctrl.DescriptionStrategyHashSuffix:
+	default:
+		return fmt.Errorf("%s value %q is invalid: must be %s, %s or %s", flagDescriptionStrategy, cfg.descriptionStrategy, ctrl.DescriptionStrategyTruncate, ctrl.DescriptionStrategyDropLabels, ctrl.DescriptionStrategyHashSuffix)
+	}
+	if cfg.controllerConfig != "" {
+		if errs := validation.IsDNS1123Subdomain(cfg.controllerConfig); errs != nil {
+			return fmt.Errorf("%s value %q is not a valid resource name: %v", flagControllerConfig, cfg.controllerConfig, errs)
+		}
+	}
+	if cfg.webhookURL != "" && cfg.webhookTimeout <= 0 {
+		return fmt.Errorf("%s value %s is invalid: must be greater than 0", flagWebhookTimeout, cfg.webhookTimeout)
+	}
 	for l := range cfg.serviceLabels {
 		err := validateLabel(l)
 		if err != nil {
@@ -288,6 +1249,19 @@ func sanitizedStringSlice(s string) []string {
 	return sanitized
 }
 
+// parseAllowedPrefixes parses the value of the allowed prefixes flag.
+func parseAllowedPrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, p := range sanitizedStringSlice(s) {
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s value %q is not a valid CIDR: %w", flagAllowedPrefixes, p, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
 // validateLabel returns a nil error if s is a valid kubernetes label value,
 // else it returns an error containing the reason(s) it is not valid
 func validateLabel(s string) error {
@@ -298,29 +1272,109 @@ func validateLabel(s string) error {
 	return nil
 }
 
+// metricsTLSOptions returns the TLS options of the metrics server, which
+// serves the certificate of certWatcher, and requires client certificates
+// signed by the root certificates in clientCAPath, if it is not empty.
+func metricsTLSOptions(certWatcher *certwatcher.CertWatcher, clientCAPath string) ([]func(*tls.Config), error) {
+	var clientCAs *x509.CertPool
+	if clientCAPath != "" {
+		pem, err := os.ReadFile(clientCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading metrics client CA certificates: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s value %q is invalid: no PEM-encoded certificates found", flagMetricsClientCAPath, clientCAPath)
+		}
+	}
+
+	return []func(*tls.Config){func(c *tls.Config) {
+		c.GetCertificate = certWatcher.GetCertificate
+		if clientCAs != nil {
+			c.ClientCAs = clientCAs
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}}, nil
+}
+
 func run(ctx context.Context, globalCfg *globalConfig, cfg *rootConfig) error {
 	logger := globalCfg.logger
 	defer logger.Sync()
 
-	clientOpts := []netbox.ClientOption{
-		netbox.WithRateLimiter(globalCfg.netboxQPS, globalCfg.netboxBurst),
-		netbox.WithLogger(logger),
-	}
-	if globalCfg.netboxCACertPath != "" {
-		clientOpts = append(clientOpts, netbox.WithCARootCert(globalCfg.netboxCACertPath))
-	}
-	netboxClient, err := netbox.NewClient(globalCfg.netboxAPIURL, globalCfg.netboxToken, clientOpts...)
+	// all NetBox clients share a limiter, whose limits can be changed
+	// with the controller config, an audit log, and CA certificates
+	deps, closeAudit, err := newNetBoxClientDeps(globalCfg)
 	if err != nil {
 		return err
 	}
+	defer closeAudit()
+	deps.allowedPrefixes = cfg.allowedPrefixes
+	deps.deletionPolicy = cfg.deletionPolicy
 
-	crdClient, err := crdregistration.NewClient(globalCfg.kubeConfig)
+	// the CA certificates are reloaded for as long as the controller runs
+	if globalCfg.netboxCACertPath != "" && (globalCfg.ipamBackend == "" || globalCfg.ipamBackend == ipamBackendNetBox) {
+		pool, err := netbox.NewCAPool(globalCfg.netboxCACertPath, logger)
+		if err != nil {
+			return err
+		}
+		if err := pool.Watch(ctx); err != nil {
+			return err
+		}
+		deps.caPool = pool
+	}
+
+	netboxClient, err := newNetBoxClientWithDeps(globalCfg, deps)
 	if err != nil {
 		return err
 	}
 
-	if err := crdClient.Register(ctx, crd.NetBoxIPCRD); err != nil {
-		return err
+	metrics.EnableNetBoxIPMetrics(cfg.netboxIPMetricsLimit)
+	metrics.SetNamespaceLimit(cfg.nsMetricsLimit)
+
+	var tenantMapping *ctrl.TenantMapping
+	tenantClients := make(map[string]netbox.Client)
+	if cfg.tenantMappingPath != "" {
+		if globalCfg.ipamBackend != ipamBackendNetBox {
+			return fmt.Errorf("%s is not supported with the %s backend", flagTenantMappingPath, globalCfg.ipamBackend)
+		}
+		if tenantMapping, err = ctrl.LoadTenantMapping(cfg.tenantMappingPath); err != nil {
+			return err
+		}
+		tokens, err := tenantMapping.Tokens()
+		if err != nil {
+			return err
+		}
+		for tenant, token := range tokens {
+			tenantCfg := *globalCfg
+			tenantCfg.netboxToken = token
+			tenantCfg.netboxOAuth = netbox.ClientCredentialsConfig{}
+			if tenantClients[tenant], err = newNetBoxClientWithDeps(&tenantCfg, deps); err != nil {
+				return fmt.Errorf("creating NetBox client for tenant %s: %w", tenant, err)
+			}
+		}
+	}
+
+	if cfg.skipCRDRegistration {
+		logger.Info("skipping CRD registration")
+	} else {
+		crdClient, err := crdregistration.NewClient(globalCfg.kubeConfig)
+		if err != nil {
+			return err
+		}
+
+		if err := crdClient.Register(ctx, netboxIPCRD(cfg.crdCategoryAll, cfg.crdImmutableAddress)); err != nil {
+			return err
+		}
+		if cfg.controllerConfig != "" {
+			if err := crdClient.Register(ctx, crd.NetBoxIPControllerConfigCRD); err != nil {
+				return err
+			}
+		}
+		if cfg.ipClaims {
+			if err := crdClient.Register(ctx, crd.NetBoxIPClaimCRD); err != nil {
+				return err
+			}
+		}
 	}
 
 	scheme := runtime.NewScheme()
@@ -331,20 +1385,43 @@ func run(ctx context.Context, globalCfg *globalConfig, cfg *rootConfig) error {
 		return err
 	}
 
+	metricsOpts := metricsserver.Options{
+		BindAddress: cfg.metricsAddr,
+	}
+	var certWatcher *certwatcher.CertWatcher
+	if cfg.metricsTLSCert != "" {
+		// the certificate is reloaded whenever it changes on disk
+		certWatcher, err = certwatcher.New(cfg.metricsTLSCert, cfg.metricsTLSKey)
+		if err != nil {
+			return fmt.Errorf("loading metrics certificate: %w", err)
+		}
+		tlsOpts, err := metricsTLSOptions(certWatcher, cfg.metricsClientCA)
+		if err != nil {
+			return err
+		}
+		metricsOpts.SecureServing = true
+		metricsOpts.TLSOpts = tlsOpts
+	}
+
 	mgr, err := manager.New(globalCfg.kubeConfig, manager.Options{
-		Scheme: scheme,
-		Logger: zapr.NewLogger(logger.Named("netbox-ip-controller")),
-		Metrics: metricsserver.Options{
-			BindAddress: cfg.metricsAddr,
-		},
+		Scheme:                 scheme,
+		Logger:                 zapr.NewLogger(logger.Named("netbox-ip-controller")),
+		Metrics:                metricsOpts,
 		HealthProbeBindAddress: cfg.readyCheckAddr,
 	})
-	client := mgr.GetClient()
+	// the time spent by reconciliations waiting for Kubernetes is recorded
+	client := timing.KubeClient(mgr.GetClient())
 
 	if err != nil {
 		return fmt.Errorf("unable to set up manager: %s", err)
 	}
 
+	if certWatcher != nil {
+		if err := mgr.Add(certWatcher); err != nil {
+			return fmt.Errorf("unable to watch metrics certificate: %s", err)
+		}
+	}
+
 	// The ready check endpoint always responds with ready and serves as a simple
 	// indicator of whether or not the controller manager has been started yet.
 	if err = mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
@@ -355,45 +1432,261 @@ func run(ctx context.Context, globalCfg *globalConfig, cfg *rootConfig) error {
 
 	controllers := make(map[string]ctrl.Controller)
 
-	netboxController, err := netboxipctrl.New(
+	recorder := mgr.GetEventRecorderFor("netbox-ip-controller")
+	if cfg.eventDedupWindow > 0 {
+		recorder = ctrl.NewDedupRecorder(recorder, cfg.eventDedupWindow)
+	}
+
+	// controllers with a rate budget of their own do
+	// not wait for the rate limiter shared by the others
+	rateBudgets := make(map[string]*rate.Limiter)
+	for name, budget := range cfg.rateBudgets {
+		rateBudgets[name] = rate.NewLimiter(budget.qps, budget.burst)
+	}
+
+	// all controllers share the tags they publish IPs with,
+	// which are kept up to date with NetBox
+	tagCache := ctrl.NewTagCache(netboxClient, logger, cfg.tagRefreshInterval)
+	if cfg.tagRefreshInterval > 0 {
+		if err := mgr.Add(tagCache); err != nil {
+			return fmt.Errorf("unable to refresh tags: %s", err)
+		}
+	}
+
+	netboxOpts := []ctrl.Option{
 		ctrl.WithKubernetesClient(client),
 		ctrl.WithLogger(logger),
 		ctrl.WithNetBoxClient(netboxClient),
-	)
+		ctrl.WithTagCache(tagCache),
+		ctrl.WithEventRecorder(recorder),
+		ctrl.WithTenantNetBoxClients(tenantClients),
+		ctrl.WithDeletionPolicy(cfg.deletionPolicy),
+		ctrl.WithDeletionDebounce(cfg.deletionDebounce),
+		ctrl.WithRequeueInterval(cfg.requeueInterval),
+		ctrl.WithSlowReconcileThreshold(cfg.slowReconcile),
+		ctrl.WithShard(cfg.shardIndex, cfg.shardCount),
+		ctrl.WithQueueLimit(cfg.queueMaxSize, cfg.queueOverflowPolicy, cfg.queueParkDelay),
+		ctrl.WithRateBudgets(rateBudgets),
+	}
+	if cfg.webhookURL != "" {
+		sink, err := webhook.NewHTTPSink(cfg.webhookURL, cfg.webhookTimeout)
+		if err != nil {
+			return fmt.Errorf("%s value is invalid: %w", flagWebhookURL, err)
+		}
+		netboxOpts = append(netboxOpts, ctrl.WithWebhookSink(sink))
+	}
+	if cfg.dnsEndpoints {
+		netboxOpts = append(netboxOpts, ctrl.WithDNSEndpoints())
+	}
+	if cfg.sharedAddresses {
+		netboxOpts = append(netboxOpts, ctrl.WithSharedAddresses())
+	}
+	if cfg.serviceIPPriority {
+		netboxOpts = append(netboxOpts, ctrl.WithServiceIPPriority())
+	}
+	if cfg.netboxWebhookAddr != "" {
+		netboxOpts = append(netboxOpts, ctrl.WithNetBoxWebhook(cfg.netboxWebhookAddr, cfg.netboxWebhookSecret))
+		netboxOpts = append(netboxOpts, ctrl.WithUIDFieldName(globalCfg.uidField))
+	}
+
+	netboxController, err := netboxipctrl.New(netboxOpts...)
 	if err != nil {
 		return fmt.Errorf("initializing netbox controller: %q", err)
 	}
-	controllers["netboxip"] = netboxController
+	controllers[netboxipctrl.ControllerName] = netboxController
+
+	if cfg.ipClaims {
+		claimController, err := claimctrl.New(
+			ctrl.WithKubernetesClient(client),
+			ctrl.WithLogger(logger),
+			ctrl.WithNetBoxClient(netboxClient),
+			ctrl.WithAllowedPrefixes(cfg.allowedPrefixes),
+			ctrl.WithEventRecorder(recorder),
+			ctrl.WithDeletionPolicy(cfg.deletionPolicy),
+			ctrl.WithRequeueInterval(cfg.requeueInterval),
+			ctrl.WithSlowReconcileThreshold(cfg.slowReconcile),
+			ctrl.WithShard(cfg.shardIndex, cfg.shardCount),
+			ctrl.WithQueueLimit(cfg.queueMaxSize, cfg.queueOverflowPolicy, cfg.queueParkDelay),
+			ctrl.WithRateBudgets(rateBudgets),
+		)
+		if err != nil {
+			return fmt.Errorf("initializing netboxipclaim controller: %s", err)
+		}
+		controllers[claimctrl.ControllerName] = claimController
+	}
+
+	if cfg.nsPrefixParent.IsValid() {
+		nsController, err := nsctrl.New(
+			ctrl.WithKubernetesClient(client),
+			ctrl.WithLogger(logger),
+			ctrl.WithNamespacePrefixes(cfg.nsPrefixParent, cfg.nsPrefixLength, netboxClient),
+			ctrl.WithEventRecorder(recorder),
+			ctrl.WithClusterTag(cfg.clusterTag),
+			ctrl.WithDeletionPolicy(cfg.deletionPolicy),
+			ctrl.WithSlowReconcileThreshold(cfg.slowReconcile),
+			ctrl.WithShard(cfg.shardIndex, cfg.shardCount),
+			ctrl.WithQueueLimit(cfg.queueMaxSize, cfg.queueOverflowPolicy, cfg.queueParkDelay),
+			ctrl.WithRateBudgets(rateBudgets),
+		)
+		if err != nil {
+			return fmt.Errorf("initializing namespace controller: %s", err)
+		}
+		controllers[nsctrl.ControllerName] = nsController
+	}
+
+	// with the controller config, tags, labels and namespace filters
+	// of the pod and service controllers may change at runtime
+	var podSettings, svcSettings *ctrl.LiveSettings
+	if cfg.controllerConfig != "" {
+		podTags, err := tagCache.Lookup(ctx, cfg.podTags)
+		if err != nil {
+			return err
+		}
+		podSettings = ctrl.NewLiveSettings(ctrl.PublishSettings{Tags: podTags, Labels: cfg.podLabels})
+
+		svcTags, err := tagCache.Lookup(ctx, cfg.serviceTags)
+		if err != nil {
+			return err
+		}
+		svcSettings = ctrl.NewLiveSettings(ctrl.PublishSettings{Tags: svcTags, Labels: cfg.serviceLabels})
+
+		configController, err := configctrl.New(configctrl.Config{
+			Name:            cfg.controllerConfig,
+			Pods:            podSettings,
+			PodDefaults:     configctrl.Defaults{Tags: cfg.podTags, Labels: cfg.podLabels},
+			Services:        svcSettings,
+			ServiceDefaults: configctrl.Defaults{Tags: cfg.serviceTags, Labels: cfg.serviceLabels},
+			RateLimiters:    []*rate.Limiter{deps.limiter},
+			NetBoxQPS:       globalCfg.netboxQPS,
+			NetBoxBurst:     globalCfg.netboxBurst,
+		},
+			ctrl.WithKubernetesClient(client),
+			ctrl.WithLogger(logger),
+			ctrl.WithNetBoxClient(netboxClient),
+			ctrl.WithTagCache(tagCache),
+			ctrl.WithEventRecorder(recorder),
+			ctrl.WithClusterTag(cfg.clusterTag),
+		)
+		if err != nil {
+			return fmt.Errorf("initializing config controller: %s", err)
+		}
+		controllers[configctrl.ControllerName] = configController
+	}
 
 	podCtrOpts := []ctrl.Option{
 		ctrl.WithKubernetesClient(client),
 		ctrl.WithLogger(logger),
-		ctrl.WithTags(cfg.podTags, netboxClient),
-		ctrl.WithLabels(cfg.podLabels),
+		ctrl.WithTagCache(tagCache),
+		ctrl.WithTenantMapping(tenantMapping),
+		ctrl.WithClusterTag(cfg.clusterTag),
+		ctrl.WithClusterDomain(cfg.clusterDomain),
+		ctrl.WithIPFamilies(cfg.publishIPv4, cfg.publishIPv6),
+		ctrl.WithCompletedPodIPTTL(cfg.completedPodIPTTL),
+		ctrl.WithExcludedOwnerKinds(cfg.podExcludeOwnerKinds),
+		ctrl.WithOwnerKinds(cfg.podOwnerKinds),
+		ctrl.WithJobPolicy(cfg.podJobPolicy, cfg.podJobTag),
+		ctrl.WithDescriptionStrategy(cfg.descriptionStrategy),
+		ctrl.WithRequeueInterval(cfg.requeueInterval),
+		ctrl.WithSlowReconcileThreshold(cfg.slowReconcile),
+		ctrl.WithShard(cfg.shardIndex, cfg.shardCount),
+		ctrl.WithQueueLimit(cfg.queueMaxSize, cfg.queueOverflowPolicy, cfg.queueParkDelay),
+		ctrl.WithRateBudgets(rateBudgets),
+	}
+	if podSettings != nil {
+		podCtrOpts = append(podCtrOpts, ctrl.WithLiveSettings(podSettings))
+	} else {
+		podCtrOpts = append(podCtrOpts, ctrl.WithTags(cfg.podTags), ctrl.WithLabels(cfg.podLabels))
+	}
+	if cfg.podCustomFields != nil {
+		podCtrOpts = append(podCtrOpts, ctrl.WithCustomFieldAnnotations(cfg.podCustomFields))
+	}
+	if cfg.sharedAddresses {
+		podCtrOpts = append(podCtrOpts, ctrl.WithSharedAddresses())
+	}
+	if cfg.podMACAddresses {
+		podCtrOpts = append(podCtrOpts, ctrl.WithMACAddresses())
 	}
-	if globalCfg.dualStackIP {
-		podCtrOpts = append(podCtrOpts, ctrl.WithDualStackIP())
+	if cfg.nodeDeviceMatch != ctrl.NodeDeviceMatchName {
+		podCtrOpts = append(podCtrOpts, ctrl.WithNodeDeviceMatch(cfg.nodeDeviceMatch, netboxClient))
+	}
+	if cfg.nodeInterface != "" {
+		podCtrOpts = append(podCtrOpts, ctrl.WithNodeInterface(cfg.nodeInterface))
+	}
+	if cfg.annotationOverrides {
+		podCtrOpts = append(podCtrOpts, ctrl.WithAnnotationOverrides())
+	}
+	if cfg.podRequireReady {
+		podCtrOpts = append(podCtrOpts, ctrl.WithRequireReady(cfg.podNotReadyGrace))
+	}
+	if cfg.podSkipStaticPods {
+		podCtrOpts = append(podCtrOpts, ctrl.WithoutStaticPods())
+	}
+	if cfg.podOmitDNSName {
+		podCtrOpts = append(podCtrOpts, ctrl.WithoutDNSName())
 	}
 	podController, err := podctrl.New(podCtrOpts...)
 	if err != nil {
 		return fmt.Errorf("initializing pod controller: %s", err)
 	}
-	controllers["pod"] = podController
+	controllers[podctrl.ControllerName] = podController
 	svcCtrOpts := []ctrl.Option{
 		ctrl.WithKubernetesClient(client),
 		ctrl.WithLogger(logger),
-		ctrl.WithTags(cfg.serviceTags, netboxClient),
-		ctrl.WithLabels(cfg.serviceLabels),
+		ctrl.WithTagCache(tagCache),
 		ctrl.WithClusterDomain(cfg.clusterDomain),
+		ctrl.WithIPFamilies(cfg.publishIPv4, cfg.publishIPv6),
+		ctrl.WithTenantMapping(tenantMapping),
+		ctrl.WithClusterTag(cfg.clusterTag),
+		ctrl.WithDescriptionStrategy(cfg.descriptionStrategy),
+		ctrl.WithRequeueInterval(cfg.requeueInterval),
+		ctrl.WithSlowReconcileThreshold(cfg.slowReconcile),
+		ctrl.WithShard(cfg.shardIndex, cfg.shardCount),
+		ctrl.WithQueueLimit(cfg.queueMaxSize, cfg.queueOverflowPolicy, cfg.queueParkDelay),
+		ctrl.WithRateBudgets(rateBudgets),
+	}
+	if svcSettings != nil {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithLiveSettings(svcSettings))
+	} else {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithTags(cfg.serviceTags), ctrl.WithLabels(cfg.serviceLabels))
+	}
+	if cfg.serviceOmitDNSName {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithoutDNSName())
+	}
+	if cfg.serviceLBIPs {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithLoadBalancerIPs())
+	}
+	if cfg.serviceLBNAT {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithLoadBalancerNAT())
+	}
+	if cfg.serviceLBIPPool.IsValid() {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithLoadBalancerIPAllocation(cfg.serviceLBIPPool, cfg.serviceLBIPAnnot, netboxClient))
+	}
+	if cfg.serviceLBResolve {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithLoadBalancerHostnames(net.DefaultResolver, cfg.serviceLBRefresh))
+	}
+	if cfg.serviceExtNames {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithExternalNames(net.DefaultResolver, cfg.serviceExtRefresh))
+	}
+	if cfg.serviceNSDomains {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithNamespaceClusterDomains())
+	}
+	if len(cfg.serviceClusterIPTags) > 0 || len(cfg.serviceLBIPTags) > 0 {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithServiceIPKindTags(cfg.serviceClusterIPTags, cfg.serviceLBIPTags))
+	}
+	if cfg.serviceSelectorField != "" || cfg.servicePortsField != "" {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithSelectorCustomFields(cfg.serviceSelectorField, cfg.servicePortsField))
+	}
+	if cfg.serviceVIPs {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithVIPAnnotations(cfg.serviceVIPTag))
 	}
-	if globalCfg.dualStackIP {
-		svcCtrOpts = append(svcCtrOpts, ctrl.WithDualStackIP())
+	if cfg.serviceNodePorts {
+		svcCtrOpts = append(svcCtrOpts, ctrl.WithNodePortServices(netboxClient))
 	}
 	svcController, err := svcctrl.New(svcCtrOpts...)
 	if err != nil {
 		return fmt.Errorf("initializing service controller: %s", err)
 	}
-	controllers["service"] = svcController
+	controllers[svcctrl.ControllerName] = svcController
 
 	for name, controller := range controllers {
 		if err := controller.AddToManager(mgr); err != nil {
@@ -18,11 +18,18 @@ package main
 
 import (
 	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/time/rate"
 )
 
 func TestConfigSetup(t *testing.T) {
@@ -41,35 +48,178 @@ func TestConfigSetup(t *testing.T) {
 			"SERVICE_PUBLISH_LABELS": "baz",
 			"CLUSTER_DOMAIN":         "example.com",
 			"READY_CHECK_ADDR":       ":4000",
+			"CLUSTER_TAG":            "prod-1",
+			"WEBHOOK_URL":            "https://cmdb.example.com/events",
+			"WEBHOOK_TIMEOUT":        "5s",
+			"DELETION_POLICY":        "retain",
+			"DESCRIPTION_STRATEGY":   "hash-suffix",
+			"POD_REQUIRE_READY":      "true",
 		},
 		expectedConfig: &rootConfig{
-			metricsAddr:    ":9000",
-			podTags:        []string{"a", "b"},
-			serviceTags:    nil,
-			podLabels:      map[string]bool{"foo": true, "bar": true},
-			serviceLabels:  map[string]bool{"baz": true},
-			clusterDomain:  "example.com",
-			readyCheckAddr: ":4000",
+			metricsAddr:         ":9000",
+			podTags:             []string{"a", "b", "prod-1"},
+			serviceTags:         []string{"prod-1"},
+			podLabels:           map[string]bool{"foo": true, "bar": true},
+			serviceLabels:       map[string]bool{"baz": true},
+			clusterDomain:       "example.com",
+			readyCheckAddr:      ":4000",
+			clusterTag:          "prod-1",
+			webhookURL:          "https://cmdb.example.com/events",
+			webhookTimeout:      5 * time.Second,
+			deletionPolicy:      "retain",
+			descriptionStrategy: "hash-suffix",
+			podRequireReady:     true,
+			podNotReadyGrace:    5 * time.Minute,
+			podJobPolicy:        "publish",
+			podJobTag:           "job",
+			nodeDeviceMatch:     "name",
+			serviceLBRefresh:    5 * time.Minute,
+			serviceExtRefresh:   5 * time.Minute,
+			serviceVIPTag:       "vip",
+			nsMetricsLimit:      100,
+			nsPrefixLength:      24,
+			serviceLBIPAnnot:    "metallb.universe.tf/loadBalancerIPs",
+			publishIPv4:         true,
+			publishIPv6:         true,
+			eventDedupWindow:    5 * time.Minute,
+			slowReconcile:       5 * time.Second,
+			shardCount:          1,
+			queueOverflowPolicy: "park",
+			queueParkDelay:      time.Minute,
+			tagRefreshInterval:  10 * time.Minute,
 		},
 	}, {
 		name: "from flags",
 		flags: map[string]string{
-			"metrics-addr":           ":9000",
-			"pod-ip-tags":            "a,b",
-			"service-ip-tags":        "",
-			"pod-publish-labels":     "foo, bar",
-			"service-publish-labels": "baz",
-			"cluster-domain":         "example.com",
-			"ready-check-addr":       ":4000",
+			"metrics-addr":                            ":9000",
+			"pod-ip-tags":                             "a,b",
+			"service-ip-tags":                         "",
+			"pod-publish-labels":                      "foo, bar",
+			"service-publish-labels":                  "baz",
+			"cluster-domain":                          "example.com",
+			"ready-check-addr":                        ":4000",
+			"publish-ipv6":                            "false",
+			"skip-crd-registration":                   "true",
+			"allowed-prefixes":                        "10.0.0.0/8, fd00::1/8",
+			"dns-endpoints":                           "true",
+			"netboxip-metrics-limit":                  "1000",
+			"controller-config":                       "netbox-ip-controller",
+			"ip-claims":                               "true",
+			"completed-pod-ip-ttl":                    "1h",
+			"deletion-debounce":                       "30s",
+			"requeue-interval":                        "6h",
+			"pod-exclude-owner-kinds":                 "DaemonSet, Node",
+			"pod-skip-static-pods":                    "true",
+			"annotation-overrides":                    "true",
+			"shared-addresses":                        "true",
+			"pod-custom-field-annotations":            "cost_center=example.com/cost-center",
+			"pod-mac-addresses":                       "true",
+			"node-device-match":                       "label:example.com/device",
+			"node-interface":                          "bond0",
+			"pod-not-ready-grace-period":              "1m",
+			"pod-job-policy":                          "tag",
+			"pod-job-tag":                             "ci-job",
+			"service-load-balancer-ips":               "true",
+			"service-load-balancer-nat":               "true",
+			"service-load-balancer-ip-pool":           "203.0.113.0/24",
+			"service-load-balancer-ip-annotation":     "kube-vip.io/loadbalancerIPs",
+			"service-resolve-load-balancer-hostnames": "true",
+			"service-load-balancer-hostname-refresh":  "1m",
+			"service-node-port-services":              "true",
+			"service-external-names":                  "true",
+			"service-external-name-refresh":           "10m",
+			"service-namespace-cluster-domains":       "true",
+			"service-vip-annotations":                 "true",
+			"service-vip-tag":                         "reserved",
+			"service-cluster-ip-tags":                 "k8s-clusterip",
+			"service-load-balancer-ip-tags":           "k8s-lb-vip,external",
+			"service-selector-field":                  "k8s_selector",
+			"service-ports-field":                     "k8s_ports",
+			"namespace-metrics-limit":                 "20",
+			"namespace-prefix-parent":                 "10.1.0.0/16",
+			"namespace-prefix-length":                 "26",
+			"metrics-tls-cert-path":                   "/etc/metrics/tls.crt",
+			"metrics-tls-key-path":                    "/etc/metrics/tls.key",
+			"metrics-tls-client-ca-path":              "/etc/metrics/ca.crt",
+			"event-dedup-window":                      "1m",
+			"slow-reconcile-threshold":                "10s",
+			"queue-max-size":                          "5000",
+			"queue-overflow-policy":                   "shed",
+			"netbox-tag-refresh-interval":             "1h",
+			"netbox-controller-rate-limits":           "netboxip-service=20:10, netboxipclaim=5:5",
+			"crd-category-all":                        "true",
+			"crd-immutable-address":                   "true",
 		},
 		expectedConfig: &rootConfig{
-			metricsAddr:    ":9000",
-			podTags:        []string{"a", "b"},
-			serviceTags:    nil,
-			podLabels:      map[string]bool{"foo": true, "bar": true},
-			serviceLabels:  map[string]bool{"baz": true},
-			clusterDomain:  "example.com",
-			readyCheckAddr: ":4000",
+			metricsAddr:         ":9000",
+			podTags:             []string{"a", "b"},
+			serviceTags:         nil,
+			podLabels:           map[string]bool{"foo": true, "bar": true},
+			serviceLabels:       map[string]bool{"baz": true},
+			clusterDomain:       "example.com",
+			readyCheckAddr:      ":4000",
+			skipCRDRegistration: true,
+			allowedPrefixes: []netip.Prefix{
+				netip.MustParsePrefix("10.0.0.0/8"),
+				netip.MustParsePrefix("fd00::/8"),
+			},
+			webhookTimeout:       10 * time.Second,
+			dnsEndpoints:         true,
+			netboxIPMetricsLimit: 1000,
+			controllerConfig:     "netbox-ip-controller",
+			ipClaims:             true,
+			deletionPolicy:       "delete",
+			completedPodIPTTL:    time.Hour,
+			deletionDebounce:     30 * time.Second,
+			descriptionStrategy:  "truncate",
+			requeueInterval:      6 * time.Hour,
+			podExcludeOwnerKinds: []string{"DaemonSet", "Node"},
+			podSkipStaticPods:    true,
+			annotationOverrides:  true,
+			sharedAddresses:      true,
+			podMACAddresses:      true,
+			nodeDeviceMatch:      "label:example.com/device",
+			nodeInterface:        "bond0",
+			podCustomFields:      map[string]string{"cost_center": "example.com/cost-center"},
+			podNotReadyGrace:     time.Minute,
+			podJobPolicy:         "tag",
+			podJobTag:            "ci-job",
+			serviceLBIPs:         true,
+			serviceLBNAT:         true,
+			serviceLBIPPool:      netip.MustParsePrefix("203.0.113.0/24"),
+			serviceLBIPAnnot:     "kube-vip.io/loadbalancerIPs",
+			publishIPv4:          true,
+			serviceLBResolve:     true,
+			serviceLBRefresh:     time.Minute,
+			serviceNodePorts:     true,
+			serviceExtNames:      true,
+			serviceExtRefresh:    10 * time.Minute,
+			serviceNSDomains:     true,
+			serviceVIPs:          true,
+			serviceVIPTag:        "reserved",
+			serviceClusterIPTags: []string{"k8s-clusterip"},
+			serviceLBIPTags:      []string{"k8s-lb-vip", "external"},
+			serviceSelectorField: "k8s_selector",
+			servicePortsField:    "k8s_ports",
+			nsMetricsLimit:       20,
+			nsPrefixParent:       netip.MustParsePrefix("10.1.0.0/16"),
+			nsPrefixLength:       26,
+			metricsTLSCert:       "/etc/metrics/tls.crt",
+			metricsTLSKey:        "/etc/metrics/tls.key",
+			metricsClientCA:      "/etc/metrics/ca.crt",
+			eventDedupWindow:     time.Minute,
+			slowReconcile:        10 * time.Second,
+			shardCount:           1,
+			queueMaxSize:         5000,
+			queueOverflowPolicy:  "shed",
+			queueParkDelay:       time.Minute,
+			tagRefreshInterval:   time.Hour,
+			rateBudgets: map[string]rateBudget{
+				"netboxip-service": {qps: 20, burst: 10},
+				"netboxipclaim":    {qps: 5, burst: 5},
+			},
+			crdCategoryAll:      true,
+			crdImmutableAddress: true,
 		},
 	}, {
 		name: "flags override env vars",
@@ -89,13 +239,34 @@ func TestConfigSetup(t *testing.T) {
 			"ready-check-addr":       ":5000",
 		},
 		expectedConfig: &rootConfig{
-			metricsAddr:    ":9000",
-			podTags:        []string{"a", "b"},
-			serviceTags:    nil,
-			podLabels:      map[string]bool{"foo": true, "bar": true},
-			serviceLabels:  map[string]bool{"baz": true},
-			clusterDomain:  "example.com",
-			readyCheckAddr: ":5000",
+			metricsAddr:         ":9000",
+			podTags:             []string{"a", "b"},
+			serviceTags:         nil,
+			podLabels:           map[string]bool{"foo": true, "bar": true},
+			serviceLabels:       map[string]bool{"baz": true},
+			clusterDomain:       "example.com",
+			readyCheckAddr:      ":5000",
+			webhookTimeout:      10 * time.Second,
+			deletionPolicy:      "delete",
+			descriptionStrategy: "truncate",
+			podNotReadyGrace:    5 * time.Minute,
+			podJobPolicy:        "publish",
+			podJobTag:           "job",
+			nodeDeviceMatch:     "name",
+			serviceLBRefresh:    5 * time.Minute,
+			serviceExtRefresh:   5 * time.Minute,
+			serviceVIPTag:       "vip",
+			nsMetricsLimit:      100,
+			nsPrefixLength:      24,
+			serviceLBIPAnnot:    "metallb.universe.tf/loadBalancerIPs",
+			publishIPv4:         true,
+			publishIPv6:         true,
+			eventDedupWindow:    5 * time.Minute,
+			slowReconcile:       5 * time.Second,
+			shardCount:          1,
+			queueOverflowPolicy: "park",
+			queueParkDelay:      time.Minute,
+			tagRefreshInterval:  10 * time.Minute,
 		},
 	}}
 
@@ -126,6 +297,29 @@ func TestGlobalConfigValidation(t *testing.T) {
 		name              string
 		netboxAPIURL      string
 		netboxToken       string
+		netboxOAuth       netbox.ClientCredentialsConfig
+		netboxQPS         rate.Limit
+		netboxBurst       int
+		netboxTLSVersion  string
+		netboxTLSCiphers  []string
+		netboxTLSName     string
+		auditLogPath      string
+		uidPrefix         string
+		duplicateIPs      string
+		adoptionPolicy    string
+		ipamBackend       string
+		phpipamAPIURL     string
+		phpipamAppID      string
+		phpipamToken      string
+		phpipamSubnetIDs  []int64
+		infobloxAPIURL    string
+		infobloxUsername  string
+		infobloxPassword  string
+		netboxBodyLimit   int
+		netboxLookupTTL   time.Duration
+		netboxTimeout     time.Duration
+		uidField          string
+		previousUIDField  string
 		errorExpected     bool
 		expectedErrSubstr string
 	}{{
@@ -138,20 +332,319 @@ func TestGlobalConfigValidation(t *testing.T) {
 		netboxToken:       "foo",
 		errorExpected:     true,
 		expectedErrSubstr: flagNetBoxAPIURL,
+	}, {
+		name:         "no client ID provided with OAuth token URL",
+		netboxAPIURL: "foo",
+		netboxOAuth: netbox.ClientCredentialsConfig{
+			TokenURL: "https://sso.example.com/token",
+		},
+		errorExpected:     true,
+		expectedErrSubstr: flagNetBoxOAuthClientID,
+	}, {
+		name:         "OAuth without netbox token",
+		netboxAPIURL: "foo",
+		netboxOAuth: netbox.ClientCredentialsConfig{
+			TokenURL: "https://sso.example.com/token",
+			ClientID: "bar",
+		},
+		netboxQPS:   1,
+		netboxBurst: 1,
+	}, {
+		name:              "unknown TLS version",
+		netboxAPIURL:      "foo",
+		netboxToken:       "bar",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		netboxTLSVersion:  "1.4",
+		errorExpected:     true,
+		expectedErrSubstr: flagNetBoxTLSMinVersion,
+	}, {
+		name:              "insecure TLS cipher suite",
+		netboxAPIURL:      "foo",
+		netboxToken:       "bar",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		netboxTLSCiphers:  []string{"TLS_RSA_WITH_RC4_128_SHA"},
+		errorExpected:     true,
+		expectedErrSubstr: flagNetBoxTLSCiphers,
+	}, {
+		name:              "invalid UID prefix",
+		netboxAPIURL:      "foo",
+		netboxToken:       "bar",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		uidPrefix:         "prod/1",
+		errorExpected:     true,
+		expectedErrSubstr: flagUIDPrefix,
+	}, {
+		name:              "unknown duplicate IP strategy",
+		netboxAPIURL:      "foo",
+		netboxToken:       "bar",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		duplicateIPs:      "adopt-newest",
+		errorExpected:     true,
+		expectedErrSubstr: flagDuplicateIPStrategy,
+	}, {
+		name:         "duplicate IP strategy",
+		netboxAPIURL: "foo",
+		netboxToken:  "bar",
+		netboxQPS:    1,
+		netboxBurst:  1,
+		duplicateIPs: "merge-and-delete-duplicates",
+	}, {
+		name:              "unknown adoption policy",
+		netboxAPIURL:      "foo",
+		netboxToken:       "bar",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		adoptionPolicy:    "steal",
+		errorExpected:     true,
+		expectedErrSubstr: flagAdoptionPolicy,
+	}, {
+		name:           "adoption policy",
+		netboxAPIURL:   "foo",
+		netboxToken:    "bar",
+		netboxQPS:      1,
+		netboxBurst:    1,
+		adoptionPolicy: "adopt",
+	}, {
+		name:           "adoption of matching IPs",
+		netboxAPIURL:   "foo",
+		netboxToken:    "bar",
+		netboxQPS:      1,
+		netboxBurst:    1,
+		adoptionPolicy: "adopt-matching",
+	}, {
+		name:              "negative body log limit",
+		netboxAPIURL:      "foo",
+		netboxToken:       "bar",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		netboxBodyLimit:   -1,
+		errorExpected:     true,
+		expectedErrSubstr: flagNetBoxLogBodies,
+	}, {
+		name:              "negative lookup cache TTL",
+		netboxAPIURL:      "foo",
+		netboxToken:       "bar",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		netboxLookupTTL:   -time.Minute,
+		errorExpected:     true,
+		expectedErrSubstr: flagNetBoxLookupTTL,
+	}, {
+		name:              "negative request timeout",
+		netboxAPIURL:      "foo",
+		netboxToken:       "bar",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		netboxTimeout:     -time.Second,
+		errorExpected:     true,
+		expectedErrSubstr: flagNetBoxTimeout,
+	}, {
+		name:              "invalid UID field name",
+		netboxAPIURL:      "foo",
+		netboxToken:       "bar",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		uidField:          "my uid",
+		errorExpected:     true,
+		expectedErrSubstr: flagNetBoxUIDField,
+	}, {
+		name:              "previous UID field same as UID field",
+		netboxAPIURL:      "foo",
+		netboxToken:       "bar",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		uidField:          netbox.UIDCustomFieldName,
+		previousUIDField:  netbox.UIDCustomFieldName,
+		errorExpected:     true,
+		expectedErrSubstr: flagNetBoxPreviousUID,
+	}, {
+		name:             "UID field migration",
+		netboxAPIURL:     "foo",
+		netboxToken:      "bar",
+		netboxQPS:        1,
+		netboxBurst:      1,
+		uidField:         "my_uid",
+		previousUIDField: netbox.UIDCustomFieldName,
+	}, {
+		name:             "TLS settings",
+		netboxAPIURL:     "foo",
+		netboxToken:      "bar",
+		netboxQPS:        1,
+		netboxBurst:      1,
+		netboxTLSVersion: "1.2",
+		netboxTLSCiphers: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	}, {
+		name:              "unknown IPAM backend",
+		netboxAPIURL:      "foo",
+		netboxToken:       "bar",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		ipamBackend:       "bluecat",
+		errorExpected:     true,
+		expectedErrSubstr: flagIPAMBackend,
+	}, {
+		name:              "no phpIPAM subnet IDs provided",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		ipamBackend:       ipamBackendPHPIPAM,
+		phpipamAPIURL:     "https://phpipam.example.com/api",
+		phpipamAppID:      "k8s",
+		phpipamToken:      "foo",
+		errorExpected:     true,
+		expectedErrSubstr: flagPHPIPAMSubnetIDs,
+	}, {
+		name:             "phpIPAM without netbox settings",
+		netboxQPS:        1,
+		netboxBurst:      1,
+		ipamBackend:      ipamBackendPHPIPAM,
+		phpipamAPIURL:    "https://phpipam.example.com/api",
+		phpipamAppID:     "k8s",
+		phpipamToken:     "foo",
+		phpipamSubnetIDs: []int64{7},
+	}, {
+		name:              "phpIPAM with a UID prefix",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		ipamBackend:       ipamBackendPHPIPAM,
+		phpipamAPIURL:     "https://phpipam.example.com/api",
+		phpipamAppID:      "k8s",
+		phpipamToken:      "foo",
+		phpipamSubnetIDs:  []int64{7},
+		uidPrefix:         "cluster-a",
+		errorExpected:     true,
+		expectedErrSubstr: flagUIDPrefix,
+	}, {
+		name:              "phpIPAM with a duplicate IP strategy",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		ipamBackend:       ipamBackendPHPIPAM,
+		phpipamAPIURL:     "https://phpipam.example.com/api",
+		phpipamAppID:      "k8s",
+		phpipamToken:      "foo",
+		phpipamSubnetIDs:  []int64{7},
+		duplicateIPs:      netbox.DuplicateStrategyMerge,
+		errorExpected:     true,
+		expectedErrSubstr: flagDuplicateIPStrategy,
+	}, {
+		name:              "phpIPAM with a UID field",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		ipamBackend:       ipamBackendPHPIPAM,
+		phpipamAPIURL:     "https://phpipam.example.com/api",
+		phpipamAppID:      "k8s",
+		phpipamToken:      "foo",
+		phpipamSubnetIDs:  []int64{7},
+		uidField:          "cluster_a_uid",
+		errorExpected:     true,
+		expectedErrSubstr: flagNetBoxUIDField,
+	}, {
+		name:             "phpIPAM with the default NetBox settings",
+		netboxQPS:        1,
+		netboxBurst:      1,
+		ipamBackend:      ipamBackendPHPIPAM,
+		phpipamAPIURL:    "https://phpipam.example.com/api",
+		phpipamAppID:     "k8s",
+		phpipamToken:     "foo",
+		phpipamSubnetIDs: []int64{7},
+		duplicateIPs:     netbox.DuplicateStrategyFail,
+		adoptionPolicy:   netbox.AdoptionPolicyDuplicate,
+		uidField:         netbox.UIDCustomFieldName,
+		netboxTimeout:    netbox.DefaultRequestTimeout,
+	}, {
+		name:              "no Infoblox password provided",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		ipamBackend:       ipamBackendInfoblox,
+		infobloxAPIURL:    "https://gm.example.com/wapi/v2.11",
+		infobloxUsername:  "admin",
+		errorExpected:     true,
+		expectedErrSubstr: flagInfobloxPassword,
+	}, {
+		name:             "Infoblox without netbox settings",
+		netboxQPS:        1,
+		netboxBurst:      1,
+		ipamBackend:      ipamBackendInfoblox,
+		infobloxAPIURL:   "https://gm.example.com/wapi/v2.11",
+		infobloxUsername: "admin",
+		infobloxPassword: "secret",
+	}, {
+		name:              "Infoblox with an audit log",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		ipamBackend:       ipamBackendInfoblox,
+		infobloxAPIURL:    "https://gm.example.com/wapi/v2.11",
+		infobloxUsername:  "admin",
+		infobloxPassword:  "secret",
+		auditLogPath:      "-",
+		errorExpected:     true,
+		expectedErrSubstr: flagAuditLogPath,
+	}, {
+		name:              "Infoblox with an adoption policy",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		ipamBackend:       ipamBackendInfoblox,
+		infobloxAPIURL:    "https://gm.example.com/wapi/v2.11",
+		infobloxUsername:  "admin",
+		infobloxPassword:  "secret",
+		adoptionPolicy:    netbox.AdoptionPolicyAdopt,
+		errorExpected:     true,
+		expectedErrSubstr: flagAdoptionPolicy,
+	}, {
+		name:              "Infoblox with a TLS server name",
+		netboxQPS:         1,
+		netboxBurst:       1,
+		ipamBackend:       ipamBackendInfoblox,
+		infobloxAPIURL:    "https://gm.example.com/wapi/v2.11",
+		infobloxUsername:  "admin",
+		infobloxPassword:  "secret",
+		netboxTLSName:     "netbox.example.com",
+		errorExpected:     true,
+		expectedErrSubstr: flagNetBoxTLSServerName,
 	}}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			cfg := globalConfig{
-				netboxAPIURL: test.netboxAPIURL,
-				netboxToken:  test.netboxToken,
+				netboxAPIURL:     test.netboxAPIURL,
+				netboxToken:      test.netboxToken,
+				netboxOAuth:      test.netboxOAuth,
+				netboxQPS:        test.netboxQPS,
+				netboxBurst:      test.netboxBurst,
+				netboxTLSVersion: test.netboxTLSVersion,
+				netboxTLSCiphers: test.netboxTLSCiphers,
+				netboxTLSName:    test.netboxTLSName,
+				auditLogPath:     test.auditLogPath,
+				uidPrefix:        test.uidPrefix,
+				duplicateIPs:     test.duplicateIPs,
+				adoptionPolicy:   test.adoptionPolicy,
+				ipamBackend:      test.ipamBackend,
+				phpipamAPIURL:    test.phpipamAPIURL,
+				phpipamAppID:     test.phpipamAppID,
+				phpipamToken:     test.phpipamToken,
+				phpipamSubnetIDs: test.phpipamSubnetIDs,
+				infobloxAPIURL:   test.infobloxAPIURL,
+				infobloxUsername: test.infobloxUsername,
+				infobloxPassword: test.infobloxPassword,
+				netboxBodyLimit:  test.netboxBodyLimit,
+				netboxLookupTTL:  test.netboxLookupTTL,
+				netboxTimeout:    test.netboxTimeout,
+				uidField:         test.uidField,
+				previousUIDField: test.previousUIDField,
 			}
 
 			err := cfg.validate()
 
-			err = expectError(test.expectedErrSubstr, err)
-			if err != nil {
-				t.Error(err)
+			if test.errorExpected {
+				err = expectError(test.expectedErrSubstr, err)
+				if err != nil {
+					t.Error(err)
+				}
+			} else if err != nil {
+				t.Errorf("expected nil error but got %v", err)
 			}
 		})
 	}
@@ -159,11 +652,53 @@ func TestGlobalConfigValidation(t *testing.T) {
 
 func TestRootConfigValidation(t *testing.T) {
 	tests := []struct {
-		name              string
-		podLabels         map[string]bool
-		serviceLabels     map[string]bool
-		errorExpected     bool
-		expectedErrSubstr string
+		name                 string
+		podLabels            map[string]bool
+		serviceLabels        map[string]bool
+		clusterTag           string
+		netboxWebhookAddr    string
+		netboxWebhookSecret  string
+		controllerConfig     string
+		deletionPolicy       string
+		completedPodIPTTL    time.Duration
+		requeueInterval      time.Duration
+		podExcludeOwnerKinds []string
+		podOwnerKinds        []string
+		podNotReadyGrace     time.Duration
+		podJobPolicy         string
+		podJobTag            string
+		nodeDeviceMatch      string
+		descriptionStrategy  string
+		podCustomFields      map[string]string
+		serviceLBIPs         bool
+		serviceLBNAT         bool
+		serviceLBIPPool      netip.Prefix
+		serviceLBIPAnnot     string
+		skipIPv4             bool
+		skipIPv6             bool
+		serviceLBResolve     bool
+		serviceLBRefresh     time.Duration
+		serviceVIPs          bool
+		serviceVIPTag        string
+		serviceSelectorField string
+		nsPrefixParent       netip.Prefix
+		nsPrefixLength       int
+		metricsTLSCert       string
+		metricsTLSKey        string
+		metricsClientCA      string
+		eventDedupWindow     time.Duration
+		slowReconcile        time.Duration
+		sharedAddresses      bool
+		shardIndex           int
+		shardCount           int
+		serviceIPPriority    bool
+		queueMaxSize         int
+		queueOverflowPolicy  string
+		queueParkDelay       time.Duration
+		rateBudgets          map[string]rateBudget
+		tagRefreshInterval   time.Duration
+		errorExpected        bool
+		expectedErrSubstr    string
 	}{{
 		name: "invalid pod label",
 		podLabels: map[string]bool{
@@ -190,13 +725,369 @@ func TestRootConfigValidation(t *testing.T) {
 			"the_best_label": true,
 		},
 		errorExpected: false,
+	}, {
+		name:              "invalid cluster tag",
+		clusterTag:        "prod 1",
+		errorExpected:     true,
+		expectedErrSubstr: flagClusterTag,
+	}, {
+		name:              "NetBox webhook without secret",
+		netboxWebhookAddr: ":8443",
+		errorExpected:     true,
+		expectedErrSubstr: flagNetBoxWebhookSecret,
+	}, {
+		name:              "invalid controller config name",
+		controllerConfig:  "Not_A_Name",
+		errorExpected:     true,
+		expectedErrSubstr: flagControllerConfig,
+	}, {
+		name:              "negative slow reconcile threshold",
+		slowReconcile:     -time.Second,
+		errorExpected:     true,
+		expectedErrSubstr: flagSlowReconcile,
+	}, {
+		name:       "sharded",
+		shardIndex: 2,
+		shardCount: 3,
+	}, {
+		name:              "shard index out of range",
+		shardIndex:        3,
+		shardCount:        3,
+		errorExpected:     true,
+		expectedErrSubstr: flagShardIndex,
+	}, {
+		name:              "negative shard count",
+		shardCount:        -1,
+		errorExpected:     true,
+		expectedErrSubstr: flagShardCount,
+	}, {
+		name:              "shared addresses with shards",
+		sharedAddresses:   true,
+		shardCount:        2,
+		errorExpected:     true,
+		expectedErrSubstr: flagSharedAddresses,
+	}, {
+		name:                "shed",
+		queueMaxSize:        100,
+		queueOverflowPolicy: "shed",
+	}, {
+		name:              "service IP priority",
+		serviceIPPriority: true,
+	}, {
+		name:              "shared addresses with service IP priority",
+		sharedAddresses:   true,
+		serviceIPPriority: true,
+		errorExpected:     true,
+		expectedErrSubstr: flagServiceIPPriority,
+	}, {
+		name:                "NetBox webhook with shards",
+		netboxWebhookAddr:   ":8443",
+		netboxWebhookSecret: "secret",
+		shardCount:          2,
+		errorExpected:       true,
+		expectedErrSubstr:   flagNetBoxWebhookAddr,
+	}, {
+		name:        "rate budgets",
+		rateBudgets: map[string]rateBudget{"pod": {qps: 10, burst: 1}, "netboxip": {qps: 50, burst: 5}},
+	}, {
+		name:              "rate budget of unknown controller",
+		rateBudgets:       map[string]rateBudget{"node": {qps: 10, burst: 1}},
+		errorExpected:     true,
+		expectedErrSubstr: flagControllerRateLimits,
+	}, {
+		name:              "rate budget without burst",
+		rateBudgets:       map[string]rateBudget{"pod": {qps: 10}},
+		errorExpected:     true,
+		expectedErrSubstr: flagControllerRateLimits,
+	}, {
+		name:              "service rate budget without service IP priority",
+		rateBudgets:       map[string]rateBudget{"netboxip-service": {qps: 10, burst: 1}},
+		errorExpected:     true,
+		expectedErrSubstr: flagServiceIPPriority,
+	}, {
+		name:              "service rate budget",
+		serviceIPPriority: true,
+		rateBudgets:       map[string]rateBudget{"netboxip-service": {qps: 10, burst: 1}},
+	}, {
+		name:               "negative tag refresh interval",
+		tagRefreshInterval: -time.Minute,
+		errorExpected:      true,
+		expectedErrSubstr:  flagTagRefreshInterval,
+	}, {
+		name:              "negative event dedup window",
+		eventDedupWindow:  -time.Minute,
+		errorExpected:     true,
+		expectedErrSubstr: flagEventDedupWindow,
+	}, {
+		name:              "metrics certificate without key",
+		metricsTLSCert:    "/etc/metrics/tls.crt",
+		errorExpected:     true,
+		expectedErrSubstr: flagMetricsTLSKeyPath,
+	}, {
+		name:              "metrics client CA without certificate",
+		metricsClientCA:   "/etc/metrics/ca.crt",
+		errorExpected:     true,
+		expectedErrSubstr: flagMetricsTLSCertPath,
+	}, {
+		name:            "metrics TLS with client certificates",
+		metricsTLSCert:  "/etc/metrics/tls.crt",
+		metricsTLSKey:   "/etc/metrics/tls.key",
+		metricsClientCA: "/etc/metrics/ca.crt",
+		errorExpected:   false,
+	}, {
+		name:             "valid controller config name",
+		controllerConfig: "netbox-ip-controller",
+		errorExpected:    false,
+	}, {
+		name:           "retain deletion policy",
+		deletionPolicy: "retain",
+		errorExpected:  false,
+	}, {
+		name:           "deprecate deletion policy",
+		deletionPolicy: "deprecate",
+		errorExpected:  false,
+	}, {
+		name:              "negative completed pod IP TTL",
+		completedPodIPTTL: -time.Minute,
+		errorExpected:     true,
+		expectedErrSubstr: flagCompletedPodIPTTL,
+	}, {
+		name:              "negative requeue interval",
+		requeueInterval:   -time.Hour,
+		errorExpected:     true,
+		expectedErrSubstr: flagRequeueInterval,
+	}, {
+		name:                 "valid excluded owner kinds",
+		podExcludeOwnerKinds: []string{"DaemonSet", "Node"},
+		errorExpected:        false,
+	}, {
+		name:                 "invalid excluded owner kind",
+		podExcludeOwnerKinds: []string{"daemon set"},
+		errorExpected:        true,
+		expectedErrSubstr:    flagPodExcludeOwnerKinds,
+	}, {
+		name:              "invalid owner kind",
+		podOwnerKinds:     []string{"statefulset"},
+		errorExpected:     true,
+		expectedErrSubstr: flagPodOwnerKinds,
+	}, {
+		name:              "negative not ready grace period",
+		podNotReadyGrace:  -time.Minute,
+		errorExpected:     true,
+		expectedErrSubstr: flagPodNotReadyGrace,
+	}, {
+		name:          "tag job policy",
+		podJobPolicy:  "tag",
+		podJobTag:     "job",
+		errorExpected: false,
+	}, {
+		name:              "invalid job tag",
+		podJobPolicy:      "tag",
+		podJobTag:         "ci job",
+		errorExpected:     true,
+		expectedErrSubstr: flagPodJobTag,
+	}, {
+		name:              "invalid VIP tag",
+		serviceVIPs:       true,
+		serviceVIPTag:     "load balancer",
+		errorExpected:     true,
+		expectedErrSubstr: flagServiceVIPTag,
+	}, {
+		name:              "invalid job policy",
+		podJobPolicy:      "delete",
+		errorExpected:     true,
+		expectedErrSubstr: flagPodJobPolicy,
+	}, {
+		name:            "node device match by label",
+		nodeDeviceMatch: "label:example.com/device",
+	}, {
+		name:              "node device match by label without key",
+		nodeDeviceMatch:   "label:",
+		errorExpected:     true,
+		expectedErrSubstr: flagNodeDeviceMatch,
+	}, {
+		name:              "invalid node device match",
+		nodeDeviceMatch:   "asset-tag",
+		errorExpected:     true,
+		expectedErrSubstr: flagNodeDeviceMatch,
+	}, {
+		name:              "invalid deletion policy",
+		deletionPolicy:    "keep",
+		errorExpected:     true,
+		expectedErrSubstr: flagDeletionPolicy,
+	}, {
+		name:                "drop labels description strategy",
+		descriptionStrategy: "drop-labels-by-priority",
+		errorExpected:       false,
+	}, {
+		name:                "invalid description strategy",
+		descriptionStrategy: "shorten",
+		errorExpected:       true,
+		expectedErrSubstr:   flagDescriptionStrategy,
+	}, {
+		name:            "valid custom fields",
+		podCustomFields: map[string]string{"cost_center": "example.com/cost-center"},
+		errorExpected:   false,
+	}, {
+		name:              "invalid custom field",
+		podCustomFields:   map[string]string{"cost center": "example.com/cost-center"},
+		errorExpected:     true,
+		expectedErrSubstr: flagPodCustomFields,
+	}, {
+		name:              "UID custom field",
+		podCustomFields:   map[string]string{"netbox_ip_controller_uid": "example.com/uid"},
+		errorExpected:     true,
+		expectedErrSubstr: flagPodCustomFields,
+	}, {
+		name:              "invalid custom field annotation",
+		podCustomFields:   map[string]string{"cost_center": "example.com/cost center"},
+		errorExpected:     true,
+		expectedErrSubstr: flagPodCustomFields,
+	}, {
+		name:                 "invalid selector custom field",
+		serviceSelectorField: "k8s selector",
+		errorExpected:        true,
+		expectedErrSubstr:    flagServiceSelectorField,
+	}, {
+		name:             "resolve load balancer hostnames",
+		serviceLBIPs:     true,
+		serviceLBResolve: true,
+		serviceLBRefresh: time.Minute,
+		errorExpected:    false,
+	}, {
+		name:              "resolve load balancer hostnames without load balancer IPs",
+		serviceLBResolve:  true,
+		serviceLBRefresh:  time.Minute,
+		errorExpected:     true,
+		expectedErrSubstr: flagServiceLBIPs,
+	}, {
+		name:          "load balancer NAT with VIPs",
+		serviceLBNAT:  true,
+		serviceVIPs:   true,
+		serviceVIPTag: "vip",
+		errorExpected: false,
+	}, {
+		name:              "load balancer NAT without load balancer IPs",
+		serviceLBNAT:      true,
+		errorExpected:     true,
+		expectedErrSubstr: flagServiceLBNAT,
+	}, {
+		name:             "load balancer IP pool",
+		serviceLBIPPool:  netip.MustParsePrefix("203.0.113.0/24"),
+		serviceLBIPAnnot: "metallb.universe.tf/loadBalancerIPs",
+		serviceVIPs:      true,
+		serviceVIPTag:    "vip",
+		errorExpected:    false,
+	}, {
+		name:              "load balancer IP pool without VIP annotations",
+		serviceLBIPPool:   netip.MustParsePrefix("203.0.113.0/24"),
+		serviceLBIPAnnot:  "metallb.universe.tf/loadBalancerIPs",
+		errorExpected:     true,
+		expectedErrSubstr: flagServiceVIPs,
+	}, {
+		name:              "unknown load balancer IP annotation",
+		serviceLBIPPool:   netip.MustParsePrefix("203.0.113.0/24"),
+		serviceLBIPAnnot:  "example.com/loadBalancerIPs",
+		serviceVIPs:       true,
+		serviceVIPTag:     "vip",
+		errorExpected:     true,
+		expectedErrSubstr: flagServiceLBIPAnnot,
+	}, {
+		name:           "namespace prefixes",
+		nsPrefixParent: netip.MustParsePrefix("fd00::/48"),
+		nsPrefixLength: 64,
+		errorExpected:  false,
+	}, {
+		name:              "namespace prefix length not longer than parent",
+		nsPrefixParent:    netip.MustParsePrefix("10.0.0.0/16"),
+		nsPrefixLength:    16,
+		errorExpected:     true,
+		expectedErrSubstr: flagNSPrefixLength,
+	}, {
+		name:              "namespace prefix length longer than addresses",
+		nsPrefixParent:    netip.MustParsePrefix("10.0.0.0/16"),
+		nsPrefixLength:    64,
+		errorExpected:     true,
+		expectedErrSubstr: flagNSPrefixLength,
+	}, {
+		name:     "IPv4 only",
+		skipIPv6: true,
+	}, {
+		name:              "no IP family",
+		skipIPv4:          true,
+		skipIPv6:          true,
+		errorExpected:     true,
+		expectedErrSubstr: flagPublishIPv4,
+	}, {
+		name:              "zero load balancer hostname refresh",
+		serviceLBIPs:      true,
+		serviceLBResolve:  true,
+		errorExpected:     true,
+		expectedErrSubstr: flagServiceLBRefresh,
 	}}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			cfg := rootConfig{
-				podLabels:     test.podLabels,
-				serviceLabels: test.serviceLabels,
+				podLabels:            test.podLabels,
+				serviceLabels:        test.serviceLabels,
+				clusterTag:           test.clusterTag,
+				netboxWebhookAddr:    test.netboxWebhookAddr,
+				netboxWebhookSecret:  test.netboxWebhookSecret,
+				controllerConfig:     test.controllerConfig,
+				deletionPolicy:       test.deletionPolicy,
+				completedPodIPTTL:    test.completedPodIPTTL,
+				requeueInterval:      test.requeueInterval,
+				podExcludeOwnerKinds: test.podExcludeOwnerKinds,
+				podOwnerKinds:        test.podOwnerKinds,
+				podNotReadyGrace:     test.podNotReadyGrace,
+				podJobPolicy:         test.podJobPolicy,
+				podJobTag:            test.podJobTag,
+				nodeDeviceMatch:      test.nodeDeviceMatch,
+				descriptionStrategy:  test.descriptionStrategy,
+				podCustomFields:      test.podCustomFields,
+				serviceLBIPs:         test.serviceLBIPs,
+				serviceLBNAT:         test.serviceLBNAT,
+				serviceLBIPPool:      test.serviceLBIPPool,
+				serviceLBIPAnnot:     test.serviceLBIPAnnot,
+				publishIPv4:          !test.skipIPv4,
+				publishIPv6:          !test.skipIPv6,
+				serviceLBResolve:     test.serviceLBResolve,
+				serviceLBRefresh:     test.serviceLBRefresh,
+				serviceVIPs:          test.serviceVIPs,
+				serviceVIPTag:        test.serviceVIPTag,
+				serviceSelectorField: test.serviceSelectorField,
+				nsPrefixParent:       test.nsPrefixParent,
+				nsPrefixLength:       test.nsPrefixLength,
+				metricsTLSCert:       test.metricsTLSCert,
+				metricsTLSKey:        test.metricsTLSKey,
+				metricsClientCA:      test.metricsClientCA,
+				eventDedupWindow:     test.eventDedupWindow,
+				slowReconcile:        test.slowReconcile,
+				sharedAddresses:      test.sharedAddresses,
+				shardIndex:           test.shardIndex,
+				shardCount:           test.shardCount,
+				serviceIPPriority:    test.serviceIPPriority,
+				queueMaxSize:         test.queueMaxSize,
+				queueOverflowPolicy:  test.queueOverflowPolicy,
+				queueParkDelay:       test.queueParkDelay,
+				rateBudgets:          test.rateBudgets,
+				tagRefreshInterval:   test.tagRefreshInterval,
+			}
+			if cfg.deletionPolicy == "" {
+				cfg.deletionPolicy = "delete"
+			}
+			if cfg.descriptionStrategy == "" {
+				cfg.descriptionStrategy = "truncate"
+			}
+			if cfg.podJobPolicy == "" {
+				cfg.podJobPolicy = "publish"
+			}
+			if cfg.nodeDeviceMatch == "" {
+				cfg.nodeDeviceMatch = "name"
+			}
+			if cfg.shardCount == 0 {
+				cfg.shardCount = 1
 			}
 
 			err := cfg.validate()
@@ -215,6 +1106,20 @@ func TestRootConfigValidation(t *testing.T) {
 	}
 }
 
+func TestMetricsTLSOptionsInvalidClientCA(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := metricsTLSOptions(nil, path); err == nil {
+		t.Error("expected error for invalid client CA certificates")
+	}
+	if _, err := metricsTLSOptions(nil, filepath.Join(t.TempDir(), "missing.crt")); err == nil {
+		t.Error("expected error for missing client CA certificates")
+	}
+}
+
 func TestSanitizeStringSlices(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -0,0 +1,202 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	crd "github.com/digitalocean/netbox-ip-controller/api/netbox"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "go.uber.org/zap"
+	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+)
+
+// crdDeletionTimeout is how long uninstall waits for the
+// NetBoxIP CRD to be deleted, with all its NetBoxIPs.
+const crdDeletionTimeout = 2 * time.Minute
+
+func newUninstallCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Removes everything the controller created: IPs in NetBox, custom resources, and custom resource definitions, and verifies that no IPs managed by the controller remain in NetBox.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			v := viper.New()
+			v.AutomaticEnv()
+			v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+			if err := v.BindPFlags(cmd.Flags()); err != nil {
+				return fmt.Errorf("binding flags: %w", err)
+			}
+			allowedPrefixes, err := parseAllowedPrefixes(v.GetString(flagAllowedPrefixes))
+			if err != nil {
+				return err
+			}
+			if concurrency := v.GetInt(flagConcurrency); concurrency < 1 {
+				return fmt.Errorf("%s value %d is invalid: must be at least 1", flagConcurrency, concurrency)
+			}
+
+			ctx := signals.SetupSignalHandler()
+			return uninstall(ctx, globalCfg, cleanOptions{
+				allowedPrefixes: allowedPrefixes,
+				concurrency:     v.GetInt(flagConcurrency),
+				branch:          v.GetBool(flagBranch),
+			})
+		},
+	}
+
+	cmd.Flags().String(flagAllowedPrefixes, "", "comma-separated list of CIDRs; if set, IPs in NetBox outside of these prefixes are not deleted")
+	cmd.Flags().Int(flagConcurrency, 1, "number of NetBoxIPs cleaned at the same time; requests to NetBox are still limited by --netbox-qps and --netbox-burst")
+	cmd.Flags().Bool(flagBranch, false, branchFlagUsage)
+
+	return cmd
+}
+
+// uninstall cleans the NetBoxIPs and their IPs in NetBox, removes the
+// finalizers of NetBoxIPs that are stuck, e.g. because they were created by
+// a controller that is still running, deletes the CRDs of the controller,
+// and verifies that no IPs managed by the controller remain in NetBox.
+// Like clean, it can be run again if it fails. The controller registers
+// no admission webhooks, so there are none to remove.
+func uninstall(ctx context.Context, cfg *globalConfig, opts cleanOptions) error {
+	defer cfg.logger.Sync()
+
+	scheme := runtime.NewScheme()
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	kubeClient, err := client.New(cfg.kubeConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return fmt.Errorf("creating k8s client: %w", err)
+	}
+	extensionsClient, err := apiextensionsclient.NewForConfig(cfg.kubeConfig)
+	if err != nil {
+		return fmt.Errorf("creating API extensions client: %w", err)
+	}
+	crds := extensionsClient.ApiextensionsV1().CustomResourceDefinitions()
+
+	if _, err := crds.Get(ctx, crd.NetBoxIPCRDName, metav1.GetOptions{}); kubeerrors.IsNotFound(err) {
+		cfg.logger.Info("NetBoxIP custom resource already deleted")
+	} else if err != nil {
+		return fmt.Errorf("getting NetBoxIP custom resource: %w", err)
+	} else {
+		if err := clean(ctx, cfg, opts); err != nil {
+			return fmt.Errorf("cleaning: %w", err)
+		}
+		if err := stripFinalizers(ctx, cfg.logger, kubeClient); err != nil {
+			return err
+		}
+
+		cfg.logger.Info("waiting for NetBoxIP custom resource to be deleted")
+		if err := wait.PollUntilContextTimeout(ctx, time.Second, crdDeletionTimeout, true, func(ctx context.Context) (bool, error) {
+			_, err := crds.Get(ctx, crd.NetBoxIPCRDName, metav1.GetOptions{})
+			if kubeerrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}); err != nil {
+			return fmt.Errorf("waiting for NetBoxIP custom resource to be deleted: %w", err)
+		}
+	}
+
+	if err := crds.Delete(ctx, crd.NetBoxIPControllerConfigCRDName, metav1.DeleteOptions{}); err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("deleting NetBoxIPControllerConfig custom resource: %w", err)
+	}
+
+	netboxClient, closeAudit, err := newNetBoxClient(cfg, opts.allowedPrefixes)
+	if err != nil {
+		return fmt.Errorf("creating netbox client: %w", err)
+	}
+	defer closeAudit()
+	remaining, err := remainingIPs(ctx, netboxClient, opts.allowedPrefixes)
+	if err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		for _, ip := range remaining {
+			cfg.logger.Error("IP managed by the controller remains in NetBox",
+				log.String("uid", string(ip.UID)), log.Int64("id", ip.ID), log.Any("ip", ip.Address))
+		}
+		return fmt.Errorf("%d IPs managed by the controller remain in NetBox: run prune to delete them", len(remaining))
+	}
+
+	cfg.logger.Info("uninstalled")
+	return nil
+}
+
+// stripFinalizers removes the finalizer of all remaining NetBoxIPs.
+func stripFinalizers(ctx context.Context, logger *log.Logger, kubeClient client.Client) error {
+	var ips []v1beta1.NetBoxIP
+	err := listNetBoxIPs(ctx, kubeClient, func(ip *v1beta1.NetBoxIP) {
+		if controllerutil.ContainsFinalizer(ip, netboxctrl.IPFinalizer) {
+			ip.ManagedFields = nil
+			ips = append(ips, *ip)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs multierror.Error
+	for i := range ips {
+		ip := &ips[i]
+
+		patch := client.MergeFrom(ip.DeepCopy())
+		controllerutil.RemoveFinalizer(ip, netboxctrl.IPFinalizer)
+		if err := kubeClient.Patch(ctx, ip, patch); kubeerrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			multierror.Append(&errs, fmt.Errorf("removing finalizer of netboxip %s/%s: %w", ip.Namespace, ip.Name, err))
+			continue
+		}
+		logger.Info("removed finalizer of stuck netboxip", log.String("namespace", ip.Namespace), log.String("name", ip.Name))
+	}
+	return errs.ErrorOrNil()
+}
+
+// remainingIPs returns the IPs managed by the controller that remain
+// in NetBox, except for those outside of the allowed prefixes, if any,
+// which are kept on purpose.
+func remainingIPs(ctx context.Context, netboxClient netbox.Client, allowedPrefixes []netip.Prefix) ([]netbox.IPAddress, error) {
+	ips, err := netboxClient.ListIPs(ctx, netbox.IPFilter{Managed: true})
+	if err != nil {
+		return nil, fmt.Errorf("listing IPs in NetBox: %w", err)
+	}
+
+	var remaining []netbox.IPAddress
+	for _, ip := range ips {
+		if prefixesContain(allowedPrefixes, netip.Addr(ip.Address)) {
+			remaining = append(remaining, ip)
+		}
+	}
+	return remaining, nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStripFinalizers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(&v1beta1.NetBoxIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "stuck",
+			Namespace:  "test",
+			Finalizers: []string{netboxctrl.IPFinalizer, "example.com/other"},
+		},
+		Spec: v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("192.168.0.1")},
+	}).Build()
+
+	if err := stripFinalizers(context.Background(), log.L(), kubeClient); err != nil {
+		t.Fatalf("removing finalizers: %s", err)
+	}
+
+	var ip v1beta1.NetBoxIP
+	if err := kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "test", Name: "stuck"}, &ip); err != nil {
+		t.Fatal(err)
+	}
+	if len(ip.Finalizers) != 1 || ip.Finalizers[0] != "example.com/other" {
+		t.Errorf("expected only the finalizer of the controller to be removed, got %v", ip.Finalizers)
+	}
+}
+
+func TestRemainingIPs(t *testing.T) {
+	netboxClient := netbox.NewFakeClient(nil, map[netbox.UID]netbox.IPAddress{
+		"inside":  {UID: "inside", Address: netbox.IP(netip.MustParseAddr("10.0.0.1"))},
+		"outside": {UID: "outside", Address: netbox.IP(netip.MustParseAddr("192.168.0.1"))},
+		// not managed by the controller
+		"": {Address: netbox.IP(netip.MustParseAddr("10.0.0.2"))},
+	})
+
+	remaining, err := remainingIPs(context.Background(), netboxClient, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	if err != nil {
+		t.Fatalf("listing remaining IPs: %s", err)
+	}
+	if len(remaining) != 1 || remaining[0].UID != "inside" {
+		t.Errorf("expected only the managed IP inside of the allowed prefixes to remain, got %v", remaining)
+	}
+}
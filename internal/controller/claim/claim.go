@@ -0,0 +1,370 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/digitalocean/netbox-ip-controller/internal/metrics"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimecontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// claimClient is a client of an IPAM system that can allocate IPs.
+type claimClient interface {
+	netbox.Client
+	netbox.Allocator
+}
+
+// ControllerName is the name of the NetBoxIPClaim controller, which
+// names its workqueue and its rate budget of requests to NetBox.
+const ControllerName = "netboxipclaim"
+
+type controller struct {
+	reconciler *reconciler
+	// shard of namespaces to reconcile objects in, if sharded
+	shard *ctrl.Shard
+	// limit of the workqueue, if any
+	queueLimit *ctrl.QueueLimit
+	// rate limiter of requests to NetBox, if not shared
+	rateBudget *rate.Limiter
+}
+
+// New returns a new Controller for NetBoxIPClaim resource.
+func New(opts ...ctrl.Option) (ctrl.Controller, error) {
+	var s ctrl.Settings
+	for _, o := range opts {
+		if err := o(&s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.KubeClient == nil {
+		return nil, errors.New("kubernetes client is required for netboxipclaim controller")
+	}
+	if s.NetBoxClient == nil {
+		return nil, errors.New("netbox client is required for netboxipclaim controller")
+	}
+	netboxClient, ok := s.NetBoxClient.(claimClient)
+	if !ok {
+		return nil, errors.New("the IPAM backend does not support allocating IPs from prefixes")
+	}
+
+	logger := log.L()
+	if s.Logger != nil {
+		logger = s.Logger
+	}
+
+	var recorder record.EventRecorder = &record.FakeRecorder{}
+	if s.Recorder != nil {
+		recorder = s.Recorder
+	}
+
+	return &controller{
+		shard:      s.Shard,
+		queueLimit: s.QueueLimit,
+		rateBudget: s.RateBudgets[ControllerName],
+		reconciler: &reconciler{
+			kubeClient:      s.KubeClient,
+			netboxClient:    netboxClient,
+			log:             logger.With(log.String("reconciler", ControllerName)),
+			recorder:        recorder,
+			allowedPrefixes: s.AllowedPrefixes,
+			deletionPolicy:  s.DeletionPolicy,
+			requeueAfter:    s.RequeueInterval,
+			slowThreshold:   s.SlowReconcileThreshold,
+		},
+	}, nil
+}
+
+// AddToManager attaches the controller to the given manager.
+func (c *controller) AddToManager(mgr manager.Manager) error {
+	q := ctrl.NewQueueTracker(ControllerName, c.queueLimit)
+	return builder.
+		ControllerManagedBy(mgr).
+		Named(ControllerName).
+		Watches(&v1beta1.NetBoxIPClaim{}, q.Handler(&handler.EnqueueRequestForObject{})).
+		WithEventFilter(ctrl.OnCreateAndUpdateFilter).
+		// with > 1 concurrent reconciles, claims of the same prefix
+		// would be racing for the same available IP in NetBox
+		WithOptions(runtimecontroller.Options{MaxConcurrentReconciles: 1}).
+		Complete(q.Reconciler(ctrl.Budgeted(c.rateBudget, ctrl.Sharded(c.shard, c.reconciler))))
+}
+
+type reconciler struct {
+	kubeClient      client.Client
+	netboxClient    claimClient
+	log             *log.Logger
+	recorder        record.EventRecorder
+	allowedPrefixes []netip.Prefix
+	// what happens to IPs in NetBox when NetBoxIPClaims are deleted
+	deletionPolicy string
+	// how often NetBoxIPClaims are reconciled without changes, if at all
+	requeueAfter time.Duration
+	// slowThreshold, if greater than 0, is how long a reconciliation
+	// may take before a warning with a breakdown of its time is logged
+	slowThreshold time.Duration
+}
+
+// Reconcile is called on every change of a NetBoxIPClaim. It allocates an IP
+// from the prefix of the claim, unless it has one already, which is then kept
+// in sync with the spec of the claim, and removed once the claim is deleted.
+func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ll := r.log.With(
+		log.String("namespace", req.Namespace),
+		log.String("name", req.Name),
+	)
+	ctx, ll = ctrl.WithRequestID(ctx, ll)
+
+	ll.Info("reconciling netboxipclaim")
+
+	ctx, outcome := ctrl.WithOutcome(ctx)
+	result, err := r.reconcileClaim(ctx, ll, req)
+	outcome.Log(ll, err)
+	outcome.WarnIfSlow(ll, r.slowThreshold)
+	return result, err
+}
+
+func (r *reconciler) reconcileClaim(ctx context.Context, ll *log.Logger, req reconcile.Request) (reconcile.Result, error) {
+	var claim v1beta1.NetBoxIPClaim
+	err := r.kubeClient.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: req.Name}, &claim)
+	if err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return reconcile.Result{}, fmt.Errorf("retrieving netboxipclaim: %w", err)
+		}
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonNotFound)
+		return reconcile.Result{}, nil
+	}
+
+	ll = ll.With(
+		log.String("uid", string(claim.UID)),
+		log.Stringer("prefix", claim.Spec.Prefix),
+	)
+	if claim.Allocated() {
+		ll = ll.With(log.Stringer("ip", claim.Status.Address))
+	}
+
+	if !claim.DeletionTimestamp.IsZero() {
+		if err := r.removeIP(ctx, ll, &claim); err != nil {
+			return reconcile.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(&claim, netboxctrl.IPFinalizer)
+		if err := r.kubeClient.Update(ctx, &claim); err != nil {
+			return reconcile.Result{}, fmt.Errorf("removing finalizer: %w", err)
+		}
+		return reconcile.Result{}, nil
+	}
+
+	// the finalizer is added before allocating, so
+	// that no allocated IP is left behind in NetBox
+	if !controllerutil.ContainsFinalizer(&claim, netboxctrl.IPFinalizer) {
+		controllerutil.AddFinalizer(&claim, netboxctrl.IPFinalizer)
+		if err := r.kubeClient.Update(ctx, &claim); err != nil {
+			return reconcile.Result{}, fmt.Errorf("setting finalizer: %w", err)
+		}
+	}
+
+	if !r.allowed(&claim) {
+		// no point in retrying, since the prefix is immutable
+		ll.Warn("not allocating IP: prefix is outside of the allowed prefixes")
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonDisallowed)
+		return reconcile.Result{}, nil
+	}
+
+	ip := ipAddress(&claim)
+	if !claim.Allocated() {
+		return r.allocate(ctx, ll, &claim, ip)
+	}
+
+	ip.ID = claim.Status.NetBoxID
+	ip.Address = netbox.IP(claim.Status.Address)
+	ip.PrefixLength = int(claim.Status.PrefixLength)
+	upserted, result, err := r.netboxClient.UpsertIP(ctx, ip)
+	if errors.Is(err, netbox.ErrDisallowedIP) {
+		// e.g. the allocated IP was moved in NetBox
+		ll.Warn("not upserting IP: outside of the allowed prefixes in NetBox")
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonDisallowed)
+		return ctrl.Requeue(r.requeueAfter), nil
+	}
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("upserting IP: %w", err)
+	}
+	if result != netbox.UpsertUnchanged {
+		if err := r.updateStatus(ctx, &claim, upserted); err != nil {
+			return reconcile.Result{}, err
+		}
+		ll.Info("upserted IP", log.Int64("id", upserted.ID))
+		outcome := ctrl.OutcomeUpdated
+		if result == netbox.UpsertCreated {
+			// e.g. deleted in NetBox behind the controller's back
+			outcome = ctrl.OutcomeCreated
+		}
+		ctrl.RecordOutcome(ctx, outcome, ctrl.ReasonPublished)
+	}
+	return ctrl.Requeue(r.requeueAfter), nil
+}
+
+// allocate allocates an IP for the claim, and records it in its status.
+func (r *reconciler) allocate(ctx context.Context, ll *log.Logger, claim *v1beta1.NetBoxIPClaim, ip *netbox.IPAddress) (reconcile.Result, error) {
+	// the IP may have been allocated before, but not recorded
+	// in the status, e.g. because patching the status failed
+	allocated, err := r.netboxClient.GetIP(ctx, ip.UID)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("checking for allocated IP: %w", err)
+	}
+	if allocated != nil {
+		ll.Info("found IP allocated before", log.Int64("id", allocated.ID))
+	} else {
+		allocated, err = r.netboxClient.AllocateIP(ctx, claim.Spec.Prefix, ip)
+		if errors.Is(err, netbox.ErrPrefixNotFound) || errors.Is(err, netbox.ErrPrefixFull) {
+			// retried with a backoff, since the prefix may be created
+			// or have IPs freed up in NetBox without the claim changing
+			r.recorder.Eventf(claim, corev1.EventTypeWarning, "AllocationFailed",
+				"Failed to allocate an IP from prefix %s: %s", claim.Spec.Prefix, err)
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeError, ctrl.ReasonAllocationFailed)
+		}
+		if err != nil {
+			return reconcile.Result{}, fmt.Errorf("allocating IP: %w", err)
+		}
+	}
+
+	if err := r.updateStatus(ctx, claim, allocated); err != nil {
+		return reconcile.Result{}, err
+	}
+	ll.Info("allocated IP", log.Stringer("ip", claim.Status.Address), log.Int64("id", allocated.ID))
+	r.recorder.Eventf(claim, corev1.EventTypeNormal, "Allocated",
+		"Allocated IP %s from prefix %s in NetBox", claim.Status.Address, claim.Spec.Prefix)
+	ctrl.RecordOutcome(ctx, ctrl.OutcomeCreated, ctrl.ReasonAllocated)
+	return ctrl.Requeue(r.requeueAfter), nil
+}
+
+// removeIP removes the IP of the deleted claim from NetBox,
+// according to the deletion policy.
+func (r *reconciler) removeIP(ctx context.Context, ll *log.Logger, claim *v1beta1.NetBoxIPClaim) error {
+	uid := netbox.UID(claim.UID)
+	switch r.deletionPolicy {
+	case ctrl.DeletionPolicyRetain:
+		if err := r.netboxClient.ReleaseIP(ctx, uid); errors.Is(err, netbox.ErrDisallowedIP) {
+			ll.Warn("not releasing IP: outside of the allowed prefixes in NetBox")
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonDisallowed)
+		} else if err != nil {
+			return fmt.Errorf("releasing IP: %w", err)
+		} else {
+			ll.Info("released IP: netboxipclaim was removed")
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeDeleted, ctrl.ReasonReleased)
+		}
+	case ctrl.DeletionPolicyDeprecate:
+		note := fmt.Sprintf("(deleted %s)", claim.DeletionTimestamp.UTC().Format(time.RFC3339))
+		if err := r.netboxClient.DeprecateIP(ctx, uid, note); errors.Is(err, netbox.ErrDisallowedIP) {
+			ll.Warn("not deprecating IP: outside of the allowed prefixes in NetBox")
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonDisallowed)
+		} else if err != nil {
+			return fmt.Errorf("deprecating IP: %w", err)
+		} else {
+			ll.Info("deprecated IP: netboxipclaim was removed")
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeDeleted, ctrl.ReasonDeprecated)
+		}
+	default:
+		if err := r.netboxClient.DeleteIP(ctx, uid); errors.Is(err, netbox.ErrDisallowedIP) {
+			ll.Warn("not deleting IP: outside of the allowed prefixes in NetBox")
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonDisallowed)
+		} else if err != nil {
+			return fmt.Errorf("deleting IP: %w", err)
+		} else {
+			ll.Info("deleted IP: netboxipclaim was removed")
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeDeleted, ctrl.ReasonRemoved)
+		}
+	}
+	return nil
+}
+
+// updateStatus records the address, ID and URL of ip in the status of the claim.
+func (r *reconciler) updateStatus(ctx context.Context, claim *v1beta1.NetBoxIPClaim, ip *netbox.IPAddress) error {
+	status := claim.Status
+	status.Address = netip.Addr(ip.Address)
+	status.PrefixLength = int32(ip.PrefixLength)
+	if ip.ID != 0 {
+		status.NetBoxID = ip.ID
+		status.NetBoxURL = ip.WebURL
+	}
+	if status == claim.Status {
+		return nil
+	}
+
+	patch := client.MergeFrom(claim.DeepCopy())
+	claim.Status = status
+	if err := r.kubeClient.Status().Patch(ctx, claim, patch); err != nil {
+		return fmt.Errorf("updating netboxipclaim status: %w", err)
+	}
+	return nil
+}
+
+// allowed returns true if IPs may be allocated from the prefix of the claim,
+// that is, if there are no allowed prefixes or the prefix is within one of
+// them. Otherwise, it emits an event and increments the disallowed IPs metric.
+func (r *reconciler) allowed(claim *v1beta1.NetBoxIPClaim) bool {
+	if len(r.allowedPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range r.allowedPrefixes {
+		if prefix.Bits() <= claim.Spec.Prefix.Bits() && prefix.Contains(claim.Spec.Prefix.Addr()) {
+			return true
+		}
+	}
+
+	metrics.IncrementDisallowedIPs("allocate")
+	r.recorder.Eventf(claim, corev1.EventTypeWarning, "DisallowedPrefix",
+		"Refusing to allocate an IP from prefix %s in NetBox: it is outside of the allowed prefixes", claim.Spec.Prefix)
+	return false
+}
+
+// ipAddress returns the IP of the claim, without an address.
+func ipAddress(claim *v1beta1.NetBoxIPClaim) *netbox.IPAddress {
+	ip := &netbox.IPAddress{
+		UID:         netbox.UID(claim.UID),
+		DNSName:     claim.Spec.DNSName,
+		Description: claim.Spec.Description,
+	}
+	for _, t := range claim.Spec.Tags {
+		ip.Tags = append(ip.Tags, netbox.Tag{Name: t.Name, Slug: t.Slug})
+	}
+	if claim.Spec.Tenant != "" {
+		ip.Tenant = &netbox.Tenant{Slug: claim.Spec.Tenant}
+	}
+	if claim.Spec.VRF != "" {
+		ip.VRF = &netbox.VRF{Name: claim.Spec.VRF}
+	}
+	return ip
+}
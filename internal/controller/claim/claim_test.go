@@ -0,0 +1,213 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claim
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const uid = "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"
+
+var req = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "foo"}}
+
+func newClaim(prefix string) *v1beta1.NetBoxIPClaim {
+	return &v1beta1.NetBoxIPClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      req.Name,
+			Namespace: req.Namespace,
+			UID:       uid,
+		},
+		Spec: v1beta1.NetBoxIPClaimSpec{
+			Prefix:  netip.MustParsePrefix(prefix),
+			DNSName: "foo.example.com",
+			Tags:    []v1beta1.Tag{{Name: "claimed", Slug: "claimed"}},
+		},
+	}
+}
+
+func newReconciler(t *testing.T, netboxClient netbox.Client, objs ...*v1beta1.NetBoxIPClaim) *reconciler {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+	b := fakeclient.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&v1beta1.NetBoxIPClaim{})
+	for _, obj := range objs {
+		b = b.WithObjects(obj)
+	}
+
+	c, err := New(
+		ctrl.WithKubernetesClient(b.Build()),
+		ctrl.WithNetBoxClient(netboxClient),
+		ctrl.WithLogger(log.NewNop()),
+		ctrl.WithEventRecorder(record.NewFakeRecorder(10)),
+		ctrl.WithAllowedPrefixes([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*controller).reconciler
+}
+
+func TestReconcileAllocatesIP(t *testing.T) {
+	taken := netbox.UID("d2b9a3f0-0b7c-4b6e-9f4a-1c2d3e4f5a6b")
+	netboxClient := netbox.NewFakeClient(nil, map[netbox.UID]netbox.IPAddress{
+		taken: {UID: taken, Address: netbox.IP(netip.MustParseAddr("10.0.0.1"))},
+	})
+	r := newReconciler(t, netboxClient, newClaim("10.0.0.0/24"))
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %s", err)
+	}
+
+	var claim v1beta1.NetBoxIPClaim
+	if err := r.kubeClient.Get(context.Background(), req.NamespacedName, &claim); err != nil {
+		t.Fatal(err)
+	}
+	if claim.Status.Address != netip.MustParseAddr("10.0.0.2") || claim.Status.PrefixLength != 24 {
+		t.Errorf("want 10.0.0.2/24 allocated, got %s/%d", claim.Status.Address, claim.Status.PrefixLength)
+	}
+	if len(claim.Finalizers) != 1 || claim.Finalizers[0] != netboxctrl.IPFinalizer {
+		t.Errorf("want finalizer, got %v", claim.Finalizers)
+	}
+
+	// changes of the spec are applied to the allocated IP
+	claim.Spec.DNSName = "bar.example.com"
+	if err := r.kubeClient.Update(context.Background(), &claim); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %s", err)
+	}
+
+	ip, err := netboxClient.GetIP(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if netip.Addr(ip.Address) != netip.MustParseAddr("10.0.0.2") || ip.DNSName != "bar.example.com" {
+		t.Errorf("want 10.0.0.2 with DNS name bar.example.com, got %s with %q", netip.Addr(ip.Address), ip.DNSName)
+	}
+	if len(ip.Tags) != 1 || ip.Tags[0].Name != "claimed" {
+		t.Errorf("want tag claimed, got %v", ip.Tags)
+	}
+}
+
+func TestReconcileAdoptsAllocatedIP(t *testing.T) {
+	// allocated, but not recorded in the status
+	netboxClient := netbox.NewFakeClient(nil, map[netbox.UID]netbox.IPAddress{
+		uid: {UID: uid, Address: netbox.IP(netip.MustParseAddr("10.0.0.7")), PrefixLength: 24},
+	})
+	r := newReconciler(t, netboxClient, newClaim("10.0.0.0/24"))
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %s", err)
+	}
+
+	var claim v1beta1.NetBoxIPClaim
+	if err := r.kubeClient.Get(context.Background(), req.NamespacedName, &claim); err != nil {
+		t.Fatal(err)
+	}
+	if claim.Status.Address != netip.MustParseAddr("10.0.0.7") {
+		t.Errorf("want 10.0.0.7 adopted, got %s", claim.Status.Address)
+	}
+}
+
+func TestReconcileFailedAllocation(t *testing.T) {
+	tests := []struct {
+		name          string
+		prefix        string
+		expectedError bool
+	}{{
+		name:          "prefix full",
+		prefix:        "10.0.0.0/31",
+		expectedError: true,
+	}, {
+		name:          "prefix not allowed",
+		prefix:        "192.168.0.0/24",
+		expectedError: false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			netboxClient := netbox.NewFakeClient(nil, map[netbox.UID]netbox.IPAddress{
+				"a": {Address: netbox.IP(netip.MustParseAddr("10.0.0.0"))},
+				"b": {Address: netbox.IP(netip.MustParseAddr("10.0.0.1"))},
+			})
+			r := newReconciler(t, netboxClient, newClaim(test.prefix))
+
+			_, err := r.Reconcile(context.Background(), req)
+			if (err != nil) != test.expectedError {
+				t.Errorf("want error: %v, got %v", test.expectedError, err)
+			}
+
+			var claim v1beta1.NetBoxIPClaim
+			if err := r.kubeClient.Get(context.Background(), req.NamespacedName, &claim); err != nil {
+				t.Fatal(err)
+			}
+			if claim.Allocated() {
+				t.Errorf("want no IP allocated, got %s", claim.Status.Address)
+			}
+		})
+	}
+}
+
+func TestReconcileDeletedClaim(t *testing.T) {
+	now := metav1.NewTime(time.Now())
+	claim := newClaim("10.0.0.0/24")
+	claim.Finalizers = []string{netboxctrl.IPFinalizer}
+	claim.DeletionTimestamp = &now
+	claim.Status.Address = netip.MustParseAddr("10.0.0.2")
+
+	netboxClient := netbox.NewFakeClient(nil, map[netbox.UID]netbox.IPAddress{
+		uid: {UID: uid, Address: netbox.IP(netip.MustParseAddr("10.0.0.2"))},
+	})
+	r := newReconciler(t, netboxClient, claim)
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %s", err)
+	}
+
+	ip, err := netboxClient.GetIP(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != nil {
+		t.Errorf("want IP deleted, got %+v", ip)
+	}
+	// without the finalizer, the claim is gone
+	if err := r.kubeClient.Get(context.Background(), req.NamespacedName, claim); !kubeerrors.IsNotFound(err) {
+		t.Errorf("want claim deleted, got %v", err)
+	}
+}
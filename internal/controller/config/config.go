@@ -0,0 +1,271 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Defaults are the settings of the pod or service controller,
+// as set by flags, that apply unless overridden by the
+// NetBoxIPControllerConfig.
+type Defaults struct {
+	// Tags are the names of the tags added to every IP.
+	Tags   []string
+	Labels map[string]bool
+}
+
+// Config describes what the controller reconfigures.
+type Config struct {
+	// Name is the name of the NetBoxIPControllerConfig to watch.
+	Name string
+	// Pods and Services are the live settings of
+	// the pod and service controllers respectively.
+	Pods            *ctrl.LiveSettings
+	PodDefaults     Defaults
+	Services        *ctrl.LiveSettings
+	ServiceDefaults Defaults
+	// RateLimiters limit the requests to NetBox, by default
+	// with NetBoxQPS and NetBoxBurst.
+	RateLimiters []*rate.Limiter
+	NetBoxQPS    rate.Limit
+	NetBoxBurst  int
+}
+
+// ControllerName is the name of the controller config controller.
+const ControllerName = "config"
+
+type controller struct {
+	name       string
+	reconciler *reconciler
+}
+
+// New returns a new Controller for NetBoxIPControllerConfig resource.
+func New(cfg Config, opts ...ctrl.Option) (ctrl.Controller, error) {
+	var s ctrl.Settings
+	for _, o := range opts {
+		if err := o(&s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.KubeClient == nil {
+		return nil, errors.New("kubernetes client is required for config controller")
+	}
+	if s.NetBoxClient == nil {
+		return nil, errors.New("netbox client is required for config controller")
+	}
+	if cfg.Name == "" {
+		return nil, errors.New("name of the controller config is required for config controller")
+	}
+	if cfg.Pods == nil || cfg.Services == nil {
+		return nil, errors.New("pod and service settings are required for config controller")
+	}
+
+	logger := log.L()
+	if s.Logger != nil {
+		logger = s.Logger
+	}
+
+	var recorder record.EventRecorder = &record.FakeRecorder{}
+	if s.Recorder != nil {
+		recorder = s.Recorder
+	}
+
+	return &controller{
+		name: cfg.Name,
+		reconciler: &reconciler{
+			kubeClient:   s.KubeClient,
+			netboxClient: s.NetBoxClient,
+			log:          logger.With(log.String("reconciler", ControllerName)),
+			recorder:     recorder,
+			clusterTag:   s.ClusterTag,
+			tagCache:     s.TagCache,
+			cfg:          cfg,
+		},
+	}, nil
+}
+
+// AddToManager attaches the controller to the given manager.
+func (c *controller) AddToManager(mgr manager.Manager) error {
+	return builder.
+		ControllerManagedBy(mgr).
+		Named(ControllerName).
+		// deletes are not filtered out, since deleting
+		// the config restores the defaults
+		For(&v1beta1.NetBoxIPControllerConfig{}, builder.WithPredicates(
+			predicate.NewPredicateFuncs(func(o client.Object) bool {
+				return o.GetName() == c.name
+			}),
+		)).
+		Complete(c.reconciler)
+}
+
+type reconciler struct {
+	kubeClient   client.Client
+	netboxClient netbox.Client
+	log          *log.Logger
+	recorder     record.EventRecorder
+	clusterTag   string
+	// if set, the tags of the config are resolved with it
+	tagCache *ctrl.TagCache
+	cfg      Config
+}
+
+// Reconcile is called on every change of the watched NetBoxIPControllerConfig,
+// and applies its settings to the pod and service controllers.
+func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ll := r.log.With(log.String("name", req.Name))
+
+	ll.Info("reconciling controller config")
+
+	var config v1beta1.NetBoxIPControllerConfig
+	if err := r.kubeClient.Get(ctx, client.ObjectKey{Name: req.Name}, &config); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			ll.Error("failed to retrieve controller config", log.Error(err))
+			return reconcile.Result{}, fmt.Errorf("retrieving controller config: %w", err)
+		}
+		// with an empty spec, the defaults are restored
+		ll.Info("controller config not found, using defaults")
+	}
+
+	if err := validate(config.Spec); err != nil {
+		// retrying will not help until the config is fixed, which
+		// triggers another reconciliation
+		ll.Error("invalid controller config", log.Error(err))
+		r.recorder.Event(&config, corev1.EventTypeWarning, "InvalidConfig", err.Error())
+		return reconcile.Result{}, nil
+	}
+
+	podSettings, err := r.publishSettings(ctx, config.Spec.Pods, r.cfg.PodDefaults)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	serviceSettings, err := r.publishSettings(ctx, config.Spec.Services, r.cfg.ServiceDefaults)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if r.cfg.Pods.Set(podSettings) {
+		ll.Info("applied pod settings")
+	}
+	if r.cfg.Services.Set(serviceSettings) {
+		ll.Info("applied service settings")
+	}
+
+	qps, burst := r.cfg.NetBoxQPS, r.cfg.NetBoxBurst
+	if config.Spec.NetBoxQPS > 0 {
+		qps = rate.Limit(config.Spec.NetBoxQPS)
+	}
+	if config.Spec.NetBoxBurst > 0 {
+		burst = config.Spec.NetBoxBurst
+	}
+	for _, limiter := range r.cfg.RateLimiters {
+		if limiter.Limit() != qps || limiter.Burst() != burst {
+			limiter.SetLimit(qps)
+			limiter.SetBurst(burst)
+			ll.Info("applied NetBox rate limit", log.Float64("qps", float64(qps)), log.Int("burst", burst))
+		}
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// publishSettings returns the settings of the pod or service
+// controller, overriding the defaults with the given config.
+func (r *reconciler) publishSettings(ctx context.Context, config v1beta1.PublishConfig, defaults Defaults) (ctrl.PublishSettings, error) {
+	tagNames := defaults.Tags
+	if len(config.Tags) > 0 {
+		tagNames = config.Tags
+	}
+	if r.clusterTag != "" && !contains(tagNames, r.clusterTag) {
+		tagNames = append(append([]string{}, tagNames...), r.clusterTag)
+	}
+
+	// with a tag cache, the tags are only created
+	// once the first IP with them is published
+	var tags []netbox.Tag
+	var err error
+	if r.tagCache != nil {
+		tags, err = r.tagCache.Lookup(ctx, tagNames)
+	} else {
+		tags, err = ctrl.EnsureTags(ctx, r.netboxClient, r.log, tagNames)
+	}
+	if err != nil {
+		return ctrl.PublishSettings{}, err
+	}
+
+	labels := defaults.Labels
+	if len(config.PublishLabels) > 0 {
+		labels = toSet(config.PublishLabels)
+	}
+
+	return ctrl.PublishSettings{
+		Tags:               tags,
+		Labels:             labels,
+		Namespaces:         toSet(config.Namespaces),
+		ExcludedNamespaces: toSet(config.ExcludedNamespaces),
+	}, nil
+}
+
+// validate checks the parts of the config that cannot
+// be checked by the validation schema of the CRD.
+func validate(spec v1beta1.NetBoxIPControllerConfigSpec) error {
+	for _, l := range append(append([]string{}, spec.Pods.PublishLabels...), spec.Services.PublishLabels...) {
+		if errs := validation.IsQualifiedName(l); errs != nil {
+			return fmt.Errorf("publish label %q is not a valid kubernetes label: %v", l, errs)
+		}
+	}
+	return nil
+}
+
+func toSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
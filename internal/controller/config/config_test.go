@@ -0,0 +1,170 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	log "go.uber.org/zap"
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcile(t *testing.T) {
+	name := "netbox-ip-controller"
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	defaultPodSettings := ctrl.PublishSettings{
+		Tags:   []netbox.Tag{{Name: "k8s-pod", Slug: "k8s-pod"}, {Name: "prod", Slug: "prod"}},
+		Labels: map[string]bool{"app": true},
+	}
+	defaultServiceSettings := ctrl.PublishSettings{
+		Tags:   []netbox.Tag{{Name: "k8s-service", Slug: "k8s-service"}, {Name: "prod", Slug: "prod"}},
+		Labels: map[string]bool{"app": true},
+	}
+
+	tests := []struct {
+		name                    string
+		existingConfig          *v1beta1.NetBoxIPControllerConfig
+		expectedPodSettings     ctrl.PublishSettings
+		expectedServiceSettings ctrl.PublishSettings
+		expectedQPS             rate.Limit
+		expectedBurst           int
+	}{{
+		name:                    "config does not exist",
+		expectedPodSettings:     defaultPodSettings,
+		expectedServiceSettings: defaultServiceSettings,
+		expectedQPS:             100,
+		expectedBurst:           1,
+	}, {
+		name: "empty config",
+		existingConfig: &v1beta1.NetBoxIPControllerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+		},
+		expectedPodSettings:     defaultPodSettings,
+		expectedServiceSettings: defaultServiceSettings,
+		expectedQPS:             100,
+		expectedBurst:           1,
+	}, {
+		name: "config overrides defaults",
+		existingConfig: &v1beta1.NetBoxIPControllerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1beta1.NetBoxIPControllerConfigSpec{
+				Pods: v1beta1.PublishConfig{
+					Tags:               []string{"pod"},
+					PublishLabels:      []string{"team", "component"},
+					ExcludedNamespaces: []string{"kube-system"},
+				},
+				Services: v1beta1.PublishConfig{
+					Namespaces: []string{"team-a"},
+				},
+				NetBoxQPS:   5,
+				NetBoxBurst: 10,
+			},
+		},
+		expectedPodSettings: ctrl.PublishSettings{
+			Tags:               []netbox.Tag{{Name: "pod", Slug: "pod"}, {Name: "prod", Slug: "prod"}},
+			Labels:             map[string]bool{"team": true, "component": true},
+			ExcludedNamespaces: map[string]bool{"kube-system": true},
+		},
+		expectedServiceSettings: ctrl.PublishSettings{
+			Tags:       defaultServiceSettings.Tags,
+			Labels:     defaultServiceSettings.Labels,
+			Namespaces: map[string]bool{"team-a": true},
+		},
+		expectedQPS:   5,
+		expectedBurst: 10,
+	}, {
+		name: "invalid config is ignored",
+		existingConfig: &v1beta1.NetBoxIPControllerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1beta1.NetBoxIPControllerConfigSpec{
+				Pods: v1beta1.PublishConfig{
+					Tags:          []string{"pod"},
+					PublishLabels: []string{"not a label!"},
+				},
+				NetBoxQPS: 5,
+			},
+		},
+		expectedPodSettings:     ctrl.PublishSettings{Labels: map[string]bool{"foo": true}},
+		expectedServiceSettings: ctrl.PublishSettings{Labels: map[string]bool{"foo": true}},
+		expectedQPS:             1,
+		expectedBurst:           1,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeClientBuilder := fakeclient.NewClientBuilder().WithScheme(scheme)
+			if test.existingConfig != nil {
+				kubeClientBuilder = kubeClientBuilder.WithObjects(test.existingConfig)
+			}
+
+			// the live settings start out different from
+			// the defaults, to tell if they have been applied
+			pods := ctrl.NewLiveSettings(ctrl.PublishSettings{Labels: map[string]bool{"foo": true}})
+			services := ctrl.NewLiveSettings(ctrl.PublishSettings{Labels: map[string]bool{"foo": true}})
+			limiter := rate.NewLimiter(1, 1)
+
+			r := &reconciler{
+				kubeClient:   kubeClientBuilder.Build(),
+				netboxClient: netbox.NewFakeClient(nil, nil),
+				log:          log.L(),
+				recorder:     record.NewFakeRecorder(10),
+				clusterTag:   "prod",
+				cfg: Config{
+					Name:            name,
+					Pods:            pods,
+					PodDefaults:     Defaults{Tags: []string{"k8s-pod", "prod"}, Labels: map[string]bool{"app": true}},
+					Services:        services,
+					ServiceDefaults: Defaults{Tags: []string{"k8s-service"}, Labels: map[string]bool{"app": true}},
+					RateLimiters:    []*rate.Limiter{limiter},
+					NetBoxQPS:       100,
+					NetBoxBurst:     1,
+				},
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name}}
+			if _, err := r.Reconcile(context.Background(), req); err != nil {
+				t.Fatalf("reconciling: %q\n", err)
+			}
+
+			if diff := cmp.Diff(test.expectedPodSettings, pods.Get(), cmpopts.IgnoreUnexported(netbox.Tag{})); diff != "" {
+				t.Errorf("pod settings (-want, +got)\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedServiceSettings, services.Get(), cmpopts.IgnoreUnexported(netbox.Tag{})); diff != "" {
+				t.Errorf("service settings (-want, +got)\n%s", diff)
+			}
+			if limiter.Limit() != test.expectedQPS || limiter.Burst() != test.expectedBurst {
+				t.Errorf("want rate limit %v with burst %d, got %v with burst %d",
+					test.expectedQPS, test.expectedBurst, limiter.Limit(), limiter.Burst())
+			}
+		})
+	}
+}
@@ -20,16 +20,56 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/netip"
+	"time"
 
 	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+	"github.com/digitalocean/netbox-ip-controller/internal/webhook"
 
 	log "go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
+// Deletion policies, deciding what happens to an IP in NetBox
+// when its NetBoxIP is deleted.
+const (
+	// DeletionPolicyDelete deletes the IP from NetBox.
+	DeletionPolicyDelete = netbox.DeletionPolicyDelete
+	// DeletionPolicyRetain keeps the IP in NetBox, but clears its UID,
+	// so that it is no longer managed by the controller.
+	DeletionPolicyRetain = netbox.DeletionPolicyRetain
+	// DeletionPolicyDeprecate keeps the IP in NetBox like
+	// DeletionPolicyRetain, but also sets its status to deprecated,
+	// and appends the time of deletion to its description.
+	DeletionPolicyDeprecate = netbox.DeletionPolicyDeprecate
+)
+
+// Job policies, deciding how IPs of pods controlled by Jobs are published.
+const (
+	// JobPolicyPublish publishes IPs of Job pods like any other.
+	JobPolicyPublish = "publish"
+	// JobPolicySkip does not publish IPs of Job pods.
+	JobPolicySkip = "skip"
+	// JobPolicyTag publishes IPs of Job pods with an additional tag, and
+	// removes them once their Job has finished, even if the IPs of
+	// completed pods would otherwise be kept.
+	JobPolicyTag = "tag"
+)
+
+// VIPAnnotations are the annotations with which load balancer implementations
+// let services request addresses, as comma-separated lists.
+var VIPAnnotations = []string{
+	"kube-vip.io/loadbalancerIPs",
+	"metallb.universe.tf/loadBalancerIPs",
+}
+
 // Controller is responsible for updating IPs of a single k8s resource.
 type Controller interface {
 	AddToManager(manager.Manager) error
@@ -43,7 +83,165 @@ type Settings struct {
 	Labels        map[string]bool
 	ClusterDomain string
 	Logger        *log.Logger
-	DualStackIP   bool
+	// SkipIPv4 and SkipIPv6 disable publishing the pod and
+	// cluster IPs of a family, which are published by default.
+	SkipIPv4 bool
+	SkipIPv6 bool
+	// OmitDNSName publishes IPs without a DNS name.
+	OmitDNSName bool
+	// DescriptionStrategy is one of DescriptionStrategyTruncate (the default),
+	// DescriptionStrategyDropLabels or DescriptionStrategyHashSuffix.
+	DescriptionStrategy string
+	// AllowedPrefixes, if not empty, are the only ranges
+	// in which IPs may be allocated.
+	AllowedPrefixes []netip.Prefix
+	Recorder        record.EventRecorder
+	TenantMapping   *TenantMapping
+	// ClusterTag is the name of the cluster, which is added
+	// to the description of every IP published by the controller.
+	ClusterTag string
+	// TenantNetBoxClients are NetBox clients authenticated with
+	// tenant-specific tokens, keyed by tenant slug.
+	TenantNetBoxClients map[string]netbox.Client
+	// WebhookSink receives an event whenever an IP is created,
+	// updated or deleted in NetBox.
+	WebhookSink webhook.Sink
+	// DNSEndpoints enables creating external-dns DNSEndpoint
+	// resources for published IPs.
+	DNSEndpoints bool
+	// NetBoxWebhookAddr, if set, is the address to serve
+	// NetBox webhooks on, authenticated with NetBoxWebhookSecret.
+	NetBoxWebhookAddr   string
+	NetBoxWebhookSecret string
+	// UIDFieldName is the name of the NetBox custom field that UIDs
+	// are stored in, if not netbox.UIDCustomFieldName.
+	UIDFieldName string
+	// DeletionPolicy is one of DeletionPolicyDelete (the default),
+	// DeletionPolicyRetain or DeletionPolicyDeprecate.
+	DeletionPolicy string
+	// DeletionDebounce is how long IPs are kept in NetBox after their
+	// NetBoxIPs are deleted, during which a NetBoxIP with the same address
+	// takes over the IP rather than creating a new one.
+	DeletionDebounce time.Duration
+	// CompletedPodIPTTL is how long the IPs of succeeded
+	// or failed pods are kept before they are deleted.
+	CompletedPodIPTTL time.Duration
+	// ExcludedOwnerKinds are the kinds of controllers, e.g.
+	// DaemonSet, whose pods are not published.
+	ExcludedOwnerKinds map[string]bool
+	// SkipStaticPods disables publishing IPs of static pods.
+	SkipStaticPods bool
+	// RequireReady restricts publishing IPs to ready pods. IPs of pods
+	// that stop being ready are removed after NotReadyGracePeriod.
+	RequireReady        bool
+	NotReadyGracePeriod time.Duration
+	// AnnotationOverrides enables overriding the tenant and VRF
+	// of IPs with annotations on pods.
+	AnnotationOverrides bool
+	// JobPolicy is one of JobPolicyPublish (the default),
+	// JobPolicySkip or JobPolicyTag.
+	JobPolicy string
+	// JobTag is the tag added to IPs of Job pods with JobPolicyTag.
+	JobTag *netbox.Tag
+	// OwnerKinds, if not empty, are the kinds of controllers, e.g.
+	// StatefulSet, whose pods are the only ones published.
+	OwnerKinds map[string]bool
+	// SharedAddresses enables publishing IPs of host network pods,
+	// and publishing all NetBoxIPs with the same address and VRF
+	// as a single IP in NetBox.
+	SharedAddresses bool
+	// CustomFieldAnnotations maps names of NetBox custom fields
+	// to the pod annotations that their values are taken from.
+	CustomFieldAnnotations map[string]string
+	// MACAddresses enables assigning the IPs of pods to NetBox interfaces
+	// with the MAC addresses that the CNI reports in pod annotations,
+	// which are created on the device named after the node of the pod.
+	MACAddresses bool
+	// NodeDevices, if set, maps nodes to the NetBox devices that
+	// interfaces of their pods are created on, instead of the devices
+	// named after the nodes.
+	NodeDevices *NodeDeviceMatcher
+	// NodeInterface, if set, is the name of the interface of the NetBox
+	// devices of nodes that IPs of host network pods are assigned to.
+	NodeInterface string
+	// LoadBalancerIPs enables publishing the addresses of the
+	// load balancer ingress points of LoadBalancer services.
+	LoadBalancerIPs bool
+	// LoadBalancerNAT links the IPs of load balancer ingress points
+	// and VIPs in NetBox to the cluster IPs of their services,
+	// as their NAT inside addresses.
+	LoadBalancerNAT bool
+	// LoadBalancerResolver, if set, resolves load balancer ingress points
+	// that only have a hostname, e.g. on AWS, every LoadBalancerRefresh.
+	LoadBalancerResolver Resolver
+	LoadBalancerRefresh  time.Duration
+	// ExternalNameResolver, if set, resolves the targets of ExternalName
+	// services, whose addresses are then published, every ExternalNameRefresh.
+	ExternalNameResolver Resolver
+	ExternalNameRefresh  time.Duration
+	// NodePortServices, if set, publishes the node ports of services
+	// as NetBox services on the IPs of the nodes.
+	NodePortServices netbox.ServiceClient
+	// NamespacePrefixes, if set, allocates a child prefix of
+	// NamespacePrefixParent for every namespace that requests one with
+	// an annotation, NamespacePrefixLength long unless it says otherwise.
+	NamespacePrefixes     netbox.PrefixAllocator
+	NamespacePrefixParent netip.Prefix
+	NamespacePrefixLength int
+	// RequeueInterval, if greater than 0, is how often every
+	// object is reconciled, even if it does not change.
+	RequeueInterval time.Duration
+	// SlowReconcileThreshold, if greater than 0, is how long a reconciliation
+	// may take before a warning with a breakdown of its time is logged.
+	SlowReconcileThreshold time.Duration
+	// LiveSettings, if set, replace Tags and Labels with
+	// settings that may be changed at runtime.
+	LiveSettings *LiveSettings
+	// NamespaceClusterDomains enables overriding ClusterDomain
+	// with an annotation on namespaces.
+	NamespaceClusterDomains bool
+	// VIPTag, if set, enables publishing the addresses that LoadBalancer
+	// services request with kube-vip or MetalLB annotations, with this tag,
+	// before they are assigned.
+	VIPTag *netbox.Tag
+	// LoadBalancerIPPool, if valid, is the prefix from which IPs are allocated
+	// with LoadBalancerIPAllocator, which implements netbox.Allocator, for
+	// LoadBalancer services that request one with an annotation. Allocated
+	// IPs are written to the LoadBalancerIPAnnotation of the services.
+	LoadBalancerIPPool       netip.Prefix
+	LoadBalancerIPAllocator  netbox.Client
+	LoadBalancerIPAnnotation string
+	// ClusterIPTags and LoadBalancerIPTags are added to the tags of
+	// cluster IPs and load balancer addresses of services respectively.
+	ClusterIPTags      []netbox.Tag
+	LoadBalancerIPTags []netbox.Tag
+	// SelectorCustomField and PortsCustomField, if set, are the NetBox
+	// custom fields that the selector and ports of services are written to.
+	SelectorCustomField string
+	PortsCustomField    string
+	// Shard, if set, limits reconciliation to objects in the
+	// namespaces owned by this replica of the controller.
+	Shard *Shard
+	// ServiceIPPriority enables reconciling NetBoxIPs of services
+	// separately from others, so that they are not delayed by
+	// a backlog of NetBoxIPs of pods.
+	ServiceIPPriority bool
+	// QueueLimit, if set, limits how many events may be waiting
+	// in the workqueue of the controller.
+	QueueLimit *QueueLimit
+	// RateBudgets are the rate limiters of requests to NetBox
+	// of the controllers with their own rate budget, by controller
+	// name. Other controllers share the rate limiter of the client.
+	RateBudgets map[string]*rate.Limiter
+	// TagCache, if set, resolves and caches the tags of the
+	// controller, and keeps them up to date with NetBox.
+	TagCache *TagCache
+}
+
+// Resolver looks up the IP addresses of hostnames.
+// It is implemented by net.Resolver.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
 }
 
 // Option can be used to tune controller settings.
@@ -58,42 +256,45 @@ func WithLogger(logger *log.Logger) Option {
 }
 
 // WithTags sets the tags that are applied to every IP
-// published by the controller.
-func WithTags(tags []string, netboxClient netbox.Client) Option {
+// published by the controller. Tags that do not exist in
+// NetBox are created once the first IP with them is published.
+func WithTags(tags []string) Option {
 	return func(s *Settings) error {
-		s.NetBoxClient = netboxClient
-
-		if s.NetBoxClient == nil {
-			return errors.New("missing netbox client")
+		lookedUp, err := s.TagCache.Lookup(context.Background(), tags)
+		if err != nil {
+			return err
 		}
+		s.Tags = append(s.Tags, lookedUp...)
+		return nil
+	}
+}
 
-		ctx := context.Background()
-		for _, tag := range tags {
-			existingTag, err := s.NetBoxClient.GetTag(ctx, tag)
-			if err != nil {
-				return fmt.Errorf("retrieving tag %s: %w", tag, err)
-			}
+// EnsureTags returns the NetBox tags with the given names,
+// creating the ones that do not exist yet.
+func EnsureTags(ctx context.Context, netboxClient netbox.Client, logger *log.Logger, names []string) ([]netbox.Tag, error) {
+	var tags []netbox.Tag
+	for _, tag := range names {
+		existingTag, err := netboxClient.GetTag(ctx, tag)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving tag %s: %w", tag, err)
+		}
 
-			ll := log.L().With(log.String("tag", tag))
-			if s.Logger != nil {
-				ll = s.Logger.With(log.String("tag", tag))
-			}
+		ll := logger.With(log.String("tag", tag))
 
-			if existingTag != nil {
-				ll.Info("tag already exists")
-				s.Tags = append(s.Tags, *existingTag)
-				continue
-			}
+		if existingTag != nil {
+			ll.Info("tag already exists")
+			tags = append(tags, *existingTag)
+			continue
+		}
 
-			createdTag, err := s.NetBoxClient.CreateTag(ctx, tag)
-			if err != nil {
-				return fmt.Errorf("creating tag %s: %w", tag, err)
-			}
-			s.Tags = append(s.Tags, *createdTag)
-			ll.Info("created tag")
+		createdTag, err := netboxClient.CreateTag(ctx, tag)
+		if err != nil {
+			return nil, fmt.Errorf("creating tag %s: %w", tag, err)
 		}
-		return nil
+		tags = append(tags, *createdTag)
+		ll.Info("created tag")
 	}
+	return tags, nil
 }
 
 // WithLabels sets the k8s object labels that are added to the description
@@ -121,6 +322,16 @@ func WithKubernetesClient(client client.Client) Option {
 	}
 }
 
+// WithNamespaceClusterDomains enables overriding the cluster domain
+// of objects in a namespace with ClusterDomainAnnotation on the namespace,
+// e.g. for namespaces of virtual clusters served by a different DNS suffix.
+func WithNamespaceClusterDomains() Option {
+	return func(s *Settings) error {
+		s.NamespaceClusterDomains = true
+		return nil
+	}
+}
+
 // WithClusterDomain sets the k8s cluster domain name.
 func WithClusterDomain(domain string) Option {
 	return func(s *Settings) error {
@@ -129,11 +340,575 @@ func WithClusterDomain(domain string) Option {
 	}
 }
 
-// WithDualStackIP enables registering both IPv6 and IPv4 address in netbox
-// for dual stack pods and services.
-func WithDualStackIP() Option {
+// WithoutDNSName publishes IPs without a DNS name, instead of
+// deriving one from the name of the pod or service.
+func WithoutDNSName() Option {
+	return func(s *Settings) error {
+		s.OmitDNSName = true
+		return nil
+	}
+}
+
+// WithDescriptionStrategy sets how descriptions of IPs
+// longer than NetBox allows are shortened.
+func WithDescriptionStrategy(strategy string) Option {
+	return func(s *Settings) error {
+		switch strategy {
+		case DescriptionStrategyTruncate, DescriptionStrategyDropLabels, DescriptionStrategyHashSuffix:
+		default:
+			return fmt.Errorf("unknown description strategy %q", strategy)
+		}
+		s.DescriptionStrategy = strategy
+		return nil
+	}
+}
+
+// WithIPFamilies sets whether IPv4 and IPv6 pod and cluster IPs are
+// published. Both are published by default, so that dual-stack pods and
+// services have an IP of each family in NetBox.
+func WithIPFamilies(ipv4, ipv6 bool) Option {
+	return func(s *Settings) error {
+		if !ipv4 && !ipv6 {
+			return errors.New("at least one IP family must be published")
+		}
+		s.SkipIPv4 = !ipv4
+		s.SkipIPv6 = !ipv6
+		return nil
+	}
+}
+
+// WithAllowedPrefixes restricts the prefixes that the controller may
+// allocate IPs from to the given ones. The IPs written to NetBox are
+// restricted by the NetBox client, see netbox.WithAllowedPrefixes.
+func WithAllowedPrefixes(prefixes []netip.Prefix) Option {
+	return func(s *Settings) error {
+		s.AllowedPrefixes = prefixes
+		return nil
+	}
+}
+
+// WithEventRecorder sets the recorder used to emit Kubernetes events.
+func WithEventRecorder(recorder record.EventRecorder) Option {
+	return func(s *Settings) error {
+		s.Recorder = recorder
+		return nil
+	}
+}
+
+// WithClusterTag sets the name of the cluster to be included in
+// the description of every IP published by the controller. The tag itself
+// should be added with WithTags.
+func WithClusterTag(tag string) Option {
+	return func(s *Settings) error {
+		s.ClusterTag = tag
+		return nil
+	}
+}
+
+// WithTenantMapping sets the mapping of namespaces to NetBox tenants.
+func WithTenantMapping(mapping *TenantMapping) Option {
+	return func(s *Settings) error {
+		s.TenantMapping = mapping
+		return nil
+	}
+}
+
+// WithTenantNetBoxClients sets the NetBox clients used to write IPs
+// of the given tenants, instead of the default NetBox client.
+func WithTenantNetBoxClients(clients map[string]netbox.Client) Option {
+	return func(s *Settings) error {
+		s.TenantNetBoxClients = clients
+		return nil
+	}
+}
+
+// WithWebhookSink sets the sink that is notified whenever an IP
+// is created, updated or deleted in NetBox.
+func WithWebhookSink(sink webhook.Sink) Option {
+	return func(s *Settings) error {
+		s.WebhookSink = sink
+		return nil
+	}
+}
+
+// WithDNSEndpoints enables creating an external-dns DNSEndpoint
+// resource for every published IP that has a DNS name.
+func WithDNSEndpoints() Option {
+	return func(s *Settings) error {
+		s.DNSEndpoints = true
+		return nil
+	}
+}
+
+// WithNetBoxWebhook enables receiving NetBox webhooks on the given address.
+// Webhook bodies must be signed with the given secret.
+func WithNetBoxWebhook(addr, secret string) Option {
+	return func(s *Settings) error {
+		if secret == "" {
+			return errors.New("secret for NetBox webhooks is required")
+		}
+		s.NetBoxWebhookAddr = addr
+		s.NetBoxWebhookSecret = secret
+		return nil
+	}
+}
+
+// WithUIDFieldName sets the name of the NetBox custom field that UIDs
+// are stored in, which UIDs are read from in NetBox webhooks.
+func WithUIDFieldName(name string) Option {
+	return func(s *Settings) error {
+		s.UIDFieldName = name
+		return nil
+	}
+}
+
+// WithDeletionPolicy sets what happens to an IP in NetBox
+// when its NetBoxIP is deleted.
+func WithDeletionPolicy(policy string) Option {
+	return func(s *Settings) error {
+		switch policy {
+		case DeletionPolicyDelete, DeletionPolicyRetain, DeletionPolicyDeprecate:
+		default:
+			return fmt.Errorf("unknown deletion policy %q", policy)
+		}
+		s.DeletionPolicy = policy
+		return nil
+	}
+}
+
+// WithCompletedPodIPTTL keeps the IPs of succeeded or failed pods
+// for the given duration after the pods complete.
+func WithCompletedPodIPTTL(ttl time.Duration) Option {
+	return func(s *Settings) error {
+		if ttl < 0 {
+			return fmt.Errorf("completed pod IP TTL %s must not be negative", ttl)
+		}
+		s.CompletedPodIPTTL = ttl
+		return nil
+	}
+}
+
+// WithExcludedOwnerKinds disables publishing IPs of pods
+// controlled by objects of the given kinds, e.g. DaemonSet.
+func WithExcludedOwnerKinds(kinds []string) Option {
+	return func(s *Settings) error {
+		if len(kinds) == 0 {
+			return nil
+		}
+		s.ExcludedOwnerKinds = make(map[string]bool)
+		for _, kind := range kinds {
+			s.ExcludedOwnerKinds[kind] = true
+		}
+		return nil
+	}
+}
+
+// WithOwnerKinds restricts publishing IPs to pods controlled, directly
+// or through other controllers, by objects of the given kinds, e.g.
+// Deployment, which controls pods through a ReplicaSet.
+func WithOwnerKinds(kinds []string) Option {
+	return func(s *Settings) error {
+		if len(kinds) == 0 {
+			return nil
+		}
+		s.OwnerKinds = make(map[string]bool)
+		for _, kind := range kinds {
+			s.OwnerKinds[kind] = true
+		}
+		return nil
+	}
+}
+
+// WithRequireReady restricts publishing IPs to pods that are ready.
+// IPs of pods that stop being ready are removed, once they have not
+// been ready for longer than the given grace period.
+func WithRequireReady(gracePeriod time.Duration) Option {
+	return func(s *Settings) error {
+		if gracePeriod < 0 {
+			return fmt.Errorf("not ready grace period %s must not be negative", gracePeriod)
+		}
+		s.RequireReady = true
+		s.NotReadyGracePeriod = gracePeriod
+		return nil
+	}
+}
+
+// WithJobPolicy sets how IPs of pods controlled by Jobs are published.
+// With JobPolicyTag, the tag with the given name is added to their IPs.
+func WithJobPolicy(policy string, tag string) Option {
+	return func(s *Settings) error {
+		switch policy {
+		case JobPolicyPublish, JobPolicySkip:
+		case JobPolicyTag:
+			tags, err := s.TagCache.Lookup(context.Background(), []string{tag})
+			if err != nil {
+				return err
+			}
+			s.JobTag = &tags[0]
+		default:
+			return fmt.Errorf("unknown job policy %q", policy)
+		}
+		s.JobPolicy = policy
+		return nil
+	}
+}
+
+// WithAnnotationOverrides enables overriding the tenant and VRF of
+// the IPs of a pod with annotations on the pod.
+func WithAnnotationOverrides() Option {
+	return func(s *Settings) error {
+		s.AnnotationOverrides = true
+		return nil
+	}
+}
+
+// WithCustomFieldAnnotations sets the NetBox custom fields of IPs
+// to the values of the given annotations, keyed by field name.
+func WithCustomFieldAnnotations(annotations map[string]string) Option {
+	return func(s *Settings) error {
+		s.CustomFieldAnnotations = annotations
+		return nil
+	}
+}
+
+// WithMACAddresses enables assigning the IPs of pods to NetBox interfaces
+// with the MAC addresses of the pods' network interfaces, where the CNI
+// reports them in the network-status or OVN pod-networks annotation.
+func WithMACAddresses() Option {
+	return func(s *Settings) error {
+		s.MACAddresses = true
+		return nil
+	}
+}
+
+// WithNodeDeviceMatch sets how nodes are matched to the NetBox devices
+// they run on: one of NodeDeviceMatchName (the default),
+// NodeDeviceMatchProviderID, NodeDeviceMatchSerial, or
+// NodeDeviceMatchLabelPrefix followed by the key of a node label.
+func WithNodeDeviceMatch(match string, ipamClient netbox.Client) Option {
+	return func(s *Settings) error {
+		matcher, err := NewNodeDeviceMatcher(match, ipamClient)
+		if err != nil {
+			return err
+		}
+		s.NodeDevices = matcher
+		return nil
+	}
+}
+
+// WithNodeInterface assigns the IPs of host network pods, which are
+// the IPs of their nodes, to the interface with the given name of the
+// NetBox devices of the nodes.
+func WithNodeInterface(name string) Option {
+	return func(s *Settings) error {
+		s.NodeInterface = name
+		return nil
+	}
+}
+
+// WithShard limits reconciliation to objects in the namespaces owned
+// by the replica with the given index out of count replicas.
+func WithShard(index, count int) Option {
+	return func(s *Settings) error {
+		if count < 1 {
+			return fmt.Errorf("invalid shard count %d: must be at least 1", count)
+		}
+		if index < 0 || index >= count {
+			return fmt.Errorf("invalid shard index %d: must be between 0 and %d", index, count-1)
+		}
+		s.Shard = &Shard{Index: index, Count: count}
+		return nil
+	}
+}
+
+// WithServiceIPPriority enables reconciling NetBoxIPs of services with
+// a workqueue of their own, which is drained alongside, rather than after,
+// the backlog of NetBoxIPs of pods.
+func WithServiceIPPriority() Option {
+	return func(s *Settings) error {
+		s.ServiceIPPriority = true
+		return nil
+	}
+}
+
+// WithQueueLimit limits the workqueue of the controller to maxSize events,
+// unless it is 0. Events beyond the limit are dropped with QueueOverflowShed,
+// and added to the workqueue after parkDelay with QueueOverflowPark.
+func WithQueueLimit(maxSize int, policy string, parkDelay time.Duration) Option {
+	return func(s *Settings) error {
+		if maxSize < 0 {
+			return fmt.Errorf("invalid queue size %d: must not be negative", maxSize)
+		}
+		if maxSize == 0 {
+			return nil
+		}
+		switch policy {
+		case QueueOverflowShed:
+		case QueueOverflowPark:
+			if parkDelay <= 0 {
+				return fmt.Errorf("invalid park delay %s: must be greater than 0", parkDelay)
+			}
+		default:
+			return fmt.Errorf("unknown queue overflow policy %q", policy)
+		}
+		s.QueueLimit = &QueueLimit{MaxSize: maxSize, Policy: policy, ParkDelay: parkDelay}
+		return nil
+	}
+}
+
+// WithRateBudgets gives the controllers with the given names rate
+// limiters of their own for requests to NetBox, so that a burst of
+// reconciliations in one of them cannot starve the others.
+func WithRateBudgets(budgets map[string]*rate.Limiter) Option {
+	return func(s *Settings) error {
+		s.RateBudgets = budgets
+		return nil
+	}
+}
+
+// WithTagCache resolves the tags of the controller with the given cache,
+// which keeps them up to date with NetBox. It must precede the options
+// that set tags, e.g. WithTags, for them to use the cached tags.
+func WithTagCache(cache *TagCache) Option {
+	return func(s *Settings) error {
+		s.TagCache = cache
+		return nil
+	}
+}
+
+// WithSharedAddresses enables publishing NetBoxIPs with the same address
+// and VRF, such as those of host network pods on the same node, as a single
+// IP in NetBox with their merged tags and descriptions.
+func WithSharedAddresses() Option {
+	return func(s *Settings) error {
+		s.SharedAddresses = true
+		return nil
+	}
+}
+
+// WithLoadBalancerIPs enables publishing the addresses of
+// the load balancer ingress points of LoadBalancer services.
+func WithLoadBalancerIPs() Option {
+	return func(s *Settings) error {
+		s.LoadBalancerIPs = true
+		return nil
+	}
+}
+
+// WithLoadBalancerNAT links the IPs of load balancer ingress points
+// and VIPs of services to their cluster IPs as NAT inside addresses,
+// so that the path of traffic through them is visible in NetBox.
+func WithLoadBalancerNAT() Option {
+	return func(s *Settings) error {
+		s.LoadBalancerNAT = true
+		return nil
+	}
+}
+
+// WithServiceIPKindTags adds the given tags to the cluster IPs and
+// to the load balancer addresses of services respectively, so that
+// internal and externally reachable addresses can be told apart.
+func WithServiceIPKindTags(clusterIPTags, loadBalancerIPTags []string) Option {
+	return func(s *Settings) error {
+		var err error
+		if s.ClusterIPTags, err = s.TagCache.Lookup(context.Background(), clusterIPTags); err != nil {
+			return err
+		}
+		if s.LoadBalancerIPTags, err = s.TagCache.Lookup(context.Background(), loadBalancerIPTags); err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+// WithSelectorCustomFields writes the selector and the ports of services to
+// the given NetBox custom fields of their IPs, e.g. app=foo and 80/TCP, so
+// that it can be seen in NetBox which workloads a service fronts. Either
+// field may be empty, in which case it is not written.
+func WithSelectorCustomFields(selectorField, portsField string) Option {
+	return func(s *Settings) error {
+		s.SelectorCustomField = selectorField
+		s.PortsCustomField = portsField
+		return nil
+	}
+}
+
+// WithVIPAnnotations enables publishing the addresses that LoadBalancer
+// services request with the kube-vip or MetalLB annotations, so that they
+// are reserved in NetBox before they are assigned, with the given tag.
+func WithVIPAnnotations(tag string) Option {
+	return func(s *Settings) error {
+		tags, err := s.TagCache.Lookup(context.Background(), []string{tag})
+		if err != nil {
+			return err
+		}
+		s.VIPTag = &tags[0]
+		return nil
+	}
+}
+
+// WithLoadBalancerIPAllocation allocates IPs from pool in the IPAM system
+// for LoadBalancer services that request them with an annotation, and writes
+// them to the given VIP annotation of the services, one of VIPAnnotations,
+// so that the load balancer implementation assigns them.
+func WithLoadBalancerIPAllocation(pool netip.Prefix, annotation string, ipamClient netbox.Client) Option {
+	return func(s *Settings) error {
+		if _, ok := ipamClient.(netbox.Allocator); !ok {
+			return errors.New("the IPAM backend does not support allocating IPs from prefixes")
+		}
+		if !pool.IsValid() {
+			return errors.New("load balancer IP pool is required")
+		}
+		for _, a := range VIPAnnotations {
+			if a == annotation {
+				s.LoadBalancerIPPool = pool.Masked()
+				s.LoadBalancerIPAllocator = ipamClient
+				s.LoadBalancerIPAnnotation = annotation
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid load balancer IP annotation %q: must be one of %v", annotation, VIPAnnotations)
+	}
+}
+
+// WithLoadBalancerHostnames enables publishing the addresses of load
+// balancer ingress points that only have a hostname, as resolved by the
+// given resolver. Hostnames are resolved again after the refresh interval,
+// so that changes of their addresses are eventually published.
+func WithLoadBalancerHostnames(resolver Resolver, refresh time.Duration) Option {
+	return func(s *Settings) error {
+		if resolver == nil {
+			return errors.New("missing resolver")
+		}
+		if refresh <= 0 {
+			return fmt.Errorf("load balancer hostname refresh interval %s must be greater than 0", refresh)
+		}
+		s.LoadBalancerResolver = resolver
+		s.LoadBalancerRefresh = refresh
+		return nil
+	}
+}
+
+// WithExternalNames enables publishing the addresses that the targets of
+// ExternalName services resolve to, as resolved by the given resolver.
+// Targets are resolved again after the refresh interval, so that changes
+// of their addresses are eventually published.
+func WithExternalNames(resolver Resolver, refresh time.Duration) Option {
+	return func(s *Settings) error {
+		if resolver == nil {
+			return errors.New("missing resolver")
+		}
+		if refresh <= 0 {
+			return fmt.Errorf("external name refresh interval %s must be greater than 0", refresh)
+		}
+		s.ExternalNameResolver = resolver
+		s.ExternalNameRefresh = refresh
+		return nil
+	}
+}
+
+// WithDeletionDebounce delays removing IPs from NetBox after their NetBoxIPs
+// are deleted by the given window, so that deleting and creating NetBoxIPs
+// with the same address in quick succession, e.g. during rolling updates,
+// updates the IP in NetBox instead of deleting and creating it again.
+func WithDeletionDebounce(window time.Duration) Option {
+	return func(s *Settings) error {
+		if window < 0 {
+			return fmt.Errorf("deletion debounce %s must not be negative", window)
+		}
+		s.DeletionDebounce = window
+		return nil
+	}
+}
+
+// WithNodePortServices enables publishing the node ports of NodePort
+// and LoadBalancer services as services in the IPAM system, attached to
+// the IPs of the nodes, if the client supports it.
+func WithNodePortServices(ipamClient netbox.Client) Option {
+	return func(s *Settings) error {
+		serviceClient, ok := ipamClient.(netbox.ServiceClient)
+		if !ok {
+			return errors.New("the IPAM backend does not support publishing node ports as services")
+		}
+		s.NodePortServices = serviceClient
+		return nil
+	}
+}
+
+// WithNamespacePrefixes allocates child prefixes of parent, of the given
+// length by default, for namespaces that request them with an annotation,
+// if the client supports it.
+func WithNamespacePrefixes(parent netip.Prefix, length int, ipamClient netbox.Client) Option {
+	return func(s *Settings) error {
+		allocator, ok := ipamClient.(netbox.PrefixAllocator)
+		if !ok {
+			return errors.New("the IPAM backend does not support allocating prefixes")
+		}
+		if !parent.IsValid() {
+			return errors.New("parent prefix of namespace prefixes is required")
+		}
+		if length <= parent.Bits() || length > parent.Addr().BitLen() {
+			return fmt.Errorf("invalid length %d of namespace prefixes: must be longer than /%d of %s", length, parent.Bits(), parent)
+		}
+		s.NamespacePrefixes = allocator
+		s.NamespacePrefixParent = parent.Masked()
+		s.NamespacePrefixLength = length
+		return nil
+	}
+}
+
+// WithoutStaticPods disables publishing IPs of static pods,
+// i.e. pods run by a kubelet from its local configuration.
+func WithoutStaticPods() Option {
+	return func(s *Settings) error {
+		s.SkipStaticPods = true
+		return nil
+	}
+}
+
+// WithRequeueInterval makes the controller reconcile every object
+// again after the given interval, so that changes made outside of
+// Kubernetes, e.g. in NetBox, are eventually reverted.
+func WithRequeueInterval(interval time.Duration) Option {
+	return func(s *Settings) error {
+		if interval < 0 {
+			return fmt.Errorf("requeue interval %s must not be negative", interval)
+		}
+		s.RequeueInterval = interval
+		return nil
+	}
+}
+
+// WithSlowReconcileThreshold makes the controller log a warning with the
+// time spent waiting for the NetBox rate limiter, NetBox and Kubernetes
+// by every reconciliation that takes longer than the given threshold.
+func WithSlowReconcileThreshold(threshold time.Duration) Option {
+	return func(s *Settings) error {
+		if threshold < 0 {
+			return fmt.Errorf("slow reconcile threshold %s must not be negative", threshold)
+		}
+		s.SlowReconcileThreshold = threshold
+		return nil
+	}
+}
+
+// Requeue returns the result of a successful reconciliation. If interval is
+// greater than 0, the object is reconciled again after about that long;
+// the interval is jittered, so that objects reconciled at the same time,
+// e.g. on startup, are not all reconciled again at once.
+func Requeue(interval time.Duration) reconcile.Result {
+	if interval <= 0 {
+		return reconcile.Result{}
+	}
+	return reconcile.Result{RequeueAfter: wait.Jitter(interval, 0.1)}
+}
+
+// WithLiveSettings sets the tags, publish labels and namespace filters
+// of the controller, which may be changed while the controller is running.
+// They take precedence over WithTags and WithLabels.
+func WithLiveSettings(settings *LiveSettings) Option {
 	return func(s *Settings) error {
-		s.DualStackIP = true
+		s.LiveSettings = settings
 		return nil
 	}
 }
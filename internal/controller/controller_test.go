@@ -17,18 +17,22 @@ limitations under the License.
 package controller
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	log "go.uber.org/zap"
 )
 
 func TestWithTags(t *testing.T) {
 	tests := []struct {
 		name         string
 		existingTags map[string]netbox.Tag
+		cachedTags   []string
 		addedTags    []string
 		expectedTags []netbox.Tag
 	}{{
@@ -49,16 +53,33 @@ func TestWithTags(t *testing.T) {
 		},
 		addedTags:    []string{"foo"},
 		expectedTags: []netbox.Tag{{Name: "foo", Slug: "existing-foo"}},
+	}, {
+		name: "exising and added tags overlap, and are cached",
+		existingTags: map[string]netbox.Tag{
+			"foo": {ID: 1, Name: "foo", Slug: "existing-foo"},
+		},
+		cachedTags:   []string{"foo"},
+		addedTags:    []string{"foo", "bar"},
+		expectedTags: []netbox.Tag{{ID: 1, Name: "foo", Slug: "existing-foo"}, {Name: "bar", Slug: "bar"}},
 	}}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
+			existing := make(map[string]bool)
+			for name := range test.existingTags {
+				existing[name] = true
+			}
 			netboxClient := netbox.NewFakeClient(test.existingTags, nil)
+			cache := NewTagCache(netboxClient, log.NewNop(), 0)
+			if _, err := cache.Ensure(context.Background(), test.cachedTags); err != nil {
+				t.Fatal(err)
+			}
 
 			var s Settings
-			o := WithTags(test.addedTags, netboxClient)
-			if err := o(&s); err != nil {
-				t.Fatal(err)
+			for _, o := range []Option{WithTagCache(cache), WithTags(test.addedTags)} {
+				if err := o(&s); err != nil {
+					t.Fatal(err)
+				}
 			}
 
 			diff := cmp.Diff(
@@ -70,6 +91,74 @@ func TestWithTags(t *testing.T) {
 			if diff != "" {
 				t.Errorf("(-want, +got)\n%s", diff)
 			}
+
+			// tags are only created once an IP with them is published
+			for _, name := range test.addedTags {
+				if tag, _ := netboxClient.GetTag(context.Background(), name); tag != nil && !existing[name] {
+					t.Errorf("want tag %s not created, got %v", name, tag)
+				}
+			}
+		})
+	}
+}
+
+func TestWithQueueLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxSize       int
+		policy        string
+		parkDelay     time.Duration
+		expectedLimit *QueueLimit
+		errorExpected bool
+	}{{
+		name:   "no limit",
+		policy: QueueOverflowPark,
+	}, {
+		name:   "no limit with unknown policy",
+		policy: "block",
+	}, {
+		name:          "negative size",
+		maxSize:       -1,
+		policy:        QueueOverflowShed,
+		errorExpected: true,
+	}, {
+		name:          "unknown policy",
+		maxSize:       100,
+		policy:        "block",
+		errorExpected: true,
+	}, {
+		name:          "park without delay",
+		maxSize:       100,
+		policy:        QueueOverflowPark,
+		errorExpected: true,
+	}, {
+		name:          "park",
+		maxSize:       100,
+		policy:        QueueOverflowPark,
+		parkDelay:     time.Minute,
+		expectedLimit: &QueueLimit{MaxSize: 100, Policy: QueueOverflowPark, ParkDelay: time.Minute},
+	}, {
+		name:          "shed",
+		maxSize:       100,
+		policy:        QueueOverflowShed,
+		expectedLimit: &QueueLimit{MaxSize: 100, Policy: QueueOverflowShed},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var s Settings
+			err := WithQueueLimit(test.maxSize, test.policy, test.parkDelay)(&s)
+			if test.errorExpected {
+				if err == nil {
+					t.Error("expected error but got nil")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("expected nil error but got %v", err)
+			}
+			if diff := cmp.Diff(test.expectedLimit, s.QueueLimit); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
 		})
 	}
 }
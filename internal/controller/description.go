@@ -0,0 +1,93 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/metrics"
+)
+
+// Strategies for shortening descriptions longer than NetBox allows.
+const (
+	// DescriptionStrategyTruncate cuts the description off at the limit.
+	DescriptionStrategyTruncate = "truncate"
+	// DescriptionStrategyDropLabels drops object labels, starting with
+	// the last one, until the description fits. The cluster and
+	// namespace are always kept. If the description is still too long,
+	// it is truncated.
+	DescriptionStrategyDropLabels = "drop-labels-by-priority"
+	// DescriptionStrategyHashSuffix truncates the description, and ends it
+	// with a hash of the full description, so that descriptions which only
+	// differ after the limit remain distinct.
+	DescriptionStrategyHashSuffix = "hash-suffix"
+)
+
+const descriptionSeparator = ", "
+
+// hashSuffixLength is the number of hex digits of the hash
+// appended by DescriptionStrategyHashSuffix.
+const hashSuffixLength = 8
+
+// description joins the given parts of a description, shortening the result
+// with the given strategy if it is longer than NetBox allows. The first
+// keep parts are never dropped by DescriptionStrategyDropLabels.
+func description(parts []string, keep int, strategy string) (string, error) {
+	desc := strings.Join(parts, descriptionSeparator)
+	if len(desc) <= v1beta1.DescriptionMaxLength {
+		return desc, nil
+	}
+
+	if strategy == "" {
+		strategy = DescriptionStrategyTruncate
+	}
+	metrics.IncrementTruncatedDescriptions(strategy)
+
+	switch strategy {
+	case DescriptionStrategyTruncate:
+		return truncate(desc, v1beta1.DescriptionMaxLength), nil
+	case DescriptionStrategyDropLabels:
+		for len(parts) > keep && len(desc) > v1beta1.DescriptionMaxLength {
+			parts = parts[:len(parts)-1]
+			desc = strings.Join(parts, descriptionSeparator)
+		}
+		return truncate(desc, v1beta1.DescriptionMaxLength), nil
+	case DescriptionStrategyHashSuffix:
+		sum := sha256.Sum256([]byte(desc))
+		suffix := " " + hex.EncodeToString(sum[:])[:hashSuffixLength]
+		return truncate(desc, v1beta1.DescriptionMaxLength-len(suffix)) + suffix, nil
+	default:
+		return "", fmt.Errorf("unknown description strategy %q", strategy)
+	}
+}
+
+// truncate cuts s off after at most n bytes,
+// without splitting a multi-byte character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return strings.TrimRight(s[:n], descriptionSeparator)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+)
+
+func TestDescription(t *testing.T) {
+	long := "app: " + strings.Repeat("x", 160)
+	parts := []string{"cluster: prod-1", "namespace: default", "a: foo", long}
+
+	tests := []struct {
+		name         string
+		parts        []string
+		strategy     string
+		expectedDesc string
+	}{{
+		name:         "short enough",
+		parts:        []string{"namespace: default", "a: foo"},
+		strategy:     DescriptionStrategyHashSuffix,
+		expectedDesc: "namespace: default, a: foo",
+	}, {
+		name:         "truncate",
+		parts:        parts,
+		strategy:     DescriptionStrategyTruncate,
+		expectedDesc: strings.Join(parts, ", ")[:v1beta1.DescriptionMaxLength],
+	}, {
+		name:         "drop labels",
+		parts:        parts,
+		strategy:     DescriptionStrategyDropLabels,
+		expectedDesc: "cluster: prod-1, namespace: default, a: foo",
+	}, {
+		name:         "drop labels keeps cluster and namespace",
+		parts:        []string{"cluster: prod-1", "namespace: " + strings.Repeat("n", 200)},
+		strategy:     DescriptionStrategyDropLabels,
+		expectedDesc: ("cluster: prod-1, namespace: " + strings.Repeat("n", 200))[:v1beta1.DescriptionMaxLength],
+	}, {
+		name:         "hash suffix",
+		parts:        parts,
+		strategy:     DescriptionStrategyHashSuffix,
+		expectedDesc: strings.Join(parts, ", ")[:v1beta1.DescriptionMaxLength-9] + " 13c690ec",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			desc, err := description(test.parts, 2, test.strategy)
+			if err != nil {
+				t.Fatalf("unexpected error: %q", err)
+			}
+			if desc != test.expectedDesc {
+				t.Errorf("want description %q, got %q", test.expectedDesc, desc)
+			}
+			if len(desc) > v1beta1.DescriptionMaxLength {
+				t.Errorf("want description of at most %d characters, got %d", v1beta1.DescriptionMaxLength, len(desc))
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	// must not split the two-byte "é"
+	if s := truncate("abcé", 4); s != "abc" {
+		t.Errorf("want %q, got %q", "abc", s)
+	}
+	// must not end with a separator
+	if s := truncate("abc, def", 5); s != "abc" {
+		t.Errorf("want %q, got %q", "abc", s)
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ClusterDomainFor returns the cluster domain of the given namespace, which is
+// set with ClusterDomainAnnotation on the namespace, or defaultDomain if the
+// annotation is not set.
+func ClusterDomainFor(ctx context.Context, kubeClient client.Client, namespace, defaultDomain string) (string, error) {
+	var ns corev1.Namespace
+	if err := kubeClient.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		return "", fmt.Errorf("retrieving namespace: %w", err)
+	}
+
+	domain, ok := ns.Annotations[netboxctrl.ClusterDomainAnnotation]
+	if !ok {
+		return defaultDomain, nil
+	}
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+	if errs := validation.IsDNS1123Subdomain(domain); len(errs) > 0 {
+		return "", fmt.Errorf("%s annotation value %q is invalid: %s", netboxctrl.ClusterDomainAnnotation, domain, strings.Join(errs, ", "))
+	}
+	return domain, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestClusterDomainFor(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+
+	namespace := func(name, domain string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{netboxctrl.ClusterDomainAnnotation: domain},
+		}}
+	}
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		namespace("vcluster", "vcluster.example.com."),
+		namespace("invalid", "not a domain"),
+	).Build()
+
+	tests := []struct {
+		name           string
+		namespace      string
+		expectedDomain string
+		errorExpected  bool
+	}{{
+		name:           "no annotation",
+		namespace:      "default",
+		expectedDomain: "cluster.local",
+	}, {
+		name:           "annotation",
+		namespace:      "vcluster",
+		expectedDomain: "vcluster.example.com",
+	}, {
+		name:          "invalid annotation",
+		namespace:     "invalid",
+		errorExpected: true,
+	}, {
+		name:          "missing namespace",
+		namespace:     "missing",
+		errorExpected: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			domain, err := ClusterDomainFor(context.Background(), kubeClient, test.namespace, "cluster.local")
+			if test.errorExpected {
+				if err == nil {
+					t.Error("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got %q", err)
+			}
+			if domain != test.expectedDomain {
+				t.Errorf("want domain %q, got %q", test.expectedDomain, domain)
+			}
+		})
+	}
+}
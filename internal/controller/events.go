@@ -0,0 +1,120 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// repeatKey identifies events that are collapsed into one. Unlike the
+// aggregation of client-go, it does not include the UID of the object,
+// so events of objects that are recreated over and over, like the
+// NetBoxIPs of a flapping pod, are collapsed as well. It includes the
+// message, so that events with different details, e.g. a different
+// address, are not hidden behind the first one.
+type repeatKey struct {
+	kind      string
+	namespace string
+	name      string
+	eventtype string
+	reason    string
+	message   string
+}
+
+// repeatedEvent holds the latest of the events suppressed in a window.
+type repeatedEvent struct {
+	object runtime.Object
+	count  int
+}
+
+// dedupRecorder is an event recorder that collapses repeated events.
+type dedupRecorder struct {
+	record.EventRecorder
+	window time.Duration
+	// afterFunc is time.AfterFunc, replaced in tests
+	afterFunc func(d time.Duration, f func()) *time.Timer
+
+	mu       sync.Mutex
+	repeated map[repeatKey]*repeatedEvent
+}
+
+// NewDedupRecorder returns an event recorder that records the first of events
+// with the same object, type, reason and message within the given window right
+// away, and collapses the others into a single event with their count, which is
+// recorded when the window ends, similar to the event spam filter of the kubelet.
+// Annotated events are not collapsed.
+func NewDedupRecorder(recorder record.EventRecorder, window time.Duration) record.EventRecorder {
+	return &dedupRecorder{
+		EventRecorder: recorder,
+		window:        window,
+		afterFunc:     time.AfterFunc,
+		repeated:      make(map[repeatKey]*repeatedEvent),
+	}
+}
+
+// Event records the event, unless it repeats an event of the current window.
+func (r *dedupRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	key := repeatKey{
+		kind:      fmt.Sprintf("%T", object),
+		eventtype: eventtype,
+		reason:    reason,
+		message:   message,
+	}
+	if accessor, err := meta.Accessor(object); err == nil {
+		key.namespace = accessor.GetNamespace()
+		key.name = accessor.GetName()
+	}
+
+	r.mu.Lock()
+	if repeated, ok := r.repeated[key]; ok {
+		repeated.object = object
+		repeated.count++
+		r.mu.Unlock()
+		return
+	}
+	r.repeated[key] = &repeatedEvent{}
+	r.afterFunc(r.window, func() { r.flush(key) })
+	r.mu.Unlock()
+
+	r.EventRecorder.Event(object, eventtype, reason, message)
+}
+
+// Eventf is like Event, but formats the message.
+func (r *dedupRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// flush ends the window of events with the given key, and records
+// the events suppressed in it, if any, as a single event.
+func (r *dedupRecorder) flush(key repeatKey) {
+	r.mu.Lock()
+	repeated := r.repeated[key]
+	delete(r.repeated, key)
+	r.mu.Unlock()
+
+	if repeated == nil || repeated.count == 0 {
+		return
+	}
+	r.EventRecorder.Eventf(repeated.object, key.eventtype, key.reason,
+		"%s (%d similar events in the last %s)", key.message, repeated.count, r.window)
+}
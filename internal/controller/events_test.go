@@ -0,0 +1,80 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDedupRecorder(t *testing.T) {
+	fake := record.NewFakeRecorder(10)
+	recorder := NewDedupRecorder(fake, 10*time.Minute).(*dedupRecorder)
+	var flushes []func()
+	recorder.afterFunc = func(_ time.Duration, f func()) *time.Timer {
+		flushes = append(flushes, f)
+		return nil
+	}
+
+	ip := func(uid string) *v1beta1.NetBoxIP {
+		return &v1beta1.NetBoxIP{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "pod-foo",
+			UID:       types.UID(uid),
+		}}
+	}
+	other := &v1beta1.NetBoxIP{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod-bar"}}
+
+	// the NetBoxIP is recreated with a new UID every time
+	recorder.Eventf(ip("1"), corev1.EventTypeWarning, "UnmanagedIP", "IP %s exists", "192.168.0.1")
+	recorder.Eventf(ip("2"), corev1.EventTypeWarning, "UnmanagedIP", "IP %s exists", "192.168.0.1")
+	recorder.Eventf(ip("3"), corev1.EventTypeWarning, "UnmanagedIP", "IP %s exists", "192.168.0.1")
+	// events with other details are not hidden behind the first one
+	recorder.Eventf(ip("3"), corev1.EventTypeWarning, "UnmanagedIP", "IP %s exists", "192.168.0.2")
+	recorder.Event(ip("3"), corev1.EventTypeWarning, "RejectedByNetBox", "rejected")
+	recorder.Event(other, corev1.EventTypeWarning, "UnmanagedIP", "IP 192.168.0.1 exists")
+
+	for _, flush := range flushes {
+		flush()
+	}
+	// a new window starts after the previous one ended
+	recorder.Event(ip("4"), corev1.EventTypeWarning, "UnmanagedIP", "IP 192.168.0.1 exists")
+
+	expected := []string{
+		"Warning UnmanagedIP IP 192.168.0.1 exists",
+		"Warning UnmanagedIP IP 192.168.0.2 exists",
+		"Warning RejectedByNetBox rejected",
+		"Warning UnmanagedIP IP 192.168.0.1 exists",
+		"Warning UnmanagedIP IP 192.168.0.1 exists (2 similar events in the last 10m0s)",
+		"Warning UnmanagedIP IP 192.168.0.1 exists",
+	}
+	var events []string
+	for len(fake.Events) > 0 {
+		events = append(events, <-fake.Events)
+	}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// PublishSettings are the settings of a pod or service controller
+// that can be changed while the controller is running.
+type PublishSettings struct {
+	Tags   []netbox.Tag
+	Labels map[string]bool
+	// Namespaces, if not empty, are the only namespaces
+	// in which IPs are published.
+	Namespaces map[string]bool
+	// ExcludedNamespaces are the namespaces in which
+	// IPs are never published.
+	ExcludedNamespaces map[string]bool
+}
+
+// PublishesNamespace checks if IPs in the given namespace may be published.
+func (s PublishSettings) PublishesNamespace(namespace string) bool {
+	if s.ExcludedNamespaces[namespace] {
+		return false
+	}
+	return len(s.Namespaces) == 0 || s.Namespaces[namespace]
+}
+
+// LiveSettings holds PublishSettings that may be replaced at runtime,
+// and notifies the controller using them whenever they change.
+type LiveSettings struct {
+	mu       sync.RWMutex
+	settings PublishSettings
+	changes  chan event.GenericEvent
+}
+
+// NewLiveSettings returns LiveSettings with the given initial value.
+func NewLiveSettings(settings PublishSettings) *LiveSettings {
+	return &LiveSettings{
+		settings: settings,
+		// a single pending notification is enough, since all
+		// objects are re-reconciled with the latest settings
+		changes: make(chan event.GenericEvent, 1),
+	}
+}
+
+// Get returns the current settings.
+func (l *LiveSettings) Get() PublishSettings {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.settings
+}
+
+// Set replaces the current settings. It returns true if the settings
+// have changed, in which case a notification is sent to Changes.
+func (l *LiveSettings) Set(settings PublishSettings) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if reflect.DeepEqual(l.settings, settings) {
+		return false
+	}
+	l.settings = settings
+
+	select {
+	case l.changes <- event.GenericEvent{Object: &metav1.PartialObjectMetadata{}}:
+	default:
+		// a notification is already pending
+	}
+	return true
+}
+
+// Changes returns the channel notified whenever the settings change.
+// The objects of the events carry no information.
+func (l *LiveSettings) Changes() <-chan event.GenericEvent {
+	return l.changes
+}
+
+// EnqueueAll returns an event handler that enqueues all objects of the type
+// of the given list for reconciliation, regardless of the event's object.
+func EnqueueAll(kubeClient client.Client, list client.ObjectList, logger *log.Logger) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, _ client.Object) []reconcile.Request {
+		objs := list.DeepCopyObject().(client.ObjectList)
+		if err := kubeClient.List(ctx, objs); err != nil {
+			logger.Error("failed to list objects to reconcile", log.Error(err))
+			return nil
+		}
+
+		items, err := meta.ExtractList(objs)
+		if err != nil {
+			logger.Error("failed to extract listed objects", log.Error(err))
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for _, item := range items {
+			obj, ok := item.(client.Object)
+			if !ok {
+				continue
+			}
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: obj.GetNamespace(),
+				Name:      obj.GetName(),
+			}})
+		}
+		return requests
+	})
+}
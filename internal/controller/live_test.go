@@ -0,0 +1,102 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+)
+
+func TestPublishesNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		settings  PublishSettings
+		namespace string
+		expected  bool
+	}{{
+		name:      "no filters",
+		namespace: "foo",
+		expected:  true,
+	}, {
+		name:      "included namespace",
+		settings:  PublishSettings{Namespaces: map[string]bool{"foo": true}},
+		namespace: "foo",
+		expected:  true,
+	}, {
+		name:      "namespace not included",
+		settings:  PublishSettings{Namespaces: map[string]bool{"foo": true}},
+		namespace: "bar",
+		expected:  false,
+	}, {
+		name:      "excluded namespace",
+		settings:  PublishSettings{ExcludedNamespaces: map[string]bool{"foo": true}},
+		namespace: "foo",
+		expected:  false,
+	}, {
+		name: "exclusion takes precedence",
+		settings: PublishSettings{
+			Namespaces:         map[string]bool{"foo": true},
+			ExcludedNamespaces: map[string]bool{"foo": true},
+		},
+		namespace: "foo",
+		expected:  false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.settings.PublishesNamespace(test.namespace); got != test.expected {
+				t.Errorf("want %t, got %t", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestLiveSettings(t *testing.T) {
+	settings := NewLiveSettings(PublishSettings{Labels: map[string]bool{"app": true}})
+
+	if settings.Set(PublishSettings{Labels: map[string]bool{"app": true}}) {
+		t.Error("want no change when setting equal settings")
+	}
+	select {
+	case <-settings.Changes():
+		t.Fatal("want no notification when setting equal settings")
+	default:
+	}
+
+	// notifications of several changes are coalesced
+	if !settings.Set(PublishSettings{Tags: []netbox.Tag{{Name: "foo", Slug: "foo"}}}) {
+		t.Error("want change when setting different settings")
+	}
+	if !settings.Set(PublishSettings{Tags: []netbox.Tag{{Name: "bar", Slug: "bar"}}}) {
+		t.Error("want change when setting different settings")
+	}
+	select {
+	case <-settings.Changes():
+	default:
+		t.Fatal("want notification after settings changed")
+	}
+	select {
+	case <-settings.Changes():
+		t.Fatal("want a single notification")
+	default:
+	}
+
+	if tags := settings.Get().Tags; len(tags) != 1 || tags[0].Name != "bar" {
+		t.Errorf("want latest settings, got tags %v", tags)
+	}
+}
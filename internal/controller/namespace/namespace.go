@@ -0,0 +1,282 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"time"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	runtimecontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ControllerName is the name of the namespace controller, which
+// names its workqueue and its rate budget of requests to NetBox.
+const ControllerName = "namespace"
+
+type controller struct {
+	reconciler *reconciler
+	// shard of namespaces to reconcile objects in, if sharded
+	shard *ctrl.Shard
+	// limit of the workqueue, if any
+	queueLimit *ctrl.QueueLimit
+	// rate limiter of requests to NetBox, if not shared
+	rateBudget *rate.Limiter
+}
+
+// New returns a new Controller that allocates prefixes for namespaces.
+func New(opts ...ctrl.Option) (ctrl.Controller, error) {
+	var s ctrl.Settings
+	for _, o := range opts {
+		if err := o(&s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.KubeClient == nil {
+		return nil, errors.New("kubernetes client is required for namespace controller")
+	}
+	if s.NamespacePrefixes == nil {
+		return nil, errors.New("namespace prefixes are required for namespace controller")
+	}
+
+	logger := log.L()
+	if s.Logger != nil {
+		logger = s.Logger
+	}
+
+	var recorder record.EventRecorder = &record.FakeRecorder{}
+	if s.Recorder != nil {
+		recorder = s.Recorder
+	}
+
+	return &controller{
+		shard:      s.Shard,
+		queueLimit: s.QueueLimit,
+		rateBudget: s.RateBudgets[ControllerName],
+		reconciler: &reconciler{
+			kubeClient:     s.KubeClient,
+			allocator:      s.NamespacePrefixes,
+			log:            logger.With(log.String("reconciler", ControllerName)),
+			recorder:       recorder,
+			parent:         s.NamespacePrefixParent,
+			length:         s.NamespacePrefixLength,
+			clusterTag:     s.ClusterTag,
+			deletionPolicy: s.DeletionPolicy,
+			slowThreshold:  s.SlowReconcileThreshold,
+		},
+	}, nil
+}
+
+// AddToManager attaches the controller to the given manager.
+func (c *controller) AddToManager(mgr manager.Manager) error {
+	q := ctrl.NewQueueTracker(ControllerName, c.queueLimit)
+	return builder.
+		ControllerManagedBy(mgr).
+		Named(ControllerName).
+		Watches(&corev1.Namespace{}, q.Handler(&handler.EnqueueRequestForObject{})).
+		WithEventFilter(ctrl.OnCreateAndUpdateFilter).
+		// namespaces that never requested a prefix are of no interest,
+		// but those that did must be seen until their finalizer is removed
+		WithEventFilter(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			_, requested := obj.GetAnnotations()[netboxctrl.AllocatePrefixAnnotation]
+			return requested || controllerutil.ContainsFinalizer(obj, netboxctrl.IPFinalizer)
+		})).
+		// with > 1 concurrent reconciles, namespaces would
+		// be racing for the same available prefix in NetBox
+		WithOptions(runtimecontroller.Options{MaxConcurrentReconciles: 1}).
+		Complete(q.Reconciler(ctrl.Budgeted(c.rateBudget, ctrl.Sharded(c.shard, c.reconciler))))
+}
+
+type reconciler struct {
+	kubeClient client.Client
+	allocator  netbox.PrefixAllocator
+	log        *log.Logger
+	recorder   record.EventRecorder
+	// prefix that prefixes of namespaces are allocated from,
+	// and their length unless their annotation says otherwise
+	parent     netip.Prefix
+	length     int
+	clusterTag string
+	// what happens to prefixes in NetBox when namespaces are deleted
+	deletionPolicy string
+	// slowThreshold, if greater than 0, is how long a reconciliation
+	// may take before a warning with a breakdown of its time is logged
+	slowThreshold time.Duration
+}
+
+// Reconcile is called on every change of a namespace that requests
+// a prefix. It allocates a prefix for the namespace, unless it has
+// one already, and removes it once the namespace is deleted.
+func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ll := r.log.With(log.String("namespace", req.Name))
+	ctx, ll = ctrl.WithRequestID(ctx, ll)
+
+	ll.Info("reconciling namespace")
+
+	ctx, outcome := ctrl.WithOutcome(ctx)
+	result, err := r.reconcileNamespace(ctx, ll, req)
+	outcome.Log(ll, err)
+	outcome.WarnIfSlow(ll, r.slowThreshold)
+	return result, err
+}
+
+func (r *reconciler) reconcileNamespace(ctx context.Context, ll *log.Logger, req reconcile.Request) (reconcile.Result, error) {
+	var ns corev1.Namespace
+	if err := r.kubeClient.Get(ctx, client.ObjectKey{Name: req.Name}, &ns); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return reconcile.Result{}, fmt.Errorf("retrieving namespace: %w", err)
+		}
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonNotFound)
+		return reconcile.Result{}, nil
+	}
+	ll = ll.With(log.String("uid", string(ns.UID)))
+
+	if !ns.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&ns, netboxctrl.IPFinalizer) {
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonNotFound)
+			return reconcile.Result{}, nil
+		}
+		if err := r.removePrefix(ctx, ll, &ns); err != nil {
+			return reconcile.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(&ns, netboxctrl.IPFinalizer)
+		if err := r.kubeClient.Update(ctx, &ns); err != nil {
+			return reconcile.Result{}, fmt.Errorf("removing finalizer: %w", err)
+		}
+		return reconcile.Result{}, nil
+	}
+
+	requested, ok := ns.Annotations[netboxctrl.AllocatePrefixAnnotation]
+	if !ok {
+		// a prefix allocated before is kept until the namespace is deleted
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonNotPublished)
+		return reconcile.Result{}, nil
+	}
+	if allocated, ok := ns.Annotations[netboxctrl.PrefixAnnotation]; ok {
+		ll.Debug("prefix already allocated", log.String("prefix", allocated))
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonUpToDate)
+		return reconcile.Result{}, nil
+	}
+
+	length, err := r.prefixLength(requested)
+	if err != nil {
+		// no point in retrying until the annotation changes
+		ll.Warn("not allocating prefix: invalid annotation", log.Error(err))
+		r.recorder.Eventf(&ns, corev1.EventTypeWarning, "InvalidPrefixLength",
+			"Not allocating a prefix from %s: %s", r.parent, err)
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonAllocationFailed)
+		return reconcile.Result{}, nil
+	}
+
+	// the finalizer is added before allocating, so
+	// that no allocated prefix is left behind in NetBox
+	if !controllerutil.ContainsFinalizer(&ns, netboxctrl.IPFinalizer) {
+		controllerutil.AddFinalizer(&ns, netboxctrl.IPFinalizer)
+		if err := r.kubeClient.Update(ctx, &ns); err != nil {
+			return reconcile.Result{}, fmt.Errorf("setting finalizer: %w", err)
+		}
+	}
+
+	prefix, err := r.allocator.AllocatePrefix(ctx, r.parent, length, r.description(&ns))
+	if errors.Is(err, netbox.ErrPrefixNotFound) || errors.Is(err, netbox.ErrPrefixFull) {
+		// retried with a backoff, since the parent prefix may be
+		// created or have space freed up in NetBox in the meantime
+		r.recorder.Eventf(&ns, corev1.EventTypeWarning, "AllocationFailed",
+			"Failed to allocate a /%d from prefix %s: %s", length, r.parent, err)
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeError, ctrl.ReasonAllocationFailed)
+	}
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("allocating prefix: %w", err)
+	}
+
+	patch := client.MergeFrom(ns.DeepCopy())
+	if ns.Annotations == nil {
+		ns.Annotations = make(map[string]string)
+	}
+	ns.Annotations[netboxctrl.PrefixAnnotation] = prefix.String()
+	if err := r.kubeClient.Patch(ctx, &ns, patch); err != nil {
+		return reconcile.Result{}, fmt.Errorf("recording allocated prefix: %w", err)
+	}
+
+	ll.Info("allocated prefix", log.Stringer("prefix", prefix))
+	r.recorder.Eventf(&ns, corev1.EventTypeNormal, "Allocated",
+		"Allocated prefix %s from prefix %s in NetBox", prefix, r.parent)
+	ctrl.RecordOutcome(ctx, ctrl.OutcomeCreated, ctrl.ReasonAllocated)
+	return reconcile.Result{}, nil
+}
+
+// removePrefix removes the prefix of the deleted namespace
+// from NetBox, unless the deletion policy keeps it.
+func (r *reconciler) removePrefix(ctx context.Context, ll *log.Logger, ns *corev1.Namespace) error {
+	if r.deletionPolicy == ctrl.DeletionPolicyRetain || r.deletionPolicy == ctrl.DeletionPolicyDeprecate {
+		ll.Info("kept prefix: namespace was removed")
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonKept)
+		return nil
+	}
+
+	if err := r.allocator.DeletePrefix(ctx, r.parent, r.description(ns)); err != nil {
+		return fmt.Errorf("deleting prefix: %w", err)
+	}
+	ll.Info("deleted prefix: namespace was removed")
+	ctrl.RecordOutcome(ctx, ctrl.OutcomeDeleted, ctrl.ReasonRemoved)
+	return nil
+}
+
+// prefixLength returns the length of the prefix requested
+// with the value of the allocate prefix annotation.
+func (r *reconciler) prefixLength(requested string) (int, error) {
+	if requested == "true" {
+		return r.length, nil
+	}
+	length, err := strconv.Atoi(requested)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be true or a prefix length, not %q", netboxctrl.AllocatePrefixAnnotation, requested)
+	}
+	if length <= r.parent.Bits() || length > r.parent.Addr().BitLen() {
+		return 0, fmt.Errorf("prefix length %d must be longer than /%d", length, r.parent.Bits())
+	}
+	return length, nil
+}
+
+// description returns the description of the prefix of the namespace in
+// NetBox, by which it is found again. It includes the UID of the namespace,
+// so that a namespace re-created with the same name gets a prefix of its own.
+func (r *reconciler) description(ns *corev1.Namespace) string {
+	if r.clusterTag != "" {
+		return fmt.Sprintf("namespace %s in cluster %s (%s)", ns.Name, r.clusterTag, ns.UID)
+	}
+	return fmt.Sprintf("namespace %s (%s)", ns.Name, ns.UID)
+}
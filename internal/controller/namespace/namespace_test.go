@@ -0,0 +1,179 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var req = reconcile.Request{NamespacedName: types.NamespacedName{Name: "foo"}}
+
+func newNamespace(annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        req.Name,
+			UID:         "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4",
+			Annotations: annotations,
+		},
+	}
+}
+
+func newReconciler(t *testing.T, netboxClient netbox.Client, opts []ctrl.Option, objs ...*corev1.Namespace) *reconciler {
+	t.Helper()
+
+	b := fakeclient.NewClientBuilder().WithScheme(scheme.Scheme)
+	for _, obj := range objs {
+		b = b.WithObjects(obj)
+	}
+
+	c, err := New(append([]ctrl.Option{
+		ctrl.WithKubernetesClient(b.Build()),
+		ctrl.WithLogger(log.NewNop()),
+		ctrl.WithEventRecorder(record.NewFakeRecorder(10)),
+		ctrl.WithNamespacePrefixes(netip.MustParsePrefix("10.0.0.0/16"), 24, netboxClient),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*controller).reconciler
+}
+
+func TestReconcileAllocatesPrefix(t *testing.T) {
+	tests := []struct {
+		name              string
+		annotations       map[string]string
+		expectedPrefix    string
+		expectedFinalizer bool
+	}{{
+		name:              "default length",
+		annotations:       map[string]string{netboxctrl.AllocatePrefixAnnotation: "true"},
+		expectedPrefix:    "10.0.1.0/24",
+		expectedFinalizer: true,
+	}, {
+		name:              "requested length",
+		annotations:       map[string]string{netboxctrl.AllocatePrefixAnnotation: "26"},
+		expectedPrefix:    "10.0.0.64/26",
+		expectedFinalizer: true,
+	}, {
+		name:        "invalid length",
+		annotations: map[string]string{netboxctrl.AllocatePrefixAnnotation: "8"},
+	}, {
+		name:        "not requested",
+		annotations: map[string]string{},
+	}, {
+		name: "allocated before",
+		annotations: map[string]string{
+			netboxctrl.AllocatePrefixAnnotation: "true",
+			netboxctrl.PrefixAnnotation:         "10.0.7.0/24",
+		},
+		expectedPrefix: "10.0.7.0/24",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			netboxClient := netbox.NewFakeClient(nil, nil)
+			// taken by another namespace
+			if _, err := netboxClient.(netbox.PrefixAllocator).AllocatePrefix(context.Background(), netip.MustParsePrefix("10.0.0.0/16"), 26, "other"); err != nil {
+				t.Fatal(err)
+			}
+			r := newReconciler(t, netboxClient, nil, newNamespace(test.annotations))
+
+			if _, err := r.Reconcile(context.Background(), req); err != nil {
+				t.Fatalf("reconciling: %s", err)
+			}
+
+			var ns corev1.Namespace
+			if err := r.kubeClient.Get(context.Background(), req.NamespacedName, &ns); err != nil {
+				t.Fatal(err)
+			}
+			if prefix := ns.Annotations[netboxctrl.PrefixAnnotation]; prefix != test.expectedPrefix {
+				t.Errorf("want prefix %q, got %q", test.expectedPrefix, prefix)
+			}
+			if test.expectedFinalizer != (len(ns.Finalizers) == 1) {
+				t.Errorf("want finalizer: %t, got %v", test.expectedFinalizer, ns.Finalizers)
+			}
+		})
+	}
+}
+
+func TestReconcileDeletedNamespace(t *testing.T) {
+	tests := []struct {
+		name           string
+		deletionPolicy string
+		expectDeleted  bool
+	}{{
+		name:           "delete",
+		deletionPolicy: ctrl.DeletionPolicyDelete,
+		expectDeleted:  true,
+	}, {
+		name:           "retain",
+		deletionPolicy: ctrl.DeletionPolicyRetain,
+		expectDeleted:  false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := metav1.NewTime(time.Now())
+			ns := newNamespace(map[string]string{netboxctrl.AllocatePrefixAnnotation: "true"})
+			ns.Finalizers = []string{netboxctrl.IPFinalizer}
+			ns.DeletionTimestamp = &now
+
+			netboxClient := netbox.NewFakeClient(nil, nil)
+			r := newReconciler(t, netboxClient, []ctrl.Option{ctrl.WithDeletionPolicy(test.deletionPolicy)}, ns)
+
+			parent := netip.MustParsePrefix("10.0.0.0/16")
+			allocated, err := netboxClient.(netbox.PrefixAllocator).AllocatePrefix(context.Background(), parent, 24, r.description(ns))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := r.Reconcile(context.Background(), req); err != nil {
+				t.Fatalf("reconciling: %s", err)
+			}
+
+			// allocating again returns the same prefix, unless it was deleted
+			prefix, err := netboxClient.(netbox.PrefixAllocator).AllocatePrefix(context.Background(), parent, 26, r.description(ns))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted := prefix != allocated; deleted != test.expectDeleted {
+				t.Errorf("want prefix deleted: %t, got %t", test.expectDeleted, deleted)
+			}
+			// without the finalizer, the namespace is gone
+			if err := r.kubeClient.Get(context.Background(), req.NamespacedName, ns); !kubeerrors.IsNotFound(err) {
+				t.Errorf("want namespace deleted, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netboxip
+
+import (
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// debouncer delays removing IPs from NetBox after their NetBoxIPs are
+// deleted, so that a NetBoxIP with the same address created in the meantime,
+// e.g. during a rolling update, takes over the IP, which is then updated
+// rather than deleted and created again. Like the coordinator, it is only
+// used by the reconciler, which runs one reconciliation at a time.
+type debouncer struct {
+	window time.Duration
+	// NetBoxIPs being deleted whose IPs are kept
+	// until the window passes, by debounce key
+	pending map[string]types.UID
+	// NetBoxIPs being deleted whose IPs have been offered
+	// to another NetBoxIP, and are not offered again
+	taken map[types.UID]bool
+}
+
+func newDebouncer(window time.Duration) *debouncer {
+	return &debouncer{
+		window:  window,
+		pending: make(map[string]types.UID),
+		taken:   make(map[types.UID]bool),
+	}
+}
+
+// debounceKey returns the key of the address of a NetBoxIP: the IP of a
+// deleted NetBoxIP may be taken over by one with the same key. It includes
+// the tenant, since IPs of different tenants may be written with different
+// credentials.
+func debounceKey(ip *v1beta1.NetBoxIP) string {
+	return sharedAddressKey(ip) + "|" + ip.Spec.Tenant
+}
+
+// delay returns how much longer the IP of the given NetBoxIP, which is being
+// deleted, is kept, and registers it for being taken over in the meantime.
+// Once the window has passed, it returns 0, and the IP is no longer offered.
+func (d *debouncer) delay(ip *v1beta1.NetBoxIP, now time.Time) time.Duration {
+	key := debounceKey(ip)
+	remaining := ip.DeletionTimestamp.Add(d.window).Sub(now)
+	if remaining <= 0 {
+		if d.pending[key] == ip.UID {
+			delete(d.pending, key)
+		}
+		delete(d.taken, ip.UID)
+		return 0
+	}
+	if !d.taken[ip.UID] {
+		d.pending[key] = ip.UID
+	}
+	return remaining
+}
+
+// takeOver returns the UID of the IP that the given NetBoxIP may take
+// over, if any, and stops offering it to other NetBoxIPs. If it is not
+// taken over, e.g. because the NetBoxIP already has an IP or upserting it
+// fails, it is removed once the window passes, like any other.
+func (d *debouncer) takeOver(ip *v1beta1.NetBoxIP) netbox.UID {
+	key := debounceKey(ip)
+	uid, ok := d.pending[key]
+	if !ok || uid == ip.UID {
+		return ""
+	}
+	delete(d.pending, key)
+	d.taken[uid] = true
+	return netbox.UID(uid)
+}
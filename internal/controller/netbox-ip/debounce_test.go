@@ -0,0 +1,136 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netboxip
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileWithDeletionDebounce(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+	now := metav1.Now()
+	addr := netip.MustParseAddr("192.168.0.1")
+
+	tests := []struct {
+		name string
+		// whether a NetBoxIP with the same address is created
+		// while the IP of the deleted one is kept
+		recreated bool
+	}{{
+		name:      "recreated within the window",
+		recreated: true,
+	}, {
+		name: "not recreated",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objs := []client.Object{&v1beta1.NetBoxIP{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "old",
+					Namespace:         "test",
+					UID:               "old-uid",
+					Finalizers:        []string{netboxctrl.IPFinalizer},
+					DeletionTimestamp: &now,
+				},
+				Spec: v1beta1.NetBoxIPSpec{Address: addr, DNSName: "old"},
+			}}
+			if test.recreated {
+				objs = append(objs, &v1beta1.NetBoxIP{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       "new",
+						Namespace:  "test",
+						UID:        "new-uid",
+						Finalizers: []string{netboxctrl.IPFinalizer},
+					},
+					Spec: v1beta1.NetBoxIPSpec{Address: addr, DNSName: "new"},
+				})
+			}
+
+			ips := map[netbox.UID]netbox.IPAddress{
+				"old-uid": {ID: 1, UID: "old-uid", Address: netbox.IP(addr), DNSName: "old"},
+			}
+			r := &reconciler{
+				netboxClient: netbox.NewFakeClient(nil, ips),
+				kubeClient:   fakeclient.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&v1beta1.NetBoxIP{}).WithObjects(objs...).Build(),
+				log:          log.L(),
+				recorder:     record.NewFakeRecorder(10),
+				debouncer:    newDebouncer(time.Hour),
+			}
+
+			oldReq := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "old"}}
+			res, err := r.Reconcile(context.Background(), oldReq)
+			if err != nil {
+				t.Fatalf("reconciling deleted netboxip: %q", err)
+			}
+			if res.RequeueAfter <= 0 || res.RequeueAfter > time.Hour {
+				t.Errorf("want requeue within the window, got %s", res.RequeueAfter)
+			}
+			if _, ok := ips["old-uid"]; !ok {
+				t.Error("want IP of deleted netboxip kept within the window")
+			}
+
+			if test.recreated {
+				newReq := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "new"}}
+				if _, err := r.Reconcile(context.Background(), newReq); err != nil {
+					t.Fatalf("reconciling new netboxip: %q", err)
+				}
+				if _, ok := ips["old-uid"]; ok {
+					t.Error("want IP of deleted netboxip taken over")
+				}
+				if ip, ok := ips["new-uid"]; !ok || ip.DNSName != "new" {
+					t.Errorf("want IP updated for new netboxip, got %v", ip)
+				}
+			}
+
+			// the window has passed
+			r.debouncer.window = 0
+			if _, err := r.Reconcile(context.Background(), oldReq); err != nil {
+				t.Fatalf("reconciling deleted netboxip: %q", err)
+			}
+			if _, ok := ips["old-uid"]; ok {
+				t.Error("want IP of deleted netboxip removed after the window")
+			}
+			if _, ok := ips["new-uid"]; ok != test.recreated {
+				t.Errorf("want IP of new netboxip: %t, got %t", test.recreated, ok)
+			}
+
+			var ip v1beta1.NetBoxIP
+			if err := r.kubeClient.Get(context.Background(), oldReq.NamespacedName, &ip); !kubeerrors.IsNotFound(err) {
+				t.Errorf("want deleted netboxip gone after the window, got error %v", err)
+			}
+		})
+	}
+}
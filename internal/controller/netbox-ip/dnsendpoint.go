@@ -0,0 +1,95 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netboxip
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+
+	log "go.uber.org/zap"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// DNSEndpointGVK is the group, version and kind of external-dns DNSEndpoint resource.
+var DNSEndpointGVK = schema.GroupVersionKind{
+	Group:   "externaldns.k8s.io",
+	Version: "v1alpha1",
+	Kind:    "DNSEndpoint",
+}
+
+// dnsEndpointFor returns an empty DNSEndpoint object with the same name
+// and namespace as the given NetBoxIP.
+func dnsEndpointFor(ip *v1beta1.NetBoxIP) *unstructured.Unstructured {
+	endpoint := &unstructured.Unstructured{}
+	endpoint.SetGroupVersionKind(DNSEndpointGVK)
+	endpoint.SetNamespace(ip.Namespace)
+	endpoint.SetName(ip.Name)
+	return endpoint
+}
+
+// upsertDNSEndpoint makes sure that there is a DNSEndpoint with an A or AAAA record
+// pointing the DNS name of the IP to its address. The DNSEndpoint is owned by the NetBoxIP,
+// so that it is garbage-collected together with it.
+func (r *reconciler) upsertDNSEndpoint(ctx context.Context, ll *log.Logger, ip *v1beta1.NetBoxIP) error {
+	endpoint := dnsEndpointFor(ip)
+
+	if ip.Spec.DNSName == "" {
+		if err := r.kubeClient.Delete(ctx, endpoint); err != nil && !kubeerrors.IsNotFound(err) {
+			return fmt.Errorf("deleting dnsendpoint: %w", err)
+		}
+		return nil
+	}
+
+	recordType := "A"
+	if ip.Spec.Address.Is6() {
+		recordType = "AAAA"
+	}
+
+	result, err := controllerutil.CreateOrUpdate(ctx, r.kubeClient, endpoint, func() error {
+		labels := endpoint.GetLabels()
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels["app.kubernetes.io/managed-by"] = "netbox-ip-controller"
+		endpoint.SetLabels(labels)
+
+		endpoints := []interface{}{
+			map[string]interface{}{
+				"dnsName":    ip.Spec.DNSName,
+				"recordType": recordType,
+				"targets":    []interface{}{ip.Spec.Address.String()},
+			},
+		}
+		if err := unstructured.SetNestedSlice(endpoint.Object, endpoints, "spec", "endpoints"); err != nil {
+			return err
+		}
+
+		return controllerutil.SetControllerReference(ip, endpoint, r.kubeClient.Scheme())
+	})
+	if err != nil {
+		return fmt.Errorf("upserting dnsendpoint: %w", err)
+	}
+	if result != controllerutil.OperationResultNone {
+		ll.Info("upserted dnsendpoint", log.String("result", string(result)))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netboxip
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	log "go.uber.org/zap"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileDNSEndpoint(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	ip := &v1beta1.NetBoxIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "foo",
+			Namespace:  "test",
+			UID:        types.UID("123abc"),
+			Finalizers: []string{netboxctrl.IPFinalizer},
+		},
+		Spec: v1beta1.NetBoxIPSpec{
+			Address: netip.MustParseAddr("192.168.0.1"),
+			DNSName: "foo.test.svc.cluster.local",
+		},
+	}
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(ip).Build()
+
+	r := &reconciler{
+		netboxClient: netbox.NewFakeClient(nil, nil),
+		kubeClient:   kubeClient,
+		log:          log.L(),
+		recorder:     record.NewFakeRecorder(10),
+		dnsEndpoints: true,
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "foo"}}
+
+	steps := []struct {
+		name string
+		// modifies the NetBoxIP before reconciling
+		modify            func(spec *v1beta1.NetBoxIPSpec)
+		expectedEndpoints []interface{}
+	}{{
+		name:   "created",
+		modify: func(*v1beta1.NetBoxIPSpec) {},
+		expectedEndpoints: []interface{}{map[string]interface{}{
+			"dnsName":    "foo.test.svc.cluster.local",
+			"recordType": "A",
+			"targets":    []interface{}{"192.168.0.1"},
+		}},
+	}, {
+		name:   "address changed",
+		modify: func(spec *v1beta1.NetBoxIPSpec) { spec.Address = netip.MustParseAddr("fd00::1") },
+		expectedEndpoints: []interface{}{map[string]interface{}{
+			"dnsName":    "foo.test.svc.cluster.local",
+			"recordType": "AAAA",
+			"targets":    []interface{}{"fd00::1"},
+		}},
+	}, {
+		name:   "DNS name removed",
+		modify: func(spec *v1beta1.NetBoxIPSpec) { spec.DNSName = "" },
+	}}
+
+	for _, step := range steps {
+		var current v1beta1.NetBoxIP
+		if err := kubeClient.Get(ctx, req.NamespacedName, &current); err != nil {
+			t.Fatalf("%s: retrieving netboxip: %q", step.name, err)
+		}
+		step.modify(&current.Spec)
+		if err := kubeClient.Update(ctx, &current); err != nil {
+			t.Fatalf("%s: updating netboxip: %q", step.name, err)
+		}
+
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("%s: reconciling: %q", step.name, err)
+		}
+
+		endpoint := &unstructured.Unstructured{}
+		endpoint.SetGroupVersionKind(DNSEndpointGVK)
+		err := kubeClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "foo"}, endpoint)
+		if step.expectedEndpoints == nil {
+			if !kubeerrors.IsNotFound(err) {
+				t.Errorf("%s: want dnsendpoint to be deleted, got error %v", step.name, err)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%s: retrieving dnsendpoint: %q", step.name, err)
+		}
+
+		endpoints, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+		if diff := cmp.Diff(step.expectedEndpoints, endpoints); diff != "" {
+			t.Errorf("%s: endpoints (-want, +got)\n%s", step.name, diff)
+		}
+		if owner := metav1.GetControllerOf(endpoint); owner == nil || owner.UID != ip.UID {
+			t.Errorf("%s: want dnsendpoint to be owned by netboxip, got owner %v", step.name, owner)
+		}
+	}
+}
@@ -20,23 +20,58 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	netboxctrl "github.com/digitalocean/netbox-ip-controller"
 	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
 	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/digitalocean/netbox-ip-controller/internal/metrics"
 	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+	"github.com/digitalocean/netbox-ip-controller/internal/webhook"
 
 	log "go.uber.org/zap"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	runtimecontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Names of the NetBoxIP controllers, which name their workqueues
+// and their rate budgets of requests to NetBox. NetBoxIPs of services
+// are only reconciled by a controller of their own if service IPs
+// are prioritized.
+const (
+	ControllerName        = "netboxip"
+	ServiceControllerName = "netboxip-service"
 )
 
 type controller struct {
 	reconciler *reconciler
+	// shard of namespaces to reconcile objects in, if sharded
+	shard *ctrl.Shard
+	// limit of the workqueues, if any
+	queueLimit *ctrl.QueueLimit
+	// rate limiters of requests to NetBox of the
+	// workqueues that do not share one, by name
+	rateBudgets map[string]*rate.Limiter
+	// if set, reconciles the NetBoxIPs of services
+	// with a workqueue and worker of its own
+	serviceReconciler *reconciler
+	// address to serve NetBox webhooks on, if any
+	webhookAddr   string
+	webhookSecret string
+	uidFieldName  string
 }
 
 // New returns a new Controller for NetBoxIP resource.
@@ -63,32 +98,159 @@ func New(opts ...ctrl.Option) (ctrl.Controller, error) {
 		logger = s.Logger
 	}
 
-	return &controller{
+	var recorder record.EventRecorder = &record.FakeRecorder{}
+	if s.Recorder != nil {
+		recorder = s.Recorder
+	}
+
+	tagCache := s.TagCache
+	if tagCache == nil {
+		tagCache = ctrl.NewTagCache(s.NetBoxClient, logger, 0)
+	}
+
+	c := &controller{
+		shard:         s.Shard,
+		queueLimit:    s.QueueLimit,
+		rateBudgets:   s.RateBudgets,
+		webhookAddr:   s.NetBoxWebhookAddr,
+		webhookSecret: s.NetBoxWebhookSecret,
+		uidFieldName:  s.UIDFieldName,
 		reconciler: &reconciler{
-			kubeClient:   s.KubeClient,
-			netboxClient: s.NetBoxClient,
-			log:          logger.With(log.String("reconciler", "netboxip")),
+			kubeClient:     s.KubeClient,
+			netboxClient:   s.NetBoxClient,
+			log:            logger.With(log.String("reconciler", ControllerName)),
+			recorder:       recorder,
+			tenantClients:  s.TenantNetBoxClients,
+			webhook:        s.WebhookSink,
+			dnsEndpoints:   s.DNSEndpoints,
+			deletionPolicy: s.DeletionPolicy,
+			requeueAfter:   s.RequeueInterval,
+			slowThreshold:  s.SlowReconcileThreshold,
+			tagCache:       tagCache,
 		},
-	}, nil
+	}
+	if s.SharedAddresses {
+		c.reconciler.coordinator = newCoordinator()
+	}
+	if s.DeletionDebounce > 0 {
+		c.reconciler.debouncer = newDebouncer(s.DeletionDebounce)
+	}
+	if s.ServiceIPPriority {
+		if c.reconciler.coordinator != nil {
+			return nil, errors.New("prioritizing service IPs is not supported with shared addresses")
+		}
+		// the reconcilers run concurrently, so they
+		// must not share the state of the debouncer
+		svc := *c.reconciler
+		svc.log = logger.With(log.String("reconciler", ServiceControllerName))
+		if s.DeletionDebounce > 0 {
+			svc.debouncer = newDebouncer(s.DeletionDebounce)
+		}
+		c.serviceReconciler = &svc
+	}
+	return c, nil
 }
 
 // AddToManager attaches the controller to the given manager.
 func (c *controller) AddToManager(mgr manager.Manager) error {
-	return builder.
+	var watches []func(b *builder.Builder, q *ctrl.QueueTracker) *builder.Builder
+
+	if c.reconciler.coordinator != nil {
+		err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1beta1.NetBoxIP{}, addressIndexField, indexByAddress)
+		if err != nil {
+			return fmt.Errorf("indexing netboxips by address: %w", err)
+		}
+		watches = append(watches, func(b *builder.Builder, q *ctrl.QueueTracker) *builder.Builder {
+			return b.Watches(&v1beta1.NetBoxIP{}, q.Handler(enqueueFormerSharers(mgr.GetClient(), c.reconciler.log)))
+		})
+	}
+
+	if c.webhookAddr != "" {
+		err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1beta1.NetBoxIP{}, uidIndexField, func(o client.Object) []string {
+			return []string{string(o.GetUID())}
+		})
+		if err != nil {
+			return fmt.Errorf("indexing netboxips by UID: %w", err)
+		}
+
+		events := make(chan event.GenericEvent)
+		receiver := &webhookReceiver{
+			secret:       []byte(c.webhookSecret),
+			uidFieldName: c.uidFieldName,
+			kubeClient:   mgr.GetClient(),
+			log:          c.reconciler.log.With(log.String("receiver", "netbox-webhook")),
+			events:       events,
+		}
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return receiver.serve(ctx, c.webhookAddr)
+		})); err != nil {
+			return fmt.Errorf("adding NetBox webhook receiver: %w", err)
+		}
+
+		// the channel source broadcasts the events to every
+		// controller that watches it, which filters them
+		src := &source.Channel{Source: events}
+		watches = append(watches, func(b *builder.Builder, q *ctrl.QueueTracker) *builder.Builder {
+			return b.WatchesRawSource(src, q.Handler(&handler.EnqueueRequestForObject{}))
+		})
+	}
+
+	if c.serviceReconciler == nil {
+		return c.complete(mgr, ControllerName, c.reconciler, nil, watches)
+	}
+	if err := c.complete(mgr, ControllerName, c.reconciler, predicate.Not(ownedByService), watches); err != nil {
+		return err
+	}
+	return c.complete(mgr, ServiceControllerName, c.serviceReconciler, ownedByService, watches)
+}
+
+// complete builds a controller with the given name, which reconciles
+// NetBoxIPs accepted by the filter, if any, with the given reconciler.
+func (c *controller) complete(mgr manager.Manager, name string, r *reconciler, filter predicate.Predicate, watches []func(b *builder.Builder, q *ctrl.QueueTracker) *builder.Builder) error {
+	q := ctrl.NewQueueTracker(name, c.queueLimit)
+	b := builder.
 		ControllerManagedBy(mgr).
-		Named("netboxip").
-		For(&v1beta1.NetBoxIP{}).
+		Named(name).
+		Watches(&v1beta1.NetBoxIP{}, q.Handler(&handler.EnqueueRequestForObject{})).
 		WithEventFilter(ctrl.OnCreateAndUpdateFilter).
 		// with > 1 concurrent reconciles, we'd be risking creating
 		// duplicate IPs in NetBox
-		WithOptions(runtimecontroller.Options{MaxConcurrentReconciles: 1}).
-		Complete(c.reconciler)
+		WithOptions(runtimecontroller.Options{MaxConcurrentReconciles: 1})
+	if filter != nil {
+		b = b.WithEventFilter(filter)
+	}
+	for _, watch := range watches {
+		b = watch(b, q)
+	}
+	return b.Complete(q.Reconciler(ctrl.Budgeted(c.rateBudgets[name], ctrl.Sharded(c.shard, r))))
 }
 
 type reconciler struct {
 	netboxClient netbox.Client
 	kubeClient   client.Client
 	log          *log.Logger
+	recorder     record.EventRecorder
+	// NetBox clients with tenant-specific credentials, keyed by tenant slug
+	tenantClients map[string]netbox.Client
+	webhook       webhook.Sink
+	// if true, external-dns DNSEndpoints are created for IPs
+	dnsEndpoints bool
+	// what happens to IPs in NetBox when NetBoxIPs are deleted
+	deletionPolicy string
+	// how often NetBoxIPs are reconciled without changes, if at all
+	requeueAfter time.Duration
+	// slowThreshold, if greater than 0, is how long a reconciliation
+	// may take before a warning with a breakdown of its time is logged
+	slowThreshold time.Duration
+	// if set, NetBoxIPs with the same address and VRF
+	// share a single IP in NetBox
+	coordinator *coordinator
+	// if set, IPs of deleted NetBoxIPs are kept for a while,
+	// and may be taken over by NetBoxIPs with the same address
+	debouncer *debouncer
+	// resolves the tags of NetBoxIPs to their current
+	// values in NetBox, creating them on first use
+	tagCache *ctrl.TagCache
 }
 
 // Reconcile is called on every event that the given reconciler is watching,
@@ -98,9 +260,18 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		log.String("namespace", req.Namespace),
 		log.String("name", req.Name),
 	)
+	ctx, ll = ctrl.WithRequestID(ctx, ll)
 
 	ll.Info("reconciling netboxip")
 
+	ctx, outcome := ctrl.WithOutcome(ctx)
+	result, err := r.reconcileNetBoxIP(ctx, ll, req)
+	outcome.Log(ll, err)
+	outcome.WarnIfSlow(ll, r.slowThreshold)
+	return result, err
+}
+
+func (r *reconciler) reconcileNetBoxIP(ctx context.Context, ll *log.Logger, req reconcile.Request) (reconcile.Result, error) {
 	var ip v1beta1.NetBoxIP
 	err := r.kubeClient.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: req.Name}, &ip)
 	if err != nil {
@@ -108,6 +279,8 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 			ll.Error("failed to retrieve netboxip", log.Error(err))
 			return reconcile.Result{}, fmt.Errorf("retrieving netboxip: %w", err)
 		}
+		metrics.DeleteNetBoxIPState(req.Namespace, req.Name)
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonNotFound)
 		return reconcile.Result{}, nil
 	}
 
@@ -115,19 +288,90 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		log.String("uid", string(ip.UID)),
 		log.Any("ip", ip.Spec.Address),
 	)
+	manual := ctrl.IsManual(&ip)
+	if manual {
+		ll = ll.With(log.Bool("manual", true))
+	}
+
+	netboxClient := r.netboxClientFor(ip.Spec.Tenant)
+
+	var sharers []v1beta1.NetBoxIP
+	var shared bool
+	if r.coordinator != nil {
+		if sharers, err = r.sharers(ctx, &ip); err != nil {
+			return reconcile.Result{}, err
+		}
+		if shared, err = r.sharedExists(ctx, sharedAddressKey(&ip), netboxClient); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
 
 	if !ip.DeletionTimestamp.IsZero() {
 		// if deletion timestamp is set, that means the object is under deletion
 		// and waiting for finalizers to be executed
-		if err := r.netboxClient.DeleteIP(ctx, netbox.UID(ip.UID)); err != nil {
+		uid := netbox.UID(ip.UID)
+		if shared {
+			// the IP of the NetBoxIP was replaced by the shared one,
+			// which is removed together with the last of its NetBoxIPs
+			uid = sharedUID(sharedAddressKey(&ip))
+			delete(r.coordinator.merged, ip.UID)
+		}
+
+		if r.debouncer != nil && !shared {
+			if delay := r.debouncer.delay(&ip, time.Now()); delay > 0 {
+				ll.Debug("delaying removal of IP", log.Duration("delay", delay))
+				ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonDebounced)
+				return reconcile.Result{RequeueAfter: delay}, nil
+			}
+		}
+
+		if shared && len(sharers) > 0 {
+			if _, _, err := r.upsertShared(ctx, sharedAddressKey(&ip), sharers); err != nil {
+				return reconcile.Result{}, err
+			}
+			ll.Info("updated shared IP: netboxip was removed")
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeUpdated, ctrl.ReasonRemoved)
+		} else if r.deletionPolicy == ctrl.DeletionPolicyRetain {
+			// the client refuses to write the IP if its address in NetBox
+			// is outside of the allowed prefixes, and it is left there
+			if err := netboxClient.ReleaseIP(ctx, uid); errors.Is(err, netbox.ErrDisallowedIP) {
+				r.refused(ctx, ll, &ip, err)
+			} else if err != nil {
+				return reconcile.Result{}, fmt.Errorf("releasing IP: %w", err)
+			} else {
+				ll.Info("released IP: netboxip was removed")
+				ctrl.RecordOutcome(ctx, ctrl.OutcomeDeleted, ctrl.ReasonReleased)
+				r.notify(ctx, ll, webhook.EventReleased, &ip)
+			}
+		} else if r.deletionPolicy == ctrl.DeletionPolicyDeprecate {
+			note := fmt.Sprintf("(deleted %s)", ip.DeletionTimestamp.UTC().Format(time.RFC3339))
+			if err := netboxClient.DeprecateIP(ctx, uid, note); errors.Is(err, netbox.ErrDisallowedIP) {
+				r.refused(ctx, ll, &ip, err)
+			} else if err != nil {
+				return reconcile.Result{}, fmt.Errorf("deprecating IP: %w", err)
+			} else {
+				ll.Info("deprecated IP: netboxip was removed")
+				ctrl.RecordOutcome(ctx, ctrl.OutcomeDeleted, ctrl.ReasonDeprecated)
+				r.notify(ctx, ll, webhook.EventDeprecated, &ip)
+			}
+		} else if err := netboxClient.DeleteIP(ctx, uid); errors.Is(err, netbox.ErrDisallowedIP) {
+			r.refused(ctx, ll, &ip, err)
+		} else if err != nil {
 			return reconcile.Result{}, fmt.Errorf("deleting IP: %w", err)
+		} else {
+			ll.Info("deleted IP: netboxip was removed")
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeDeleted, ctrl.ReasonRemoved)
+			r.notify(ctx, ll, webhook.EventDeleted, &ip)
+		}
+		if shared && len(sharers) == 0 {
+			r.coordinator.shared[sharedAddressKey(&ip)] = false
 		}
-		ll.Info("deleted IP: netboxip was removed")
 
 		controllerutil.RemoveFinalizer(&ip, netboxctrl.IPFinalizer)
 		if err := r.kubeClient.Update(ctx, &ip); err != nil {
 			return reconcile.Result{}, fmt.Errorf("removing finalizer: %w", err)
 		}
+		metrics.DeleteNetBoxIPState(ip.Namespace, ip.Name)
 
 		return reconcile.Result{}, nil
 	}
@@ -141,6 +385,53 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		}
 	}
 
+	setSynced := func(synced bool) {
+		metrics.SetNetBoxIPState(ip.Namespace, ip.Name, ctrl.Scheme(ip.Spec.Address), synced)
+	}
+
+	if len(sharers) > 1 {
+		ipAddr, result, err := r.upsertShared(ctx, sharedAddressKey(&ip), sharers)
+		if errors.Is(err, netbox.ErrUnmanagedIP) {
+			setSynced(false)
+			ll.Warn("not upserting shared IP: it exists in NetBox, but is not managed by the controller")
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonUnmanagedIP)
+			return ctrl.Requeue(r.requeueAfter), r.updateStatus(ctx, &ip, false, nil)
+		}
+		if errors.Is(err, netbox.ErrDisallowedIP) {
+			// no point in retrying until the spec changes
+			setSynced(false)
+			r.refused(ctx, ll, &ip, err)
+			return reconcile.Result{}, r.updateStatus(ctx, &ip, false, nil)
+		}
+		if err != nil {
+			setSynced(false)
+			return reconcile.Result{}, err
+		}
+		setSynced(true)
+		if err := r.updateStatus(ctx, &ip, true, ipAddr); err != nil {
+			return reconcile.Result{}, err
+		}
+		if result != netbox.UpsertUnchanged {
+			ll.Info("upserted shared IP", log.Int64("id", ipAddr.ID), log.Int("sharers", len(sharers)))
+			ctrl.RecordOutcome(ctx, upsertOutcome(result), ctrl.ReasonPublished)
+			r.notify(ctx, ll, upsertEventType(result), &ip)
+		}
+
+		if r.dnsEndpoints {
+			if err := r.upsertDNSEndpoint(ctx, ll, &ip); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+
+		return ctrl.Requeue(r.requeueAfter), nil
+	}
+	if r.coordinator != nil {
+		if err := r.releaseShared(ctx, sharedAddressKey(&ip), &ip); err != nil {
+			setSynced(false)
+			return reconcile.Result{}, err
+		}
+	}
+
 	var tags []netbox.Tag
 	for _, t := range ip.Spec.Tags {
 		tags = append(tags, netbox.Tag{
@@ -148,20 +439,257 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 			Slug: t.Slug,
 		})
 	}
+	// the tags are created on first use, and may have been
+	// changed in NetBox since the NetBoxIP was written
+	if tags, err = r.tagCache.Resolve(ctx, tags); err != nil {
+		setSynced(false)
+		return reconcile.Result{}, fmt.Errorf("resolving tags: %w", err)
+	}
 
-	ipAddr, err := r.netboxClient.UpsertIP(ctx, &netbox.IPAddress{
-		UID:         netbox.UID(ip.UID),
-		DNSName:     ip.Spec.DNSName,
-		Address:     netbox.IP(ip.Spec.Address),
-		Tags:        tags,
-		Description: ip.Spec.Description,
+	var tenant *netbox.Tenant
+	if ip.Spec.Tenant != "" {
+		tenant = &netbox.Tenant{Slug: ip.Spec.Tenant}
+	}
+
+	var vrf *netbox.VRF
+	if ip.Spec.VRF != "" {
+		vrf = &netbox.VRF{Name: ip.Spec.VRF}
+	}
+
+	var takeOverUID netbox.UID
+	if r.debouncer != nil {
+		takeOverUID = r.debouncer.takeOver(&ip)
+	}
+	if uid, ok := ip.Annotations[netboxctrl.TakeOverAnnotation]; ok && takeOverUID == "" && ip.Status.NetBoxID == 0 {
+		// e.g. an IP allocated before the NetBoxIP was created
+		takeOverUID = netbox.UID(uid)
+	}
+
+	natInsideUID, err := r.natInsideUID(ctx, ll, &ip)
+	if err != nil {
+		setSynced(false)
+		return reconcile.Result{}, err
+	}
+
+	ipAddr, result, err := netboxClient.UpsertIP(ctx, &netbox.IPAddress{
+		// the ID saves looking the IP up by its UID
+		ID:                ip.Status.NetBoxID,
+		LastUpdated:       ip.Status.NetBoxLastUpdated,
+		UID:               netbox.UID(ip.UID),
+		DNSName:           ip.Spec.DNSName,
+		Address:           netbox.IP(ip.Spec.Address),
+		PrefixLength:      int(ip.Spec.PrefixLength),
+		Tags:              tags,
+		Description:       ip.Spec.Description,
+		Tenant:            tenant,
+		VRF:               vrf,
+		CustomFields:      ip.Spec.CustomFields,
+		AssignedInterface: assignedInterface(ip.Spec.AssignedObject),
+		NATInsideUID:      natInsideUID,
+		TakeOverUID:       takeOverUID,
 	})
+	if errors.Is(err, netbox.ErrUnmanagedIP) {
+		// no point in retrying until someone removes the IP from NetBox
+		setSynced(false)
+		r.recorder.Eventf(&ip, corev1.EventTypeWarning, "UnmanagedIP",
+			"Not creating IP %s in NetBox: it already exists there, but is not managed by the controller", ip.Spec.Address)
+		ll.Warn("not upserting IP: it exists in NetBox, but is not managed by the controller")
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonUnmanagedIP)
+		return ctrl.Requeue(r.requeueAfter), r.updateStatus(ctx, &ip, false, nil)
+	}
+	if errors.Is(err, netbox.ErrDisallowedIP) {
+		// no point in retrying until the spec changes
+		setSynced(false)
+		r.refused(ctx, ll, &ip, err)
+		return reconcile.Result{}, r.updateStatus(ctx, &ip, false, nil)
+	}
+	if reason, ok := netbox.RejectionReason(err); ok {
+		// retried, as the rejection may be caused by a change in NetBox,
+		// e.g. of a custom field, which is fixed without changing the spec
+		r.recorder.Eventf(&ip, corev1.EventTypeWarning, "RejectedByNetBox",
+			"NetBox rejected IP %s as invalid (%s): %s", ip.Spec.Address, reason, err)
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeError, ctrl.ReasonRejectedByNetBox)
+	}
+	var conflict *netbox.ConflictError
+	if errors.As(err, &conflict) {
+		// the conflict is reported once: with the last updated time
+		// recorded, the retry overwrites the IP as changed in NetBox
+		setSynced(false)
+		r.recorder.Eventf(&ip, corev1.EventTypeWarning, "Conflict",
+			"IP %s was changed in NetBox since it was last synced; overwriting it", ip.Spec.Address)
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeError, ctrl.ReasonConflict)
+		if err := r.updateStatus(ctx, &ip, false, conflict.IP); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
 	if err != nil {
+		setSynced(false)
 		return reconcile.Result{}, fmt.Errorf("upserting IP: %w", err)
 	}
-	if ipAddr != nil {
+	setSynced(true)
+	if err := r.updateStatus(ctx, &ip, true, ipAddr); err != nil {
+		return reconcile.Result{}, err
+	}
+	if result != netbox.UpsertUnchanged {
 		ll.Info("upserted IP", log.Int64("id", ipAddr.ID))
+		ctrl.RecordOutcome(ctx, upsertOutcome(result), ctrl.ReasonPublished)
+		r.notify(ctx, ll, upsertEventType(result), &ip)
+		if manual {
+			// there is no pod or service to look at
+			// for whether the IP has been published
+			r.recorder.Eventf(&ip, corev1.EventTypeNormal, "Published",
+				"Published IP %s to NetBox", ip.Spec.Address)
+		}
+	}
+
+	if r.dnsEndpoints {
+		if err := r.upsertDNSEndpoint(ctx, ll, &ip); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	return ctrl.Requeue(r.requeueAfter), nil
+}
+
+// updateStatus records the generation of the NetBoxIP as observed, and
+// as synced to NetBox if it was, so that it can be told whether its
+// current spec has been applied to NetBox, as well as the ID and URL
+// of upserted, if the IP was upserted, whether or not it was changed.
+// Failed reconciliations do not update the status, since they are retried.
+func (r *reconciler) updateStatus(ctx context.Context, ip *v1beta1.NetBoxIP, synced bool, upserted *netbox.IPAddress) error {
+	status := ip.Status
+	status.ObservedGeneration = ip.Generation
+	if synced {
+		status.SyncedGeneration = ip.Generation
+	}
+	if upserted != nil && upserted.ID != 0 {
+		status.NetBoxID = upserted.ID
+		status.NetBoxURL = upserted.WebURL
+		status.NetBoxLastUpdated = upserted.LastUpdated
+	}
+	if status.ObservedGeneration == ip.Status.ObservedGeneration &&
+		status.SyncedGeneration == ip.Status.SyncedGeneration &&
+		status.NetBoxID == ip.Status.NetBoxID &&
+		status.NetBoxURL == ip.Status.NetBoxURL &&
+		status.NetBoxLastUpdated == ip.Status.NetBoxLastUpdated {
+		return nil
 	}
 
-	return reconcile.Result{}, nil
+	patch := client.MergeFrom(ip.DeepCopy())
+	ip.Status = status
+	if err := r.kubeClient.Status().Patch(ctx, ip, patch); err != nil {
+		return fmt.Errorf("updating netboxip status: %w", err)
+	}
+	return nil
+}
+
+// natInsideUID returns the UID of the NetBoxIP that is the NAT inside
+// address of ip, or an empty UID if there is none. A NetBoxIP that does
+// not exist (yet) is skipped, so that ip is published without the link.
+func (r *reconciler) natInsideUID(ctx context.Context, ll *log.Logger, ip *v1beta1.NetBoxIP) (netbox.UID, error) {
+	if ip.Spec.NATInside == "" {
+		return "", nil
+	}
+	var inside v1beta1.NetBoxIP
+	err := r.kubeClient.Get(ctx, client.ObjectKey{Namespace: ip.Namespace, Name: ip.Spec.NATInside}, &inside)
+	if kubeerrors.IsNotFound(err) {
+		ll.Debug("NAT inside netboxip does not exist", log.String("natInside", ip.Spec.NATInside))
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("retrieving NAT inside netboxip: %w", err)
+	}
+	return netbox.UID(inside.UID), nil
+}
+
+// assignedInterface returns the NetBox interface referenced by obj, if any.
+func assignedInterface(obj *v1beta1.AssignedObject) *netbox.InterfaceRef {
+	if obj == nil {
+		return nil
+	}
+	ref := &netbox.InterfaceRef{
+		Type:       netbox.AssignedObjectTypeInterface,
+		ID:         obj.ID,
+		Name:       obj.Name,
+		Parent:     obj.Parent,
+		MACAddress: obj.MACAddress,
+	}
+	if obj.Kind == v1beta1.AssignedObjectKindVMInterface {
+		ref.Type = netbox.AssignedObjectTypeVMInterface
+	}
+	return ref
+}
+
+// netboxClientFor returns the NetBox client to use for IPs of the given tenant.
+func (r *reconciler) netboxClientFor(tenant string) netbox.Client {
+	if c, ok := r.tenantClients[tenant]; ok {
+		return c
+	}
+	return r.netboxClient
+}
+
+// upsertEventType returns the type of the lifecycle event of an upserted IP.
+func upsertEventType(result netbox.UpsertResult) string {
+	if result == netbox.UpsertCreated {
+		return webhook.EventCreated
+	}
+	return webhook.EventUpdated
+}
+
+// upsertOutcome returns the outcome of a reconciliation that upserted an IP.
+func upsertOutcome(result netbox.UpsertResult) string {
+	if result == netbox.UpsertCreated {
+		return ctrl.OutcomeCreated
+	}
+	return ctrl.OutcomeUpdated
+}
+
+// notify sends an IP lifecycle event to the webhook sink, if there is one.
+// Failing to deliver an event does not fail the reconciliation,
+// since the IP has already been synced.
+func (r *reconciler) notify(ctx context.Context, ll *log.Logger, eventType string, ip *v1beta1.NetBoxIP) {
+	if r.webhook == nil {
+		return
+	}
+
+	e := webhook.Event{
+		Type:        eventType,
+		UID:         string(ip.UID),
+		Address:     ip.Spec.Address.String(),
+		DNSName:     ip.Spec.DNSName,
+		Description: ip.Spec.Description,
+		Tenant:      ip.Spec.Tenant,
+	}
+	for _, t := range ip.Spec.Tags {
+		e.Tags = append(e.Tags, t.Name)
+	}
+	if owner := metav1.GetControllerOf(ip); owner != nil {
+		e.Source = &webhook.Source{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Namespace:  ip.Namespace,
+			Name:       owner.Name,
+			UID:        string(owner.UID),
+		}
+	}
+
+	if err := r.webhook.Send(ctx, e); err != nil {
+		metrics.IncrementWebhookFailures(eventType)
+		ll.Error("failed to send event to webhook", log.String("type", eventType), log.Error(err))
+	}
+}
+
+// refused handles the client refusing to write the IP, because its address
+// is outside of the allowed prefixes, by emitting an event on the NetBoxIP.
+func (r *reconciler) refused(ctx context.Context, ll *log.Logger, ip *v1beta1.NetBoxIP, err error) {
+	operation, address := "write", ip.Spec.Address
+	var disallowedErr *netbox.DisallowedIPError
+	if errors.As(err, &disallowedErr) {
+		// the address in NetBox may differ from that of the NetBoxIP
+		operation, address = disallowedErr.Operation, disallowedErr.Address
+	}
+	ll.Warn("not writing IP: outside of the allowed prefixes",
+		log.String("operation", operation), log.Stringer("address", address))
+	r.recorder.Eventf(ip, corev1.EventTypeWarning, "DisallowedIP",
+		"Refusing to %s IP %s in NetBox: it is outside of the allowed prefixes", operation, address)
+	ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonDisallowed)
 }
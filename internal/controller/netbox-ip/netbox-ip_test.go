@@ -24,7 +24,9 @@ import (
 
 	netboxctrl "github.com/digitalocean/netbox-ip-controller"
 	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
 	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+	"github.com/digitalocean/netbox-ip-controller/internal/webhook"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -33,6 +35,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -50,6 +53,7 @@ func TestReconcile(t *testing.T) {
 		name                string
 		existingIPInNetBox  *netbox.IPAddress
 		existingNetBoxIPObj *v1beta1.NetBoxIP
+		allowedPrefixes     []netip.Prefix
 		expectedIPInNetBox  *netbox.IPAddress
 		expectedNetBoxIPObj *v1beta1.NetBoxIP
 	}{{
@@ -169,6 +173,46 @@ func TestReconcile(t *testing.T) {
 				}},
 			},
 		},
+	}, {
+		name:               "netboxip with prefix length",
+		existingIPInNetBox: nil,
+		existingNetBoxIPObj: &v1beta1.NetBoxIP{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "NetBoxIP",
+				APIVersion: v1beta1.SchemeGroupVersion.String(),
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  namespace,
+				UID:        types.UID(uid),
+				Finalizers: []string{netboxctrl.IPFinalizer},
+			},
+			Spec: v1beta1.NetBoxIPSpec{
+				Address:      netip.AddrFrom4([4]byte{10, 0, 0, 0}),
+				PrefixLength: 24,
+			},
+		},
+		expectedIPInNetBox: &netbox.IPAddress{
+			UID:          netbox.UID(uid),
+			Address:      netbox.IP(netip.AddrFrom4([4]byte{10, 0, 0, 0})),
+			PrefixLength: 24,
+		},
+		expectedNetBoxIPObj: &v1beta1.NetBoxIP{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "NetBoxIP",
+				APIVersion: v1beta1.SchemeGroupVersion.String(),
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  namespace,
+				UID:        types.UID(uid),
+				Finalizers: []string{netboxctrl.IPFinalizer},
+			},
+			Spec: v1beta1.NetBoxIPSpec{
+				Address:      netip.AddrFrom4([4]byte{10, 0, 0, 0}),
+				PrefixLength: 24,
+			},
+		},
 	}, {
 		name: "netboxip deleted",
 		existingIPInNetBox: &netbox.IPAddress{
@@ -203,6 +247,74 @@ func TestReconcile(t *testing.T) {
 		},
 		expectedIPInNetBox:  nil,
 		expectedNetBoxIPObj: &v1beta1.NetBoxIP{},
+	}, {
+		name:               "netboxip outside of allowed prefixes not upserted",
+		existingIPInNetBox: nil,
+		existingNetBoxIPObj: &v1beta1.NetBoxIP{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "NetBoxIP",
+				APIVersion: v1beta1.SchemeGroupVersion.String(),
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  namespace,
+				UID:        types.UID(uid),
+				Finalizers: []string{netboxctrl.IPFinalizer},
+			},
+			Spec: v1beta1.NetBoxIPSpec{
+				Address: netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+				DNSName: name,
+			},
+		},
+		allowedPrefixes:    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		expectedIPInNetBox: nil,
+		expectedNetBoxIPObj: &v1beta1.NetBoxIP{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "NetBoxIP",
+				APIVersion: v1beta1.SchemeGroupVersion.String(),
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  namespace,
+				UID:        types.UID(uid),
+				Finalizers: []string{netboxctrl.IPFinalizer},
+			},
+			Spec: v1beta1.NetBoxIPSpec{
+				Address: netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+				DNSName: name,
+			},
+		},
+	}, {
+		name: "netboxip outside of allowed prefixes deleted without deleting IP",
+		existingIPInNetBox: &netbox.IPAddress{
+			UID:     netbox.UID(uid),
+			Address: netbox.IP(netip.AddrFrom4([4]byte{192, 168, 0, 1})),
+			DNSName: name,
+		},
+		existingNetBoxIPObj: &v1beta1.NetBoxIP{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "NetBoxIP",
+				APIVersion: v1beta1.SchemeGroupVersion.String(),
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         namespace,
+				UID:               types.UID(uid),
+				Finalizers:        []string{netboxctrl.IPFinalizer},
+				DeletionTimestamp: &now,
+			},
+			Spec: v1beta1.NetBoxIPSpec{
+				Address: netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+				DNSName: name,
+			},
+		},
+		allowedPrefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		expectedIPInNetBox: &netbox.IPAddress{
+			UID:     netbox.UID(uid),
+			Address: netbox.IP(netip.AddrFrom4([4]byte{192, 168, 0, 1})),
+			DNSName: name,
+		},
+		expectedNetBoxIPObj: &v1beta1.NetBoxIP{},
 	}}
 
 	for _, test := range tests {
@@ -218,9 +330,10 @@ func TestReconcile(t *testing.T) {
 			}
 
 			r := &reconciler{
-				netboxClient: netbox.NewFakeClient(nil, existingIPs),
+				netboxClient: netbox.NewFakeClientWithAllowedPrefixes(nil, existingIPs, test.allowedPrefixes),
 				kubeClient:   kubeClientBuilder.Build(),
 				log:          log.L(),
+				recorder:     record.NewFakeRecorder(10),
 			}
 
 			req := reconcile.Request{
@@ -259,3 +372,547 @@ func TestReconcile(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcileWithTenantClient(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	uid := "123abc"
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(&v1beta1.NetBoxIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "foo",
+			Namespace:  "test",
+			UID:        types.UID(uid),
+			Finalizers: []string{netboxctrl.IPFinalizer},
+		},
+		Spec: v1beta1.NetBoxIPSpec{
+			Address: netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+			DNSName: "foo",
+			Tenant:  "team-a",
+		},
+	}).Build()
+
+	r := &reconciler{
+		netboxClient:  netbox.NewFakeClient(nil, nil),
+		kubeClient:    kubeClient,
+		log:           log.L(),
+		recorder:      record.NewFakeRecorder(10),
+		tenantClients: map[string]netbox.Client{"team-a": netbox.NewFakeClient(nil, nil)},
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "foo"}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q\n", err)
+	}
+
+	if ip, err := r.netboxClient.GetIP(context.Background(), netbox.UID(uid)); err != nil || ip != nil {
+		t.Errorf("want no IP written with the default client, got %v (error: %v)", ip, err)
+	}
+
+	ip, err := r.tenantClients["team-a"].GetIP(context.Background(), netbox.UID(uid))
+	if err != nil {
+		t.Fatalf("fetching IP from NetBox: %q\n", err)
+	}
+	expectedIP := &netbox.IPAddress{
+		UID:     netbox.UID(uid),
+		Address: netbox.IP(netip.AddrFrom4([4]byte{192, 168, 0, 1})),
+		DNSName: "foo",
+		Tenant:  &netbox.Tenant{Slug: "team-a"},
+	}
+	if diff := cmp.Diff(expectedIP, ip, cmpopts.IgnoreUnexported(netbox.IP{})); diff != "" {
+		t.Errorf("IP in NetBox (-want, +got)\n%s", diff)
+	}
+}
+
+func TestReconcileRecordsGenerations(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	tests := []struct {
+		name            string
+		allowedPrefixes []netip.Prefix
+		expectedStatus  v1beta1.NetBoxIPStatus
+	}{{
+		name:           "synced",
+		expectedStatus: v1beta1.NetBoxIPStatus{ObservedGeneration: 3, SyncedGeneration: 3},
+	}, {
+		name:            "not synced",
+		allowedPrefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		expectedStatus:  v1beta1.NetBoxIPStatus{ObservedGeneration: 3, SyncedGeneration: 2},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeClient := fakeclient.NewClientBuilder().
+				WithScheme(scheme).
+				WithStatusSubresource(&v1beta1.NetBoxIP{}).
+				WithObjects(&v1beta1.NetBoxIP{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       "foo",
+						Namespace:  "test",
+						UID:        "123abc",
+						Generation: 3,
+						Finalizers: []string{netboxctrl.IPFinalizer},
+					},
+					Spec: v1beta1.NetBoxIPSpec{
+						Address: netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+						DNSName: "foo",
+					},
+					Status: v1beta1.NetBoxIPStatus{ObservedGeneration: 2, SyncedGeneration: 2},
+				}).
+				Build()
+
+			r := &reconciler{
+				netboxClient: netbox.NewFakeClientWithAllowedPrefixes(nil, nil, test.allowedPrefixes),
+				kubeClient:   kubeClient,
+				log:          log.L(),
+				recorder:     record.NewFakeRecorder(10),
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "foo"}}
+			if _, err := r.Reconcile(context.Background(), req); err != nil {
+				t.Fatalf("reconciling: %q\n", err)
+			}
+
+			var ip v1beta1.NetBoxIP
+			if err := kubeClient.Get(context.Background(), req.NamespacedName, &ip); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expectedStatus, ip.Status); diff != "" {
+				t.Errorf("status (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+// idAssigningClient assigns IDs to the IPs upserted with the fake
+// client, and records the IDs they were upserted with.
+type idAssigningClient struct {
+	netbox.Client
+	upsertedWithIDs []int64
+}
+
+func (c *idAssigningClient) UpsertIP(ctx context.Context, ip *netbox.IPAddress) (*netbox.IPAddress, netbox.UpsertResult, error) {
+	c.upsertedWithIDs = append(c.upsertedWithIDs, ip.ID)
+	upserted, result, err := c.Client.UpsertIP(ctx, ip)
+	if upserted != nil {
+		upserted.ID = 42
+		upserted.WebURL = "https://netbox.example.com/ipam/ip-addresses/42/"
+	}
+	return upserted, result, err
+}
+
+func TestReconcileRecordsNetBoxID(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	kubeClient := fakeclient.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&v1beta1.NetBoxIP{}).
+		WithObjects(&v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "foo",
+				Namespace:  "test",
+				UID:        "123abc",
+				Finalizers: []string{netboxctrl.IPFinalizer},
+			},
+			Spec: v1beta1.NetBoxIPSpec{
+				Address: netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+				DNSName: "foo",
+			},
+		}).
+		Build()
+
+	netboxClient := &idAssigningClient{Client: netbox.NewFakeClient(nil, nil)}
+	r := &reconciler{
+		netboxClient: netboxClient,
+		kubeClient:   kubeClient,
+		log:          log.L(),
+		recorder:     record.NewFakeRecorder(10),
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "foo"}}
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(context.Background(), req); err != nil {
+			t.Fatalf("reconciling: %q\n", err)
+		}
+	}
+
+	var ip v1beta1.NetBoxIP
+	if err := kubeClient.Get(context.Background(), req.NamespacedName, &ip); err != nil {
+		t.Fatal(err)
+	}
+	if ip.Status.NetBoxID != 42 || ip.Status.NetBoxURL != "https://netbox.example.com/ipam/ip-addresses/42/" {
+		t.Errorf("want NetBox ID and URL of the IP in status, got %d and %q", ip.Status.NetBoxID, ip.Status.NetBoxURL)
+	}
+	// the second upsert uses the ID recorded by the first one
+	if diff := cmp.Diff([]int64{0, 42}, netboxClient.upsertedWithIDs); diff != "" {
+		t.Errorf("IDs of upserted IPs (-want, +got)\n%s", diff)
+	}
+}
+
+func TestReconcileRecordsNetBoxIDOfUnchangedIP(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	kubeClient := fakeclient.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&v1beta1.NetBoxIP{}).
+		WithObjects(&v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "foo",
+				Namespace:  "test",
+				UID:        "123abc",
+				Finalizers: []string{netboxctrl.IPFinalizer},
+			},
+			Spec: v1beta1.NetBoxIPSpec{
+				Address: netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+				DNSName: "foo",
+			},
+		}).
+		Build()
+
+	// e.g. published before the ID was recorded in the status
+	existingIPs := map[netbox.UID]netbox.IPAddress{
+		"123abc": {
+			ID:      7,
+			UID:     "123abc",
+			Address: netbox.IP(netip.AddrFrom4([4]byte{192, 168, 0, 1})),
+			DNSName: "foo",
+			WebURL:  "https://netbox.example.com/ipam/ip-addresses/7/",
+		},
+	}
+	sink := &recordingSink{}
+	r := &reconciler{
+		netboxClient: netbox.NewFakeClient(nil, existingIPs),
+		kubeClient:   kubeClient,
+		log:          log.L(),
+		recorder:     record.NewFakeRecorder(10),
+		webhook:      sink,
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "foo"}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q\n", err)
+	}
+
+	var ip v1beta1.NetBoxIP
+	if err := kubeClient.Get(context.Background(), req.NamespacedName, &ip); err != nil {
+		t.Fatal(err)
+	}
+	if ip.Status.NetBoxID != 7 || ip.Status.NetBoxURL != "https://netbox.example.com/ipam/ip-addresses/7/" {
+		t.Errorf("want NetBox ID and URL of the IP in status, got %d and %q", ip.Status.NetBoxID, ip.Status.NetBoxURL)
+	}
+	if len(sink.events) > 0 {
+		t.Errorf("want no events for an unchanged IP, got %v", sink.events)
+	}
+}
+
+func TestReconcileTakesOverAnnotatedIP(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	allocated := netbox.UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+	kubeClient := fakeclient.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&v1beta1.NetBoxIP{}).
+		WithObjects(&v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "foo",
+				Namespace:   "test",
+				UID:         "123abc",
+				Finalizers:  []string{netboxctrl.IPFinalizer},
+				Annotations: map[string]string{netboxctrl.TakeOverAnnotation: string(allocated)},
+			},
+			Spec: v1beta1.NetBoxIPSpec{
+				Address: netip.MustParseAddr("203.0.113.1"),
+				DNSName: "foo",
+			},
+		}).
+		Build()
+
+	netboxClient := netbox.NewFakeClient(nil, map[netbox.UID]netbox.IPAddress{
+		allocated: {UID: allocated, Address: netbox.IP(netip.MustParseAddr("203.0.113.1"))},
+	})
+	r := &reconciler{
+		netboxClient: netboxClient,
+		kubeClient:   kubeClient,
+		log:          log.L(),
+		recorder:     record.NewFakeRecorder(10),
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "foo"}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q\n", err)
+	}
+
+	for uid, expected := range map[netbox.UID]bool{allocated: false, "123abc": true} {
+		ip, err := netboxClient.GetIP(context.Background(), uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (ip != nil) != expected {
+			t.Errorf("want IP with UID %s: %t, got %+v", uid, expected, ip)
+		}
+	}
+}
+
+func TestReconcileWithDeletionPolicy(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+	now := metav1.NewTime(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))
+	uid := "123abc"
+
+	tests := []struct {
+		name                string
+		policy              string
+		expectedDescription string
+		expectedEvent       string
+	}{{
+		name:                "retain",
+		policy:              ctrl.DeletionPolicyRetain,
+		expectedDescription: "web",
+		expectedEvent:       webhook.EventReleased,
+	}, {
+		name:                "deprecate",
+		policy:              ctrl.DeletionPolicyDeprecate,
+		expectedDescription: "web (deleted 2022-05-01T12:00:00Z)",
+		expectedEvent:       webhook.EventDeprecated,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(&v1beta1.NetBoxIP{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "foo",
+					Namespace:         "test",
+					UID:               types.UID(uid),
+					Finalizers:        []string{netboxctrl.IPFinalizer},
+					DeletionTimestamp: &now,
+				},
+				Spec: v1beta1.NetBoxIPSpec{
+					Address:     netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+					DNSName:     "foo",
+					Description: "web",
+				},
+			}).Build()
+
+			existingIP := netbox.IPAddress{
+				UID:         netbox.UID(uid),
+				Address:     netbox.IP(netip.AddrFrom4([4]byte{192, 168, 0, 1})),
+				DNSName:     "foo",
+				Description: "web",
+			}
+			sink := &recordingSink{}
+			r := &reconciler{
+				netboxClient:   netbox.NewFakeClient(nil, map[netbox.UID]netbox.IPAddress{netbox.UID(uid): existingIP}),
+				kubeClient:     kubeClient,
+				log:            log.L(),
+				recorder:       record.NewFakeRecorder(10),
+				webhook:        sink,
+				deletionPolicy: test.policy,
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "foo"}}
+			if _, err := r.Reconcile(context.Background(), req); err != nil {
+				t.Fatalf("reconciling: %q\n", err)
+			}
+
+			if ip, err := r.netboxClient.GetIP(context.Background(), netbox.UID(uid)); err != nil || ip != nil {
+				t.Errorf("want IP to no longer be owned, got %v (error: %v)", ip, err)
+			}
+
+			// the fake client keeps released IPs under the empty UID
+			ip, err := r.netboxClient.GetIP(context.Background(), "")
+			if err != nil {
+				t.Fatalf("fetching IP from NetBox: %q\n", err)
+			}
+			expectedIP := existingIP
+			expectedIP.UID = ""
+			expectedIP.Description = test.expectedDescription
+			if diff := cmp.Diff(&expectedIP, ip, cmpopts.IgnoreUnexported(netbox.IP{})); diff != "" {
+				t.Errorf("IP in NetBox (-want, +got)\n%s", diff)
+			}
+
+			if len(sink.events) != 1 || sink.events[0].Type != test.expectedEvent {
+				t.Errorf("want a single %q event, got %v", test.expectedEvent, sink.events)
+			}
+		})
+	}
+}
+
+type recordingSink struct {
+	events []webhook.Event
+}
+
+func (s *recordingSink) Send(_ context.Context, e webhook.Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestReconcileSendsWebhookEvents(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	ctx := context.Background()
+	uid := "123abc"
+	isController := true
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(&v1beta1.NetBoxIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "test",
+			UID:       types.UID(uid),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       "foo",
+				UID:        "456def",
+				Controller: &isController,
+			}},
+		},
+		Spec: v1beta1.NetBoxIPSpec{
+			Address: netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+			DNSName: "foo",
+			Tags:    []v1beta1.Tag{{Name: "kubernetes", Slug: "kubernetes"}},
+		},
+	}).Build()
+
+	sink := &recordingSink{}
+	r := &reconciler{
+		netboxClient: netbox.NewFakeClient(nil, nil),
+		kubeClient:   kubeClient,
+		log:          log.L(),
+		recorder:     record.NewFakeRecorder(10),
+		webhook:      sink,
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "foo"}}
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("reconciling: %q\n", err)
+		}
+	}
+
+	var ip v1beta1.NetBoxIP
+	if err := kubeClient.Get(ctx, client.ObjectKey{Namespace: "test", Name: "foo"}, &ip); err != nil {
+		t.Fatalf("retrieving netboxip: %q\n", err)
+	}
+	if err := kubeClient.Delete(ctx, &ip); err != nil {
+		t.Fatalf("deleting netboxip: %q\n", err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("reconciling: %q\n", err)
+	}
+
+	source := &webhook.Source{APIVersion: "v1", Kind: "Pod", Namespace: "test", Name: "foo", UID: "456def"}
+	newEvent := func(eventType string) webhook.Event {
+		return webhook.Event{
+			Type:    eventType,
+			UID:     uid,
+			Address: "192.168.0.1",
+			DNSName: "foo",
+			Tags:    []string{"kubernetes"},
+			Source:  source,
+		}
+	}
+	// the second upsert does not change the IP
+	expectedEvents := []webhook.Event{
+		newEvent(webhook.EventCreated),
+		newEvent(webhook.EventDeleted),
+	}
+	if diff := cmp.Diff(expectedEvents, sink.events); diff != "" {
+		t.Errorf("webhook events (-want, +got)\n%s", diff)
+	}
+}
+
+func TestReconcileManualNetBoxIP(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	newIP := func(labels map[string]string) *v1beta1.NetBoxIP {
+		return &v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "foo",
+				Namespace: "test",
+				UID:       "123abc",
+				Labels:    labels,
+			},
+			Spec: v1beta1.NetBoxIPSpec{Address: netip.AddrFrom4([4]byte{192, 168, 0, 1})},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		ip             *v1beta1.NetBoxIP
+		expectedEvents []string
+	}{{
+		name:           "manual",
+		ip:             newIP(map[string]string{netboxctrl.ManualLabel: "true"}),
+		expectedEvents: []string{"Normal Published Published IP 192.168.0.1 to NetBox"},
+	}, {
+		name: "not manual",
+		ip:   newIP(nil),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &reconciler{
+				netboxClient: netbox.NewFakeClient(nil, nil),
+				kubeClient:   fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(test.ip).Build(),
+				log:          log.L(),
+				recorder:     recorder,
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "foo"}}
+			if _, err := r.Reconcile(context.Background(), req); err != nil {
+				t.Fatalf("reconciling: %q\n", err)
+			}
+
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			if diff := cmp.Diff(test.expectedEvents, events); diff != "" {
+				t.Errorf("events (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReconcileWithRequeueInterval(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(&v1beta1.NetBoxIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "foo",
+			Namespace:  "test",
+			UID:        types.UID("123abc"),
+			Finalizers: []string{netboxctrl.IPFinalizer},
+		},
+		Spec: v1beta1.NetBoxIPSpec{
+			Address: netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+			DNSName: "foo",
+		},
+	}).Build()
+
+	r := &reconciler{
+		netboxClient: netbox.NewFakeClient(nil, nil),
+		kubeClient:   kubeClient,
+		log:          log.L(),
+		recorder:     record.NewFakeRecorder(10),
+		requeueAfter: time.Hour,
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "foo"}}
+	res, err := r.Reconcile(context.Background(), req)
+	if err != nil {
+		t.Fatalf("reconciling: %q\n", err)
+	}
+
+	// the interval is jittered by up to 10%
+	if res.RequeueAfter < time.Hour || res.RequeueAfter > time.Hour+6*time.Minute {
+		t.Errorf("want requeue after about 1h, got %s", res.RequeueAfter)
+	}
+}
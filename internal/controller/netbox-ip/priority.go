@@ -0,0 +1,34 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netboxip
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// ownedByService keeps events of NetBoxIPs controlled by services. With
+// service IPs prioritized, they are reconciled by a controller of their
+// own, so that they are not queued behind a backlog of pod IPs, e.g.
+// during a resync after an outage of NetBox. Both controllers still
+// share the NetBox rate limiter, of which the service controller gets
+// an equal share as long as it has IPs to reconcile.
+var ownedByService = predicate.NewPredicateFuncs(func(obj client.Object) bool {
+	owner := metav1.GetControllerOfNoCopy(obj)
+	return owner != nil && owner.Kind == "Service" && owner.APIVersion == "v1"
+})
@@ -0,0 +1,90 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netboxip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestOwnedByService(t *testing.T) {
+	isController := true
+	tests := []struct {
+		name     string
+		owner    *metav1.OwnerReference
+		expected bool
+	}{{
+		name: "no owner",
+	}, {
+		name:     "service",
+		owner:    &metav1.OwnerReference{APIVersion: "v1", Kind: "Service", Name: "foo", Controller: &isController},
+		expected: true,
+	}, {
+		name:  "pod",
+		owner: &metav1.OwnerReference{APIVersion: "v1", Kind: "Pod", Name: "foo", Controller: &isController},
+	}, {
+		name:  "service that is not the controller",
+		owner: &metav1.OwnerReference{APIVersion: "v1", Kind: "Service", Name: "foo"},
+	}, {
+		name:  "service of another API group",
+		owner: &metav1.OwnerReference{APIVersion: "serving.knative.dev/v1", Kind: "Service", Name: "foo", Controller: &isController},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ip := &v1beta1.NetBoxIP{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default"}}
+			if test.owner != nil {
+				ip.OwnerReferences = []metav1.OwnerReference{*test.owner}
+			}
+			if got := ownedByService.Generic(event.GenericEvent{Object: ip}); got != test.expected {
+				t.Errorf("want %t, got %t", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewWithServiceIPPriority(t *testing.T) {
+	opts := []ctrl.Option{
+		ctrl.WithKubernetesClient(fakeclient.NewClientBuilder().Build()),
+		ctrl.WithNetBoxClient(netbox.NewFakeClient(nil, nil)),
+		ctrl.WithServiceIPPriority(),
+	}
+
+	c, err := New(append(opts, ctrl.WithDeletionDebounce(time.Minute))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := c.(*controller).serviceReconciler
+	if svc == nil {
+		t.Fatal("want a reconciler of service IPs, got nil")
+	}
+	if svc.debouncer == nil || svc.debouncer == c.(*controller).reconciler.debouncer {
+		t.Error("want reconciler of service IPs to have a debouncer of its own")
+	}
+
+	if _, err := New(append(opts, ctrl.WithSharedAddresses())...); err == nil {
+		t.Error("want error with shared addresses, got nil")
+	}
+}
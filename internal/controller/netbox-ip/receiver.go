@@ -0,0 +1,170 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netboxip
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	// uidIndexField is the name of the cache index of NetBoxIPs by their UID
+	uidIndexField = "metadata.uid"
+
+	// signatureHeader is the header containing the HMAC-SHA512 signature
+	// of the webhook body, computed by NetBox with the webhook secret
+	signatureHeader = "X-Hook-Signature"
+
+	// max size of webhook body that we ever expect to get, in bytes
+	webhookBodySizeLimit = 1 << 20
+)
+
+// webhookPayload is the part of a NetBox webhook body that the receiver cares about.
+type webhookPayload struct {
+	Event string `json:"event"`
+	Model string `json:"model"`
+	Data  struct {
+		CustomFields map[string]interface{} `json:"custom_fields"`
+	} `json:"data"`
+}
+
+// webhookReceiver accepts NetBox webhooks for changes of IP addresses,
+// and enqueues the NetBoxIPs of the changed IPs for reconciliation,
+// so that manual changes in NetBox are reverted.
+type webhookReceiver struct {
+	secret []byte
+	// uidFieldName is the custom field with the UIDs of IPs,
+	// netbox.UIDCustomFieldName if empty
+	uidFieldName string
+	kubeClient   client.Client
+	log          *log.Logger
+	events       chan<- event.GenericEvent
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, webhookBodySizeLimit))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !wr.validSignature(body, req.Header.Get(signatureHeader)) {
+		wr.log.Warn("rejecting NetBox webhook with invalid signature")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if payload.Model != "ipaddress" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	uidFieldName := wr.uidFieldName
+	if uidFieldName == "" {
+		uidFieldName = netbox.UIDCustomFieldName
+	}
+	uid, _ := payload.Data.CustomFields[uidFieldName].(string)
+	if uid == "" {
+		// not an IP managed by the controller
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// strip the UID prefix, if any; Kubernetes UIDs never contain slashes
+	uid = uid[strings.LastIndex(uid, "/")+1:]
+
+	ll := wr.log.With(log.String("event", payload.Event), log.String("uid", uid))
+
+	var ips v1beta1.NetBoxIPList
+	if err := wr.kubeClient.List(req.Context(), &ips, client.MatchingFields{uidIndexField: uid}); err != nil {
+		ll.Error("failed to list netboxips for NetBox webhook", log.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for i := range ips.Items {
+		select {
+		case wr.events <- event.GenericEvent{Object: &ips.Items[i]}:
+			ll.Info("enqueued netboxip changed in NetBox", log.String("namespace", ips.Items[i].Namespace), log.String("name", ips.Items[i].Name))
+		case <-req.Context().Done():
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validSignature returns true if signature is the hex-encoded
+// HMAC-SHA512 of the body, computed with the receiver's secret.
+func (wr *webhookReceiver) validSignature(body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha512.New, wr.secret)
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// serve runs an HTTP server with the receiver on addr until ctx is done.
+func (wr *webhookReceiver) serve(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           wr,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	wr.log.Info("serving NetBox webhooks", log.String("addr", addr))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
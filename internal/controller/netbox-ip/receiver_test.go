@@ -0,0 +1,137 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netboxip
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+
+	"github.com/google/go-cmp/cmp"
+	log "go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestWebhookReceiver(t *testing.T) {
+	const secret = "s3cr3t"
+	sign := func(body string) string {
+		mac := hmac.New(sha512.New, []byte(secret))
+		mac.Write([]byte(body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		signature      string
+		expectedStatus int
+		expectedNames  []string
+	}{{
+		name:           "invalid signature",
+		method:         http.MethodPost,
+		body:           `{"event":"updated","model":"ipaddress","data":{"custom_fields":{"netbox_ip_controller_uid":"123abc"}}}`,
+		signature:      sign("something else"),
+		expectedStatus: http.StatusForbidden,
+	}, {
+		name:           "not a POST",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusMethodNotAllowed,
+	}, {
+		name:           "other model",
+		method:         http.MethodPost,
+		body:           `{"event":"updated","model":"prefix","data":{"custom_fields":{}}}`,
+		expectedStatus: http.StatusNoContent,
+	}, {
+		name:           "IP not managed by the controller",
+		method:         http.MethodPost,
+		body:           `{"event":"deleted","model":"ipaddress","data":{"custom_fields":{"netbox_ip_controller_uid":null}}}`,
+		expectedStatus: http.StatusNoContent,
+	}, {
+		name:           "updated IP",
+		method:         http.MethodPost,
+		body:           `{"event":"updated","model":"ipaddress","data":{"custom_fields":{"netbox_ip_controller_uid":"123abc"}}}`,
+		expectedStatus: http.StatusAccepted,
+		expectedNames:  []string{"foo"},
+	}, {
+		name:           "deleted IP with UID prefix",
+		method:         http.MethodPost,
+		body:           `{"event":"deleted","model":"ipaddress","data":{"custom_fields":{"netbox_ip_controller_uid":"prod-1/456def"}}}`,
+		expectedStatus: http.StatusAccepted,
+		expectedNames:  []string{"bar"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			v1beta1.AddToScheme(scheme)
+
+			kubeClient := fakeclient.NewClientBuilder().
+				WithScheme(scheme).
+				WithIndex(&v1beta1.NetBoxIP{}, uidIndexField, func(o client.Object) []string {
+					return []string{string(o.GetUID())}
+				}).
+				WithObjects(
+					&v1beta1.NetBoxIP{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "test", UID: types.UID("123abc")}},
+					&v1beta1.NetBoxIP{ObjectMeta: metav1.ObjectMeta{Name: "bar", Namespace: "test", UID: types.UID("456def")}},
+				).
+				Build()
+
+			events := make(chan event.GenericEvent, 10)
+			receiver := &webhookReceiver{
+				secret:     []byte(secret),
+				kubeClient: kubeClient,
+				log:        log.L(),
+				events:     events,
+			}
+
+			signature := test.signature
+			if signature == "" {
+				signature = sign(test.body)
+			}
+			req := httptest.NewRequest(test.method, "/", strings.NewReader(test.body))
+			req.Header.Set(signatureHeader, signature)
+			rec := httptest.NewRecorder()
+
+			receiver.ServeHTTP(rec, req)
+			close(events)
+
+			if rec.Code != test.expectedStatus {
+				t.Errorf("want status %d, got %d", test.expectedStatus, rec.Code)
+			}
+
+			var names []string
+			for e := range events {
+				names = append(names, e.Object.GetName())
+			}
+			if diff := cmp.Diff(test.expectedNames, names); diff != "" {
+				t.Errorf("enqueued netboxips (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netboxip
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/uuid"
+	log "go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// addressIndexField is the name of the cache index
+	// of NetBoxIPs by their shared address key
+	addressIndexField = "spec.addressKey"
+
+	// sharedDescriptionSeparator separates the descriptions
+	// of the NetBoxIPs merged into a shared IP
+	sharedDescriptionSeparator = "; "
+)
+
+// sharedAddressKey returns the key of the address of a NetBoxIP:
+// NetBoxIPs with the same key share a single IP in NetBox.
+func sharedAddressKey(ip *v1beta1.NetBoxIP) string {
+	return ip.Spec.Address.String() + "|" + ip.Spec.VRF
+}
+
+// indexByAddress indexes NetBoxIPs by their shared address key.
+func indexByAddress(o client.Object) []string {
+	return []string{sharedAddressKey(o.(*v1beta1.NetBoxIP))}
+}
+
+// sharedUIDNamespace is the namespace of the name-based UUIDs of shared IPs.
+var sharedUIDNamespace = uuid.MustParse("5e0b4a4c-3f0e-4f3b-9a3c-0d1c6b0c8e2a")
+
+// sharedUID returns the UID under which the shared IP of the given shared
+// address key is stored in NetBox. Like the UIDs of NetBoxIPs, it is
+// a UUID, which the UID custom field in NetBox is validated against.
+func sharedUID(key string) netbox.UID {
+	return netbox.UID(uuid.NewSHA1(sharedUIDNamespace, []byte(key)).String())
+}
+
+// coordinator keeps track of shared addresses, i.e. addresses of more than
+// one NetBoxIP, which are published as a single IP in NetBox. It is only
+// used by the reconciler, which runs one reconciliation at a time.
+type coordinator struct {
+	// whether a shared IP exists in NetBox, by shared address key;
+	// keys not known yet, e.g. after a restart, are looked up in NetBox
+	shared map[string]bool
+	// NetBoxIPs whose own IPs in NetBox have been replaced by a shared one
+	merged map[types.UID]bool
+}
+
+func newCoordinator() *coordinator {
+	return &coordinator{
+		shared: make(map[string]bool),
+		merged: make(map[types.UID]bool),
+	}
+}
+
+// sharers returns the NetBoxIPs that are not being deleted and have the same
+// address and VRF as the given one, including itself, if it is not being
+// deleted either. They are ordered by namespace and name.
+func (r *reconciler) sharers(ctx context.Context, ip *v1beta1.NetBoxIP) ([]v1beta1.NetBoxIP, error) {
+	var ips v1beta1.NetBoxIPList
+	if err := r.kubeClient.List(ctx, &ips, client.MatchingFields{addressIndexField: sharedAddressKey(ip)}); err != nil {
+		return nil, fmt.Errorf("listing netboxips with the same address: %w", err)
+	}
+
+	var sharers []v1beta1.NetBoxIP
+	for _, s := range ips.Items {
+		if s.DeletionTimestamp.IsZero() {
+			sharers = append(sharers, s)
+		}
+	}
+	sort.Slice(sharers, func(i, j int) bool {
+		if sharers[i].Namespace != sharers[j].Namespace {
+			return sharers[i].Namespace < sharers[j].Namespace
+		}
+		return sharers[i].Name < sharers[j].Name
+	})
+	return sharers, nil
+}
+
+// upsertShared replaces the IPs of the given NetBoxIPs in NetBox, if any,
+// by a single IP merging all of them, and returns it, like UpsertIP.
+func (r *reconciler) upsertShared(ctx context.Context, key string, sharers []v1beta1.NetBoxIP) (*netbox.IPAddress, netbox.UpsertResult, error) {
+	for _, s := range sharers {
+		if r.coordinator.merged[s.UID] {
+			continue
+		}
+		if err := r.netboxClientFor(s.Spec.Tenant).DeleteIP(ctx, netbox.UID(s.UID)); err != nil {
+			return nil, netbox.UpsertUnchanged, fmt.Errorf("deleting IP of netboxip %s/%s: %w", s.Namespace, s.Name, err)
+		}
+		r.coordinator.merged[s.UID] = true
+	}
+
+	r.coordinator.shared[key] = true
+	merged := mergeIPs(sharedUID(key), sharers)
+	var err error
+	if merged.Tags, err = r.tagCache.Resolve(ctx, merged.Tags); err != nil {
+		return nil, netbox.UpsertUnchanged, fmt.Errorf("resolving tags: %w", err)
+	}
+	ip, result, err := r.netboxClientFor(sharers[0].Spec.Tenant).UpsertIP(ctx, merged)
+	if err != nil {
+		return nil, netbox.UpsertUnchanged, fmt.Errorf("upserting shared IP: %w", err)
+	}
+	return ip, result, nil
+}
+
+// sharedExists returns true if there is a shared IP
+// of the given key in NetBox.
+func (r *reconciler) sharedExists(ctx context.Context, key string, netboxClient netbox.Client) (bool, error) {
+	if shared, ok := r.coordinator.shared[key]; ok {
+		return shared, nil
+	}
+	existing, err := netboxClient.GetIP(ctx, sharedUID(key))
+	if err != nil {
+		return false, fmt.Errorf("checking for shared IP: %w", err)
+	}
+	r.coordinator.shared[key] = existing != nil
+	return existing != nil, nil
+}
+
+// releaseShared removes the shared IP of the given key from NetBox, if there
+// is one, once the address is no longer shared by the given NetBoxIP.
+func (r *reconciler) releaseShared(ctx context.Context, key string, ip *v1beta1.NetBoxIP) error {
+	delete(r.coordinator.merged, ip.UID)
+
+	netboxClient := r.netboxClientFor(ip.Spec.Tenant)
+	shared, err := r.sharedExists(ctx, key, netboxClient)
+	if err != nil || !shared {
+		return err
+	}
+	if err := netboxClient.DeleteIP(ctx, sharedUID(key)); err != nil {
+		return fmt.Errorf("deleting shared IP: %w", err)
+	}
+	r.coordinator.shared[key] = false
+	return nil
+}
+
+// enqueueFormerSharers returns an event handler that enqueues the other
+// NetBoxIPs with the previous address of a NetBoxIP, when it stops sharing
+// the address because it is being deleted or its address has changed,
+// so that their shared IP in NetBox is updated, or released once only
+// one of them is left.
+func enqueueFormerSharers(kubeClient client.Client, logger *log.Logger) handler.EventHandler {
+	return handler.Funcs{
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			oldIP, ok := e.ObjectOld.(*v1beta1.NetBoxIP)
+			if !ok {
+				return
+			}
+			newIP, ok := e.ObjectNew.(*v1beta1.NetBoxIP)
+			if !ok {
+				return
+			}
+			requests, err := formerSharers(ctx, kubeClient, oldIP, newIP)
+			if err != nil {
+				logger.Error("failed to list netboxips with the previous address", log.Error(err))
+				return
+			}
+			for _, req := range requests {
+				q.Add(req)
+			}
+		},
+	}
+}
+
+// formerSharers returns requests for the NetBoxIPs that shared the address of
+// oldIP, other than itself, if newIP no longer shares it.
+func formerSharers(ctx context.Context, kubeClient client.Client, oldIP, newIP *v1beta1.NetBoxIP) ([]reconcile.Request, error) {
+	if sharedAddressKey(oldIP) == sharedAddressKey(newIP) &&
+		(newIP.DeletionTimestamp.IsZero() || !oldIP.DeletionTimestamp.IsZero()) {
+		return nil, nil
+	}
+
+	var ips v1beta1.NetBoxIPList
+	if err := kubeClient.List(ctx, &ips, client.MatchingFields{addressIndexField: sharedAddressKey(oldIP)}); err != nil {
+		return nil, err
+	}
+
+	var requests []reconcile.Request
+	for _, ip := range ips.Items {
+		if ip.UID == newIP.UID {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: ip.Namespace, Name: ip.Name},
+		})
+	}
+	return requests, nil
+}
+
+// mergeIPs returns a NetBox IP with the given UID that merges the given
+// NetBoxIPs of the same address: it has the tags of all of them, their
+// distinct descriptions, as many as fit, the tenant and prefix length of
+// the first one, and the DNS name, assigned interface and value of each
+// custom field of the first one that has them.
+func mergeIPs(uid netbox.UID, ips []v1beta1.NetBoxIP) *netbox.IPAddress {
+	merged := &netbox.IPAddress{
+		UID:          uid,
+		Address:      netbox.IP(ips[0].Spec.Address),
+		PrefixLength: int(ips[0].Spec.PrefixLength),
+	}
+	if ips[0].Spec.VRF != "" {
+		merged.VRF = &netbox.VRF{Name: ips[0].Spec.VRF}
+	}
+	// the shared IP is written with the NetBox client of this tenant
+	if ips[0].Spec.Tenant != "" {
+		merged.Tenant = &netbox.Tenant{Slug: ips[0].Spec.Tenant}
+	}
+
+	tags := make(map[string]bool)
+	var descriptions []string
+	for _, ip := range ips {
+		for _, t := range ip.Spec.Tags {
+			if !tags[t.Name] {
+				tags[t.Name] = true
+				merged.Tags = append(merged.Tags, netbox.Tag{Name: t.Name, Slug: t.Slug})
+			}
+		}
+
+		if d := ip.Spec.Description; d != "" && !contains(descriptions, d) {
+			desc := strings.Join(append(descriptions, d), sharedDescriptionSeparator)
+			if len(desc) <= v1beta1.DescriptionMaxLength {
+				descriptions = append(descriptions, d)
+			}
+		}
+
+		if merged.DNSName == "" {
+			merged.DNSName = ip.Spec.DNSName
+		}
+		if merged.AssignedInterface == nil {
+			merged.AssignedInterface = assignedInterface(ip.Spec.AssignedObject)
+		}
+		for name, value := range ip.Spec.CustomFields {
+			if merged.CustomFields == nil {
+				merged.CustomFields = make(map[string]string)
+			}
+			if merged.CustomFields[name] == "" {
+				merged.CustomFields[name] = value
+			}
+		}
+	}
+	merged.Description = strings.Join(descriptions, sharedDescriptionSeparator)
+
+	return merged
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
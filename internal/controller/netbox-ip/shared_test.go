@@ -0,0 +1,241 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netboxip
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+	"github.com/digitalocean/netbox-ip-controller/internal/webhook"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	log "go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileSharedAddress(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	addr := netip.AddrFrom4([4]byte{10, 0, 0, 1})
+	newIP := func(name, uid, description, tag string) *v1beta1.NetBoxIP {
+		return &v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  "test",
+				UID:        types.UID(uid),
+				Finalizers: []string{netboxctrl.IPFinalizer},
+			},
+			Spec: v1beta1.NetBoxIPSpec{
+				Address:     addr,
+				DNSName:     name,
+				Description: description,
+				Tags:        []v1beta1.Tag{{Name: tag, Slug: tag}},
+			},
+		}
+	}
+
+	kubeClient := fakeclient.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(newIP("bar", "uid-bar", "app: bar", "bar"), newIP("foo", "uid-foo", "app: foo", "foo")).
+		WithIndex(&v1beta1.NetBoxIP{}, addressIndexField, indexByAddress).
+		Build()
+
+	// the IP of one of them was published before the address became shared
+	sink := &recordingSink{}
+	r := &reconciler{
+		netboxClient: netbox.NewFakeClient(nil, map[netbox.UID]netbox.IPAddress{
+			"uid-bar": {UID: "uid-bar", Address: netbox.IP(addr), DNSName: "bar"},
+		}),
+		kubeClient:  kubeClient,
+		log:         log.L(),
+		recorder:    record.NewFakeRecorder(10),
+		coordinator: newCoordinator(),
+		webhook:     sink,
+	}
+	ctx := context.Background()
+	uid := sharedUID(addr.String() + "|")
+
+	reconcileIP := func(name string) {
+		req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: name}}
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("reconciling %s: %q\n", name, err)
+		}
+	}
+	getIP := func(uid netbox.UID) *netbox.IPAddress {
+		ip, err := r.netboxClient.GetIP(ctx, uid)
+		if err != nil {
+			t.Fatalf("fetching IP from NetBox: %q\n", err)
+		}
+		return ip
+	}
+
+	reconcileIP("foo")
+
+	expectedIP := &netbox.IPAddress{
+		UID:         uid,
+		Address:     netbox.IP(addr),
+		DNSName:     "bar",
+		Description: "app: bar; app: foo",
+		Tags:        []netbox.Tag{{Name: "bar", Slug: "bar"}, {Name: "foo", Slug: "foo"}},
+	}
+	if diff := cmp.Diff(expectedIP, getIP(uid), cmpopts.IgnoreUnexported(netbox.IP{})); diff != "" {
+		t.Errorf("shared IP in NetBox (-want, +got)\n%s", diff)
+	}
+	for _, own := range []netbox.UID{"uid-bar", "uid-foo"} {
+		if ip := getIP(own); ip != nil {
+			t.Errorf("want IP %s to be replaced by the shared IP, got %v", own, ip)
+		}
+	}
+	if len(sink.events) != 1 || sink.events[0].Type != webhook.EventCreated || sink.events[0].UID != "uid-foo" {
+		t.Errorf("want a created event for uid-foo, got %v", sink.events)
+	}
+
+	// deleting one of them leaves the shared IP with the other one
+	if err := kubeClient.Delete(ctx, newIP("bar", "uid-bar", "", "")); err != nil {
+		t.Fatalf("deleting netboxip: %q\n", err)
+	}
+	reconcileIP("bar")
+
+	expectedIP.DNSName = "foo"
+	expectedIP.Description = "app: foo"
+	expectedIP.Tags = []netbox.Tag{{Name: "foo", Slug: "foo"}}
+	if diff := cmp.Diff(expectedIP, getIP(uid), cmpopts.IgnoreUnexported(netbox.IP{})); diff != "" {
+		t.Errorf("shared IP in NetBox (-want, +got)\n%s", diff)
+	}
+
+	// until it is no longer shared by the remaining one
+	reconcileIP("foo")
+
+	if ip := getIP(uid); ip != nil {
+		t.Errorf("want shared IP to be deleted, got %v", ip)
+	}
+	if ip := getIP("uid-foo"); ip == nil || ip.Description != "app: foo" {
+		t.Errorf("want IP uid-foo to be published, got %v", ip)
+	}
+}
+
+func TestMergeIPsDescription(t *testing.T) {
+	long := strings.Repeat("x", 150)
+	ips := []v1beta1.NetBoxIP{
+		{Spec: v1beta1.NetBoxIPSpec{Description: "a"}},
+		{Spec: v1beta1.NetBoxIPSpec{Description: "a"}},
+		{Spec: v1beta1.NetBoxIPSpec{Description: long}},
+		{Spec: v1beta1.NetBoxIPSpec{Description: long + "b"}},
+		{Spec: v1beta1.NetBoxIPSpec{Description: "c"}},
+	}
+
+	// duplicates and descriptions that no longer fit are left out
+	expected := "a; " + long + "; c"
+	if desc := mergeIPs("", ips).Description; desc != expected {
+		t.Errorf("want description %q, got %q", expected, desc)
+	}
+}
+
+func TestSharedUIDIsValid(t *testing.T) {
+	for _, key := range []string{"10.0.0.1|", "10.0.0.1|vrf-a", "fd00::1|"} {
+		uid := sharedUID(key)
+		if !netbox.ValidUID(uid) {
+			t.Errorf("want a valid UID for %q, got %q", key, uid)
+		}
+		if sharedUID(key) != uid {
+			t.Errorf("want the same UID for %q every time", key)
+		}
+	}
+	if sharedUID("10.0.0.1|") == sharedUID("10.0.0.2|") {
+		t.Error("want different UIDs for different addresses")
+	}
+}
+
+func TestFormerSharers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	newIP := func(name string, addr netip.Addr) *v1beta1.NetBoxIP {
+		return &v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "test",
+				UID:       types.UID("uid-" + name),
+			},
+			Spec: v1beta1.NetBoxIPSpec{Address: addr},
+		}
+	}
+	shared := netip.MustParseAddr("10.0.0.1")
+	other := netip.MustParseAddr("10.0.0.2")
+
+	kubeClient := fakeclient.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(newIP("bar", shared), newIP("foo", shared), newIP("baz", other)).
+		WithIndex(&v1beta1.NetBoxIP{}, addressIndexField, indexByAddress).
+		Build()
+
+	deleting := func(ip *v1beta1.NetBoxIP) *v1beta1.NetBoxIP {
+		now := metav1.Now()
+		ip.DeletionTimestamp = &now
+		return ip
+	}
+	bar := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "test", Name: "bar"}}}
+
+	tests := []struct {
+		name     string
+		oldIP    *v1beta1.NetBoxIP
+		newIP    *v1beta1.NetBoxIP
+		expected []reconcile.Request
+	}{{
+		name:  "unchanged address",
+		oldIP: newIP("foo", shared),
+		newIP: newIP("foo", shared),
+	}, {
+		name:     "changed address",
+		oldIP:    newIP("foo", shared),
+		newIP:    newIP("foo", netip.MustParseAddr("10.0.0.3")),
+		expected: bar,
+	}, {
+		name:     "being deleted",
+		oldIP:    newIP("foo", shared),
+		newIP:    deleting(newIP("foo", shared)),
+		expected: bar,
+	}, {
+		name:  "already being deleted",
+		oldIP: deleting(newIP("foo", shared)),
+		newIP: deleting(newIP("foo", shared)),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests, err := formerSharers(context.Background(), kubeClient, test.oldIP, test.newIP)
+			if err != nil {
+				t.Fatalf("listing former sharers: %s", err)
+			}
+			if diff := cmp.Diff(test.expected, requests); diff != "" {
+				t.Errorf("requests (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
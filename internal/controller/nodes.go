@@ -0,0 +1,104 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Ways of matching nodes to the NetBox devices they run on.
+const (
+	// NodeDeviceMatchName matches the device named after the node.
+	NodeDeviceMatchName = "name"
+	// NodeDeviceMatchProviderID matches the device whose
+	// ProviderIDCustomField is the provider ID of the node.
+	NodeDeviceMatchProviderID = "provider-id"
+	// NodeDeviceMatchSerial matches the device whose serial number
+	// is the system UUID of the node, as reported by its kubelet.
+	NodeDeviceMatchSerial = "serial"
+	// NodeDeviceMatchLabelPrefix, followed by the key of a node label,
+	// matches the device named after the value of that label.
+	NodeDeviceMatchLabelPrefix = "label:"
+)
+
+// ProviderIDCustomField is the NetBox custom field of devices
+// that NodeDeviceMatchProviderID matches nodes by.
+const ProviderIDCustomField = "provider_id"
+
+// NodeDeviceMatcher maps nodes to the NetBox devices they run on.
+// A nil NodeDeviceMatcher matches devices named after the node.
+type NodeDeviceMatcher struct {
+	match   string
+	devices netbox.DeviceFinder
+}
+
+// NewNodeDeviceMatcher returns a matcher of nodes to NetBox devices, which
+// are looked up with the given client, unless they are matched by name.
+func NewNodeDeviceMatcher(match string, ipamClient netbox.Client) (*NodeDeviceMatcher, error) {
+	switch {
+	case match == NodeDeviceMatchName:
+		return &NodeDeviceMatcher{match: match}, nil
+	case strings.HasPrefix(match, NodeDeviceMatchLabelPrefix):
+		if strings.TrimPrefix(match, NodeDeviceMatchLabelPrefix) == "" {
+			return nil, errors.New("label of nodes matched to devices is required")
+		}
+		return &NodeDeviceMatcher{match: match}, nil
+	case match == NodeDeviceMatchProviderID, match == NodeDeviceMatchSerial:
+		devices, ok := ipamClient.(netbox.DeviceFinder)
+		if !ok {
+			return nil, errors.New("the IPAM backend does not support looking up devices")
+		}
+		return &NodeDeviceMatcher{match: match, devices: devices}, nil
+	default:
+		return nil, fmt.Errorf("unknown node device match %q", match)
+	}
+}
+
+// DeviceFor returns the name of the NetBox device that the node with
+// the given name runs on, or an empty string if no device matches.
+func (m *NodeDeviceMatcher) DeviceFor(ctx context.Context, kubeClient client.Client, nodeName string) (string, error) {
+	if m == nil || m.match == NodeDeviceMatchName {
+		return nodeName, nil
+	}
+
+	var node corev1.Node
+	if err := kubeClient.Get(ctx, client.ObjectKey{Name: nodeName}, &node); err != nil {
+		return "", fmt.Errorf("retrieving node: %w", err)
+	}
+
+	var field, value string
+	switch m.match {
+	case NodeDeviceMatchProviderID:
+		field, value = "cf_"+ProviderIDCustomField, node.Spec.ProviderID
+	case NodeDeviceMatchSerial:
+		field, value = "serial", node.Status.NodeInfo.SystemUUID
+	default:
+		return node.Labels[strings.TrimPrefix(m.match, NodeDeviceMatchLabelPrefix)], nil
+	}
+	if value == "" {
+		return "", nil
+	}
+	return m.devices.DeviceName(ctx, field, value)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeDeviceFinder has devices by the filter and value they are looked up by.
+type fakeDeviceFinder struct {
+	netbox.Client
+	devices map[string]string
+}
+
+func (f *fakeDeviceFinder) DeviceName(_ context.Context, field, value string) (string, error) {
+	return f.devices[field+"="+value], nil
+}
+
+func TestNodeDeviceMatcher(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"example.com/device": "server-a"}},
+		Spec:       corev1.NodeSpec{ProviderID: "digitalocean://42"},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "4c4c4544-0042"}},
+	}
+	finder := &fakeDeviceFinder{
+		Client: netbox.NewFakeClient(nil, nil),
+		devices: map[string]string{
+			"cf_provider_id=digitalocean://42": "server-b",
+			"serial=4c4c4544-0042":             "server-c",
+		},
+	}
+
+	tests := []struct {
+		name           string
+		match          string
+		ipamClient     netbox.Client
+		errorExpected  bool
+		expectedDevice string
+	}{{
+		name:           "name",
+		match:          NodeDeviceMatchName,
+		expectedDevice: "node-1",
+	}, {
+		name:           "label",
+		match:          "label:example.com/device",
+		expectedDevice: "server-a",
+	}, {
+		name:  "missing label",
+		match: "label:example.com/other",
+	}, {
+		name:          "label without key",
+		match:         "label:",
+		errorExpected: true,
+	}, {
+		name:           "provider ID",
+		match:          NodeDeviceMatchProviderID,
+		ipamClient:     finder,
+		expectedDevice: "server-b",
+	}, {
+		name:           "serial",
+		match:          NodeDeviceMatchSerial,
+		ipamClient:     finder,
+		expectedDevice: "server-c",
+	}, {
+		name:          "unsupported backend",
+		match:         NodeDeviceMatchSerial,
+		ipamClient:    netbox.NewFakeClient(nil, nil),
+		errorExpected: true,
+	}, {
+		name:          "unknown",
+		match:         "asset-tag",
+		errorExpected: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matcher, err := NewNodeDeviceMatcher(test.match, test.ipamClient)
+			if err != nil {
+				if !test.errorExpected {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			} else if test.errorExpected {
+				t.Fatal("want error, got nil")
+			}
+
+			kubeClient := fakeclient.NewClientBuilder().WithObjects(node).Build()
+			device, err := matcher.DeviceFor(context.Background(), kubeClient, "node-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if device != test.expectedDevice {
+				t.Errorf("want device %q, got %q", test.expectedDevice, device)
+			}
+		})
+	}
+}
+
+func TestNilNodeDeviceMatcher(t *testing.T) {
+	var matcher *NodeDeviceMatcher
+	device, err := matcher.DeviceFor(context.Background(), fakeclient.NewClientBuilder().Build(), "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device != "node-1" {
+		t.Errorf("want device node-1, got %q", device)
+	}
+}
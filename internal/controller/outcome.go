@@ -0,0 +1,185 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+	"github.com/digitalocean/netbox-ip-controller/internal/timing"
+
+	log "go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// Outcomes of reconciliations, logged in the outcome field of the
+// "reconciled" message. They are listed from the least to the most
+// significant: a reconciliation that e.g. created one NetBoxIP and
+// deleted another one is logged as deleted.
+const (
+	OutcomeNoop    = "noop"
+	OutcomeUpdated = "updated"
+	OutcomeCreated = "created"
+	OutcomeDeleted = "deleted"
+	OutcomeError   = "error"
+)
+
+// Reasons of outcomes, logged in the reason field of the "reconciled"
+// message. Like the reasons of Kubernetes events, they are CamelCase.
+const (
+	// ReasonUpToDate is the reason of reconciliations that changed nothing.
+	ReasonUpToDate = "UpToDate"
+	// ReasonNotFound is the reason of reconciliations of deleted objects.
+	ReasonNotFound = "NotFound"
+	// ReasonNotPublished is the reason of reconciliations of objects
+	// whose IPs are not published, e.g. pods on host network.
+	ReasonNotPublished = "NotPublished"
+	// ReasonKept is the reason of reconciliations that kept the IPs
+	// of pods that are completed or not ready for a while longer.
+	ReasonKept = "Kept"
+	// ReasonPublished is the reason of reconciliations
+	// that created or updated NetBoxIPs or IPs in NetBox.
+	ReasonPublished = "Published"
+	// ReasonStale is the reason of reconciliations that deleted
+	// NetBoxIPs of addresses that their objects no longer have.
+	ReasonStale = "Stale"
+	// ReasonMigrated is the reason of reconciliations that deleted NetBoxIPs
+	// named without the suffix of their IP family by older versions.
+	ReasonMigrated = "Migrated"
+	// ReasonPredecessor is the reason of reconciliations that deleted
+	// NetBoxIPs of a deleted service with the same name.
+	ReasonPredecessor = "Predecessor"
+	// ReasonAllocated is the reason of reconciliations
+	// that allocated IPs for NetBoxIPClaims in NetBox.
+	ReasonAllocated = "Allocated"
+	// ReasonAllocationFailed is the reason of reconciliations that failed
+	// to allocate IPs, since their prefix does not exist or is full.
+	ReasonAllocationFailed = "AllocationFailed"
+	// ReasonRemoved is the reason of reconciliations that
+	// deleted IPs of removed NetBoxIPs from NetBox.
+	ReasonRemoved = "Removed"
+	// ReasonReleased is the reason of reconciliations
+	// that released IPs of removed NetBoxIPs in NetBox.
+	ReasonReleased = "Released"
+	// ReasonDeprecated is the reason of reconciliations
+	// that deprecated IPs of removed NetBoxIPs in NetBox.
+	ReasonDeprecated = "Deprecated"
+	// ReasonDebounced is the reason of reconciliations
+	// that delayed the removal of IPs from NetBox.
+	ReasonDebounced = "Debounced"
+	// ReasonManual is the reason of reconciliations that left alone
+	// manually created NetBoxIPs with the names of their own.
+	ReasonManual = "Manual"
+	// ReasonDisallowed is the reason of reconciliations of
+	// NetBoxIPs with addresses outside of the allowed prefixes.
+	ReasonDisallowed = "Disallowed"
+	// ReasonUnmanagedIP is the reason of reconciliations of NetBoxIPs
+	// whose addresses exist in NetBox, but are not managed by the controller.
+	ReasonUnmanagedIP = "UnmanagedIP"
+	// ReasonRejectedByNetBox is the reason of reconciliations
+	// that failed because NetBox rejected an IP as invalid.
+	ReasonRejectedByNetBox = "RejectedByNetBox"
+	// ReasonConflict is the reason of reconciliations that failed
+	// because an IP was changed in NetBox while it was being updated.
+	ReasonConflict = "Conflict"
+	// ReasonFailed is the reason of reconciliations
+	// that failed for any other reason.
+	ReasonFailed = "Failed"
+)
+
+var outcomeSignificance = map[string]int{
+	OutcomeNoop:    1,
+	OutcomeUpdated: 2,
+	OutcomeCreated: 3,
+	OutcomeDeleted: 4,
+	OutcomeError:   5,
+}
+
+type outcomeKey struct{}
+
+// Outcome is the outcome of a reconciliation, and the reason for it.
+type Outcome struct {
+	Outcome string
+	Reason  string
+
+	start     time.Time
+	breakdown *timing.Breakdown
+}
+
+// WithOutcome returns a context that the outcome of a reconciliation
+// is recorded to by RecordOutcome, and the time spent waiting for
+// dependencies by timing.Record. The reconciliation starts now.
+func WithOutcome(ctx context.Context) (context.Context, *Outcome) {
+	ctx, breakdown := timing.WithBreakdown(ctx)
+	outcome := &Outcome{start: time.Now(), breakdown: breakdown}
+	return context.WithValue(ctx, outcomeKey{}, outcome), outcome
+}
+
+// WithRequestID generates a correlation ID for a reconciliation, and returns
+// a context whose requests to NetBox are sent with it, and a logger that logs
+// it, so that the access logs of NetBox can be correlated with the logs of
+// the controller.
+func WithRequestID(ctx context.Context, ll *log.Logger) (context.Context, *log.Logger) {
+	id := string(uuid.NewUUID())
+	return netbox.WithRequestID(ctx, id), ll.With(log.String("requestID", id))
+}
+
+// RecordOutcome records the outcome of the reconciliation of ctx,
+// unless a more or equally significant one was recorded already.
+// It does nothing if ctx was not returned by WithOutcome, e.g.
+// when NetBoxIPs are upserted by commands rather than reconcilers.
+func RecordOutcome(ctx context.Context, outcome, reason string) {
+	o, ok := ctx.Value(outcomeKey{}).(*Outcome)
+	if !ok || outcomeSignificance[outcome] <= outcomeSignificance[o.Outcome] {
+		return
+	}
+	o.Outcome = outcome
+	o.Reason = reason
+}
+
+// Log logs the outcome of a reconciliation that returned err.
+// Failed reconciliations are logged with the error outcome,
+// and reconciliations that recorded nothing as noop.
+func (o *Outcome) Log(ll *log.Logger, err error) {
+	outcome, reason := o.Outcome, o.Reason
+	if err != nil && outcome != OutcomeError {
+		outcome, reason = OutcomeError, ReasonFailed
+	}
+	if outcome == "" {
+		outcome, reason = OutcomeNoop, ReasonUpToDate
+	}
+
+	fields := []log.Field{log.String("outcome", outcome), log.String("reason", reason)}
+	if err != nil {
+		fields = append(fields, log.Error(err))
+	}
+	ll.Info("reconciled", fields...)
+}
+
+// WarnIfSlow logs a warning with the time spent waiting for each
+// dependency if the reconciliation has taken longer than threshold,
+// so that it is obvious which one is the bottleneck. A threshold
+// of 0 disables the warning.
+func (o *Outcome) WarnIfSlow(ll *log.Logger, threshold time.Duration) {
+	if threshold <= 0 {
+		return
+	}
+	if total := time.Since(o.start); total > threshold {
+		ll.Warn("slow reconciliation", o.breakdown.Fields(total)...)
+	}
+}
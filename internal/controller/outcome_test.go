@@ -0,0 +1,77 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRecordOutcome(t *testing.T) {
+	tests := []struct {
+		name     string
+		recorded []Outcome
+		expected Outcome
+	}{{
+		name:     "nothing recorded",
+		expected: Outcome{},
+	}, {
+		name:     "single outcome",
+		recorded: []Outcome{{Outcome: OutcomeNoop, Reason: ReasonNotFound}},
+		expected: Outcome{Outcome: OutcomeNoop, Reason: ReasonNotFound},
+	}, {
+		name: "more significant outcome wins",
+		recorded: []Outcome{
+			{Outcome: OutcomeCreated, Reason: ReasonPublished},
+			{Outcome: OutcomeDeleted, Reason: ReasonStale},
+			{Outcome: OutcomeUpdated, Reason: ReasonPublished},
+		},
+		expected: Outcome{Outcome: OutcomeDeleted, Reason: ReasonStale},
+	}, {
+		name: "first of equally significant outcomes wins",
+		recorded: []Outcome{
+			{Outcome: OutcomeDeleted, Reason: ReasonPredecessor},
+			{Outcome: OutcomeDeleted, Reason: ReasonStale},
+		},
+		expected: Outcome{Outcome: OutcomeDeleted, Reason: ReasonPredecessor},
+	}, {
+		name: "error wins",
+		recorded: []Outcome{
+			{Outcome: OutcomeError, Reason: ReasonRejectedByNetBox},
+			{Outcome: OutcomeDeleted, Reason: ReasonStale},
+		},
+		expected: Outcome{Outcome: OutcomeError, Reason: ReasonRejectedByNetBox},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, outcome := WithOutcome(context.Background())
+			for _, o := range test.recorded {
+				RecordOutcome(ctx, o.Outcome, o.Reason)
+			}
+			got := Outcome{Outcome: outcome.Outcome, Reason: outcome.Reason}
+			if got != test.expected {
+				t.Errorf("want %+v, got %+v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestRecordOutcomeWithoutReconciliation(t *testing.T) {
+	// must not panic
+	RecordOutcome(context.Background(), OutcomeCreated, ReasonPublished)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod
+
+import (
+	"regexp"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var tenantSlugRegexp = regexp.MustCompile("^[-a-zA-Z0-9_]+$")
+
+// limits set by NetBox
+const (
+	maxTenantSlugLength = 100
+	maxVRFNameLength    = 100
+)
+
+// applyAnnotations returns the tenant and VRF of the pod's IPs, taking
+// the overrides in the pod's annotations into account, if enabled.
+// Invalid annotations are ignored, since they would only make
+// the NetBoxIPs of the pod fail validation.
+func (r *reconciler) applyAnnotations(ll *log.Logger, pod *corev1.Pod, tenant string) (string, string) {
+	if !r.annotationOverrides {
+		return tenant, ""
+	}
+
+	if t, ok := pod.Annotations[netboxctrl.TenantAnnotation]; ok {
+		if tenantSlugRegexp.MatchString(t) && len(t) <= maxTenantSlugLength {
+			tenant = t
+		} else {
+			ll.Warn("ignoring invalid tenant annotation", log.String("tenant", t))
+		}
+	}
+
+	vrf, ok := pod.Annotations[netboxctrl.VRFAnnotation]
+	if ok && (vrf == "" || len(vrf) > maxVRFNameLength) {
+		ll.Warn("ignoring invalid VRF annotation", log.String("vrf", vrf))
+		vrf = ""
+	}
+	return tenant, vrf
+}
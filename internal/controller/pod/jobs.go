@@ -0,0 +1,94 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod
+
+import (
+	"context"
+	"fmt"
+
+	log "go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// jobOf returns the owner reference of the Job controlling the pod,
+// or nil if the pod is not controlled by a Job.
+func jobOf(pod *corev1.Pod) *metav1.OwnerReference {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "Job" || owner.APIVersion != batchv1.SchemeGroupVersion.String() {
+		return nil
+	}
+	return owner
+}
+
+// jobFinished returns true if the pod is controlled by a Job
+// that has completed or failed, or that no longer exists.
+func (r *reconciler) jobFinished(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	owner := jobOf(pod)
+	if owner == nil {
+		return false, nil
+	}
+
+	var job batchv1.Job
+	err := r.kubeClient.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: owner.Name}, &job)
+	if kubeerrors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("retrieving job: %w", err)
+	}
+	if job.UID != owner.UID {
+		// a different job with the same name
+		return true, nil
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) &&
+			condition.Status == corev1.ConditionTrue {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// enqueueJobPods returns an event handler that enqueues
+// all pods controlled by the Job of an event.
+func enqueueJobPods(kubeClient client.Client, logger *log.Logger) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, job client.Object) []reconcile.Request {
+		var pods corev1.PodList
+		if err := kubeClient.List(ctx, &pods, client.InNamespace(job.GetNamespace())); err != nil {
+			logger.Error("failed to list pods of job", log.Error(err))
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for i := range pods.Items {
+			if owner := jobOf(&pods.Items[i]); owner != nil && owner.UID == job.GetUID() {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+					Namespace: pods.Items[i].Namespace,
+					Name:      pods.Items[i].Name,
+				}})
+			}
+		}
+		return requests
+	})
+}
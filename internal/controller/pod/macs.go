@@ -0,0 +1,168 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Annotations in which CNI plugins report the network interfaces of pods.
+const (
+	// networkStatusAnnotation is set by Multus, and other CNI plugins
+	// implementing the Kubernetes Network Plumbing WG specification.
+	networkStatusAnnotation = "k8s.v1.cni.cncf.io/network-status"
+	// ovnPodNetworksAnnotation is set by OVN-Kubernetes.
+	ovnPodNetworksAnnotation = "k8s.ovn.org/pod-networks"
+)
+
+// ovnDefaultInterface is the name of the interface of
+// the default network of pods in OVN-Kubernetes.
+const ovnDefaultInterface = "eth0"
+
+// podInterface is a network interface of a pod, as reported by the CNI.
+type podInterface struct {
+	name string
+	mac  string
+}
+
+// podInterfaces returns the network interfaces of the pod by their
+// addresses, as reported in the network-status or OVN pod-networks
+// annotation. Interfaces without a valid MAC address are left out,
+// and so are invalid annotations, since they would only make
+// the NetBoxIPs of the pod fail validation.
+func podInterfaces(pod *corev1.Pod) map[netip.Addr]podInterface {
+	interfaces := make(map[netip.Addr]podInterface)
+	add := func(name, mac string, addresses []string) {
+		hw, err := net.ParseMAC(mac)
+		if err != nil || len(hw) != 6 || name == "" {
+			return
+		}
+		for _, address := range addresses {
+			addr, err := netip.ParseAddr(address)
+			if err != nil {
+				prefix, err := netip.ParsePrefix(address)
+				if err != nil {
+					continue
+				}
+				addr = prefix.Addr()
+			}
+			if _, ok := interfaces[addr]; !ok {
+				interfaces[addr] = podInterface{name: name, mac: hw.String()}
+			}
+		}
+	}
+
+	if status, ok := pod.Annotations[networkStatusAnnotation]; ok {
+		var networks []struct {
+			Interface string   `json:"interface"`
+			IPs       []string `json:"ips"`
+			MAC       string   `json:"mac"`
+		}
+		if err := json.Unmarshal([]byte(status), &networks); err == nil {
+			for _, network := range networks {
+				add(network.Interface, network.MAC, network.IPs)
+			}
+		}
+	}
+
+	if podNetworks, ok := pod.Annotations[ovnPodNetworksAnnotation]; ok {
+		var networks map[string]struct {
+			IPAddresses []string `json:"ip_addresses"`
+			MACAddress  string   `json:"mac_address"`
+		}
+		if err := json.Unmarshal([]byte(podNetworks), &networks); err == nil {
+			if network, ok := networks["default"]; ok {
+				add(ovnDefaultInterface, network.MACAddress, network.IPAddresses)
+			}
+		}
+	}
+
+	return interfaces
+}
+
+// assignInterfaces assigns the IPs of the pod to interfaces of the NetBox
+// device of its node: IPs of host network pods, which are the IPs of the
+// node, to the node interface, if set, and other IPs to interfaces with
+// the MAC addresses of the pod's network interfaces, if enabled.
+func (r *reconciler) assignInterfaces(ctx context.Context, ll *log.Logger, pod *corev1.Pod, ips *ctrl.IPs) error {
+	enabled := r.macAddresses
+	if pod.Spec.HostNetwork {
+		enabled = r.nodeInterface != ""
+	}
+	if !enabled || pod.Spec.NodeName == "" {
+		return nil
+	}
+
+	device, err := r.nodeDevices.DeviceFor(ctx, r.kubeClient, pod.Spec.NodeName)
+	if err != nil {
+		return fmt.Errorf("matching node to NetBox device: %w", err)
+	}
+	if device == "" {
+		ll.Debug("no NetBox device matches node - not assigning IPs to interfaces", log.String("node", pod.Spec.NodeName))
+		return nil
+	}
+
+	if !pod.Spec.HostNetwork {
+		assignMACInterfaces(pod, device, []*v1beta1.NetBoxIP{ips.IPv4, ips.IPv6})
+		return nil
+	}
+	for _, ip := range []*v1beta1.NetBoxIP{ips.IPv4, ips.IPv6} {
+		if ip != nil {
+			ip.Spec.AssignedObject = &v1beta1.AssignedObject{
+				Kind:   v1beta1.AssignedObjectKindInterface,
+				Name:   r.nodeInterface,
+				Parent: device,
+			}
+		}
+	}
+	return nil
+}
+
+// assignMACInterfaces assigns the IPs to the NetBox interfaces of the
+// pod's network interfaces that they are on, if the CNI reports them, on
+// the given device. Since every pod on a node has an eth0, the interfaces
+// are named after the network interface and the UID of the pod, e.g.
+// eth0-<pod UID>, which fits within the 64 characters NetBox allows for
+// interface names.
+func assignMACInterfaces(pod *corev1.Pod, device string, ips []*v1beta1.NetBoxIP) {
+	interfaces := podInterfaces(pod)
+	for _, ip := range ips {
+		if ip == nil {
+			continue
+		}
+		iface, ok := interfaces[ip.Spec.Address]
+		if !ok {
+			continue
+		}
+		ip.Spec.AssignedObject = &v1beta1.AssignedObject{
+			Kind:       v1beta1.AssignedObjectKindInterface,
+			Name:       fmt.Sprintf("%s-%s", iface.name, pod.UID),
+			Parent:     device,
+			MACAddress: iface.mac,
+		}
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/google/go-cmp/cmp"
+
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAssignMACInterfaces(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    map[string]*v1beta1.AssignedObject
+	}{{
+		name:     "no annotations",
+		expected: map[string]*v1beta1.AssignedObject{},
+	}, {
+		name: "network status",
+		annotations: map[string]string{
+			networkStatusAnnotation: `[{"name": "cbr0", "interface": "eth0", "ips": ["10.0.0.1", "fd00::1"], "mac": "0A:58:0A:00:00:01", "default": true}]`,
+		},
+		expected: map[string]*v1beta1.AssignedObject{
+			"10.0.0.1": {Kind: v1beta1.AssignedObjectKindInterface, Name: "eth0-abc", Parent: "node-1", MACAddress: "0a:58:0a:00:00:01"},
+			"fd00::1":  {Kind: v1beta1.AssignedObjectKindInterface, Name: "eth0-abc", Parent: "node-1", MACAddress: "0a:58:0a:00:00:01"},
+		},
+	}, {
+		name: "OVN pod networks",
+		annotations: map[string]string{
+			ovnPodNetworksAnnotation: `{"default": {"ip_addresses": ["10.0.0.1/24"], "mac_address": "0a:58:0a:00:00:02"}}`,
+		},
+		expected: map[string]*v1beta1.AssignedObject{
+			"10.0.0.1": {Kind: v1beta1.AssignedObjectKindInterface, Name: "eth0-abc", Parent: "node-1", MACAddress: "0a:58:0a:00:00:02"},
+		},
+	}, {
+		name: "invalid MAC address",
+		annotations: map[string]string{
+			networkStatusAnnotation: `[{"interface": "eth0", "ips": ["10.0.0.1"], "mac": "foo"}]`,
+		},
+		expected: map[string]*v1beta1.AssignedObject{},
+	}, {
+		name: "invalid annotation",
+		annotations: map[string]string{
+			networkStatusAnnotation: `{`,
+		},
+		expected: map[string]*v1beta1.AssignedObject{},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{UID: "abc", Annotations: test.annotations},
+			}
+			ips := []*v1beta1.NetBoxIP{{
+				Spec: v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("10.0.0.1")},
+			}, {
+				Spec: v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("fd00::1")},
+			}, nil}
+
+			assignMACInterfaces(pod, "node-1", ips)
+
+			assigned := make(map[string]*v1beta1.AssignedObject)
+			for _, ip := range ips {
+				if ip != nil && ip.Spec.AssignedObject != nil {
+					assigned[ip.Spec.Address.String()] = ip.Spec.AssignedObject
+				}
+			}
+			if diff := cmp.Diff(test.expected, assigned); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAssignInterfaces(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"example.com/device": "rack1-server3"}},
+	}
+	networkStatus := `[{"interface": "eth0", "ips": ["10.0.0.1"], "mac": "0a:58:0a:00:00:01"}]`
+
+	tests := []struct {
+		name          string
+		macAddresses  bool
+		nodeInterface string
+		match         string
+		hostNetwork   bool
+		nodeName      string
+		expected      *v1beta1.AssignedObject
+	}{{
+		name:     "disabled",
+		nodeName: "node-1",
+	}, {
+		name:         "MAC address on device named after node",
+		macAddresses: true,
+		nodeName:     "node-1",
+		expected:     &v1beta1.AssignedObject{Kind: v1beta1.AssignedObjectKindInterface, Name: "eth0-abc", Parent: "node-1", MACAddress: "0a:58:0a:00:00:01"},
+	}, {
+		name:         "MAC address on device matched by label",
+		macAddresses: true,
+		match:        "label:example.com/device",
+		nodeName:     "node-1",
+		expected:     &v1beta1.AssignedObject{Kind: v1beta1.AssignedObjectKindInterface, Name: "eth0-abc", Parent: "rack1-server3", MACAddress: "0a:58:0a:00:00:01"},
+	}, {
+		name:         "no device matched",
+		macAddresses: true,
+		match:        "label:example.com/other",
+		nodeName:     "node-1",
+	}, {
+		name:         "not scheduled",
+		macAddresses: true,
+		match:        "label:example.com/device",
+	}, {
+		name:          "host network",
+		macAddresses:  true,
+		nodeInterface: "bond0",
+		match:         "label:example.com/device",
+		hostNetwork:   true,
+		nodeName:      "node-1",
+		expected:      &v1beta1.AssignedObject{Kind: v1beta1.AssignedObjectKindInterface, Name: "bond0", Parent: "rack1-server3"},
+	}, {
+		name:         "host network without node interface",
+		macAddresses: true,
+		hostNetwork:  true,
+		nodeName:     "node-1",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &reconciler{
+				kubeClient:    fakeclient.NewClientBuilder().WithObjects(node).Build(),
+				macAddresses:  test.macAddresses,
+				nodeInterface: test.nodeInterface,
+			}
+			if test.match != "" {
+				matcher, err := ctrl.NewNodeDeviceMatcher(test.match, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.nodeDevices = matcher
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{UID: "abc", Annotations: map[string]string{networkStatusAnnotation: networkStatus}},
+				Spec:       corev1.PodSpec{NodeName: test.nodeName, HostNetwork: test.hostNetwork},
+			}
+			ips := &ctrl.IPs{IPv4: &v1beta1.NetBoxIP{
+				Spec: v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("10.0.0.1")},
+			}}
+
+			if err := r.assignInterfaces(context.Background(), log.NewNop(), pod, ips); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, ips.IPv4.Spec.AssignedObject); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
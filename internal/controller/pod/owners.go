@@ -0,0 +1,71 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxOwnerDepth limits how many controllers are looked up
+// when resolving the owners of a pod.
+const maxOwnerDepth = 5
+
+// ownerAllowed returns true if the pod is controlled, directly or through
+// a chain of controllers, e.g. a Deployment controlling the pod's ReplicaSet,
+// by an object of one of the allowed owner kinds. Without allowed
+// owner kinds, all pods are allowed.
+func (r *reconciler) ownerAllowed(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	if len(r.ownerKinds) == 0 {
+		return true, nil
+	}
+
+	var obj metav1.Object = pod
+	for i := 0; i < maxOwnerDepth; i++ {
+		owner := metav1.GetControllerOfNoCopy(obj)
+		if owner == nil {
+			return false, nil
+		}
+		if r.ownerKinds[owner.Kind] {
+			return true, nil
+		}
+
+		gv, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err != nil {
+			return false, fmt.Errorf("parsing API version of owner %s %s: %w", owner.Kind, owner.Name, err)
+		}
+		var ownerObj metav1.PartialObjectMetadata
+		ownerObj.SetGroupVersionKind(gv.WithKind(owner.Kind))
+		err = r.kubeClient.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: owner.Name}, &ownerObj)
+		if kubeerrors.IsNotFound(err) || kubeerrors.IsForbidden(err) || meta.IsNoMatchError(err) {
+			// the chain cannot be followed any further, e.g. because
+			// the owner is already deleted, or is of an unknown kind
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("retrieving owner %s %s: %w", owner.Kind, owner.Name, err)
+		}
+		obj = &ownerObj
+	}
+	return false, nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOwnerAllowed(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-abc12",
+			Namespace: namespace,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       "web",
+				Controller: pointer.Bool(true),
+			}},
+		},
+	}
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(replicaSet).Build()
+
+	ownedBy := func(apiVersion, kind, name string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{
+			APIVersion: apiVersion,
+			Kind:       kind,
+			Name:       name,
+			Controller: pointer.Bool(true),
+		}}
+	}
+
+	tests := []struct {
+		name            string
+		ownerKinds      map[string]bool
+		owners          []metav1.OwnerReference
+		expectedAllowed bool
+	}{{
+		name:            "no owner kinds",
+		expectedAllowed: true,
+	}, {
+		name:            "no owner",
+		ownerKinds:      map[string]bool{"StatefulSet": true},
+		expectedAllowed: false,
+	}, {
+		name:            "direct owner",
+		ownerKinds:      map[string]bool{"StatefulSet": true},
+		owners:          ownedBy("apps/v1", "StatefulSet", "db"),
+		expectedAllowed: true,
+	}, {
+		name:            "indirect owner",
+		ownerKinds:      map[string]bool{"Deployment": true},
+		owners:          ownedBy("apps/v1", "ReplicaSet", "web-abc12"),
+		expectedAllowed: true,
+	}, {
+		name:            "other owner",
+		ownerKinds:      map[string]bool{"StatefulSet": true},
+		owners:          ownedBy("apps/v1", "ReplicaSet", "web-abc12"),
+		expectedAllowed: false,
+	}, {
+		name:            "deleted owner",
+		ownerKinds:      map[string]bool{"Deployment": true},
+		owners:          ownedBy("apps/v1", "ReplicaSet", "gone"),
+		expectedAllowed: false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &reconciler{
+				kubeClient: kubeClient,
+				ownerKinds: test.ownerKinds,
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            name,
+					Namespace:       namespace,
+					OwnerReferences: test.owners,
+				},
+			}
+
+			allowed, err := r.ownerAllowed(context.Background(), pod)
+			if err != nil {
+				t.Fatalf("unexpected error: %q", err)
+			}
+			if allowed != test.expectedAllowed {
+				t.Errorf("want allowed %t, got %t", test.expectedAllowed, allowed)
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
 	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
@@ -27,16 +28,31 @@ import (
 	"github.com/hashicorp/go-multierror"
 
 	log "go.uber.org/zap"
+	"golang.org/x/time/rate"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
+// ControllerName is the name of the pod controller, which
+// names its workqueue and its rate budget of requests to NetBox.
+const ControllerName = "pod"
+
 type controller struct {
 	reconciler *reconciler
+	// shard of namespaces to reconcile objects in, if sharded
+	shard *ctrl.Shard
+	// limit of the workqueue, if any
+	queueLimit *ctrl.QueueLimit
+	// rate limiter of requests to NetBox, if not shared
+	rateBudget *rate.Limiter
 }
 
 // New returns a new Controller for pods.
@@ -58,32 +74,135 @@ func New(opts ...ctrl.Option) (ctrl.Controller, error) {
 	}
 
 	return &controller{
+		shard:      s.Shard,
+		queueLimit: s.QueueLimit,
+		rateBudget: s.RateBudgets[ControllerName],
 		reconciler: &reconciler{
-			kubeClient:  s.KubeClient,
-			tags:        s.Tags,
-			labels:      s.Labels,
-			log:         logger.With(log.String("reconciler", "pod")),
-			dualStackIP: s.DualStackIP,
+			kubeClient:             s.KubeClient,
+			tags:                   s.Tags,
+			tagCache:               s.TagCache,
+			labels:                 s.Labels,
+			log:                    logger.With(log.String("reconciler", ControllerName)),
+			skipIPv4:               s.SkipIPv4,
+			skipIPv6:               s.SkipIPv6,
+			tenants:                s.TenantMapping,
+			clusterTag:             s.ClusterTag,
+			clusterDomain:          s.ClusterDomain,
+			settings:               s.LiveSettings,
+			completedTTL:           s.CompletedPodIPTTL,
+			descriptionStrategy:    s.DescriptionStrategy,
+			omitDNSName:            s.OmitDNSName,
+			requeueAfter:           s.RequeueInterval,
+			slowThreshold:          s.SlowReconcileThreshold,
+			excludedOwnerKinds:     s.ExcludedOwnerKinds,
+			skipStaticPods:         s.SkipStaticPods,
+			ownerKinds:             s.OwnerKinds,
+			requireReady:           s.RequireReady,
+			notReadyGrace:          s.NotReadyGracePeriod,
+			jobPolicy:              s.JobPolicy,
+			jobTag:                 s.JobTag,
+			annotationOverrides:    s.AnnotationOverrides,
+			hostNetwork:            s.SharedAddresses,
+			customFieldAnnotations: s.CustomFieldAnnotations,
+			macAddresses:           s.MACAddresses,
+			nodeDevices:            s.NodeDevices,
+			nodeInterface:          s.NodeInterface,
 		},
 	}, nil
 }
 
 // AddToManager attaches the controller to the given manager.
 func (c *controller) AddToManager(mgr manager.Manager) error {
-	return builder.
+	q := ctrl.NewQueueTracker(ControllerName, c.queueLimit)
+	b := builder.
 		ControllerManagedBy(mgr).
-		Named("pod").
-		For(&corev1.Pod{}).
-		WithEventFilter(ctrl.OnCreateAndUpdateFilter).
-		Complete(c.reconciler)
+		Named(ControllerName).
+		Watches(&corev1.Pod{}, q.Handler(&handler.EnqueueRequestForObject{})).
+		WithEventFilter(ctrl.OnCreateAndUpdateFilter)
+
+	if c.reconciler.settings != nil {
+		// re-reconcile all pods whenever the settings change
+		b = b.WatchesRawSource(
+			&source.Channel{Source: c.reconciler.settings.Changes()},
+			q.Handler(ctrl.EnqueueAll(mgr.GetClient(), &corev1.PodList{}, c.reconciler.log)),
+		)
+	}
+	if c.reconciler.jobPolicy == ctrl.JobPolicyTag {
+		// remove IPs of pods as soon as their job finishes
+		b = b.Watches(&batchv1.Job{}, q.Handler(enqueueJobPods(mgr.GetClient(), c.reconciler.log)))
+	}
+
+	return b.Complete(q.Reconciler(ctrl.Budgeted(c.rateBudget, ctrl.Sharded(c.shard, c.reconciler))))
 }
 
 type reconciler struct {
-	kubeClient  client.Client
-	tags        []netbox.Tag
-	labels      map[string]bool
-	log         *log.Logger
-	dualStackIP bool
+	kubeClient client.Client
+	tags       []netbox.Tag
+	labels     map[string]bool
+	log        *log.Logger
+	// if true, pod IPs of the family are not published
+	skipIPv4   bool
+	skipIPv6   bool
+	tenants    *ctrl.TenantMapping
+	clusterTag string
+	// domain of the cluster, for DNS names of pods with a hostname and subdomain
+	clusterDomain string
+	// if set, replaces the tags that it has resolved
+	// with their current values in NetBox
+	tagCache *ctrl.TagCache
+	// settings, if set, replace tags and labels
+	settings *ctrl.LiveSettings
+	// how long IPs of completed pods are kept
+	completedTTL time.Duration
+	// if true, IPs are published without a DNS name
+	omitDNSName bool
+	// how descriptions longer than NetBox allows are shortened
+	descriptionStrategy string
+	// how often pods are reconciled without changes, if at all
+	requeueAfter time.Duration
+	// slowThreshold, if greater than 0, is how long a reconciliation
+	// may take before a warning with a breakdown of its time is logged
+	slowThreshold time.Duration
+	// pods controlled by objects of these kinds are not published
+	excludedOwnerKinds map[string]bool
+	// if true, static pods are not published
+	skipStaticPods bool
+	// if not empty, only pods controlled, directly or indirectly,
+	// by objects of these kinds are published
+	ownerKinds map[string]bool
+	// if true, only ready pods are published, and IPs of pods
+	// that stop being ready are kept for notReadyGrace
+	requireReady  bool
+	notReadyGrace time.Duration
+	// how IPs of pods controlled by Jobs are published,
+	// and the tag added to them with ctrl.JobPolicyTag
+	jobPolicy string
+	jobTag    *netbox.Tag
+	// if true, the tenant and VRF of IPs may be set with pod annotations
+	annotationOverrides bool
+	// if true, IPs of host network pods are published,
+	// sharing the IP of the node in NetBox
+	hostNetwork bool
+	// NetBox custom fields set from pod annotations,
+	// by field name, with the annotation as value
+	customFieldAnnotations map[string]string
+	// if true, IPs are assigned to NetBox interfaces with the
+	// MAC addresses that the CNI reports in pod annotations
+	macAddresses bool
+	// maps nodes to the NetBox devices that interfaces are assigned on
+	nodeDevices *ctrl.NodeDeviceMatcher
+	// if set, the interface of the devices of nodes
+	// that IPs of host network pods are assigned to
+	nodeInterface string
+}
+
+// publishSettings returns the current tags, publish labels
+// and namespace filters of the reconciler.
+func (r *reconciler) publishSettings() ctrl.PublishSettings {
+	if r.settings != nil {
+		return r.settings.Get()
+	}
+	return ctrl.PublishSettings{Tags: r.tags, Labels: r.labels}
 }
 
 // Reconcile is called on every event that the given reconciler is watching,
@@ -93,9 +212,18 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		log.String("namespace", req.Namespace),
 		log.String("name", req.Name),
 	)
+	ctx, ll = ctrl.WithRequestID(ctx, ll)
 
 	ll.Info("reconciling pod")
 
+	ctx, outcome := ctrl.WithOutcome(ctx)
+	result, err := r.reconcilePod(ctx, ll, req)
+	outcome.Log(ll, err)
+	outcome.WarnIfSlow(ll, r.slowThreshold)
+	return result, err
+}
+
+func (r *reconciler) reconcilePod(ctx context.Context, ll *log.Logger, req reconcile.Request) (reconcile.Result, error) {
 	var pod corev1.Pod
 	err := r.kubeClient.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: req.Name}, &pod)
 	if err != nil {
@@ -103,22 +231,79 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 			ll.Error("failed to retrieve pod", log.Error(err))
 			return reconcile.Result{}, fmt.Errorf("retrieving pod: %w", err)
 		}
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonNotFound)
 		return reconcile.Result{}, nil
 	}
 
-	if pod.Spec.HostNetwork {
+	if pod.Spec.HostNetwork && !r.hostNetwork {
 		// a pod on host network will have the same IP as the node
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonNotPublished)
 		return reconcile.Result{}, nil
 	}
 
-	ips, err := r.netboxIPsFromPod(&pod, r.dualStackIP)
+	tenant, err := r.tenants.TenantFor(ctx, r.kubeClient, pod.Namespace)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("determining tenant: %w", err)
+	}
+
+	settings := r.publishSettings()
+
+	ownerAllowed, err := r.ownerAllowed(ctx, &pod)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("resolving owners: %w", err)
+	}
+	publish := ownerAllowed && r.podShouldHaveIP(&pod, settings)
+
+	var jobFinished bool
+	if r.jobPolicy == ctrl.JobPolicyTag {
+		if jobFinished, err = r.jobFinished(ctx, &pod); err != nil {
+			return reconcile.Result{}, err
+		}
+		// IPs of pods of finished jobs are removed right away
+		publish = publish && !jobFinished
+	}
+
+	if remaining := r.completedTTLRemaining(&pod); remaining > 0 && !jobFinished {
+		// IPs of recently completed pods are kept, so that they can
+		// still be looked up in NetBox; they are neither created nor
+		// updated though, as the pod is no longer running
+		ll.Info("keeping IPs of completed pod", log.Duration("remaining", remaining))
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonKept)
+		return reconcile.Result{RequeueAfter: remaining}, nil
+	}
+
+	if remaining := r.notReadyGraceRemaining(&pod); remaining > 0 {
+		// the pod may become ready again soon, so its IPs
+		// are kept, but not created or updated until it does
+		ll.Info("keeping IPs of pod that is not ready", log.Duration("remaining", remaining))
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonKept)
+		return reconcile.Result{RequeueAfter: remaining}, nil
+	}
+
+	tenant, vrf := r.applyAnnotations(ll, &pod, tenant)
+
+	ips, err := r.netboxIPsFromPod(&pod, tenant, vrf, settings)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
+	if publish {
+		if err := r.assignInterfaces(ctx, ll, &pod, ips); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	successors := ips
+	if !publish {
+		// the IPs are removed, rather than taken over
+		successors = &ctrl.IPs{}
+	}
+	if err := ctrl.MigrateUnsuffixedNetBoxIP(ctx, r.kubeClient, ll, &pod, successors); err != nil {
+		return reconcile.Result{}, err
+	}
 
 	// Create/update non-nil NetBoxIPs
 	for _, ip := range []*v1beta1.NetBoxIP{ips.IPv4, ips.IPv6} {
-		if ip == nil || !r.podShouldHaveIP(&pod) {
+		if ip == nil || !publish {
 			continue
 		}
 
@@ -136,11 +321,11 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	// This is because if the pod has entered a completed phase, its IP may be re-used by another pod.
 
 	var errs multierror.Error
-	if err = r.deleteNetBoxIPIfStale(ctx, ips.IPv4, pod, "ipv4"); err != nil {
+	if err = r.deleteNetBoxIPIfStale(ctx, ips.IPv4, pod, "ipv4", publish); err != nil {
 		multierror.Append(&errs, err)
 	}
 
-	if err = r.deleteNetBoxIPIfStale(ctx, ips.IPv6, pod, "ipv6"); err != nil {
+	if err = r.deleteNetBoxIPIfStale(ctx, ips.IPv6, pod, "ipv6", publish); err != nil {
 		multierror.Append(&errs, err)
 	}
 
@@ -148,24 +333,51 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		return reconcile.Result{}, &errs
 	}
 
-	return reconcile.Result{}, nil
+	return ctrl.Requeue(r.requeueAfter), nil
 }
 
-func (r *reconciler) netboxIPsFromPod(pod *corev1.Pod, dualStack bool) (*ctrl.IPs, error) {
+func (r *reconciler) netboxIPsFromPod(pod *corev1.Pod, tenant, vrf string, settings ctrl.PublishSettings) (*ctrl.IPs, error) {
 	var podIPs []string
-	if dualStack {
-		for _, ip := range pod.Status.PodIPs {
-			podIPs = append(podIPs, ip.IP)
-		}
-	} else {
+	for _, ip := range pod.Status.PodIPs {
+		podIPs = append(podIPs, ip.IP)
+	}
+	if len(podIPs) == 0 {
+		// set by older API servers, or in tests
 		podIPs = []string{pod.Status.PodIP}
 	}
+	podIPs = ctrl.PublishedFamilies(podIPs, r.skipIPv4, r.skipIPv6)
+
+	var dnsName string
+	if !r.omitDNSName {
+		dnsName = r.dnsName(pod)
+	}
+
+	tags := settings.Tags
+	if r.jobPolicy == ctrl.JobPolicyTag && r.jobTag != nil && jobOf(pod) != nil {
+		tags = append(append([]netbox.Tag{}, tags...), *r.jobTag)
+	}
+
+	var customFields map[string]string
+	if len(r.customFieldAnnotations) > 0 {
+		// fields of missing annotations are included,
+		// so that they are cleared when an annotation is removed
+		customFields = make(map[string]string)
+		for field, annotation := range r.customFieldAnnotations {
+			customFields[field] = pod.Annotations[annotation]
+		}
+	}
 
 	ips, err := ctrl.CreateNetBoxIPs(podIPs, ctrl.NetBoxIPConfig{
-		Object:           pod,
-		DNSName:          pod.Name,
-		ReconcilerTags:   r.tags,
-		ReconcilerLabels: r.labels,
+		Object:              pod,
+		DNSName:             dnsName,
+		ReconcilerTags:      tags,
+		TagCache:            r.tagCache,
+		ReconcilerLabels:    settings.Labels,
+		Tenant:              tenant,
+		VRF:                 vrf,
+		CustomFields:        customFields,
+		ClusterTag:          r.clusterTag,
+		DescriptionStrategy: r.descriptionStrategy,
 	})
 	if err != nil {
 		return &ctrl.IPs{}, err
@@ -174,22 +386,117 @@ func (r *reconciler) netboxIPsFromPod(pod *corev1.Pod, dualStack bool) (*ctrl.IP
 	return ips, nil
 }
 
-func (r *reconciler) deleteNetBoxIPIfStale(ctx context.Context, netboxip *v1beta1.NetBoxIP, pod corev1.Pod, suffix string) error {
+func (r *reconciler) deleteNetBoxIPIfStale(ctx context.Context, netboxip *v1beta1.NetBoxIP, pod corev1.Pod, suffix string, publish bool) error {
 	var ip v1beta1.NetBoxIP
 	err := r.kubeClient.Get(context.Background(), client.ObjectKey{Namespace: pod.Namespace, Name: ctrl.NetBoxIPName(&pod, suffix)}, &ip)
 	if client.IgnoreNotFound(err) != nil {
 		return fmt.Errorf("fetching NetBoxIP: %q", err)
-	} else if !kubeerrors.IsNotFound(err) {
-		if netboxip == nil || !r.podShouldHaveIP(&pod) {
+	} else if !kubeerrors.IsNotFound(err) && !ctrl.IsManual(&ip) {
+		if netboxip == nil || !publish {
 			if err := r.kubeClient.Delete(ctx, &ip); client.IgnoreNotFound(err) != nil {
 				return fmt.Errorf("deleting netboxip: %w", err)
 			}
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeDeleted, ctrl.ReasonStale)
+		}
+	}
+	return nil
+}
+
+// completedTTLRemaining returns how much longer the IPs of
+// a succeeded or failed pod are kept, or 0 if they are not.
+func (r *reconciler) completedTTLRemaining(pod *corev1.Pod) time.Duration {
+	if r.completedTTL <= 0 || !podCompleted(pod) {
+		return 0
+	}
+	completed := podCompletionTime(pod)
+	if completed.IsZero() {
+		return 0
+	}
+	return time.Until(completed.Add(r.completedTTL))
+}
+
+// notReadyGraceRemaining returns how much longer the IPs of
+// a pod that is not ready are kept, or 0 if they are not.
+func (r *reconciler) notReadyGraceRemaining(pod *corev1.Pod) time.Duration {
+	if !r.requireReady || r.notReadyGrace <= 0 || podCompleted(pod) {
+		return 0
+	}
+	ready := readyCondition(pod)
+	if ready == nil || ready.Status == corev1.ConditionTrue {
+		return 0
+	}
+	return time.Until(ready.LastTransitionTime.Add(r.notReadyGrace))
+}
+
+// readyCondition returns the Ready condition of the pod, if it has one.
+func readyCondition(pod *corev1.Pod) *corev1.PodCondition {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == corev1.PodReady {
+			return &pod.Status.Conditions[i]
 		}
 	}
 	return nil
 }
 
-func (r *reconciler) podShouldHaveIP(pod *corev1.Pod) bool {
-	return ctrl.HasPublishLabels(r.labels, pod.Labels) &&
-		!(pod.Status.PodIP == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed)
+func podReady(pod *corev1.Pod) bool {
+	ready := readyCondition(pod)
+	return ready != nil && ready.Status == corev1.ConditionTrue
+}
+
+func podCompleted(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// podCompletionTime returns the time at which the last container of
+// a completed pod terminated, or, if no container ever ran, at which
+// the pod stopped being ready. It is zero if neither is known.
+func podCompletionTime(pod *corev1.Pod) time.Time {
+	var completed time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if t := status.State.Terminated; t != nil && t.FinishedAt.Time.After(completed) {
+			completed = t.FinishedAt.Time
+		}
+	}
+	if !completed.IsZero() {
+		return completed
+	}
+	if ready := readyCondition(pod); ready != nil {
+		return ready.LastTransitionTime.Time
+	}
+	return completed
+}
+
+func (r *reconciler) podShouldHaveIP(pod *corev1.Pod, settings ctrl.PublishSettings) bool {
+	return ctrl.HasPublishLabels(settings.Labels, pod.Labels) &&
+		settings.PublishesNamespace(pod.Namespace) &&
+		!r.podExcluded(pod) &&
+		!(r.jobPolicy == ctrl.JobPolicySkip && jobOf(pod) != nil) &&
+		(!r.requireReady || podReady(pod)) &&
+		!(pod.Status.PodIP == "" || podCompleted(pod))
+}
+
+// dnsName returns the DNS name of the pod: pods with a hostname and
+// a subdomain, e.g. StatefulSet pods, have a record in cluster DNS,
+// <hostname>.<subdomain>.<namespace>.svc.<cluster domain>, while
+// others are known by their name only.
+func (r *reconciler) dnsName(pod *corev1.Pod) string {
+	if pod.Spec.Hostname == "" || pod.Spec.Subdomain == "" || r.clusterDomain == "" {
+		return pod.Name
+	}
+	return fmt.Sprintf("%s.%s.%s.svc.%s", pod.Spec.Hostname, pod.Spec.Subdomain, pod.Namespace, r.clusterDomain)
+}
+
+// podExcluded returns true if the IPs of the pod are not published
+// because it is a static pod, or because of the kind of its controller.
+func (r *reconciler) podExcluded(pod *corev1.Pod) bool {
+	if r.skipStaticPods {
+		// the API server only has mirrors of static pods
+		if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+			return true
+		}
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil && r.excludedOwnerKinds[owner.Kind] {
+		return true
+	}
+	return false
 }
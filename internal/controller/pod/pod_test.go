@@ -21,14 +21,17 @@ import (
 	"fmt"
 	"net/netip"
 	"testing"
+	"time"
 
 	netboxctrl "github.com/digitalocean/netbox-ip-controller"
 	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
 	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	log "go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -61,6 +64,8 @@ func TestReconcile(t *testing.T) {
 		existingPod      *corev1.Pod
 		existingNetBoxIP *v1beta1.NetBoxIP
 		expectedNetBoxIP *v1beta1.NetBoxIP
+		// if true, IPv6 addresses are not published
+		skipIPv6 bool
 	}{{
 		name:             "does not exist",
 		existingPod:      nil,
@@ -298,9 +303,10 @@ func TestReconcile(t *testing.T) {
 		},
 		expectedNetBoxIP: nil,
 	}, {
-		// It is acceptable to have dual stack services/pods exist in a cluster even if
-		// dual-stack-ips is disabled. In this case, only the PodIP should be registered into netbox
 		name: "with dual stack PodIPs",
+		// if IPv6 addresses are not published, only the IPv4 PodIP
+		// of dual stack pods is registered in NetBox
+		skipIPv6: true,
 		existingPod: &corev1.Pod{
 			TypeMeta: metav1.TypeMeta{
 				Kind:       "Pod",
@@ -351,7 +357,7 @@ func TestReconcile(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			kubeClientBuilder := fakeclient.NewClientBuilder().WithScheme(scheme)
+			kubeClientBuilder := fakeclient.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&v1beta1.NetBoxIP{})
 
 			var existingObjs []client.Object
 			if test.existingPod != nil {
@@ -367,6 +373,7 @@ func TestReconcile(t *testing.T) {
 				tags:       []netbox.Tag{{Name: "bar", Slug: "bar"}},
 				labels:     map[string]bool{"pod": true},
 				log:        log.L(),
+				skipIPv6:   test.skipIPv6,
 			}
 
 			req := reconcile.Request{
@@ -391,7 +398,7 @@ func TestReconcile(t *testing.T) {
 			} else if test.expectedNetBoxIP == nil && !kubeerrors.IsNotFound(err) {
 				t.Errorf("want NetBoxIP not to exist, got %v\n", actualNetBoxIP)
 			} else if test.expectedNetBoxIP != nil {
-				if diff := cmp.Diff(test.expectedNetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmp.Comparer(addrComparer)); diff != "" {
+				if diff := cmp.Diff(test.expectedNetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmpopts.IgnoreFields(v1beta1.NetBoxIP{}, "Status"), cmp.Comparer(addrComparer)); diff != "" {
 					t.Errorf("NetBoxIP object (-want, +got)\n%s", diff)
 				}
 			}
@@ -940,7 +947,7 @@ func TestReconcileDualStack(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			kubeClientBuilder := fakeclient.NewClientBuilder().WithScheme(scheme)
+			kubeClientBuilder := fakeclient.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&v1beta1.NetBoxIP{})
 
 			var existingObjs []client.Object
 			if test.existingPod != nil {
@@ -954,11 +961,10 @@ func TestReconcileDualStack(t *testing.T) {
 			kubeClientBuilder = kubeClientBuilder.WithObjects(existingObjs...)
 
 			r := &reconciler{
-				kubeClient:  kubeClientBuilder.Build(),
-				tags:        []netbox.Tag{{Name: "bar", Slug: "bar"}},
-				labels:      map[string]bool{"pod": true},
-				log:         log.L(),
-				dualStackIP: true,
+				kubeClient: kubeClientBuilder.Build(),
+				tags:       []netbox.Tag{{Name: "bar", Slug: "bar"}},
+				labels:     map[string]bool{"pod": true},
+				log:        log.L(),
 			}
 
 			req := reconcile.Request{
@@ -985,7 +991,7 @@ func TestReconcileDualStack(t *testing.T) {
 			} else if test.expectedIPv4NetBoxIP == nil && !kubeerrors.IsNotFound(err) {
 				t.Errorf("want IPv4 NetBoxIP not to exist, got %v\n", actualNetBoxIP)
 			} else if test.expectedIPv4NetBoxIP != nil {
-				if diff := cmp.Diff(test.expectedIPv4NetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmp.Comparer(addrComparer)); diff != "" {
+				if diff := cmp.Diff(test.expectedIPv4NetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmpopts.IgnoreFields(v1beta1.NetBoxIP{}, "Status"), cmp.Comparer(addrComparer)); diff != "" {
 					t.Errorf("NetBoxIP object (-want, +got)\n%s", diff)
 				}
 			}
@@ -1001,10 +1007,683 @@ func TestReconcileDualStack(t *testing.T) {
 			} else if test.expectedIPv6NetBoxIP == nil && !kubeerrors.IsNotFound(err) {
 				t.Errorf("want IPv6 NetBoxIP not to exist, got %v\n", actualNetBoxIP)
 			} else if test.expectedIPv6NetBoxIP != nil {
-				if diff := cmp.Diff(test.expectedIPv6NetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmp.Comparer(addrComparer)); diff != "" {
+				if diff := cmp.Diff(test.expectedIPv6NetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmpopts.IgnoreFields(v1beta1.NetBoxIP{}, "Status"), cmp.Comparer(addrComparer)); diff != "" {
 					t.Errorf("NetBoxIP object (-want, +got)\n%s", diff)
 				}
 			}
 		})
 	}
 }
+
+func TestReconcileWithLiveSettings(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID(podUID),
+			Labels:    map[string]string{"team": "foo"},
+		},
+		Status: corev1.PodStatus{
+			PodIP: "192.168.0.1",
+		},
+	}
+
+	settings := ctrl.NewLiveSettings(ctrl.PublishSettings{
+		Tags:   []netbox.Tag{{Name: "bar", Slug: "bar"}},
+		Labels: map[string]bool{"team": true},
+	})
+	r := &reconciler{
+		kubeClient: fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build(),
+		// replaced by the live settings
+		labels:   map[string]bool{"pod": true},
+		log:      log.L(),
+		settings: settings,
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+	ipKey := client.ObjectKey{Namespace: namespace, Name: fmt.Sprintf("pod-%s-ipv4", podUID)}
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q\n", err)
+	}
+	var ip v1beta1.NetBoxIP
+	if err := r.kubeClient.Get(context.Background(), ipKey, &ip); err != nil {
+		t.Fatalf("fetching NetBoxIP: %q\n", err)
+	}
+	if diff := cmp.Diff([]v1beta1.Tag{{Name: "bar", Slug: "bar"}}, ip.Spec.Tags); diff != "" {
+		t.Errorf("tags (-want, +got)\n%s", diff)
+	}
+
+	settings.Set(ctrl.PublishSettings{
+		Tags:               []netbox.Tag{{Name: "bar", Slug: "bar"}},
+		Labels:             map[string]bool{"team": true},
+		ExcludedNamespaces: map[string]bool{namespace: true},
+	})
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q\n", err)
+	}
+	// the NetBoxIP is kept until its finalizer removes the IP from NetBox
+	if err := r.kubeClient.Get(context.Background(), ipKey, &ip); client.IgnoreNotFound(err) != nil {
+		t.Fatalf("fetching NetBoxIP: %q\n", err)
+	} else if err == nil && ip.DeletionTimestamp == nil {
+		t.Error("want NetBoxIP in excluded namespace to be deleted")
+	}
+}
+
+func TestReconcileCompletedPodWithTTL(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	tests := []struct {
+		name            string
+		finishedAgo     time.Duration
+		expectedRequeue bool
+	}{{
+		name:            "within TTL",
+		finishedAgo:     10 * time.Minute,
+		expectedRequeue: true,
+	}, {
+		name:            "TTL expired",
+		finishedAgo:     2 * time.Hour,
+		expectedRequeue: false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					UID:       types.UID(podUID),
+					Labels:    map[string]string{"app": "job"},
+				},
+				Status: corev1.PodStatus{
+					Phase: corev1.PodSucceeded,
+					PodIP: "192.168.0.1",
+					ContainerStatuses: []corev1.ContainerStatus{{
+						State: corev1.ContainerState{
+							Terminated: &corev1.ContainerStateTerminated{
+								FinishedAt: metav1.NewTime(time.Now().Add(-test.finishedAgo)),
+							},
+						},
+					}},
+				},
+			}
+			ip := &v1beta1.NetBoxIP{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      fmt.Sprintf("pod-%s-ipv4", podUID),
+					Namespace: namespace,
+				},
+				Spec: v1beta1.NetBoxIPSpec{
+					Address: netip.MustParseAddr("192.168.0.1"),
+					DNSName: name,
+				},
+			}
+
+			r := &reconciler{
+				kubeClient:   fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(pod, ip).Build(),
+				labels:       map[string]bool{"app": true},
+				log:          log.L(),
+				completedTTL: time.Hour,
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+			res, err := r.Reconcile(context.Background(), req)
+			if err != nil {
+				t.Fatalf("reconciling: %q\n", err)
+			}
+			if requeued := res.RequeueAfter > 0; requeued != test.expectedRequeue {
+				t.Errorf("want requeue %t, got requeue after %s", test.expectedRequeue, res.RequeueAfter)
+			}
+
+			err = r.kubeClient.Get(context.Background(), client.ObjectKeyFromObject(ip), &v1beta1.NetBoxIP{})
+			if test.expectedRequeue && err != nil {
+				t.Errorf("want NetBoxIP to be kept, got %q", err)
+			} else if !test.expectedRequeue && !kubeerrors.IsNotFound(err) {
+				t.Errorf("want NetBoxIP to be deleted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReconcileWithoutDNSName(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID(podUID),
+			Labels:    map[string]string{"app": "foo"},
+		},
+		Status: corev1.PodStatus{PodIP: "192.168.0.1"},
+	}
+
+	r := &reconciler{
+		kubeClient:  fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build(),
+		labels:      map[string]bool{"app": true},
+		log:         log.L(),
+		omitDNSName: true,
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q\n", err)
+	}
+
+	var ip v1beta1.NetBoxIP
+	key := types.NamespacedName{Namespace: namespace, Name: fmt.Sprintf("pod-%s-ipv4", podUID)}
+	if err := r.kubeClient.Get(context.Background(), key, &ip); err != nil {
+		t.Fatalf("retrieving netboxip: %q\n", err)
+	}
+	if ip.Spec.DNSName != "" {
+		t.Errorf("want no DNS name, got %q", ip.Spec.DNSName)
+	}
+}
+
+func TestReconcileRecordsAddressHistory(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID(podUID),
+			Labels:    map[string]string{"app": "foo"},
+		},
+		Status: corev1.PodStatus{PodIP: "192.168.0.1"},
+	}
+
+	r := &reconciler{
+		kubeClient: fakeclient.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&v1beta1.NetBoxIP{}).WithObjects(pod).Build(),
+		labels:     map[string]bool{"app": true},
+		log:        log.L(),
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q\n", err)
+	}
+
+	// the pod gets a new IP, e.g. after its sandbox is recreated
+	pod.Status.PodIP = "192.168.0.2"
+	if err := r.kubeClient.Status().Update(context.Background(), pod); err != nil {
+		t.Fatalf("updating pod: %q\n", err)
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q\n", err)
+	}
+
+	var ip v1beta1.NetBoxIP
+	key := types.NamespacedName{Namespace: namespace, Name: fmt.Sprintf("pod-%s-ipv4", podUID)}
+	if err := r.kubeClient.Get(context.Background(), key, &ip); err != nil {
+		t.Fatalf("retrieving netboxip: %q\n", err)
+	}
+	if ip.Spec.Address != netip.MustParseAddr("192.168.0.2") {
+		t.Errorf("want address 192.168.0.2, got %s", ip.Spec.Address)
+	}
+	if len(ip.Status.History) != 1 || ip.Status.History[0].Address != netip.MustParseAddr("192.168.0.1") {
+		t.Errorf("want 192.168.0.1 in address history, got %+v", ip.Status.History)
+	}
+}
+
+func TestReconcileHostNetwork(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	for _, hostNetwork := range []bool{false, true} {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				UID:       types.UID(podUID),
+				Labels:    map[string]string{"app": "foo"},
+			},
+			Spec:   corev1.PodSpec{HostNetwork: true},
+			Status: corev1.PodStatus{PodIP: "192.168.0.1"},
+		}
+
+		r := &reconciler{
+			kubeClient:  fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build(),
+			labels:      map[string]bool{"app": true},
+			log:         log.L(),
+			hostNetwork: hostNetwork,
+		}
+
+		req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+		if _, err := r.Reconcile(context.Background(), req); err != nil {
+			t.Fatalf("reconciling: %q\n", err)
+		}
+
+		var ip v1beta1.NetBoxIP
+		key := types.NamespacedName{Namespace: namespace, Name: fmt.Sprintf("pod-%s-ipv4", podUID)}
+		err := r.kubeClient.Get(context.Background(), key, &ip)
+		if hostNetwork && err != nil {
+			t.Errorf("want netboxip of host network pod, got error %q", err)
+		} else if !hostNetwork && !kubeerrors.IsNotFound(err) {
+			t.Errorf("want no netboxip of host network pod, got error %v", err)
+		}
+	}
+}
+
+func TestReconcileWithCustomFields(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			UID:         types.UID(podUID),
+			Labels:      map[string]string{"app": "foo"},
+			Annotations: map[string]string{"example.com/cost-center": "billing"},
+		},
+		Status: corev1.PodStatus{PodIP: "192.168.0.1"},
+	}
+
+	r := &reconciler{
+		kubeClient: fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build(),
+		labels:     map[string]bool{"app": true},
+		log:        log.L(),
+		customFieldAnnotations: map[string]string{
+			"cost_center": "example.com/cost-center",
+			"team":        "example.com/team",
+		},
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q\n", err)
+	}
+
+	var ip v1beta1.NetBoxIP
+	key := types.NamespacedName{Namespace: namespace, Name: fmt.Sprintf("pod-%s-ipv4", podUID)}
+	if err := r.kubeClient.Get(context.Background(), key, &ip); err != nil {
+		t.Fatalf("retrieving netboxip: %q\n", err)
+	}
+	// the field of the missing annotation is cleared
+	expectedCustomFields := map[string]string{"cost_center": "billing", "team": ""}
+	if diff := cmp.Diff(expectedCustomFields, ip.Spec.CustomFields); diff != "" {
+		t.Errorf("custom fields (-want, +got)\n%s", diff)
+	}
+}
+
+func TestDNSName(t *testing.T) {
+	tests := []struct {
+		name            string
+		spec            corev1.PodSpec
+		expectedDNSName string
+	}{{
+		name:            "without hostname and subdomain",
+		expectedDNSName: "foo",
+	}, {
+		name:            "with hostname only",
+		spec:            corev1.PodSpec{Hostname: "web-0"},
+		expectedDNSName: "foo",
+	}, {
+		name:            "with subdomain only",
+		spec:            corev1.PodSpec{Subdomain: "web"},
+		expectedDNSName: "foo",
+	}, {
+		name:            "with hostname and subdomain",
+		spec:            corev1.PodSpec{Hostname: "web-0", Subdomain: "web"},
+		expectedDNSName: "web-0.web.test.svc.cluster.local",
+	}}
+
+	r := &reconciler{clusterDomain: "cluster.local"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec:       test.spec,
+			}
+			if dnsName := r.dnsName(pod); dnsName != test.expectedDNSName {
+				t.Errorf("want DNS name %q, got %q", test.expectedDNSName, dnsName)
+			}
+		})
+	}
+}
+
+func TestApplyAnnotations(t *testing.T) {
+	tests := []struct {
+		name                string
+		annotationOverrides bool
+		annotations         map[string]string
+		expectedTenant      string
+		expectedVRF         string
+	}{{
+		name:                "overrides disabled",
+		annotationOverrides: false,
+		annotations: map[string]string{
+			netboxctrl.TenantAnnotation: "team-b",
+			netboxctrl.VRFAnnotation:    "blue",
+		},
+		expectedTenant: "team-a",
+	}, {
+		name:                "no annotations",
+		annotationOverrides: true,
+		expectedTenant:      "team-a",
+	}, {
+		name:                "overrides",
+		annotationOverrides: true,
+		annotations: map[string]string{
+			netboxctrl.TenantAnnotation: "team-b",
+			netboxctrl.VRFAnnotation:    "blue",
+		},
+		expectedTenant: "team-b",
+		expectedVRF:    "blue",
+	}, {
+		name:                "invalid annotations",
+		annotationOverrides: true,
+		annotations: map[string]string{
+			netboxctrl.TenantAnnotation: "team b",
+			netboxctrl.VRFAnnotation:    "",
+		},
+		expectedTenant: "team-a",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &reconciler{annotationOverrides: test.annotationOverrides}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Namespace:   namespace,
+					Annotations: test.annotations,
+				},
+			}
+			tenant, vrf := r.applyAnnotations(log.L(), pod, "team-a")
+			if tenant != test.expectedTenant {
+				t.Errorf("want tenant %q, got %q", test.expectedTenant, tenant)
+			}
+			if vrf != test.expectedVRF {
+				t.Errorf("want VRF %q, got %q", test.expectedVRF, vrf)
+			}
+		})
+	}
+}
+
+func TestPodExcluded(t *testing.T) {
+	controller := true
+	tests := []struct {
+		name             string
+		annotations      map[string]string
+		owners           []metav1.OwnerReference
+		expectedExcluded bool
+	}{{
+		name: "deployment pod",
+		owners: []metav1.OwnerReference{{
+			Kind:       "ReplicaSet",
+			Name:       "foo-abc12",
+			Controller: &controller,
+		}},
+		expectedExcluded: false,
+	}, {
+		name: "daemonset pod",
+		owners: []metav1.OwnerReference{{
+			Kind:       "DaemonSet",
+			Name:       "foo",
+			Controller: &controller,
+		}},
+		expectedExcluded: true,
+	}, {
+		name: "owned, but not controlled by daemonset",
+		owners: []metav1.OwnerReference{{
+			Kind: "DaemonSet",
+			Name: "foo",
+		}},
+		expectedExcluded: false,
+	}, {
+		name:             "static pod",
+		annotations:      map[string]string{corev1.MirrorPodAnnotationKey: "abc"},
+		expectedExcluded: true,
+	}}
+
+	r := &reconciler{
+		excludedOwnerKinds: map[string]bool{"DaemonSet": true},
+		skipStaticPods:     true,
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            name,
+					Namespace:       namespace,
+					Annotations:     test.annotations,
+					OwnerReferences: test.owners,
+				},
+			}
+			if excluded := r.podExcluded(pod); excluded != test.expectedExcluded {
+				t.Errorf("want excluded %t, got %t", test.expectedExcluded, excluded)
+			}
+		})
+	}
+}
+
+func TestReconcileRequireReady(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	tests := []struct {
+		name            string
+		ready           corev1.ConditionStatus
+		transitionAgo   time.Duration
+		existingIP      bool
+		expectedIP      bool
+		expectedRequeue bool
+	}{{
+		name:          "ready",
+		ready:         corev1.ConditionTrue,
+		transitionAgo: time.Minute,
+		expectedIP:    true,
+	}, {
+		name:            "not ready yet",
+		ready:           corev1.ConditionFalse,
+		transitionAgo:   time.Minute,
+		expectedIP:      false,
+		expectedRequeue: true,
+	}, {
+		name:            "not ready within grace period",
+		ready:           corev1.ConditionFalse,
+		transitionAgo:   time.Minute,
+		existingIP:      true,
+		expectedIP:      true,
+		expectedRequeue: true,
+	}, {
+		name:          "not ready after grace period",
+		ready:         corev1.ConditionFalse,
+		transitionAgo: time.Hour,
+		existingIP:    true,
+		expectedIP:    false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					UID:       types.UID(podUID),
+					Labels:    map[string]string{"app": "foo"},
+				},
+				Status: corev1.PodStatus{
+					PodIP: "192.168.0.1",
+					Conditions: []corev1.PodCondition{{
+						Type:               corev1.PodReady,
+						Status:             test.ready,
+						LastTransitionTime: metav1.NewTime(time.Now().Add(-test.transitionAgo)),
+					}},
+				},
+			}
+			ipKey := types.NamespacedName{Namespace: namespace, Name: fmt.Sprintf("pod-%s-ipv4", podUID)}
+			objs := []client.Object{pod}
+			if test.existingIP {
+				objs = append(objs, &v1beta1.NetBoxIP{
+					ObjectMeta: metav1.ObjectMeta{Name: ipKey.Name, Namespace: ipKey.Namespace},
+					Spec: v1beta1.NetBoxIPSpec{
+						Address: netip.MustParseAddr("192.168.0.1"),
+						DNSName: name,
+					},
+				})
+			}
+
+			r := &reconciler{
+				kubeClient:    fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+				labels:        map[string]bool{"app": true},
+				log:           log.L(),
+				requireReady:  true,
+				notReadyGrace: 5 * time.Minute,
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+			res, err := r.Reconcile(context.Background(), req)
+			if err != nil {
+				t.Fatalf("reconciling: %q\n", err)
+			}
+			if requeued := res.RequeueAfter > 0; requeued != test.expectedRequeue {
+				t.Errorf("want requeue %t, got requeue after %s", test.expectedRequeue, res.RequeueAfter)
+			}
+
+			err = r.kubeClient.Get(context.Background(), ipKey, &v1beta1.NetBoxIP{})
+			if test.expectedIP && err != nil {
+				t.Errorf("want NetBoxIP, got %q", err)
+			} else if !test.expectedIP && !kubeerrors.IsNotFound(err) {
+				t.Errorf("want NetBoxIP to not exist, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReconcileJobPods(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	jobTag := netbox.Tag{Name: "job", Slug: "job"}
+
+	tests := []struct {
+		name         string
+		policy       string
+		jobCompleted bool
+		podPhase     corev1.PodPhase
+		expectedTags []v1beta1.Tag
+		expectedIP   bool
+	}{{
+		name:       "publish",
+		policy:     ctrl.JobPolicyPublish,
+		podPhase:   corev1.PodRunning,
+		expectedIP: true,
+	}, {
+		name:       "skip",
+		policy:     ctrl.JobPolicySkip,
+		podPhase:   corev1.PodRunning,
+		expectedIP: false,
+	}, {
+		name:         "tag",
+		policy:       ctrl.JobPolicyTag,
+		podPhase:     corev1.PodRunning,
+		expectedTags: []v1beta1.Tag{{Name: "job", Slug: "job"}},
+		expectedIP:   true,
+	}, {
+		name:         "tag with completed job",
+		policy:       ctrl.JobPolicyTag,
+		jobCompleted: true,
+		podPhase:     corev1.PodSucceeded,
+		expectedIP:   false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job := &batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "build",
+					Namespace: namespace,
+					UID:       types.UID("job123"),
+				},
+			}
+			if test.jobCompleted {
+				job.Status.Conditions = []batchv1.JobCondition{{
+					Type:   batchv1.JobComplete,
+					Status: corev1.ConditionTrue,
+				}}
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					UID:       types.UID(podUID),
+					Labels:    map[string]string{"app": "foo"},
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "batch/v1",
+						Kind:       "Job",
+						Name:       job.Name,
+						UID:        job.UID,
+						Controller: pointer.Bool(true),
+					}},
+				},
+				Status: corev1.PodStatus{
+					Phase: test.podPhase,
+					PodIP: "192.168.0.1",
+				},
+			}
+			ipKey := types.NamespacedName{Namespace: namespace, Name: fmt.Sprintf("pod-%s-ipv4", podUID)}
+			ip := &v1beta1.NetBoxIP{
+				ObjectMeta: metav1.ObjectMeta{Name: ipKey.Name, Namespace: ipKey.Namespace},
+				Spec: v1beta1.NetBoxIPSpec{
+					Address: netip.MustParseAddr("192.168.0.1"),
+					DNSName: name,
+				},
+			}
+
+			r := &reconciler{
+				kubeClient:   fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(job, pod, ip).Build(),
+				labels:       map[string]bool{"app": true},
+				log:          log.L(),
+				completedTTL: time.Hour,
+				jobPolicy:    test.policy,
+				jobTag:       &jobTag,
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+			res, err := r.Reconcile(context.Background(), req)
+			if err != nil {
+				t.Fatalf("reconciling: %q\n", err)
+			}
+			if res.RequeueAfter > 0 {
+				t.Errorf("want no requeue, got requeue after %s", res.RequeueAfter)
+			}
+
+			var actualIP v1beta1.NetBoxIP
+			err = r.kubeClient.Get(context.Background(), ipKey, &actualIP)
+			if !test.expectedIP {
+				if !kubeerrors.IsNotFound(err) {
+					t.Errorf("want NetBoxIP to not exist, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want NetBoxIP, got %q", err)
+			}
+			if diff := cmp.Diff(test.expectedTags, actualIP.Spec.Tags); diff != "" {
+				t.Errorf("tags (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/metrics"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Policies for events of controllers whose workqueue is full.
+const (
+	// QueueOverflowPark adds the events to the workqueue
+	// once the park delay of the QueueLimit has passed.
+	QueueOverflowPark = "park"
+	// QueueOverflowShed drops the events. Their objects are reconciled
+	// again on their next change, or the next periodic resync.
+	QueueOverflowShed = "shed"
+)
+
+// QueueLimit limits how many events may be waiting
+// in the workqueue of a controller.
+type QueueLimit struct {
+	MaxSize   int
+	Policy    string
+	ParkDelay time.Duration
+}
+
+// QueueTracker keeps track of the events waiting in the workqueue of
+// a controller, whose handlers and reconciler it wraps, so that the age
+// of the oldest one is exported, and applies the QueueLimit, if any.
+// Requeues of failed or periodic reconciliations are not events,
+// and are neither tracked nor limited.
+type QueueTracker struct {
+	name  string
+	limit *QueueLimit
+
+	mu sync.Mutex
+	// events waiting to be reconciled, by request
+	pending map[reconcile.Request]pendingEvent
+}
+
+type pendingEvent struct {
+	since  time.Time
+	parked bool
+}
+
+// NewQueueTracker returns a tracker of the workqueue of the controller
+// with the given name, whose age is exported in the
+// netbox_ip_controller_queue_oldest_seconds metric.
+func NewQueueTracker(name string, limit *QueueLimit) *QueueTracker {
+	t := &QueueTracker{
+		name:    name,
+		limit:   limit,
+		pending: make(map[reconcile.Request]pendingEvent),
+	}
+	metrics.SetQueueAgeFunc(name, t.OldestAge)
+	return t
+}
+
+// OldestAge returns how long the oldest event in the workqueue
+// has been waiting to be reconciled, or 0 if none is.
+func (t *QueueTracker) OldestAge() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var oldest time.Time
+	for _, e := range t.pending {
+		if oldest.IsZero() || e.since.Before(oldest) {
+			oldest = e.since
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest)
+}
+
+// Handler wraps the event handler so that the events it enqueues are tracked.
+func (t *QueueTracker) Handler(h handler.EventHandler) handler.EventHandler {
+	return &trackedHandler{handler: h, tracker: t}
+}
+
+// Reconciler wraps the reconciler so that requests are no
+// longer tracked once their reconciliation starts.
+func (t *QueueTracker) Reconciler(r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		t.mu.Lock()
+		delete(t.pending, req)
+		t.mu.Unlock()
+		return r.Reconcile(ctx, req)
+	})
+}
+
+// add adds the request to the queue, unless the queue is full,
+// in which case the request is parked or shed.
+func (t *QueueTracker) add(q workqueue.RateLimitingInterface, req reconcile.Request) {
+	t.mu.Lock()
+	e, tracked := t.pending[req]
+	// requests already in the queue do not make it any longer
+	full := t.limit != nil && t.limit.MaxSize > 0 && q.Len() >= t.limit.MaxSize && (!tracked || e.parked)
+	if full && e.parked {
+		// already added once the delay has passed
+		t.mu.Unlock()
+		return
+	}
+	if !full || t.limit.Policy == QueueOverflowPark {
+		if !tracked {
+			e.since = time.Now()
+		}
+		e.parked = full
+		t.pending[req] = e
+	}
+	t.mu.Unlock()
+
+	if !full {
+		q.Add(req)
+		return
+	}
+	metrics.IncrementQueueOverflows(t.name, t.limit.Policy)
+	if t.limit.Policy == QueueOverflowPark {
+		q.AddAfter(req, t.limit.ParkDelay)
+	}
+}
+
+// trackedHandler is an event handler whose
+// requests are added through its tracker.
+type trackedHandler struct {
+	handler handler.EventHandler
+	tracker *QueueTracker
+}
+
+func (h *trackedHandler) Create(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Create(ctx, e, &trackedQueue{RateLimitingInterface: q, tracker: h.tracker})
+}
+
+func (h *trackedHandler) Update(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Update(ctx, e, &trackedQueue{RateLimitingInterface: q, tracker: h.tracker})
+}
+
+func (h *trackedHandler) Delete(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Delete(ctx, e, &trackedQueue{RateLimitingInterface: q, tracker: h.tracker})
+}
+
+func (h *trackedHandler) Generic(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Generic(ctx, e, &trackedQueue{RateLimitingInterface: q, tracker: h.tracker})
+}
+
+// trackedQueue is the workqueue as seen by a trackedHandler.
+type trackedQueue struct {
+	workqueue.RateLimitingInterface
+	tracker *QueueTracker
+}
+
+func (q *trackedQueue) Add(item interface{}) {
+	req, ok := item.(reconcile.Request)
+	if !ok {
+		q.RateLimitingInterface.Add(item)
+		return
+	}
+	q.tracker.add(q.RateLimitingInterface, req)
+}
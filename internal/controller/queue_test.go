@@ -0,0 +1,101 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestQueueTracker(t *testing.T) {
+	pod := func(name string) event.CreateEvent {
+		return event.CreateEvent{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}}
+	}
+	request := func(name string) reconcile.Request {
+		return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: name}}
+	}
+
+	tests := []struct {
+		name          string
+		limit         *QueueLimit
+		expectedLen   int
+		expectedAfter int
+	}{{
+		name:        "unlimited",
+		expectedLen: 3,
+	}, {
+		name:        "shed",
+		limit:       &QueueLimit{MaxSize: 2, Policy: QueueOverflowShed},
+		expectedLen: 2,
+	}, {
+		name:          "park",
+		limit:         &QueueLimit{MaxSize: 2, Policy: QueueOverflowPark, ParkDelay: 10 * time.Millisecond},
+		expectedLen:   2,
+		expectedAfter: 3,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+			defer q.ShutDown()
+			tracker := NewQueueTracker("test-"+test.name, test.limit)
+			h := tracker.Handler(&handler.EnqueueRequestForObject{})
+
+			if age := tracker.OldestAge(); age != 0 {
+				t.Errorf("want age 0 of empty queue, got %s", age)
+			}
+
+			for _, name := range []string{"a", "b", "a", "c"} {
+				h.Create(context.Background(), pod(name), q)
+			}
+			if q.Len() != test.expectedLen {
+				t.Errorf("want %d queued requests, got %d", test.expectedLen, q.Len())
+			}
+			if tracker.OldestAge() <= 0 {
+				t.Error("want age of oldest request, got 0")
+			}
+
+			if test.expectedAfter > 0 {
+				time.Sleep(50 * time.Millisecond)
+				if q.Len() != test.expectedAfter {
+					t.Errorf("want %d queued requests after park delay, got %d", test.expectedAfter, q.Len())
+				}
+			}
+
+			r := tracker.Reconciler(reconcile.Func(func(context.Context, reconcile.Request) (reconcile.Result, error) {
+				return reconcile.Result{}, nil
+			}))
+			for _, name := range []string{"a", "b", "c"} {
+				if _, err := r.Reconcile(context.Background(), request(name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if age := tracker.OldestAge(); age != 0 {
+				t.Errorf("want age 0 after reconciling all requests, got %s", age)
+			}
+		})
+	}
+}
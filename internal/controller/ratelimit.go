@@ -0,0 +1,38 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/ratelimit"
+
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Budgeted wraps the reconciler so that its requests to NetBox
+// wait for the given rate limiter instead of the one of the client.
+// If the limiter is nil, the reconciler is returned as is.
+func Budgeted(limiter *rate.Limiter, r reconcile.Reconciler) reconcile.Reconciler {
+	if limiter == nil {
+		return r
+	}
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		return r.Reconcile(ratelimit.WithLimiter(ctx, limiter), req)
+	})
+}
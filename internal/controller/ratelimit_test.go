@@ -0,0 +1,61 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/ratelimit"
+
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestBudgeted(t *testing.T) {
+	shared := rate.NewLimiter(10, 1)
+	budget := rate.NewLimiter(5, 1)
+
+	tests := []struct {
+		name     string
+		limiter  *rate.Limiter
+		expected *rate.Limiter
+	}{{
+		name:     "without budget",
+		expected: shared,
+	}, {
+		name:     "with budget",
+		limiter:  budget,
+		expected: budget,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got *rate.Limiter
+			r := Budgeted(test.limiter, reconcile.Func(func(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+				got = ratelimit.Limiter(ctx, shared)
+				return reconcile.Result{}, nil
+			}))
+			if _, err := r.Reconcile(context.Background(), reconcile.Request{}); err != nil {
+				t.Fatal(err)
+			}
+			if got != test.expected {
+				t.Errorf("want limiter %p, got %p", test.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// allocatorClient is a client of an IPAM system that can allocate IPs.
+type allocatorClient interface {
+	netbox.Client
+	netbox.Allocator
+}
+
+// requestsLoadBalancerIP returns true if an IP is allocated
+// for the service from the load balancer IP pool.
+func (r *reconciler) requestsLoadBalancerIP(svc *corev1.Service) bool {
+	return r.lbIPAllocator != nil &&
+		svc.Spec.Type == corev1.ServiceTypeLoadBalancer &&
+		svc.Annotations[netboxctrl.AllocateLoadBalancerIPAnnotation] == "true"
+}
+
+// allocateLoadBalancerIP allocates an IP from the load balancer IP pool for
+// the service, if it requests one and has no address in the load balancer
+// IP annotation yet, and writes it to the annotation, so that the load
+// balancer implementation assigns it. The IP is created in NetBox with the
+// UID of the service, and taken over by the NetBoxIP of the address, see
+// takeOverAllocatedIP.
+func (r *reconciler) allocateLoadBalancerIP(ctx context.Context, ll *log.Logger, svc *corev1.Service, tenant string) error {
+	if !r.requestsLoadBalancerIP(svc) || strings.TrimSpace(svc.Annotations[r.lbIPAnnotation]) != "" {
+		return nil
+	}
+
+	// the IP may have been allocated before, but not
+	// written to the annotation, e.g. because that failed
+	uid := netbox.UID(svc.UID)
+	allocated, err := r.lbIPAllocator.GetIP(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("checking for allocated load balancer IP: %w", err)
+	}
+	if allocated != nil {
+		ll.Info("found load balancer IP allocated before", log.Int64("id", allocated.ID))
+	} else {
+		ip := &netbox.IPAddress{
+			UID:         uid,
+			Description: fmt.Sprintf("load balancer IP of service %s/%s", svc.Namespace, svc.Name),
+		}
+		if tenant != "" {
+			ip.Tenant = &netbox.Tenant{Slug: tenant}
+		}
+		allocated, err = r.lbIPAllocator.AllocateIP(ctx, r.lbIPPool, ip)
+		if err != nil {
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeError, ctrl.ReasonAllocationFailed)
+			return fmt.Errorf("allocating load balancer IP from %s: %w", r.lbIPPool, err)
+		}
+	}
+
+	addr := netip.Addr(allocated.Address)
+	patch := client.MergeFrom(svc.DeepCopy())
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	svc.Annotations[r.lbIPAnnotation] = addr.String()
+	if err := r.kubeClient.Patch(ctx, svc, patch); err != nil {
+		return fmt.Errorf("writing allocated load balancer IP: %w", err)
+	}
+
+	ll.Info("allocated load balancer IP", log.Stringer("ip", addr), log.String("annotation", r.lbIPAnnotation))
+	ctrl.RecordOutcome(ctx, ctrl.OutcomeCreated, ctrl.ReasonAllocated)
+	return nil
+}
+
+// takeOverAllocatedIP makes the NetBoxIP of addr take over the IP allocated
+// for the service, if addr is in the load balancer IP pool, so that the
+// allocated IP is published rather than duplicated.
+func (r *reconciler) takeOverAllocatedIP(ip *v1beta1.NetBoxIP, svc *corev1.Service, addr netip.Addr) {
+	if !r.requestsLoadBalancerIP(svc) || !r.lbIPPool.Contains(addr) {
+		return
+	}
+	if ip.Annotations == nil {
+		ip.Annotations = make(map[string]string)
+	}
+	ip.Annotations[netboxctrl.TakeOverAnnotation] = string(svc.UID)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestAllocateLoadBalancerIP(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	const annotation = "metallb.universe.tf/loadBalancerIPs"
+	// a valid UID, since the fake client allocates IPs with it
+	const uid = "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"
+
+	tests := []struct {
+		name             string
+		annotations      map[string]string
+		allocatedBefore  string
+		expectedAddr     string
+		expectedTakeOver bool
+	}{{
+		name:             "allocated",
+		annotations:      map[string]string{netboxctrl.AllocateLoadBalancerIPAnnotation: "true"},
+		expectedAddr:     "203.0.113.1",
+		expectedTakeOver: true,
+	}, {
+		name:             "allocated before",
+		annotations:      map[string]string{netboxctrl.AllocateLoadBalancerIPAnnotation: "true"},
+		allocatedBefore:  "203.0.113.7",
+		expectedAddr:     "203.0.113.7",
+		expectedTakeOver: true,
+	}, {
+		name: "address outside of the pool",
+		annotations: map[string]string{
+			netboxctrl.AllocateLoadBalancerIPAnnotation: "true",
+			annotation: "198.51.100.10",
+		},
+		expectedAddr: "198.51.100.10",
+	}, {
+		name:         "not requested",
+		annotations:  map[string]string{},
+		expectedAddr: "",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			svc := &corev1.Service{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "Service",
+					APIVersion: "v1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Namespace:   namespace,
+					UID:         uid,
+					Labels:      map[string]string{"app": "foo"},
+					Annotations: test.annotations,
+				},
+				Spec: corev1.ServiceSpec{
+					Type:      corev1.ServiceTypeLoadBalancer,
+					ClusterIP: "192.168.0.1",
+				},
+			}
+
+			ips := make(map[netbox.UID]netbox.IPAddress)
+			if test.allocatedBefore != "" {
+				ips[uid] = netbox.IPAddress{UID: uid, Address: netbox.IP(netip.MustParseAddr(test.allocatedBefore))}
+			}
+			netboxClient := netbox.NewFakeClient(nil, ips)
+
+			r := &reconciler{
+				kubeClient:     fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(svc).Build(),
+				labels:         map[string]bool{"app": true},
+				log:            log.NewNop(),
+				vipTag:         &netbox.Tag{Name: "vip", Slug: "vip"},
+				lbIPAllocator:  netboxClient.(allocatorClient),
+				lbIPPool:       netip.MustParsePrefix("203.0.113.0/24"),
+				lbIPAnnotation: annotation,
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+			if _, err := r.Reconcile(context.Background(), req); err != nil {
+				t.Fatalf("reconciling: %s", err)
+			}
+
+			if err := r.kubeClient.Get(context.Background(), req.NamespacedName, svc); err != nil {
+				t.Fatal(err)
+			}
+			if addr := svc.Annotations[annotation]; addr != test.expectedAddr {
+				t.Errorf("want %q in %s, got %q", test.expectedAddr, annotation, addr)
+			}
+			if test.expectedAddr == "" {
+				return
+			}
+
+			var ip v1beta1.NetBoxIP
+			ipName := types.NamespacedName{Namespace: namespace, Name: fmt.Sprintf("service-%s-vip-%s", uid, test.expectedAddr)}
+			if err := r.kubeClient.Get(context.Background(), ipName, &ip); err != nil {
+				t.Fatalf("retrieving VIP NetBoxIP: %s", err)
+			}
+			if takeOver := ip.Annotations[netboxctrl.TakeOverAnnotation] == uid; takeOver != test.expectedTakeOver {
+				t.Errorf("want allocated IP taken over: %t, got annotations %v", test.expectedTakeOver, ip.Annotations)
+			}
+		})
+	}
+}
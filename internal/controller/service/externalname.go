@@ -0,0 +1,102 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// externalNameSuffix prefixes the suffixes of the names
+// of NetBoxIPs of addresses of ExternalName targets.
+const externalNameSuffix = "ext-"
+
+// reconcileExternalNameIPs creates or updates the NetBoxIPs of the
+// addresses that the target of an ExternalName service resolves to, and
+// deletes the ones of addresses that it no longer resolves to. It returns
+// true if the target was resolved.
+func (r *reconciler) reconcileExternalNameIPs(ctx context.Context, ll *log.Logger, svc *corev1.Service, tenant string, settings ctrl.PublishSettings) (bool, error) {
+	if r.externalNameResolver == nil {
+		return false, nil
+	}
+
+	var resolved bool
+	desired := make(map[string]bool)
+	if svc.Spec.Type == corev1.ServiceTypeExternalName && svc.Spec.ExternalName != "" &&
+		ctrl.HasPublishLabels(settings.Labels, svc.Labels) && settings.PublishesNamespace(svc.Namespace) {
+		// on errors, existing NetBoxIPs are kept rather than deleted,
+		// since a failed lookup does not mean the addresses have changed
+		addrs, err := r.externalNameResolver.LookupNetIP(ctx, "ip", svc.Spec.ExternalName)
+		if err != nil {
+			return false, fmt.Errorf("resolving external name %s: %w", svc.Spec.ExternalName, err)
+		}
+		resolved = true
+
+		dnsName, err := r.dnsName(ctx, svc)
+		if err != nil {
+			return false, err
+		}
+
+		for _, addr := range addrs {
+			addr = addr.Unmap()
+			ips, err := ctrl.CreateNetBoxIPs([]string{addr.String()}, ctrl.NetBoxIPConfig{
+				Object:              svc,
+				DNSName:             dnsName,
+				ReconcilerTags:      settings.Tags,
+				TagCache:            r.tagCache,
+				ReconcilerLabels:    settings.Labels,
+				Tenant:              tenant,
+				ClusterTag:          r.clusterTag,
+				DescriptionStrategy: r.descriptionStrategy,
+				ExternalName:        svc.Spec.ExternalName,
+			})
+			if err != nil {
+				return false, err
+			}
+			ip := ips.IPv4
+			if ip == nil {
+				ip = ips.IPv6
+			}
+			ip.Name = externalNameIPName(svc, addr)
+
+			if err := ctrl.DeclareOwner(ip, svc); err != nil {
+				return false, fmt.Errorf("setting owner: %w", err)
+			}
+			if err := ctrl.UpsertNetBoxIP(ctx, r.kubeClient, ll, ip); err != nil {
+				return false, err
+			}
+			desired[ip.Name] = true
+		}
+	}
+
+	if err := r.deleteUndesiredIPs(ctx, ll, svc, externalNameSuffix, desired); err != nil {
+		return false, err
+	}
+	return resolved, nil
+}
+
+// externalNameIPName returns the name of the NetBoxIP of the given
+// address of the target of an ExternalName service.
+func externalNameIPName(svc *corev1.Service, addr netip.Addr) string {
+	return addressIPName(svc, externalNameSuffix, addr)
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// extIP describes a NetBoxIP of an address of an ExternalName target.
+type extIP struct {
+	Name        string
+	Address     string
+	DNSName     string
+	Description string
+}
+
+func TestReconcileExternalName(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	const target = "db.example.com"
+
+	externalNameService := func(svcType corev1.ServiceType) *corev1.Service {
+		return &corev1.Service{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Service",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				UID:       types.UID(serviceUID),
+				Labels:    map[string]string{"app": "foo"},
+			},
+			Spec: corev1.ServiceSpec{
+				Type:         svcType,
+				ExternalName: target,
+			},
+		}
+	}
+
+	extIPName := func(suffix string) string {
+		return fmt.Sprintf("service-%s-ext-%s", serviceUID, suffix)
+	}
+
+	tests := []struct {
+		name            string
+		service         *corev1.Service
+		existingIPs     []string
+		resolver        fakeResolver
+		expectedIPs     []extIP
+		expectedRequeue time.Duration
+		errorExpected   bool
+	}{{
+		name:    "resolved target",
+		service: externalNameService(corev1.ServiceTypeExternalName),
+		resolver: fakeResolver{target: {
+			netip.MustParseAddr("203.0.113.10"),
+			netip.MustParseAddr("2001:db8::1"),
+		}},
+		expectedIPs: []extIP{{
+			Name:        extIPName("2001-0db8-0000-0000-0000-0000-0000-0001"),
+			Address:     "2001:db8::1",
+			DNSName:     "foo.test.svc.testclusterdomain",
+			Description: "namespace: test, external name: db.example.com, app: foo",
+		}, {
+			Name:        extIPName("203.0.113.10"),
+			Address:     "203.0.113.10",
+			DNSName:     "foo.test.svc.testclusterdomain",
+			Description: "namespace: test, external name: db.example.com, app: foo",
+		}},
+		expectedRequeue: time.Minute,
+	}, {
+		name:        "changed address",
+		service:     externalNameService(corev1.ServiceTypeExternalName),
+		existingIPs: []string{"203.0.113.10"},
+		resolver:    fakeResolver{target: {netip.MustParseAddr("203.0.113.11")}},
+		expectedIPs: []extIP{{
+			Name:        extIPName("203.0.113.11"),
+			Address:     "203.0.113.11",
+			DNSName:     "foo.test.svc.testclusterdomain",
+			Description: "namespace: test, external name: db.example.com, app: foo",
+		}},
+		expectedRequeue: time.Minute,
+	}, {
+		name:          "failed resolution keeps existing IPs",
+		service:       externalNameService(corev1.ServiceTypeExternalName),
+		existingIPs:   []string{"203.0.113.10"},
+		resolver:      fakeResolver{},
+		expectedIPs:   []extIP{{Name: extIPName("203.0.113.10"), Address: "203.0.113.10"}},
+		errorExpected: true,
+	}, {
+		name:        "no longer an ExternalName service",
+		service:     externalNameService(corev1.ServiceTypeClusterIP),
+		existingIPs: []string{"203.0.113.10"},
+		resolver:    fakeResolver{},
+		expectedIPs: nil,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objs := []client.Object{test.service}
+			for _, addr := range test.existingIPs {
+				objs = append(objs, &v1beta1.NetBoxIP{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      extIPName(addr),
+						Namespace: namespace,
+						Labels:    map[string]string{netboxctrl.NameLabel: name},
+					},
+					Spec: v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr(addr)},
+				})
+			}
+
+			r := &reconciler{
+				kubeClient:           fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+				clusterDomain:        "testclusterdomain",
+				tags:                 []netbox.Tag{{Name: "bar", Slug: "bar"}},
+				labels:               map[string]bool{"app": true},
+				log:                  log.L(),
+				externalNameResolver: test.resolver,
+				externalNameRefresh:  time.Minute,
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+			res, err := r.Reconcile(context.Background(), req)
+			if test.errorExpected && err == nil {
+				t.Error("expected error but got nil")
+			} else if !test.errorExpected && err != nil {
+				t.Errorf("reconciling: %q", err)
+			}
+
+			// requeue intervals are jittered by up to 10%
+			if res.RequeueAfter < test.expectedRequeue || res.RequeueAfter > test.expectedRequeue+test.expectedRequeue/10 {
+				t.Errorf("want requeue after about %s, got %s", test.expectedRequeue, res.RequeueAfter)
+			}
+
+			var ips v1beta1.NetBoxIPList
+			if err := r.kubeClient.List(context.Background(), &ips, client.InNamespace(namespace)); err != nil {
+				t.Fatalf("listing NetBoxIPs: %q", err)
+			}
+			var actual []extIP
+			for _, ip := range ips.Items {
+				if strings.Contains(ip.Name, "-ext-") {
+					actual = append(actual, extIP{
+						Name:        ip.Name,
+						Address:     ip.Spec.Address.String(),
+						DNSName:     ip.Spec.DNSName,
+						Description: ip.Spec.Description,
+					})
+				}
+			}
+			sort.Slice(actual, func(i, j int) bool { return actual[i].Name < actual[j].Name })
+
+			if diff := cmp.Diff(test.expectedIPs, actual); diff != "" {
+				t.Errorf("ExternalName NetBoxIPs (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// loadBalancerSuffix prefixes the suffixes of the names
+// of NetBoxIPs of load balancer ingress addresses.
+const loadBalancerSuffix = "lb-"
+
+// loadBalancerIPName returns the name of the NetBoxIP of the given
+// load balancer ingress address of the service.
+func loadBalancerIPName(svc *corev1.Service, addr netip.Addr) string {
+	return addressIPName(svc, loadBalancerSuffix, addr)
+}
+
+// addressIPName returns the name of the NetBoxIP of the service
+// with the given suffix prefix and address.
+func addressIPName(svc *corev1.Service, suffix string, addr netip.Addr) string {
+	// colons are not allowed in names, and the expanded form of
+	// IPv6 addresses never ends with the dash replacing them
+	return ctrl.NetBoxIPName(svc, suffix+strings.ReplaceAll(addr.StringExpanded(), ":", "-"))
+}
+
+// loadBalancerAddresses returns the addresses of the load balancer ingress
+// points of the service, mapped to their hostnames, if any. Ingress points
+// that only have a hostname are resolved if the reconciler has a resolver,
+// in which case resolved is true.
+func (r *reconciler) loadBalancerAddresses(ctx context.Context, ll *log.Logger, svc *corev1.Service) (addrs map[netip.Addr]string, resolved bool, err error) {
+	addrs = make(map[netip.Addr]string)
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			addr, err := netip.ParseAddr(ingress.IP)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid load balancer IP address: %w", err)
+			}
+			addrs[addr] = ingress.Hostname
+			continue
+		}
+
+		if ingress.Hostname == "" {
+			continue
+		}
+		if r.resolver == nil {
+			ll.Debug("skipping load balancer hostname", log.String("hostname", ingress.Hostname))
+			continue
+		}
+
+		resolvedAddrs, err := r.resolver.LookupNetIP(ctx, "ip", ingress.Hostname)
+		if err != nil {
+			return nil, false, fmt.Errorf("resolving load balancer hostname %s: %w", ingress.Hostname, err)
+		}
+		for _, addr := range resolvedAddrs {
+			addrs[addr.Unmap()] = ingress.Hostname
+		}
+		resolved = true
+	}
+	return addrs, resolved, nil
+}
+
+// natInside returns the name of the NetBoxIP of the published cluster IP
+// of the service in the IP family of the load balancer address addr,
+// which is its NAT inside address, or an empty string if NAT links are
+// not enabled, or the service has no such cluster IP.
+func (r *reconciler) natInside(svc *corev1.Service, addr netip.Addr) string {
+	if !r.loadBalancerNAT {
+		return ""
+	}
+	svcIPs, err := clusterIPs(svc, r.skipIPv4, r.skipIPv6)
+	if err != nil {
+		// the cluster IPs are not published either
+		return ""
+	}
+	for _, ip := range svcIPs {
+		if clusterIP, err := netip.ParseAddr(ip); err == nil && ctrl.Scheme(clusterIP) == ctrl.Scheme(addr) {
+			return ctrl.NetBoxIPName(svc, ctrl.Scheme(clusterIP))
+		}
+	}
+	return ""
+}
+
+// reconcileLoadBalancerIPs creates or updates the NetBoxIPs of the load
+// balancer ingress addresses of the service, and deletes the ones of
+// addresses that the service no longer has. It returns true if any
+// addresses were resolved from hostnames.
+func (r *reconciler) reconcileLoadBalancerIPs(ctx context.Context, ll *log.Logger, svc *corev1.Service, tenant string, settings ctrl.PublishSettings) (bool, error) {
+	if !r.loadBalancerIPs {
+		return false, nil
+	}
+
+	var resolved bool
+	desired := make(map[string]bool)
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer &&
+		ctrl.HasPublishLabels(settings.Labels, svc.Labels) && settings.PublishesNamespace(svc.Namespace) {
+		var addrs map[netip.Addr]string
+		var err error
+		// on errors, existing NetBoxIPs are kept rather than deleted,
+		// since a failed lookup does not mean the addresses have changed
+		if addrs, resolved, err = r.loadBalancerAddresses(ctx, ll, svc); err != nil {
+			return false, err
+		}
+
+		for addr, hostname := range addrs {
+			if r.omitDNSName {
+				hostname = ""
+			}
+			ips, err := ctrl.CreateNetBoxIPs([]string{addr.String()}, ctrl.NetBoxIPConfig{
+				Object:              svc,
+				DNSName:             hostname,
+				ReconcilerTags:      withTags(settings.Tags, r.loadBalancerTags...),
+				TagCache:            r.tagCache,
+				ReconcilerLabels:    settings.Labels,
+				Tenant:              tenant,
+				CustomFields:        r.customFields(svc),
+				ClusterTag:          r.clusterTag,
+				DescriptionStrategy: r.descriptionStrategy,
+			})
+			if err != nil {
+				return false, err
+			}
+			ip := ips.IPv4
+			if ip == nil {
+				ip = ips.IPv6
+			}
+			ip.Name = loadBalancerIPName(svc, addr)
+			ip.Spec.NATInside = r.natInside(svc, addr)
+
+			if err := ctrl.DeclareOwner(ip, svc); err != nil {
+				return false, fmt.Errorf("setting owner: %w", err)
+			}
+			if err := ctrl.UpsertNetBoxIP(ctx, r.kubeClient, ll, ip); err != nil {
+				return false, err
+			}
+			desired[ip.Name] = true
+		}
+	}
+
+	if err := r.deleteUndesiredIPs(ctx, ll, svc, loadBalancerSuffix, desired); err != nil {
+		return false, err
+	}
+	return resolved, nil
+}
+
+// deleteUndesiredIPs deletes the NetBoxIPs of the service whose names
+// start with the name of the NetBoxIP with the given suffix, but which
+// are not desired.
+func (r *reconciler) deleteUndesiredIPs(ctx context.Context, ll *log.Logger, svc *corev1.Service, suffix string, desired map[string]bool) error {
+	var existing v1beta1.NetBoxIPList
+	if err := r.kubeClient.List(ctx, &existing, client.InNamespace(svc.Namespace), client.MatchingLabels{netboxctrl.NameLabel: svc.Name}); err != nil {
+		return fmt.Errorf("listing netboxips: %w", err)
+	}
+	prefix := ctrl.NetBoxIPName(svc, suffix)
+	for i := range existing.Items {
+		ip := &existing.Items[i]
+		if !strings.HasPrefix(ip.Name, prefix) || desired[ip.Name] || ctrl.IsManual(ip) {
+			continue
+		}
+		if err := r.kubeClient.Delete(ctx, ip); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("deleting netboxip: %w", err)
+		}
+		ll.Info("deleted netboxip", log.String("netboxip", ip.Name))
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeDeleted, ctrl.ReasonStale)
+	}
+	return nil
+}
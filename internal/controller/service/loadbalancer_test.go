@@ -0,0 +1,350 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+type fakeResolver map[string][]netip.Addr
+
+func (f fakeResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	addrs, ok := f[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return addrs, nil
+}
+
+// lbIP describes a NetBoxIP of a load balancer address.
+type lbIP struct {
+	Name    string
+	Address string
+	DNSName string
+}
+
+func TestReconcileLoadBalancer(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	lbService := func(ingress ...corev1.LoadBalancerIngress) *corev1.Service {
+		return &corev1.Service{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Service",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				UID:       types.UID(serviceUID),
+				Labels:    map[string]string{"app": "foo"},
+			},
+			Spec: corev1.ServiceSpec{
+				Ports:     []corev1.ServicePort{{Port: 8080}},
+				Type:      corev1.ServiceTypeLoadBalancer,
+				ClusterIP: "192.168.0.1",
+			},
+			Status: corev1.ServiceStatus{
+				LoadBalancer: corev1.LoadBalancerStatus{Ingress: ingress},
+			},
+		}
+	}
+
+	lbIPName := func(suffix string) string {
+		return fmt.Sprintf("service-%s-lb-%s", serviceUID, suffix)
+	}
+
+	const hostname = "abc.elb.amazonaws.com"
+
+	tests := []struct {
+		name            string
+		service         *corev1.Service
+		existingIPs     []string
+		resolver        fakeResolver
+		expectedIPs     []lbIP
+		expectedRequeue time.Duration
+		errorExpected   bool
+	}{{
+		name:    "with ingress IP",
+		service: lbService(corev1.LoadBalancerIngress{IP: "203.0.113.10"}),
+		expectedIPs: []lbIP{{
+			Name:    lbIPName("203.0.113.10"),
+			Address: "203.0.113.10",
+		}},
+	}, {
+		name:    "with IPv6 ingress IP",
+		service: lbService(corev1.LoadBalancerIngress{IP: "2001:db8::1"}),
+		expectedIPs: []lbIP{{
+			Name:    lbIPName("2001-0db8-0000-0000-0000-0000-0000-0001"),
+			Address: "2001:db8::1",
+		}},
+	}, {
+		name:        "hostname without resolver",
+		service:     lbService(corev1.LoadBalancerIngress{Hostname: hostname}),
+		expectedIPs: nil,
+	}, {
+		name:    "hostname with resolver",
+		service: lbService(corev1.LoadBalancerIngress{Hostname: hostname}),
+		resolver: fakeResolver{hostname: {
+			netip.MustParseAddr("203.0.113.10"),
+			netip.MustParseAddr("::ffff:203.0.113.11"),
+		}},
+		expectedIPs: []lbIP{{
+			Name:    lbIPName("203.0.113.10"),
+			Address: "203.0.113.10",
+			DNSName: hostname,
+		}, {
+			Name:    lbIPName("203.0.113.11"),
+			Address: "203.0.113.11",
+			DNSName: hostname,
+		}},
+		expectedRequeue: time.Minute,
+	}, {
+		name:        "changed resolved address",
+		service:     lbService(corev1.LoadBalancerIngress{Hostname: hostname}),
+		existingIPs: []string{"203.0.113.10"},
+		resolver:    fakeResolver{hostname: {netip.MustParseAddr("203.0.113.12")}},
+		expectedIPs: []lbIP{{
+			Name:    lbIPName("203.0.113.12"),
+			Address: "203.0.113.12",
+			DNSName: hostname,
+		}},
+		expectedRequeue: time.Minute,
+	}, {
+		name:          "failed resolution keeps existing IPs",
+		service:       lbService(corev1.LoadBalancerIngress{Hostname: hostname}),
+		existingIPs:   []string{"203.0.113.10"},
+		resolver:      fakeResolver{},
+		expectedIPs:   []lbIP{{Name: lbIPName("203.0.113.10"), Address: "203.0.113.10"}},
+		errorExpected: true,
+	}, {
+		name: "no longer a load balancer",
+		service: func() *corev1.Service {
+			svc := lbService()
+			svc.Spec.Type = corev1.ServiceTypeClusterIP
+			return svc
+		}(),
+		existingIPs: []string{"203.0.113.10"},
+		expectedIPs: nil,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objs := []client.Object{test.service}
+			for _, addr := range test.existingIPs {
+				objs = append(objs, &v1beta1.NetBoxIP{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      lbIPName(addr),
+						Namespace: namespace,
+						Labels:    map[string]string{netboxctrl.NameLabel: name},
+					},
+					Spec: v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr(addr)},
+				})
+			}
+
+			r := &reconciler{
+				kubeClient:      fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+				clusterDomain:   "testclusterdomain",
+				tags:            []netbox.Tag{{Name: "bar", Slug: "bar"}},
+				labels:          map[string]bool{"app": true},
+				log:             log.L(),
+				loadBalancerIPs: true,
+				hostnameRefresh: time.Minute,
+			}
+			if test.resolver != nil {
+				r.resolver = test.resolver
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+			res, err := r.Reconcile(context.Background(), req)
+			if test.errorExpected && err == nil {
+				t.Error("expected error but got nil")
+			} else if !test.errorExpected && err != nil {
+				t.Errorf("reconciling: %q", err)
+			}
+
+			// requeue intervals are jittered by up to 10%
+			if res.RequeueAfter < test.expectedRequeue || res.RequeueAfter > test.expectedRequeue+test.expectedRequeue/10 {
+				t.Errorf("want requeue after about %s, got %s", test.expectedRequeue, res.RequeueAfter)
+			}
+
+			var ips v1beta1.NetBoxIPList
+			if err := r.kubeClient.List(context.Background(), &ips, client.InNamespace(namespace)); err != nil {
+				t.Fatalf("listing NetBoxIPs: %q", err)
+			}
+			var actual []lbIP
+			for _, ip := range ips.Items {
+				if strings.Contains(ip.Name, "-lb-") {
+					actual = append(actual, lbIP{Name: ip.Name, Address: ip.Spec.Address.String(), DNSName: ip.Spec.DNSName})
+				}
+			}
+			sort.Slice(actual, func(i, j int) bool { return actual[i].Name < actual[j].Name })
+
+			if diff := cmp.Diff(test.expectedIPs, actual); diff != "" {
+				t.Errorf("load balancer NetBoxIPs (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReconcileIPKindTags(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	svc := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID(serviceUID),
+			Labels:    map[string]string{"app": "foo"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:      corev1.ServiceTypeLoadBalancer,
+			ClusterIP: "192.168.0.1",
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}},
+			},
+		},
+	}
+
+	r := &reconciler{
+		kubeClient:       fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(svc).Build(),
+		clusterDomain:    "testclusterdomain",
+		tags:             []netbox.Tag{{Name: "bar", Slug: "bar"}},
+		labels:           map[string]bool{"app": true},
+		log:              log.L(),
+		loadBalancerIPs:  true,
+		clusterIPTags:    []netbox.Tag{{Name: "k8s-clusterip", Slug: "k8s-clusterip"}},
+		loadBalancerTags: []netbox.Tag{{Name: "k8s-lb-vip", Slug: "k8s-lb-vip"}},
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q", err)
+	}
+
+	expectedTags := map[string][]v1beta1.Tag{
+		fmt.Sprintf("service-%s-ipv4", serviceUID):            {{Name: "bar", Slug: "bar"}, {Name: "k8s-clusterip", Slug: "k8s-clusterip"}},
+		fmt.Sprintf("service-%s-lb-203.0.113.10", serviceUID): {{Name: "bar", Slug: "bar"}, {Name: "k8s-lb-vip", Slug: "k8s-lb-vip"}},
+	}
+
+	var ips v1beta1.NetBoxIPList
+	if err := r.kubeClient.List(context.Background(), &ips, client.InNamespace(namespace)); err != nil {
+		t.Fatalf("listing NetBoxIPs: %q", err)
+	}
+	actualTags := make(map[string][]v1beta1.Tag)
+	for _, ip := range ips.Items {
+		actualTags[ip.Name] = ip.Spec.Tags
+	}
+
+	if diff := cmp.Diff(expectedTags, actualTags); diff != "" {
+		t.Errorf("tags of NetBoxIPs (-want, +got)\n%s", diff)
+	}
+}
+
+func TestReconcileLoadBalancerNAT(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	svc := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID(serviceUID),
+			Labels:    map[string]string{"app": "foo"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:      corev1.ServiceTypeLoadBalancer,
+			ClusterIP: "192.168.0.1",
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}, {IP: "2001:db8::1"}},
+			},
+		},
+	}
+
+	r := &reconciler{
+		kubeClient:      fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(svc).Build(),
+		clusterDomain:   "testclusterdomain",
+		labels:          map[string]bool{"app": true},
+		log:             log.L(),
+		loadBalancerIPs: true,
+		loadBalancerNAT: true,
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q", err)
+	}
+
+	clusterIPName := fmt.Sprintf("service-%s-ipv4", serviceUID)
+	expectedNATInside := map[string]string{
+		clusterIPName: "",
+		fmt.Sprintf("service-%s-lb-203.0.113.10", serviceUID): clusterIPName,
+		// the service has no IPv6 cluster IP
+		fmt.Sprintf("service-%s-lb-2001-0db8-0000-0000-0000-0000-0000-0001", serviceUID): "",
+	}
+
+	var ips v1beta1.NetBoxIPList
+	if err := r.kubeClient.List(context.Background(), &ips, client.InNamespace(namespace)); err != nil {
+		t.Fatalf("listing NetBoxIPs: %q", err)
+	}
+	actualNATInside := make(map[string]string)
+	for _, ip := range ips.Items {
+		actualNATInside[ip.Name] = ip.Spec.NATInside
+	}
+
+	if diff := cmp.Diff(expectedNATInside, actualNATInside); diff != "" {
+		t.Errorf("NAT inside NetBoxIPs (-want, +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// nodePortProtocols are the protocols of service ports,
+// each of which is published as a separate NetBox service.
+var nodePortProtocols = []corev1.Protocol{corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP}
+
+// nodePortServiceName returns the name of the NetBox services
+// of the node ports of the service with the given protocol.
+func (r *reconciler) nodePortServiceName(key types.NamespacedName, protocol corev1.Protocol) string {
+	name := fmt.Sprintf("%s.%s/%s", key.Name, key.Namespace, strings.ToLower(string(protocol)))
+	if r.clusterTag != "" {
+		// services of different clusters may have the same name
+		name = r.clusterTag + ":" + name
+	}
+	return name
+}
+
+// reconcileNodePortServices publishes the node ports of the service as
+// NetBox services on the IPs of the nodes, one for each protocol, and
+// deletes the NetBox services of protocols without node ports. If svc
+// is nil, i.e. the service was deleted, all its NetBox services are deleted.
+func (r *reconciler) reconcileNodePortServices(ctx context.Context, ll *log.Logger, key types.NamespacedName, svc *corev1.Service, settings ctrl.PublishSettings) error {
+	if r.nodePortServices == nil {
+		return nil
+	}
+
+	ports := make(map[corev1.Protocol][]int32)
+	var addrs []netip.Addr
+	if svc != nil && ctrl.HasPublishLabels(settings.Labels, svc.Labels) && settings.PublishesNamespace(svc.Namespace) {
+		for _, port := range svc.Spec.Ports {
+			if port.NodePort != 0 {
+				ports[port.Protocol] = append(ports[port.Protocol], port.NodePort)
+			}
+		}
+		if len(ports) > 0 {
+			var err error
+			if addrs, err = r.nodeAddresses(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, protocol := range nodePortProtocols {
+		name := r.nodePortServiceName(key, protocol)
+		if len(ports[protocol]) == 0 {
+			if err := r.nodePortServices.DeleteNodePortService(ctx, name); err != nil {
+				return fmt.Errorf("deleting node port service %s: %w", name, err)
+			}
+			continue
+		}
+
+		err := r.nodePortServices.UpsertNodePortService(ctx, &netbox.NodePortService{
+			Name:        name,
+			Protocol:    strings.ToLower(string(protocol)),
+			Ports:       ports[protocol],
+			Description: fmt.Sprintf("node ports of service %s/%s", key.Namespace, key.Name),
+			Tags:        settings.Tags,
+			Addresses:   addrs,
+		})
+		if err != nil {
+			return fmt.Errorf("upserting node port service %s: %w", name, err)
+		}
+		ll.Debug("published node ports", log.String("service", name), log.Int32s("ports", ports[protocol]))
+	}
+	return nil
+}
+
+// nodeAddresses returns the internal and external addresses of all nodes.
+func (r *reconciler) nodeAddresses(ctx context.Context) ([]netip.Addr, error) {
+	var nodes corev1.NodeList
+	if err := r.kubeClient.List(ctx, &nodes); err != nil {
+		return nil, fmt.Errorf("listing nodes: %w", err)
+	}
+
+	var addrs []netip.Addr
+	for _, node := range nodes.Items {
+		for _, nodeAddr := range node.Status.Addresses {
+			if nodeAddr.Type != corev1.NodeInternalIP && nodeAddr.Type != corev1.NodeExternalIP {
+				continue
+			}
+			addr, err := netip.ParseAddr(nodeAddr.Address)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address of node %s: %w", node.Name, err)
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fakeServiceClient holds node port services by name.
+type fakeServiceClient map[string]netbox.NodePortService
+
+func (f fakeServiceClient) UpsertNodePortService(_ context.Context, svc *netbox.NodePortService) error {
+	f[svc.Name] = *svc
+	return nil
+}
+
+func (f fakeServiceClient) DeleteNodePortService(_ context.Context, name string) error {
+	delete(f, name)
+	return nil
+}
+
+func TestReconcileNodePortServices(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+				{Type: corev1.NodeHostName, Address: "node-1"},
+			},
+		},
+	}
+	nodeAddrs := []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("203.0.113.1")}
+
+	nodePortService := func(svcType corev1.ServiceType, ports ...corev1.ServicePort) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				UID:       types.UID(serviceUID),
+				Labels:    map[string]string{"app": "foo"},
+			},
+			Spec: corev1.ServiceSpec{
+				Type:      svcType,
+				ClusterIP: "192.168.0.1",
+				Ports:     ports,
+			},
+		}
+	}
+
+	tests := []struct {
+		name             string
+		service          *corev1.Service
+		existingServices fakeServiceClient
+		expectedServices fakeServiceClient
+	}{{
+		name: "NodePort service",
+		service: nodePortService(corev1.ServiceTypeNodePort,
+			corev1.ServicePort{Protocol: corev1.ProtocolTCP, Port: 80, NodePort: 30080},
+			corev1.ServicePort{Protocol: corev1.ProtocolTCP, Port: 443, NodePort: 30443},
+			corev1.ServicePort{Protocol: corev1.ProtocolUDP, Port: 53, NodePort: 30053},
+		),
+		existingServices: fakeServiceClient{},
+		expectedServices: fakeServiceClient{
+			"foo.test/tcp": {
+				Name:        "foo.test/tcp",
+				Protocol:    "tcp",
+				Ports:       []int32{30080, 30443},
+				Description: "node ports of service test/foo",
+				Tags:        []netbox.Tag{{Name: "bar", Slug: "bar"}},
+				Addresses:   nodeAddrs,
+			},
+			"foo.test/udp": {
+				Name:        "foo.test/udp",
+				Protocol:    "udp",
+				Ports:       []int32{30053},
+				Description: "node ports of service test/foo",
+				Tags:        []netbox.Tag{{Name: "bar", Slug: "bar"}},
+				Addresses:   nodeAddrs,
+			},
+		},
+	}, {
+		name:             "no longer a NodePort service",
+		service:          nodePortService(corev1.ServiceTypeClusterIP, corev1.ServicePort{Protocol: corev1.ProtocolTCP, Port: 80}),
+		existingServices: fakeServiceClient{"foo.test/tcp": {Name: "foo.test/tcp"}},
+		expectedServices: fakeServiceClient{},
+	}, {
+		name:             "deleted service",
+		existingServices: fakeServiceClient{"foo.test/udp": {Name: "foo.test/udp"}},
+		expectedServices: fakeServiceClient{},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objs := []client.Object{node}
+			if test.service != nil {
+				objs = append(objs, test.service)
+			}
+
+			r := &reconciler{
+				kubeClient:       fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+				clusterDomain:    "testclusterdomain",
+				tags:             []netbox.Tag{{Name: "bar", Slug: "bar"}},
+				labels:           map[string]bool{"app": true},
+				log:              log.L(),
+				nodePortServices: test.existingServices,
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+			if _, err := r.Reconcile(context.Background(), req); err != nil {
+				t.Fatalf("reconciling: %q", err)
+			}
+
+			if diff := cmp.Diff(test.expectedServices, test.existingServices, cmp.Comparer(addrComparer)); diff != "" {
+				t.Errorf("node port services (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
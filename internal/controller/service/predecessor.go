@@ -0,0 +1,61 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// deletePredecessorIPs deletes the NetBoxIPs of services that had the same
+// name as the given one, but a different UID. Such services have been
+// deleted, and their NetBoxIPs would normally be garbage collected, but
+// they may linger if the service is recreated before that happens, or
+// if the deletion is missed, and keep their addresses in NetBox.
+func (r *reconciler) deletePredecessorIPs(ctx context.Context, ll *log.Logger, svc *corev1.Service) error {
+	var existing v1beta1.NetBoxIPList
+	if err := r.kubeClient.List(ctx, &existing, client.InNamespace(svc.Namespace), client.MatchingLabels{netboxctrl.NameLabel: svc.Name}); err != nil {
+		return fmt.Errorf("listing netboxips: %w", err)
+	}
+	for i := range existing.Items {
+		ip := &existing.Items[i]
+		// pods with the same name as the service have
+		// NetBoxIPs with the same name label
+		owner := metav1.GetControllerOf(ip)
+		if owner == nil || ctrl.IsManual(ip) || owner.Kind != "Service" || owner.UID == svc.UID {
+			continue
+		}
+		if err := r.kubeClient.Delete(ctx, ip); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("deleting netboxip of predecessor: %w", err)
+		}
+		ll.Info("deleted netboxip of predecessor",
+			log.String("netboxip", ip.Name),
+			log.String("predecessor", string(owner.UID)),
+		)
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeDeleted, ctrl.ReasonPredecessor)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"testing"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileDeletesPredecessorIPs(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	svc := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID(serviceUID),
+			Labels:    map[string]string{"app": "foo"},
+		},
+		Spec: corev1.ServiceSpec{ClusterIP: "192.168.0.2"},
+	}
+
+	ownedIP := func(ipName, kind, uid, addr string) *v1beta1.NetBoxIP {
+		return &v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ipName,
+				Namespace: namespace,
+				Labels:    map[string]string{netboxctrl.NameLabel: name},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "v1",
+					Kind:       kind,
+					Name:       name,
+					UID:        types.UID(uid),
+					Controller: pointer.Bool(true),
+				}},
+			},
+			Spec: v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr(addr)},
+		}
+	}
+
+	manualIP := ownedIP("service-manual", "Service", "old123", "192.168.0.3")
+	manualIP.Labels[netboxctrl.ManualLabel] = "true"
+
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(
+		svc,
+		// created by hand, with the name label of the service
+		manualIP,
+		// of a deleted service with the same name
+		ownedIP("service-old123-ipv4", "Service", "old123", "192.168.0.1"),
+		// of a pod with the same name
+		ownedIP("pod-pod123", "Pod", "pod123", "10.0.0.1"),
+	).Build()
+
+	r := &reconciler{
+		kubeClient:    kubeClient,
+		clusterDomain: "testclusterdomain",
+		tags:          []netbox.Tag{{Name: "bar", Slug: "bar"}},
+		labels:        map[string]bool{"app": true},
+		log:           log.L(),
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconciling: %q", err)
+	}
+
+	var ips v1beta1.NetBoxIPList
+	if err := kubeClient.List(context.Background(), &ips, client.InNamespace(namespace)); err != nil {
+		t.Fatalf("listing NetBoxIPs: %q", err)
+	}
+	var actual []string
+	for _, ip := range ips.Items {
+		actual = append(actual, ip.Name)
+	}
+	sort.Strings(actual)
+
+	expected := []string{"pod-pod123", "service-abc123-ipv4", "service-manual"}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("NetBoxIPs (-want, +got)\n%s", diff)
+	}
+}
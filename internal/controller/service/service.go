@@ -20,6 +20,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/netip"
+	"strings"
+	"time"
 
 	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
 	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
@@ -27,16 +30,30 @@ import (
 	"github.com/hashicorp/go-multierror"
 
 	log "go.uber.org/zap"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
+// ControllerName is the name of the service controller, which
+// names its workqueue and its rate budget of requests to NetBox.
+const ControllerName = "service"
+
 type controller struct {
 	reconciler *reconciler
+	// shard of namespaces to reconcile objects in, if sharded
+	shard *ctrl.Shard
+	// limit of the workqueue, if any
+	queueLimit *ctrl.QueueLimit
+	// rate limiter of requests to NetBox, if not shared
+	rateBudget *rate.Limiter
 }
 
 // New returns a new Controller for services.
@@ -57,26 +74,77 @@ func New(opts ...ctrl.Option) (ctrl.Controller, error) {
 		logger = s.Logger
 	}
 
+	var lbIPAllocator allocatorClient
+	if s.LoadBalancerIPAllocator != nil {
+		allocator, ok := s.LoadBalancerIPAllocator.(allocatorClient)
+		if !ok {
+			return nil, errors.New("the IPAM backend does not support allocating IPs from prefixes")
+		}
+		lbIPAllocator = allocator
+	}
+
 	return &controller{
+		shard:      s.Shard,
+		queueLimit: s.QueueLimit,
+		rateBudget: s.RateBudgets[ControllerName],
 		reconciler: &reconciler{
-			kubeClient:    s.KubeClient,
-			tags:          s.Tags,
-			labels:        s.Labels,
-			clusterDomain: s.ClusterDomain,
-			log:           logger.With(log.String("reconciler", "service")),
-			dualStackIP:   s.DualStackIP,
+			kubeClient:           s.KubeClient,
+			tags:                 s.Tags,
+			tagCache:             s.TagCache,
+			labels:               s.Labels,
+			clusterDomain:        s.ClusterDomain,
+			log:                  logger.With(log.String("reconciler", ControllerName)),
+			skipIPv4:             s.SkipIPv4,
+			skipIPv6:             s.SkipIPv6,
+			tenants:              s.TenantMapping,
+			clusterTag:           s.ClusterTag,
+			settings:             s.LiveSettings,
+			descriptionStrategy:  s.DescriptionStrategy,
+			omitDNSName:          s.OmitDNSName,
+			requeueAfter:         s.RequeueInterval,
+			slowThreshold:        s.SlowReconcileThreshold,
+			loadBalancerIPs:      s.LoadBalancerIPs,
+			loadBalancerNAT:      s.LoadBalancerNAT,
+			resolver:             s.LoadBalancerResolver,
+			hostnameRefresh:      s.LoadBalancerRefresh,
+			nodePortServices:     s.NodePortServices,
+			externalNameResolver: s.ExternalNameResolver,
+			externalNameRefresh:  s.ExternalNameRefresh,
+			namespaceDomains:     s.NamespaceClusterDomains,
+			vipTag:               s.VIPTag,
+			lbIPAllocator:        lbIPAllocator,
+			lbIPPool:             s.LoadBalancerIPPool,
+			lbIPAnnotation:       s.LoadBalancerIPAnnotation,
+			clusterIPTags:        s.ClusterIPTags,
+			loadBalancerTags:     s.LoadBalancerIPTags,
+			selectorField:        s.SelectorCustomField,
+			portsField:           s.PortsCustomField,
 		},
 	}, nil
 }
 
 // AddToManager attaches the controller to the given manager.
 func (c *controller) AddToManager(mgr manager.Manager) error {
-	return builder.
+	q := ctrl.NewQueueTracker(ControllerName, c.queueLimit)
+	b := builder.
 		ControllerManagedBy(mgr).
-		Named("service").
-		For(&corev1.Service{}).
-		WithEventFilter(ctrl.OnCreateAndUpdateFilter).
-		Complete(c.reconciler)
+		Named(ControllerName).
+		Watches(&corev1.Service{}, q.Handler(&handler.EnqueueRequestForObject{}))
+	if c.reconciler.nodePortServices == nil {
+		// NetBoxIPs are deleted with their owners, but
+		// node port services have to be deleted explicitly
+		b = b.WithEventFilter(ctrl.OnCreateAndUpdateFilter)
+	}
+
+	if c.reconciler.settings != nil {
+		// re-reconcile all services whenever the settings change
+		b = b.WatchesRawSource(
+			&source.Channel{Source: c.reconciler.settings.Changes()},
+			q.Handler(ctrl.EnqueueAll(mgr.GetClient(), &corev1.ServiceList{}, c.reconciler.log)),
+		)
+	}
+
+	return b.Complete(q.Reconciler(ctrl.Budgeted(c.rateBudget, ctrl.Sharded(c.shard, c.reconciler))))
 }
 
 type reconciler struct {
@@ -85,7 +153,67 @@ type reconciler struct {
 	labels        map[string]bool
 	clusterDomain string
 	log           *log.Logger
-	dualStackIP   bool
+	// if true, cluster IPs of the family are not published
+	skipIPv4   bool
+	skipIPv6   bool
+	tenants    *ctrl.TenantMapping
+	clusterTag string
+	// if set, replaces the tags that it has resolved
+	// with their current values in NetBox
+	tagCache *ctrl.TagCache
+	// settings, if set, replace tags and labels
+	settings *ctrl.LiveSettings
+	// if true, IPs are published without a DNS name
+	omitDNSName bool
+	// how descriptions longer than NetBox allows are shortened
+	descriptionStrategy string
+	// how often services are reconciled without changes, if at all
+	requeueAfter time.Duration
+	// slowThreshold, if greater than 0, is how long a reconciliation
+	// may take before a warning with a breakdown of its time is logged
+	slowThreshold time.Duration
+	// if true, addresses of load balancer ingress points are published
+	loadBalancerIPs bool
+	// if true, NetBoxIPs of load balancer addresses, including VIPs,
+	// reference the NetBoxIPs of cluster IPs as their NAT inside IPs
+	loadBalancerNAT bool
+	// resolver, if set, resolves load balancer ingress hostnames,
+	// which are resolved again after hostnameRefresh
+	resolver        ctrl.Resolver
+	hostnameRefresh time.Duration
+	// if set, node ports of services are published as NetBox services
+	nodePortServices netbox.ServiceClient
+	// externalNameResolver, if set, resolves the targets of ExternalName
+	// services, which are resolved again after externalNameRefresh
+	externalNameResolver ctrl.Resolver
+	externalNameRefresh  time.Duration
+	// if true, namespaces may override clusterDomain
+	namespaceDomains bool
+	// if set, addresses requested with VIP annotations
+	// are published with this tag
+	vipTag *netbox.Tag
+	// if set, IPs are allocated from lbIPPool for LoadBalancer
+	// services that request one, and written to lbIPAnnotation
+	lbIPAllocator  allocatorClient
+	lbIPPool       netip.Prefix
+	lbIPAnnotation string
+	// added to the tags of cluster IPs and of load balancer
+	// addresses, including VIPs, respectively
+	clusterIPTags    []netbox.Tag
+	loadBalancerTags []netbox.Tag
+	// if set, the custom fields of IPs that the
+	// selector and ports of services are written to
+	selectorField string
+	portsField    string
+}
+
+// publishSettings returns the current tags, publish labels
+// and namespace filters of the reconciler.
+func (r *reconciler) publishSettings() ctrl.PublishSettings {
+	if r.settings != nil {
+		return r.settings.Get()
+	}
+	return ctrl.PublishSettings{Tags: r.tags, Labels: r.labels}
 }
 
 // Reconcile is called on every event that the given reconciler is watching,
@@ -95,9 +223,18 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		log.String("namespace", req.Namespace),
 		log.String("name", req.Name),
 	)
+	ctx, ll = ctrl.WithRequestID(ctx, ll)
 
 	ll.Info("reconciling service")
 
+	ctx, outcome := ctrl.WithOutcome(ctx)
+	result, err := r.reconcileService(ctx, ll, req)
+	outcome.Log(ll, err)
+	outcome.WarnIfSlow(ll, r.slowThreshold)
+	return result, err
+}
+
+func (r *reconciler) reconcileService(ctx context.Context, ll *log.Logger, req reconcile.Request) (reconcile.Result, error) {
 	var svc corev1.Service
 	err := r.kubeClient.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: req.Name}, &svc)
 	if err != nil {
@@ -105,18 +242,41 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 			ll.Error("failed to retrieve service", log.Error(err))
 			return reconcile.Result{}, fmt.Errorf("retrieving service: %w", err)
 		}
-		return reconcile.Result{}, nil
+		ctrl.RecordOutcome(ctx, ctrl.OutcomeNoop, ctrl.ReasonNotFound)
+		return reconcile.Result{}, r.reconcileNodePortServices(ctx, ll, req.NamespacedName, nil, r.publishSettings())
+	}
+
+	// remove NetBoxIPs of a deleted service with the same name first,
+	// since the recreated service may have been assigned their addresses
+	if err := r.deletePredecessorIPs(ctx, ll, &svc); err != nil {
+		return reconcile.Result{}, err
 	}
 
-	// ips is a slice to support dual stack IP addresses. If r.dualStackIP is false, ips will
-	// always be a slice with 1 element
-	ips, err := r.netboxIPsFromService(&svc, r.dualStackIP)
+	// ips holds an IP of each family published for the service,
+	// which depends on its cluster IPs and the published families
+	tenant, err := r.tenants.TenantFor(ctx, r.kubeClient, svc.Namespace)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("determining tenant: %w", err)
+	}
+
+	settings := r.publishSettings()
+
+	ips, err := r.netboxIPsFromService(ctx, &svc, tenant, settings)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
 
+	successors := ips
+	if !serviceShouldHaveIP(&svc, settings) {
+		// the IPs are removed, rather than taken over
+		successors = &ctrl.IPs{}
+	}
+	if err := ctrl.MigrateUnsuffixedNetBoxIP(ctx, r.kubeClient, ll, &svc, successors); err != nil {
+		return reconcile.Result{}, err
+	}
+
 	for _, ip := range []*v1beta1.NetBoxIP{ips.IPv4, ips.IPv6} {
-		if ip == nil || !r.serviceShouldHaveIP(&svc) {
+		if ip == nil || !serviceShouldHaveIP(&svc, settings) {
 			continue
 		}
 
@@ -134,11 +294,33 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	// For both IPv4 and IPv6 addresses, delete the associated NetBoxIP object (if it exists)
 	// if the service no longer has an address of that scheme assigned.
 	var errs multierror.Error
-	if err = r.deleteNetBoxIPIfStale(ctx, ips.IPv4, svc, "ipv4"); err != nil {
+	if err = r.deleteNetBoxIPIfStale(ctx, ips.IPv4, svc, "ipv4", settings); err != nil {
+		multierror.Append(&errs, err)
+	}
+
+	if err = r.deleteNetBoxIPIfStale(ctx, ips.IPv6, svc, "ipv6", settings); err != nil {
 		multierror.Append(&errs, err)
 	}
 
-	if err = r.deleteNetBoxIPIfStale(ctx, ips.IPv6, svc, "ipv6"); err != nil {
+	if err := r.allocateLoadBalancerIP(ctx, ll, &svc, tenant); err != nil {
+		multierror.Append(&errs, err)
+	}
+
+	resolved, err := r.reconcileLoadBalancerIPs(ctx, ll, &svc, tenant, settings)
+	if err != nil {
+		multierror.Append(&errs, err)
+	}
+
+	if err := r.reconcileVIPs(ctx, ll, &svc, tenant, settings); err != nil {
+		multierror.Append(&errs, err)
+	}
+
+	externalNameResolved, err := r.reconcileExternalNameIPs(ctx, ll, &svc, tenant, settings)
+	if err != nil {
+		multierror.Append(&errs, err)
+	}
+
+	if err := r.reconcileNodePortServices(ctx, ll, req.NamespacedName, &svc, settings); err != nil {
 		multierror.Append(&errs, err)
 	}
 
@@ -146,22 +328,91 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		return reconcile.Result{}, &errs
 	}
 
-	return reconcile.Result{}, nil
+	// resolved addresses may change without any change of the service
+	requeueAfter := r.requeueAfter
+	if resolved {
+		requeueAfter = minInterval(requeueAfter, r.hostnameRefresh)
+	}
+	if externalNameResolved {
+		requeueAfter = minInterval(requeueAfter, r.externalNameRefresh)
+	}
+	return ctrl.Requeue(requeueAfter), nil
+}
+
+// minInterval returns the shorter of the given requeue
+// intervals, where intervals of 0 mean never.
+func minInterval(a, b time.Duration) time.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
 }
 
-func (r *reconciler) netboxIPsFromService(svc *corev1.Service, dualStack bool) (*ctrl.IPs, error) {
-	var svcIPs []string
-	if dualStack {
-		svcIPs = svc.Spec.ClusterIPs
-	} else {
+// clusterIPs returns the cluster IPs of the service that are published:
+// the ones of the families that are not skipped, so that both cluster IPs
+// of dual-stack services are published by default, and the one cluster IP
+// of single-stack services is, if its family is published. The cluster IPs
+// must match the IP families of the service.
+func clusterIPs(svc *corev1.Service, skipIPv4, skipIPv6 bool) ([]string, error) {
+	svcIPs := svc.Spec.ClusterIPs
+	if len(svcIPs) == 0 {
+		// set by older API servers, or in tests
 		svcIPs = []string{svc.Spec.ClusterIP}
 	}
 
+	for i, ip := range svcIPs {
+		if i >= len(svc.Spec.IPFamilies) || ip == "" || ip == corev1.ClusterIPNone {
+			continue
+		}
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster IP: %w", err)
+		}
+		if family := svc.Spec.IPFamilies[i]; addr.Is4() != (family == corev1.IPv4Protocol) {
+			return nil, fmt.Errorf("cluster IP %s does not match IP family %s", ip, family)
+		}
+	}
+
+	return ctrl.PublishedFamilies(svcIPs, skipIPv4, skipIPv6), nil
+}
+
+// dnsName returns the DNS name of the service, which is published
+// with its IPs, or an empty string if IPs are published without one.
+func (r *reconciler) dnsName(ctx context.Context, svc *corev1.Service) (string, error) {
+	if r.omitDNSName {
+		return "", nil
+	}
+	domain := r.clusterDomain
+	if r.namespaceDomains {
+		var err error
+		if domain, err = ctrl.ClusterDomainFor(ctx, r.kubeClient, svc.Namespace, r.clusterDomain); err != nil {
+			return "", fmt.Errorf("determining cluster domain: %w", err)
+		}
+	}
+	return fmt.Sprintf("%s.%s.svc.%s", svc.Name, svc.Namespace, domain), nil
+}
+
+func (r *reconciler) netboxIPsFromService(ctx context.Context, svc *corev1.Service, tenant string, settings ctrl.PublishSettings) (*ctrl.IPs, error) {
+	svcIPs, err := clusterIPs(svc, r.skipIPv4, r.skipIPv6)
+	if err != nil {
+		return &ctrl.IPs{}, err
+	}
+
+	dnsName, err := r.dnsName(ctx, svc)
+	if err != nil {
+		return &ctrl.IPs{}, err
+	}
+
 	ips, err := ctrl.CreateNetBoxIPs(svcIPs, ctrl.NetBoxIPConfig{
-		Object:           svc,
-		DNSName:          fmt.Sprintf("%s.%s.svc.%s", svc.Name, svc.Namespace, r.clusterDomain),
-		ReconcilerTags:   r.tags,
-		ReconcilerLabels: r.labels,
+		Object:              svc,
+		DNSName:             dnsName,
+		ReconcilerTags:      withTags(settings.Tags, r.clusterIPTags...),
+		TagCache:            r.tagCache,
+		ReconcilerLabels:    settings.Labels,
+		Tenant:              tenant,
+		CustomFields:        r.customFields(svc),
+		ClusterTag:          r.clusterTag,
+		DescriptionStrategy: r.descriptionStrategy,
 	})
 	if err != nil {
 		return &ctrl.IPs{}, err
@@ -170,21 +421,53 @@ func (r *reconciler) netboxIPsFromService(svc *corev1.Service, dualStack bool) (
 	return ips, nil
 }
 
-func (r *reconciler) deleteNetBoxIPIfStale(ctx context.Context, netboxip *v1beta1.NetBoxIP, svc corev1.Service, suffix string) error {
+// customFields returns the values of the custom fields of the IPs of the
+// service: its selector, e.g. app=foo,tier=web, and its ports, e.g.
+// 80/TCP,443/TCP, like kubectl shows them.
+func (r *reconciler) customFields(svc *corev1.Service) map[string]string {
+	if r.selectorField == "" && r.portsField == "" {
+		return nil
+	}
+
+	// fields are included even if empty, so that they
+	// are cleared when the selector or ports are removed
+	fields := make(map[string]string)
+	if r.selectorField != "" {
+		fields[r.selectorField] = labels.Set(svc.Spec.Selector).String()
+	}
+	if r.portsField != "" {
+		ports := make([]string, 0, len(svc.Spec.Ports))
+		for _, port := range svc.Spec.Ports {
+			ports = append(ports, fmt.Sprintf("%d/%s", port.Port, port.Protocol))
+		}
+		fields[r.portsField] = strings.Join(ports, ",")
+	}
+	return fields
+}
+
+// withTags returns the given tags followed by the additional ones,
+// without modifying the given slice, which may be shared.
+func withTags(tags []netbox.Tag, additional ...netbox.Tag) []netbox.Tag {
+	return append(append([]netbox.Tag{}, tags...), additional...)
+}
+
+func (r *reconciler) deleteNetBoxIPIfStale(ctx context.Context, netboxip *v1beta1.NetBoxIP, svc corev1.Service, suffix string, settings ctrl.PublishSettings) error {
 	var ip v1beta1.NetBoxIP
 	err := r.kubeClient.Get(context.Background(), client.ObjectKey{Namespace: svc.Namespace, Name: ctrl.NetBoxIPName(&svc, suffix)}, &ip)
 	if client.IgnoreNotFound(err) != nil {
 		return fmt.Errorf("fetching NetBoxIP: %q", err)
-	} else if !kubeerrors.IsNotFound(err) {
-		if netboxip == nil || !r.serviceShouldHaveIP(&svc) {
+	} else if !kubeerrors.IsNotFound(err) && !ctrl.IsManual(&ip) {
+		if netboxip == nil || !serviceShouldHaveIP(&svc, settings) {
 			if err := r.kubeClient.Delete(ctx, &ip); client.IgnoreNotFound(err) != nil {
 				return fmt.Errorf("deleting netboxip: %w", err)
 			}
+			ctrl.RecordOutcome(ctx, ctrl.OutcomeDeleted, ctrl.ReasonStale)
 		}
 	}
 	return nil
 }
 
-func (r *reconciler) serviceShouldHaveIP(svc *corev1.Service) bool {
-	return ctrl.HasPublishLabels(r.labels, svc.Labels) && !(svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == "None")
+func serviceShouldHaveIP(svc *corev1.Service, settings ctrl.PublishSettings) bool {
+	return ctrl.HasPublishLabels(settings.Labels, svc.Labels) &&
+		settings.PublishesNamespace(svc.Namespace) && !(svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == "None")
 }
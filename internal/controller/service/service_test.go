@@ -61,6 +61,8 @@ func TestReconcile(t *testing.T) {
 		existingService  *corev1.Service
 		existingNetBoxIP *v1beta1.NetBoxIP
 		expectedNetBoxIP *v1beta1.NetBoxIP
+		// if true, IPv6 addresses are not published
+		skipIPv6 bool
 	}{{
 		name:             "does not exist",
 		existingService:  nil,
@@ -287,9 +289,10 @@ func TestReconcile(t *testing.T) {
 			},
 		},
 	}, {
-		// It is acceptable to have dual stack services/pods exist in a cluster even if
-		// dual-stack-ips is disabled. In this case, only the ClusterIP should be registered into netbox
 		name: "with dual stack ClusterIPs",
+		// if IPv6 addresses are not published, only the IPv4 ClusterIP
+		// of dual stack services is registered in NetBox
+		skipIPv6: true,
 		existingService: &corev1.Service{
 			TypeMeta: metav1.TypeMeta{
 				Kind:       "Service",
@@ -342,7 +345,7 @@ func TestReconcile(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			kubeClientBuilder := fakeclient.NewClientBuilder().WithScheme(scheme)
+			kubeClientBuilder := fakeclient.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&v1beta1.NetBoxIP{})
 
 			var existingObjs []client.Object
 			if test.existingService != nil {
@@ -359,6 +362,7 @@ func TestReconcile(t *testing.T) {
 				tags:          []netbox.Tag{{Name: "bar", Slug: "bar"}},
 				labels:        map[string]bool{"app": true},
 				log:           log.L(),
+				skipIPv6:      test.skipIPv6,
 			}
 
 			req := reconcile.Request{
@@ -383,7 +387,7 @@ func TestReconcile(t *testing.T) {
 			} else if test.expectedNetBoxIP == nil && !kubeerrors.IsNotFound(err) {
 				t.Errorf("want NetBoxIP not to exist, got %v\n", actualNetBoxIP)
 			} else if test.expectedNetBoxIP != nil {
-				if diff := cmp.Diff(test.expectedNetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmp.Comparer(addrComparer)); diff != "" {
+				if diff := cmp.Diff(test.expectedNetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmpopts.IgnoreFields(v1beta1.NetBoxIP{}, "Status"), cmp.Comparer(addrComparer)); diff != "" {
 					t.Errorf("NetBoxIP object (-want, +got)\n%s", diff)
 				}
 			}
@@ -940,7 +944,7 @@ func TestReconcileDualStack(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			kubeClientBuilder := fakeclient.NewClientBuilder().WithScheme(scheme)
+			kubeClientBuilder := fakeclient.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&v1beta1.NetBoxIP{})
 
 			var existingObjs []client.Object
 			if test.existingService != nil {
@@ -959,7 +963,6 @@ func TestReconcileDualStack(t *testing.T) {
 				tags:          []netbox.Tag{{Name: "bar", Slug: "bar"}},
 				labels:        map[string]bool{"app": true},
 				log:           log.L(),
-				dualStackIP:   true,
 			}
 
 			req := reconcile.Request{
@@ -986,7 +989,7 @@ func TestReconcileDualStack(t *testing.T) {
 			} else if test.expectedIPv4NetBoxIP == nil && !kubeerrors.IsNotFound(err) {
 				t.Errorf("want IPv4 NetBoxIP not to exist, got %v\n", actualNetBoxIP)
 			} else if test.expectedIPv4NetBoxIP != nil {
-				if diff := cmp.Diff(test.expectedIPv4NetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmp.Comparer(addrComparer)); diff != "" {
+				if diff := cmp.Diff(test.expectedIPv4NetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmpopts.IgnoreFields(v1beta1.NetBoxIP{}, "Status"), cmp.Comparer(addrComparer)); diff != "" {
 					t.Errorf("NetBoxIP object (-want, +got)\n%s", diff)
 				}
 			}
@@ -1002,10 +1005,145 @@ func TestReconcileDualStack(t *testing.T) {
 			} else if test.expectedIPv6NetBoxIP == nil && !kubeerrors.IsNotFound(err) {
 				t.Errorf("want IPv6 NetBoxIP not to exist, got %v\n", actualNetBoxIP)
 			} else if test.expectedIPv6NetBoxIP != nil {
-				if diff := cmp.Diff(test.expectedIPv6NetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmp.Comparer(addrComparer)); diff != "" {
+				if diff := cmp.Diff(test.expectedIPv6NetBoxIP, &actualNetBoxIP, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmpopts.IgnoreFields(v1beta1.NetBoxIP{}, "Status"), cmp.Comparer(addrComparer)); diff != "" {
 					t.Errorf("NetBoxIP object (-want, +got)\n%s", diff)
 				}
 			}
 		})
 	}
 }
+
+func TestClusterIPs(t *testing.T) {
+	policy := func(p corev1.IPFamilyPolicy) *corev1.IPFamilyPolicy { return &p }
+	dualStackSpec := func(p *corev1.IPFamilyPolicy) corev1.ServiceSpec {
+		return corev1.ServiceSpec{
+			ClusterIP:      "fd00::1",
+			ClusterIPs:     []string{"fd00::1", "192.168.0.1"},
+			IPFamilies:     []corev1.IPFamily{corev1.IPv6Protocol, corev1.IPv4Protocol},
+			IPFamilyPolicy: p,
+		}
+	}
+
+	tests := []struct {
+		name          string
+		spec          corev1.ServiceSpec
+		skipIPv4      bool
+		skipIPv6      bool
+		expectedIPs   []string
+		errorExpected bool
+	}{{
+		name: "single stack",
+		spec: corev1.ServiceSpec{
+			ClusterIP:      "192.168.0.1",
+			ClusterIPs:     []string{"192.168.0.1"},
+			IPFamilies:     []corev1.IPFamily{corev1.IPv4Protocol},
+			IPFamilyPolicy: policy(corev1.IPFamilyPolicySingleStack),
+		},
+		expectedIPs: []string{"192.168.0.1"},
+	}, {
+		name: "single stack of a skipped family",
+		spec: corev1.ServiceSpec{
+			ClusterIP:      "192.168.0.1",
+			ClusterIPs:     []string{"192.168.0.1"},
+			IPFamilies:     []corev1.IPFamily{corev1.IPv4Protocol},
+			IPFamilyPolicy: policy(corev1.IPFamilyPolicySingleStack),
+		},
+		skipIPv4: true,
+	}, {
+		name:        "prefer dual stack",
+		spec:        dualStackSpec(policy(corev1.IPFamilyPolicyPreferDualStack)),
+		expectedIPs: []string{"fd00::1", "192.168.0.1"},
+	}, {
+		name:        "require dual stack",
+		spec:        dualStackSpec(policy(corev1.IPFamilyPolicyRequireDualStack)),
+		expectedIPs: []string{"fd00::1", "192.168.0.1"},
+	}, {
+		name:        "no policy",
+		spec:        dualStackSpec(nil),
+		expectedIPs: []string{"fd00::1", "192.168.0.1"},
+	}, {
+		name:        "dual stack without IPv6",
+		spec:        dualStackSpec(policy(corev1.IPFamilyPolicyPreferDualStack)),
+		skipIPv6:    true,
+		expectedIPs: []string{"192.168.0.1"},
+	}, {
+		name:        "dual stack without IPv4",
+		spec:        dualStackSpec(policy(corev1.IPFamilyPolicyRequireDualStack)),
+		skipIPv4:    true,
+		expectedIPs: []string{"fd00::1"},
+	}, {
+		name:        "headless",
+		spec:        corev1.ServiceSpec{ClusterIP: "None", ClusterIPs: []string{"None"}, IPFamilies: []corev1.IPFamily{corev1.IPv4Protocol}},
+		expectedIPs: []string{"None"},
+	}, {
+		name: "mismatched IP family",
+		spec: corev1.ServiceSpec{
+			ClusterIP:  "192.168.0.1",
+			ClusterIPs: []string{"192.168.0.1"},
+			IPFamilies: []corev1.IPFamily{corev1.IPv6Protocol},
+		},
+		errorExpected: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ips, err := clusterIPs(&corev1.Service{Spec: test.spec}, test.skipIPv4, test.skipIPv6)
+			if test.errorExpected && err == nil {
+				t.Error("expected error but got nil")
+			} else if !test.errorExpected && err != nil {
+				t.Errorf("unexpected error: %q", err)
+			}
+
+			if diff := cmp.Diff(test.expectedIPs, ips); diff != "" {
+				t.Errorf("cluster IPs (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCustomFields(t *testing.T) {
+	svc := &corev1.Service{
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"tier": "web", "app": "foo"},
+			Ports: []corev1.ServicePort{
+				{Port: 80, Protocol: corev1.ProtocolTCP},
+				{Port: 53, Protocol: corev1.ProtocolUDP},
+			},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		selectorField  string
+		portsField     string
+		service        *corev1.Service
+		expectedFields map[string]string
+	}{{
+		name:           "no fields",
+		service:        svc,
+		expectedFields: nil,
+	}, {
+		name:          "selector and ports",
+		selectorField: "k8s_selector",
+		portsField:    "k8s_ports",
+		service:       svc,
+		expectedFields: map[string]string{
+			"k8s_selector": "app=foo,tier=web",
+			"k8s_ports":    "80/TCP,53/UDP",
+		},
+	}, {
+		name:           "no selector",
+		selectorField:  "k8s_selector",
+		service:        &corev1.Service{},
+		expectedFields: map[string]string{"k8s_selector": ""},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &reconciler{selectorField: test.selectorField, portsField: test.portsField}
+			if diff := cmp.Diff(test.expectedFields, r.customFields(test.service)); diff != "" {
+				t.Errorf("custom fields (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
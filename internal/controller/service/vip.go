@@ -0,0 +1,124 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	ctrl "github.com/digitalocean/netbox-ip-controller/internal/controller"
+
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// vipSuffix prefixes the suffixes of the names of NetBoxIPs
+// of addresses requested with VIP annotations.
+const vipSuffix = "vip-"
+
+// vipAddresses returns the addresses requested by the VIP annotations of the
+// service, except for those already published as load balancer ingress
+// addresses, which have their own NetBoxIPs.
+func (r *reconciler) vipAddresses(svc *corev1.Service) ([]netip.Addr, error) {
+	assigned := make(map[netip.Addr]bool)
+	if r.loadBalancerIPs {
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if addr, err := netip.ParseAddr(ingress.IP); err == nil {
+				assigned[addr] = true
+			}
+		}
+	}
+
+	seen := make(map[netip.Addr]bool)
+	var addrs []netip.Addr
+	for _, annotation := range ctrl.VIPAnnotations {
+		value, ok := svc.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		for _, s := range strings.Split(value, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s annotation value %q is invalid: %w", annotation, value, err)
+			}
+			if assigned[addr] || seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// reconcileVIPs creates or updates the NetBoxIPs of the addresses requested
+// with VIP annotations of LoadBalancer services, tagged with the VIP tag,
+// and deletes the ones of addresses that are no longer requested, or that
+// have been assigned and are published as load balancer ingress addresses.
+func (r *reconciler) reconcileVIPs(ctx context.Context, ll *log.Logger, svc *corev1.Service, tenant string, settings ctrl.PublishSettings) error {
+	if r.vipTag == nil {
+		return nil
+	}
+
+	desired := make(map[string]bool)
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer &&
+		ctrl.HasPublishLabels(settings.Labels, svc.Labels) && settings.PublishesNamespace(svc.Namespace) {
+		addrs, err := r.vipAddresses(svc)
+		if err != nil {
+			return err
+		}
+
+		tags := withTags(settings.Tags, withTags(r.loadBalancerTags, *r.vipTag)...)
+		for _, addr := range addrs {
+			ips, err := ctrl.CreateNetBoxIPs([]string{addr.String()}, ctrl.NetBoxIPConfig{
+				Object:              svc,
+				ReconcilerTags:      tags,
+				TagCache:            r.tagCache,
+				ReconcilerLabels:    settings.Labels,
+				Tenant:              tenant,
+				ClusterTag:          r.clusterTag,
+				DescriptionStrategy: r.descriptionStrategy,
+			})
+			if err != nil {
+				return err
+			}
+			ip := ips.IPv4
+			if ip == nil {
+				ip = ips.IPv6
+			}
+			ip.Name = addressIPName(svc, vipSuffix, addr)
+			ip.Spec.NATInside = r.natInside(svc, addr)
+			r.takeOverAllocatedIP(ip, svc, addr)
+
+			if err := ctrl.DeclareOwner(ip, svc); err != nil {
+				return fmt.Errorf("setting owner: %w", err)
+			}
+			if err := ctrl.UpsertNetBoxIP(ctx, r.kubeClient, ll, ip); err != nil {
+				return err
+			}
+			desired[ip.Name] = true
+		}
+	}
+
+	return r.deleteUndesiredIPs(ctx, ll, svc, vipSuffix, desired)
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"testing"
+
+	netboxctrl "github.com/digitalocean/netbox-ip-controller"
+	"github.com/digitalocean/netbox-ip-controller/api/netbox/v1beta1"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	log "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// vipIP describes a NetBoxIP of an address requested with a VIP annotation.
+type vipIP struct {
+	Name    string
+	Address string
+	Tags    []v1beta1.Tag
+}
+
+func TestReconcileVIPs(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+	v1beta1.AddToScheme(scheme)
+
+	vipService := func(annotations map[string]string, ingressIPs ...string) *corev1.Service {
+		svc := &corev1.Service{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Service",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				UID:         types.UID(serviceUID),
+				Labels:      map[string]string{"app": "foo"},
+				Annotations: annotations,
+			},
+			Spec: corev1.ServiceSpec{
+				Type:      corev1.ServiceTypeLoadBalancer,
+				ClusterIP: "192.168.0.1",
+			},
+		}
+		for _, ip := range ingressIPs {
+			svc.Status.LoadBalancer.Ingress = append(svc.Status.LoadBalancer.Ingress, corev1.LoadBalancerIngress{IP: ip})
+		}
+		return svc
+	}
+
+	vipIPName := func(suffix string) string {
+		return fmt.Sprintf("service-%s-vip-%s", serviceUID, suffix)
+	}
+	tags := []v1beta1.Tag{{Name: "bar", Slug: "bar"}, {Name: "vip", Slug: "vip"}}
+
+	tests := []struct {
+		name          string
+		service       *corev1.Service
+		existingIPs   []string
+		expectedIPs   []vipIP
+		errorExpected bool
+	}{{
+		name: "kube-vip and MetalLB annotations",
+		service: vipService(map[string]string{
+			"kube-vip.io/loadbalancerIPs":         "203.0.113.10",
+			"metallb.universe.tf/loadBalancerIPs": "203.0.113.10, 2001:db8::1",
+		}),
+		expectedIPs: []vipIP{{
+			Name:    vipIPName("2001-0db8-0000-0000-0000-0000-0000-0001"),
+			Address: "2001:db8::1",
+			Tags:    tags,
+		}, {
+			Name:    vipIPName("203.0.113.10"),
+			Address: "203.0.113.10",
+			Tags:    tags,
+		}},
+	}, {
+		name:        "assigned address",
+		service:     vipService(map[string]string{"kube-vip.io/loadbalancerIPs": "203.0.113.10"}, "203.0.113.10"),
+		existingIPs: []string{"203.0.113.10"},
+		expectedIPs: nil,
+	}, {
+		name:        "annotation removed",
+		service:     vipService(nil),
+		existingIPs: []string{"203.0.113.10"},
+		expectedIPs: nil,
+	}, {
+		name:          "invalid annotation",
+		service:       vipService(map[string]string{"kube-vip.io/loadbalancerIPs": "203.0.113"}),
+		existingIPs:   []string{"203.0.113.10"},
+		expectedIPs:   []vipIP{{Name: vipIPName("203.0.113.10"), Address: "203.0.113.10"}},
+		errorExpected: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objs := []client.Object{test.service}
+			for _, addr := range test.existingIPs {
+				objs = append(objs, &v1beta1.NetBoxIP{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      vipIPName(addr),
+						Namespace: namespace,
+						Labels:    map[string]string{netboxctrl.NameLabel: name},
+					},
+					Spec: v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr(addr)},
+				})
+			}
+
+			r := &reconciler{
+				kubeClient:      fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+				clusterDomain:   "testclusterdomain",
+				tags:            []netbox.Tag{{Name: "bar", Slug: "bar"}},
+				labels:          map[string]bool{"app": true},
+				log:             log.L(),
+				loadBalancerIPs: true,
+				vipTag:          &netbox.Tag{Name: "vip", Slug: "vip"},
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+			_, err := r.Reconcile(context.Background(), req)
+			if test.errorExpected && err == nil {
+				t.Error("expected error but got nil")
+			} else if !test.errorExpected && err != nil {
+				t.Errorf("reconciling: %q", err)
+			}
+
+			var ips v1beta1.NetBoxIPList
+			if err := r.kubeClient.List(context.Background(), &ips, client.InNamespace(namespace)); err != nil {
+				t.Fatalf("listing NetBoxIPs: %q", err)
+			}
+			var actual []vipIP
+			for _, ip := range ips.Items {
+				if strings.Contains(ip.Name, "-vip-") {
+					actual = append(actual, vipIP{
+						Name:    ip.Name,
+						Address: ip.Spec.Address.String(),
+						Tags:    ip.Spec.Tags,
+					})
+				}
+			}
+			sort.Slice(actual, func(i, j int) bool { return actual[i].Name < actual[j].Name })
+
+			if diff := cmp.Diff(test.expectedIPs, actual); diff != "" {
+				t.Errorf("VIP NetBoxIPs (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
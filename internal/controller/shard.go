@@ -0,0 +1,66 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"hash/fnv"
+
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Shard is the share of namespaces that one of Count replicas of
+// the controller reconciles objects in. Namespaces are split between
+// the shards by the hash of their names, so that every replica agrees
+// on which one of them owns a namespace without coordinating.
+type Shard struct {
+	Index int
+	Count int
+}
+
+// Owns returns whether objects in the namespace with the
+// given name are reconciled by this shard. A nil Shard owns
+// all namespaces.
+func (s *Shard) Owns(namespace string) bool {
+	if s == nil || s.Count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(namespace))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index
+}
+
+// Sharded wraps the reconciler so that it only reconciles requests
+// owned by the shard, and drops all others. Requests for namespaced
+// objects are owned by the shard of their namespace, and requests
+// for cluster-scoped objects, i.e. namespaces, by the shard of their
+// name, so that a namespace belongs to the same shard as its objects.
+func Sharded(shard *Shard, r reconcile.Reconciler) reconcile.Reconciler {
+	if shard == nil || shard.Count <= 1 {
+		return r
+	}
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		namespace := req.Namespace
+		if namespace == "" {
+			namespace = req.Name
+		}
+		if !shard.Owns(namespace) {
+			return reconcile.Result{}, nil
+		}
+		return r.Reconcile(ctx, req)
+	})
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestShardOwns(t *testing.T) {
+	const count = 3
+	owners := make([]int, count)
+	for i := 0; i < 300; i++ {
+		namespace := fmt.Sprintf("namespace-%d", i)
+		owned := 0
+		for index := 0; index < count; index++ {
+			if (&Shard{Index: index, Count: count}).Owns(namespace) {
+				owners[index]++
+				owned++
+			}
+		}
+		if owned != 1 {
+			t.Fatalf("want namespace %s owned by 1 shard, got %d", namespace, owned)
+		}
+	}
+	for index, owned := range owners {
+		if owned == 0 {
+			t.Errorf("want shard %d to own namespaces, got none", index)
+		}
+	}
+
+	var shard *Shard
+	if !shard.Owns("default") {
+		t.Error("want nil shard to own all namespaces")
+	}
+}
+
+func TestSharded(t *testing.T) {
+	var reconciled []string
+	r := reconcile.Func(func(_ context.Context, req reconcile.Request) (reconcile.Result, error) {
+		reconciled = append(reconciled, req.String())
+		return reconcile.Result{}, nil
+	})
+
+	shard := &Shard{Index: 0, Count: 2}
+	var owned, other string
+	for i := 0; owned == "" || other == ""; i++ {
+		namespace := fmt.Sprintf("namespace-%d", i)
+		if shard.Owns(namespace) {
+			owned = namespace
+		} else {
+			other = namespace
+		}
+	}
+
+	requests := []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: owned, Name: "pod"}},
+		{NamespacedName: types.NamespacedName{Namespace: other, Name: "pod"}},
+		{NamespacedName: types.NamespacedName{Name: owned}},
+		{NamespacedName: types.NamespacedName{Name: other}},
+	}
+	sharded := Sharded(shard, r)
+	for _, req := range requests {
+		if _, err := sharded.Reconcile(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{owned + "/pod", "/" + owned}
+	if len(reconciled) != len(expected) || reconciled[0] != expected[0] || reconciled[1] != expected[1] {
+		t.Errorf("want reconciled %q, got %q", expected, reconciled)
+	}
+}
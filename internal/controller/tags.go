@@ -0,0 +1,208 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+)
+
+// TagCache caches the NetBox tags of the controller by name, and
+// re-validates them periodically, so that IPs are published with
+// the current tags even if they are renamed or deleted in NetBox
+// while the controller is running. Tags that do not exist are only
+// created once the first IP with them is published.
+type TagCache struct {
+	netboxClient netbox.Client
+	log          *log.Logger
+	// how often the cached tags are re-validated,
+	// or never if not greater than 0
+	interval time.Duration
+
+	mu   sync.RWMutex
+	tags map[string]netbox.Tag
+}
+
+// NewTagCache returns an empty cache of tags, which are
+// re-validated every interval once the cache is started.
+func NewTagCache(netboxClient netbox.Client, logger *log.Logger, interval time.Duration) *TagCache {
+	return &TagCache{
+		netboxClient: netboxClient,
+		log:          logger.With(log.String("component", "tag-cache")),
+		interval:     interval,
+		tags:         make(map[string]netbox.Tag),
+	}
+}
+
+// Ensure returns the tags with the given names, like EnsureTags,
+// but only looks up or creates those that are not cached yet.
+func (c *TagCache) Ensure(ctx context.Context, names []string) ([]netbox.Tag, error) {
+	var tags []netbox.Tag
+	for _, name := range names {
+		c.mu.RLock()
+		tag, ok := c.tags[name]
+		c.mu.RUnlock()
+		if !ok {
+			ensured, err := EnsureTags(ctx, c.netboxClient, c.log, []string{name})
+			if err != nil {
+				return nil, err
+			}
+			tag = ensured[0]
+			c.mu.Lock()
+			c.tags[name] = tag
+			c.mu.Unlock()
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// Resolve returns the given tags as they are in NetBox, by name, looking
+// up or creating those that are not cached yet. A nil cache returns the
+// tags as they are.
+func (c *TagCache) Resolve(ctx context.Context, tags []netbox.Tag) ([]netbox.Tag, error) {
+	if c == nil || len(tags) == 0 {
+		return tags, nil
+	}
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return c.Ensure(ctx, names)
+}
+
+// Lookup returns the tags with the given names, like Ensure, but does not
+// create the ones that do not exist in NetBox yet: they are returned with
+// their names as slugs, which is how the controller creates them once the
+// first IP with them is published, so that IPs do not change when they are.
+// A nil cache returns every tag that way.
+func (c *TagCache) Lookup(ctx context.Context, names []string) ([]netbox.Tag, error) {
+	var tags []netbox.Tag
+	for _, name := range names {
+		tag := netbox.Tag{Name: name, Slug: name}
+		if c == nil {
+			tags = append(tags, tag)
+			continue
+		}
+		c.mu.RLock()
+		cached, ok := c.tags[name]
+		c.mu.RUnlock()
+		if ok {
+			tag = cached
+		} else {
+			existing, err := c.netboxClient.GetTag(ctx, name)
+			if err != nil {
+				return nil, fmt.Errorf("retrieving tag %s: %w", name, err)
+			}
+			if existing != nil {
+				tag = *existing
+				c.mu.Lock()
+				c.tags[name] = tag
+				c.mu.Unlock()
+			}
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// Current returns the given tags, with those that are
+// cached replaced by the cached tags of the same name.
+func (c *TagCache) Current(tags []netbox.Tag) []netbox.Tag {
+	if c == nil {
+		return tags
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	current := make([]netbox.Tag, 0, len(tags))
+	for _, tag := range tags {
+		if cached, ok := c.tags[tag.Name]; ok {
+			tag = cached
+		}
+		current = append(current, tag)
+	}
+	return current
+}
+
+// Refresh looks up every cached tag again, updates the ones that have
+// changed in NetBox, and re-creates the ones that no longer exist. Tags
+// that cannot be looked up or re-created are kept as they are, and
+// re-validated again at the next refresh.
+func (c *TagCache) Refresh(ctx context.Context) error {
+	c.mu.RLock()
+	cached := make(map[string]netbox.Tag, len(c.tags))
+	for name, tag := range c.tags {
+		cached[name] = tag
+	}
+	c.mu.RUnlock()
+
+	var failed int
+	for name, old := range cached {
+		ll := c.log.With(log.String("tag", name))
+
+		tag, err := c.netboxClient.GetTag(ctx, name)
+		if err != nil {
+			ll.Error("failed to retrieve tag", log.Error(err))
+			failed++
+			continue
+		}
+		if tag == nil {
+			if tag, err = c.netboxClient.CreateTag(ctx, name); err != nil {
+				ll.Error("failed to re-create missing tag", log.Error(err))
+				failed++
+				continue
+			}
+			ll.Warn("re-created tag missing from NetBox")
+		} else if *tag != old {
+			ll.Info("tag changed in NetBox", log.Int64("id", tag.ID), log.String("slug", tag.Slug))
+		}
+
+		c.mu.Lock()
+		c.tags[name] = *tag
+		c.mu.Unlock()
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to refresh %d of %d tags", failed, len(cached))
+	}
+	return nil
+}
+
+// Start re-validates the cached tags every interval until
+// the context is done. It implements manager.Runnable.
+func (c *TagCache) Start(ctx context.Context) error {
+	if c.interval <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil {
+				c.log.Error("failed to refresh tags", log.Error(err))
+			}
+		}
+	}
+}
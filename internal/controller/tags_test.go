@@ -0,0 +1,113 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	log "go.uber.org/zap"
+)
+
+func TestTagCache(t *testing.T) {
+	ctx := context.Background()
+	netboxTags := map[string]netbox.Tag{
+		"kubernetes": {ID: 1, Name: "kubernetes", Slug: "kubernetes"},
+	}
+	cache := NewTagCache(netbox.NewFakeClient(netboxTags, nil), log.NewNop(), 0)
+
+	tags, err := cache.Ensure(ctx, []string{"kubernetes", "pod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []netbox.Tag{{ID: 1, Name: "kubernetes", Slug: "kubernetes"}, {Name: "pod", Slug: "pod"}}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("want tags %v, got %v", expected, tags)
+	}
+
+	// changed and deleted in NetBox
+	netboxTags["kubernetes"] = netbox.Tag{ID: 1, Name: "kubernetes", Slug: "k8s"}
+	delete(netboxTags, "pod")
+
+	tags, err = cache.Ensure(ctx, []string{"kubernetes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, expected[:1]) {
+		t.Errorf("want cached tags %v before refresh, got %v", expected[:1], tags)
+	}
+
+	if err := cache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := netboxTags["pod"]; !ok {
+		t.Error("want deleted tag re-created in NetBox")
+	}
+
+	spec := []netbox.Tag{{Name: "kubernetes", Slug: "kubernetes"}, {Name: "pod", Slug: "pod"}, {Name: "other", Slug: "other"}}
+	current := cache.Current(spec)
+	expected = []netbox.Tag{{ID: 1, Name: "kubernetes", Slug: "k8s"}, {Name: "pod", Slug: "pod"}, {Name: "other", Slug: "other"}}
+	if !reflect.DeepEqual(current, expected) {
+		t.Errorf("want current tags %v, got %v", expected, current)
+	}
+
+	var nilCache *TagCache
+	if current := nilCache.Current(spec); !reflect.DeepEqual(current, spec) {
+		t.Errorf("want tags unchanged by nil cache, got %v", current)
+	}
+}
+
+func TestTagCacheResolve(t *testing.T) {
+	ctx := context.Background()
+	netboxTags := map[string]netbox.Tag{
+		"kubernetes": {ID: 1, Name: "kubernetes", Slug: "k8s"},
+	}
+	cache := NewTagCache(netbox.NewFakeClient(netboxTags, nil), log.NewNop(), 0)
+
+	lookedUp, err := cache.Lookup(ctx, []string{"kubernetes", "pod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []netbox.Tag{{ID: 1, Name: "kubernetes", Slug: "k8s"}, {Name: "pod", Slug: "pod"}}
+	if !reflect.DeepEqual(lookedUp, expected) {
+		t.Errorf("want looked up tags %v, got %v", expected, lookedUp)
+	}
+	if _, ok := netboxTags["pod"]; ok {
+		t.Error("want tag not created before it is resolved")
+	}
+
+	resolved, err := cache.Resolve(ctx, lookedUp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the names and slugs that NetBoxIPs are written with
+	// do not change once the tags are created
+	if len(resolved) != len(lookedUp) {
+		t.Fatalf("want resolved tags %v, got %v", lookedUp, resolved)
+	}
+	for i := range resolved {
+		if resolved[i].Name != lookedUp[i].Name || resolved[i].Slug != lookedUp[i].Slug {
+			t.Errorf("want resolved tag %v, got %v", lookedUp[i], resolved[i])
+		}
+	}
+	if _, ok := netboxTags["pod"]; !ok {
+		t.Error("want tag created once it is resolved")
+	}
+}
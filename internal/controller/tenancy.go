@@ -0,0 +1,130 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// TenantMapping maps namespaces to NetBox tenants.
+type TenantMapping struct {
+	// Rules are evaluated in order, and the first one
+	// matching the namespace is used.
+	Rules []TenantRule `json:"rules"`
+}
+
+// TenantRule assigns IPs of objects in the matching namespaces to a NetBox tenant.
+// A rule matches a namespace if both its name and its labels match.
+type TenantRule struct {
+	// Namespace is the name of the namespace. If empty, any name matches.
+	Namespace string `json:"namespace,omitempty"`
+	// NamespaceLabels must all be set on the namespace. If empty, any labels match.
+	NamespaceLabels map[string]string `json:"namespaceLabels,omitempty"`
+	// Tenant is the slug of the NetBox tenant.
+	Tenant string `json:"tenant"`
+	// TokenFile is an optional path to a file containing the NetBox API token
+	// to use when writing IPs of this tenant.
+	TokenFile string `json:"tokenFile,omitempty"`
+}
+
+// LoadTenantMapping reads the tenant mapping from a YAML or JSON file.
+func LoadTenantMapping(path string) (*TenantMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading tenant mapping: %w", err)
+	}
+
+	var m TenantMapping
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing tenant mapping: %w", err)
+	}
+
+	for i, rule := range m.Rules {
+		if rule.Tenant == "" {
+			return nil, fmt.Errorf("tenant mapping rule %d: tenant is required", i)
+		}
+		if rule.Namespace == "" && len(rule.NamespaceLabels) == 0 {
+			return nil, fmt.Errorf("tenant mapping rule %d: either namespace or namespaceLabels is required", i)
+		}
+	}
+
+	return &m, nil
+}
+
+// matches returns true if the rule applies to the given namespace.
+func (r TenantRule) matches(ns *corev1.Namespace) bool {
+	if r.Namespace != "" && r.Namespace != ns.Name {
+		return false
+	}
+	return labels.SelectorFromSet(r.NamespaceLabels).Matches(labels.Set(ns.Labels))
+}
+
+// TenantFor returns the slug of the tenant that IPs of objects in the given namespace
+// belong to, or an empty string if there is no mapping for the namespace.
+func (m *TenantMapping) TenantFor(ctx context.Context, kubeClient client.Client, namespace string) (string, error) {
+	if m == nil || len(m.Rules) == 0 {
+		return "", nil
+	}
+
+	var ns corev1.Namespace
+	if err := kubeClient.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		return "", fmt.Errorf("retrieving namespace: %w", err)
+	}
+
+	for _, rule := range m.Rules {
+		if rule.matches(&ns) {
+			return rule.Tenant, nil
+		}
+	}
+	return "", nil
+}
+
+// Tokens returns NetBox API tokens of the tenants that have one configured,
+// keyed by tenant slug.
+func (m *TenantMapping) Tokens() (map[string]string, error) {
+	tokens := make(map[string]string)
+	if m == nil {
+		return tokens, nil
+	}
+
+	for _, rule := range m.Rules {
+		if rule.TokenFile == "" {
+			continue
+		}
+		data, err := os.ReadFile(rule.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading token of tenant %s: %w", rule.Tenant, err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return nil, fmt.Errorf("token file of tenant %s is empty", rule.Tenant)
+		}
+		if existing, ok := tokens[rule.Tenant]; ok && existing != token {
+			return nil, fmt.Errorf("tenant %s has conflicting tokens", rule.Tenant)
+		}
+		tokens[rule.Tenant] = token
+	}
+	return tokens, nil
+}
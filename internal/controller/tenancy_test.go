@@ -0,0 +1,193 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestLoadTenantMapping(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            string
+		expectedMapping *TenantMapping
+		errorExpected   bool
+	}{{
+		name: "valid",
+		data: `
+rules:
+  - namespace: team-a
+    tenant: team-a
+    tokenFile: /etc/netbox/team-a/token
+  - namespaceLabels:
+      team: b
+    tenant: team-b
+`,
+		expectedMapping: &TenantMapping{
+			Rules: []TenantRule{{
+				Namespace: "team-a",
+				Tenant:    "team-a",
+				TokenFile: "/etc/netbox/team-a/token",
+			}, {
+				NamespaceLabels: map[string]string{"team": "b"},
+				Tenant:          "team-b",
+			}},
+		},
+	}, {
+		name: "rule without tenant",
+		data: `
+rules:
+  - namespace: team-a
+`,
+		errorExpected: true,
+	}, {
+		name: "rule without namespace selection",
+		data: `
+rules:
+  - tenant: team-a
+`,
+		errorExpected: true,
+	}, {
+		name: "unknown field",
+		data: `
+rules:
+  - namespace: team-a
+    tenant: team-a
+    token: foo
+`,
+		errorExpected: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tenants.yaml")
+			if err := os.WriteFile(path, []byte(test.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			mapping, err := LoadTenantMapping(path)
+			if test.errorExpected {
+				if err == nil {
+					t.Error("want an error, got nil")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("want no error, got %q", err)
+			}
+
+			if diff := cmp.Diff(test.expectedMapping, mapping); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTenantFor(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kubescheme.AddToScheme(scheme)
+
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "b-frontend", Labels: map[string]string{"team": "b"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shared", Labels: map[string]string{"team": "c"}}},
+	).Build()
+
+	mapping := &TenantMapping{
+		Rules: []TenantRule{{
+			Namespace: "team-a",
+			Tenant:    "team-a",
+		}, {
+			NamespaceLabels: map[string]string{"team": "b"},
+			Tenant:          "team-b",
+		}},
+	}
+
+	tests := []struct {
+		name           string
+		mapping        *TenantMapping
+		namespace      string
+		expectedTenant string
+	}{{
+		name:           "no mapping",
+		namespace:      "team-a",
+		expectedTenant: "",
+	}, {
+		name:           "by namespace name",
+		mapping:        mapping,
+		namespace:      "team-a",
+		expectedTenant: "team-a",
+	}, {
+		name:           "by namespace labels",
+		mapping:        mapping,
+		namespace:      "b-frontend",
+		expectedTenant: "team-b",
+	}, {
+		name:           "no matching rule",
+		mapping:        mapping,
+		namespace:      "shared",
+		expectedTenant: "",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tenant, err := test.mapping.TenantFor(context.Background(), kubeClient, test.namespace)
+			if err != nil {
+				t.Fatalf("want no error, got %q", err)
+			}
+			if tenant != test.expectedTenant {
+				t.Errorf("want tenant %q, got %q", test.expectedTenant, tenant)
+			}
+		})
+	}
+}
+
+func TestTenantTokens(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("abc123\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	mapping := &TenantMapping{
+		Rules: []TenantRule{{
+			Namespace: "team-a",
+			Tenant:    "team-a",
+			TokenFile: tokenFile,
+		}, {
+			Namespace: "team-b",
+			Tenant:    "team-b",
+		}},
+	}
+
+	tokens, err := mapping.Tokens()
+	if err != nil {
+		t.Fatalf("want no error, got %q", err)
+	}
+	if diff := cmp.Diff(map[string]string{"team-a": "abc123"}, tokens); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
@@ -39,8 +39,8 @@ import (
 )
 
 // IPs is a struct used to store the NetBoxIPs belonging to a pod or service.
-// A nil value means the pod or service does not have an IP of that scheme.
-// If dual stack is not enabled, at least one of the two IPs will be nil
+// A nil value means the pod or service does not have an IP of that scheme,
+// or that IPs of that scheme are not published.
 type IPs struct {
 	IPv4 *v1beta1.NetBoxIP
 	IPv6 *v1beta1.NetBoxIP
@@ -53,6 +53,23 @@ type NetBoxIPConfig struct {
 	DNSName          string
 	ReconcilerTags   []netbox.Tag
 	ReconcilerLabels map[string]bool
+	// Tenant is the slug of NetBox tenant of the IPs, if any
+	Tenant string
+	// ClusterTag is the name of the cluster, if any
+	ClusterTag string
+	// VRF is the name of NetBox VRF of the IPs, if any
+	VRF string
+	// CustomFields are the values of NetBox custom fields of the IPs
+	CustomFields map[string]string
+	// DescriptionStrategy is used to shorten descriptions longer than
+	// NetBox allows. Defaults to DescriptionStrategyTruncate.
+	DescriptionStrategy string
+	// ExternalName is the name that the IPs were resolved from,
+	// e.g. of an ExternalName service, if any
+	ExternalName string
+	// TagCache, if set, replaces the ReconcilerTags that
+	// it has resolved already with their values in NetBox
+	TagCache *TagCache
 }
 
 // CreateNetBoxIPs takes a slice of IP addresses in string form and creates
@@ -70,9 +87,22 @@ func CreateNetBoxIPs(ips []string, config NetBoxIPConfig) (*IPs, error) {
 	}
 	sort.Strings(labels)
 	labels = append([]string{fmt.Sprintf("namespace: %s", config.Object.GetNamespace())}, labels...)
+	keep := 1
+	if config.ExternalName != "" {
+		labels = append([]string{labels[0], fmt.Sprintf("external name: %s", config.ExternalName)}, labels[1:]...)
+		keep++
+	}
+	if config.ClusterTag != "" {
+		labels = append([]string{fmt.Sprintf("cluster: %s", config.ClusterTag)}, labels...)
+		keep++
+	}
+	desc, err := description(labels, keep, config.DescriptionStrategy)
+	if err != nil {
+		return &IPs{}, err
+	}
 
 	var tags []v1beta1.Tag
-	for _, tag := range config.ReconcilerTags {
+	for _, tag := range config.TagCache.Current(config.ReconcilerTags) {
 		tags = append(tags, v1beta1.Tag{
 			Name: tag.Name,
 			Slug: tag.Slug,
@@ -110,10 +140,13 @@ func CreateNetBoxIPs(ips []string, config NetBoxIPConfig) (*IPs, error) {
 				Finalizers: []string{netboxctrl.IPFinalizer},
 			},
 			Spec: v1beta1.NetBoxIPSpec{
-				Address:     addr,
-				DNSName:     config.DNSName,
-				Tags:        tags,
-				Description: strings.Join(labels, ", "),
+				Address:      addr,
+				DNSName:      config.DNSName,
+				Tags:         tags,
+				Description:  desc,
+				Tenant:       config.Tenant,
+				VRF:          config.VRF,
+				CustomFields: config.CustomFields,
 			},
 		}
 
@@ -169,7 +202,15 @@ func DeclareOwner(ip *v1beta1.NetBoxIP, obj client.Object) error {
 	return nil
 }
 
+// IsManual returns true if the NetBoxIP is marked with the ManualLabel as
+// created by hand rather than for a pod or service. Such NetBoxIPs must
+// never be updated or garbage-collected by reconcilers.
+func IsManual(ip *v1beta1.NetBoxIP) bool {
+	return ip.Labels[netboxctrl.ManualLabel] == "true"
+}
+
 // UpsertNetBoxIP creates or updates (if exists) the NetBoxIP provided.
+// A manually created NetBoxIP with the same name is left alone.
 func UpsertNetBoxIP(ctx context.Context, kubeClient client.Client, ll *log.Logger, ip *v1beta1.NetBoxIP) error {
 	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		var existingIP v1beta1.NetBoxIP
@@ -179,28 +220,149 @@ func UpsertNetBoxIP(ctx context.Context, kubeClient client.Client, ll *log.Logge
 				return fmt.Errorf("creating netboxip: %w", err)
 			}
 			ll.Info("created netboxip")
+			RecordOutcome(ctx, OutcomeCreated, ReasonPublished)
 			return nil
 		} else if err != nil {
 			return fmt.Errorf("retrieving netboxip: %w", err)
 		}
+		if IsManual(&existingIP) {
+			ll.Warn("not updating netboxip: it was created manually")
+			RecordOutcome(ctx, OutcomeNoop, ReasonManual)
+			return nil
+		}
 
-		if !ip.Spec.Changed(existingIP.Spec) {
+		spec := ip.Spec
+		if spec.AssignedObject == nil {
+			// the assigned object is only set by the pod controller for
+			// pods with MAC addresses, so it is kept otherwise, if it was
+			// set by hand or by another tool
+			spec.AssignedObject = existingIP.Spec.AssignedObject
+		}
+		if !spec.Changed(existingIP.Spec) {
 			return nil
 		}
 
-		existingIP.Spec = ip.Spec
+		addressChanged := existingIP.Spec.Address != spec.Address
+		var history []v1beta1.AddressHistoryEntry
+		if addressChanged {
+			existingIP.RecordAddress(metav1.Now())
+			history = existingIP.Status.History
+		}
+		existingIP.Spec = spec
 		existingIP.OwnerReferences = ip.OwnerReferences
 		existingIP.Finalizers = ip.Finalizers
 		existingIP.Labels = ip.Labels
-		if err := kubeClient.Update(ctx, &existingIP); err != nil {
+		err = kubeClient.Update(ctx, &existingIP)
+		if kubeerrors.IsInvalid(err) && addressChanged {
+			// the address may be immutable, see crd.WithImmutableAddress,
+			// so the NetBoxIP is deleted, and re-created when the update
+			// is retried, once the finalizer has removed its IP
+			if err := kubeClient.Delete(ctx, &existingIP); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("deleting netboxip to change its address: %w", err)
+			}
+			ll.Info("deleted netboxip to change its address")
+			return fmt.Errorf("replacing netboxip to change its address: %w", err)
+		}
+		if err != nil {
 			return fmt.Errorf("updating netboxip: %w", err)
 		}
+		if addressChanged {
+			// the status is a subresource, which is not written by
+			// the update; it is patched rather than updated, so that
+			// it does not conflict with the status written by the
+			// netboxip reconciler
+			patch := client.MergeFrom(existingIP.DeepCopy())
+			existingIP.Status.History = history
+			if err := kubeClient.Status().Patch(ctx, &existingIP, patch); err != nil {
+				return fmt.Errorf("recording previous address of netboxip: %w", err)
+			}
+		}
 		ll.Info("updated netboxip")
+		RecordOutcome(ctx, OutcomeUpdated, ReasonPublished)
 
 		return nil
 	})
 }
 
+// PublishedFamilies returns the IPs that are not of a skipped family,
+// keeping the ones that are not valid addresses, e.g. "None".
+func PublishedFamilies(ips []string, skipIPv4, skipIPv6 bool) []string {
+	var published []string
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			if (skipIPv4 && Scheme(addr) == "ipv4") || (skipIPv6 && Scheme(addr) == "ipv6") {
+				continue
+			}
+		}
+		published = append(published, ip)
+	}
+	return published
+}
+
+// MigrateUnsuffixedNetBoxIP deletes the NetBoxIP of obj that is named
+// without the suffix of its IP family, as by older versions, if there is one.
+// If ips has a NetBoxIP of the same family that has not been published yet,
+// that NetBoxIP takes over the IP of the unsuffixed one in NetBox, rather
+// than creating another one, and the IP is kept when the unsuffixed NetBoxIP
+// is deleted. It must be called before the NetBoxIPs in ips are upserted.
+func MigrateUnsuffixedNetBoxIP(ctx context.Context, kubeClient client.Client, ll *log.Logger, obj client.Object, ips *IPs) error {
+	var unsuffixed v1beta1.NetBoxIP
+	err := kubeClient.Get(ctx, client.ObjectKey{Namespace: obj.GetNamespace(), Name: NetBoxIPName(obj, "")}, &unsuffixed)
+	if kubeerrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("retrieving unsuffixed netboxip: %w", err)
+	}
+	if IsManual(&unsuffixed) || !unsuffixed.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	ll = ll.With(log.String("netboxip", unsuffixed.Name))
+
+	successor := ips.IPv4
+	if Scheme(unsuffixed.Spec.Address) == "ipv6" {
+		successor = ips.IPv6
+	}
+	takeOver := successor != nil && successor.Spec.Address == unsuffixed.Spec.Address
+	if takeOver {
+		var existing v1beta1.NetBoxIP
+		err := kubeClient.Get(ctx, client.ObjectKeyFromObject(successor), &existing)
+		switch {
+		case kubeerrors.IsNotFound(err):
+			if successor.Annotations == nil {
+				successor.Annotations = make(map[string]string)
+			}
+			successor.Annotations[netboxctrl.TakeOverAnnotation] = string(unsuffixed.UID)
+		case err != nil:
+			return fmt.Errorf("retrieving netboxip: %w", err)
+		case existing.Status.NetBoxID != 0:
+			// the successor already has an IP of its own in NetBox
+			takeOver = false
+		default:
+			patch := client.MergeFrom(existing.DeepCopy())
+			if existing.Annotations == nil {
+				existing.Annotations = make(map[string]string)
+			}
+			existing.Annotations[netboxctrl.TakeOverAnnotation] = string(unsuffixed.UID)
+			if err := kubeClient.Patch(ctx, &existing, patch); err != nil {
+				return fmt.Errorf("annotating netboxip: %w", err)
+			}
+		}
+	}
+
+	if takeOver && controllerutil.RemoveFinalizer(&unsuffixed, netboxctrl.IPFinalizer) {
+		// the IP in NetBox is kept for the successor to take over
+		if err := kubeClient.Update(ctx, &unsuffixed); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("removing finalizer of unsuffixed netboxip: %w", err)
+		}
+	}
+	if err := kubeClient.Delete(ctx, &unsuffixed); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("deleting unsuffixed netboxip: %w", err)
+	}
+	ll.Info("migrated unsuffixed netboxip", log.Bool("takenOver", takeOver))
+	RecordOutcome(ctx, OutcomeDeleted, ReasonMigrated)
+	return nil
+}
+
 // HasPublishLabels checks if the given object labels contain any of the publish labels
 // (i.e. labels that indicate its IP should be exported).
 func HasPublishLabels(publishLabels map[string]bool, objLabels map[string]string) bool {
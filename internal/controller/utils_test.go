@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"context"
 	"net/netip"
 	"testing"
 
@@ -10,9 +11,16 @@ import (
 	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	log "go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 )
 
 func TestCreateNetBoxIPs(t *testing.T) {
@@ -121,6 +129,47 @@ func TestCreateNetBoxIPs(t *testing.T) {
 				},
 			},
 		},
+	}, {
+		name: "with cluster tag and tenant",
+		ips:  []string{"192.168.0.1"},
+		config: NetBoxIPConfig{
+			Object: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "Pod",
+					APIVersion: "v1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "testpod",
+					Namespace: "testnamespace",
+					UID:       types.UID("abc123"),
+					Labels:    map[string]string{"a": "baz"},
+				},
+			},
+			ReconcilerLabels: map[string]bool{"a": true},
+			ClusterTag:       "prod-1",
+			Tenant:           "team-a",
+		},
+		expectedIPs: &IPs{
+			IPv4: &v1beta1.NetBoxIP{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       netboxcrd.NetBoxIPKind,
+					APIVersion: "v1beta1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "pod-abc123-ipv4",
+					Namespace: "testnamespace",
+					Labels: map[string]string{
+						netboxctrl.NameLabel: "testpod",
+					},
+					Finalizers: []string{netboxctrl.IPFinalizer},
+				},
+				Spec: v1beta1.NetBoxIPSpec{
+					Address:     netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+					Description: "cluster: prod-1, namespace: testnamespace, a: baz",
+					Tenant:      "team-a",
+				},
+			},
+		},
 	}}
 
 	for _, test := range tests {
@@ -141,3 +190,225 @@ func TestCreateNetBoxIPs(t *testing.T) {
 		})
 	}
 }
+
+func TestUpsertNetBoxIPKeepsManualNetBoxIP(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	manual := &v1beta1.NetBoxIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-abc123",
+			Namespace: "testnamespace",
+			Labels:    map[string]string{netboxctrl.ManualLabel: "true"},
+		},
+		Spec: v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("10.0.0.1")},
+	}
+	kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(manual).Build()
+
+	ctx, outcome := WithOutcome(context.Background())
+	err := UpsertNetBoxIP(ctx, kubeClient, log.L(), &v1beta1.NetBoxIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-abc123", Namespace: "testnamespace"},
+		Spec:       v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("192.168.0.1")},
+	})
+	if err != nil {
+		t.Fatalf("upserting NetBoxIP: %q", err)
+	}
+
+	var ip v1beta1.NetBoxIP
+	if err := kubeClient.Get(ctx, client.ObjectKeyFromObject(manual), &ip); err != nil {
+		t.Fatalf("retrieving NetBoxIP: %q", err)
+	}
+	if ip.Spec.Address != manual.Spec.Address {
+		t.Errorf("want address %s, got %s", manual.Spec.Address, ip.Spec.Address)
+	}
+	if outcome.Reason != ReasonManual {
+		t.Errorf("want reason %s, got %s", ReasonManual, outcome.Reason)
+	}
+}
+
+func TestUpsertNetBoxIPReplacesImmutableAddress(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	existing := &v1beta1.NetBoxIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-abc123", Namespace: "testnamespace"},
+		Spec:       v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("10.0.0.1")},
+	}
+	// as rejected by the validation rule of crd.WithImmutableAddress
+	kubeClient := interceptor.NewClient(fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build(), interceptor.Funcs{
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			return kubeerrors.NewInvalid(schema.GroupKind{Group: "netbox.digitalocean.com", Kind: "NetBoxIP"}, obj.GetName(), nil)
+		},
+	})
+
+	ip := &v1beta1.NetBoxIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-abc123", Namespace: "testnamespace"},
+		Spec:       v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("10.0.0.2")},
+	}
+	if err := UpsertNetBoxIP(context.Background(), kubeClient, log.L(), ip); err == nil {
+		t.Fatal("want an error, so that the upsert is retried, got nil")
+	}
+	err := kubeClient.Get(context.Background(), client.ObjectKeyFromObject(ip), &v1beta1.NetBoxIP{})
+	if !kubeerrors.IsNotFound(err) {
+		t.Fatalf("want NetBoxIP to be deleted, got %v", err)
+	}
+
+	// once the NetBoxIP is gone, it is re-created with the new address
+	if err := UpsertNetBoxIP(context.Background(), kubeClient, log.L(), ip); err != nil {
+		t.Fatalf("upserting NetBoxIP: %q", err)
+	}
+	var created v1beta1.NetBoxIP
+	if err := kubeClient.Get(context.Background(), client.ObjectKeyFromObject(ip), &created); err != nil {
+		t.Fatalf("retrieving NetBoxIP: %q", err)
+	}
+	if created.Spec.Address != ip.Spec.Address {
+		t.Errorf("want address %s, got %s", ip.Spec.Address, created.Spec.Address)
+	}
+}
+
+func TestPublishedFamilies(t *testing.T) {
+	ips := []string{"192.168.0.1", "fd00::1", "None"}
+
+	tests := []struct {
+		name        string
+		skipIPv4    bool
+		skipIPv6    bool
+		expectedIPs []string
+	}{{
+		name:        "both families",
+		expectedIPs: []string{"192.168.0.1", "fd00::1", "None"},
+	}, {
+		name:        "IPv4 only",
+		skipIPv6:    true,
+		expectedIPs: []string{"192.168.0.1", "None"},
+	}, {
+		name:        "IPv6 only",
+		skipIPv4:    true,
+		expectedIPs: []string{"fd00::1", "None"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			published := PublishedFamilies(ips, test.skipIPv4, test.skipIPv6)
+			if diff := cmp.Diff(test.expectedIPs, published); diff != "" {
+				t.Errorf("published IPs (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMigrateUnsuffixedNetBoxIP(t *testing.T) {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "testpod", Namespace: "testnamespace", UID: types.UID("abc123")},
+	}
+	unsuffixed := func(labels map[string]string) *v1beta1.NetBoxIP {
+		return &v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "pod-abc123",
+				Namespace:  "testnamespace",
+				UID:        types.UID("old-uid"),
+				Labels:     labels,
+				Finalizers: []string{netboxctrl.IPFinalizer},
+			},
+			Spec: v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("192.168.0.1")},
+		}
+	}
+	successor := func(netboxID int64) *v1beta1.NetBoxIP {
+		return &v1beta1.NetBoxIP{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-abc123-ipv4", Namespace: "testnamespace"},
+			Spec:       v1beta1.NetBoxIPSpec{Address: netip.MustParseAddr("192.168.0.1")},
+			Status:     v1beta1.NetBoxIPStatus{NetBoxID: netboxID},
+		}
+	}
+
+	tests := []struct {
+		name               string
+		existing           []client.Object
+		ips                *IPs
+		expectedTakeOver   bool
+		expectedRemoved    bool
+		expectedDeleting   bool
+		expectedUntouched  bool
+		expectedOutcomeSet bool
+	}{{
+		name: "no unsuffixed netboxip",
+		ips:  &IPs{IPv4: successor(0)},
+	}, {
+		name:               "successor not created yet",
+		existing:           []client.Object{unsuffixed(nil)},
+		ips:                &IPs{IPv4: successor(0)},
+		expectedTakeOver:   true,
+		expectedRemoved:    true,
+		expectedOutcomeSet: true,
+	}, {
+		name:               "successor not published yet",
+		existing:           []client.Object{unsuffixed(nil), successor(0)},
+		ips:                &IPs{IPv4: successor(0)},
+		expectedTakeOver:   true,
+		expectedRemoved:    true,
+		expectedOutcomeSet: true,
+	}, {
+		name:               "successor already published",
+		existing:           []client.Object{unsuffixed(nil), successor(5)},
+		ips:                &IPs{IPv4: successor(0)},
+		expectedDeleting:   true,
+		expectedOutcomeSet: true,
+	}, {
+		name:               "family no longer published",
+		existing:           []client.Object{unsuffixed(nil)},
+		ips:                &IPs{},
+		expectedDeleting:   true,
+		expectedOutcomeSet: true,
+	}, {
+		name:              "manual",
+		existing:          []client.Object{unsuffixed(map[string]string{netboxctrl.ManualLabel: "true"})},
+		ips:               &IPs{IPv4: successor(0)},
+		expectedUntouched: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeClient := fakeclient.NewClientBuilder().WithScheme(scheme).
+				WithStatusSubresource(&v1beta1.NetBoxIP{}).WithObjects(test.existing...).Build()
+
+			ctx, outcome := WithOutcome(context.Background())
+			if err := MigrateUnsuffixedNetBoxIP(ctx, kubeClient, log.L(), pod, test.ips); err != nil {
+				t.Fatalf("migrating unsuffixed NetBoxIP: %q", err)
+			}
+
+			var old v1beta1.NetBoxIP
+			err := kubeClient.Get(ctx, client.ObjectKey{Namespace: "testnamespace", Name: "pod-abc123"}, &old)
+			switch {
+			case test.expectedRemoved:
+				if !kubeerrors.IsNotFound(err) {
+					t.Errorf("want unsuffixed NetBoxIP removed, got %v", err)
+				}
+			case test.expectedDeleting:
+				if err != nil || old.DeletionTimestamp.IsZero() || len(old.Finalizers) == 0 {
+					t.Errorf("want unsuffixed NetBoxIP deleted with its finalizer, got %v, %v", err, old.Finalizers)
+				}
+			case test.expectedUntouched:
+				if err != nil || !old.DeletionTimestamp.IsZero() {
+					t.Errorf("want unsuffixed NetBoxIP kept, got %v", err)
+				}
+			}
+
+			takeOver := test.ips.IPv4 != nil && test.ips.IPv4.Annotations[netboxctrl.TakeOverAnnotation] == "old-uid"
+			var stored v1beta1.NetBoxIP
+			if err := kubeClient.Get(ctx, client.ObjectKey{Namespace: "testnamespace", Name: "pod-abc123-ipv4"}, &stored); err == nil {
+				takeOver = takeOver || stored.Annotations[netboxctrl.TakeOverAnnotation] == "old-uid"
+			}
+			if takeOver != test.expectedTakeOver {
+				t.Errorf("want take over: %t, got %t", test.expectedTakeOver, takeOver)
+			}
+
+			if (outcome.Reason == ReasonMigrated) != test.expectedOutcomeSet {
+				t.Errorf("want migrated outcome: %t, got reason %q", test.expectedOutcomeSet, outcome.Reason)
+			}
+		})
+	}
+}
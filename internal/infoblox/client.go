@@ -0,0 +1,585 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package infoblox implements the IPAM client interface on top of Infoblox WAPI.
+package infoblox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/metrics"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+	"github.com/digitalocean/netbox-ip-controller/internal/ratelimit"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	log "go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// UIDAttribute is the name of the extensible attribute of host records
+	// containing the UID of the resource that an IP is assigned to.
+	UIDAttribute = "netbox-ip-controller-uid"
+	// TagsAttribute is the name of the extensible attribute of host records
+	// containing the comma-separated names of the tags of an IP.
+	TagsAttribute = "netbox-ip-controller-tags"
+
+	// max size of response body that we ever expect to get, in bytes
+	responseBodySizeLimit = 1 << 20
+
+	hostReturnFields = "name,comment,extattrs,ipv4addrs,ipv6addrs,network_view"
+
+	// listPageSize is the number of host records requested at once when listing IPs
+	listPageSize = 500
+)
+
+var errNotFound = errors.New("not found")
+
+type client struct {
+	httpClient  *retryablehttp.Client
+	baseURL     string
+	username    string
+	password    string
+	networkView string
+	rateLimiter *rate.Limiter
+	logger      *log.Logger
+}
+
+// ClientOption is a function type to pass options to NewClient
+type ClientOption func(*client) error
+
+// NewClient returns a client that stores IPs in Infoblox as host records
+// that are not configured for DNS, with the UID and the tags of an IP stored
+// in the UIDAttribute and TagsAttribute extensible attributes.
+// apiURL is the versioned WAPI URL, e.g. https://gm.example.com/wapi/v2.11.
+// Requests are authenticated with the given username and password.
+func NewClient(apiURL, username, password string, opts ...ClientOption) (netbox.Client, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse WAPI URL: %w", err)
+	} else if !u.IsAbs() || u.Hostname() == "" {
+		return nil, errors.New("WAPI URL must be in scheme://host:port/wapi/<version> format")
+	}
+
+	c := &client{
+		httpClient: retryablehttp.NewClient(),
+		baseURL:    strings.TrimSuffix(u.String(), "/"),
+		username:   username,
+		password:   password,
+		logger:     log.L(),
+	}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	c.httpClient.RetryMax = 5
+	c.httpClient.Logger = nil
+
+	if c.rateLimiter == nil {
+		c.rateLimiter = rate.NewLimiter(rate.Inf, 1)
+	}
+
+	return c, nil
+}
+
+// WithLogger sets the logger to be used by the client.
+func WithLogger(logger *log.Logger) ClientOption {
+	return func(c *client) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithRateLimiter attaches a token bucket style rate limiter to the client.
+func WithRateLimiter(refillRate rate.Limit, bucketSize int) ClientOption {
+	return func(c *client) error {
+		c.rateLimiter = rate.NewLimiter(refillRate, bucketSize)
+		return nil
+	}
+}
+
+// WithSharedRateLimiter attaches the given rate limiter to the client.
+// Its limit and burst may be changed while the client is in use.
+func WithSharedRateLimiter(limiter *rate.Limiter) ClientOption {
+	return func(c *client) error {
+		c.rateLimiter = limiter
+		return nil
+	}
+}
+
+// WithNetworkView sets the network view in which host records are created
+// and looked up. If not set, the default network view is used.
+func WithNetworkView(view string) ClientOption {
+	return func(c *client) error {
+		c.networkView = view
+		return nil
+	}
+}
+
+type extAttr struct {
+	Value string `json:"value"`
+}
+
+type hostAddr struct {
+	IPv4Addr string `json:"ipv4addr,omitempty"`
+	IPv6Addr string `json:"ipv6addr,omitempty"`
+}
+
+type hostRecord struct {
+	Ref             string             `json:"_ref,omitempty"`
+	Name            string             `json:"name"`
+	Comment         string             `json:"comment"`
+	ExtAttrs        map[string]extAttr `json:"extattrs"`
+	IPv4Addrs       []hostAddr         `json:"ipv4addrs"`
+	IPv6Addrs       []hostAddr         `json:"ipv6addrs"`
+	NetworkView     string             `json:"network_view,omitempty"`
+	ConfigureForDNS *bool              `json:"configure_for_dns,omitempty"`
+}
+
+type attributeDef struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// UpsertUIDField creates extensible attribute definitions for the UID
+// and the tags of IPs, if they do not exist yet.
+func (c *client) UpsertUIDField(ctx context.Context) error {
+	for _, name := range []string{UIDAttribute, TagsAttribute} {
+		query := url.Values{}
+		query.Set("name", name)
+
+		var defs []attributeDef
+		if err := c.executeRequest(ctx, http.MethodGet, "/extensibleattributedef?"+query.Encode(), nil, &defs); err != nil {
+			return fmt.Errorf("retrieving extensible attribute %s: %w", name, err)
+		}
+		if len(defs) > 0 {
+			c.logger.Info("extensible attribute already exists", log.String("name", name))
+			continue
+		}
+
+		def := attributeDef{
+			Name:    name,
+			Type:    "STRING",
+			Comment: "managed by netbox-ip-controller",
+		}
+		if err := c.executeRequest(ctx, http.MethodPost, "/extensibleattributedef", def, nil); err != nil {
+			return fmt.Errorf("creating extensible attribute %s: %w", name, err)
+		}
+		c.logger.Info("created extensible attribute", log.String("name", name))
+	}
+	return nil
+}
+
+// GetTag returns a tag with the given name. Tags are stored in Infoblox as names
+// in an extensible attribute, so every tag exists.
+func (c *client) GetTag(_ context.Context, tag string) (*netbox.Tag, error) {
+	return &netbox.Tag{Name: tag, Slug: tag}, nil
+}
+
+// CreateTag returns a tag with the given name, see GetTag.
+func (c *client) CreateTag(_ context.Context, tag string) (*netbox.Tag, error) {
+	return &netbox.Tag{Name: tag, Slug: tag}, nil
+}
+
+// GetIP returns an IP address with the given UID.
+func (c *client) GetIP(ctx context.Context, uid netbox.UID) (*netbox.IPAddress, error) {
+	host, err := c.getHost(ctx, uid)
+	if err != nil || host == nil {
+		return nil, err
+	}
+	return toIPAddress(host)
+}
+
+func (c *client) getHost(ctx context.Context, uid netbox.UID) (*hostRecord, error) {
+	query := url.Values{}
+	query.Set("*"+UIDAttribute, string(uid))
+	query.Set("_return_fields", hostReturnFields)
+	if c.networkView != "" {
+		query.Set("network_view", c.networkView)
+	}
+
+	var hosts []hostRecord
+	if err := c.executeRequest(ctx, http.MethodGet, "/record:host?"+query.Encode(), nil, &hosts); err != nil {
+		return nil, fmt.Errorf("retrieving host records: %w", err)
+	}
+
+	if len(hosts) > 1 {
+		return nil, fmt.Errorf("more than one IP with UID %q found", uid)
+	}
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	return &hosts[0], nil
+}
+
+// ListIPs returns the IPs of the host records in the network view of the
+// client that match filter, requesting them in pages. Apart from selecting
+// managed IPs, filtering is done by the client, and custom fields are not supported.
+func (c *client) ListIPs(ctx context.Context, filter netbox.IPFilter) ([]netbox.IPAddress, error) {
+	if filter.CustomField != "" {
+		return nil, errors.New("filtering IPs by custom fields is not supported by Infoblox")
+	}
+
+	query := url.Values{}
+	query.Set("_return_fields", hostReturnFields)
+	query.Set("_paging", "1")
+	query.Set("_return_as_object", "1")
+	query.Set("_max_results", fmt.Sprint(listPageSize))
+	if filter.Managed {
+		query.Set("*"+UIDAttribute+"~", ".")
+	}
+	if c.networkView != "" {
+		query.Set("network_view", c.networkView)
+	}
+
+	var ips []netbox.IPAddress
+	for {
+		var page struct {
+			Result     []hostRecord `json:"result"`
+			NextPageID string       `json:"next_page_id"`
+		}
+		if err := c.executeRequest(ctx, http.MethodGet, "/record:host?"+query.Encode(), nil, &page); err != nil {
+			return nil, fmt.Errorf("retrieving host records: %w", err)
+		}
+		for i := range page.Result {
+			ip, err := toIPAddress(&page.Result[i])
+			if err != nil {
+				// e.g. host records with several addresses, which are not managed by the controller
+				c.logger.Debug("skipping host record", log.String("name", page.Result[i].Name), log.Error(err))
+				continue
+			}
+			if filter.Matches(ip) {
+				ips = append(ips, *ip)
+			}
+		}
+		if page.NextPageID == "" {
+			return ips, nil
+		}
+		query = url.Values{}
+		query.Set("_page_id", page.NextPageID)
+	}
+}
+
+// getOwnedHost returns the host record with the given UID, like getHost,
+// before it is deleted or released. Since looking it up by its UID and
+// changing it are separate requests, the record is read again by its
+// reference, and nil is returned if its UID no longer matches. The re-read
+// and the change are still separate requests, so this narrows the window
+// in which a re-used record may be changed, but does not close it.
+func (c *client) getOwnedHost(ctx context.Context, uid netbox.UID) (*hostRecord, error) {
+	host, err := c.getHost(ctx, uid)
+	if err != nil || host == nil {
+		return host, err
+	}
+
+	query := url.Values{}
+	query.Set("_return_fields", hostReturnFields)
+	var current hostRecord
+	if err := c.executeRequest(ctx, http.MethodGet, "/"+host.Ref+"?"+query.Encode(), nil, &current); errors.Is(err, errNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("retrieving host record: %w", err)
+	}
+	if current.ExtAttrs[UIDAttribute].Value != string(uid) {
+		c.logger.Warn("not changing IP: its UID no longer matches",
+			log.String("uid", string(uid)), log.String("ref", host.Ref), log.String("currentUID", current.ExtAttrs[UIDAttribute].Value))
+		metrics.IncrementUIDMismatches()
+		return nil, nil
+	}
+	current.Ref = host.Ref
+	return &current, nil
+}
+
+// UpsertIP creates a host record for an IP address, or updates one,
+// if a host record with the same UID already exists.
+func (c *client) UpsertIP(ctx context.Context, ip *netbox.IPAddress) (*netbox.IPAddress, netbox.UpsertResult, error) {
+	existing, err := c.getHost(ctx, ip.UID)
+	if err != nil {
+		return nil, netbox.UpsertUnchanged, fmt.Errorf("checking for existing IP: %w", err)
+	}
+	if existing == nil && ip.TakeOverUID != "" {
+		if existing, err = c.getHost(ctx, ip.TakeOverUID); err != nil {
+			return nil, netbox.UpsertUnchanged, fmt.Errorf("checking for IP to take over: %w", err)
+		}
+	}
+
+	desired := toHostRecord(ip)
+
+	if existing != nil {
+		if !hostChanged(existing, &desired) {
+			c.logger.Info("IP has not changed - not updating")
+			unchanged, err := toIPAddress(existing)
+			return unchanged, netbox.UpsertUnchanged, err
+		}
+
+		// keep extensible attributes that are not managed by the controller,
+		// since WAPI replaces all of them on update
+		for name, attr := range existing.ExtAttrs {
+			if _, ok := desired.ExtAttrs[name]; !ok && name != TagsAttribute {
+				desired.ExtAttrs[name] = attr
+			}
+		}
+
+		// name, comment, extensible attributes and addresses can all be updated in place
+		if err := c.executeRequest(ctx, http.MethodPut, "/"+existing.Ref, desired, nil); err != nil {
+			return nil, netbox.UpsertUnchanged, fmt.Errorf("updating IP: %w", err)
+		}
+		upserted, err := toIPAddress(&desired)
+		return upserted, netbox.UpsertUpdated, err
+	}
+
+	desired.NetworkView = c.networkView
+	configureForDNS := false
+	desired.ConfigureForDNS = &configureForDNS
+	if err := c.executeRequest(ctx, http.MethodPost, "/record:host", desired, nil); err != nil {
+		return nil, netbox.UpsertUnchanged, fmt.Errorf("creating IP: %w", err)
+	}
+	upserted, err := toIPAddress(&desired)
+	return upserted, netbox.UpsertCreated, err
+}
+
+// DeleteIP deletes the host record of an IP with the given UID from Infoblox.
+func (c *client) DeleteIP(ctx context.Context, uid netbox.UID) error {
+	existing, err := c.getOwnedHost(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("checking if IP exists: %w", err)
+	}
+	if existing == nil {
+		return nil
+	}
+
+	if err := c.executeRequest(ctx, http.MethodDelete, "/"+existing.Ref, nil, nil); err != nil {
+		return fmt.Errorf("deleting IP: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIP removes the UID attribute from the host record
+// of an IP with the given UID in Infoblox.
+func (c *client) ReleaseIP(ctx context.Context, uid netbox.UID) error {
+	existing, err := c.getOwnedHost(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("checking if IP exists: %w", err)
+	}
+	if existing == nil {
+		return nil
+	}
+
+	// "extattrs-" removes the given attributes, keeping the others
+	body := map[string]interface{}{
+		"extattrs-": map[string]interface{}{UIDAttribute: map[string]interface{}{}},
+	}
+	if err := c.executeRequest(ctx, http.MethodPut, "/"+existing.Ref, body, nil); err != nil {
+		return fmt.Errorf("releasing IP: %w", err)
+	}
+	return nil
+}
+
+// DeprecateIP appends note to the comment of the host record with the
+// given UID and removes its UID attribute. Host records have no status,
+// so the record otherwise stays as it is.
+func (c *client) DeprecateIP(ctx context.Context, uid netbox.UID, note string) error {
+	existing, err := c.getOwnedHost(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("checking if IP exists: %w", err)
+	}
+	if existing == nil {
+		return nil
+	}
+
+	body := map[string]interface{}{
+		"comment":   netbox.AppendNote(existing.Comment, note),
+		"extattrs-": map[string]interface{}{UIDAttribute: map[string]interface{}{}},
+	}
+	if err := c.executeRequest(ctx, http.MethodPut, "/"+existing.Ref, body, nil); err != nil {
+		return fmt.Errorf("deprecating IP: %w", err)
+	}
+	return nil
+}
+
+// executeRequest sends a request to Infoblox WAPI, and unmarshals
+// the response into out, unless it is nil.
+func (c *client) executeRequest(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("marshaling body: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.username, c.password)
+
+	if err := ratelimit.Limiter(ctx, c.rateLimiter).Wait(ctx); err != nil {
+		return err
+	}
+
+	var res *http.Response
+	if method == http.MethodPost {
+		// non-idempotent method - we should not retry it
+		res, err = c.httpClient.HTTPClient.Do(req)
+	} else {
+		var retryableReq *retryablehttp.Request
+		if retryableReq, err = retryablehttp.FromRequest(req); err != nil {
+			return fmt.Errorf("creating retryable request: %w", err)
+		}
+		res, err = c.httpClient.Do(retryableReq)
+	}
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, responseBodySizeLimit))
+	if err != nil {
+		return errors.New("reading response data")
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		// WAPI errors look like {"Error": "AdmConProtoError: ...", "code": "Client.Ibap.Proto", "text": "..."}
+		var wapiErr struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(data, &wapiErr); err == nil && wapiErr.Text != "" {
+			return fmt.Errorf("%s: %s", res.Status, wapiErr.Text)
+		}
+		return fmt.Errorf("%s: %s", res.Status, string(data))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unmarshaling response: %w", err)
+	}
+	return nil
+}
+
+func toHostRecord(ip *netbox.IPAddress) hostRecord {
+	host := hostRecord{
+		Name:    ip.DNSName,
+		Comment: ip.Description,
+		ExtAttrs: map[string]extAttr{
+			UIDAttribute: {Value: string(ip.UID)},
+		},
+		IPv4Addrs: []hostAddr{},
+		IPv6Addrs: []hostAddr{},
+	}
+	if host.Name == "" {
+		// host records must have a name, but since they are not
+		// configured for DNS, it does not have to be resolvable
+		host.Name = string(ip.UID)
+	}
+	if tags := tagsString(ip.Tags); tags != "" {
+		host.ExtAttrs[TagsAttribute] = extAttr{Value: tags}
+	}
+
+	addr := netip.Addr(ip.Address)
+	if addr.Is4() {
+		host.IPv4Addrs = append(host.IPv4Addrs, hostAddr{IPv4Addr: addr.String()})
+	} else {
+		host.IPv6Addrs = append(host.IPv6Addrs, hostAddr{IPv6Addr: addr.String()})
+	}
+	return host
+}
+
+func toIPAddress(host *hostRecord) (*netbox.IPAddress, error) {
+	var addrs []string
+	for _, a := range host.IPv4Addrs {
+		addrs = append(addrs, a.IPv4Addr)
+	}
+	for _, a := range host.IPv6Addrs {
+		addrs = append(addrs, a.IPv6Addr)
+	}
+	if len(addrs) != 1 {
+		return nil, fmt.Errorf("host record %s must have exactly one address, but has %d", host.Name, len(addrs))
+	}
+	addr, err := netip.ParseAddr(addrs[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing address: %w", err)
+	}
+
+	ip := &netbox.IPAddress{
+		UID:         netbox.UID(host.ExtAttrs[UIDAttribute].Value),
+		DNSName:     host.Name,
+		Address:     netbox.IP(addr),
+		Description: host.Comment,
+	}
+	if ip.DNSName == string(ip.UID) {
+		ip.DNSName = ""
+	}
+	for _, name := range strings.Split(host.ExtAttrs[TagsAttribute].Value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ip.Tags = append(ip.Tags, netbox.Tag{Name: name, Slug: name})
+		}
+	}
+	return ip, nil
+}
+
+// hostChanged returns true if any of the fields managed
+// by the controller differ between the two host records.
+func hostChanged(existing, desired *hostRecord) bool {
+	if existing.Name != desired.Name || existing.Comment != desired.Comment {
+		return true
+	}
+	for _, name := range []string{UIDAttribute, TagsAttribute} {
+		if existing.ExtAttrs[name] != desired.ExtAttrs[name] {
+			return true
+		}
+	}
+
+	existingIP, err := toIPAddress(existing)
+	if err != nil {
+		return true
+	}
+	desiredIP, err := toIPAddress(desired)
+	if err != nil {
+		return true
+	}
+	return netip.Addr(existingIP.Address) != netip.Addr(desiredIP.Address)
+}
+
+func tagsString(tags []netbox.Tag) string {
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
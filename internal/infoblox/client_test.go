@@ -0,0 +1,402 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infoblox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// fakeServer is a minimal in-memory implementation of Infoblox WAPI.
+type fakeServer struct {
+	mu         sync.Mutex
+	attributes map[string]bool
+	hosts      map[string]hostRecord
+	nextRef    int
+	// reuseAfterLookup, if not empty, is the UID the host records found
+	// by their UID are given after the lookup, as if they were re-used
+	reuseAfterLookup string
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reply := func(code int, data interface{}) {
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(data)
+	}
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+		reply(http.StatusUnauthorized, map[string]string{"text": "Authorization Required"})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/wapi/v2.11/")
+	switch {
+	case r.Method == http.MethodGet && path == "extensibleattributedef":
+		defs := []attributeDef{}
+		if name := r.URL.Query().Get("name"); s.attributes[name] {
+			defs = append(defs, attributeDef{Name: name, Type: "STRING"})
+		}
+		reply(http.StatusOK, defs)
+	case r.Method == http.MethodPost && path == "extensibleattributedef":
+		var def attributeDef
+		if err := json.NewDecoder(r.Body).Decode(&def); err != nil || s.attributes[def.Name] {
+			reply(http.StatusBadRequest, map[string]string{"text": "duplicate"})
+			return
+		}
+		s.attributes[def.Name] = true
+		reply(http.StatusCreated, "extensibleattributedef/"+def.Name)
+	case r.Method == http.MethodGet && path == "record:host" && (r.URL.Query().Has("_paging") || r.URL.Query().Has("_page_id")):
+		// pages of one host record, with the index of the next one as the page ID
+		var refs []string
+		for ref, h := range s.hosts {
+			if r.URL.Query().Has("_page_id") || !r.URL.Query().Has("*"+UIDAttribute+"~") || h.ExtAttrs[UIDAttribute].Value != "" {
+				refs = append(refs, ref)
+			}
+		}
+		sort.Strings(refs)
+		next, _ := strconv.Atoi(r.URL.Query().Get("_page_id"))
+		page := map[string]interface{}{"result": []hostRecord{}}
+		if next < len(refs) {
+			page["result"] = []hostRecord{s.hosts[refs[next]]}
+		}
+		if next+1 < len(refs) {
+			page["next_page_id"] = strconv.Itoa(next + 1)
+		}
+		reply(http.StatusOK, page)
+	case r.Method == http.MethodGet && path == "record:host":
+		hosts := []hostRecord{}
+		for _, h := range s.hosts {
+			if h.ExtAttrs[UIDAttribute].Value == r.URL.Query().Get("*"+UIDAttribute) {
+				hosts = append(hosts, h)
+				if s.reuseAfterLookup != "" {
+					s.hosts[h.Ref].ExtAttrs[UIDAttribute] = extAttr{Value: s.reuseAfterLookup}
+				}
+			}
+		}
+		reply(http.StatusOK, hosts)
+	case r.Method == http.MethodPost && path == "record:host":
+		var h hostRecord
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil || h.ConfigureForDNS == nil || *h.ConfigureForDNS {
+			reply(http.StatusBadRequest, map[string]string{"text": "invalid host record"})
+			return
+		}
+		s.nextRef++
+		h.Ref = fmt.Sprintf("record:host/%d:%s/default", s.nextRef, h.Name)
+		h.ConfigureForDNS = nil
+		s.hosts[h.Ref] = h
+		reply(http.StatusCreated, h.Ref)
+	case strings.HasPrefix(path, "record:host/"):
+		if _, ok := s.hosts[path]; !ok {
+			reply(http.StatusNotFound, map[string]string{"text": "Reference not found"})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			reply(http.StatusOK, s.hosts[path])
+		case http.MethodPut:
+			var h hostRecord
+			if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+				reply(http.StatusBadRequest, map[string]string{"text": "invalid host record"})
+				return
+			}
+			h.Ref = path
+			s.hosts[path] = h
+			reply(http.StatusOK, path)
+		case http.MethodDelete:
+			delete(s.hosts, path)
+			reply(http.StatusOK, path)
+		default:
+			reply(http.StatusMethodNotAllowed, nil)
+		}
+	default:
+		reply(http.StatusNotFound, map[string]string{"text": "not found"})
+	}
+}
+
+func newTestClient(t *testing.T, s *fakeServer) netbox.Client {
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient(srv.URL+"/wapi/v2.11", "admin", "secret")
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+	return c
+}
+
+func TestUpsertUIDField(t *testing.T) {
+	s := &fakeServer{attributes: map[string]bool{UIDAttribute: true}}
+	c := newTestClient(t, s)
+
+	if err := c.UpsertUIDField(context.Background()); err != nil {
+		t.Fatalf("upserting UID field: %s", err)
+	}
+	if diff := cmp.Diff(map[string]bool{UIDAttribute: true, TagsAttribute: true}, s.attributes); diff != "" {
+		t.Errorf("attributes (-want, +got)\n%s", diff)
+	}
+}
+
+func TestIPLifecycle(t *testing.T) {
+	ctx := context.Background()
+	s := &fakeServer{hosts: make(map[string]hostRecord)}
+	c := newTestClient(t, s)
+
+	ip := &netbox.IPAddress{
+		UID:         "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4",
+		DNSName:     "pod.default.cluster.local",
+		Address:     netbox.IP(netip.MustParseAddr("10.0.0.5")),
+		Tags:        []netbox.Tag{{Name: "k8s-pod", Slug: "k8s-pod"}, {Name: "kubernetes", Slug: "kubernetes"}},
+		Description: "app: foo",
+	}
+
+	steps := []struct {
+		name string
+		// modifies the IP before it is upserted
+		modify       func(ip *netbox.IPAddress)
+		expectResult netbox.UpsertResult
+		expectStored hostRecord
+	}{{
+		name:         "create",
+		modify:       func(*netbox.IPAddress) {},
+		expectResult: netbox.UpsertCreated,
+		expectStored: hostRecord{
+			Ref:     "record:host/1:pod.default.cluster.local/default",
+			Name:    "pod.default.cluster.local",
+			Comment: "app: foo",
+			ExtAttrs: map[string]extAttr{
+				UIDAttribute:  {Value: string(ip.UID)},
+				TagsAttribute: {Value: "k8s-pod,kubernetes"},
+			},
+			IPv4Addrs: []hostAddr{{IPv4Addr: "10.0.0.5"}},
+			IPv6Addrs: []hostAddr{},
+		},
+	}, {
+		name:         "unchanged",
+		modify:       func(*netbox.IPAddress) {},
+		expectResult: netbox.UpsertUnchanged,
+		expectStored: hostRecord{
+			Ref:     "record:host/1:pod.default.cluster.local/default",
+			Name:    "pod.default.cluster.local",
+			Comment: "app: foo",
+			ExtAttrs: map[string]extAttr{
+				UIDAttribute:  {Value: string(ip.UID)},
+				TagsAttribute: {Value: "k8s-pod,kubernetes"},
+			},
+			IPv4Addrs: []hostAddr{{IPv4Addr: "10.0.0.5"}},
+			IPv6Addrs: []hostAddr{},
+		},
+	}, {
+		name: "address and tags changed",
+		modify: func(ip *netbox.IPAddress) {
+			ip.Address = netbox.IP(netip.MustParseAddr("fd00::5"))
+			ip.Tags = nil
+		},
+		expectResult: netbox.UpsertUpdated,
+		expectStored: hostRecord{
+			Ref:     "record:host/1:pod.default.cluster.local/default",
+			Name:    "pod.default.cluster.local",
+			Comment: "app: foo",
+			ExtAttrs: map[string]extAttr{
+				UIDAttribute: {Value: string(ip.UID)},
+			},
+			IPv4Addrs: []hostAddr{},
+			IPv6Addrs: []hostAddr{{IPv6Addr: "fd00::5"}},
+		},
+	}}
+
+	for _, step := range steps {
+		step.modify(ip)
+		upserted, result, err := c.UpsertIP(ctx, ip)
+		if err != nil {
+			t.Fatalf("%s: upserting IP: %s", step.name, err)
+		}
+		if upserted == nil || upserted.UID != ip.UID {
+			t.Errorf("%s: want upserted IP with UID %q, got %v", step.name, ip.UID, upserted)
+		}
+		if result != step.expectResult {
+			t.Errorf("%s: want result %d, got %d", step.name, step.expectResult, result)
+		}
+		if len(s.hosts) != 1 {
+			t.Fatalf("%s: want 1 stored host record, got %d", step.name, len(s.hosts))
+		}
+		if diff := cmp.Diff(step.expectStored, s.hosts[step.expectStored.Ref]); diff != "" {
+			t.Errorf("%s: stored host record (-want, +got)\n%s", step.name, diff)
+		}
+	}
+
+	got, err := c.GetIP(ctx, ip.UID)
+	if err != nil {
+		t.Fatalf("getting IP: %s", err)
+	}
+	if diff := cmp.Diff(ip, got,
+		cmp.Comparer(func(x, y netbox.IP) bool { return netip.Addr(x) == netip.Addr(y) }),
+		cmpopts.IgnoreUnexported(netbox.Tag{}),
+	); diff != "" {
+		t.Errorf("IP (-want, +got)\n%s", diff)
+	}
+
+	if err := c.DeleteIP(ctx, ip.UID); err != nil {
+		t.Fatalf("deleting IP: %s", err)
+	}
+	if len(s.hosts) != 0 {
+		t.Errorf("want no stored host records, got %v", s.hosts)
+	}
+	if got, err := c.GetIP(ctx, ip.UID); err != nil || got != nil {
+		t.Errorf("want no IP after deletion, got %v, %v", got, err)
+	}
+}
+
+func TestOwnedIPChecksUID(t *testing.T) {
+	uid := netbox.UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	ops := map[string]func(c netbox.Client) error{
+		"delete":    func(c netbox.Client) error { return c.DeleteIP(context.Background(), uid) },
+		"release":   func(c netbox.Client) error { return c.ReleaseIP(context.Background(), uid) },
+		"deprecate": func(c netbox.Client) error { return c.DeprecateIP(context.Background(), uid, "deprecated") },
+	}
+
+	for name, op := range ops {
+		t.Run(name, func(t *testing.T) {
+			s := &fakeServer{hosts: make(map[string]hostRecord)}
+			c := newTestClient(t, s)
+
+			ip := &netbox.IPAddress{UID: uid, Address: netbox.IP(netip.MustParseAddr("10.0.0.5"))}
+			if _, _, err := c.UpsertIP(context.Background(), ip); err != nil {
+				t.Fatalf("creating IP: %s", err)
+			}
+			ref := "record:host/1:" + string(uid) + "/default"
+			want := s.hosts[ref]
+
+			// the record is re-used by another object right after it is looked up
+			s.reuseAfterLookup = "other-uid"
+			if err := op(c); err != nil {
+				t.Fatal(err)
+			}
+
+			want.ExtAttrs[UIDAttribute] = extAttr{Value: "other-uid"}
+			if diff := cmp.Diff(want, s.hosts[ref]); diff != "" {
+				t.Errorf("want re-used host record to be unchanged (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpdateKeepsUnmanagedAttributes(t *testing.T) {
+	ctx := context.Background()
+	s := &fakeServer{hosts: make(map[string]hostRecord)}
+	c := newTestClient(t, s)
+
+	ip := &netbox.IPAddress{
+		UID:     "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4",
+		Address: netbox.IP(netip.MustParseAddr("10.0.0.5")),
+	}
+	if _, _, err := c.UpsertIP(ctx, ip); err != nil {
+		t.Fatalf("creating IP: %s", err)
+	}
+
+	ref := "record:host/1:" + string(ip.UID) + "/default"
+	s.hosts[ref].ExtAttrs["Site"] = extAttr{Value: "nyc3"}
+
+	ip.Description = "app: foo"
+	if _, _, err := c.UpsertIP(ctx, ip); err != nil {
+		t.Fatalf("updating IP: %s", err)
+	}
+
+	want := map[string]extAttr{
+		UIDAttribute: {Value: string(ip.UID)},
+		"Site":       {Value: "nyc3"},
+	}
+	if diff := cmp.Diff(want, s.hosts[ref].ExtAttrs); diff != "" {
+		t.Errorf("extensible attributes (-want, +got)\n%s", diff)
+	}
+}
+
+func TestListIPs(t *testing.T) {
+	s := &fakeServer{attributes: make(map[string]bool), hosts: make(map[string]hostRecord)}
+	c := newTestClient(t, s)
+
+	for i, uid := range []netbox.UID{"a", "b"} {
+		_, _, err := c.UpsertIP(context.Background(), &netbox.IPAddress{
+			UID:     uid,
+			Address: netbox.IP(netip.MustParseAddr(fmt.Sprintf("10.0.0.%d", i+1))),
+			Tags:    []netbox.Tag{{Name: string(uid), Slug: string(uid)}},
+		})
+		if err != nil {
+			t.Fatalf("upserting IP: %s", err)
+		}
+	}
+	// a host record that is not managed by the controller
+	s.hosts["record:host/9:other/default"] = hostRecord{
+		Ref:       "record:host/9:other/default",
+		Name:      "other",
+		IPv4Addrs: []hostAddr{{IPv4Addr: "10.0.0.9"}},
+	}
+
+	tests := []struct {
+		name         string
+		filter       netbox.IPFilter
+		expectedUIDs []netbox.UID
+	}{{
+		name:         "all",
+		expectedUIDs: []netbox.UID{"a", "b", ""},
+	}, {
+		name:         "managed",
+		filter:       netbox.IPFilter{Managed: true},
+		expectedUIDs: []netbox.UID{"a", "b"},
+	}, {
+		name:         "by tag",
+		filter:       netbox.IPFilter{Tag: "b"},
+		expectedUIDs: []netbox.UID{"b"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ips, err := c.ListIPs(context.Background(), test.filter)
+			if err != nil {
+				t.Fatalf("listing IPs: %s", err)
+			}
+			var uids []netbox.UID
+			for _, ip := range ips {
+				uids = append(uids, ip.UID)
+			}
+			if diff := cmp.Diff(test.expectedUIDs, uids); diff != "" {
+				t.Errorf("UIDs of listed IPs (-want, +got)\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := c.ListIPs(context.Background(), netbox.IPFilter{CustomField: "foo"}); err == nil {
+		t.Error("want error filtering by custom field, got nil")
+	}
+}
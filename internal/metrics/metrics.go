@@ -18,6 +18,10 @@ limitations under the License.
 package metrics
 
 import (
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	kubemetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
@@ -26,6 +30,22 @@ import (
 // exposed by the kubernetes controller manager
 func init() {
 	kubemetrics.Registry.MustRegister(netboxTotalRequests)
+	kubemetrics.Registry.MustRegister(disallowedIPs)
+	kubemetrics.Registry.MustRegister(webhookFailures)
+	kubemetrics.Registry.MustRegister(netBoxIPInfo)
+	kubemetrics.Registry.MustRegister(netBoxIPInfoDropped)
+	kubemetrics.Registry.MustRegister(lostIPs)
+	kubemetrics.Registry.MustRegister(duplicateIPs)
+	kubemetrics.Registry.MustRegister(truncatedDescriptions)
+	kubemetrics.Registry.MustRegister(uidMismatches)
+	kubemetrics.Registry.MustRegister(conflicts)
+	kubemetrics.Registry.MustRegister(throttledSeconds)
+	kubemetrics.Registry.MustRegister(publishedIPs)
+	kubemetrics.Registry.MustRegister(rejectedRequests)
+	kubemetrics.Registry.MustRegister(buildInfo)
+	kubemetrics.Registry.MustRegister(configInfo)
+	kubemetrics.Registry.MustRegister(queueOverflows)
+	kubemetrics.Registry.MustRegister(queueAges)
 }
 
 var (
@@ -35,8 +55,168 @@ var (
 	},
 		[]string{"status"},
 	)
+
+	disallowedIPs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "netbox_ip_disallowed_total",
+		Help: "Total number of IP upserts and deletes refused because the IP is outside of the allowed prefixes",
+	},
+		[]string{"operation"},
+	)
+
+	webhookFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "netbox_ip_webhook_failures_total",
+		Help: "Total number of IP lifecycle events that could not be delivered to the webhook",
+	},
+		[]string{"type"},
+	)
+
+	netBoxIPInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netbox_ip_info",
+		Help: "Information about a NetBoxIP, including whether its IP is synced to NetBox; always 1",
+	},
+		[]string{"namespace", "name", "family", "synced"},
+	)
+
+	netBoxIPInfoDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "netbox_ip_info_dropped_total",
+		Help: "Total number of netbox_ip_info updates dropped because the limit of exported NetBoxIPs was reached",
+	})
+
+	lostIPs = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "netbox_ip_lost_total",
+		Help: "Total number of IPs that were deleted in NetBox outside of the controller, and re-created",
+	})
+
+	duplicateIPs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "netbox_ip_duplicates_total",
+		Help: "Total number of times several IPs with the same UID were found in NetBox",
+	},
+		[]string{"strategy"},
+	)
+
+	truncatedDescriptions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "netbox_ip_description_truncated_total",
+		Help: "Total number of IP descriptions that were longer than NetBox allows, and were shortened",
+	},
+		[]string{"strategy"},
+	)
+
+	uidMismatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "netbox_ip_uid_mismatches_total",
+		Help: "Total number of IP deletions and releases skipped because the UID of the IP changed since it was looked up",
+	})
+
+	conflicts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "netbox_ip_conflicts_total",
+		Help: "Total number of IP updates skipped because the IP was changed in NetBox since it was read",
+	})
+
+	throttledSeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "netbox_throttled_seconds_total",
+		Help: "Total time in seconds that requests to NetBox waited before being retried, as NetBox asked with Retry-After headers",
+	})
+
+	rejectedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "netbox_requests_rejected_total",
+		Help: "Total number of requests that NetBox rejected as invalid, by the first invalid field",
+	},
+		[]string{"reason"},
+	)
+
+	publishedIPs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netbox_ip_published",
+		Help: "Number of NetBoxIPs whose IPs are currently published to NetBox, by namespace",
+	},
+		[]string{"namespace"},
+	)
+
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netbox_ip_controller_build_info",
+		Help: "Version and VCS revision of the controller, and the Go version it was built with; always 1",
+	},
+		[]string{"version", "revision", "goversion"},
+	)
+
+	configInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netbox_ip_controller_config_info",
+		Help: "Fingerprint of the configuration of the controller, which differs between differently configured controllers; always 1",
+	},
+		[]string{"hash"},
+	)
+
+	queueOverflows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "netbox_ip_controller_queue_overflows_total",
+		Help: "Total number of events that were shed or parked because the workqueue of their controller was full",
+	},
+		[]string{"controller", "policy"},
+	)
+
+	queueAges = &queueAgeCollector{
+		desc: prometheus.NewDesc(
+			"netbox_ip_controller_queue_oldest_seconds",
+			"Age in seconds of the oldest event waiting to be reconciled, by controller; 0 if none is waiting",
+			[]string{"controller"}, nil,
+		),
+		ages: make(map[string]func() time.Duration),
+	}
 )
 
+// queueAgeCollector collects the age of the oldest event
+// waiting in the workqueue of every controller when scraped.
+type queueAgeCollector struct {
+	desc *prometheus.Desc
+	mu   sync.Mutex
+	ages map[string]func() time.Duration
+}
+
+// Describe implements prometheus.Collector.
+func (c *queueAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *queueAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for controller, age := range c.ages {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, age().Seconds(), controller)
+	}
+}
+
+// OtherNamespaces is the namespace label of the netbox_ip_published metric
+// of NetBoxIPs in namespaces beyond the limit. It is not a valid namespace name.
+const OtherNamespaces = "_other"
+
+// DefaultNamespaceLimit is the default limit of namespaces
+// for which netbox_ip_published is exported individually.
+const DefaultNamespaceLimit = 100
+
+// SetBuildInfo sets the netbox_ip_controller_build_info metric.
+func SetBuildInfo(version, revision, goVersion string) {
+	buildInfo.Reset()
+	buildInfo.WithLabelValues(version, revision, goVersion).Set(1)
+}
+
+// SetConfigHash sets the netbox_ip_controller_config_info metric.
+func SetConfigHash(hash string) {
+	configInfo.Reset()
+	configInfo.WithLabelValues(hash).Set(1)
+}
+
+// SetQueueAgeFunc sets the function that returns the age of the oldest event waiting
+// in the workqueue of the given controller, for the netbox_ip_controller_queue_oldest_seconds metric.
+func SetQueueAgeFunc(controller string, age func() time.Duration) {
+	queueAges.mu.Lock()
+	defer queueAges.mu.Unlock()
+	queueAges.ages[controller] = age
+}
+
+// IncrementQueueOverflows increments the netbox_ip_controller_queue_overflows_total
+// metric for the given controller and overflow policy
+func IncrementQueueOverflows(controller, policy string) {
+	queueOverflows.WithLabelValues(controller, policy).Inc()
+}
+
 // IncrementNetboxRequests increments the netbox_total_requests metric with success/failure labels
 func IncrementNetboxRequests(isSuccess bool) {
 	if isSuccess {
@@ -45,3 +225,181 @@ func IncrementNetboxRequests(isSuccess bool) {
 		netboxTotalRequests.WithLabelValues("failure").Inc()
 	}
 }
+
+// IncrementDisallowedIPs increments the netbox_ip_disallowed_total metric for the given operation
+func IncrementDisallowedIPs(operation string) {
+	disallowedIPs.WithLabelValues(operation).Inc()
+}
+
+// IncrementWebhookFailures increments the netbox_ip_webhook_failures_total metric for the given event type
+func IncrementWebhookFailures(eventType string) {
+	webhookFailures.WithLabelValues(eventType).Inc()
+}
+
+// IncrementLostIPs increments the netbox_ip_lost_total metric
+func IncrementLostIPs() {
+	lostIPs.Inc()
+}
+
+// IncrementUIDMismatches increments the netbox_ip_uid_mismatches_total metric
+func IncrementUIDMismatches() {
+	uidMismatches.Inc()
+}
+
+// IncrementConflicts increments the netbox_ip_conflicts_total metric
+func IncrementConflicts() {
+	conflicts.Inc()
+}
+
+// AddThrottledSeconds adds the given number of seconds to the netbox_throttled_seconds_total metric
+func AddThrottledSeconds(seconds float64) {
+	throttledSeconds.Add(seconds)
+}
+
+// IncrementRejectedRequests increments the netbox_requests_rejected_total metric for the given reason
+func IncrementRejectedRequests(reason string) {
+	rejectedRequests.WithLabelValues(reason).Inc()
+}
+
+// IncrementDuplicateIPs increments the netbox_ip_duplicates_total metric for the strategy used to resolve them
+func IncrementDuplicateIPs(strategy string) {
+	duplicateIPs.WithLabelValues(strategy).Inc()
+}
+
+// IncrementTruncatedDescriptions increments the netbox_ip_description_truncated_total metric for the strategy used to shorten them
+func IncrementTruncatedDescriptions(strategy string) {
+	truncatedDescriptions.WithLabelValues(strategy).Inc()
+}
+
+// netBoxIPKey identifies a NetBoxIP in per-object metrics
+type netBoxIPKey struct {
+	namespace string
+	name      string
+}
+
+// netBoxIPStates tracks the labels of per-object metrics, so that
+// their series can be replaced and deleted, and their number limited.
+var netBoxIPStates = struct {
+	sync.Mutex
+	limit  int
+	labels map[netBoxIPKey]prometheus.Labels
+}{labels: make(map[netBoxIPKey]prometheus.Labels)}
+
+// EnableNetBoxIPMetrics enables the per-object netbox_ip_info metric, exported
+// for at most limit NetBoxIPs. Once the limit is reached, NetBoxIPs without a series yet
+// are counted in the netbox_ip_info_dropped_total metric instead.
+func EnableNetBoxIPMetrics(limit int) {
+	netBoxIPStates.Lock()
+	defer netBoxIPStates.Unlock()
+	netBoxIPStates.limit = limit
+}
+
+// publishedIPCounts tracks the NetBoxIPs counted in the netbox_ip_published
+// metric, so that they are counted once, and the number of namespaces is limited.
+var publishedIPCounts = struct {
+	sync.Mutex
+	limit int
+	// namespace labels of the counted NetBoxIPs
+	published map[netBoxIPKey]string
+	// counted NetBoxIPs, by namespace label
+	counts map[string]int
+}{
+	limit:     DefaultNamespaceLimit,
+	published: make(map[netBoxIPKey]string),
+	counts:    make(map[string]int),
+}
+
+// SetNamespaceLimit sets the number of namespaces for which the
+// netbox_ip_published metric is exported individually. NetBoxIPs in namespaces
+// beyond the limit are counted with the OtherNamespaces label instead.
+func SetNamespaceLimit(limit int) {
+	publishedIPCounts.Lock()
+	defer publishedIPCounts.Unlock()
+	publishedIPCounts.limit = limit
+}
+
+// setPublished counts the given NetBoxIP in the netbox_ip_published metric
+// if published is true, and stops counting it otherwise.
+func setPublished(key netBoxIPKey, published bool) {
+	publishedIPCounts.Lock()
+	defer publishedIPCounts.Unlock()
+
+	label, counted := publishedIPCounts.published[key]
+	if published == counted {
+		return
+	}
+
+	if published {
+		label = key.namespace
+		if publishedIPCounts.counts[label] == 0 {
+			namespaces := len(publishedIPCounts.counts)
+			if publishedIPCounts.counts[OtherNamespaces] > 0 {
+				namespaces--
+			}
+			if namespaces >= publishedIPCounts.limit {
+				label = OtherNamespaces
+			}
+		}
+		publishedIPCounts.published[key] = label
+		publishedIPCounts.counts[label]++
+		publishedIPs.WithLabelValues(label).Set(float64(publishedIPCounts.counts[label]))
+		return
+	}
+
+	delete(publishedIPCounts.published, key)
+	publishedIPCounts.counts[label]--
+	if publishedIPCounts.counts[label] > 0 {
+		publishedIPs.WithLabelValues(label).Set(float64(publishedIPCounts.counts[label]))
+		return
+	}
+	delete(publishedIPCounts.counts, label)
+	publishedIPs.DeleteLabelValues(label)
+}
+
+// SetNetBoxIPState sets the netbox_ip_info metric of the given NetBoxIP,
+// if per-object metrics are enabled, and counts it in the netbox_ip_published
+// metric if it is synced.
+func SetNetBoxIPState(namespace, name, family string, synced bool) {
+	setPublished(netBoxIPKey{namespace: namespace, name: name}, synced)
+
+	netBoxIPStates.Lock()
+	defer netBoxIPStates.Unlock()
+
+	if netBoxIPStates.limit <= 0 {
+		return
+	}
+
+	key := netBoxIPKey{namespace: namespace, name: name}
+	labels := prometheus.Labels{
+		"namespace": namespace,
+		"name":      name,
+		"family":    family,
+		"synced":    strconv.FormatBool(synced),
+	}
+
+	old, exists := netBoxIPStates.labels[key]
+	if !exists && len(netBoxIPStates.labels) >= netBoxIPStates.limit {
+		netBoxIPInfoDropped.Inc()
+		return
+	}
+	if exists {
+		netBoxIPInfo.Delete(old)
+	}
+	netBoxIPStates.labels[key] = labels
+	netBoxIPInfo.With(labels).Set(1)
+}
+
+// DeleteNetBoxIPState removes the netbox_ip_info metric of the given
+// NetBoxIP, and stops counting it in the netbox_ip_published metric.
+func DeleteNetBoxIPState(namespace, name string) {
+	setPublished(netBoxIPKey{namespace: namespace, name: name}, false)
+
+	netBoxIPStates.Lock()
+	defer netBoxIPStates.Unlock()
+
+	key := netBoxIPKey{namespace: namespace, name: name}
+	if labels, ok := netBoxIPStates.labels[key]; ok {
+		netBoxIPInfo.Delete(labels)
+		delete(netBoxIPStates.labels, key)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// netBoxIPInfoSeries returns the label values of all netbox_ip_info series,
+// joined with slashes and sorted.
+func netBoxIPInfoSeries(t *testing.T) []string {
+	ch := make(chan prometheus.Metric, 100)
+	netBoxIPInfo.Collect(ch)
+	close(ch)
+
+	var series []string
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatalf("writing metric: %s", err)
+		}
+		var values []string
+		for _, l := range metric.GetLabel() {
+			values = append(values, l.GetName()+"="+l.GetValue())
+		}
+		series = append(series, strings.Join(values, ","))
+	}
+	sort.Strings(series)
+	return series
+}
+
+func TestNetBoxIPState(t *testing.T) {
+	defer EnableNetBoxIPMetrics(0)
+
+	// disabled by default
+	SetNetBoxIPState("default", "foo", "ipv4", true)
+	if diff := cmp.Diff([]string(nil), netBoxIPInfoSeries(t)); diff != "" {
+		t.Errorf("series with metrics disabled (-want, +got)\n%s", diff)
+	}
+
+	EnableNetBoxIPMetrics(2)
+	SetNetBoxIPState("default", "foo", "ipv4", false)
+	SetNetBoxIPState("default", "bar", "ipv6", true)
+	// over the limit
+	SetNetBoxIPState("default", "baz", "ipv4", true)
+	// replaces the existing series
+	SetNetBoxIPState("default", "foo", "ipv4", true)
+
+	want := []string{
+		"family=ipv4,name=foo,namespace=default,synced=true",
+		"family=ipv6,name=bar,namespace=default,synced=true",
+	}
+	if diff := cmp.Diff(want, netBoxIPInfoSeries(t)); diff != "" {
+		t.Errorf("series (-want, +got)\n%s", diff)
+	}
+
+	var dropped dto.Metric
+	if err := netBoxIPInfoDropped.Write(&dropped); err != nil {
+		t.Fatalf("writing metric: %s", err)
+	}
+	if got := dropped.GetCounter().GetValue(); got != 1 {
+		t.Errorf("want 1 dropped update, got %f", got)
+	}
+
+	// frees up space for another NetBoxIP
+	DeleteNetBoxIPState("default", "bar")
+	SetNetBoxIPState("default", "baz", "ipv4", true)
+
+	want = []string{
+		"family=ipv4,name=baz,namespace=default,synced=true",
+		"family=ipv4,name=foo,namespace=default,synced=true",
+	}
+	if diff := cmp.Diff(want, netBoxIPInfoSeries(t)); diff != "" {
+		t.Errorf("series after deletion (-want, +got)\n%s", diff)
+	}
+}
+
+// publishedIPsSeries returns the namespaces and values
+// of all netbox_ip_published series, sorted.
+func publishedIPsSeries(t *testing.T) []string {
+	ch := make(chan prometheus.Metric, 100)
+	publishedIPs.Collect(ch)
+	close(ch)
+
+	var series []string
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatalf("writing metric: %s", err)
+		}
+		series = append(series, fmt.Sprintf("%s=%g", metric.GetLabel()[0].GetValue(), metric.GetGauge().GetValue()))
+	}
+	sort.Strings(series)
+	return series
+}
+
+// resetPublishedIPs stops counting the NetBoxIPs counted by other tests.
+func resetPublishedIPs() {
+	publishedIPCounts.Lock()
+	defer publishedIPCounts.Unlock()
+	publishedIPCounts.published = make(map[netBoxIPKey]string)
+	publishedIPCounts.counts = make(map[string]int)
+	publishedIPs.Reset()
+}
+
+func TestPublishedIPs(t *testing.T) {
+	resetPublishedIPs()
+	defer resetPublishedIPs()
+	defer SetNamespaceLimit(DefaultNamespaceLimit)
+
+	SetNamespaceLimit(2)
+	SetNetBoxIPState("a", "foo", "ipv4", true)
+	SetNetBoxIPState("a", "bar", "ipv4", true)
+	// counted once
+	SetNetBoxIPState("a", "bar", "ipv4", true)
+	SetNetBoxIPState("b", "foo", "ipv4", true)
+	// over the limit
+	SetNetBoxIPState("c", "foo", "ipv4", true)
+	SetNetBoxIPState("d", "foo", "ipv4", true)
+	// not published
+	SetNetBoxIPState("e", "foo", "ipv4", false)
+
+	want := []string{"_other=2", "a=2", "b=1"}
+	if diff := cmp.Diff(want, publishedIPsSeries(t)); diff != "" {
+		t.Errorf("series (-want, +got)\n%s", diff)
+	}
+
+	SetNetBoxIPState("a", "foo", "ipv4", false)
+	DeleteNetBoxIPState("b", "foo")
+	DeleteNetBoxIPState("c", "foo")
+
+	want = []string{"_other=1", "a=1"}
+	if diff := cmp.Diff(want, publishedIPsSeries(t)); diff != "" {
+		t.Errorf("series after deletion (-want, +got)\n%s", diff)
+	}
+
+	// frees up space for another namespace
+	SetNetBoxIPState("e", "foo", "ipv4", true)
+
+	want = []string{"_other=1", "a=1", "e=1"}
+	if diff := cmp.Diff(want, publishedIPsSeries(t)); diff != "" {
+		t.Errorf("series after namespace was freed up (-want, +got)\n%s", diff)
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	SetConfigHash("abc")
+	SetConfigHash("def")
+
+	ch := make(chan prometheus.Metric, 10)
+	configInfo.Collect(ch)
+	close(ch)
+
+	var hashes []string
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatalf("writing metric: %s", err)
+		}
+		hashes = append(hashes, metric.GetLabel()[0].GetValue())
+	}
+	if diff := cmp.Diff([]string{"def"}, hashes); diff != "" {
+		t.Errorf("series (-want, +got)\n%s", diff)
+	}
+}
+
+func TestQueueAges(t *testing.T) {
+	SetQueueAgeFunc("test", func() time.Duration { return 90 * time.Second })
+	defer func() {
+		queueAges.mu.Lock()
+		delete(queueAges.ages, "test")
+		queueAges.mu.Unlock()
+	}()
+
+	ch := make(chan prometheus.Metric, 10)
+	queueAges.Collect(ch)
+	close(ch)
+
+	var found bool
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatalf("writing metric: %s", err)
+		}
+		if metric.GetLabel()[0].GetValue() != "test" {
+			continue
+		}
+		found = true
+		if value := metric.GetGauge().GetValue(); value != 90 {
+			t.Errorf("want age of 90 seconds, got %v", value)
+		}
+	}
+	if !found {
+		t.Error("want age of test controller, got none")
+	}
+}
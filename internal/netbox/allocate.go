@@ -0,0 +1,145 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+)
+
+// ErrPrefixNotFound is returned by AllocateIP if there
+// is no prefix to allocate the IP from in NetBox.
+var ErrPrefixNotFound = errors.New("prefix does not exist in NetBox")
+
+// ErrPrefixFull is returned by AllocateIP if the
+// prefix has no available IPs left.
+var ErrPrefixFull = errors.New("prefix has no available IPs")
+
+// Allocator is implemented by clients that can allocate
+// available IPs from prefixes in the IPAM system.
+type Allocator interface {
+	// AllocateIP creates an IP with the UID and other fields of ip, whose
+	// address is the first available one in prefix, in the VRF of ip, if any,
+	// and returns it. The address and prefix length of ip are ignored: the IP
+	// is created with the prefix length of prefix.
+	AllocateIP(ctx context.Context, prefix netip.Prefix, ip *IPAddress) (*IPAddress, error)
+}
+
+// AllocateIP allocates the first available IP in the prefix
+// with the available-ips endpoint of the prefix in NetBox.
+func (c *client) AllocateIP(ctx context.Context, prefix netip.Prefix, ip *IPAddress) (*IPAddress, error) {
+	if uid := c.storedUID(ip.UID); !ValidUID(uid) {
+		return nil, fmt.Errorf("invalid UID %q: must match %s", uid, uidRegexpStr)
+	}
+
+	storedIP := *ip
+	storedIP.ID = 0
+	storedIP.UID = c.storedUID(ip.UID)
+	storedIP.Address = IP{}
+	storedIP.PrefixLength = 0
+	if err := c.resolveReferences(ctx, &storedIP); err != nil {
+		return nil, err
+	}
+	if storedIP.VRF != nil && storedIP.VRF.ID == 0 {
+		return nil, fmt.Errorf("%w: VRF %q does not exist", ErrPrefixNotFound, storedIP.VRF.Name)
+	}
+
+	prefixID, err := c.prefixID(ctx, prefix.Masked(), storedIP.VRF)
+	if err != nil {
+		return nil, err
+	}
+
+	// NetBox allocates the address, and would reject an empty one
+	var fields map[string]json.RawMessage
+	data, err := c.marshalIP(&storedIP)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("marshaling IP: %w", err)
+	}
+	delete(fields, "address")
+
+	url := fmt.Sprintf("%s/ipam/prefixes/%d/available-ips/", c.baseURL, prefixID)
+	record := AuditRecord{
+		Operation: AuditOperationCreate,
+		Object:    AuditObjectIPAddress,
+		UID:       string(ip.UID),
+		Changes:   ipChanges(nil, &storedIP),
+	}
+	data, err = c.executeRequest(ctx, url, http.MethodPost, fields)
+	if isConflict(err) {
+		err = fmt.Errorf("%w: %s", ErrPrefixFull, prefix.Masked())
+		c.recordFailedAudit(record, err)
+		return nil, err
+	} else if err != nil {
+		c.recordFailedAudit(record, err)
+		c.forgetReferences(ip)
+		return nil, fmt.Errorf("executing request: %w", err)
+	}
+
+	var allocatedIP IPAddress
+	if err := c.unmarshalIP(data, &allocatedIP); err != nil {
+		return nil, fmt.Errorf("unmarshaling response: %w", err)
+	}
+	allocatedIP.UID = ip.UID
+	allocatedIP.WebURL = c.webURL(allocatedIP.ID)
+	c.setKnownID(ip.UID, allocatedIP.ID)
+
+	record.ID = allocatedIP.ID
+	record.Address = addressString(allocatedIP.Address)
+	c.recordAudit(record)
+
+	return &allocatedIP, nil
+}
+
+// prefixID returns the ID of the prefix in NetBox in the given VRF,
+// or in the global table if vrf is nil.
+func (c *client) prefixID(ctx context.Context, prefix netip.Prefix, vrf *VRF) (int64, error) {
+	vrfID := "null"
+	if vrf != nil {
+		vrfID = fmt.Sprint(vrf.ID)
+	}
+	url := fmt.Sprintf("%s/ipam/prefixes/?prefix=%s&vrf_id=%s", c.baseURL, url.QueryEscape(prefix.String()), vrfID)
+	data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+	if err != nil {
+		return 0, fmt.Errorf("executing request: %w", err)
+	}
+
+	var list struct {
+		Results []struct {
+			ID int64 `json:"id"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return 0, fmt.Errorf("unmarshaling response: %w", err)
+	}
+	switch len(list.Results) {
+	case 0:
+		return 0, fmt.Errorf("%w: %s", ErrPrefixNotFound, prefix)
+	case 1:
+		return list.Results[0].ID, nil
+	default:
+		// NetBox does not require prefixes to be unique
+		return 0, fmt.Errorf("more than one prefix %s found", prefix)
+	}
+}
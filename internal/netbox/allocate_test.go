@@ -0,0 +1,134 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestAllocateIP(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name           string
+		vrf            *VRF
+		prefixes       string
+		full           bool
+		expectedQuery  string
+		expectedErr    error
+		expectedAddr   netip.Addr
+		expectedLength int
+	}{{
+		name:           "global prefix",
+		prefixes:       `[{"id": 3}]`,
+		expectedQuery:  "prefix=10.0.0.0%2F24&vrf_id=null",
+		expectedAddr:   netip.MustParseAddr("10.0.0.5"),
+		expectedLength: 24,
+	}, {
+		name:           "prefix in VRF",
+		vrf:            &VRF{Name: "blue"},
+		prefixes:       `[{"id": 3}]`,
+		expectedQuery:  "prefix=10.0.0.0%2F24&vrf_id=9",
+		expectedAddr:   netip.MustParseAddr("10.0.0.5"),
+		expectedLength: 24,
+	}, {
+		name:          "prefix not found",
+		prefixes:      `[]`,
+		expectedQuery: "prefix=10.0.0.0%2F24&vrf_id=null",
+		expectedErr:   ErrPrefixNotFound,
+	}, {
+		name:          "prefix full",
+		prefixes:      `[{"id": 3}]`,
+		full:          true,
+		expectedQuery: "prefix=10.0.0.0%2F24&vrf_id=null",
+		expectedErr:   ErrPrefixFull,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var query string
+			var written map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/api/ipam/vrfs/":
+					w.Write([]byte(`{"count": 1, "results": [{"id": 9, "name": "blue"}]}`))
+				case r.Method == http.MethodGet && r.URL.Path == "/api/ipam/prefixes/":
+					query = r.URL.RawQuery
+					fmt.Fprintf(w, `{"results": %s}`, test.prefixes)
+				case r.Method == http.MethodPost && r.URL.Path == "/api/ipam/prefixes/3/available-ips/":
+					if test.full {
+						w.WriteHeader(http.StatusConflict)
+						w.Write([]byte(`{"detail": "An insufficient number of IP addresses are available within 10.0.0.0/24 (1 requested, 0 available)"}`))
+						return
+					}
+					json.NewDecoder(r.Body).Decode(&written)
+					fmt.Fprintf(w, `{"id": 12, "address": "10.0.0.5/24", "dns_name": "foo.example.com", "custom_fields": {"%s": "%s"}}`, UIDCustomFieldName, uid)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL)
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL+"/api", "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ip, err := c.(Allocator).AllocateIP(context.Background(), netip.MustParsePrefix("10.0.0.0/24"), &IPAddress{
+				UID:     uid,
+				DNSName: "foo.example.com",
+				VRF:     test.vrf,
+			})
+			if query != test.expectedQuery {
+				t.Errorf("want prefix query %q, got %q", test.expectedQuery, query)
+			}
+			if test.expectedErr != nil {
+				if !errors.Is(err, test.expectedErr) {
+					t.Errorf("want error %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("allocating IP: %s", err)
+			}
+
+			if _, ok := written["address"]; ok {
+				t.Errorf("want no address written, got %v", written["address"])
+			}
+			if written["dns_name"] != "foo.example.com" {
+				t.Errorf("want DNS name written, got %v", written["dns_name"])
+			}
+			if netip.Addr(ip.Address) != test.expectedAddr || ip.PrefixLength != test.expectedLength {
+				t.Errorf("want %s/%d, got %s/%d", test.expectedAddr, test.expectedLength, netip.Addr(ip.Address), ip.PrefixLength)
+			}
+			if ip.UID != uid {
+				t.Errorf("want UID %q, got %q", uid, ip.UID)
+			}
+			if expectedURL := server.URL + "/ipam/ip-addresses/12/"; ip.WebURL != expectedURL {
+				t.Errorf("want URL %q, got %q", expectedURL, ip.WebURL)
+			}
+		})
+	}
+}
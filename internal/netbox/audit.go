@@ -0,0 +1,232 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Audited operations.
+const (
+	AuditOperationCreate = "create"
+	AuditOperationUpdate = "update"
+	AuditOperationDelete = "delete"
+	// AuditOperationUpsert is only recorded for IPs that are refused
+	// before it is known whether they exist in NetBox.
+	AuditOperationUpsert = "upsert"
+)
+
+// Kinds of NetBox objects in audit records.
+const (
+	AuditObjectIPAddress   = "ip-address"
+	AuditObjectTag         = "tag"
+	AuditObjectCustomField = "custom-field"
+	AuditObjectService     = "service"
+	AuditObjectPrefix      = "prefix"
+	AuditObjectInterface   = "interface"
+)
+
+// AuditRecord describes a single write operation performed against NetBox.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Object    string    `json:"object"`
+	// ID is the NetBox ID of the object.
+	ID      int64  `json:"id,omitempty"`
+	UID     string `json:"uid,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address,omitempty"`
+	// Changes holds the fields that were changed by the operation.
+	Changes map[string]AuditChange `json:"changes,omitempty"`
+	// Error, if set, is why the operation failed or was refused,
+	// in which case Changes are the ones that were attempted.
+	Error string `json:"error,omitempty"`
+}
+
+// AuditChange is the old and the new value of a changed field.
+type AuditChange struct {
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// AuditSink receives records of all write operations performed against NetBox.
+type AuditSink interface {
+	Record(AuditRecord)
+}
+
+type jsonAuditSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONAuditSink returns an AuditSink that writes every record
+// to w as a single line of JSON.
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	return &jsonAuditSink{enc: json.NewEncoder(w)}
+}
+
+// Record writes the audit record. Errors are ignored, since failing
+// to write the audit log must not fail the operation that was already performed.
+func (s *jsonAuditSink) Record(r AuditRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.enc.Encode(r)
+}
+
+// WithAuditSink sets the sink that receives records of all create,
+// update and delete operations performed by the client.
+func WithAuditSink(sink AuditSink) ClientOption {
+	return func(c *client) error {
+		c.audit = sink
+		return nil
+	}
+}
+
+func (c *client) recordAudit(r AuditRecord) {
+	if c.audit == nil {
+		return
+	}
+	r.Time = time.Now().UTC()
+	c.audit.Record(r)
+}
+
+// recordFailedAudit records an operation that failed, or was refused, with err.
+func (c *client) recordFailedAudit(r AuditRecord, err error) {
+	r.Error = err.Error()
+	c.recordAudit(r)
+}
+
+// ipChanges returns the fields of IP address that differ between
+// oldIP and newIP, either of which may be nil.
+func ipChanges(oldIP, newIP *IPAddress) map[string]AuditChange {
+	if oldIP == nil {
+		oldIP = &IPAddress{}
+	}
+	if newIP == nil {
+		newIP = &IPAddress{}
+	}
+
+	changes := make(map[string]AuditChange)
+	addChange := func(field string, old, new string) {
+		if old == new {
+			return
+		}
+		var change AuditChange
+		if old != "" {
+			change.Old = old
+		}
+		if new != "" {
+			change.New = new
+		}
+		changes[field] = change
+	}
+
+	addChange("uid", string(oldIP.UID), string(newIP.UID))
+	addChange("address", addressString(oldIP.Address), addressString(newIP.Address))
+	addChange("dns_name", oldIP.DNSName, newIP.DNSName)
+	addChange("description", oldIP.Description, newIP.Description)
+	addChange("tenant", tenantSlug(oldIP.Tenant), tenantSlug(newIP.Tenant))
+	addChange("vrf", vrfName(oldIP.VRF), vrfName(newIP.VRF))
+	addChange("assigned_object", assignedObject(oldIP), assignedObject(newIP))
+	addChange("nat_inside", natInside(oldIP), natInside(newIP))
+	// other custom fields of the old IP are not managed by the controller
+	for name, value := range newIP.CustomFields {
+		addChange("custom_fields."+name, oldIP.CustomFields[name], value)
+	}
+
+	oldTags, newTags := tagNames(oldIP.Tags), tagNames(newIP.Tags)
+	if !equalStrings(oldTags, newTags) {
+		var change AuditChange
+		if oldTags != nil {
+			change.Old = oldTags
+		}
+		if newTags != nil {
+			change.New = newTags
+		}
+		changes["tags"] = change
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func addressString(ip IP) string {
+	b, err := ip.MarshalText()
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func assignedObject(ip *IPAddress) string {
+	if ip.AssignedObjectType == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", ip.AssignedObjectType, ip.AssignedObjectID)
+}
+
+func natInside(ip *IPAddress) string {
+	if ip.NATInside == nil {
+		return ""
+	}
+	return fmt.Sprint(ip.NATInside.ID)
+}
+
+func tenantSlug(tenant *Tenant) string {
+	if tenant == nil {
+		return ""
+	}
+	return tenant.Slug
+}
+
+func vrfName(vrf *VRF) string {
+	if vrf == nil {
+		return ""
+	}
+	return vrf.Name
+}
+
+func tagNames(tags []Tag) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestIPChanges(t *testing.T) {
+	ip := &IPAddress{
+		ID:          1,
+		UID:         "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4",
+		Address:     IP(netip.MustParseAddr("192.168.0.1")),
+		DNSName:     "foo.default.pod.cluster.local",
+		Tags:        []Tag{{Name: "kubernetes"}, {Name: "k8s-pod"}},
+		Description: "app: foo",
+	}
+
+	tests := []struct {
+		name     string
+		old      *IPAddress
+		new      *IPAddress
+		expected map[string]AuditChange
+	}{{
+		name: "create",
+		new:  ip,
+		expected: map[string]AuditChange{
+			"uid":         {New: "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"},
+			"address":     {New: "192.168.0.1/32"},
+			"dns_name":    {New: "foo.default.pod.cluster.local"},
+			"description": {New: "app: foo"},
+			"tags":        {New: []string{"k8s-pod", "kubernetes"}},
+		},
+	}, {
+		name: "update",
+		old:  ip,
+		new: &IPAddress{
+			UID:         ip.UID,
+			Address:     IP(netip.MustParseAddr("192.168.0.2")),
+			DNSName:     ip.DNSName,
+			Tags:        []Tag{{Name: "k8s-pod", ID: 2}, {Name: "kubernetes", ID: 3}},
+			Description: "app: bar",
+		},
+		expected: map[string]AuditChange{
+			"address":     {Old: "192.168.0.1/32", New: "192.168.0.2/32"},
+			"description": {Old: "app: foo", New: "app: bar"},
+		},
+	}, {
+		name: "delete",
+		old:  ip,
+		expected: map[string]AuditChange{
+			"uid":         {Old: "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"},
+			"address":     {Old: "192.168.0.1/32"},
+			"dns_name":    {Old: "foo.default.pod.cluster.local"},
+			"description": {Old: "app: foo"},
+			"tags":        {Old: []string{"k8s-pod", "kubernetes"}},
+		},
+	}, {
+		name: "no changes",
+		old:  ip,
+		new:  ip,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := ipChanges(test.old, test.new)
+			if diff := cmp.Diff(test.expected, changes); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	existing := `{"id": 7, "address": "192.168.0.1/32", "custom_fields": {"netbox_ip_controller_uid": "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Path == "/ipam/ip-addresses/7/" {
+				w.Write([]byte(existing))
+				return
+			}
+			w.Write([]byte(`{"count": 1, "results": [` + existing + `]}`))
+		case http.MethodPut:
+			w.Write([]byte(`{"id": 7}`))
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	c, err := NewClient(server.URL, "foo", WithAuditSink(NewJSONAuditSink(&buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := &IPAddress{
+		UID:         "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4",
+		Address:     IP(netip.MustParseAddr("192.168.0.1")),
+		Description: "app: foo",
+	}
+	if _, _, err := c.UpsertIP(context.Background(), ip); err != nil {
+		t.Fatalf("upserting IP: %s", err)
+	}
+	if err := c.DeleteIP(context.Background(), ip.UID); err != nil {
+		t.Fatalf("deleting IP: %s", err)
+	}
+
+	var records []AuditRecord
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r AuditRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("decoding audit record: %s", err)
+		}
+		records = append(records, r)
+	}
+
+	expected := []AuditRecord{{
+		Operation: AuditOperationUpdate,
+		Object:    AuditObjectIPAddress,
+		ID:        7,
+		UID:       string(ip.UID),
+		Address:   "192.168.0.1/32",
+		Changes: map[string]AuditChange{
+			"description": {New: "app: foo"},
+		},
+	}, {
+		Operation: AuditOperationDelete,
+		Object:    AuditObjectIPAddress,
+		ID:        7,
+		UID:       string(ip.UID),
+		Address:   "192.168.0.1/32",
+		Changes: map[string]AuditChange{
+			"uid":     {Old: string(ip.UID)},
+			"address": {Old: "192.168.0.1/32"},
+		},
+	}}
+	if diff := cmp.Diff(expected, records, cmpopts.IgnoreFields(AuditRecord{}, "Time")); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestAuditLogFailedWrites(t *testing.T) {
+	existing := `{"id": 7, "address": "10.0.0.1/32", "custom_fields": {"netbox_ip_controller_uid": "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Path == "/ipam/ip-addresses/7/" {
+				w.Write([]byte(existing))
+				return
+			}
+			w.Write([]byte(`{"count": 1, "results": [` + existing + `]}`))
+		case http.MethodPut:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"description": ["invalid description"]}`))
+		}
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	c, err := NewClient(server.URL, "foo",
+		WithAuditSink(NewJSONAuditSink(&buf)),
+		WithAllowedPrefixes([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failedIP := &IPAddress{
+		UID:         "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4",
+		Address:     IP(netip.MustParseAddr("10.0.0.1")),
+		Description: "app: foo",
+	}
+	if _, _, err := c.UpsertIP(context.Background(), failedIP); err == nil {
+		t.Fatal("expected upserting IP to fail")
+	}
+	refusedIP := &IPAddress{
+		UID:     "a4e3b8f0-9b6b-4a1b-a4e3-e7e6e3b8f0e4",
+		Address: IP(netip.MustParseAddr("192.168.0.1")),
+	}
+	if _, _, err := c.UpsertIP(context.Background(), refusedIP); err == nil {
+		t.Fatal("expected upserting IP to be refused")
+	}
+
+	var records []AuditRecord
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r AuditRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("decoding audit record: %s", err)
+		}
+		if r.Error == "" {
+			t.Errorf("expected error in audit record %+v", r)
+		}
+		records = append(records, r)
+	}
+
+	expected := []AuditRecord{{
+		Operation: AuditOperationUpdate,
+		Object:    AuditObjectIPAddress,
+		ID:        7,
+		UID:       string(failedIP.UID),
+		Address:   "10.0.0.1/32",
+		Changes: map[string]AuditChange{
+			"description": {New: "app: foo"},
+		},
+	}, {
+		Operation: AuditOperationUpsert,
+		Object:    AuditObjectIPAddress,
+		UID:       string(refusedIP.UID),
+		Address:   "192.168.0.1/32",
+	}}
+	if diff := cmp.Diff(expected, records, cmpopts.IgnoreFields(AuditRecord{}, "Time", "Error")); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+// refresh access tokens this long before they actually expire,
+// so that a token does not expire while a request is in flight
+const tokenExpiryDelta = 30 * time.Second
+
+// AuthProvider adds credentials to the requests sent to NetBox.
+type AuthProvider interface {
+	Authenticate(ctx context.Context, req *http.Request) error
+}
+
+// renewableAuth is implemented by AuthProviders whose credentials may be
+// rejected by NetBox before they expire, e.g. revoked access tokens.
+type renewableAuth interface {
+	// invalidate discards the cached credentials, so that new ones
+	// are acquired for the next request
+	invalidate()
+}
+
+// tlsAuth is implemented by AuthProviders that send requests of their own,
+// which must use the same TLS settings as the requests sent to NetBox.
+type tlsAuth interface {
+	// useTLS sets up the TLS settings of the provider's requests, given
+	// the settings of the client, and the host of the NetBox API URL
+	useTLS(settings tlsSettings, apiHost string) error
+}
+
+type tokenAuth struct {
+	token string
+}
+
+// NewTokenAuth returns an AuthProvider that authenticates requests
+// with a static NetBox API token.
+func NewTokenAuth(token string) AuthProvider {
+	return &tokenAuth{token: token}
+}
+
+// Authenticate sets the NetBox token Authorization header on the request.
+func (a *tokenAuth) Authenticate(_ context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", fmt.Sprintf("Token %s", a.token))
+	return nil
+}
+
+func (a *tokenAuth) secrets() []string {
+	return []string{a.token}
+}
+
+// ClientCredentialsConfig describes how to obtain an access token
+// using the OAuth2 client credentials grant.
+type ClientCredentialsConfig struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+type clientCredentialsAuth struct {
+	cfg        ClientCredentialsConfig
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+// NewClientCredentialsAuth returns an AuthProvider that acquires a bearer token
+// from an OAuth2/OIDC token endpoint using the client credentials grant,
+// and refreshes it before it expires.
+func NewClientCredentialsAuth(cfg ClientCredentialsConfig) (AuthProvider, error) {
+	if _, err := parseAndValidateURL(cfg.TokenURL); err != nil {
+		return nil, fmt.Errorf("invalid token URL: %w", err)
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("client ID is required")
+	}
+
+	return &clientCredentialsAuth{
+		cfg:        cfg,
+		httpClient: cleanhttp.DefaultPooledClient(),
+		now:        time.Now,
+	}, nil
+}
+
+// Authenticate sets a bearer token Authorization header on the request,
+// acquiring a new token first if there isn't a valid one.
+func (a *clientCredentialsAuth) Authenticate(ctx context.Context, req *http.Request) error {
+	token, err := a.token(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring access token: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return nil
+}
+
+func (a *clientCredentialsAuth) token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.accessToken != "" && (a.expiry.IsZero() || a.now().Add(tokenExpiryDelta).Before(a.expiry)) {
+		return a.accessToken, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(a.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.cfg.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.cfg.ClientID), url.QueryEscape(a.cfg.ClientSecret))
+
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if err := httpErrorFrom(res); err != nil {
+		return "", err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, responseBodySizeLimit))
+	if err != nil {
+		return "", errors.New("reading response data")
+	}
+
+	var tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &tokenResponse); err != nil {
+		return "", fmt.Errorf("unmarshaling response: %w", err)
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", errors.New("token endpoint returned an empty access token")
+	}
+	if tokenResponse.TokenType != "" && !strings.EqualFold(tokenResponse.TokenType, "bearer") {
+		return "", fmt.Errorf("unsupported token type %q", tokenResponse.TokenType)
+	}
+
+	a.accessToken = tokenResponse.AccessToken
+	a.expiry = time.Time{}
+	if tokenResponse.ExpiresIn > 0 {
+		a.expiry = a.now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+	}
+
+	return a.accessToken, nil
+}
+
+// invalidate discards the cached access token, e.g. after NetBox rejected it.
+func (a *clientCredentialsAuth) invalidate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accessToken = ""
+	a.expiry = time.Time{}
+}
+
+// useTLS makes the token requests use the TLS settings of the NetBox client.
+// A pinned server name only applies if the token endpoint is on the NetBox host.
+func (a *clientCredentialsAuth) useTLS(settings tlsSettings, apiHost string) error {
+	if settings.isZero() {
+		return nil
+	}
+
+	u, err := url.Parse(a.cfg.TokenURL)
+	if err != nil {
+		return fmt.Errorf("invalid token URL: %w", err)
+	}
+	settings.host = u.Hostname()
+	if settings.host != apiHost {
+		settings.serverName = ""
+	}
+
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.TLSClientConfig = settings.config()
+	if settings.rootCAs != nil {
+		settings.rootCAs.notifyReload(transport.CloseIdleConnections)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.httpClient = &http.Client{Transport: transport}
+	return nil
+}
+
+func (a *clientCredentialsAuth) secrets() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return []string{a.cfg.ClientSecret, a.accessToken}
+}
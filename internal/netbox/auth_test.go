@@ -0,0 +1,183 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientCredentialsAuth(t *testing.T) {
+	var issued int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing token request: %s", err)
+		}
+		if grantType := r.PostForm.Get("grant_type"); grantType != "client_credentials" {
+			t.Errorf("want grant_type client_credentials, got %q", grantType)
+		}
+		if scope := r.PostForm.Get("scope"); scope != "netbox read" {
+			t.Errorf("want scope %q, got %q", "netbox read", scope)
+		}
+		if id, secret, ok := r.BasicAuth(); !ok || id != "foo" || secret != "bar" {
+			t.Errorf("want client credentials foo:bar, got %s:%s", id, secret)
+		}
+
+		issued++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": 300}`, issued)
+	}))
+	defer server.Close()
+
+	provider, err := NewClientCredentialsAuth(ClientCredentialsConfig{
+		TokenURL:     server.URL,
+		ClientID:     "foo",
+		ClientSecret: "bar",
+		Scopes:       []string{"netbox", "read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := provider.(*clientCredentialsAuth)
+	now := time.Now()
+	auth.now = func() time.Time { return now }
+
+	authorization := func() string {
+		req, _ := http.NewRequest(http.MethodGet, "http://netbox.example.com/api/", nil)
+		if err := auth.Authenticate(context.Background(), req); err != nil {
+			t.Fatalf("authenticating: %s", err)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	if got := authorization(); got != "Bearer token-1" {
+		t.Errorf("want a newly acquired token, got %q", got)
+	}
+	if got := authorization(); got != "Bearer token-1" {
+		t.Errorf("want the cached token to be reused, got %q", got)
+	}
+
+	now = now.Add(5 * time.Minute)
+	if got := authorization(); got != "Bearer token-2" {
+		t.Errorf("want the token to be refreshed after expiry, got %q", got)
+	}
+}
+
+func TestClientCredentialsAuthError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "invalid_client"}`))
+	}))
+	defer server.Close()
+
+	auth, err := NewClientCredentialsAuth(ClientCredentialsConfig{
+		TokenURL: server.URL,
+		ClientID: "foo",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://netbox.example.com/api/", nil)
+	if err := auth.Authenticate(context.Background(), req); err == nil {
+		t.Error("want an error, got nil")
+	}
+	if h := req.Header.Get("Authorization"); h != "" {
+		t.Errorf("want no Authorization header, got %q", h)
+	}
+}
+
+// newTokenAndAPIServer returns a handler serving access tokens token-1, token-2, ...
+// at /token, and NetBox API requests at /api/, which are rejected
+// unless they are authenticated with the given token.
+func newTokenAndAPIServer(acceptedToken string) (http.Handler, *int) {
+	var issued int
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			issued++
+			fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": 300}`, issued)
+		default:
+			if r.Header.Get("Authorization") != "Bearer "+acceptedToken {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"detail": "Invalid token"}`))
+				return
+			}
+			w.Write([]byte("{}"))
+		}
+	}), &issued
+}
+
+func TestClientCredentialsAuthRenewal(t *testing.T) {
+	// the first token is revoked before it expires
+	handler, issued := newTokenAndAPIServer("token-2")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	auth, err := NewClientCredentialsAuth(ClientCredentialsConfig{TokenURL: server.URL + "/token", ClientID: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, err := NewClient(server.URL+"/api", "", WithAuthProvider(auth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := nc.(*client)
+	c.httpClient.RetryMax = 0
+
+	if _, err := c.executeRequest(context.Background(), server.URL+"/api/", http.MethodGet, nil); err != nil {
+		t.Fatalf("want the request to succeed with a new token, got %s", err)
+	}
+	if *issued != 2 {
+		t.Errorf("want 2 tokens to be issued, got %d", *issued)
+	}
+}
+
+func TestClientCredentialsAuthTLS(t *testing.T) {
+	handler, _ := newTokenAndAPIServer("token-1")
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, serverCA, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := NewClientCredentialsAuth(ClientCredentialsConfig{TokenURL: server.URL + "/token", ClientID: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the token endpoint is only trusted with the CA certificates of the client
+	nc, err := NewClient(server.URL+"/api", "", WithCARootCert(caPath), WithAuthProvider(auth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := nc.(*client)
+	c.httpClient.RetryMax = 0
+
+	if _, err := c.executeRequest(context.Background(), server.URL+"/api/", http.MethodGet, nil); err != nil {
+		t.Errorf("want no error, got %s", err)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+// BranchHeader is the header of requests to NetBox that the schema ID
+// of the branch of their context is sent in, see WithBranch.
+const BranchHeader = "X-NetBox-Branch"
+
+// Statuses of branches and jobs of the NetBox branching plugin.
+const (
+	branchStatusReady  = "ready"
+	branchStatusFailed = "failed"
+
+	jobStatusCompleted = "completed"
+	jobStatusErrored   = "errored"
+	jobStatusFailed    = "failed"
+)
+
+// branchPollInterval is how often the status of a branch, or of the job
+// merging it, is checked while waiting for it to be provisioned or merged.
+var branchPollInterval = 2 * time.Second
+
+// Branch is a branch of the NetBox branching plugin, in which
+// changes are staged until the branch is merged.
+type Branch struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	SchemaID string `json:"schema_id"`
+}
+
+// Brancher is implemented by clients that can stage changes in a branch,
+// so that a large number of them is applied at once when it is merged,
+// rather than one by one.
+type Brancher interface {
+	// CreateBranch creates a branch with the given name,
+	// and waits until it is ready for changes.
+	CreateBranch(ctx context.Context, name string) (*Branch, error)
+	// MergeBranch merges the changes staged in the branch,
+	// and waits until they are applied.
+	MergeBranch(ctx context.Context, branch *Branch) error
+}
+
+type branchKey struct{}
+
+// WithBranch returns a context whose requests to NetBox are sent with
+// the schema ID of the branch in BranchHeader, so that the changes they
+// make are staged in the branch rather than applied right away.
+func WithBranch(ctx context.Context, branch *Branch) context.Context {
+	return context.WithValue(ctx, branchKey{}, branch.SchemaID)
+}
+
+// branchSchemaID returns the schema ID of the branch of the context, if any.
+func branchSchemaID(ctx context.Context) string {
+	id, _ := ctx.Value(branchKey{}).(string)
+	return id
+}
+
+// statusValue is the status of a branch or job, as returned by NetBox.
+type statusValue struct {
+	Value string `json:"value"`
+}
+
+// CreateBranch creates the branch, and polls it until it is provisioned.
+func (c *client) CreateBranch(ctx context.Context, name string) (*Branch, error) {
+	// the branch itself is managed outside of any branch
+	ctx = context.WithValue(ctx, branchKey{}, "")
+
+	url := fmt.Sprintf("%s/plugins/branching/branches/", c.baseURL)
+	data, err := c.executeRequest(ctx, url, http.MethodPost, map[string]interface{}{"name": name})
+	if err != nil {
+		return nil, fmt.Errorf("creating branch: %w", err)
+	}
+	var branch Branch
+	if err := json.Unmarshal(data, &branch); err != nil {
+		return nil, fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	url = fmt.Sprintf("%s/plugins/branching/branches/%d/", c.baseURL, branch.ID)
+	for {
+		data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+		if err != nil {
+			return nil, fmt.Errorf("getting branch: %w", err)
+		}
+		var b struct {
+			Branch
+			Status statusValue `json:"status"`
+		}
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, fmt.Errorf("unmarshaling response: %w", err)
+		}
+
+		switch b.Status.Value {
+		case branchStatusReady:
+			c.logger.Info("created branch in NetBox", log.String("branch", b.Name), log.String("schemaID", b.SchemaID))
+			return &b.Branch, nil
+		case branchStatusFailed:
+			return nil, fmt.Errorf("provisioning branch %q failed", name)
+		}
+
+		if err := sleep(ctx, branchPollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// MergeBranch starts the job merging the branch, and polls it until it is done.
+func (c *client) MergeBranch(ctx context.Context, branch *Branch) error {
+	ctx = context.WithValue(ctx, branchKey{}, "")
+
+	url := fmt.Sprintf("%s/plugins/branching/branches/%d/merge/", c.baseURL, branch.ID)
+	data, err := c.executeRequest(ctx, url, http.MethodPost, map[string]interface{}{"commit": true})
+	if err != nil {
+		return fmt.Errorf("merging branch: %w", err)
+	}
+	var job struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(data, &job); err != nil {
+		return fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	url = fmt.Sprintf("%s/core/jobs/%d/", c.baseURL, job.ID)
+	for {
+		data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+		if err != nil {
+			return fmt.Errorf("getting merge job: %w", err)
+		}
+		var j struct {
+			Status statusValue `json:"status"`
+			Error  string      `json:"error"`
+		}
+		if err := json.Unmarshal(data, &j); err != nil {
+			return fmt.Errorf("unmarshaling response: %w", err)
+		}
+
+		switch j.Status.Value {
+		case jobStatusCompleted:
+			c.logger.Info("merged branch in NetBox", log.String("branch", branch.Name))
+			return nil
+		case jobStatusErrored, jobStatusFailed:
+			return fmt.Errorf("merging branch %q failed: %s", branch.Name, j.Error)
+		}
+
+		if err := sleep(ctx, branchPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
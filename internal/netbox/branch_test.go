@@ -0,0 +1,133 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBranch(t *testing.T) {
+	defer func(interval time.Duration) { branchPollInterval = interval }(branchPollInterval)
+	branchPollInterval = time.Millisecond
+
+	tests := []struct {
+		name string
+		// statuses of the branch returned by successive polls
+		branchStatuses []string
+		// statuses of the merge job returned by successive polls
+		jobStatuses   []string
+		expectedError string
+	}{{
+		name:           "merged",
+		branchStatuses: []string{"new", "provisioning", "ready"},
+		jobStatuses:    []string{"pending", "running", "completed"},
+	}, {
+		name:           "provisioning failed",
+		branchStatuses: []string{"provisioning", "failed"},
+		expectedError:  `provisioning branch "test" failed`,
+	}, {
+		name:           "merge failed",
+		branchStatuses: []string{"ready"},
+		jobStatuses:    []string{"running", "errored"},
+		expectedError:  `merging branch "test" failed: conflicts`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []string
+			branchPolls, jobPolls := 0, 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				request := r.Method + " " + r.URL.Path
+				if branch := r.Header.Get(BranchHeader); branch != "" {
+					request += " in " + branch
+				}
+				requests = append(requests, request)
+
+				switch {
+				case r.Method == http.MethodPost && r.URL.Path == "/plugins/branching/branches/":
+					w.Write([]byte(`{"id": 1, "name": "test", "schema_id": "abc123", "status": {"value": "new"}}`))
+				case r.URL.Path == "/plugins/branching/branches/1/":
+					status := test.branchStatuses[branchPolls]
+					branchPolls++
+					fmt.Fprintf(w, `{"id": 1, "name": "test", "schema_id": "abc123", "status": {"value": %q}}`, status)
+				case r.URL.Path == "/plugins/branching/branches/1/merge/":
+					w.Write([]byte(`{"id": 7, "status": {"value": "pending"}}`))
+				case r.URL.Path == "/core/jobs/7/":
+					status := test.jobStatuses[jobPolls]
+					jobPolls++
+					fmt.Fprintf(w, `{"id": 7, "status": {"value": %q}, "error": "conflicts"}`, status)
+				case r.URL.Path == "/ipam/ip-addresses/5/":
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					t.Errorf("unexpected request %s", request)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			brancher := c.(Brancher)
+
+			err = func() error {
+				branch, err := brancher.CreateBranch(context.Background(), "test")
+				if err != nil {
+					return err
+				}
+				if branch.SchemaID != "abc123" {
+					t.Errorf("want schema ID abc123, got %q", branch.SchemaID)
+				}
+				ctx := WithBranch(context.Background(), branch)
+				if _, err := c.(*client).executeRequest(ctx, server.URL+"/ipam/ip-addresses/5/", http.MethodDelete, nil); err != nil {
+					return err
+				}
+				return brancher.MergeBranch(ctx, branch)
+			}()
+			if test.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+					t.Errorf("want error %q, got %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			expectedRequests := []string{
+				"POST /plugins/branching/branches/",
+				"GET /plugins/branching/branches/1/",
+				"GET /plugins/branching/branches/1/",
+				"GET /plugins/branching/branches/1/",
+				"DELETE /ipam/ip-addresses/5/ in abc123",
+				"POST /plugins/branching/branches/1/merge/",
+				"GET /core/jobs/7/",
+				"GET /core/jobs/7/",
+				"GET /core/jobs/7/",
+			}
+			if fmt.Sprint(requests) != fmt.Sprint(expectedRequests) {
+				t.Errorf("want requests %q, got %q", expectedRequests, requests)
+			}
+		})
+	}
+}
@@ -22,18 +22,23 @@ package netbox
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/netip"
 	"net/url"
-	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/digitalocean/netbox-ip-controller/internal/metrics"
+	"github.com/digitalocean/netbox-ip-controller/internal/ratelimit"
+	"github.com/digitalocean/netbox-ip-controller/internal/timing"
 
 	"github.com/hashicorp/go-cleanhttp"
 	retryablehttp "github.com/hashicorp/go-retryablehttp"
@@ -45,36 +50,104 @@ const (
 	// UIDCustomFieldName is the name of the custom field in NetBox,
 	// containing the UID of the resource that an IP is assigned to.
 	UIDCustomFieldName = "netbox_ip_controller_uid"
-	uidRegexpStr       = "^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}$"
+	// the UID may be preceded by a cluster-scoped prefix, see WithUIDPrefix
+	uidRegexpStr = "^(" + uidPrefixRegexpStr + "/)?[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}$"
+	// uidPrefixRegexpStr matches valid UID prefixes
+	uidPrefixRegexpStr = "[-a-zA-Z0-9_.]+"
 
 	// max size of response body that we ever expect to get, in bytes:
-	// a safeguard in case we get a never-ending or extremely long response
+	// a safeguard in case we get a never-ending or extremely long response.
+	// Lists may be longer, see listResponseBodySizeLimit.
 	responseBodySizeLimit = 1 << 20
 )
 
-// Client is a netbox client.
+// Client is a client of an IPAM system that the controller publishes IPs to.
+// It is implemented for NetBox by NewClient, and may be implemented for other
+// IPAM systems, as long as they can store the UID and tags of an IP.
 type Client interface {
 	GetTag(ctx context.Context, tag string) (*Tag, error)
 	CreateTag(ctx context.Context, tag string) (*Tag, error)
+	// GetIP returns the IP with the given UID, or nil if there is none.
 	GetIP(ctx context.Context, uid UID) (*IPAddress, error)
-	UpsertIP(ctx context.Context, ip *IPAddress) (*IPAddress, error)
+	// UpsertIP creates or updates the IP with the UID of ip, and returns
+	// the resulting IP, including its ID, even if it already existed and
+	// was unchanged, and whether it was created, updated or unchanged.
+	UpsertIP(ctx context.Context, ip *IPAddress) (upserted *IPAddress, result UpsertResult, err error)
+	// DeleteIP deletes the IP with the given UID, if it exists.
 	DeleteIP(ctx context.Context, uid UID) error
+	// ReleaseIP clears the UID of the IP with the given UID, if it exists,
+	// so that the IP is kept, but no longer managed by the controller.
+	ReleaseIP(ctx context.Context, uid UID) error
+	// DeprecateIP marks the IP with the given UID, if it exists, as deprecated,
+	// appends note to its description, and clears its UID, so that the IP
+	// is kept for review, but no longer managed by the controller.
+	DeprecateIP(ctx context.Context, uid UID, note string) error
+	// UpsertUIDField ensures that the IPAM system can store UIDs of IPs.
 	UpsertUIDField(ctx context.Context) error
+	// ListIPs returns the IPs that match filter. IPs that are not managed
+	// by the controller have no UID, including those with a different
+	// UID prefix, e.g. those managed by the controller of another cluster.
+	ListIPs(ctx context.Context, filter IPFilter) ([]IPAddress, error)
 }
 
 type client struct {
 	httpClient  *retryablehttp.Client
 	baseURL     string
-	token       string
+	auth        AuthProvider
 	rateLimiter *rate.Limiter
 	logger      *log.Logger
+	tls         tlsSettings
+	// names of headers and fields, in addition to DefaultSensitiveFields,
+	// whose values are redacted from logs and errors
+	sensitiveFields []string
+	redactor        *redactor
+	audit           AuditSink
+	// uidPrefix, if set, is prepended to UIDs stored in NetBox
+	uidPrefix string
+	// name of the custom field that UIDs are stored in, and of the
+	// one they were stored in before, if they are being migrated
+	uidFieldName         string
+	previousUIDFieldName string
+	// if true, an existing UID field with an outdated definition is left as it is
+	skipUIDFieldMigration bool
+	// what to do about several IPs with the same UID
+	duplicateStrategy string
+	// what to do about IPs with the same address, but without a UID
+	adoptionPolicy string
+	// allowedPrefixes, if not empty, are the only ranges in which IPs may be written
+	allowedPrefixes []netip.Prefix
+	// what to do about merged duplicates
+	deletionPolicy string
+	// bodyLogLimit, if greater than 0, is the size up to which
+	// request and response bodies are logged at debug level
+	bodyLogLimit int
+	// middlewares wrap the transport, the first one outermost
+	middlewares []Middleware
+
+	// IDs of the IPs written by the client, keyed by UID, used
+	// to tell when IPs have been deleted in NetBox behind its back
+	idsMu sync.Mutex
+	ids   map[UID]int64
+
+	// IDs of the IPs found by their UID in the previous UID field,
+	// which are written even if unchanged, so that the UID is moved
+	previousFieldIDs map[int64]bool
+
+	// IDs of interfaces referenced by name, so that they are looked up
+	// only once, rather than every time an IP assigned to them is upserted
+	interfacesMu sync.Mutex
+	interfaces   map[InterfaceRef]int64
+
+	// IDs of VRFs and tenants referenced by name and slug
+	lookups *lookupCache
 }
 
 // ClientOption is a function type to pass options to NewClient
 type ClientOption func(*client) error
 
 // NewClient sets up a new NetBox client with default authorization
-// and retries.
+// and retries. If apiToken is not empty, it is used to authenticate
+// requests, unless a different AuthProvider is set with WithAuthProvider.
 func NewClient(apiURL, apiToken string, opts ...ClientOption) (Client, error) {
 	u, err := parseAndValidateURL(apiURL)
 	if err != nil {
@@ -82,10 +155,15 @@ func NewClient(apiURL, apiToken string, opts ...ClientOption) (Client, error) {
 	}
 
 	c := &client{
-		httpClient: retryablehttp.NewClient(),
-		baseURL:    strings.TrimSuffix(u.String(), "/"),
-		token:      apiToken,
-		logger:     log.L(),
+		httpClient:   retryablehttp.NewClient(),
+		baseURL:      strings.TrimSuffix(u.String(), "/"),
+		logger:       log.L(),
+		lookups:      newLookupCache(DefaultLookupCacheTTL),
+		uidFieldName: UIDCustomFieldName,
+	}
+	c.httpClient.HTTPClient.Timeout = DefaultRequestTimeout
+	if apiToken != "" {
+		c.auth = NewTokenAuth(apiToken)
 	}
 
 	for _, opt := range opts {
@@ -93,9 +171,35 @@ func NewClient(apiURL, apiToken string, opts ...ClientOption) (Client, error) {
 			return nil, err
 		}
 	}
+	if c.previousUIDFieldName == c.uidFieldName {
+		return nil, fmt.Errorf("previous UID field name %q must differ from the UID field name", c.previousUIDFieldName)
+	}
+
+	c.redactor = newRedactor(append(append([]string{}, DefaultSensitiveFields...), c.sensitiveFields...))
+	c.redactor.auth = c.auth
 
 	c.httpClient.RetryMax = 5
-	c.httpClient.Logger = newRetryableHTTPLogger(c.logger)
+	c.httpClient.Backoff = retryAfterBackoff
+	c.httpClient.Logger = newRetryableHTTPLogger(c.logger, c.redactor)
+
+	if auth, ok := c.auth.(tlsAuth); ok {
+		if err := auth.useTLS(c.tls, u.Hostname()); err != nil {
+			return nil, err
+		}
+	}
+
+	if !c.tls.isZero() {
+		c.tls.host = u.Hostname()
+		// Use cleanhttp.DefaultTransport, as that's what is used by retryablehttp.NewClient()
+		transport := cleanhttp.DefaultTransport()
+		transport.TLSClientConfig = c.tls.config()
+		if c.tls.rootCAs != nil {
+			// make sure that subsequent requests are verified with the new certificates
+			c.tls.rootCAs.notifyReload(transport.CloseIdleConnections)
+		}
+		c.httpClient.HTTPClient.Transport = transport
+	}
+	c.wrapTransport()
 
 	if c.rateLimiter == nil {
 		c.rateLimiter = rate.NewLimiter(rate.Inf, 1)
@@ -112,6 +216,25 @@ func WithLogger(logger *log.Logger) ClientOption {
 	}
 }
 
+// WithAuthProvider sets the AuthProvider used to authenticate requests to NetBox,
+// replacing the static API token.
+func WithAuthProvider(auth AuthProvider) ClientOption {
+	return func(c *client) error {
+		c.auth = auth
+		return nil
+	}
+}
+
+// WithSensitiveFields adds names of headers, JSON fields and query parameters
+// whose values must be redacted from logs and errors, in addition to
+// DefaultSensitiveFields. Names are matched case-insensitively.
+func WithSensitiveFields(names ...string) ClientOption {
+	return func(c *client) error {
+		c.sensitiveFields = append(c.sensitiveFields, names...)
+		return nil
+	}
+}
+
 // WithRateLimiter is a functional option that attaches a token bucket style rate limiter
 // to the given client.
 func WithRateLimiter(refillRate rate.Limit, bucketSize int) ClientOption {
@@ -121,29 +244,265 @@ func WithRateLimiter(refillRate rate.Limit, bucketSize int) ClientOption {
 	}
 }
 
-// WithCARootCert is a functional option that adds the PEM-encoded root certificate
-// found at the given path to the TLSClientConfig of the client's underlying HTTPClient.
+// WithSharedRateLimiter attaches the given rate limiter to the client.
+// Its limit and burst may be changed while the client is in use.
+func WithSharedRateLimiter(limiter *rate.Limiter) ClientOption {
+	return func(c *client) error {
+		c.rateLimiter = limiter
+		return nil
+	}
+}
+
+// WithCARootCert is a functional option that makes the client verify NetBox server's
+// certificate against the PEM-encoded root certificates found at the given path.
+// The path may point to either a single file, or a directory containing
+// .pem, .crt or .cer files. The certificates are loaded once; use WithCAPool
+// with a watched CAPool to reload them whenever they change on disk.
 func WithCARootCert(path string) ClientOption {
 	return func(c *client) error {
-		cert, err := os.ReadFile(path)
+		pool, err := NewCAPool(path, c.logger)
 		if err != nil {
-			if c.logger != nil {
-				c.logger.Error(err.Error())
-			} else {
-				log.L().Error(err.Error())
-			}
+			c.logger.Error(err.Error())
 			return err
 		}
-		certPool := x509.NewCertPool()
-		if !certPool.AppendCertsFromPEM(cert) {
-			return errors.New("no certificates were successfully parsed")
+		c.tls.rootCAs = pool
+		return nil
+	}
+}
+
+// WithCAPool makes the client verify NetBox server's certificate against
+// the root certificates of the given pool. If the pool is watched, subsequent
+// connections are verified with the reloaded certificates.
+func WithCAPool(pool *CAPool) ClientOption {
+	return func(c *client) error {
+		c.tls.rootCAs = pool
+		return nil
+	}
+}
+
+// WithUIDPrefix makes the client store UIDs in NetBox as "<prefix>/<uid>",
+// so that objects from different clusters never collide on the UID custom field.
+// IPs stored with an unprefixed UID are still found, and their UID is
+// migrated to the prefixed one when they are next updated.
+func WithUIDPrefix(prefix string) ClientOption {
+	return func(c *client) error {
+		if !uidPrefixRegexp.MatchString(prefix) {
+			return fmt.Errorf("invalid UID prefix %q: must match %s", prefix, uidPrefixRegexp)
 		}
-		// Use cleanhttp.DefaultTransport, as that's what is used by retryablehttp.NewClient()
-		transport := cleanhttp.DefaultTransport()
-		transport.TLSClientConfig = &tls.Config{
-			RootCAs: certPool,
+		c.uidPrefix = prefix
+		return nil
+	}
+}
+
+var uidPrefixRegexp = regexp.MustCompile("^" + uidPrefixRegexpStr + "$")
+
+var uidRegexp = regexp.MustCompile(uidRegexpStr)
+
+// ValidUID returns true if uid, optionally with a UID prefix,
+// may be stored in the UID custom field in NetBox.
+func ValidUID(uid UID) bool {
+	return uidRegexp.MatchString(string(uid))
+}
+
+// Strategies for resolving several IPs with the same UID in NetBox.
+const (
+	// DuplicateStrategyFail fails every operation on the IP,
+	// until the duplicates are removed manually.
+	DuplicateStrategyFail = "fail"
+	// DuplicateStrategyAdoptOldest uses the oldest IP,
+	// and leaves the others as they are.
+	DuplicateStrategyAdoptOldest = "adopt-oldest"
+	// DuplicateStrategyMerge merges the tags and fields of the others into
+	// the oldest IP when it is next upserted, and then disposes of the others
+	// according to the deletion policy.
+	DuplicateStrategyMerge = "merge-and-delete-duplicates"
+)
+
+// Policies for IPs that are no longer needed, such as merged duplicates.
+const (
+	// DeletionPolicyDelete deletes the IP from NetBox.
+	DeletionPolicyDelete = "delete"
+	// DeletionPolicyRetain keeps the IP in NetBox, but clears its UID.
+	DeletionPolicyRetain = "retain"
+	// DeletionPolicyDeprecate keeps the IP in NetBox like
+	// DeletionPolicyRetain, but also sets its status to deprecated.
+	DeletionPolicyDeprecate = "deprecate"
+)
+
+// Policies for IPs that already exist in NetBox with the same address
+// as an IP being created, but that are not managed by the controller.
+const (
+	// AdoptionPolicyDuplicate creates another IP with the same address.
+	AdoptionPolicyDuplicate = "duplicate"
+	// AdoptionPolicySkip does not create the IP, failing with ErrUnmanagedIP.
+	AdoptionPolicySkip = "skip"
+	// AdoptionPolicyAdopt takes ownership of the existing IP, and updates it.
+	AdoptionPolicyAdopt = "adopt"
+	// AdoptionPolicyAdoptMatching takes ownership of the existing IP like
+	// AdoptionPolicyAdopt, but only if it also has the same DNS name,
+	// keeping its tags. Otherwise, another IP is created.
+	AdoptionPolicyAdoptMatching = "adopt-matching"
+)
+
+// UpsertResult is what UpsertIP did with an IP.
+type UpsertResult int
+
+const (
+	// UpsertUnchanged means that the IP already existed, and was not changed.
+	UpsertUnchanged UpsertResult = iota
+	// UpsertCreated means that the IP was created.
+	UpsertCreated
+	// UpsertUpdated means that the IP already existed, and was updated.
+	UpsertUpdated
+)
+
+// ErrUnmanagedIP is returned by UpsertIP with AdoptionPolicySkip, if the IP
+// already exists in NetBox, but is not managed by the controller.
+var ErrUnmanagedIP = errors.New("IP already exists in NetBox, but is not managed by the controller")
+
+// ErrDisallowedIP is matched by the DisallowedIPError returned by UpsertIP,
+// ReleaseIP, DeprecateIP and DeleteIP if the IP is outside of the prefixes
+// set with WithAllowedPrefixes.
+var ErrDisallowedIP = errors.New("IP is outside of the allowed prefixes")
+
+// DisallowedIPError is returned when the client refuses to write an IP,
+// because it is outside of the prefixes set with WithAllowedPrefixes.
+type DisallowedIPError struct {
+	// Operation is one of "upsert", "release", "deprecate" or "delete".
+	Operation string
+	Address   netip.Addr
+}
+
+func (e *DisallowedIPError) Error() string {
+	return fmt.Sprintf("refusing to %s IP %s: %s", e.Operation, e.Address, ErrDisallowedIP)
+}
+
+// Is makes errors.Is(err, ErrDisallowedIP) true for a DisallowedIPError.
+func (e *DisallowedIPError) Is(target error) bool {
+	return target == ErrDisallowedIP
+}
+
+// ErrConflict is returned when an IP is not updated, because it was changed
+// in NetBox, e.g. by someone else, since it was last synced.
+var ErrConflict = errors.New("IP was changed in NetBox since it was last synced")
+
+// ConflictError is returned when UpsertIP does not update an IP, because
+// its last updated time in NetBox differs from the one it was upserted with.
+type ConflictError struct {
+	// IP is the IP as changed in NetBox. Upserting it again with
+	// its last updated time overwrites the changes.
+	IP *IPAddress
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("updating IP %d: %s", e.IP.ID, ErrConflict)
+}
+
+// Is makes errors.Is(err, ErrConflict) true for a ConflictError.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// WithAllowedPrefixes makes the client refuse to create, update, release,
+// deprecate or delete IPs outside of the given prefixes, including
+// duplicates of IPs with the same UID, with a DisallowedIPError.
+func WithAllowedPrefixes(prefixes []netip.Prefix) ClientOption {
+	return func(c *client) error {
+		c.allowedPrefixes = prefixes
+		return nil
+	}
+}
+
+// allowed returns a DisallowedIPError, and increments the disallowed IPs
+// metric, if the IP may not be written with the given operation, that is,
+// if there are allowed prefixes and it is not within one of them.
+func allowed(prefixes []netip.Prefix, operation string, address IP) error {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(netip.Addr(address)) {
+			return nil
 		}
-		c.httpClient.HTTPClient.Transport = transport
+	}
+	metrics.IncrementDisallowedIPs(operation)
+	return &DisallowedIPError{Operation: operation, Address: netip.Addr(address)}
+}
+
+// WithAdoptionPolicy sets what the client does when it creates an IP,
+// whose address already exists in NetBox without a UID;
+// AdoptionPolicyDuplicate by default.
+func WithAdoptionPolicy(policy string) ClientOption {
+	return func(c *client) error {
+		switch policy {
+		case AdoptionPolicyDuplicate, AdoptionPolicySkip, AdoptionPolicyAdopt, AdoptionPolicyAdoptMatching:
+		default:
+			return fmt.Errorf("unknown adoption policy %q", policy)
+		}
+		c.adoptionPolicy = policy
+		return nil
+	}
+}
+
+// WithDuplicateStrategy sets what the client does when it finds
+// several IPs with the same UID; DuplicateStrategyFail by default.
+func WithDuplicateStrategy(strategy string) ClientOption {
+	return func(c *client) error {
+		switch strategy {
+		case DuplicateStrategyFail, DuplicateStrategyAdoptOldest, DuplicateStrategyMerge:
+		default:
+			return fmt.Errorf("unknown duplicate IP strategy %q", strategy)
+		}
+		c.duplicateStrategy = strategy
+		return nil
+	}
+}
+
+// WithDeletionPolicy sets what the client does with duplicates of an IP
+// after they are merged by DuplicateStrategyMerge; DeletionPolicyDelete by default.
+func WithDeletionPolicy(policy string) ClientOption {
+	return func(c *client) error {
+		switch policy {
+		case DeletionPolicyDelete, DeletionPolicyRetain, DeletionPolicyDeprecate:
+		default:
+			return fmt.Errorf("invalid deletion policy %q", policy)
+		}
+		c.deletionPolicy = policy
+		return nil
+	}
+}
+
+// storedUID returns the UID as stored in NetBox.
+func (c *client) storedUID(uid UID) UID {
+	if c.uidPrefix == "" {
+		return uid
+	}
+	return UID(c.uidPrefix + "/" + string(uid))
+}
+
+// WithTLSMinVersion sets the minimum TLS version accepted by the client,
+// e.g. tls.VersionTLS12.
+func WithTLSMinVersion(version uint16) ClientOption {
+	return func(c *client) error {
+		c.tls.minVersion = version
+		return nil
+	}
+}
+
+// WithTLSCipherSuites restricts the cipher suites the client may use
+// for TLS 1.0-1.2 connections. TLS 1.3 cipher suites are not configurable.
+func WithTLSCipherSuites(ids []uint16) ClientOption {
+	return func(c *client) error {
+		c.tls.cipherSuites = ids
+		return nil
+	}
+}
+
+// WithTLSServerName pins the name that NetBox server's certificate must be valid for.
+// When set, the certificate's SANs must match this name instead of the host in the API URL.
+func WithTLSServerName(name string) ClientOption {
+	return func(c *client) error {
+		c.tls.serverName = name
 		return nil
 	}
 }
@@ -162,8 +521,9 @@ func parseAndValidateURL(apiURL string) (*url.URL, error) {
 // (e.g. PUT /someobj/1/, DELETE /someobj/1/): without it, NetBox will always return
 // 200 without actually making any changes ¯\_(ツ)_/¯
 
-// UpsertUIDField adds a custom field with name UIDCustomFieldName
-// to NetBox IPAddresses if it doesn't exist.
+// UpsertUIDField adds the UID custom field, named UIDCustomFieldName
+// unless set with WithUIDFieldName, to NetBox IPAddresses if it doesn't exist,
+// and migrates it to the current definition if it does, see migrateUIDField.
 func (c *client) UpsertUIDField(ctx context.Context) error {
 	existingField, err := c.getCustomUIDField(ctx)
 	if err != nil {
@@ -171,32 +531,35 @@ func (c *client) UpsertUIDField(ctx context.Context) error {
 	}
 
 	if existingField != nil {
-		c.logger.Info("UID field already exists")
-		return nil
+		return c.migrateUIDField(ctx, existingField)
 	}
 
 	url := fmt.Sprintf("%s/extras/custom-fields/", c.baseURL)
 
-	field := CustomField{
-		ContentTypes:    []string{"ipam.ipaddress"},
-		Description:     "UID of the object the IP is assigned to.",
-		FilterLogic:     "exact",
-		Label:           "UID",
-		Name:            UIDCustomFieldName,
-		Required:        false,
-		Type:            "text",
-		ValidationRegex: uidRegexpStr,
-		Weight:          100,
+	field := c.uidFieldDefinition()
+	record := AuditRecord{
+		Operation: AuditOperationCreate,
+		Object:    AuditObjectCustomField,
+		Name:      c.uidFieldName,
 	}
-
-	if _, err := c.executeRequest(ctx, url, http.MethodPost, field); err != nil {
+	data, err := c.executeRequest(ctx, url, http.MethodPost, field)
+	if err != nil {
+		c.recordFailedAudit(record, err)
 		return fmt.Errorf("executing request: %w", err)
 	}
+
+	var createdField CustomField
+	if err := json.Unmarshal(data, &createdField); err != nil {
+		return fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	record.ID = createdField.ID
+	c.recordAudit(record)
 	return nil
 }
 
 func (c *client) getCustomUIDField(ctx context.Context) (*CustomField, error) {
-	url := fmt.Sprintf("%s/extras/custom-fields/?name=%s", c.baseURL, UIDCustomFieldName)
+	url := fmt.Sprintf("%s/extras/custom-fields/?name=%s", c.baseURL, c.uidFieldName)
 
 	data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
 	if err != nil {
@@ -210,7 +573,7 @@ func (c *client) getCustomUIDField(ctx context.Context) (*CustomField, error) {
 
 	if len(fieldList.Results) > 1 {
 		// should never happen since names of custom fields must be unique
-		return nil, fmt.Errorf("more than one custom field %q found", UIDCustomFieldName)
+		return nil, fmt.Errorf("more than one custom field %q found", c.uidFieldName)
 	}
 	if len(fieldList.Results) == 0 {
 		return nil, nil
@@ -245,16 +608,26 @@ func (c *client) GetTag(ctx context.Context, tag string) (*Tag, error) {
 }
 
 // CreateTag creates a tag with the given name. Tag slug is set to the
-// same value as tag name.
+// same value as tag name, and its description to CreatedTagDescription,
+// so that it can be garbage collected once it is no longer used.
 func (c *client) CreateTag(ctx context.Context, tag string) (*Tag, error) {
 	url := fmt.Sprintf("%s/extras/tags/", c.baseURL)
 
-	t := &Tag{
-		Name: tag,
-		Slug: tag,
+	t := &tagWithUsage{
+		Tag: Tag{
+			Name: tag,
+			Slug: tag,
+		},
+		Description: CreatedTagDescription,
+	}
+	record := AuditRecord{
+		Operation: AuditOperationCreate,
+		Object:    AuditObjectTag,
+		Name:      tag,
 	}
 	data, err := c.executeRequest(ctx, url, http.MethodPost, t)
 	if err != nil {
+		c.recordFailedAudit(record, err)
 		return nil, fmt.Errorf("executing request: %w", err)
 	}
 
@@ -263,12 +636,99 @@ func (c *client) CreateTag(ctx context.Context, tag string) (*Tag, error) {
 		return nil, fmt.Errorf("unmarshaling response: %w", err)
 	}
 
+	record.ID = createdTag.ID
+	c.recordAudit(record)
+
 	return &createdTag, nil
 }
 
-// GetIP returns an IP address with the given ID.
+// GetIP returns an IP address with the given UID.
 func (c *client) GetIP(ctx context.Context, uid UID) (*IPAddress, error) {
-	url := fmt.Sprintf("%s/ipam/ip-addresses/?cf_%s=%s", c.baseURL, UIDCustomFieldName, uid)
+	ip, err := c.getStoredIP(ctx, uid)
+	if ip != nil {
+		ip.UID = uid
+		ip.WebURL = c.webURL(ip.ID)
+	}
+	return ip, err
+}
+
+// webURL returns the URL of the IP with the given ID in the web UI
+// of NetBox, which is served under the base URL of the API.
+func (c *client) webURL(id int64) string {
+	return fmt.Sprintf("%s/ipam/ip-addresses/%d/", strings.TrimSuffix(c.baseURL, "/api"), id)
+}
+
+// getExistingIPs returns the IPs with the UID of ip, like getStoredIPs.
+// If the ID of ip is set, the IP with the ID is read instead, which is
+// cheaper than filtering IPs by the UID custom field, unless it no longer
+// has the UID, e.g. because it was deleted or re-used in NetBox. Since
+// duplicates are not found this way, the ID is not used when they are
+// to be merged.
+func (c *client) getExistingIPs(ctx context.Context, ip *IPAddress) ([]IPAddress, error) {
+	if ip.ID == 0 || c.duplicateStrategy == DuplicateStrategyMerge {
+		return c.getStoredIPs(ctx, ip.UID)
+	}
+
+	url := fmt.Sprintf("%s/ipam/ip-addresses/%d/", c.baseURL, ip.ID)
+	data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+	if isNotFound(err) {
+		return c.getStoredIPs(ctx, ip.UID)
+	} else if err != nil {
+		return nil, fmt.Errorf("executing request: %w", err)
+	}
+
+	var existingIP IPAddress
+	if err := c.unmarshalIP(data, &existingIP); err != nil {
+		return nil, fmt.Errorf("unmarshaling response: %w", err)
+	}
+	if existingIP.UID != c.storedUID(ip.UID) && existingIP.UID != ip.UID {
+		return c.getStoredIPs(ctx, ip.UID)
+	}
+	return []IPAddress{existingIP}, nil
+}
+
+// getStoredIP returns an IP address with the given UID, as it is stored in NetBox,
+// i.e. with the UID prefix, if any. If there are several, unless the duplicate
+// strategy is DuplicateStrategyFail, the oldest one is returned as it is:
+// duplicates are only resolved when the IP is upserted.
+func (c *client) getStoredIP(ctx context.Context, uid UID) (*IPAddress, error) {
+	ips, err := c.getStoredIPs(ctx, uid)
+	if err != nil || len(ips) == 0 {
+		return nil, err
+	}
+	if len(ips) > 1 {
+		c.logger.Warn("more than one IP with the same UID found - using the oldest",
+			log.String("uid", string(uid)), log.Int64("id", ips[0].ID), log.Int("count", len(ips)))
+	}
+	return &ips[0], nil
+}
+
+// getStoredIPs returns the IPs with the given UID, as they are stored in NetBox,
+// oldest first. If the UID prefix is set, but there are no IPs with the prefixed
+// UID, it falls back to IPs stored with the unprefixed UID.
+// If the previous UID field is set, IPs with the UID in it are looked up last.
+func (c *client) getStoredIPs(ctx context.Context, uid UID) ([]IPAddress, error) {
+	fields := []string{c.uidFieldName}
+	if c.previousUIDFieldName != "" {
+		fields = append(fields, c.previousUIDFieldName)
+	}
+	for _, field := range fields {
+		ips, err := c.getIPsByStoredUID(ctx, field, c.storedUID(uid))
+		if err == nil && len(ips) == 0 && c.uidPrefix != "" {
+			ips, err = c.getIPsByStoredUID(ctx, field, uid)
+		}
+		if err != nil || len(ips) > 0 {
+			if field != c.uidFieldName {
+				c.setMovingUIDs(ips)
+			}
+			return ips, err
+		}
+	}
+	return nil, nil
+}
+
+func (c *client) getIPsByStoredUID(ctx context.Context, field string, uid UID) ([]IPAddress, error) {
+	url := fmt.Sprintf("%s/ipam/ip-addresses/?cf_%s=%s", c.baseURL, field, url.QueryEscape(string(uid)))
 
 	data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
 	if err != nil {
@@ -276,76 +736,733 @@ func (c *client) GetIP(ctx context.Context, uid UID) (*IPAddress, error) {
 	}
 
 	var ipList IPAddressList
-	if err := json.Unmarshal(data, &ipList); err != nil {
+	if err := c.unmarshalIPList(data, &ipList); err != nil {
 		return nil, fmt.Errorf("unmarshaling response: %w", err)
 	}
 
-	if len(ipList.Results) > 1 {
-		// may happen either when a duplicate is accidentally created,
-		// or if the UID custom field hasn't been created (in this case
-		// NetBox won't do any filtering at all)
-		return nil, fmt.Errorf("more than one IP with UID %q found", uid)
+	ips := ipList.Results
+	if len(ips) > 1 {
+		for _, ip := range ips {
+			// if the UID custom field hasn't been created,
+			// NetBox won't do any filtering at all
+			if ip.UID != uid {
+				return nil, fmt.Errorf("more than one IP with UID %q found: is the %s custom field missing?", uid, field)
+			}
+		}
+
+		strategy := c.duplicateStrategy
+		if strategy == "" {
+			strategy = DuplicateStrategyFail
+		}
+		metrics.IncrementDuplicateIPs(strategy)
+		if strategy == DuplicateStrategyFail {
+			return nil, fmt.Errorf("more than one IP with UID %q found", uid)
+		}
+		sort.Slice(ips, func(i, j int) bool { return ips[i].ID < ips[j].ID })
 	}
-	if len(ipList.Results) == 0 {
-		return nil, nil
+
+	return ips, nil
+}
+
+// getOwnedIPs returns the IPs with the given UID, like getStoredIPs, before
+// they are deleted or released. Since looking them up by their UID and changing
+// them are separate requests, each IP is read again by its ID, and left out
+// if its UID no longer matches: the IP may have been re-used in the meantime,
+// e.g. for a new pod that got the address of a completed one. The re-read
+// and the change are still separate requests, so this narrows the window
+// in which a re-used IP may be changed, but does not close it.
+// Duplicates are only included with DuplicateStrategyMerge.
+func (c *client) getOwnedIPs(ctx context.Context, uid UID) ([]IPAddress, error) {
+	ips, err := c.getStoredIPs(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) > 1 && c.duplicateStrategy != DuplicateStrategyMerge {
+		ips = ips[:1]
+	}
+
+	var owned []IPAddress
+	for _, ip := range ips {
+		url := fmt.Sprintf("%s/ipam/ip-addresses/%d/", c.baseURL, ip.ID)
+		data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("executing request: %w", err)
+		}
+
+		var currentIP IPAddress
+		if err := c.unmarshalIP(data, &currentIP); err != nil {
+			return nil, fmt.Errorf("unmarshaling response: %w", err)
+		}
+		if currentIP.UID != c.storedUID(uid) && currentIP.UID != uid {
+			c.logger.Warn("not changing IP: its UID no longer matches",
+				log.String("uid", string(uid)), log.Int64("id", currentIP.ID), log.String("currentUID", string(currentIP.UID)))
+			metrics.IncrementUIDMismatches()
+			continue
+		}
+		owned = append(owned, currentIP)
+	}
+	return owned, nil
+}
+
+// checkUnmodified returns a ConflictError if existingIP is the IP with the ID
+// of ip, whose last updated time in NetBox differs from the one of ip, i.e. the
+// one of the last time it was synced, so that changes by someone else are not
+// silently overwritten. IPs upserted without a last updated time are not checked.
+func (c *client) checkUnmodified(ip, existingIP *IPAddress) error {
+	if ip.LastUpdated == "" || ip.ID != existingIP.ID || ip.LastUpdated == existingIP.LastUpdated {
+		return nil
+	}
+	c.logger.Warn("not updating IP: it was changed in NetBox since it was last synced",
+		log.Int64("id", existingIP.ID), log.String("synced", ip.LastUpdated), log.String("lastUpdated", existingIP.LastUpdated))
+	metrics.IncrementConflicts()
+	conflictingIP := *existingIP
+	conflictingIP.UID = ip.UID
+	conflictingIP.WebURL = c.webURL(existingIP.ID)
+	return &ConflictError{IP: &conflictingIP}
+}
+
+// mergeDuplicates merges the tags and fields of the duplicates of the oldest
+// IP with the given UID into it, and then disposes of the duplicates according
+// to the deletion policy. It returns the merged IP.
+func (c *client) mergeDuplicates(ctx context.Context, uid UID, ips []IPAddress) (*IPAddress, error) {
+	kept := ips[0]
+	merged := mergeIPs(kept, ips[1:])
+
+	if kept.changed(&merged) {
+		record := AuditRecord{
+			Operation: AuditOperationUpdate,
+			Object:    AuditObjectIPAddress,
+			ID:        kept.ID,
+			UID:       string(uid),
+			Address:   addressString(kept.Address),
+			Changes:   ipChanges(&kept, &merged),
+		}
+		body, err := c.marshalIP(&merged)
+		if err != nil {
+			return nil, err
+		}
+		url := fmt.Sprintf("%s/ipam/ip-addresses/%d/", c.baseURL, kept.ID)
+		if _, err := c.executeRequest(ctx, url, http.MethodPut, body); err != nil {
+			c.recordFailedAudit(record, err)
+			return nil, fmt.Errorf("merging duplicates into IP %d: %w", kept.ID, err)
+		}
+		c.recordAudit(record)
+	}
+
+	for i := range ips[1:] {
+		duplicate := &ips[i+1]
+		var err error
+		switch c.deletionPolicy {
+		case DeletionPolicyRetain:
+			err = c.releaseRecord(ctx, uid, duplicate)
+		case DeletionPolicyDeprecate:
+			err = c.deprecateRecord(ctx, uid, duplicate, fmt.Sprintf("(duplicate of IP %d)", kept.ID))
+		default:
+			err = c.deleteRecord(ctx, uid, duplicate)
+		}
+		if errors.Is(err, ErrDisallowedIP) {
+			c.logger.Warn("not disposing of duplicate IP: outside of the allowed prefixes",
+				log.String("uid", string(uid)), log.Int64("id", duplicate.ID))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("disposing of duplicate IP %d: %w", duplicate.ID, err)
+		}
+	}
+
+	return &merged, nil
+}
+
+// mergeIPs returns the IP with the tags of all the duplicates added to it,
+// and with its fields that are not set taken from the first duplicate that has them.
+func mergeIPs(ip IPAddress, duplicates []IPAddress) IPAddress {
+	merged := ip
+	merged.Tags = append([]Tag{}, ip.Tags...)
+	merged.CustomFields = make(map[string]string)
+	for name, value := range ip.CustomFields {
+		merged.CustomFields[name] = value
+	}
+
+	tags := make(map[string]bool)
+	for _, tag := range ip.Tags {
+		tags[tag.Name] = true
+	}
+	for _, duplicate := range duplicates {
+		for _, tag := range duplicate.Tags {
+			if !tags[tag.Name] {
+				tags[tag.Name] = true
+				merged.Tags = append(merged.Tags, tag)
+			}
+		}
+		for name, value := range duplicate.CustomFields {
+			if merged.CustomFields[name] == "" && value != "" {
+				merged.CustomFields[name] = value
+			}
+		}
+		if merged.DNSName == "" {
+			merged.DNSName = duplicate.DNSName
+		}
+		if merged.Description == "" {
+			merged.Description = duplicate.Description
+		}
+		if merged.Tenant == nil {
+			merged.Tenant = duplicate.Tenant
+		}
+		if merged.VRF == nil {
+			merged.VRF = duplicate.VRF
+		}
+		if merged.AssignedObjectType == "" {
+			merged.AssignedObjectType = duplicate.AssignedObjectType
+			merged.AssignedObjectID = duplicate.AssignedObjectID
+		}
+	}
+	return merged
+}
+
+// getUnmanagedIP returns the oldest IP with the given address in the given VRF,
+// or in the global table if vrf is nil, that has no UID, or nil if there is none.
+// If dnsName is not nil, only IPs with that DNS name are considered.
+func (c *client) getUnmanagedIP(ctx context.Context, address IP, vrf *VRF, dnsName *string) (*IPAddress, error) {
+	query := url.Values{}
+	query.Set("address", netip.Addr(address).String())
+	if vrf == nil {
+		query.Set("vrf_id", "null")
+	} else {
+		id, err := c.vrfID(ctx, vrf)
+		if err != nil {
+			return nil, fmt.Errorf("looking up VRF: %w", err)
+		}
+		if id == 0 {
+			// no IPs can exist in a VRF that doesn't
+			return nil, nil
+		}
+		query.Set("vrf_id", strconv.FormatInt(id, 10))
+	}
+	url := fmt.Sprintf("%s/ipam/ip-addresses/?%s", c.baseURL, query.Encode())
+
+	data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+	if err != nil {
+		return nil, fmt.Errorf("executing request: %w", err)
+	}
+
+	var ipList IPAddressList
+	if err := c.unmarshalIPList(data, &ipList); err != nil {
+		return nil, fmt.Errorf("unmarshaling response: %w", err)
 	}
 
-	return &ipList.Results[0], nil
+	var oldest *IPAddress
+	for i, ip := range ipList.Results {
+		if dnsName != nil && !strings.EqualFold(ip.DNSName, *dnsName) {
+			continue
+		}
+		if ip.UID == "" && (oldest == nil || ip.ID < oldest.ID) {
+			oldest = &ipList.Results[i]
+		}
+	}
+	return oldest, nil
+}
+
+// interfaceID returns the ID of the referenced interface.
+// IDs of interfaces referenced by name are cached. If the reference has
+// a MAC address, the interface is created if it does not exist, and its
+// MAC address is updated if it differs.
+func (c *client) interfaceID(ctx context.Context, ref *InterfaceRef) (int64, error) {
+	if ref.ID != 0 {
+		return ref.ID, nil
+	}
+
+	c.interfacesMu.Lock()
+	id, ok := c.interfaces[*ref]
+	c.interfacesMu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	endpoint, parentField, err := interfaceEndpoint(ref.Type)
+	if err != nil {
+		return 0, err
+	}
+	query := url.Values{}
+	query.Set("name", ref.Name)
+	query.Set(parentField, ref.Parent)
+
+	url := fmt.Sprintf("%s/%s/?%s", c.baseURL, endpoint, query.Encode())
+	data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+	if err != nil {
+		return 0, fmt.Errorf("executing request: %w", err)
+	}
+
+	var interfaces InterfaceList
+	if err := json.Unmarshal(data, &interfaces); err != nil {
+		return 0, fmt.Errorf("unmarshaling response: %w", err)
+	}
+	switch {
+	case len(interfaces.Results) == 0 && ref.MACAddress != "":
+		// interfaces with a MAC address are created by the controller
+		if id, err = c.createInterface(ctx, ref); err != nil {
+			return 0, err
+		}
+	case len(interfaces.Results) != 1:
+		return 0, fmt.Errorf("found %d interfaces named %q on %q", len(interfaces.Results), ref.Name, ref.Parent)
+	default:
+		id = interfaces.Results[0].ID
+		if mac := interfaces.Results[0].MACAddress; ref.MACAddress != "" && !strings.EqualFold(mac, ref.MACAddress) {
+			if err := c.updateInterfaceMAC(ctx, ref, id, mac); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	c.interfacesMu.Lock()
+	defer c.interfacesMu.Unlock()
+	if c.interfaces == nil {
+		c.interfaces = make(map[InterfaceRef]int64)
+	}
+	c.interfaces[*ref] = id
+	return id, nil
+}
+
+// forgetInterfaceID removes the cached ID of the referenced interface,
+// e.g. because the interface may have been re-created with a new ID.
+func (c *client) forgetInterfaceID(ref *InterfaceRef) {
+	c.interfacesMu.Lock()
+	defer c.interfacesMu.Unlock()
+	delete(c.interfaces, *ref)
 }
 
 // UpsertIP creates an IP address or updates one, if an IP with the same
-// UID already exists.
-func (c *client) UpsertIP(ctx context.Context, ip *IPAddress) (*IPAddress, error) {
-	existingIP, err := c.GetIP(ctx, ip.UID)
+// UID already exists. Whether an IP with the same address, but without
+// a UID, is used instead of creating one depends on the adoption policy.
+func (c *client) UpsertIP(ctx context.Context, ip *IPAddress) (*IPAddress, UpsertResult, error) {
+	if uid := c.storedUID(ip.UID); !ValidUID(uid) {
+		// NetBox would reject it with the validation regex of the UID field
+		return nil, UpsertUnchanged, fmt.Errorf("invalid UID %q: must match %s", uid, uidRegexpStr)
+	}
+	if err := allowed(c.allowedPrefixes, "upsert", ip.Address); err != nil {
+		c.recordFailedAudit(AuditRecord{
+			Operation: AuditOperationUpsert,
+			Object:    AuditObjectIPAddress,
+			UID:       string(ip.UID),
+			Address:   addressString(ip.Address),
+		}, err)
+		return nil, UpsertUnchanged, err
+	}
+
+	if ip.AssignedInterface != nil {
+		id, err := c.interfaceID(ctx, ip.AssignedInterface)
+		if err != nil {
+			return nil, UpsertUnchanged, fmt.Errorf("looking up assigned interface: %w", err)
+		}
+		assignedIP := *ip
+		assignedIP.AssignedObjectType = ip.AssignedInterface.Type
+		assignedIP.AssignedObjectID = id
+		ip = &assignedIP
+	}
+
+	if ip.NATInsideUID != "" {
+		insideIP, err := c.getStoredIP(ctx, ip.NATInsideUID)
+		if err != nil {
+			return nil, UpsertUnchanged, fmt.Errorf("looking up NAT inside IP: %w", err)
+		}
+		if insideIP == nil {
+			// the IP is linked once the inside IP has been created
+			c.logger.Debug("NAT inside IP does not exist yet", log.String("uid", string(ip.NATInsideUID)))
+		} else {
+			natIP := *ip
+			natIP.NATInside = &NestedIP{ID: insideIP.ID}
+			ip = &natIP
+		}
+	}
+
+	existingIPs, err := c.getExistingIPs(ctx, ip)
 	if err != nil {
-		return nil, fmt.Errorf("checking for existing IP: %w", err)
+		return nil, UpsertUnchanged, fmt.Errorf("checking for existing IP: %w", err)
+	}
+	var existingIP *IPAddress
+	if len(existingIPs) > 0 {
+		existingIP = &existingIPs[0]
+	}
+	if len(existingIPs) > 1 {
+		c.logger.Warn("more than one IP with the same UID found - using the oldest",
+			log.String("uid", string(ip.UID)), log.Int64("id", existingIP.ID), log.Int("count", len(existingIPs)))
+		if c.duplicateStrategy == DuplicateStrategyMerge {
+			if existingIP, err = c.mergeDuplicates(ctx, ip.UID, existingIPs); err != nil {
+				return nil, UpsertUnchanged, err
+			}
+		}
 	}
 
-	if existingIP != nil && !existingIP.changed(ip) {
+	if existingIP == nil && ip.TakeOverUID != "" {
+		if existingIP, err = c.getStoredIP(ctx, ip.TakeOverUID); err != nil {
+			return nil, UpsertUnchanged, fmt.Errorf("checking for IP to take over: %w", err)
+		}
+		if existingIP != nil {
+			c.logger.Info("taking over IP", log.String("from", string(ip.TakeOverUID)), log.Int64("id", existingIP.ID))
+			c.forgetID(ip.TakeOverUID)
+		}
+	}
+
+	if existingIP == nil && c.adoptionPolicy != "" && c.adoptionPolicy != AdoptionPolicyDuplicate {
+		var dnsName *string
+		if c.adoptionPolicy == AdoptionPolicyAdoptMatching {
+			dnsName = &ip.DNSName
+		}
+		unmanagedIP, err := c.getUnmanagedIP(ctx, ip.Address, ip.VRF, dnsName)
+		if err != nil {
+			return nil, UpsertUnchanged, fmt.Errorf("checking for unmanaged IP: %w", err)
+		}
+		if unmanagedIP != nil {
+			if c.adoptionPolicy == AdoptionPolicySkip {
+				return nil, UpsertUnchanged, ErrUnmanagedIP
+			}
+			c.logger.Info("adopting IP", log.Int64("id", unmanagedIP.ID))
+			existingIP = unmanagedIP
+			if c.adoptionPolicy == AdoptionPolicyAdoptMatching {
+				// the tags the IP was given in NetBox are kept alongside ours
+				adoptedIP := *ip
+				adoptedIP.Tags = mergeIPs(*ip, []IPAddress{*unmanagedIP}).Tags
+				ip = &adoptedIP
+			}
+		}
+	}
+
+	// an IP stored with an unprefixed UID, or in the previous UID field,
+	// is considered changed, so that its UID is migrated
+	storedIP := *ip
+	storedIP.ID = 0
+	storedIP.UID = c.storedUID(ip.UID)
+
+	if existingIP == nil {
+		if id, ok := c.knownID(ip.UID); ok {
+			c.ipLost(ip.UID, id)
+		}
+	} else if !c.movingUID(existingIP.ID) && !existingIP.changed(&storedIP) {
 		c.logger.Info("IP has not changed - not updating")
-		return nil, nil
+		c.setKnownID(ip.UID, existingIP.ID)
+		unchangedIP := *existingIP
+		unchangedIP.UID = ip.UID
+		unchangedIP.WebURL = c.webURL(existingIP.ID)
+		return &unchangedIP, UpsertUnchanged, nil
+	}
+	if err := c.resolveReferences(ctx, &storedIP); err != nil {
+		return nil, UpsertUnchanged, err
 	}
 
+	body, err := c.marshalIP(&storedIP)
+	if err != nil {
+		return nil, UpsertUnchanged, err
+	}
 	var data []byte
 	if existingIP != nil {
+		// the IP may have had another address in NetBox
+		if err := allowed(c.allowedPrefixes, "upsert", existingIP.Address); err != nil {
+			c.recordFailedAudit(upsertAudit(ip.UID, existingIP, &storedIP), err)
+			return nil, UpsertUnchanged, err
+		}
+		if err := c.checkUnmodified(ip, existingIP); err != nil {
+			c.recordFailedAudit(upsertAudit(ip.UID, existingIP, &storedIP), err)
+			return nil, UpsertUnchanged, err
+		}
 		url := fmt.Sprintf("%s/ipam/ip-addresses/%d/", c.baseURL, existingIP.ID)
-		data, err = c.executeRequest(ctx, url, http.MethodPut, ip)
-	} else {
+		data, err = c.executeRequest(ctx, url, http.MethodPut, body)
+		if isNotFound(err) {
+			// deleted in NetBox since it was looked up
+			c.ipLost(ip.UID, existingIP.ID)
+			existingIP = nil
+		}
+	}
+	if existingIP == nil {
 		url := fmt.Sprintf("%s/ipam/ip-addresses/", c.baseURL)
-		data, err = c.executeRequest(ctx, url, http.MethodPost, ip)
+		data, err = c.executeRequest(ctx, url, http.MethodPost, body)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("executing request: %w", err)
+		c.recordFailedAudit(upsertAudit(ip.UID, existingIP, &storedIP), err)
+		if ip.AssignedInterface != nil {
+			// the cached interface ID may be stale, and is looked up again next time
+			c.forgetInterfaceID(ip.AssignedInterface)
+		}
+		// so are the cached IDs of the VRF and tenant
+		c.forgetReferences(ip)
+		return nil, UpsertUnchanged, fmt.Errorf("executing request: %w", err)
 	}
 
 	var createdIP IPAddress
-	if err := json.Unmarshal(data, &createdIP); err != nil {
-		return nil, fmt.Errorf("unmarshaling response: %w", err)
+	if err := c.unmarshalIP(data, &createdIP); err != nil {
+		return nil, UpsertUnchanged, fmt.Errorf("unmarshaling response: %w", err)
 	}
+	createdIP.UID = ip.UID
+	createdIP.WebURL = c.webURL(createdIP.ID)
+	c.setKnownID(ip.UID, createdIP.ID)
 
-	return &createdIP, nil
+	record := upsertAudit(ip.UID, existingIP, &storedIP)
+	if existingIP == nil {
+		record.ID = createdIP.ID
+	}
+	c.recordAudit(record)
+
+	if existingIP != nil {
+		return &createdIP, UpsertUpdated, nil
+	}
+	return &createdIP, UpsertCreated, nil
+}
+
+// upsertAudit returns the audit record of creating storedIP,
+// or of updating existingIP to it, if existingIP is not nil.
+func upsertAudit(uid UID, existingIP, storedIP *IPAddress) AuditRecord {
+	record := AuditRecord{
+		Operation: AuditOperationCreate,
+		Object:    AuditObjectIPAddress,
+		UID:       string(uid),
+		Address:   addressString(storedIP.Address),
+		Changes:   ipChanges(existingIP, storedIP),
+	}
+	if existingIP != nil {
+		record.Operation = AuditOperationUpdate
+		record.ID = existingIP.ID
+	}
+	return record
 }
 
 // DeleteIP deletes an IP with the given UID from NetBox.
 func (c *client) DeleteIP(ctx context.Context, uid UID) error {
-	existingIP, err := c.GetIP(ctx, uid)
+	c.forgetID(uid)
+
+	existingIPs, err := c.getOwnedIPs(ctx, uid)
 	if err != nil {
 		return fmt.Errorf("checking if IP exists: %w", err)
 	}
 
-	if existingIP == nil {
-		return nil
+	// the IPs within the allowed prefixes are deleted
+	// even if some of the duplicates are not
+	var disallowed error
+	for i := range existingIPs {
+		existingIP := &existingIPs[i]
+		err := c.deleteRecord(ctx, uid, existingIP)
+		if errors.Is(err, ErrDisallowedIP) {
+			disallowed = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.deleteCreatedInterface(ctx, existingIP)
 	}
 
+	return disallowed
+}
+
+func (c *client) deleteRecord(ctx context.Context, uid UID, existingIP *IPAddress) error {
+	record := AuditRecord{
+		Operation: AuditOperationDelete,
+		Object:    AuditObjectIPAddress,
+		ID:        existingIP.ID,
+		UID:       string(uid),
+		Address:   addressString(existingIP.Address),
+		Changes:   ipChanges(existingIP, nil),
+	}
+	if err := allowed(c.allowedPrefixes, "delete", existingIP.Address); err != nil {
+		c.recordFailedAudit(record, err)
+		return err
+	}
 	url := fmt.Sprintf("%s/ipam/ip-addresses/%d/", c.baseURL, existingIP.ID)
 	if _, err := c.executeRequest(ctx, url, http.MethodDelete, nil); err != nil {
+		c.recordFailedAudit(record, err)
 		return fmt.Errorf("executing request: %w", err)
 	}
 
+	c.recordAudit(record)
+
 	return nil
 }
 
+// ReleaseIP clears the UID custom field of an IP with the given UID in NetBox.
+func (c *client) ReleaseIP(ctx context.Context, uid UID) error {
+	c.forgetID(uid)
+
+	existingIPs, err := c.getOwnedIPs(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("checking if IP exists: %w", err)
+	}
+
+	var disallowed error
+	for i := range existingIPs {
+		err := c.releaseRecord(ctx, uid, &existingIPs[i])
+		if errors.Is(err, ErrDisallowedIP) {
+			disallowed = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return disallowed
+}
+
+func (c *client) releaseRecord(ctx context.Context, uid UID, existingIP *IPAddress) error {
+	releasedIP := *existingIP
+	releasedIP.UID = ""
+	record := AuditRecord{
+		Operation: AuditOperationUpdate,
+		Object:    AuditObjectIPAddress,
+		ID:        existingIP.ID,
+		UID:       string(uid),
+		Address:   addressString(existingIP.Address),
+		Changes:   ipChanges(existingIP, &releasedIP),
+	}
+	if err := allowed(c.allowedPrefixes, "release", existingIP.Address); err != nil {
+		c.recordFailedAudit(record, err)
+		return err
+	}
+	// UID cannot be used, since it is never marshaled as null
+	body := map[string]interface{}{
+		"custom_fields": c.releaseUIDFields(),
+	}
+	url := fmt.Sprintf("%s/ipam/ip-addresses/%d/", c.baseURL, existingIP.ID)
+	if _, err := c.executeRequest(ctx, url, http.MethodPatch, body); err != nil {
+		c.recordFailedAudit(record, err)
+		return fmt.Errorf("executing request: %w", err)
+	}
+
+	c.recordAudit(record)
+
+	return nil
+}
+
+// DeprecateIP sets the status of an IP with the given UID in NetBox
+// to deprecated, appends note to its description, and clears its UID.
+func (c *client) DeprecateIP(ctx context.Context, uid UID, note string) error {
+	c.forgetID(uid)
+
+	existingIPs, err := c.getOwnedIPs(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("checking if IP exists: %w", err)
+	}
+
+	var disallowed error
+	for i := range existingIPs {
+		err := c.deprecateRecord(ctx, uid, &existingIPs[i], note)
+		if errors.Is(err, ErrDisallowedIP) {
+			disallowed = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return disallowed
+}
+
+func (c *client) deprecateRecord(ctx context.Context, uid UID, existingIP *IPAddress, note string) error {
+	deprecatedIP := *existingIP
+	deprecatedIP.UID = ""
+	deprecatedIP.Description = AppendNote(existingIP.Description, note)
+
+	changes := ipChanges(existingIP, &deprecatedIP)
+	changes["status"] = AuditChange{New: IPStatusDeprecated}
+	record := AuditRecord{
+		Operation: AuditOperationUpdate,
+		Object:    AuditObjectIPAddress,
+		ID:        existingIP.ID,
+		UID:       string(uid),
+		Address:   addressString(existingIP.Address),
+		Changes:   changes,
+	}
+	if err := allowed(c.allowedPrefixes, "deprecate", existingIP.Address); err != nil {
+		c.recordFailedAudit(record, err)
+		return err
+	}
+	body := map[string]interface{}{
+		"status":        IPStatusDeprecated,
+		"description":   deprecatedIP.Description,
+		"custom_fields": c.releaseUIDFields(),
+	}
+	url := fmt.Sprintf("%s/ipam/ip-addresses/%d/", c.baseURL, existingIP.ID)
+	if _, err := c.executeRequest(ctx, url, http.MethodPatch, body); err != nil {
+		c.recordFailedAudit(record, err)
+		return fmt.Errorf("executing request: %w", err)
+	}
+
+	c.recordAudit(record)
+
+	return nil
+}
+
+// knownID returns the ID of the IP with the given UID,
+// if the client has written it before.
+func (c *client) knownID(uid UID) (int64, bool) {
+	c.idsMu.Lock()
+	defer c.idsMu.Unlock()
+	id, ok := c.ids[uid]
+	return id, ok
+}
+
+func (c *client) setKnownID(uid UID, id int64) {
+	c.idsMu.Lock()
+	defer c.idsMu.Unlock()
+	if c.ids == nil {
+		c.ids = make(map[UID]int64)
+	}
+	c.ids[uid] = id
+}
+
+func (c *client) forgetID(uid UID) {
+	c.idsMu.Lock()
+	defer c.idsMu.Unlock()
+	delete(c.ids, uid)
+}
+
+// setMovingUIDs records that the UIDs of ips are in the previous UID field.
+func (c *client) setMovingUIDs(ips []IPAddress) {
+	c.idsMu.Lock()
+	defer c.idsMu.Unlock()
+	if c.previousFieldIDs == nil {
+		c.previousFieldIDs = make(map[int64]bool)
+	}
+	for _, ip := range ips {
+		c.previousFieldIDs[ip.ID] = true
+	}
+}
+
+// movingUID returns true if the UID of the IP with the given ID
+// is in the previous UID field, and forgets about it.
+func (c *client) movingUID(id int64) bool {
+	c.idsMu.Lock()
+	defer c.idsMu.Unlock()
+	moving := c.previousFieldIDs[id]
+	delete(c.previousFieldIDs, id)
+	return moving
+}
+
+// ipLost records that an IP written by the client was deleted
+// in NetBox by someone else, so that it is about to be re-created.
+func (c *client) ipLost(uid UID, id int64) {
+	c.logger.Warn("IP was deleted in NetBox - recreating it",
+		log.String("uid", string(uid)), log.Int64("id", id))
+	metrics.IncrementLostIPs()
+	c.forgetID(uid)
+}
+
+// executeRequest sends a request to NetBox and returns the response body.
+// Secrets are redacted from the returned error, as it may include the response body.
 func (c *client) executeRequest(ctx context.Context, url string, method string, body interface{}) ([]byte, error) {
+	data, err := c.doRequest(ctx, url, method, body)
+	if auth, ok := c.auth.(renewableAuth); ok && isUnauthorized(err) {
+		// the credentials may have been revoked before they expired,
+		// so the request is sent once more with new ones
+		auth.invalidate()
+		data, err = c.doRequest(ctx, url, method, body)
+	}
+	if err != nil {
+		return nil, c.redactor.redactError(err)
+	}
+	return data, nil
+}
+
+func (c *client) doRequest(ctx context.Context, url string, method string, body interface{}) ([]byte, error) {
 	var b []byte
 	var err error
 	if body != nil {
@@ -361,17 +1478,36 @@ func (c *client) executeRequest(ctx context.Context, url string, method string,
 	req = req.WithContext(ctx)
 
 	req.Header.Set("Accept", "application/json")
+	acceptGzip(req)
 	if b != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
-	if c.token != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Token %s", c.token))
+	if id := requestID(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	if id := branchSchemaID(ctx); id != "" {
+		req.Header.Set(BranchHeader, id)
+	}
+	if c.auth != nil {
+		if err := c.auth.Authenticate(ctx, req); err != nil {
+			return nil, fmt.Errorf("authenticating request: %w", err)
+		}
 	}
 
-	if err := c.rateLimiter.Wait(ctx); err != nil {
+	waitStart := time.Now()
+	err = ratelimit.Limiter(ctx, c.rateLimiter).Wait(ctx)
+	timing.Record(ctx, timing.RateLimiter, waitStart)
+	if err != nil {
 		return nil, err
 	}
 
+	if c.bodyLogLimit > 0 {
+		c.logger.Debug("sending request to NetBox", log.String("method", method),
+			log.String("url", c.redactor.redact(url)), log.String("body", c.loggedBody(b)))
+	}
+
+	defer timing.Record(ctx, timing.NetBox, time.Now())
+
 	var res *http.Response
 	var responseErr error
 	if method == http.MethodPost || method == http.MethodPatch {
@@ -390,17 +1526,33 @@ func (c *client) executeRequest(ctx context.Context, url string, method string,
 		return nil, responseErr
 	}
 	defer res.Body.Close()
+	if err := decompress(res); err != nil {
+		metrics.IncrementNetboxRequests(false)
+		return nil, fmt.Errorf("decompressing response: %w", err)
+	}
 
 	if err := httpErrorFrom(res); err != nil {
 		metrics.IncrementNetboxRequests(false)
+		if reason, ok := RejectionReason(err); ok {
+			metrics.IncrementRejectedRequests(reason)
+		}
+		if c.bodyLogLimit > 0 {
+			// the error has the body of the response
+			c.logger.Debug("received error response from NetBox", log.String("method", method),
+				log.String("url", c.redactor.redact(url)), log.String("error", c.loggedBody([]byte(err.Error()))))
+		}
 		return nil, err
 	}
 
 	metrics.IncrementNetboxRequests(true)
 
-	data, err := io.ReadAll(io.LimitReader(res.Body, responseBodySizeLimit))
+	data, err := readBody(res.Body, bodySizeLimit(method, url))
 	if err != nil {
-		return nil, errors.New("reading response data")
+		return nil, fmt.Errorf("reading response data: %w", err)
+	}
+	if c.bodyLogLimit > 0 {
+		c.logger.Debug("received response from NetBox", log.String("method", method),
+			log.String("url", c.redactor.redact(url)), log.Int("status", res.StatusCode), log.String("body", c.loggedBody(data)))
 	}
 	return data, err
 }
@@ -415,7 +1567,33 @@ func httpErrorFrom(res *http.Response) error {
 		return fmt.Errorf("read error response data: %w", err)
 	}
 	if len(data) > 0 {
-		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(data)))
+		return &statusError{code: res.StatusCode, msg: fmt.Sprintf("%s: %s", res.Status, strings.TrimSpace(string(data)))}
 	}
-	return errors.New(res.Status)
+	return &statusError{code: res.StatusCode, msg: res.Status}
+}
+
+// statusError is returned for requests that NetBox
+// responded to with a non-2xx status code.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+func isUnauthorized(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == http.StatusUnauthorized
+}
+
+func isNotFound(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == http.StatusNotFound
+}
+
+func isConflict(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == http.StatusConflict
 }
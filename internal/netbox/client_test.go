@@ -17,6 +17,16 @@ limitations under the License.
 package netbox
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -54,3 +64,1247 @@ func TestParseAndValidateURL(t *testing.T) {
 		})
 	}
 }
+
+func TestUIDPrefix(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name string
+		// UID of the IP that exists in NetBox, if any
+		existingUID     UID
+		expectedMethod  string
+		expectedUID     UID
+		expectedQueries []string
+	}{{
+		name:            "new IP",
+		expectedMethod:  http.MethodPost,
+		expectedUID:     "prod-1/" + uid,
+		expectedQueries: []string{"prod-1%2F" + string(uid), string(uid)},
+	}, {
+		name:            "IP with prefixed UID",
+		existingUID:     "prod-1/" + uid,
+		expectedMethod:  http.MethodPut,
+		expectedUID:     "prod-1/" + uid,
+		expectedQueries: []string{"prod-1%2F" + string(uid)},
+	}, {
+		name:            "IP with unprefixed UID is migrated",
+		existingUID:     uid,
+		expectedMethod:  http.MethodPut,
+		expectedUID:     "prod-1/" + uid,
+		expectedQueries: []string{"prod-1%2F" + string(uid), string(uid)},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var queries []string
+			var method string
+			var written IPAddress
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					query := r.URL.RawQuery[len("cf_"+UIDCustomFieldName+"="):]
+					queries = append(queries, query)
+					if test.existingUID != "" && r.URL.Query().Get("cf_"+UIDCustomFieldName) == string(test.existingUID) {
+						fmt.Fprintf(w, `{"count": 1, "results": [{"id": 1, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}]}`, UIDCustomFieldName, test.existingUID)
+						return
+					}
+					w.Write([]byte(`{"count": 0, "results": []}`))
+				default:
+					method = r.Method
+					body, _ := io.ReadAll(r.Body)
+					if err := json.Unmarshal(body, &written); err != nil {
+						t.Errorf("unmarshaling request: %s", err)
+					}
+					w.Write(body)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo", WithUIDPrefix("prod-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ip, result, err := c.UpsertIP(context.Background(), &IPAddress{
+				UID:         uid,
+				Address:     IP(netip.MustParseAddr("192.168.0.1")),
+				Description: "foo",
+			})
+			if err != nil {
+				t.Fatalf("upserting IP: %s", err)
+			}
+
+			if method != test.expectedMethod {
+				t.Errorf("want %s request, got %q", test.expectedMethod, method)
+			}
+			if want := test.expectedMethod == http.MethodPost; (result == UpsertCreated) != want {
+				t.Errorf("want created %t, got result %d", want, result)
+			}
+			if written.UID != test.expectedUID {
+				t.Errorf("want UID %q written to NetBox, got %q", test.expectedUID, written.UID)
+			}
+			if ip.UID != uid {
+				t.Errorf("want returned UID %q, got %q", uid, ip.UID)
+			}
+			if fmt.Sprint(queries) != fmt.Sprint(test.expectedQueries) {
+				t.Errorf("want queries %v, got %v", test.expectedQueries, queries)
+			}
+		})
+	}
+}
+
+func TestUpsertIPRecreatesLostIP(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name string
+		// whether the IP is found by its UID, but then deleted before it is updated
+		deletedBeforeUpdate bool
+		expectedMethods     []string
+	}{{
+		name:            "IP deleted since last upsert",
+		expectedMethods: []string{http.MethodPost},
+	}, {
+		name:                "IP deleted since lookup",
+		deletedBeforeUpdate: true,
+		expectedMethods:     []string{http.MethodPut, http.MethodPost},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var methods []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					if test.deletedBeforeUpdate {
+						fmt.Fprintf(w, `{"count": 1, "results": [{"id": 1, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}]}`, UIDCustomFieldName, uid)
+						return
+					}
+					w.Write([]byte(`{"count": 0, "results": []}`))
+				case http.MethodPut:
+					methods = append(methods, r.Method)
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"detail": "Not found."}`))
+				default:
+					methods = append(methods, r.Method)
+					fmt.Fprintf(w, `{"id": 2, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}`, UIDCustomFieldName, uid)
+				}
+			}))
+			defer server.Close()
+
+			nc, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := nc.(*client)
+			// the IP was written before with ID 1
+			c.setKnownID(uid, 1)
+
+			ip, result, err := c.UpsertIP(context.Background(), &IPAddress{
+				UID:         uid,
+				Address:     IP(netip.MustParseAddr("192.168.0.1")),
+				Description: "foo",
+			})
+			if err != nil {
+				t.Fatalf("upserting IP: %s", err)
+			}
+			if result != UpsertCreated {
+				t.Error("want re-created IP to be reported as created")
+			}
+
+			if fmt.Sprint(methods) != fmt.Sprint(test.expectedMethods) {
+				t.Errorf("want requests %v, got %v", test.expectedMethods, methods)
+			}
+			if ip.ID != 2 {
+				t.Errorf("want re-created IP with ID 2, got %d", ip.ID)
+			}
+			if id, _ := c.knownID(uid); id != 2 {
+				t.Errorf("want known ID 2, got %d", id)
+			}
+		})
+	}
+}
+
+func TestUpsertIPUnchanged(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	var writes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writes = append(writes, r.Method+" "+r.URL.Path)
+			return
+		}
+		fmt.Fprintf(w, `{"count": 1, "results": [{"id": 1, "address": "192.168.0.1/32", "description": "foo", "custom_fields": {"%s": "%s"}}]}`, UIDCustomFieldName, uid)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL+"/api", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, result, err := c.UpsertIP(context.Background(), &IPAddress{
+		UID:         uid,
+		Address:     IP(netip.MustParseAddr("192.168.0.1")),
+		Description: "foo",
+	})
+	if err != nil {
+		t.Fatalf("upserting IP: %s", err)
+	}
+
+	if result != UpsertUnchanged {
+		t.Errorf("want IP unchanged, got result %d", result)
+	}
+	if len(writes) > 0 {
+		t.Errorf("want no writes, got %v", writes)
+	}
+	if ip == nil || ip.ID != 1 || ip.UID != uid {
+		t.Fatalf("want existing IP 1 with UID %q returned, got %v", uid, ip)
+	}
+	if expectedURL := server.URL + "/ipam/ip-addresses/1/"; ip.WebURL != expectedURL {
+		t.Errorf("want web URL %q, got %q", expectedURL, ip.WebURL)
+	}
+}
+
+func TestUpsertIPWithID(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name string
+		// UID of the IP with the ID in NetBox, if it exists
+		uidOfID         UID
+		existsByID      bool
+		expectedLookups []string
+	}{{
+		name:            "IP still has the UID",
+		existsByID:      true,
+		uidOfID:         uid,
+		expectedLookups: []string{"/api/ipam/ip-addresses/5/"},
+	}, {
+		name:            "IP has been re-used",
+		existsByID:      true,
+		uidOfID:         "",
+		expectedLookups: []string{"/api/ipam/ip-addresses/5/", "/api/ipam/ip-addresses/"},
+	}, {
+		name:            "IP has been deleted",
+		expectedLookups: []string{"/api/ipam/ip-addresses/5/", "/api/ipam/ip-addresses/"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lookups []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/api/ipam/ip-addresses/5/":
+					lookups = append(lookups, r.URL.Path)
+					if !test.existsByID {
+						w.WriteHeader(http.StatusNotFound)
+						w.Write([]byte(`{"detail": "Not found."}`))
+						return
+					}
+					fmt.Fprintf(w, `{"id": 5, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}`, UIDCustomFieldName, test.uidOfID)
+				case r.Method == http.MethodGet:
+					lookups = append(lookups, r.URL.Path)
+					fmt.Fprintf(w, `{"count": 1, "results": [{"id": 6, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}]}`, UIDCustomFieldName, uid)
+				default:
+					var body map[string]interface{}
+					json.NewDecoder(r.Body).Decode(&body)
+					if _, ok := body["id"]; ok {
+						t.Errorf("want no ID written, got %v", body["id"])
+					}
+					id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/ipam/ip-addresses/"), "/")
+					fmt.Fprintf(w, `{"id": %s, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}`, id, UIDCustomFieldName, uid)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL+"/api", "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ip, _, err := c.UpsertIP(context.Background(), &IPAddress{
+				ID:          5,
+				UID:         uid,
+				Address:     IP(netip.MustParseAddr("192.168.0.1")),
+				Description: "foo",
+			})
+			if err != nil {
+				t.Fatalf("upserting IP: %s", err)
+			}
+
+			if fmt.Sprint(lookups) != fmt.Sprint(test.expectedLookups) {
+				t.Errorf("want lookups %v, got %v", test.expectedLookups, lookups)
+			}
+			expectedURL := fmt.Sprintf("%s/ipam/ip-addresses/%d/", server.URL, ip.ID)
+			if ip.WebURL != expectedURL {
+				t.Errorf("want web URL %q, got %q", expectedURL, ip.WebURL)
+			}
+		})
+	}
+}
+
+func TestOwnedIPChecksUID(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name string
+		// the IP as read by its ID right before the deletion, if it still exists
+		currentIP      string
+		expectedWrites []string
+	}{{
+		name:           "UID unchanged",
+		currentIP:      fmt.Sprintf(`{"id": 1, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}`, UIDCustomFieldName, uid),
+		expectedWrites: []string{"DELETE /ipam/ip-addresses/1/", "PATCH /ipam/ip-addresses/1/", "PATCH /ipam/ip-addresses/1/"},
+	}, {
+		name:      "IP re-used by another object",
+		currentIP: fmt.Sprintf(`{"id": 1, "address": "192.168.0.1/32", "custom_fields": {"%s": "other-uid"}}`, UIDCustomFieldName),
+	}, {
+		name:      "IP released",
+		currentIP: fmt.Sprintf(`{"id": 1, "address": "192.168.0.1/32", "custom_fields": {"%s": null}}`, UIDCustomFieldName),
+	}, {
+		name: "IP deleted since lookup",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var writes []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/ipam/ip-addresses/1/":
+					if test.currentIP == "" {
+						w.WriteHeader(http.StatusNotFound)
+						w.Write([]byte(`{"detail": "Not found."}`))
+						return
+					}
+					w.Write([]byte(test.currentIP))
+				case r.Method == http.MethodGet:
+					fmt.Fprintf(w, `{"count": 1, "results": [{"id": 1, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}]}`, UIDCustomFieldName, uid)
+				case r.Method == http.MethodDelete:
+					writes = append(writes, r.Method+" "+r.URL.Path)
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					writes = append(writes, r.Method+" "+r.URL.Path)
+					w.Write([]byte("{}"))
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			if err := c.DeleteIP(ctx, uid); err != nil {
+				t.Fatalf("deleting IP: %s", err)
+			}
+			if err := c.ReleaseIP(ctx, uid); err != nil {
+				t.Fatalf("releasing IP: %s", err)
+			}
+			if err := c.DeprecateIP(ctx, uid, "deprecated"); err != nil {
+				t.Fatalf("deprecating IP: %s", err)
+			}
+			// only the requests that change the IP are recorded
+			if fmt.Sprint(writes) != fmt.Sprint(test.expectedWrites) {
+				t.Errorf("want requests %v, got %v", test.expectedWrites, writes)
+			}
+		})
+	}
+}
+
+func TestDeleteIPAllowedPrefixes(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name              string
+		allowedPrefixes   []netip.Prefix
+		errorExpected     bool
+		expectedDeletions []string
+	}{{
+		name:              "no allowed prefixes",
+		expectedDeletions: []string{"/ipam/ip-addresses/1/"},
+	}, {
+		name:              "within the allowed prefixes",
+		allowedPrefixes:   []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")},
+		expectedDeletions: []string{"/ipam/ip-addresses/1/"},
+	}, {
+		name:            "outside of the allowed prefixes",
+		allowedPrefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		errorExpected:   true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var deletions []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/ipam/ip-addresses/1/":
+					fmt.Fprintf(w, `{"id": 1, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}`, UIDCustomFieldName, uid)
+				case r.Method == http.MethodGet:
+					fmt.Fprintf(w, `{"count": 1, "results": [{"id": 1, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}]}`, UIDCustomFieldName, uid)
+				case r.Method == http.MethodDelete:
+					deletions = append(deletions, r.URL.Path)
+					w.WriteHeader(http.StatusNoContent)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo", WithAllowedPrefixes(test.allowedPrefixes))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.DeleteIP(context.Background(), uid)
+			if test.errorExpected && !errors.Is(err, ErrDisallowedIP) {
+				t.Errorf("want %q, got %v", ErrDisallowedIP, err)
+			} else if !test.errorExpected && err != nil {
+				t.Errorf("want no error, got %s", err)
+			}
+			if fmt.Sprint(deletions) != fmt.Sprint(test.expectedDeletions) {
+				t.Errorf("want deletions %v, got %v", test.expectedDeletions, deletions)
+			}
+		})
+	}
+}
+
+func TestWritesAllowedPrefixes(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+	allowedPrefixes := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name              string
+		write             func(c Client) error
+		expectedOperation string
+		expectedAddress   netip.Addr
+	}{{
+		name: "upsert outside of the allowed prefixes",
+		write: func(c Client) error {
+			_, _, err := c.UpsertIP(context.Background(), &IPAddress{UID: uid, Address: IP(netip.MustParseAddr("172.16.0.1"))})
+			return err
+		},
+		expectedOperation: "upsert",
+		expectedAddress:   netip.MustParseAddr("172.16.0.1"),
+	}, {
+		name: "upsert moving an IP from outside of the allowed prefixes",
+		write: func(c Client) error {
+			_, _, err := c.UpsertIP(context.Background(), &IPAddress{UID: uid, Address: IP(netip.MustParseAddr("10.0.0.1")), DNSName: "foo"})
+			return err
+		},
+		expectedOperation: "upsert",
+		expectedAddress:   netip.MustParseAddr("192.168.0.1"),
+	}, {
+		name:              "release",
+		write:             func(c Client) error { return c.ReleaseIP(context.Background(), uid) },
+		expectedOperation: "release",
+		expectedAddress:   netip.MustParseAddr("192.168.0.1"),
+	}, {
+		name:              "deprecate",
+		write:             func(c Client) error { return c.DeprecateIP(context.Background(), uid, "(deleted)") },
+		expectedOperation: "deprecate",
+		expectedAddress:   netip.MustParseAddr("192.168.0.1"),
+	}, {
+		name:              "delete",
+		write:             func(c Client) error { return c.DeleteIP(context.Background(), uid) },
+		expectedOperation: "delete",
+		expectedAddress:   netip.MustParseAddr("192.168.0.1"),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var writes []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet {
+					writes = append(writes, r.Method+" "+r.URL.Path)
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				if r.URL.Path == "/ipam/ip-addresses/1/" {
+					fmt.Fprintf(w, `{"id": 1, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}`, UIDCustomFieldName, uid)
+					return
+				}
+				fmt.Fprintf(w, `{"count": 1, "results": [{"id": 1, "address": "192.168.0.1/32", "custom_fields": {"%s": "%s"}}]}`, UIDCustomFieldName, uid)
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo", WithAllowedPrefixes(allowedPrefixes))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = test.write(c)
+			var disallowedErr *DisallowedIPError
+			if !errors.As(err, &disallowedErr) {
+				t.Fatalf("want a DisallowedIPError, got %v", err)
+			}
+			if !errors.Is(err, ErrDisallowedIP) {
+				t.Errorf("want %v to match %q", err, ErrDisallowedIP)
+			}
+			if disallowedErr.Operation != test.expectedOperation || disallowedErr.Address != test.expectedAddress {
+				t.Errorf("want refused %s of %s, got %s of %s",
+					test.expectedOperation, test.expectedAddress, disallowedErr.Operation, disallowedErr.Address)
+			}
+			if len(writes) > 0 {
+				t.Errorf("want no writes, got %v", writes)
+			}
+		})
+	}
+}
+
+func TestDuplicateStrategy(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name           string
+		strategy       string
+		deletionPolicy string
+		// UID of the second IP found by NetBox
+		otherUID        UID
+		allowedPrefixes []netip.Prefix
+		errorExpected   bool
+		expectedID      int64
+		// requests that change IPs, made by UpsertIP
+		expectedWrites []string
+		// tags and custom fields of the oldest IP after the merge, if any
+		expectedMerge string
+	}{{
+		name:          "fail by default",
+		otherUID:      uid,
+		errorExpected: true,
+	}, {
+		name:           "adopt oldest",
+		strategy:       DuplicateStrategyAdoptOldest,
+		otherUID:       uid,
+		expectedID:     3,
+		expectedWrites: []string{"PUT /ipam/ip-addresses/3/"},
+	}, {
+		name:           "merge and delete duplicates",
+		strategy:       DuplicateStrategyMerge,
+		otherUID:       uid,
+		expectedID:     3,
+		expectedWrites: []string{"PUT /ipam/ip-addresses/3/", "DELETE /ipam/ip-addresses/7/", "PUT /ipam/ip-addresses/3/"},
+		expectedMerge:  "[a b] map[cost_center:1234]",
+	}, {
+		name:           "merge and retain duplicates",
+		strategy:       DuplicateStrategyMerge,
+		deletionPolicy: DeletionPolicyRetain,
+		otherUID:       uid,
+		expectedID:     3,
+		expectedWrites: []string{"PUT /ipam/ip-addresses/3/", "PATCH /ipam/ip-addresses/7/", "PUT /ipam/ip-addresses/3/"},
+		expectedMerge:  "[a b] map[cost_center:1234]",
+	}, {
+		name:           "merge and deprecate duplicates",
+		strategy:       DuplicateStrategyMerge,
+		deletionPolicy: DeletionPolicyDeprecate,
+		otherUID:       uid,
+		expectedID:     3,
+		expectedWrites: []string{"PUT /ipam/ip-addresses/3/", "PATCH /ipam/ip-addresses/7/", "PUT /ipam/ip-addresses/3/"},
+		expectedMerge:  "[a b] map[cost_center:1234]",
+	}, {
+		name:            "merge keeps duplicates outside of the allowed prefixes",
+		strategy:        DuplicateStrategyMerge,
+		otherUID:        uid,
+		allowedPrefixes: []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")},
+		expectedID:      3,
+		expectedWrites:  []string{"PUT /ipam/ip-addresses/3/", "PUT /ipam/ip-addresses/3/"},
+		expectedMerge:   "[a b] map[cost_center:1234]",
+	}, {
+		name:          "not duplicates without UID custom field",
+		strategy:      DuplicateStrategyMerge,
+		otherUID:      "foo",
+		errorExpected: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var writes []string
+			var merge string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					fmt.Fprintf(w, `{"count": 2, "results": [`+
+						`{"id": 7, "address": "10.0.0.7/32", "tags": [{"name": "b", "slug": "b"}], "custom_fields": {"%s": "%s", "cost_center": "1234"}}, `+
+						`{"id": 3, "address": "192.168.0.3/32", "tags": [{"name": "a", "slug": "a"}], "custom_fields": {"%s": "%s"}}]}`,
+						UIDCustomFieldName, test.otherUID, UIDCustomFieldName, uid)
+					return
+				}
+				writes = append(writes, r.Method+" "+r.URL.Path)
+				if r.Method == http.MethodPut && merge == "" {
+					var ip IPAddress
+					if err := json.NewDecoder(r.Body).Decode(&ip); err != nil {
+						t.Errorf("decoding merged IP: %s", err)
+					}
+					var tags []string
+					for _, tag := range ip.Tags {
+						tags = append(tags, tag.Name)
+					}
+					merge = fmt.Sprint(tags, " ", ip.CustomFields)
+				}
+				w.Write([]byte(`{"id": 3}`))
+			}))
+			defer server.Close()
+
+			opts := []ClientOption{WithAllowedPrefixes(test.allowedPrefixes)}
+			if test.strategy != "" {
+				opts = append(opts, WithDuplicateStrategy(test.strategy))
+			}
+			if test.deletionPolicy != "" {
+				opts = append(opts, WithDeletionPolicy(test.deletionPolicy))
+			}
+			c, err := NewClient(server.URL, "foo", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ip, err := c.GetIP(context.Background(), uid)
+			if test.errorExpected {
+				if err == nil {
+					t.Errorf("want an error, got IP %v", ip)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getting IP: %s", err)
+			}
+			if ip.ID != test.expectedID {
+				t.Errorf("want IP with ID %d, got %d", test.expectedID, ip.ID)
+			}
+			if len(writes) > 0 {
+				t.Fatalf("want duplicates to be resolved only when upserting, got %v", writes)
+			}
+
+			_, _, err = c.UpsertIP(context.Background(), &IPAddress{
+				UID:     uid,
+				DNSName: "foo",
+				Address: IP(netip.MustParseAddr("192.168.0.3")),
+				Tags:    []Tag{{Name: "a", Slug: "a"}},
+			})
+			if err != nil {
+				t.Fatalf("upserting IP: %s", err)
+			}
+			if fmt.Sprint(writes) != fmt.Sprint(test.expectedWrites) {
+				t.Errorf("want requests %v, got %v", test.expectedWrites, writes)
+			}
+			if test.expectedMerge != "" && merge != test.expectedMerge {
+				t.Errorf("want merged IP with %s, got %s", test.expectedMerge, merge)
+			}
+		})
+	}
+}
+
+func TestAdoptionPolicy(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name           string
+		policy         string
+		errorExpected  error
+		expectedMethod string
+		expectedPath   string
+	}{{
+		name:           "duplicate by default",
+		expectedMethod: http.MethodPost,
+		expectedPath:   "/ipam/ip-addresses/",
+	}, {
+		name:          "skip",
+		policy:        AdoptionPolicySkip,
+		errorExpected: ErrUnmanagedIP,
+	}, {
+		name:           "adopt",
+		policy:         AdoptionPolicyAdopt,
+		expectedMethod: http.MethodPut,
+		expectedPath:   "/ipam/ip-addresses/3/",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var method, path string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Query().Get("address") != "":
+					// an IP managed by someone else, and two unmanaged ones
+					fmt.Fprintf(w, `{"count": 3, "results": [{"id": 2, "custom_fields": {"%s": "foo"}}, {"id": 5, "custom_fields": {}}, {"id": 3, "custom_fields": {}}]}`, UIDCustomFieldName)
+				case r.Method == http.MethodGet:
+					w.Write([]byte(`{"count": 0, "results": []}`))
+				default:
+					method, path = r.Method, r.URL.Path
+					body, _ := io.ReadAll(r.Body)
+					w.Write(body)
+				}
+			}))
+			defer server.Close()
+
+			var opts []ClientOption
+			if test.policy != "" {
+				opts = append(opts, WithAdoptionPolicy(test.policy))
+			}
+			c, err := NewClient(server.URL, "foo", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = c.UpsertIP(context.Background(), &IPAddress{
+				UID:     uid,
+				Address: IP(netip.MustParseAddr("192.168.0.1")),
+			})
+			if !errors.Is(err, test.errorExpected) {
+				t.Fatalf("want error %v, got %v", test.errorExpected, err)
+			}
+
+			if method != test.expectedMethod || path != test.expectedPath {
+				t.Errorf("want %s %s request, got %q %q", test.expectedMethod, test.expectedPath, method, path)
+			}
+		})
+	}
+}
+
+func TestAdoptionPolicyVRF(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name           string
+		vrf            *VRF
+		expectedVRFID  string
+		expectedMethod string
+	}{{
+		name:           "global table",
+		expectedVRFID:  "null",
+		expectedMethod: http.MethodPut,
+	}, {
+		name:           "VRF by name",
+		vrf:            &VRF{Name: "blue"},
+		expectedVRFID:  "9",
+		expectedMethod: http.MethodPut,
+	}, {
+		name:           "VRF by ID",
+		vrf:            &VRF{ID: 4},
+		expectedVRFID:  "4",
+		expectedMethod: http.MethodPut,
+	}, {
+		name: "VRF that does not exist",
+		vrf:  &VRF{Name: "red"},
+		// no unmanaged IP can be adopted, so a new one is created
+		expectedMethod: http.MethodPost,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var vrfID, method string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/ipam/vrfs/":
+					if r.URL.Query().Get("name") == "blue" {
+						w.Write([]byte(`{"count": 1, "results": [{"id": 9, "name": "blue"}]}`))
+						return
+					}
+					w.Write([]byte(`{"count": 0, "results": []}`))
+				case r.Method == http.MethodGet && r.URL.Query().Get("address") != "":
+					vrfID = r.URL.Query().Get("vrf_id")
+					w.Write([]byte(`{"count": 1, "results": [{"id": 3, "custom_fields": {}}]}`))
+				case r.Method == http.MethodGet:
+					w.Write([]byte(`{"count": 0, "results": []}`))
+				default:
+					method = r.Method
+					body, _ := io.ReadAll(r.Body)
+					w.Write(body)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo", WithAdoptionPolicy(AdoptionPolicyAdopt))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = c.UpsertIP(context.Background(), &IPAddress{
+				UID:     uid,
+				Address: IP(netip.MustParseAddr("192.168.0.1")),
+				VRF:     test.vrf,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if vrfID != test.expectedVRFID {
+				t.Errorf("want unmanaged IPs looked up with vrf_id %q, got %q", test.expectedVRFID, vrfID)
+			}
+			if method != test.expectedMethod {
+				t.Errorf("want %s request, got %q", test.expectedMethod, method)
+			}
+		})
+	}
+}
+
+func TestAdoptionPolicyAdoptMatching(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name           string
+		dnsName        string
+		expectedMethod string
+		expectedPath   string
+		expectedTags   []string
+	}{{
+		name:           "matching DNS name",
+		dnsName:        "pod.example.com",
+		expectedMethod: http.MethodPut,
+		expectedPath:   "/ipam/ip-addresses/5/",
+		expectedTags:   []string{"bar", "foo"},
+	}, {
+		name:           "DNS name in another case",
+		dnsName:        "POD.example.com",
+		expectedMethod: http.MethodPut,
+		expectedPath:   "/ipam/ip-addresses/5/",
+		expectedTags:   []string{"bar", "foo"},
+	}, {
+		name:           "other DNS name",
+		dnsName:        "other.example.com",
+		expectedMethod: http.MethodPost,
+		expectedPath:   "/ipam/ip-addresses/",
+		expectedTags:   []string{"foo"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var method, path string
+			var stored IPAddress
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Query().Get("address") != "":
+					// the oldest unmanaged IP has another DNS name
+					w.Write([]byte(`{"count": 2, "results": [{"id": 3, "dns_name": "old.example.com", "custom_fields": {}}, {"id": 5, "dns_name": "pod.example.com", "tags": [{"name": "bar", "slug": "bar"}], "custom_fields": {}}]}`))
+				case r.Method == http.MethodGet && r.URL.Path == "/extras/tags/":
+					fmt.Fprintf(w, `{"count": 1, "results": [{"id": 1, "name": %q, "slug": %q}]}`, r.URL.Query().Get("name"), r.URL.Query().Get("name"))
+				case r.Method == http.MethodGet:
+					w.Write([]byte(`{"count": 0, "results": []}`))
+				default:
+					method, path = r.Method, r.URL.Path
+					body, _ := io.ReadAll(r.Body)
+					if err := json.Unmarshal(body, &stored); err != nil {
+						t.Error(err)
+					}
+					w.Write(body)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo", WithAdoptionPolicy(AdoptionPolicyAdoptMatching))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = c.UpsertIP(context.Background(), &IPAddress{
+				UID:     uid,
+				Address: IP(netip.MustParseAddr("192.168.0.1")),
+				DNSName: test.dnsName,
+				Tags:    []Tag{{Name: "foo", Slug: "foo"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if method != test.expectedMethod || path != test.expectedPath {
+				t.Errorf("want %s %s request, got %q %q", test.expectedMethod, test.expectedPath, method, path)
+			}
+			var tags []string
+			for _, tag := range stored.Tags {
+				tags = append(tags, tag.Name)
+			}
+			sort.Strings(tags)
+			if fmt.Sprint(tags) != fmt.Sprint(test.expectedTags) {
+				t.Errorf("want tags %v, got %v", test.expectedTags, tags)
+			}
+		})
+	}
+}
+
+func TestInterfaceIDCache(t *testing.T) {
+	var lookups int
+	var failWrites bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/interfaces/"):
+			lookups++
+			w.Write([]byte(`{"count": 1, "results": [{"id": 12}]}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"count": 0, "results": []}`))
+		case failWrites:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"assigned_object_id": ["Related object not found"]}`))
+		default:
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upsert := func(name string) error {
+		_, _, err := c.UpsertIP(context.Background(), &IPAddress{
+			UID:               UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"),
+			Address:           IP(netip.MustParseAddr("192.168.0.1")),
+			AssignedInterface: &InterfaceRef{Type: AssignedObjectTypeInterface, Name: name, Parent: "node1"},
+		})
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := upsert("eth0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("want the interface to be looked up once, got %d lookups", lookups)
+	}
+
+	if err := upsert("eth1"); err != nil {
+		t.Fatal(err)
+	}
+	if lookups != 2 {
+		t.Errorf("want another interface to be looked up, got %d lookups", lookups)
+	}
+
+	// the interface was re-created, and the cached ID is no longer valid
+	failWrites = true
+	if err := upsert("eth0"); err == nil {
+		t.Fatal("want an error, got nil")
+	}
+	failWrites = false
+	if err := upsert("eth0"); err != nil {
+		t.Fatal(err)
+	}
+	if lookups != 3 {
+		t.Errorf("want the interface to be looked up again after a failed write, got %d lookups", lookups)
+	}
+}
+
+func TestUpsertIPAssignedInterface(t *testing.T) {
+	tests := []struct {
+		name          string
+		ref           InterfaceRef
+		interfaces    string
+		errorExpected bool
+		expectedQuery string
+		expectedID    int64
+	}{{
+		name:       "by id",
+		ref:        InterfaceRef{Type: AssignedObjectTypeInterface, ID: 7},
+		expectedID: 7,
+	}, {
+		name:          "by name",
+		ref:           InterfaceRef{Type: AssignedObjectTypeVMInterface, Name: "eth0", Parent: "vm1"},
+		interfaces:    `{"count": 1, "results": [{"id": 12}]}`,
+		expectedQuery: "/virtualization/interfaces/?name=eth0&virtual_machine=vm1",
+		expectedID:    12,
+	}, {
+		name:          "not found",
+		ref:           InterfaceRef{Type: AssignedObjectTypeInterface, Name: "eth0", Parent: "node1"},
+		interfaces:    `{"count": 0, "results": []}`,
+		errorExpected: true,
+		expectedQuery: "/dcim/interfaces/?device=node1&name=eth0",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var query string
+			var created IPAddress
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/interfaces/"):
+					query = r.URL.RequestURI()
+					w.Write([]byte(test.interfaces))
+				case r.Method == http.MethodGet:
+					w.Write([]byte(`{"count": 0, "results": []}`))
+				default:
+					body, _ := io.ReadAll(r.Body)
+					json.Unmarshal(body, &created)
+					w.Write(body)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ref := test.ref
+			_, _, err = c.UpsertIP(context.Background(), &IPAddress{
+				UID:               UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"),
+				Address:           IP(netip.MustParseAddr("192.168.0.1")),
+				AssignedInterface: &ref,
+			})
+			if test.errorExpected && err == nil {
+				t.Fatal("expected error but got nil")
+			} else if !test.errorExpected && err != nil {
+				t.Fatalf("unexpected error: %q", err)
+			}
+
+			if query != test.expectedQuery {
+				t.Errorf("want interface query %q, got %q", test.expectedQuery, query)
+			}
+			if test.errorExpected {
+				return
+			}
+			if created.AssignedObjectType != test.ref.Type || created.AssignedObjectID != test.expectedID {
+				t.Errorf("want IP assigned to %s %d, got %s %d", test.ref.Type, test.expectedID, created.AssignedObjectType, created.AssignedObjectID)
+			}
+		})
+	}
+}
+
+func TestUpsertIPNATInside(t *testing.T) {
+	const insideUID = "d2b9a3f0-0b7c-4b6e-9f4a-1c2d3e4f5a6b"
+
+	tests := []struct {
+		name              string
+		insideIPs         string
+		expectedNATInside *NestedIP
+	}{{
+		name:              "inside IP exists",
+		insideIPs:         `{"count": 1, "results": [{"id": 7, "address": "192.168.0.1/32"}]}`,
+		expectedNATInside: &NestedIP{ID: 7},
+	}, {
+		name:      "inside IP does not exist yet",
+		insideIPs: `{"count": 0, "results": []}`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var created IPAddress
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Query().Get("cf_"+UIDCustomFieldName) == insideUID:
+					w.Write([]byte(test.insideIPs))
+				case r.Method == http.MethodGet:
+					w.Write([]byte(`{"count": 0, "results": []}`))
+				default:
+					body, _ := io.ReadAll(r.Body)
+					json.Unmarshal(body, &created)
+					w.Write(body)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = c.UpsertIP(context.Background(), &IPAddress{
+				UID:          UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"),
+				Address:      IP(netip.MustParseAddr("203.0.113.10")),
+				NATInsideUID: insideUID,
+			})
+			if err != nil {
+				t.Fatalf("upserting IP: %q", err)
+			}
+
+			if fmt.Sprint(created.NATInside) != fmt.Sprint(test.expectedNATInside) {
+				t.Errorf("want NAT inside IP %v, got %v", test.expectedNATInside, created.NATInside)
+			}
+		})
+	}
+}
+
+func TestWithUIDPrefixValidation(t *testing.T) {
+	if _, err := NewClient("https://netbox.example.com", "foo", WithUIDPrefix("prod/1")); err == nil {
+		t.Error("want an error for a prefix containing a slash, got nil")
+	}
+}
+
+func TestUpsertIPValidatesUID(t *testing.T) {
+	tests := []struct {
+		name          string
+		uid           UID
+		uidPrefix     string
+		errorExpected bool
+	}{{
+		name: "UUID",
+		uid:  "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4",
+	}, {
+		name:      "UUID with prefix",
+		uid:       "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4",
+		uidPrefix: "prod-1",
+	}, {
+		name:          "not a UUID",
+		uid:           "shared-6bcb4ab29b6b4a1ba4e3e7e6e3b8f0e4",
+		errorExpected: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if r.Method == http.MethodGet {
+					w.Write([]byte(`{"count": 0, "results": []}`))
+					return
+				}
+				body, _ := io.ReadAll(r.Body)
+				w.Write(body)
+			}))
+			defer server.Close()
+
+			var opts []ClientOption
+			if test.uidPrefix != "" {
+				opts = append(opts, WithUIDPrefix(test.uidPrefix))
+			}
+			c, err := NewClient(server.URL, "foo", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = c.UpsertIP(context.Background(), &IPAddress{
+				UID:     test.uid,
+				Address: IP(netip.MustParseAddr("192.168.0.1")),
+			})
+			if test.errorExpected {
+				if err == nil {
+					t.Error("want an error for an invalid UID, got nil")
+				}
+				if requests != 0 {
+					t.Errorf("want no requests for an invalid UID, got %d", requests)
+				}
+			} else if err != nil {
+				t.Errorf("upserting IP: %s", err)
+			}
+		})
+	}
+}
+
+func TestUpsertIPTakeOver(t *testing.T) {
+	oldUID := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+	newUID := UID("0f7c1c8e-1d1a-4c2b-8f3e-3a9b5c2d4e6f")
+
+	var method, path string
+	var written IPAddress
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("cf_"+UIDCustomFieldName) == string(oldUID):
+			fmt.Fprintf(w, `{"count": 1, "results": [{"id": 5, "address": "192.168.0.1/32", "custom_fields": {%q: %q}}]}`, UIDCustomFieldName, oldUID)
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"count": 0, "results": []}`))
+		default:
+			method, path = r.Method, r.URL.Path
+			body, _ := io.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &written); err != nil {
+				t.Errorf("decoding IP: %s", err)
+			}
+			w.Write(body)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, result, err := c.UpsertIP(context.Background(), &IPAddress{
+		UID:         newUID,
+		Address:     IP(netip.MustParseAddr("192.168.0.1")),
+		DNSName:     "new",
+		TakeOverUID: oldUID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != UpsertUpdated {
+		t.Errorf("want IP updated, got result %d", result)
+	}
+	if method != http.MethodPut || path != "/ipam/ip-addresses/5/" {
+		t.Errorf("want IP 5 updated, got %s %s", method, path)
+	}
+	if written.UID != newUID {
+		t.Errorf("want IP updated with UID %q, got %q", newUID, written.UID)
+	}
+}
+
+func TestUpsertIPConflict(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name        string
+		id          int64
+		lastUpdated string
+		expectedErr error
+		expectedPut bool
+	}{{
+		name:        "unchanged since synced",
+		id:          1,
+		lastUpdated: "2022-06-01T12:00:00.000000Z",
+		expectedPut: true,
+	}, {
+		name:        "never synced",
+		expectedPut: true,
+	}, {
+		name:        "another IP synced",
+		id:          2,
+		lastUpdated: "2022-05-01T12:00:00.000000Z",
+		expectedPut: true,
+	}, {
+		name:        "changed since synced",
+		id:          1,
+		lastUpdated: "2022-05-01T12:00:00.000000Z",
+		expectedErr: ErrConflict,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var put bool
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/ipam/ip-addresses/":
+					fmt.Fprintf(w, `{"count": 1, "results": [{"id": 1, "address": "192.168.0.1/32", "dns_name": "changed", "last_updated": "2022-06-01T12:00:00.000000Z", "custom_fields": {%q: %q}}]}`, UIDCustomFieldName, uid)
+				case r.Method == http.MethodGet && r.URL.Path == "/ipam/ip-addresses/2/":
+					w.WriteHeader(http.StatusNotFound)
+				case r.Method == http.MethodGet:
+					fmt.Fprintf(w, `{"id": 1, "address": "192.168.0.1/32", "dns_name": "changed", "last_updated": "2022-06-01T12:00:00.000000Z", "custom_fields": {%q: %q}}`, UIDCustomFieldName, uid)
+				case r.Method == http.MethodPut:
+					put = true
+					fmt.Fprintf(w, `{"id": 1, "address": "192.168.0.1/32", "dns_name": "new", "last_updated": "2022-06-01T12:10:00.000000Z", "custom_fields": {%q: %q}}`, UIDCustomFieldName, uid)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			upserted, _, err := c.UpsertIP(context.Background(), &IPAddress{
+				ID:          test.id,
+				UID:         uid,
+				Address:     IP(netip.MustParseAddr("192.168.0.1")),
+				DNSName:     "new",
+				LastUpdated: test.lastUpdated,
+			})
+			if test.expectedErr != nil {
+				if !errors.Is(err, test.expectedErr) {
+					t.Errorf("want error %q, got %v", test.expectedErr, err)
+				}
+				var conflict *ConflictError
+				if !errors.As(err, &conflict) {
+					t.Fatalf("want ConflictError, got %T", err)
+				}
+				if conflict.IP.LastUpdated != "2022-06-01T12:00:00.000000Z" {
+					t.Errorf("want last updated time of the changed IP, got %q", conflict.IP.LastUpdated)
+				}
+			} else if err != nil {
+				t.Errorf("upserting IP: %s", err)
+			} else if upserted.LastUpdated != "2022-06-01T12:10:00.000000Z" {
+				t.Errorf("want last updated time of the updated IP, got %q", upserted.LastUpdated)
+			}
+			if put != test.expectedPut {
+				t.Errorf("want IP updated: %v, got %v", test.expectedPut, put)
+			}
+		})
+	}
+}
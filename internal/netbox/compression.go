@@ -0,0 +1,103 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// listResponseBodySizeLimit is the max size of responses of list endpoints,
+// in bytes, after decompression: a page of IPs with many custom fields
+// takes a few MiB, which would exceed responseBodySizeLimit.
+const listResponseBodySizeLimit = 32 << 20
+
+// bodySizeLimit returns the max size of the response to a request with
+// the given method and URL: GET requests of collections, rather than of
+// single objects by ID, may return large lists.
+func bodySizeLimit(method, rawURL string) int64 {
+	if method != http.MethodGet {
+		return responseBodySizeLimit
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return responseBodySizeLimit
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if _, err := strconv.ParseInt(segments[len(segments)-1], 10, 64); err == nil {
+		return responseBodySizeLimit
+	}
+	return listResponseBodySizeLimit
+}
+
+// acceptGzip asks NetBox for a gzip-compressed response. Since the header
+// is set explicitly, the transport leaves decompressing it to decompress,
+// which works regardless of the transport used.
+func acceptGzip(req *http.Request) {
+	req.Header.Set("Accept-Encoding", "gzip")
+}
+
+// decompress replaces the body of a gzip-compressed response
+// with its decompressed content.
+func decompress(res *http.Response) error {
+	if !strings.EqualFold(strings.TrimSpace(res.Header.Get("Content-Encoding")), "gzip") {
+		return nil
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if errors.Is(err, io.EOF) {
+		// e.g. the empty body of a 204 response
+		res.Body = gzipBody{Reader: http.NoBody, body: res.Body}
+	} else if err != nil {
+		return err
+	} else {
+		res.Body = gzipBody{Reader: zr, body: res.Body}
+	}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return nil
+}
+
+// gzipBody reads the decompressed body of a response, and closes the original body.
+type gzipBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b gzipBody) Close() error {
+	return b.body.Close()
+}
+
+// readBody reads up to limit bytes of body, and fails if it is longer,
+// rather than returning a truncated body.
+func readBody(body io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("response body exceeds %d bytes", limit)
+	}
+	return data, nil
+}
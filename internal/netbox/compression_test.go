@@ -0,0 +1,98 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzipResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("want gzip accepted, got Accept-Encoding %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		if r.Method == http.MethodDelete {
+			// an empty body is not gzipped, even though the header says so
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(`{"count": 1, "results": [{"id": 1, "name": "foo", "slug": "foo"}]}`))
+		zw.Close()
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag, err := c.GetTag(context.Background(), "foo")
+	if err != nil {
+		t.Fatalf("getting tag: %s", err)
+	}
+	if tag == nil || tag.ID != 1 {
+		t.Errorf("want tag 1, got %v", tag)
+	}
+
+	if err := c.(TagCollector).DeleteTag(context.Background(), *tag); err != nil {
+		t.Errorf("deleting tag: %s", err)
+	}
+}
+
+func TestBodySizeLimit(t *testing.T) {
+	tests := []struct {
+		method        string
+		url           string
+		expectedLimit int64
+	}{{
+		method:        http.MethodGet,
+		url:           "https://netbox.example.com/api/ipam/ip-addresses/?limit=500&offset=0",
+		expectedLimit: listResponseBodySizeLimit,
+	}, {
+		method:        http.MethodGet,
+		url:           "https://netbox.example.com/api/ipam/ip-addresses/42/",
+		expectedLimit: responseBodySizeLimit,
+	}, {
+		method:        http.MethodPut,
+		url:           "https://netbox.example.com/api/ipam/ip-addresses/42/",
+		expectedLimit: responseBodySizeLimit,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.url, func(t *testing.T) {
+			if limit := bodySizeLimit(test.method, test.url); limit != test.expectedLimit {
+				t.Errorf("want limit %d, got %d", test.expectedLimit, limit)
+			}
+		})
+	}
+}
+
+func TestReadBodyLimit(t *testing.T) {
+	if _, err := readBody(strings.NewReader("12345"), 5); err != nil {
+		t.Errorf("want body within the limit read, got %s", err)
+	}
+	if _, err := readBody(strings.NewReader("123456"), 5); err == nil {
+		t.Error("want error for body over the limit, got nil")
+	}
+}
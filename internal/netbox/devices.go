@@ -0,0 +1,41 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"fmt"
+)
+
+// DeviceFinder is implemented by clients that can look up
+// NetBox devices, e.g. to find the device a node runs on.
+type DeviceFinder interface {
+	// DeviceName returns the name of the device whose field, a filter of
+	// the NetBox devices endpoint such as serial or cf_<custom field>,
+	// has the given value, or an empty string if there is none.
+	DeviceName(ctx context.Context, field, value string) (string, error)
+}
+
+// DeviceName looks up the device, whose name is cached like
+// the IDs of VRFs and tenants, see WithLookupCacheTTL.
+func (c *client) DeviceName(ctx context.Context, field, value string) (string, error) {
+	entry, err := c.lookup(ctx, devicesEndpoint, field, value)
+	if err != nil {
+		return "", fmt.Errorf("looking up device: %w", err)
+	}
+	return entry.name, nil
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeviceName(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RequestURI())
+		if r.URL.Query().Get("serial") == "abc123" {
+			w.Write([]byte(`{"count": 1, "results": [{"id": 4, "name": "rack1-server3"}]}`))
+			return
+		}
+		w.Write([]byte(`{"count": 0, "results": []}`))
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	finder := c.(DeviceFinder)
+
+	for i := 0; i < 2; i++ {
+		name, err := finder.DeviceName(context.Background(), "serial", "abc123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "rack1-server3" {
+			t.Errorf("want device rack1-server3, got %q", name)
+		}
+	}
+
+	name, err := finder.DeviceName(context.Background(), "cf_provider_id", "digitalocean://42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "" {
+		t.Errorf("want no device, got %q", name)
+	}
+
+	expected := []string{
+		"/dcim/devices/?serial=abc123",
+		"/dcim/devices/?cf_provider_id=digitalocean%3A%2F%2F42",
+	}
+	if len(queries) != len(expected) || queries[0] != expected[0] || queries[1] != expected[1] {
+		t.Errorf("want queries %q, got %q", expected, queries)
+	}
+}
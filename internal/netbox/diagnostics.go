@@ -0,0 +1,105 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Diagnoser is implemented by clients that can check
+// whether NetBox is set up for the controller.
+type Diagnoser interface {
+	// Version returns the version of NetBox, which
+	// also checks that NetBox can be reached.
+	Version(ctx context.Context) (string, error)
+	// CanWriteIPs returns true if the credentials
+	// of the client allow creating IPs.
+	CanWriteIPs(ctx context.Context) (bool, error)
+	// CheckUIDField returns an error if the UID custom field
+	// does not exist, or differs from the one that UpsertUIDField
+	// creates, e.g. because it was created by an older version.
+	CheckUIDField(ctx context.Context) error
+}
+
+// Version returns the version of NetBox.
+func (c *client) Version(ctx context.Context) (string, error) {
+	data, err := c.executeRequest(ctx, c.baseURL+"/status/", http.MethodGet, nil)
+	if err != nil {
+		return "", fmt.Errorf("executing request: %w", err)
+	}
+
+	var status struct {
+		Version string `json:"netbox-version"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return "", fmt.Errorf("unmarshaling response: %w", err)
+	}
+	return status.Version, nil
+}
+
+// CanWriteIPs returns true if the credentials of the client allow creating
+// IPs: NetBox only describes the POST action of an endpoint to users who
+// are allowed to use it.
+func (c *client) CanWriteIPs(ctx context.Context) (bool, error) {
+	data, err := c.executeRequest(ctx, c.baseURL+"/ipam/ip-addresses/", http.MethodOptions, nil)
+	if err != nil {
+		return false, fmt.Errorf("executing request: %w", err)
+	}
+
+	var metadata struct {
+		Actions map[string]json.RawMessage `json:"actions"`
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return false, fmt.Errorf("unmarshaling response: %w", err)
+	}
+	_, ok := metadata.Actions[http.MethodPost]
+	return ok, nil
+}
+
+// CheckUIDField checks the UID custom field.
+func (c *client) CheckUIDField(ctx context.Context) error {
+	field, err := c.getCustomUIDField(ctx)
+	if err != nil {
+		return err
+	}
+	if field == nil {
+		return fmt.Errorf("custom field %s does not exist: it is created when the controller starts", c.uidFieldName)
+	}
+
+	var ipAddresses bool
+	for _, contentType := range field.ContentTypes {
+		if contentType == "ipam.ipaddress" {
+			ipAddresses = true
+		}
+	}
+	if !ipAddresses {
+		return fmt.Errorf("custom field %s is not a field of IP addresses", c.uidFieldName)
+	}
+	if field.Type != "text" {
+		return fmt.Errorf("custom field %s is a %s field rather than a text field", c.uidFieldName, field.Type)
+	}
+	if field.FilterLogic != "exact" {
+		return fmt.Errorf("custom field %s is not filtered exactly: IPs with similar UIDs would be mixed up", c.uidFieldName)
+	}
+	if field.ValidationRegex != uidRegexpStr {
+		return fmt.Errorf("custom field %s has an outdated validation regex: it is updated when the controller starts", c.uidFieldName)
+	}
+	return nil
+}
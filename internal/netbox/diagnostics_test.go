@@ -0,0 +1,112 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCanWriteIPs(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected bool
+	}{{
+		name:     "read-write token",
+		response: `{"name": "IP Address List", "actions": {"POST": {}}}`,
+		expected: true,
+	}, {
+		name:     "read-only token",
+		response: `{"name": "IP Address List"}`,
+		expected: false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodOptions {
+					t.Errorf("expected %s request, got %s", http.MethodOptions, r.Method)
+				}
+				fmt.Fprint(w, test.response)
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			canWrite, err := c.(Diagnoser).CanWriteIPs(context.Background())
+			if err != nil {
+				t.Fatalf("checking permissions: %s", err)
+			}
+			if canWrite != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, canWrite)
+			}
+		})
+	}
+}
+
+func TestCheckUIDField(t *testing.T) {
+	upToDate := fmt.Sprintf(`{"name": %q, "type": {"value": "text"}, "content_types": ["ipam.ipaddress"], "filter_logic": {"value": "exact"}, "validation_regex": %q}`, UIDCustomFieldName, uidRegexpStr)
+
+	tests := []struct {
+		name              string
+		fields            []string
+		expectedErrSubstr string
+	}{{
+		name:   "up to date",
+		fields: []string{upToDate},
+	}, {
+		name:              "missing",
+		expectedErrSubstr: "does not exist",
+	}, {
+		name:              "loose filter logic",
+		fields:            []string{strings.Replace(upToDate, `"exact"`, `"loose"`, 1)},
+		expectedErrSubstr: "not filtered exactly",
+	}, {
+		name:              "outdated validation regex",
+		fields:            []string{fmt.Sprintf(`{"name": %q, "type": "text", "content_types": ["ipam.ipaddress"], "filter_logic": "exact"}`, UIDCustomFieldName)},
+		expectedErrSubstr: "outdated validation regex",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"count": %d, "results": [%s]}`, len(test.fields), strings.Join(test.fields, ","))
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.(Diagnoser).CheckUIDField(context.Background())
+			if test.expectedErrSubstr == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if test.expectedErrSubstr != "" && (err == nil || !strings.Contains(err.Error(), test.expectedErrSubstr)) {
+				t.Errorf("expected error containing %q, got %v", test.expectedErrSubstr, err)
+			}
+		})
+	}
+}
@@ -19,11 +19,22 @@ package netbox
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"sort"
 )
 
 type fakeClient struct {
 	tags map[string]Tag
-	ips  map[UID]IPAddress
+	// names of the tags created by CreateTag
+	createdTags map[string]bool
+	ips         map[UID]IPAddress
+	services    map[string]NodePortService
+	// prefixes allocated by AllocatePrefix, by description
+	prefixes map[string]netip.Prefix
+	// allowedPrefixes, if not empty, are the only ranges in which IPs may be written
+	allowedPrefixes []netip.Prefix
 }
 
 // NewFakeClient returns a fake NetBox client.
@@ -40,6 +51,14 @@ func NewFakeClient(tags map[string]Tag, ips map[UID]IPAddress) Client {
 	}
 }
 
+// NewFakeClientWithAllowedPrefixes returns a fake NetBox client, which refuses
+// to write IPs outside of the given prefixes, like WithAllowedPrefixes.
+func NewFakeClientWithAllowedPrefixes(tags map[string]Tag, ips map[UID]IPAddress, prefixes []netip.Prefix) Client {
+	c := NewFakeClient(tags, ips).(*fakeClient)
+	c.allowedPrefixes = prefixes
+	return c
+}
+
 // GetTag returns a tag with the given name from fake NetBox.
 func (c *fakeClient) GetTag(_ context.Context, tag string) (*Tag, error) {
 	if t, ok := c.tags[tag]; ok {
@@ -58,9 +77,39 @@ func (c *fakeClient) CreateTag(_ context.Context, tag string) (*Tag, error) {
 		Slug: tag,
 	}
 	c.tags[tag] = t
+	if c.createdTags == nil {
+		c.createdTags = make(map[string]bool)
+	}
+	c.createdTags[tag] = true
 	return &t, nil
 }
 
+// ListUnusedTags returns the tags created by CreateTag
+// that no IP in fake NetBox is tagged with.
+func (c *fakeClient) ListUnusedTags(_ context.Context) ([]Tag, error) {
+	used := make(map[string]bool)
+	for _, ip := range c.ips {
+		for _, tag := range ip.Tags {
+			used[tag.Name] = true
+		}
+	}
+	var tags []Tag
+	for name := range c.createdTags {
+		if !used[name] {
+			tags = append(tags, c.tags[name])
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+// DeleteTag deletes a tag from fake NetBox.
+func (c *fakeClient) DeleteTag(_ context.Context, tag Tag) error {
+	delete(c.tags, tag.Name)
+	delete(c.createdTags, tag.Name)
+	return nil
+}
+
 // GetIP returns an IP with the given UID from fake NetBox.
 func (c *fakeClient) GetIP(_ context.Context, uid UID) (*IPAddress, error) {
 	if ip, ok := c.ips[uid]; ok {
@@ -70,21 +119,190 @@ func (c *fakeClient) GetIP(_ context.Context, uid UID) (*IPAddress, error) {
 }
 
 // UpsertIP adds an IP to fake NetBox or updates it if already exists.
-func (c *fakeClient) UpsertIP(_ context.Context, ip *IPAddress) (*IPAddress, error) {
+// The ID and web URL of an existing IP are kept.
+func (c *fakeClient) UpsertIP(_ context.Context, ip *IPAddress) (*IPAddress, UpsertResult, error) {
+	if err := allowed(c.allowedPrefixes, "upsert", ip.Address); err != nil {
+		return nil, UpsertUnchanged, err
+	}
 	if c.ips == nil {
 		c.ips = make(map[UID]IPAddress)
 	}
-	c.ips[ip.UID] = *ip
-	return ip, nil
+	existing, exists := c.ips[ip.UID]
+	if takenOver, ok := c.ips[ip.TakeOverUID]; ok && !exists && ip.TakeOverUID != "" {
+		delete(c.ips, ip.TakeOverUID)
+		existing, exists = takenOver, true
+	}
+	stored := *ip
+	stored.TakeOverUID = ""
+	if !exists {
+		c.ips[ip.UID] = stored
+		return &stored, UpsertCreated, nil
+	}
+	stored.ID, stored.WebURL = existing.ID, existing.WebURL
+	if reflect.DeepEqual(existing, stored) {
+		return &existing, UpsertUnchanged, nil
+	}
+	c.ips[ip.UID] = stored
+	return &stored, UpsertUpdated, nil
 }
 
 // DeleteIP deletes an IP with the given UID from fake NetBox.
 func (c *fakeClient) DeleteIP(_ context.Context, uid UID) error {
+	if ip, ok := c.ips[uid]; ok {
+		if err := allowed(c.allowedPrefixes, "delete", ip.Address); err != nil {
+			return err
+		}
+	}
 	delete(c.ips, uid)
 	return nil
 }
 
+// ReleaseIP clears the UID of an IP with the given UID in fake NetBox.
+// Since IPs are keyed by UID, it is kept under the empty UID.
+func (c *fakeClient) ReleaseIP(_ context.Context, uid UID) error {
+	if ip, ok := c.ips[uid]; ok {
+		if err := allowed(c.allowedPrefixes, "release", ip.Address); err != nil {
+			return err
+		}
+		delete(c.ips, uid)
+		ip.UID = ""
+		c.ips[""] = ip
+	}
+	return nil
+}
+
+// DeprecateIP appends note to the description of an IP with the given UID
+// in fake NetBox and clears its UID. Like with ReleaseIP, it is kept
+// under the empty UID. The status of IPs is not tracked.
+func (c *fakeClient) DeprecateIP(_ context.Context, uid UID, note string) error {
+	if ip, ok := c.ips[uid]; ok {
+		if err := allowed(c.allowedPrefixes, "deprecate", ip.Address); err != nil {
+			return err
+		}
+		delete(c.ips, uid)
+		ip.UID = ""
+		ip.Description = AppendNote(ip.Description, note)
+		c.ips[""] = ip
+	}
+	return nil
+}
+
 // UpsertUIDField is a noop.
 func (c *fakeClient) UpsertUIDField(ctx context.Context) error {
 	return nil
 }
+
+// UpsertNodePortService adds a node port service to fake NetBox
+// or updates it if it already exists. Since fake NetBox has no devices,
+// there is a single service with the name of svc.
+func (c *fakeClient) UpsertNodePortService(_ context.Context, svc *NodePortService) error {
+	if c.services == nil {
+		c.services = make(map[string]NodePortService)
+	}
+	c.services[svc.Name] = *svc
+	return nil
+}
+
+// DeleteNodePortService deletes the node port service with the given name from fake NetBox.
+func (c *fakeClient) DeleteNodePortService(_ context.Context, name string) error {
+	delete(c.services, name)
+	return nil
+}
+
+// ListIPs returns the IPs in fake NetBox that match filter.
+func (c *fakeClient) ListIPs(_ context.Context, filter IPFilter) ([]IPAddress, error) {
+	var ips []IPAddress
+	for uid, ip := range c.ips {
+		// IPs are keyed by UID
+		ip.UID = uid
+		if filter.Matches(&ip) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
+
+// AllocateIP adds an IP with the first address in prefix that no IP in
+// fake NetBox has to fake NetBox. Prefixes exist regardless of their VRF.
+func (c *fakeClient) AllocateIP(_ context.Context, prefix netip.Prefix, ip *IPAddress) (*IPAddress, error) {
+	if c.ips == nil {
+		c.ips = make(map[UID]IPAddress)
+	}
+	prefix = prefix.Masked()
+	used := make(map[netip.Addr]bool)
+	for _, existing := range c.ips {
+		used[netip.Addr(existing.Address)] = true
+	}
+	addr := prefix.Addr()
+	if addr.Is4() && prefix.Bits() < 31 {
+		// the network address is not available
+		addr = addr.Next()
+	}
+	for ; prefix.Contains(addr) && used[addr]; addr = addr.Next() {
+	}
+	if !prefix.Contains(addr) {
+		return nil, fmt.Errorf("%w: %s", ErrPrefixFull, prefix)
+	}
+
+	allocated := *ip
+	allocated.Address = IP(addr)
+	allocated.PrefixLength = prefix.Bits()
+	c.ips[ip.UID] = allocated
+	return &allocated, nil
+}
+
+// AllocatePrefix adds a prefix with the description and the first range of
+// the given length in parent that no other prefix overlaps to fake NetBox,
+// unless a prefix with the description exists already.
+func (c *fakeClient) AllocatePrefix(_ context.Context, parent netip.Prefix, length int, description string) (netip.Prefix, error) {
+	if prefix, ok := c.prefixes[description]; ok {
+		return prefix, nil
+	}
+	if c.prefixes == nil {
+		c.prefixes = make(map[string]netip.Prefix)
+	}
+
+	parent = parent.Masked()
+	if length <= parent.Bits() || length > parent.Addr().BitLen() {
+		return netip.Prefix{}, fmt.Errorf("invalid prefix length %d", length)
+	}
+	candidate := netip.PrefixFrom(parent.Addr(), length)
+	for parent.Contains(candidate.Addr()) {
+		overlaps := false
+		for _, prefix := range c.prefixes {
+			if prefix.Overlaps(candidate) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			c.prefixes[description] = candidate
+			return candidate, nil
+		}
+		candidate = nextPrefix(candidate)
+	}
+	return netip.Prefix{}, fmt.Errorf("%w: %s", ErrPrefixFull, parent)
+}
+
+// nextPrefix returns the prefix of the same length right after prefix,
+// or an invalid prefix if there is none.
+func nextPrefix(prefix netip.Prefix) netip.Prefix {
+	b := prefix.Addr().AsSlice()
+	carry := 1 << (7 - (prefix.Bits()-1)%8)
+	for i := (prefix.Bits() - 1) / 8; i >= 0 && carry > 0; i-- {
+		sum := int(b[i]) + carry
+		b[i] = byte(sum)
+		carry = sum >> 8
+	}
+	if carry > 0 {
+		return netip.Prefix{}
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return netip.PrefixFrom(addr, prefix.Bits())
+}
+
+// DeletePrefix removes the prefix with the description from fake NetBox.
+func (c *fakeClient) DeletePrefix(_ context.Context, _ netip.Prefix, description string) error {
+	delete(c.prefixes, description)
+	return nil
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	log "go.uber.org/zap"
+)
+
+// CreatedInterfaceDescription is the description of interfaces created by
+// the controller, by which they are told apart from other interfaces in NetBox.
+const CreatedInterfaceDescription = "Created by netbox-ip-controller"
+
+// createdInterfaceType is the type of the device interfaces
+// created by the controller.
+const createdInterfaceType = "virtual"
+
+// interfaceEndpoint returns the API endpoint of interfaces
+// of the given type, and the field referencing their parent.
+func interfaceEndpoint(interfaceType string) (string, string, error) {
+	switch interfaceType {
+	case AssignedObjectTypeInterface:
+		return "dcim/interfaces", "device", nil
+	case AssignedObjectTypeVMInterface:
+		return "virtualization/interfaces", "virtual_machine", nil
+	default:
+		return "", "", fmt.Errorf("unknown interface type %q", interfaceType)
+	}
+}
+
+// createInterface creates the referenced interface with its MAC address
+// on its device or virtual machine, which must already exist.
+func (c *client) createInterface(ctx context.Context, ref *InterfaceRef) (int64, error) {
+	endpoint, parentField, err := interfaceEndpoint(ref.Type)
+	if err != nil {
+		return 0, err
+	}
+	parentEndpoint := "dcim/devices"
+	if ref.Type == AssignedObjectTypeVMInterface {
+		parentEndpoint = "virtualization/virtual-machines"
+	}
+
+	query := url.Values{}
+	query.Set("name", ref.Parent)
+	data, err := c.executeRequest(ctx, fmt.Sprintf("%s/%s/?%s", c.baseURL, parentEndpoint, query.Encode()), http.MethodGet, nil)
+	if err != nil {
+		return 0, fmt.Errorf("looking up %s: %w", parentField, err)
+	}
+	var parents struct {
+		Results []struct {
+			ID int64 `json:"id"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(data, &parents); err != nil {
+		return 0, fmt.Errorf("unmarshaling response: %w", err)
+	}
+	if len(parents.Results) != 1 {
+		return 0, fmt.Errorf("found %d of %s named %q", len(parents.Results), parentEndpoint, ref.Parent)
+	}
+
+	body := map[string]interface{}{
+		parentField:   parents.Results[0].ID,
+		"name":        ref.Name,
+		"mac_address": ref.MACAddress,
+		"description": CreatedInterfaceDescription,
+	}
+	if ref.Type == AssignedObjectTypeInterface {
+		body["type"] = createdInterfaceType
+	}
+	record := AuditRecord{
+		Operation: AuditOperationCreate,
+		Object:    AuditObjectInterface,
+		Name:      ref.Name,
+		Changes:   map[string]AuditChange{"mac_address": {New: ref.MACAddress}},
+	}
+	data, err = c.executeRequest(ctx, fmt.Sprintf("%s/%s/", c.baseURL, endpoint), http.MethodPost, body)
+	if err != nil {
+		c.recordFailedAudit(record, err)
+		return 0, fmt.Errorf("creating interface: %w", err)
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(data, &created); err != nil {
+		return 0, fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	record.ID = created.ID
+	c.recordAudit(record)
+	return created.ID, nil
+}
+
+// updateInterfaceMAC sets the MAC address of the interface with the given ID.
+func (c *client) updateInterfaceMAC(ctx context.Context, ref *InterfaceRef, id int64, oldMAC string) error {
+	endpoint, _, err := interfaceEndpoint(ref.Type)
+	if err != nil {
+		return err
+	}
+	record := AuditRecord{
+		Operation: AuditOperationUpdate,
+		Object:    AuditObjectInterface,
+		ID:        id,
+		Name:      ref.Name,
+		Changes:   map[string]AuditChange{"mac_address": {Old: oldMAC, New: ref.MACAddress}},
+	}
+	url := fmt.Sprintf("%s/%s/%d/", c.baseURL, endpoint, id)
+	if _, err := c.executeRequest(ctx, url, http.MethodPatch, map[string]interface{}{"mac_address": ref.MACAddress}); err != nil {
+		c.recordFailedAudit(record, err)
+		return fmt.Errorf("updating MAC address of interface: %w", err)
+	}
+
+	c.recordAudit(record)
+	return nil
+}
+
+// deleteCreatedInterface deletes the interface that the deleted IP was
+// assigned to, if it was created by the controller and has no IPs left.
+// Failing to do so does not fail the deletion of the IP, since it would
+// not be retried once the IP is gone.
+func (c *client) deleteCreatedInterface(ctx context.Context, ip *IPAddress) {
+	if ip.AssignedObjectType == "" {
+		return
+	}
+	endpoint, _, err := interfaceEndpoint(ip.AssignedObjectType)
+	if err != nil {
+		return
+	}
+	ll := c.logger.With(log.String("type", ip.AssignedObjectType), log.Int64("interfaceID", ip.AssignedObjectID))
+
+	url := fmt.Sprintf("%s/%s/%d/", c.baseURL, endpoint, ip.AssignedObjectID)
+	data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+	if isNotFound(err) {
+		return
+	} else if err != nil {
+		ll.Warn("failed to look up interface of deleted IP", log.Error(err))
+		return
+	}
+	var iface struct {
+		Name             string `json:"name"`
+		Description      string `json:"description"`
+		CountIPAddresses *int   `json:"count_ipaddresses"`
+	}
+	if err := json.Unmarshal(data, &iface); err != nil {
+		ll.Warn("failed to look up interface of deleted IP", log.Error(err))
+		return
+	}
+	if iface.Description != CreatedInterfaceDescription || iface.CountIPAddresses == nil || *iface.CountIPAddresses > 0 {
+		return
+	}
+
+	record := AuditRecord{
+		Operation: AuditOperationDelete,
+		Object:    AuditObjectInterface,
+		ID:        ip.AssignedObjectID,
+		Name:      iface.Name,
+	}
+	if _, err := c.executeRequest(ctx, url, http.MethodDelete, nil); err != nil && !isNotFound(err) {
+		c.recordFailedAudit(record, err)
+		ll.Warn("failed to delete interface of deleted IP", log.Error(err))
+		return
+	}
+	c.recordAudit(record)
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestUpsertIPInterfaceMAC(t *testing.T) {
+	tests := []struct {
+		name           string
+		ref            InterfaceRef
+		interfaces     string
+		expectedWrites []string
+		expectedID     int64
+	}{{
+		name:           "created",
+		ref:            InterfaceRef{Type: AssignedObjectTypeInterface, Name: "eth0-abc", Parent: "node1", MACAddress: "0a:58:0a:00:00:01"},
+		interfaces:     `{"count": 0, "results": []}`,
+		expectedWrites: []string{`POST /dcim/interfaces/ {"description":"Created by netbox-ip-controller","device":3,"mac_address":"0a:58:0a:00:00:01","name":"eth0-abc","type":"virtual"}`},
+		expectedID:     21,
+	}, {
+		name:           "created on virtual machine",
+		ref:            InterfaceRef{Type: AssignedObjectTypeVMInterface, Name: "eth0-abc", Parent: "node1", MACAddress: "0a:58:0a:00:00:01"},
+		interfaces:     `{"count": 0, "results": []}`,
+		expectedWrites: []string{`POST /virtualization/interfaces/ {"description":"Created by netbox-ip-controller","mac_address":"0a:58:0a:00:00:01","name":"eth0-abc","virtual_machine":3}`},
+		expectedID:     21,
+	}, {
+		name:           "MAC address changed",
+		ref:            InterfaceRef{Type: AssignedObjectTypeInterface, Name: "eth0-abc", Parent: "node1", MACAddress: "0a:58:0a:00:00:01"},
+		interfaces:     `{"count": 1, "results": [{"id": 12, "mac_address": "0A:58:0A:00:00:02"}]}`,
+		expectedWrites: []string{`PATCH /dcim/interfaces/12/ {"mac_address":"0a:58:0a:00:00:01"}`},
+		expectedID:     12,
+	}, {
+		name:       "MAC address unchanged",
+		ref:        InterfaceRef{Type: AssignedObjectTypeInterface, Name: "eth0-abc", Parent: "node1", MACAddress: "0a:58:0a:00:00:01"},
+		interfaces: `{"count": 1, "results": [{"id": 12, "mac_address": "0A:58:0A:00:00:01"}]}`,
+		expectedID: 12,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var writes []string
+			var created IPAddress
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				switch {
+				case r.Method == http.MethodGet && (r.URL.Path == "/dcim/interfaces/" || r.URL.Path == "/virtualization/interfaces/"):
+					w.Write([]byte(test.interfaces))
+				case r.Method == http.MethodGet && (r.URL.Path == "/dcim/devices/" || r.URL.Path == "/virtualization/virtual-machines/"):
+					w.Write([]byte(`{"count": 1, "results": [{"id": 3}]}`))
+				case r.Method == http.MethodGet:
+					w.Write([]byte(`{"count": 0, "results": []}`))
+				case r.URL.Path == "/ipam/ip-addresses/":
+					json.Unmarshal(body, &created)
+					w.Write(body)
+				default:
+					writes = append(writes, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, body))
+					w.Write([]byte(`{"id": 21}`))
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ref := test.ref
+			_, _, err = c.UpsertIP(context.Background(), &IPAddress{
+				UID:               UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"),
+				Address:           IP(netip.MustParseAddr("192.168.0.1")),
+				AssignedInterface: &ref,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(writes) != fmt.Sprint(test.expectedWrites) {
+				t.Errorf("want writes %q, got %q", test.expectedWrites, writes)
+			}
+			if created.AssignedObjectType != test.ref.Type || created.AssignedObjectID != test.expectedID {
+				t.Errorf("want IP assigned to %s %d, got %s %d", test.ref.Type, test.expectedID, created.AssignedObjectType, created.AssignedObjectID)
+			}
+		})
+	}
+}
+
+func TestDeleteIPCreatedInterface(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name              string
+		iface             string
+		expectedDeletions []string
+	}{{
+		name:              "created and unused",
+		iface:             fmt.Sprintf(`{"id": 12, "name": "eth0-abc", "description": %q, "count_ipaddresses": 0}`, CreatedInterfaceDescription),
+		expectedDeletions: []string{"/ipam/ip-addresses/1/", "/dcim/interfaces/12/"},
+	}, {
+		name:              "created and still used",
+		iface:             fmt.Sprintf(`{"id": 12, "name": "eth0-abc", "description": %q, "count_ipaddresses": 1}`, CreatedInterfaceDescription),
+		expectedDeletions: []string{"/ipam/ip-addresses/1/"},
+	}, {
+		name:              "not created by the controller",
+		iface:             `{"id": 12, "name": "eth0", "description": "", "count_ipaddresses": 0}`,
+		expectedDeletions: []string{"/ipam/ip-addresses/1/"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var deletions []string
+			ip := fmt.Sprintf(`{"id": 1, "address": "192.168.0.1/32", "assigned_object_type": "dcim.interface", "assigned_object_id": 12, "custom_fields": {"%s": "%s"}}`, UIDCustomFieldName, uid)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/dcim/interfaces/12/":
+					w.Write([]byte(test.iface))
+				case r.Method == http.MethodGet && r.URL.Path == "/ipam/ip-addresses/1/":
+					w.Write([]byte(ip))
+				case r.Method == http.MethodGet:
+					fmt.Fprintf(w, `{"count": 1, "results": [%s]}`, ip)
+				case r.Method == http.MethodDelete:
+					deletions = append(deletions, r.URL.Path)
+					w.WriteHeader(http.StatusNoContent)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.DeleteIP(context.Background(), uid); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(deletions) != fmt.Sprint(test.expectedDeletions) {
+				t.Errorf("want deletions %v, got %v", test.expectedDeletions, deletions)
+			}
+		})
+	}
+}
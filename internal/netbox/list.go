@@ -0,0 +1,128 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// listPageSize is the number of IPs requested at once when listing IPs.
+const listPageSize = 500
+
+// IPFilter selects the IPs returned by ListIPs: IPs must match
+// every field that is set. The zero IPFilter selects all IPs.
+type IPFilter struct {
+	// Managed selects the IPs that are managed by the controller, i.e. that
+	// have a UID, with the UID prefix of the client, if any.
+	Managed bool
+	// Tag selects the IPs that have the tag with this slug.
+	Tag string
+	// Prefix selects the IPs within this prefix.
+	Prefix netip.Prefix
+	// CustomField selects the IPs that have a value
+	// in the custom field with this name.
+	CustomField string
+}
+
+// Matches returns true if ip, as returned by ListIPs, is selected by the filter.
+func (filter IPFilter) Matches(ip *IPAddress) bool {
+	if filter.Managed && ip.UID == "" {
+		return false
+	}
+	if filter.Tag != "" {
+		var tagged bool
+		for _, tag := range ip.Tags {
+			tagged = tagged || tag.Slug == filter.Tag
+		}
+		if !tagged {
+			return false
+		}
+	}
+	if filter.Prefix.IsValid() && !filter.Prefix.Contains(netip.Addr(ip.Address)) {
+		return false
+	}
+	return filter.CustomField == "" || ip.CustomFields[filter.CustomField] != ""
+}
+
+// ListIPs returns the IPs in NetBox that match filter, requesting them in pages.
+func (c *client) ListIPs(ctx context.Context, filter IPFilter) ([]IPAddress, error) {
+	query := url.Values{}
+	if filter.Managed {
+		query.Set(fmt.Sprintf("cf_%s__empty", c.uidFieldName), "false")
+	}
+	if filter.Tag != "" {
+		query.Set("tag", filter.Tag)
+	}
+	if filter.Prefix.IsValid() {
+		query.Set("parent", filter.Prefix.String())
+	}
+	if filter.CustomField != "" {
+		query.Set(fmt.Sprintf("cf_%s__empty", filter.CustomField), "false")
+	}
+	query.Set("limit", fmt.Sprint(listPageSize))
+
+	var ips []IPAddress
+	for offset := 0; ; {
+		query.Set("offset", fmt.Sprint(offset))
+		url := fmt.Sprintf("%s/ipam/ip-addresses/?%s", c.baseURL, query.Encode())
+		data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+		if err != nil {
+			return nil, fmt.Errorf("executing request: %w", err)
+		}
+
+		var ipList IPAddressList
+		if err := c.unmarshalIPList(data, &ipList); err != nil {
+			return nil, fmt.Errorf("unmarshaling response: %w", err)
+		}
+
+		for _, ip := range ipList.Results {
+			// IPs with a different UID prefix are not managed by this controller
+			uid, ok := c.unprefixedUID(ip.UID)
+			if !ok {
+				uid = ""
+			}
+			ip.UID = uid
+			// older NetBox versions may not filter by custom fields
+			if filter.Matches(&ip) {
+				ips = append(ips, ip)
+			}
+		}
+
+		offset += len(ipList.Results)
+		if len(ipList.Results) == 0 || offset >= int(ipList.Count) {
+			return ips, nil
+		}
+	}
+}
+
+// unprefixedUID returns the UID stored in NetBox without the UID prefix
+// of the client, and false if it is empty or has a different prefix.
+func (c *client) unprefixedUID(stored UID) (UID, bool) {
+	if stored == "" {
+		return "", false
+	}
+	prefix, uid, found := strings.Cut(string(stored), "/")
+	if !found {
+		return stored, c.uidPrefix == ""
+	}
+	return UID(uid), prefix == c.uidPrefix
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListManagedIPs(t *testing.T) {
+	// stored UIDs of the IPs in NetBox, by ID
+	stored := []UID{"", "a", "prod-1/b", "staging/c", "d"}
+
+	tests := []struct {
+		name         string
+		uidPrefix    string
+		expectedUIDs []UID
+	}{{
+		name:         "without UID prefix",
+		expectedUIDs: []UID{"a", "d"},
+	}, {
+		name:         "with UID prefix",
+		uidPrefix:    "prod-1",
+		expectedUIDs: []UID{"b"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// pages of 2 IPs
+				offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+				var results []string
+				for id := offset; id < len(stored) && id < offset+2; id++ {
+					results = append(results, fmt.Sprintf(`{"id": %d, "address": "192.168.0.%d/32", "custom_fields": {"%s": %q}}`, id, id+1, UIDCustomFieldName, stored[id]))
+				}
+				fmt.Fprintf(w, `{"count": %d, "results": [%s]}`, len(stored), strings.Join(results, ","))
+			}))
+			defer server.Close()
+
+			var opts []ClientOption
+			if test.uidPrefix != "" {
+				opts = append(opts, WithUIDPrefix(test.uidPrefix))
+			}
+			c, err := NewClient(server.URL, "foo", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ips, err := c.ListIPs(context.Background(), IPFilter{Managed: true})
+			if err != nil {
+				t.Fatalf("listing IPs: %s", err)
+			}
+			var uids []UID
+			for _, ip := range ips {
+				uids = append(uids, ip.UID)
+			}
+
+			if diff := cmp.Diff(test.expectedUIDs, uids); diff != "" {
+				t.Errorf("UIDs of managed IPs (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestListIPsFilter(t *testing.T) {
+	tests := []struct {
+		name          string
+		filter        IPFilter
+		expectedQuery string
+		expectedIDs   []int64
+	}{{
+		name:          "all IPs",
+		expectedQuery: "limit=500&offset=0",
+		expectedIDs:   []int64{1, 2, 3},
+	}, {
+		name:          "by tag",
+		filter:        IPFilter{Tag: "pod"},
+		expectedQuery: "limit=500&offset=0&tag=pod",
+		expectedIDs:   []int64{1, 2},
+	}, {
+		name:          "by prefix",
+		filter:        IPFilter{Prefix: netip.MustParsePrefix("10.0.0.0/24")},
+		expectedQuery: "limit=500&offset=0&parent=10.0.0.0%2F24",
+		expectedIDs:   []int64{1},
+	}, {
+		name:          "by custom field and tag",
+		filter:        IPFilter{CustomField: "cost_center", Tag: "pod"},
+		expectedQuery: "cf_cost_center__empty=false&limit=500&offset=0&tag=pod",
+		expectedIDs:   []int64{2},
+	}, {
+		name:          "managed",
+		filter:        IPFilter{Managed: true},
+		expectedQuery: "cf_netbox_ip_controller_uid__empty=false&limit=500&offset=0",
+		expectedIDs:   []int64{3},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var query string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.RawQuery
+				// as if NetBox did not filter at all, so that the IPs are filtered by the client
+				fmt.Fprintf(w, `{"count": 3, "results": [
+					{"id": 1, "address": "10.0.0.1/32", "tags": [{"name": "pod", "slug": "pod"}]},
+					{"id": 2, "address": "10.0.1.1/32", "tags": [{"name": "pod", "slug": "pod"}], "custom_fields": {"cost_center": "billing"}},
+					{"id": 3, "address": "10.0.1.2/32", "custom_fields": {"%s": "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"}}
+				]}`, UIDCustomFieldName)
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ips, err := c.ListIPs(context.Background(), test.filter)
+			if err != nil {
+				t.Fatalf("listing IPs: %s", err)
+			}
+			var ids []int64
+			for _, ip := range ips {
+				ids = append(ids, ip.ID)
+			}
+
+			if query != test.expectedQuery {
+				t.Errorf("want query %q, got %q", test.expectedQuery, query)
+			}
+			if diff := cmp.Diff(test.expectedIDs, ids); diff != "" {
+				t.Errorf("IDs of listed IPs (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
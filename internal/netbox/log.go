@@ -1,45 +1,69 @@
 package netbox
 
 import (
+	"fmt"
+
 	retryablehttp "github.com/hashicorp/go-retryablehttp"
 	"go.uber.org/zap"
 )
 
 // retryableHTTPLogger is a wrapper for zap logger that implements retyablehttp.LeveledLogger
-// interface and therefore can be passed to a retryablehttp client
+// interface and therefore can be passed to a retryablehttp client;
+// secrets are redacted from the logged values
 type retryableHTTPLogger struct {
-	logger *zap.Logger
+	logger   *zap.Logger
+	redactor *redactor
 }
 
-func newRetryableHTTPLogger(logger *zap.Logger) retryablehttp.LeveledLogger {
-	return &retryableHTTPLogger{logger: logger}
+func newRetryableHTTPLogger(logger *zap.Logger, redactor *redactor) retryablehttp.LeveledLogger {
+	return &retryableHTTPLogger{logger: logger, redactor: redactor}
 }
 
 func (l *retryableHTTPLogger) Error(msg string, keysAndValues ...interface{}) {
-	l.logger.Error(msg, fieldsFromKeysAndValues(keysAndValues)...)
+	l.logger.Error(msg, fieldsFromKeysAndValues(keysAndValues, l.redactor)...)
 }
 
 func (l *retryableHTTPLogger) Info(msg string, keysAndValues ...interface{}) {
-	l.logger.Info(msg, fieldsFromKeysAndValues(keysAndValues)...)
+	l.logger.Info(msg, fieldsFromKeysAndValues(keysAndValues, l.redactor)...)
 }
 
 func (l *retryableHTTPLogger) Debug(msg string, keysAndValues ...interface{}) {
-	l.logger.Info(msg, fieldsFromKeysAndValues(keysAndValues)...)
+	l.logger.Info(msg, fieldsFromKeysAndValues(keysAndValues, l.redactor)...)
 }
 
 func (l *retryableHTTPLogger) Warn(msg string, keysAndValues ...interface{}) {
-	l.logger.Info(msg, fieldsFromKeysAndValues(keysAndValues)...)
+	l.logger.Info(msg, fieldsFromKeysAndValues(keysAndValues, l.redactor)...)
 }
 
-func fieldsFromKeysAndValues(keysAndValues []interface{}) []zap.Field {
+func fieldsFromKeysAndValues(keysAndValues []interface{}, redactor *redactor) []zap.Field {
 	var fields []zap.Field
 	for i := 1; i < len(keysAndValues); i += 2 {
 		key := keysAndValues[i-1]
 		value := keysAndValues[i]
 		if keyStr, ok := key.(string); ok {
-			fields = append(fields, zap.Any(keyStr, value))
+			fields = append(fields, zap.Any(keyStr, redactor.redactValue(value)))
 		}
 		// ignore malformed key-value pair
 	}
 	return fields
 }
+
+// WithBodyLogging makes the client log the bodies of requests to NetBox and of
+// its responses at debug level, with secrets redacted, and truncated to limit
+// bytes, e.g. to diagnose requests that NetBox rejects as invalid.
+func WithBodyLogging(limit int) ClientOption {
+	return func(c *client) error {
+		c.bodyLogLimit = limit
+		return nil
+	}
+}
+
+// loggedBody returns body with secrets redacted, truncated to the body log limit.
+// Secrets are redacted first, so that they cannot be cut in half and thus missed.
+func (c *client) loggedBody(body []byte) string {
+	s := c.redactor.redact(string(body))
+	if len(s) > c.bodyLogLimit {
+		s = s[:c.bodyLogLimit] + fmt.Sprintf("... (%d bytes truncated)", len(s)-c.bodyLogLimit)
+	}
+	return s
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultLookupCacheTTL is how long the IDs of VRFs and
+// tenants are cached, unless set with WithLookupCacheTTL.
+const DefaultLookupCacheTTL = 10 * time.Minute
+
+// NetBox endpoints of the objects whose IDs are looked up by name or slug.
+const (
+	vrfsEndpoint    = "ipam/vrfs"
+	tenantsEndpoint = "tenancy/tenants"
+	devicesEndpoint = "dcim/devices"
+)
+
+// WithLookupCacheTTL sets how long the IDs of VRFs and tenants, looked up
+// by their names and slugs, are cached, so that they are not looked up every
+// time an IP is written. Once an ID has expired, it is looked up again, so
+// that re-created VRFs and tenants are eventually picked up. A ttl of 0
+// disables caching.
+func WithLookupCacheTTL(ttl time.Duration) ClientOption {
+	return func(c *client) error {
+		if ttl < 0 {
+			return fmt.Errorf("lookup cache TTL must not be negative, got %s", ttl)
+		}
+		c.lookups.ttl = ttl
+		return nil
+	}
+}
+
+type lookupKey struct {
+	endpoint string
+	field    string
+	value    string
+}
+
+type lookupEntry struct {
+	id      int64
+	name    string
+	expires time.Time
+}
+
+// lookupCache caches IDs and names of NetBox objects by the endpoint
+// they were looked up at and the field and value they were looked
+// up by, e.g. their name or slug, until they expire.
+type lookupCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[lookupKey]lookupEntry
+}
+
+func newLookupCache(ttl time.Duration) *lookupCache {
+	return &lookupCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[lookupKey]lookupEntry),
+	}
+}
+
+func (lc *lookupCache) get(key lookupKey) (lookupEntry, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	entry, ok := lc.entries[key]
+	if !ok || !lc.now().Before(entry.expires) {
+		delete(lc.entries, key)
+		return lookupEntry{}, false
+	}
+	return entry, true
+}
+
+func (lc *lookupCache) set(key lookupKey, entry lookupEntry) {
+	if lc.ttl <= 0 {
+		return
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	entry.expires = lc.now().Add(lc.ttl)
+	lc.entries[key] = entry
+}
+
+func (lc *lookupCache) forget(key lookupKey) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	delete(lc.entries, key)
+}
+
+// lookupID returns the ID of the object at the given endpoint whose field
+// has the given value, or 0 if there is no such object. IDs of objects that
+// exist are cached.
+func (c *client) lookupID(ctx context.Context, endpoint, field, value string) (int64, error) {
+	entry, err := c.lookup(ctx, endpoint, field, value)
+	return entry.id, err
+}
+
+// lookup returns the ID and name of the object at the given endpoint whose
+// field has the given value, or an empty entry if there is no such object.
+// Objects that exist are cached.
+func (c *client) lookup(ctx context.Context, endpoint, field, value string) (lookupEntry, error) {
+	key := lookupKey{endpoint: endpoint, field: field, value: value}
+	if entry, ok := c.lookups.get(key); ok {
+		return entry, nil
+	}
+
+	url := fmt.Sprintf("%s/%s/?%s=%s", c.baseURL, endpoint, field, url.QueryEscape(value))
+	data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+	if err != nil {
+		return lookupEntry{}, fmt.Errorf("executing request: %w", err)
+	}
+
+	var list struct {
+		Results []struct {
+			ID   int64  `json:"id"`
+			Name string `json:"name"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return lookupEntry{}, fmt.Errorf("unmarshaling response: %w", err)
+	}
+	if len(list.Results) == 0 {
+		// not cached, so that the object is found once it is created
+		return lookupEntry{}, nil
+	}
+	entry := lookupEntry{id: list.Results[0].ID, name: list.Results[0].Name}
+	c.lookups.set(key, entry)
+	return entry, nil
+}
+
+// vrfID returns the ID of the VRF, looking it up by its name if it is not set,
+// or 0 if there is no such VRF.
+func (c *client) vrfID(ctx context.Context, vrf *VRF) (int64, error) {
+	if vrf.ID != 0 {
+		return vrf.ID, nil
+	}
+	return c.lookupID(ctx, vrfsEndpoint, "name", vrf.Name)
+}
+
+// tenantID returns the ID of the tenant, looking it up by its slug if it
+// is not set, or 0 if there is no such tenant.
+func (c *client) tenantID(ctx context.Context, tenant *Tenant) (int64, error) {
+	if tenant.ID != 0 {
+		return tenant.ID, nil
+	}
+	return c.lookupID(ctx, tenantsEndpoint, "slug", tenant.Slug)
+}
+
+// resolveReferences sets the IDs of the VRF and tenant of ip, so that they
+// are written by ID: VRF names need not be unique in NetBox. References to
+// VRFs and tenants that do not exist are left for NetBox to reject.
+func (c *client) resolveReferences(ctx context.Context, ip *IPAddress) error {
+	if ip.VRF != nil && ip.VRF.Name != "" {
+		id, err := c.vrfID(ctx, ip.VRF)
+		if err != nil {
+			return fmt.Errorf("looking up VRF: %w", err)
+		}
+		ip.VRF = &VRF{ID: id, Name: ip.VRF.Name}
+	}
+	if ip.Tenant != nil && ip.Tenant.Slug != "" {
+		id, err := c.tenantID(ctx, ip.Tenant)
+		if err != nil {
+			return fmt.Errorf("looking up tenant: %w", err)
+		}
+		ip.Tenant = &Tenant{ID: id, Name: ip.Tenant.Name, Slug: ip.Tenant.Slug}
+	}
+	return nil
+}
+
+// forgetReferences removes the cached IDs of the VRF and tenant of ip,
+// e.g. because they may have been re-created with new IDs.
+func (c *client) forgetReferences(ip *IPAddress) {
+	if ip.VRF != nil {
+		c.lookups.forget(lookupKey{endpoint: vrfsEndpoint, field: "name", value: ip.VRF.Name})
+	}
+	if ip.Tenant != nil {
+		c.lookups.forget(lookupKey{endpoint: tenantsEndpoint, field: "slug", value: ip.Tenant.Slug})
+	}
+}
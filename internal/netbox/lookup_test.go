@@ -0,0 +1,169 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestLookupCache(t *testing.T) {
+	tests := []struct {
+		name            string
+		ttl             time.Duration
+		elapsed         time.Duration
+		expectedLookups int
+	}{{
+		name:            "cached",
+		ttl:             time.Minute,
+		elapsed:         30 * time.Second,
+		expectedLookups: 2,
+	}, {
+		name:            "expired",
+		ttl:             time.Minute,
+		elapsed:         time.Minute,
+		expectedLookups: 4,
+	}, {
+		name:            "caching disabled",
+		ttl:             0,
+		elapsed:         0,
+		expectedLookups: 4,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lookups int
+			var written []map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/ipam/vrfs/":
+					lookups++
+					w.Write([]byte(`{"count": 1, "results": [{"id": 9, "name": "blue"}]}`))
+				case r.Method == http.MethodGet && r.URL.Path == "/tenancy/tenants/":
+					lookups++
+					w.Write([]byte(`{"count": 1, "results": [{"id": 7, "name": "Team A", "slug": "team-a"}]}`))
+				case r.Method == http.MethodGet:
+					w.Write([]byte(`{"count": 0, "results": []}`))
+				default:
+					body, _ := io.ReadAll(r.Body)
+					var fields map[string]interface{}
+					json.Unmarshal(body, &fields)
+					written = append(written, fields)
+					w.Write(body)
+				}
+			}))
+			defer server.Close()
+
+			now := time.Now()
+			c, err := NewClient(server.URL, "foo", WithLookupCacheTTL(test.ttl))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.(*client).lookups.now = func() time.Time { return now }
+
+			upsert := func(address string) {
+				_, _, err := c.UpsertIP(context.Background(), &IPAddress{
+					UID:     UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"),
+					Address: IP(netip.MustParseAddr(address)),
+					VRF:     &VRF{Name: "blue"},
+					Tenant:  &Tenant{Slug: "team-a"},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			upsert("192.168.0.1")
+			now = now.Add(test.elapsed)
+			upsert("192.168.0.2")
+
+			if lookups != test.expectedLookups {
+				t.Errorf("want %d lookups, got %d", test.expectedLookups, lookups)
+			}
+			for _, fields := range written {
+				vrf, _ := fields["vrf"].(map[string]interface{})
+				if vrf["id"] != float64(9) {
+					t.Errorf("want VRF written with ID 9, got %v", fields["vrf"])
+				}
+				tenant, _ := fields["tenant"].(map[string]interface{})
+				if tenant["id"] != float64(7) {
+					t.Errorf("want tenant written with ID 7, got %v", fields["tenant"])
+				}
+			}
+		})
+	}
+}
+
+func TestLookupCacheForgetsIDsAfterFailedWrite(t *testing.T) {
+	var lookups int
+	var failWrites bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/ipam/vrfs/":
+			lookups++
+			w.Write([]byte(`{"count": 1, "results": [{"id": 9, "name": "blue"}]}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"count": 0, "results": []}`))
+		case failWrites:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"vrf": ["Related object not found"]}`))
+		default:
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upsert := func() error {
+		_, _, err := c.UpsertIP(context.Background(), &IPAddress{
+			UID:     UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4"),
+			Address: IP(netip.MustParseAddr("192.168.0.1")),
+			VRF:     &VRF{Name: "blue"},
+		})
+		return err
+	}
+
+	// the VRF was re-created, and the cached ID is no longer valid
+	failWrites = true
+	if err := upsert(); err == nil {
+		t.Fatal("want an error, got nil")
+	}
+	failWrites = false
+	if err := upsert(); err != nil {
+		t.Fatal(err)
+	}
+	if lookups != 2 {
+		t.Errorf("want the VRF to be looked up again after a failed write, got %d lookups", lookups)
+	}
+}
+
+func TestWithLookupCacheTTLValidation(t *testing.T) {
+	if _, err := NewClient("http://netbox.example.com", "foo", WithLookupCacheTTL(-time.Second)); err == nil {
+		t.Error("want an error, got nil")
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"net/http"
+)
+
+// Middleware wraps the transport that the client sends requests to NetBox
+// with, e.g. to record custom metrics, add headers, or inject failures in
+// tests. It sees every attempt of a request, including retries, after the
+// request was authenticated, and the response before it is decompressed.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to use a function as an http.RoundTripper,
+// e.g. in a Middleware.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// WithMiddleware adds middlewares that wrap the transport of the client.
+// The first middleware added is the outermost one, which sees requests
+// first and responses last.
+func WithMiddleware(middlewares ...Middleware) ClientOption {
+	return func(c *client) error {
+		c.middlewares = append(c.middlewares, middlewares...)
+		return nil
+	}
+}
+
+// wrapTransport wraps the transport of the client in its middlewares.
+func (c *client) wrapTransport() {
+	if len(c.middlewares) == 0 {
+		return
+	}
+	transport := c.httpClient.HTTPClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		transport = c.middlewares[i](transport)
+	}
+	c.httpClient.HTTPClient.Transport = transport
+}
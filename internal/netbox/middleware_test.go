@@ -0,0 +1,81 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithMiddleware(t *testing.T) {
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Test")
+		w.Write([]byte(`{"count": 0, "results": []}`))
+	}))
+	defer server.Close()
+
+	var calls []string
+	middleware := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, name+" request")
+				req.Header.Set("X-Test", req.Header.Get("X-Test")+name)
+				res, err := next.RoundTrip(req)
+				calls = append(calls, fmt.Sprintf("%s response %d", name, res.StatusCode))
+				return res, err
+			})
+		}
+	}
+
+	c, err := NewClient(server.URL, "foo", WithMiddleware(middleware("a")), WithMiddleware(middleware("b")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetTag(context.Background(), "foo"); err != nil {
+		t.Fatalf("getting tag: %s", err)
+	}
+
+	expectedCalls := []string{"a request", "b request", "b response 200", "a response 200"}
+	if fmt.Sprint(calls) != fmt.Sprint(expectedCalls) {
+		t.Errorf("want calls %v, got %v", expectedCalls, calls)
+	}
+	if header != "ab" {
+		t.Errorf("want header %q, got %q", "ab", header)
+	}
+}
+
+func TestMiddlewareError(t *testing.T) {
+	injected := errors.New("injected failure")
+	c, err := NewClient("http://netbox.example.com", "foo", WithMiddleware(func(http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, injected
+		})
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// creating a tag is not retried
+	if _, err := c.CreateTag(context.Background(), "foo"); !errors.Is(err, injected) {
+		t.Errorf("want injected error, got %v", err)
+	}
+}
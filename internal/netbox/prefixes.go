@@ -0,0 +1,159 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+)
+
+// PrefixAllocator is implemented by clients that can allocate
+// available child prefixes from prefixes in the IPAM system.
+type PrefixAllocator interface {
+	// AllocatePrefix creates a prefix with the given length and description
+	// in the first available space of parent, in the global table, and returns
+	// it. The description identifies the prefix: if a prefix with it exists
+	// within parent already, e.g. because it was allocated before, it is
+	// returned instead.
+	AllocatePrefix(ctx context.Context, parent netip.Prefix, length int, description string) (netip.Prefix, error)
+	// DeletePrefix deletes the prefixes with the given description
+	// within parent, if any.
+	DeletePrefix(ctx context.Context, parent netip.Prefix, description string) error
+}
+
+// childPrefix is a prefix in NetBox allocated from a parent prefix.
+type childPrefix struct {
+	ID          int64        `json:"id,omitempty"`
+	Prefix      netip.Prefix `json:"prefix"`
+	Description string       `json:"description"`
+}
+
+// AllocatePrefix allocates the prefix with the
+// available-prefixes endpoint of parent in NetBox.
+func (c *client) AllocatePrefix(ctx context.Context, parent netip.Prefix, length int, description string) (netip.Prefix, error) {
+	parent = parent.Masked()
+	if length <= parent.Bits() || length > parent.Addr().BitLen() {
+		return netip.Prefix{}, fmt.Errorf("invalid prefix length %d: must be longer than /%d of %s", length, parent.Bits(), parent)
+	}
+
+	// the prefix may have been allocated before, but not
+	// recorded by the caller, e.g. because that failed
+	existing, err := c.childPrefixes(ctx, parent, description)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if len(existing) > 0 {
+		return existing[0].Prefix, nil
+	}
+
+	parentID, err := c.prefixID(ctx, parent, nil)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	body := map[string]interface{}{
+		"prefix_length": length,
+		"description":   description,
+	}
+	record := AuditRecord{
+		Operation: AuditOperationCreate,
+		Object:    AuditObjectPrefix,
+		Name:      description,
+	}
+	url := fmt.Sprintf("%s/ipam/prefixes/%d/available-prefixes/", c.baseURL, parentID)
+	data, err := c.executeRequest(ctx, url, http.MethodPost, body)
+	if isConflict(err) {
+		err = fmt.Errorf("%w: no /%d available in %s", ErrPrefixFull, length, parent)
+		c.recordFailedAudit(record, err)
+		return netip.Prefix{}, err
+	} else if err != nil {
+		c.recordFailedAudit(record, err)
+		return netip.Prefix{}, fmt.Errorf("executing request: %w", err)
+	}
+
+	var allocated childPrefix
+	if err := json.Unmarshal(data, &allocated); err != nil {
+		return netip.Prefix{}, fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	record.ID = allocated.ID
+	record.Address = allocated.Prefix.String()
+	c.recordAudit(record)
+
+	return allocated.Prefix, nil
+}
+
+// DeletePrefix deletes the prefixes with the description within parent from NetBox.
+func (c *client) DeletePrefix(ctx context.Context, parent netip.Prefix, description string) error {
+	prefixes, err := c.childPrefixes(ctx, parent.Masked(), description)
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range prefixes {
+		record := AuditRecord{
+			Operation: AuditOperationDelete,
+			Object:    AuditObjectPrefix,
+			ID:        prefix.ID,
+			Name:      description,
+			Address:   prefix.Prefix.String(),
+		}
+		url := fmt.Sprintf("%s/ipam/prefixes/%d/", c.baseURL, prefix.ID)
+		if _, err := c.executeRequest(ctx, url, http.MethodDelete, nil); err != nil {
+			c.recordFailedAudit(record, err)
+			return fmt.Errorf("executing request: %w", err)
+		}
+
+		c.recordAudit(record)
+	}
+
+	return nil
+}
+
+// childPrefixes returns the prefixes with the given
+// description within parent in the global table.
+func (c *client) childPrefixes(ctx context.Context, parent netip.Prefix, description string) ([]childPrefix, error) {
+	query := url.Values{}
+	query.Set("within", parent.String())
+	query.Set("description", description)
+	query.Set("vrf_id", "null")
+	url := fmt.Sprintf("%s/ipam/prefixes/?%s", c.baseURL, query.Encode())
+	data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+	if err != nil {
+		return nil, fmt.Errorf("executing request: %w", err)
+	}
+
+	var list struct {
+		Results []childPrefix `json:"results"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	var prefixes []childPrefix
+	for _, prefix := range list.Results {
+		// older NetBox versions may not filter by description
+		if prefix.Description == description {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestAllocatePrefix(t *testing.T) {
+	const description = "namespace foo"
+
+	tests := []struct {
+		name           string
+		existing       string
+		parents        string
+		full           bool
+		expectedErr    error
+		expectedPrefix netip.Prefix
+		expectedPost   bool
+	}{{
+		name:           "allocated",
+		existing:       `[]`,
+		parents:        `[{"id": 3}]`,
+		expectedPrefix: netip.MustParsePrefix("10.0.4.0/24"),
+		expectedPost:   true,
+	}, {
+		name:           "allocated before",
+		existing:       fmt.Sprintf(`[{"id": 7, "prefix": "10.0.1.0/24", "description": %q}]`, description),
+		expectedPrefix: netip.MustParsePrefix("10.0.1.0/24"),
+	}, {
+		name:           "other prefix with a different description",
+		existing:       `[{"id": 7, "prefix": "10.0.1.0/24", "description": "namespace bar"}]`,
+		parents:        `[{"id": 3}]`,
+		expectedPrefix: netip.MustParsePrefix("10.0.4.0/24"),
+		expectedPost:   true,
+	}, {
+		name:        "parent not found",
+		existing:    `[]`,
+		parents:     `[]`,
+		expectedErr: ErrPrefixNotFound,
+	}, {
+		name:         "parent full",
+		existing:     `[]`,
+		parents:      `[{"id": 3}]`,
+		full:         true,
+		expectedErr:  ErrPrefixFull,
+		expectedPost: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var written map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Query().Get("within") != "":
+					if r.URL.Query().Get("within") != "10.0.0.0/16" || r.URL.Query().Get("description") != description {
+						t.Errorf("unexpected query of child prefixes %s", r.URL.RawQuery)
+					}
+					fmt.Fprintf(w, `{"results": %s}`, test.existing)
+				case r.Method == http.MethodGet:
+					fmt.Fprintf(w, `{"results": %s}`, test.parents)
+				case r.Method == http.MethodPost && r.URL.Path == "/ipam/prefixes/3/available-prefixes/":
+					json.NewDecoder(r.Body).Decode(&written)
+					if test.full {
+						w.WriteHeader(http.StatusConflict)
+						w.Write([]byte(`{"detail": "Insufficient space is available to accommodate the requested prefix size(s)"}`))
+						return
+					}
+					fmt.Fprintf(w, `{"id": 12, "prefix": "10.0.4.0/24", "description": %q}`, description)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL)
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prefix, err := c.(PrefixAllocator).AllocatePrefix(context.Background(), netip.MustParsePrefix("10.0.0.0/16"), 24, description)
+			if test.expectedPost != (written != nil) {
+				t.Errorf("want prefix requested: %t, got %v", test.expectedPost, written)
+			}
+			if test.expectedErr != nil {
+				if !errors.Is(err, test.expectedErr) {
+					t.Errorf("want error %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("allocating prefix: %s", err)
+			}
+
+			if prefix != test.expectedPrefix {
+				t.Errorf("want %s, got %s", test.expectedPrefix, prefix)
+			}
+			if written != nil && (written["prefix_length"] != float64(24) || written["description"] != description) {
+				t.Errorf("want /24 with description %q requested, got %v", description, written)
+			}
+		})
+	}
+}
+
+func TestAllocatePrefixInvalidLength(t *testing.T) {
+	c, err := NewClient("http://netbox.example.com", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, length := range []int{16, 33} {
+		if _, err := c.(PrefixAllocator).AllocatePrefix(context.Background(), netip.MustParsePrefix("10.0.0.0/16"), length, "foo"); err == nil {
+			t.Errorf("want error for /%d, got nil", length)
+		}
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"results": [{"id": 7, "prefix": "10.0.1.0/24", "description": "namespace foo"}, {"id": 8, "prefix": "10.0.2.0/24", "description": "namespace foobar"}]}`))
+		case http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.(PrefixAllocator).DeletePrefix(context.Background(), netip.MustParsePrefix("10.0.0.0/16"), "namespace foo"); err != nil {
+		t.Fatalf("deleting prefix: %s", err)
+	}
+	if expected := []string{"/ipam/prefixes/7/"}; fmt.Sprint(deleted) != fmt.Sprint(expected) {
+		t.Errorf("want %v deleted, got %v", expected, deleted)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// DefaultSensitiveFields are the names of headers, JSON fields and query
+// parameters whose values are always redacted from logs and errors.
+var DefaultSensitiveFields = []string{
+	"authorization",
+	"token",
+	"password",
+	"secret",
+	"client_secret",
+	"access_token",
+	"refresh_token",
+}
+
+// secretHolder is implemented by auth providers that hold secrets
+// which must never appear in logs or errors.
+type secretHolder interface {
+	secrets() []string
+}
+
+// redactor removes secrets from strings that end up in logs and errors:
+// the values of sensitive fields (like "Authorization: ...", "token=..."
+// or "password": "..."), and any occurrence of a secret held by the auth provider.
+type redactor struct {
+	fields *regexp.Regexp
+	auth   AuthProvider
+}
+
+func newRedactor(fields []string) *redactor {
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" {
+			names = append(names, regexp.QuoteMeta(f))
+		}
+	}
+
+	r := &redactor{}
+	if len(names) > 0 {
+		// matches e.g. `Authorization: Token abc`, `"password": "abc"` and `token=abc`;
+		// a header value may contain a scheme, like "Bearer", followed by a space
+		r.fields = regexp.MustCompile(
+			`(?i)("?\b(?:` + strings.Join(names, "|") + `)"?\s*[:=]\s*"?)` +
+				`((?:(?:Token|Bearer|Basic) )?[^"&\s,;}]+)`,
+		)
+	}
+	return r
+}
+
+func (r *redactor) redact(s string) string {
+	if r == nil {
+		return s
+	}
+	if r.fields != nil {
+		s = r.fields.ReplaceAllString(s, "${1}"+redacted)
+	}
+
+	if h, ok := r.auth.(secretHolder); ok {
+		for _, secret := range h.secrets() {
+			if secret != "" {
+				s = strings.ReplaceAll(s, secret, redacted)
+			}
+		}
+	}
+
+	return s
+}
+
+// redactValue returns a loggable version of v with all secrets removed.
+func (r *redactor) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return r.redact(v)
+	case *url.URL:
+		if v == nil {
+			return v
+		}
+		return r.redact(v.Redacted())
+	case error:
+		return r.redact(v.Error())
+	case fmt.Stringer:
+		return r.redact(v.String())
+	default:
+		return v
+	}
+}
+
+// redactError returns err with all secrets removed from its message.
+// The original error is not unwrapped, as its message still contains the
+// secrets, but errors.Is keeps working for sentinel errors, and errors.As
+// for status errors, whose messages are redacted as well.
+func (r *redactor) redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if redactedMsg := r.redact(msg); redactedMsg != msg {
+		return &redactedError{msg: redactedMsg, err: err, redact: r.redact}
+	}
+	return err
+}
+
+type redactedError struct {
+	msg    string
+	err    error
+	redact func(string) string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+// Is reports whether the original error matches target,
+// without exposing the original error.
+func (e *redactedError) Is(target error) bool {
+	return errors.Is(e.err, target)
+}
+
+// As sets target to a copy of the status error of the original
+// error, if there is one, with its message redacted.
+func (e *redactedError) As(target interface{}) bool {
+	se, ok := target.(**statusError)
+	if !ok {
+		return false
+	}
+	var original *statusError
+	if !errors.As(e.err, &original) {
+		return false
+	}
+	*se = &statusError{code: original.code, msg: e.redact(original.msg)}
+	return true
+}
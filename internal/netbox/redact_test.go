@@ -0,0 +1,118 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   []string
+		input    string
+		expected string
+	}{{
+		name:     "authorization header",
+		fields:   DefaultSensitiveFields,
+		input:    "Authorization: Token abc123",
+		expected: "Authorization: REDACTED",
+	}, {
+		name:     "JSON field",
+		fields:   DefaultSensitiveFields,
+		input:    `{"username": "foo", "password": "bar"}`,
+		expected: `{"username": "foo", "password": "REDACTED"}`,
+	}, {
+		name:     "query parameter",
+		fields:   DefaultSensitiveFields,
+		input:    "https://sso.example.com/token?client_secret=bar&scope=netbox",
+		expected: "https://sso.example.com/token?client_secret=REDACTED&scope=netbox",
+	}, {
+		name:     "configured field",
+		fields:   append(DefaultSensitiveFields, "X-Api-Key"),
+		input:    "x-api-key: abc123",
+		expected: "x-api-key: REDACTED",
+	}, {
+		name:     "secret held by the auth provider",
+		input:    "invalid credentials abc123",
+		expected: "invalid credentials REDACTED",
+	}, {
+		name:     "nothing to redact",
+		fields:   DefaultSensitiveFields,
+		input:    `400 Bad Request: {"address": ["Duplicate IP address found"]}`,
+		expected: `400 Bad Request: {"address": ["Duplicate IP address found"]}`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRedactor(test.fields)
+			r.auth = NewTokenAuth("abc123")
+
+			if got := r.redact(test.input); got != test.expected {
+				t.Errorf("want %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestExecuteRequestRedactsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"detail": "Invalid token", "token": "` + strings.TrimPrefix(r.Header.Get("Authorization"), "Token ") + `"}`))
+	}))
+	defer server.Close()
+
+	nc, err := NewClient(server.URL, "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := nc.(*client)
+
+	_, err = c.executeRequest(context.Background(), server.URL, http.MethodPost, nil)
+	if err == nil {
+		t.Fatal("want an error, got nil")
+	}
+	if strings.Contains(err.Error(), "abc123") {
+		t.Errorf("want the token to be redacted, got %q", err)
+	}
+	for unwrapped := errors.Unwrap(err); unwrapped != nil; unwrapped = errors.Unwrap(unwrapped) {
+		if strings.Contains(unwrapped.Error(), "abc123") {
+			t.Errorf("want the token to be redacted from wrapped errors, got %q", unwrapped)
+		}
+	}
+	var se *statusError
+	if !errors.As(err, &se) {
+		t.Errorf("want the redacted error to have a status error, got %v", err)
+	} else if strings.Contains(se.Error(), "abc123") {
+		t.Errorf("want the token to be redacted from the status error, got %q", se)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.executeRequest(ctx, server.URL+"?token=abc123", http.MethodGet, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want the redacted error to wrap %v, got %v", context.Canceled, err)
+	}
+	if strings.Contains(err.Error(), "abc123") {
+		t.Errorf("want the token to be redacted, got %q", err)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Reasons of rejected requests that are not about a known field.
+const (
+	// RejectionReasonOther is the reason of requests
+	// rejected because of fields that are not known.
+	RejectionReasonOther = "other"
+	// RejectionReasonUnknown is the reason of requests
+	// rejected with a response that could not be parsed.
+	RejectionReasonUnknown = "unknown"
+)
+
+// rejectionFields are the fields that NetBox may reject, which are used as
+// the reasons of rejections. Other fields are not, to bound the cardinality
+// of the rejection metric.
+var rejectionFields = map[string]bool{
+	"address":              true,
+	"dns_name":             true,
+	"description":          true,
+	"tags":                 true,
+	"tenant":               true,
+	"vrf":                  true,
+	"status":               true,
+	"custom_fields":        true,
+	"assigned_object_type": true,
+	"assigned_object_id":   true,
+	"non_field_errors":     true,
+}
+
+// RejectionReason returns the reason why NetBox rejected a request as invalid,
+// e.g. a DNS name with invalid characters, or a description that is too long,
+// and false if err is not such a rejection. The reason is the name of the
+// first invalid field, or one of RejectionReasonOther and RejectionReasonUnknown.
+func RejectionReason(err error) (string, bool) {
+	var se *statusError
+	if !errors.As(err, &se) || se.code != http.StatusBadRequest {
+		return "", false
+	}
+	return rejectionReason(se.msg), true
+}
+
+// rejectionReason returns the reason of a rejection from the message of its
+// status error, which has the body of the response after the status: NetBox
+// responds with the errors of each invalid field, e.g. {"dns_name": ["..."]}.
+func rejectionReason(msg string) string {
+	_, body, found := strings.Cut(msg, ": ")
+	if !found {
+		return RejectionReasonUnknown
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &fields); err != nil || len(fields) == 0 {
+		return RejectionReasonUnknown
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if rejectionFields[name] {
+			return name
+		}
+	}
+	return RejectionReasonOther
+}
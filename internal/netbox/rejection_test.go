@@ -0,0 +1,102 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestRejectionReason(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		response       string
+		expectedReason string
+		expectedOK     bool
+	}{{
+		name:           "invalid DNS name",
+		status:         http.StatusBadRequest,
+		response:       `{"dns_name": ["Only alphanumeric characters, hyphens, periods, and underscores are allowed in DNS names"]}`,
+		expectedReason: "dns_name",
+		expectedOK:     true,
+	}, {
+		name:           "several invalid fields",
+		status:         http.StatusBadRequest,
+		response:       `{"foo": ["invalid"], "description": ["Ensure this field has no more than 200 characters."]}`,
+		expectedReason: "description",
+		expectedOK:     true,
+	}, {
+		name:           "unknown field",
+		status:         http.StatusBadRequest,
+		response:       `{"foo": ["invalid"]}`,
+		expectedReason: RejectionReasonOther,
+		expectedOK:     true,
+	}, {
+		name:           "unparsable response",
+		status:         http.StatusBadRequest,
+		response:       `<html>Bad Request</html>`,
+		expectedReason: RejectionReasonUnknown,
+		expectedOK:     true,
+	}, {
+		name:     "not a rejection",
+		status:   http.StatusForbidden,
+		response: `{"detail": "You do not have permission to perform this action."}`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					fmt.Fprint(w, `{"count": 0, "results": []}`)
+					return
+				}
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.response)
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = c.UpsertIP(context.Background(), &IPAddress{
+				UID:     "5e0b4a4c-3f0e-4f3b-9a3c-0d1c6b0c8e2a",
+				Address: IP(netip.MustParseAddr("192.168.0.1")),
+				DNSName: "foo bar",
+			})
+			if err == nil {
+				t.Fatal("expected error but got none")
+			}
+
+			reason, ok := RejectionReason(err)
+			if ok != test.expectedOK || reason != test.expectedReason {
+				t.Errorf("want reason %q (%t), got %q (%t)", test.expectedReason, test.expectedOK, reason, ok)
+			}
+		})
+	}
+
+	if _, ok := RejectionReason(errors.New("400 Bad Request")); ok {
+		t.Error("expected errors other than status errors not to be rejections")
+	}
+}
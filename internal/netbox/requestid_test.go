@@ -0,0 +1,63 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		expected string
+	}{{
+		name:     "with request ID",
+		ctx:      WithRequestID(context.Background(), "abc-123"),
+		expected: "abc-123",
+	}, {
+		name:     "without request ID",
+		ctx:      context.Background(),
+		expected: "",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get(RequestIDHeader)
+				fmt.Fprint(w, `{"netbox-version": "3.4.0"}`)
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.(Diagnoser).Version(test.ctx); err != nil {
+				t.Fatalf("getting version: %s", err)
+			}
+			if got != test.expected {
+				t.Errorf("expected request ID %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
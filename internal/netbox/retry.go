@@ -0,0 +1,103 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/metrics"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+)
+
+// DefaultRequestTimeout is how long an attempt of a request to NetBox
+// may take, unless set with WithRequestTimeout.
+const DefaultRequestTimeout = 30 * time.Second
+
+// WithRequestTimeout sets how long an attempt of a request to NetBox may
+// take, including reading the response, so that a hung connection does
+// not stall the caller. Requests that may be retried are retried after
+// an attempt times out. A timeout of 0 disables it, leaving requests
+// only to be canceled with their context.
+func WithRequestTimeout(timeout time.Duration) ClientOption {
+	return func(c *client) error {
+		if timeout < 0 {
+			return fmt.Errorf("request timeout must not be negative, got %s", timeout)
+		}
+		c.httpClient.HTTPClient.Timeout = timeout
+		return nil
+	}
+}
+
+// maxRetryAfter limits how long a request waits before it is retried
+// when NetBox asks for it with a Retry-After header, so that a bogus
+// header does not stall the controller.
+const maxRetryAfter = 5 * time.Minute
+
+// retryAfterBackoff returns how long to wait before retrying a request: as
+// long as NetBox, or a proxy in front of it, asks for in the Retry-After
+// header of a 429 or 503 response, either in seconds or as a date, and
+// the exponential backoff of retryablehttp.DefaultBackoff otherwise.
+func retryAfterBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	if wait, ok := retryAfter(resp, time.Now()); ok {
+		metrics.AddThrottledSeconds(wait.Seconds())
+		return wait
+	}
+	// a Retry-After header that could not be parsed is ignored
+	return retryablehttp.DefaultBackoff(min, max, attemptNum, nil)
+}
+
+// retryAfter returns the time until the request may be retried according
+// to the Retry-After header of resp, if it is a 429 or 503 response,
+// and false if there is no valid header.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+
+	var wait time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		if seconds > int64(maxRetryAfter/time.Second) {
+			return maxRetryAfter, true
+		}
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = date.Sub(now)
+		if wait < 0 {
+			// the date has passed already, e.g. because of clock skew
+			wait = 0
+		}
+	} else {
+		return 0, false
+	}
+
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+	return wait, true
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2022, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		status       int
+		retryAfter   string
+		expectedWait time.Duration
+		expectedOK   bool
+	}{{
+		name:         "seconds",
+		status:       http.StatusTooManyRequests,
+		retryAfter:   "120",
+		expectedWait: 2 * time.Minute,
+		expectedOK:   true,
+	}, {
+		name:         "date",
+		status:       http.StatusServiceUnavailable,
+		retryAfter:   "Tue, 01 Mar 2022 12:00:30 GMT",
+		expectedWait: 30 * time.Second,
+		expectedOK:   true,
+	}, {
+		name:       "past date",
+		status:     http.StatusServiceUnavailable,
+		retryAfter: "Tue, 01 Mar 2022 11:00:00 GMT",
+		expectedOK: true,
+	}, {
+		name:         "too long",
+		status:       http.StatusTooManyRequests,
+		retryAfter:   "86400",
+		expectedWait: maxRetryAfter,
+		expectedOK:   true,
+	}, {
+		name:       "invalid",
+		status:     http.StatusTooManyRequests,
+		retryAfter: "soon",
+	}, {
+		name:       "negative",
+		status:     http.StatusTooManyRequests,
+		retryAfter: "-1",
+	}, {
+		name:   "no header",
+		status: http.StatusTooManyRequests,
+	}, {
+		name:       "other status",
+		status:     http.StatusInternalServerError,
+		retryAfter: "120",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: test.status, Header: http.Header{}}
+			if test.retryAfter != "" {
+				resp.Header.Set("Retry-After", test.retryAfter)
+			}
+
+			wait, ok := retryAfter(resp, now)
+			if ok != test.expectedOK || wait != test.expectedWait {
+				t.Errorf("want %s, %t, got %s, %t", test.expectedWait, test.expectedOK, wait, ok)
+			}
+		})
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// hangs until the test is over
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	c, err := NewClient(server.URL, "foo", WithRequestTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	// creating a tag is not retried
+	if _, err := c.CreateTag(context.Background(), "foo"); err == nil {
+		t.Error("want error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("want request to time out, took %s", elapsed)
+	}
+}
+
+func TestWithRequestTimeoutValidation(t *testing.T) {
+	if _, err := NewClient("http://netbox.example.com", "foo", WithRequestTimeout(-time.Second)); err == nil {
+		t.Error("want error, got nil")
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// NodePortService is a port of a Kubernetes service that is exposed
+// on the addresses of nodes, e.g. the node port of a NodePort service.
+type NodePortService struct {
+	// Name identifies the service in NetBox, and must be unique.
+	Name string
+	// Protocol is one of "tcp", "udp" or "sctp".
+	Protocol    string
+	Ports       []int32
+	Description string
+	Tags        []Tag
+	// Addresses are the addresses of the nodes that expose the port.
+	Addresses []netip.Addr
+}
+
+// ServiceClient is implemented by clients that can
+// publish node ports as services in the IPAM system.
+type ServiceClient interface {
+	// UpsertNodePortService creates or updates a NetBox service with the
+	// name of svc for every device or virtual machine that the IPs with
+	// the addresses of svc are assigned to, and deletes the services with
+	// that name on other devices or virtual machines. Addresses that are
+	// not in NetBox, or not assigned to an interface, are ignored.
+	UpsertNodePortService(ctx context.Context, svc *NodePortService) error
+	// DeleteNodePortService deletes the NetBox services with the given name.
+	DeleteNodePortService(ctx context.Context, name string) error
+}
+
+// objectID is the ID of a related NetBox object, which is written as
+// the ID, but read either as the ID or as an object with the ID.
+type objectID int64
+
+// UnmarshalJSON implements the json.Unmarshaler interface for objectID.
+func (id *objectID) UnmarshalJSON(b []byte) error {
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*id = objectID(n)
+		return nil
+	}
+
+	var obj struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return fmt.Errorf("unmarshaling object ID: %w", err)
+	}
+	*id = objectID(obj.ID)
+	return nil
+}
+
+// service represents a NetBox service.
+type service struct {
+	ID             int64         `json:"id,omitempty"`
+	Device         *objectID     `json:"device,omitempty"`
+	VirtualMachine *objectID     `json:"virtual_machine,omitempty"`
+	Name           string        `json:"name"`
+	Protocol       LabeledString `json:"protocol"`
+	Ports          []int32       `json:"ports"`
+	IPAddresses    []objectID    `json:"ipaddresses"`
+	Description    string        `json:"description"`
+	Tags           []Tag         `json:"tags"`
+}
+
+// parent identifies the device or virtual machine that a service
+// or the interface of an IP belongs to.
+type parent struct {
+	device bool
+	id     objectID
+}
+
+func (s *service) parent() parent {
+	if s.Device != nil {
+		return parent{device: true, id: *s.Device}
+	}
+	if s.VirtualMachine != nil {
+		return parent{id: *s.VirtualMachine}
+	}
+	return parent{}
+}
+
+// changed reports whether the fields of s2 that are managed
+// by the controller differ from the ones of s.
+func (s *service) changed(s2 *service) bool {
+	if s.Name != s2.Name || s.Protocol != s2.Protocol || s.Description != s2.Description {
+		return true
+	}
+	if !equalSorted(s.Ports, s2.Ports) || !equalSorted(s.IPAddresses, s2.IPAddresses) {
+		return true
+	}
+	names := func(tags []Tag) []string {
+		var names []string
+		for _, tag := range tags {
+			names = append(names, tag.Name)
+		}
+		return names
+	}
+	return !equalSorted(names(s.Tags), names(s2.Tags))
+}
+
+func equalSorted[T int32 | objectID | string](a, b []T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]T(nil), a...)
+	b = append([]T(nil), b...)
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// UpsertNodePortService creates or updates the NetBox services of svc.
+func (c *client) UpsertNodePortService(ctx context.Context, svc *NodePortService) error {
+	ipsByParent := make(map[parent][]objectID)
+	for _, addr := range svc.Addresses {
+		if err := c.addNodeIPs(ctx, addr, ipsByParent); err != nil {
+			return fmt.Errorf("looking up IPs of %s: %w", addr, err)
+		}
+	}
+
+	existing, err := c.getServices(ctx, svc.Name)
+	if err != nil {
+		return err
+	}
+
+	var errs multierror.Error
+	for p, ips := range ipsByParent {
+		desired := &service{
+			Name:        svc.Name,
+			Protocol:    LabeledString(svc.Protocol),
+			Ports:       svc.Ports,
+			IPAddresses: ips,
+			Description: svc.Description,
+			Tags:        svc.Tags,
+		}
+		id := p.id
+		if p.device {
+			desired.Device = &id
+		} else {
+			desired.VirtualMachine = &id
+		}
+
+		var current *service
+		for i := range existing {
+			if existing[i].parent() == p {
+				current = &existing[i]
+				break
+			}
+		}
+		if err := c.writeService(ctx, current, desired); err != nil {
+			multierror.Append(&errs, err)
+		}
+	}
+
+	for i := range existing {
+		if _, ok := ipsByParent[existing[i].parent()]; !ok {
+			if err := c.deleteService(ctx, &existing[i]); err != nil {
+				multierror.Append(&errs, err)
+			}
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// DeleteNodePortService deletes the NetBox services with the given name.
+func (c *client) DeleteNodePortService(ctx context.Context, name string) error {
+	existing, err := c.getServices(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	var errs multierror.Error
+	for i := range existing {
+		if err := c.deleteService(ctx, &existing[i]); err != nil {
+			multierror.Append(&errs, err)
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// addNodeIPs adds the IDs of the NetBox IPs with the given address
+// that are assigned to an interface to the IDs of their parents.
+func (c *client) addNodeIPs(ctx context.Context, addr netip.Addr, ipsByParent map[parent][]objectID) error {
+	url := fmt.Sprintf("%s/ipam/ip-addresses/?address=%s", c.baseURL, url.QueryEscape(addr.String()))
+	data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+	if err != nil {
+		return fmt.Errorf("executing request: %w", err)
+	}
+
+	var ipList struct {
+		Results []struct {
+			ID             objectID `json:"id"`
+			AssignedObject *struct {
+				Device         *objectID `json:"device"`
+				VirtualMachine *objectID `json:"virtual_machine"`
+			} `json:"assigned_object"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(data, &ipList); err != nil {
+		return fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	for _, ip := range ipList.Results {
+		if ip.AssignedObject == nil {
+			continue
+		}
+		s := service{Device: ip.AssignedObject.Device, VirtualMachine: ip.AssignedObject.VirtualMachine}
+		if p := s.parent(); p.id != 0 {
+			ipsByParent[p] = append(ipsByParent[p], ip.ID)
+		}
+	}
+	return nil
+}
+
+func (c *client) getServices(ctx context.Context, name string) ([]service, error) {
+	url := fmt.Sprintf("%s/ipam/services/?name=%s", c.baseURL, url.QueryEscape(name))
+	data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+	if err != nil {
+		return nil, fmt.Errorf("looking up services: %w", err)
+	}
+
+	var serviceList struct {
+		Results []service `json:"results"`
+	}
+	if err := json.Unmarshal(data, &serviceList); err != nil {
+		return nil, fmt.Errorf("unmarshaling response: %w", err)
+	}
+	return serviceList.Results, nil
+}
+
+// writeService creates the desired service if current is nil,
+// or updates current if it differs from the desired service.
+func (c *client) writeService(ctx context.Context, current, desired *service) error {
+	url := fmt.Sprintf("%s/ipam/services/", c.baseURL)
+	method := http.MethodPost
+	operation := AuditOperationCreate
+	if current != nil {
+		if !current.changed(desired) {
+			return nil
+		}
+		url = fmt.Sprintf("%s%d/", url, current.ID)
+		method = http.MethodPut
+		operation = AuditOperationUpdate
+	}
+
+	record := AuditRecord{
+		Operation: operation,
+		Object:    AuditObjectService,
+		Name:      desired.Name,
+	}
+	if current != nil {
+		record.ID = current.ID
+	}
+	data, err := c.executeRequest(ctx, url, method, desired)
+	if err != nil {
+		c.recordFailedAudit(record, err)
+		return fmt.Errorf("writing service: %w", err)
+	}
+	var written service
+	if err := json.Unmarshal(data, &written); err != nil {
+		return fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	record.ID = written.ID
+	c.recordAudit(record)
+	return nil
+}
+
+func (c *client) deleteService(ctx context.Context, s *service) error {
+	record := AuditRecord{
+		Operation: AuditOperationDelete,
+		Object:    AuditObjectService,
+		ID:        s.ID,
+		Name:      s.Name,
+	}
+	url := fmt.Sprintf("%s/ipam/services/%d/", c.baseURL, s.ID)
+	if _, err := c.executeRequest(ctx, url, http.MethodDelete, nil); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		c.recordFailedAudit(record, err)
+		return fmt.Errorf("deleting service: %w", err)
+	}
+
+	c.recordAudit(record)
+	return nil
+}
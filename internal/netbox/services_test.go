@@ -0,0 +1,133 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUpsertNodePortService(t *testing.T) {
+	// IPs by address: 10.0.0.1 and 10.0.0.2 are on device 1,
+	// 10.0.0.3 is on virtual machine 2, and 10.0.0.4 is unassigned
+	ips := map[string]string{
+		"10.0.0.1": `{"id": 11, "assigned_object": {"id": 100, "device": {"id": 1}}}`,
+		"10.0.0.2": `{"id": 12, "assigned_object": {"id": 101, "device": {"id": 1}}}`,
+		"10.0.0.3": `{"id": 13, "assigned_object": {"id": 200, "virtual_machine": {"id": 2}}}`,
+		"10.0.0.4": `{"id": 14, "assigned_object": null}`,
+	}
+
+	tests := []struct {
+		name             string
+		existingServices string
+		addresses        []string
+		expectedRequests []string
+	}{{
+		name:             "new services",
+		existingServices: `[]`,
+		addresses:        []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"},
+		expectedRequests: []string{
+			`POST /ipam/services/ device=1 ips=[11 12]`,
+			`POST /ipam/services/ device=0 ips=[13]`,
+		},
+	}, {
+		name:             "unchanged service",
+		existingServices: `[{"id": 5, "device": {"id": 1}, "name": "foo", "protocol": {"value": "tcp", "label": "TCP"}, "ports": [30080], "ipaddresses": [{"id": 11}], "description": "bar", "tags": []}]`,
+		addresses:        []string{"10.0.0.1"},
+	}, {
+		name:             "changed service",
+		existingServices: `[{"id": 5, "device": {"id": 1}, "name": "foo", "protocol": {"value": "tcp", "label": "TCP"}, "ports": [30081], "ipaddresses": [{"id": 11}], "description": "bar", "tags": []}]`,
+		addresses:        []string{"10.0.0.1"},
+		expectedRequests: []string{`PUT /ipam/services/5/ device=1 ips=[11]`},
+	}, {
+		name:             "service of a node that is gone",
+		existingServices: `[{"id": 6, "virtual_machine": {"id": 2}, "name": "foo", "protocol": {"value": "tcp", "label": "TCP"}, "ports": [30080], "ipaddresses": [{"id": 13}]}]`,
+		addresses:        []string{"10.0.0.1"},
+		expectedRequests: []string{
+			`POST /ipam/services/ device=1 ips=[11]`,
+			`DELETE /ipam/services/6/`,
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/ipam/ip-addresses/":
+					if ip, ok := ips[r.URL.Query().Get("address")]; ok {
+						fmt.Fprintf(w, `{"count": 1, "results": [%s]}`, ip)
+						return
+					}
+					w.Write([]byte(`{"count": 0, "results": []}`))
+				case r.Method == http.MethodGet && r.URL.Path == "/ipam/services/":
+					fmt.Fprintf(w, `{"results": %s}`, test.existingServices)
+				case r.Method == http.MethodDelete:
+					requests = append(requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					var s struct {
+						Device      int64   `json:"device"`
+						IPAddresses []int64 `json:"ipaddresses"`
+					}
+					if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+						t.Errorf("decoding service: %s", err)
+					}
+					sort.Slice(s.IPAddresses, func(i, j int) bool { return s.IPAddresses[i] < s.IPAddresses[j] })
+					requests = append(requests, fmt.Sprintf("%s %s device=%d ips=%v", r.Method, r.URL.Path, s.Device, s.IPAddresses))
+					w.Write([]byte(`{"id": 7}`))
+				}
+			}))
+			defer server.Close()
+
+			c, err := NewClient(server.URL, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var addrs []netip.Addr
+			for _, addr := range test.addresses {
+				addrs = append(addrs, netip.MustParseAddr(addr))
+			}
+			err = c.(ServiceClient).UpsertNodePortService(context.Background(), &NodePortService{
+				Name:        "foo",
+				Protocol:    "tcp",
+				Ports:       []int32{30080},
+				Description: "bar",
+				Addresses:   addrs,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// services of different parents are written in no particular order
+			sort.Strings(requests)
+			sort.Strings(test.expectedRequests)
+			if diff := cmp.Diff(test.expectedRequests, requests); diff != "" {
+				t.Errorf("requests (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
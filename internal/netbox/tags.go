@@ -0,0 +1,105 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// CreatedTagDescription is the description of tags created by the
+// controller, by which they are told apart from other tags in NetBox.
+const CreatedTagDescription = "Created by netbox-ip-controller"
+
+// TagCollector is implemented by clients that can garbage
+// collect the tags created by the controller.
+type TagCollector interface {
+	// ListUnusedTags returns the tags created by the controller
+	// that no object in the IPAM system is tagged with.
+	ListUnusedTags(ctx context.Context) ([]Tag, error)
+	// DeleteTag deletes the given tag.
+	DeleteTag(ctx context.Context, tag Tag) error
+}
+
+// tagWithUsage is a tag as returned by NetBox, with the number of
+// objects that are tagged with it, which is nil if it is unknown.
+type tagWithUsage struct {
+	Tag
+	Description string `json:"description"`
+	TaggedItems *int   `json:"tagged_items,omitempty"`
+}
+
+// ListUnusedTags returns the tags with CreatedTagDescription
+// that have no tagged items in NetBox.
+func (c *client) ListUnusedTags(ctx context.Context) ([]Tag, error) {
+	query := url.Values{}
+	query.Set("description", CreatedTagDescription)
+	query.Set("limit", fmt.Sprint(listPageSize))
+
+	var tags []Tag
+	for offset := 0; ; {
+		query.Set("offset", fmt.Sprint(offset))
+		url := fmt.Sprintf("%s/extras/tags/?%s", c.baseURL, query.Encode())
+		data, err := c.executeRequest(ctx, url, http.MethodGet, nil)
+		if err != nil {
+			return nil, fmt.Errorf("executing request: %w", err)
+		}
+
+		var tagList struct {
+			Count   uint           `json:"count"`
+			Results []tagWithUsage `json:"results"`
+		}
+		if err := json.Unmarshal(data, &tagList); err != nil {
+			return nil, fmt.Errorf("unmarshaling response: %w", err)
+		}
+
+		for _, tag := range tagList.Results {
+			// older NetBox versions may not filter by description, and tags
+			// whose usage is not reported are not known to be unused
+			if tag.Description == CreatedTagDescription && tag.TaggedItems != nil && *tag.TaggedItems == 0 {
+				tags = append(tags, tag.Tag)
+			}
+		}
+
+		offset += len(tagList.Results)
+		if len(tagList.Results) == 0 || offset >= int(tagList.Count) {
+			return tags, nil
+		}
+	}
+}
+
+// DeleteTag deletes the tag with the ID of tag from NetBox.
+func (c *client) DeleteTag(ctx context.Context, tag Tag) error {
+	record := AuditRecord{
+		Operation: AuditOperationDelete,
+		Object:    AuditObjectTag,
+		ID:        tag.ID,
+		Name:      tag.Name,
+	}
+	url := fmt.Sprintf("%s/extras/tags/%d/", c.baseURL, tag.ID)
+	if _, err := c.executeRequest(ctx, url, http.MethodDelete, nil); err != nil {
+		c.recordFailedAudit(record, err)
+		return fmt.Errorf("executing request: %w", err)
+	}
+
+	c.recordAudit(record)
+
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCreateTagDescription(t *testing.T) {
+	var description string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		description, _ = body["description"].(string)
+		w.Write([]byte(`{"id": 1, "name": "foo", "slug": "foo"}`))
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateTag(context.Background(), "foo"); err != nil {
+		t.Fatalf("creating tag: %s", err)
+	}
+	if description != CreatedTagDescription {
+		t.Errorf("want description %q, got %q", CreatedTagDescription, description)
+	}
+}
+
+func TestListUnusedTags(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("description") != CreatedTagDescription {
+				t.Errorf("want tags filtered by description, got query %q", r.URL.RawQuery)
+			}
+			switch r.URL.Query().Get("offset") {
+			case "0":
+				w.Write([]byte(`{"count": 4, "results": [
+					{"id": 1, "name": "unused", "slug": "unused", "description": "Created by netbox-ip-controller", "tagged_items": 0},
+					{"id": 2, "name": "used", "slug": "used", "description": "Created by netbox-ip-controller", "tagged_items": 3}
+				]}`))
+			default:
+				w.Write([]byte(`{"count": 4, "results": [
+					{"id": 3, "name": "foreign", "slug": "foreign", "description": "", "tagged_items": 0},
+					{"id": 4, "name": "unknown", "slug": "unknown", "description": "Created by netbox-ip-controller"}
+				]}`))
+			}
+		case http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector := c.(TagCollector)
+
+	tags, err := collector.ListUnusedTags(context.Background())
+	if err != nil {
+		t.Fatalf("listing unused tags: %s", err)
+	}
+	if diff := cmp.Diff([]Tag{{ID: 1, Name: "unused", Slug: "unused"}}, tags); diff != "" {
+		t.Errorf("unused tags (-want, +got)\n%s", diff)
+	}
+
+	if err := collector.DeleteTag(context.Background(), tags[0]); err != nil {
+		t.Fatalf("deleting tag: %s", err)
+	}
+	if diff := cmp.Diff([]string{"/extras/tags/1/"}, deleted); diff != "" {
+		t.Errorf("deleted tags (-want, +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	log "go.uber.org/zap"
+)
+
+// CAPool is a set of root certificates loaded from a file or a directory.
+// If watched, the certificates are reloaded whenever they change on disk.
+// A CAPool may be shared by several clients.
+type CAPool struct {
+	path   string
+	logger *log.Logger
+
+	mu       sync.RWMutex
+	pool     *x509.CertPool
+	onReload []func()
+}
+
+// NewCAPool loads the PEM-encoded root certificates found at the given path,
+// which may point to either a single file, or a directory containing
+// .pem, .crt or .cer files.
+func NewCAPool(path string, logger *log.Logger) (*CAPool, error) {
+	if logger == nil {
+		logger = log.L()
+	}
+	p := &CAPool{
+		path:   filepath.Clean(path),
+		logger: logger,
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// certPool returns the most recently loaded root certificates.
+func (p *CAPool) certPool() *x509.CertPool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pool
+}
+
+// notifyReload registers f to be called after the certificates are reloaded.
+func (p *CAPool) notifyReload(f func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onReload = append(p.onReload, f)
+}
+
+func (p *CAPool) load() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+
+	files := []string{p.path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(p.path)
+		if err != nil {
+			return err
+		}
+		files = nil
+		for _, e := range entries {
+			if isCertFile(e.Name()) {
+				files = append(files, filepath.Join(p.path, e.Name()))
+			}
+		}
+	}
+
+	pool := x509.NewCertPool()
+	var parsed bool
+	for _, f := range files {
+		cert, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if pool.AppendCertsFromPEM(cert) {
+			parsed = true
+		}
+	}
+	if !parsed {
+		return errors.New("no certificates were successfully parsed")
+	}
+
+	p.mu.Lock()
+	p.pool = pool
+	p.mu.Unlock()
+
+	return nil
+}
+
+func isCertFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pem", ".crt", ".cer":
+		return true
+	}
+	return false
+}
+
+// kubernetesDataDir is the symlink that is atomically replaced
+// when a mounted Kubernetes secret or config map is updated.
+const kubernetesDataDir = "..data"
+
+// Watch starts reloading the certificates whenever they change on disk,
+// until the context is done. A file is watched through its parent directory,
+// so that atomic replacements (such as updates of a mounted Kubernetes secret)
+// are picked up as well; events for other files in the directory are ignored.
+func (p *CAPool) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating watcher: %w", err)
+	}
+
+	dir := p.path
+	isDir := true
+	if info, err := os.Stat(p.path); err == nil && !info.IsDir() {
+		dir = filepath.Dir(p.path)
+		isDir = false
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("watching %s: %w", dir, err)
+	}
+
+	// relevant reports whether the event may have changed the certificates
+	relevant := func(event fsnotify.Event) bool {
+		name := filepath.Base(event.Name)
+		if name == kubernetesDataDir {
+			return true
+		}
+		if isDir {
+			return isCertFile(name)
+		}
+		return filepath.Clean(event.Name) == p.path
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !relevant(event) {
+					continue
+				}
+				if err := p.load(); err != nil {
+					p.logger.Error("reloading CA certificates", log.String("path", p.path), log.Error(err))
+					continue
+				}
+				p.logger.Info("reloaded CA certificates", log.String("path", p.path))
+				p.mu.RLock()
+				onReload := p.onReload
+				p.mu.RUnlock()
+				for _, f := range onReload {
+					f()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				p.logger.Error("watching CA certificates", log.String("path", p.path), log.Error(err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// tlsSettings are the TLS options of the client's transport.
+type tlsSettings struct {
+	minVersion   uint16
+	cipherSuites []uint16
+	// serverName, if set, is the name that the server certificate
+	// must be valid for, regardless of the host the client connects to
+	serverName string
+	// host is the host of the API URL, which the server certificate
+	// must be valid for if no serverName is set
+	host    string
+	rootCAs *CAPool
+}
+
+func (s tlsSettings) isZero() bool {
+	return s.minVersion == 0 && len(s.cipherSuites) == 0 && s.serverName == "" && s.rootCAs == nil
+}
+
+// config returns the TLS config for the client's transport.
+func (s tlsSettings) config() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:   s.minVersion,
+		CipherSuites: s.cipherSuites,
+	}
+
+	if s.rootCAs != nil || s.serverName != "" {
+		// the default verification is replaced by VerifyConnection, which
+		// uses the most recently loaded root certificates and the pinned name
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = s.verifyConnection
+	}
+
+	return cfg
+}
+
+// verifyConnection verifies the server certificate chain against the current
+// root certificates (or the system ones, if none were configured), and checks
+// that the certificate is valid for the pinned server name or, if there is none,
+// for the host of the API URL. The host is used rather than cs.ServerName,
+// which is empty when the host is an IP address.
+func (s tlsSettings) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server did not present a certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       s.host,
+		Intermediates: x509.NewCertPool(),
+	}
+	if s.serverName != "" {
+		opts.DNSName = s.serverName
+	}
+	if s.rootCAs != nil {
+		opts.Roots = s.rootCAs.certPool()
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// TLSVersion returns the TLS version with the given name, e.g. "1.2".
+func TLSVersion(name string) (uint16, error) {
+	switch name {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", name)
+}
+
+// CipherSuites returns IDs of the cipher suites with the given names,
+// as returned by tls.CipherSuiteName. Only the cipher suites considered
+// secure by crypto/tls are accepted.
+func CipherSuites(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWithCARootCertReload(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	otherCA := selfSignedCertPEM(t)
+
+	tests := []struct {
+		name string
+		// write the given PEM data to the CA path, and return the path
+		write func(t *testing.T, dir string, data []byte) string
+	}{{
+		name: "file",
+		write: func(t *testing.T, dir string, data []byte) string {
+			path := filepath.Join(dir, "ca.pem")
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		},
+	}, {
+		name: "directory",
+		write: func(t *testing.T, dir string, data []byte) string {
+			if err := os.WriteFile(filepath.Join(dir, "ca.crt"), data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			return dir
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := test.write(t, dir, otherCA)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			pool, err := NewCAPool(path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := pool.Watch(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			nc, err := NewClient(server.URL, "", WithCAPool(pool))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := nc.(*client)
+			c.httpClient.RetryMax = 0
+
+			if _, err := c.executeRequest(context.Background(), server.URL, http.MethodGet, nil); err == nil {
+				t.Fatal("want an error verifying certificate signed by an unknown CA, got nil")
+			}
+
+			test.write(t, dir, serverCA)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				_, err := c.executeRequest(context.Background(), server.URL, http.MethodGet, nil)
+				if err == nil {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("certificates were not reloaded: %s", err)
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestTLSSettings(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, serverCA, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		opts          []ClientOption
+		errorExpected bool
+	}{{
+		name: "pinned name matching the certificate",
+		// the httptest server certificate is valid for example.com
+		opts: []ClientOption{WithCARootCert(caPath), WithTLSServerName("example.com")},
+	}, {
+		name:          "pinned name not matching the certificate",
+		opts:          []ClientOption{WithCARootCert(caPath), WithTLSServerName("netbox.example.org")},
+		errorExpected: true,
+	}, {
+		name:          "minimum version not supported by the server",
+		opts:          []ClientOption{WithCARootCert(caPath), WithTLSMinVersion(tls.VersionTLS13)},
+		errorExpected: true,
+	}, {
+		name: "allowed cipher suite",
+		opts: []ClientOption{
+			WithCARootCert(caPath),
+			WithTLSMinVersion(tls.VersionTLS12),
+			WithTLSCipherSuites([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}),
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nc, err := NewClient(server.URL, "", test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := nc.(*client)
+			c.httpClient.RetryMax = 0
+
+			_, err = c.executeRequest(context.Background(), server.URL, http.MethodGet, nil)
+			if test.errorExpected && err == nil {
+				t.Error("want an error, got nil")
+			} else if !test.errorExpected && err != nil {
+				t.Errorf("want no error, got %s", err)
+			}
+		})
+	}
+}
+
+func TestTLSVerifiesIPAddressHost(t *testing.T) {
+	certPEM, cert := selfSignedCert(t, "netbox.example.org")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		opts          []ClientOption
+		errorExpected bool
+	}{{
+		name: "certificate not valid for the IP address",
+		// the server URL has an IP address as its host, which
+		// the certificate for netbox.example.org is not valid for
+		opts:          []ClientOption{WithCARootCert(caPath)},
+		errorExpected: true,
+	}, {
+		name: "pinned name matching the certificate",
+		opts: []ClientOption{WithCARootCert(caPath), WithTLSServerName("netbox.example.org")},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nc, err := NewClient(server.URL, "", test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := nc.(*client)
+			c.httpClient.RetryMax = 0
+
+			_, err = c.executeRequest(context.Background(), server.URL, http.MethodGet, nil)
+			if test.errorExpected && err == nil {
+				t.Error("want an error, got nil")
+			} else if !test.errorExpected && err != nil {
+				t.Errorf("want no error, got %s", err)
+			}
+		})
+	}
+}
+
+func TestCipherSuites(t *testing.T) {
+	tests := []struct {
+		name          string
+		names         []string
+		expected      []uint16
+		errorExpected bool
+	}{{
+		name:     "secure cipher suites",
+		names:    []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"},
+		expected: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+	}, {
+		name:          "insecure cipher suite",
+		names:         []string{"TLS_RSA_WITH_RC4_128_SHA"},
+		errorExpected: true,
+	}, {
+		name:          "unknown cipher suite",
+		names:         []string{"foo"},
+		errorExpected: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids, err := CipherSuites(test.names)
+			if test.errorExpected {
+				if err == nil {
+					t.Error("want an error, got nil")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, ids); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func selfSignedCertPEM(t *testing.T) []byte {
+	t.Helper()
+	certPEM, _ := selfSignedCert(t)
+	return certPEM
+}
+
+// selfSignedCert returns a self-signed CA certificate, valid for the given
+// DNS names, both PEM-encoded and with its private key for use by a server.
+func selfSignedCert(t *testing.T, dnsNames ...string) ([]byte, tls.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
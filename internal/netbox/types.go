@@ -99,16 +99,221 @@ type TagList struct {
 	Results []Tag `json:"results"`
 }
 
+// Tenant represents a NetBox tenant. When writing an IP address,
+// it is enough to set the slug: NetBox will look up the tenant by it.
+type Tenant struct {
+	ID   int64  `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	Slug string `json:"slug,omitempty"`
+}
+
+// VRF represents a NetBox VRF. When writing an IP address,
+// it is enough to set the name: the client looks up the ID.
+type VRF struct {
+	ID   int64  `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// NestedIP references a NetBox IP address by its ID.
+type NestedIP struct {
+	ID int64 `json:"id"`
+}
+
+// IPStatusDeprecated is the status of IPs that are kept
+// in NetBox for review after their NetBoxIP was deleted.
+const IPStatusDeprecated = "deprecated"
+
+// AppendNote appends note to the description of an IP,
+// separated by a space if the description is not empty.
+func AppendNote(description, note string) string {
+	if description == "" {
+		return note
+	}
+	return description + " " + note
+}
+
 // IPAddress represents a NetBox IP address.
 type IPAddress struct {
+	// ID is the ID of the IP in NetBox. If set when the IP is upserted,
+	// e.g. from a previous upsert, the IP with the ID is read instead of
+	// looking it up by its UID, as long as it still has the UID.
 	ID int64 `json:"id,omitempty"`
+	// WebURL is the URL of the IP in the web UI of NetBox.
+	// It is set on IPs returned by the NetBox client.
+	WebURL string `json:"-"`
 	// UID is the UID of the object that this IP is assigned to.
 	// It is stored in NetBox as a custom field.
-	UID         UID    `json:"custom_fields,omitempty"`
-	DNSName     string `json:"dns_name,omitempty"`
-	Address     IP     `json:"address,omitempty"`
-	Tags        []Tag  `json:"tags,omitempty"`
-	Description string `json:"description,omitempty"`
+	UID UID `json:"custom_fields,omitempty"`
+	// DNSName is always sent, so that an empty name
+	// clears the name of an existing IP.
+	DNSName string `json:"dns_name"`
+	Address IP     `json:"address,omitempty"`
+	// PrefixLength is the prefix length that Address is written with,
+	// e.g. 24 for 10.0.0.0/24. If 0, it is the length of the address,
+	// i.e. 32 for IPv4 and 128 for IPv6 addresses.
+	PrefixLength int     `json:"-"`
+	Tags         []Tag   `json:"tags,omitempty"`
+	Description  string  `json:"description,omitempty"`
+	Tenant       *Tenant `json:"tenant,omitempty"`
+	VRF          *VRF    `json:"vrf,omitempty"`
+	// AssignedObjectType and AssignedObjectID identify
+	// the interface that the IP is assigned to, if any.
+	AssignedObjectType string `json:"assigned_object_type,omitempty"`
+	AssignedObjectID   int64  `json:"assigned_object_id,omitempty"`
+	// AssignedInterface, if set, is looked up when the IP is upserted,
+	// and replaces AssignedObjectType and AssignedObjectID.
+	AssignedInterface *InterfaceRef `json:"-"`
+	// NATInside is the IP that this IP is the outside address of, if any.
+	// It is only written if set, so that NAT relationships set in NetBox
+	// are kept for IPs that have none.
+	NATInside *NestedIP `json:"nat_inside,omitempty"`
+	// NATInsideUID, if set, is the UID of the IP that is looked up when
+	// the IP is upserted, and replaces NATInside.
+	NATInsideUID UID `json:"-"`
+	// TakeOverUID, if set, is the UID of an IP, e.g. of a deleted object
+	// with the same address, which is updated to have UID when the IP is
+	// upserted, if there is no IP with UID yet.
+	TakeOverUID UID `json:"-"`
+	// CustomFields are the values of text custom fields other than
+	// the UID, by field name. When writing an IP, fields that are not
+	// set are left as they are, and empty values clear the field.
+	CustomFields map[string]string `json:"-"`
+	// LastUpdated is when the IP was last changed in NetBox, as read
+	// from NetBox. When upserting an IP with the ID and last updated time
+	// of the last time it was synced, it is only updated if it has not
+	// changed in NetBox since.
+	LastUpdated string `json:"-"`
+}
+
+// ipAddress has the fields, but not the methods of IPAddress,
+// for marshaling the fields that do not need special handling.
+type ipAddress IPAddress
+
+// MarshalJSON implements the json.Marshaler interface for IPAddress.
+func (ip IPAddress) MarshalJSON() ([]byte, error) {
+	return ip.marshalJSON(UIDCustomFieldName, "")
+}
+
+// marshalJSON marshals the IP with its UID in the custom field uidField.
+// If previousUIDField is set, it is cleared, so that the UID is moved
+// rather than copied.
+func (ip IPAddress) marshalJSON(uidField, previousUIDField string) ([]byte, error) {
+	uid := ip.UID
+	// the custom fields are marshaled below
+	ip.UID = ""
+	data, err := json.Marshal(ipAddress(ip))
+	if err != nil || (len(ip.CustomFields) == 0 && ip.PrefixLength == 0 && uid == "") {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if ip.PrefixLength != 0 {
+		prefix, err := netip.Addr(ip.Address).Prefix(ip.PrefixLength)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix length %d of %s: %w", ip.PrefixLength, netip.Addr(ip.Address), err)
+		}
+		// the address itself is kept, e.g. 10.0.0.1/24 rather than 10.0.0.0/24
+		if fields["address"], err = json.Marshal(fmt.Sprintf("%s/%d", netip.Addr(ip.Address), prefix.Bits())); err != nil {
+			return nil, err
+		}
+	}
+	customFields := make(map[string]interface{})
+	for name, value := range ip.CustomFields {
+		if value == "" {
+			customFields[name] = nil
+		} else {
+			customFields[name] = value
+		}
+	}
+	if uid != "" {
+		if previousUIDField != "" {
+			customFields[previousUIDField] = nil
+		}
+		customFields[uidField] = string(uid)
+	}
+	if len(customFields) > 0 {
+		if fields["custom_fields"], err = json.Marshal(customFields); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for IPAddress.
+func (ip *IPAddress) UnmarshalJSON(b []byte) error {
+	return ip.unmarshalJSON(b, UIDCustomFieldName, "")
+}
+
+// unmarshalJSON unmarshals an IP with its UID in the custom field uidField,
+// or, if that is not set, in previousUIDField, if any. Other custom fields,
+// including one named UIDCustomFieldName that is not uidField, e.g. of
+// another tool, are kept in CustomFields.
+func (ip *IPAddress) unmarshalJSON(b []byte, uidField, previousUIDField string) error {
+	if err := json.Unmarshal(b, (*ipAddress)(ip)); err != nil {
+		return err
+	}
+
+	var fields struct {
+		Address      string                 `json:"address"`
+		CustomFields map[string]interface{} `json:"custom_fields"`
+		LastUpdated  string                 `json:"last_updated"`
+	}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	ip.LastUpdated = fields.LastUpdated
+	if fields.Address != "" {
+		// the address was parsed already, so the prefix is valid
+		prefix, _ := netip.ParsePrefix(fields.Address)
+		if prefix.Bits() != prefix.Addr().BitLen() {
+			ip.PrefixLength = prefix.Bits()
+		}
+	}
+	ip.UID = ""
+	if uid, ok := fields.CustomFields[uidField].(string); ok {
+		ip.UID = UID(uid)
+	} else if uid, ok := fields.CustomFields[previousUIDField].(string); ok && previousUIDField != "" {
+		ip.UID = UID(uid)
+	}
+	for name, value := range fields.CustomFields {
+		if s, ok := value.(string); ok && name != uidField && name != previousUIDField {
+			if ip.CustomFields == nil {
+				ip.CustomFields = make(map[string]string)
+			}
+			ip.CustomFields[name] = s
+		}
+	}
+	return nil
+}
+
+// Types of NetBox interfaces that IPs can be assigned to.
+const (
+	AssignedObjectTypeInterface   = "dcim.interface"
+	AssignedObjectTypeVMInterface = "virtualization.vminterface"
+)
+
+// InterfaceRef references a NetBox interface of the given type, either
+// by ID, or by the names of the interface and its device or virtual machine.
+type InterfaceRef struct {
+	Type   string
+	ID     int64
+	Name   string
+	Parent string
+	// MACAddress, if set, is the MAC address of the interface,
+	// which is created if it does not exist.
+	MACAddress string
+}
+
+// InterfaceList represents the response from the NetBox endpoints that return multiple interfaces.
+type InterfaceList struct {
+	Count   uint `json:"count"`
+	Results []struct {
+		ID         int64  `json:"id"`
+		MACAddress string `json:"mac_address,omitempty"`
+	} `json:"results"`
 }
 
 // IPAddressList represents the response from the NetBox endpoints that return multiple IP addresses.
@@ -187,12 +392,47 @@ func (ip *IPAddress) changed(ip2 *IPAddress) bool {
 		return true
 	}
 
+	if ip2.Tenant == nil {
+		// tenant is not managed by the controller for this IP,
+		// so whatever tenant is set in NetBox should be left as is
+		ipCopy := *ip
+		ipCopy.Tenant = nil
+		ip = &ipCopy
+	}
+	if ip2.VRF == nil {
+		// neither is the VRF
+		ipCopy := *ip
+		ipCopy.VRF = nil
+		ip = &ipCopy
+	}
+	for name, value := range ip2.CustomFields {
+		if ip.CustomFields[name] != value {
+			return true
+		}
+	}
+	if ip2.AssignedObjectType == "" {
+		// neither is the assigned object
+		ipCopy := *ip
+		ipCopy.AssignedObjectType = ""
+		ipCopy.AssignedObjectID = 0
+		ip = &ipCopy
+	}
+	if ip2.NATInside == nil {
+		// nor the NAT inside address
+		ipCopy := *ip
+		ipCopy.NATInside = nil
+		ip = &ipCopy
+	}
+
 	// slug names are required to be unique, so can base sorting on it
 	sortTags := func(t1, t2 Tag) bool { return t1.Name < t2.Name }
 
 	return !cmp.Equal(ip, ip2,
-		cmpopts.IgnoreFields(IPAddress{}, "ID"),
+		// only the custom fields of ip2 are managed, and compared above
+		cmpopts.IgnoreFields(IPAddress{}, "ID", "WebURL", "AssignedInterface", "NATInsideUID", "TakeOverUID", "CustomFields", "LastUpdated"),
 		cmpopts.IgnoreFields(Tag{}, "ID"),
+		cmpopts.IgnoreFields(Tenant{}, "ID", "Name"),
+		cmpopts.IgnoreFields(VRF{}, "ID"),
 		cmpopts.SortSlices(sortTags),
 		cmpopts.EquateEmpty(),
 		cmpopts.IgnoreUnexported(IP{}),
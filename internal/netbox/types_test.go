@@ -118,6 +118,17 @@ func TestIPAddressUnmarshaling(t *testing.T) {
 			ID:      123,
 			Address: IP(netip.AddrFrom16([16]byte{0, 1, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3})),
 		},
+	}, {
+		name: "with prefix length",
+		data: `{
+			"id": 123,
+			"address": "10.0.0.0/24"
+		}`,
+		expectedIP: &IPAddress{
+			ID:           123,
+			Address:      IP(netip.AddrFrom4([4]byte{10, 0, 0, 0})),
+			PrefixLength: 24,
+		},
 	}, {
 		name: "with invalid address",
 		data: `{
@@ -138,6 +149,21 @@ func TestIPAddressUnmarshaling(t *testing.T) {
 			ID:  123,
 			UID: UID("5d9b8cf3-feba-4d73-8075-18b99783b7be"),
 		},
+	}, {
+		name: "with custom fields",
+		data: `{
+			"id": 123,
+			"custom_fields": {
+				"netbox_ip_controller_uid": "5d9b8cf3-feba-4d73-8075-18b99783b7be",
+				"cost_center": "billing",
+				"unset_field": null
+			}
+		}`,
+		expectedIP: &IPAddress{
+			ID:           123,
+			UID:          UID("5d9b8cf3-feba-4d73-8075-18b99783b7be"),
+			CustomFields: map[string]string{"cost_center": "billing"},
+		},
 	}, {
 		name: "with custom fields but no uid",
 		data: `{
@@ -167,6 +193,26 @@ func TestIPAddressUnmarshaling(t *testing.T) {
 				Slug: "bar",
 			}},
 		},
+	}, {
+		name: "with tenant",
+		data: `{
+			"id": 123,
+			"tenant": {
+				"id": 7,
+				"url": "https://netbox.example.com/api/tenancy/tenants/7/",
+				"display": "Team A",
+				"name": "Team A",
+				"slug": "team-a"
+			}
+		}`,
+		expectedIP: &IPAddress{
+			ID: 123,
+			Tenant: &Tenant{
+				ID:   7,
+				Name: "Team A",
+				Slug: "team-a",
+			},
+		},
 	}}
 
 	for _, test := range tests {
@@ -197,7 +243,8 @@ func TestIPAddressMarshaling(t *testing.T) {
 		name: "empty",
 		ip:   &IPAddress{},
 		expectedData: `{
-			"address": ""
+			"address": "",
+			"dns_name": ""
 		}`,
 	}, {
 		name: "with IPv4 address",
@@ -207,7 +254,8 @@ func TestIPAddressMarshaling(t *testing.T) {
 		},
 		expectedData: `{
 			"id": 123,
-			"address": "192.168.0.1/32"
+			"address": "192.168.0.1/32",
+			"dns_name": ""
 		}`,
 	}, {
 		name: "with IPv6 address",
@@ -217,7 +265,20 @@ func TestIPAddressMarshaling(t *testing.T) {
 		},
 		expectedData: `{
 			"id": 123,
-			"address": "1:2::3/128"
+			"address": "1:2::3/128",
+			"dns_name": ""
+		}`,
+	}, {
+		name: "with prefix length",
+		ip: &IPAddress{
+			ID:           123,
+			Address:      IP(netip.AddrFrom4([4]byte{10, 0, 0, 0})),
+			PrefixLength: 24,
+		},
+		expectedData: `{
+			"id": 123,
+			"address": "10.0.0.0/24",
+			"dns_name": ""
 		}`,
 	}, {
 		name: "with uid",
@@ -228,10 +289,28 @@ func TestIPAddressMarshaling(t *testing.T) {
 		expectedData: `{
 			"id": 123,
 			"address": "",
+			"dns_name": "",
 			"custom_fields": {
 				"netbox_ip_controller_uid": "5d9b8cf3-feba-4d73-8075-18b99783b7be"
 			}
 		}`,
+	}, {
+		name: "with custom fields",
+		ip: &IPAddress{
+			ID:           123,
+			UID:          UID("5d9b8cf3-feba-4d73-8075-18b99783b7be"),
+			CustomFields: map[string]string{"cost_center": "billing", "team": ""},
+		},
+		expectedData: `{
+			"id": 123,
+			"address": "",
+			"dns_name": "",
+			"custom_fields": {
+				"netbox_ip_controller_uid": "5d9b8cf3-feba-4d73-8075-18b99783b7be",
+				"cost_center": "billing",
+				"team": null
+			}
+		}`,
 	}, {
 		name: "with tags",
 		ip: &IPAddress{
@@ -245,12 +324,27 @@ func TestIPAddressMarshaling(t *testing.T) {
 		expectedData: `{
 			"id": 123,
 			"address": "",
+			"dns_name": "",
 			"tags": [{
 				"id": 5,
 				"name": "foo",
 				"slug": "bar"
 			}]
 		}`,
+	}, {
+		name: "with tenant",
+		ip: &IPAddress{
+			ID:     123,
+			Tenant: &Tenant{Slug: "team-a"},
+		},
+		expectedData: `{
+			"id": 123,
+			"address": "",
+			"dns_name": "",
+			"tenant": {
+				"slug": "team-a"
+			}
+		}`,
 	}}
 
 	for _, test := range tests {
@@ -272,6 +366,16 @@ func TestIPAddressMarshaling(t *testing.T) {
 	}
 }
 
+func TestIPAddressMarshalingInvalidPrefixLength(t *testing.T) {
+	ip := &IPAddress{
+		Address:      IP(netip.AddrFrom4([4]byte{10, 0, 0, 0})),
+		PrefixLength: 64,
+	}
+	if _, err := json.Marshal(ip); err == nil {
+		t.Error("want an error, got nil")
+	}
+}
+
 func TestIPChanged(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -304,6 +408,76 @@ func TestIPChanged(t *testing.T) {
 		},
 		ip2:     &IPAddress{},
 		changed: false,
+	}, {
+		name: "with the same tenant",
+		ip1: &IPAddress{
+			Tenant: &Tenant{ID: 7, Name: "Team A", Slug: "team-a"},
+		},
+		ip2: &IPAddress{
+			Tenant: &Tenant{Slug: "team-a"},
+		},
+		changed: false,
+	}, {
+		name: "with a different tenant",
+		ip1: &IPAddress{
+			Tenant: &Tenant{ID: 7, Name: "Team A", Slug: "team-a"},
+		},
+		ip2: &IPAddress{
+			Tenant: &Tenant{Slug: "team-b"},
+		},
+		changed: true,
+	}, {
+		name: "with unmanaged tenant",
+		ip1: &IPAddress{
+			Tenant: &Tenant{ID: 7, Name: "Team A", Slug: "team-a"},
+		},
+		ip2:     &IPAddress{},
+		changed: false,
+	}, {
+		name: "with unmanaged custom fields",
+		ip1: &IPAddress{
+			CustomFields: map[string]string{"cost_center": "billing", "owner": "team-a"},
+		},
+		ip2: &IPAddress{
+			CustomFields: map[string]string{"cost_center": "billing"},
+		},
+		changed: false,
+	}, {
+		name: "with a different custom field",
+		ip1: &IPAddress{
+			CustomFields: map[string]string{"cost_center": "billing"},
+		},
+		ip2: &IPAddress{
+			CustomFields: map[string]string{"cost_center": "sales"},
+		},
+		changed: true,
+	}, {
+		name: "with a cleared custom field",
+		ip1: &IPAddress{
+			CustomFields: map[string]string{"cost_center": "billing"},
+		},
+		ip2: &IPAddress{
+			CustomFields: map[string]string{"cost_center": ""},
+		},
+		changed: true,
+	}, {
+		name: "with the same VRF",
+		ip1: &IPAddress{
+			VRF: &VRF{ID: 3, Name: "blue"},
+		},
+		ip2: &IPAddress{
+			VRF: &VRF{Name: "blue"},
+		},
+		changed: false,
+	}, {
+		name: "with a different VRF",
+		ip1: &IPAddress{
+			VRF: &VRF{ID: 3, Name: "blue"},
+		},
+		ip2: &IPAddress{
+			VRF: &VRF{Name: "red"},
+		},
+		changed: true,
 	}}
 
 	for _, test := range tests {
@@ -337,3 +511,22 @@ func jsonDiff(b1, b2 []byte) (string, error) {
 
 	return cmp.Diff(formattedB1, formattedB2), nil
 }
+
+func TestAppendNote(t *testing.T) {
+	tests := []struct {
+		description string
+		expected    string
+	}{{
+		description: "",
+		expected:    "(deleted)",
+	}, {
+		description: "web",
+		expected:    "web (deleted)",
+	}}
+
+	for _, test := range tests {
+		if got := AppendNote(test.description, "(deleted)"); got != test.expected {
+			t.Errorf("want %q, got %q", test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	log "go.uber.org/zap"
+)
+
+var customFieldNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]{1,50}$")
+
+// WithUIDFieldName makes the client store UIDs in the NetBox custom field
+// with the given name rather than UIDCustomFieldName, e.g. so that two tools,
+// or two generations of the controller, sharing a NetBox do not collide.
+func WithUIDFieldName(name string) ClientOption {
+	return func(c *client) error {
+		if !customFieldNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid UID field name %q", name)
+		}
+		c.uidFieldName = name
+		return nil
+	}
+}
+
+// WithPreviousUIDFieldName makes the client migrate UIDs from the custom field
+// with the given name, which UIDs were stored in before: IPs with a UID in it,
+// but not in the current UID field, are found by their UID, and their UID is
+// moved to the current field when they are upserted.
+func WithPreviousUIDFieldName(name string) ClientOption {
+	return func(c *client) error {
+		if !customFieldNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid previous UID field name %q", name)
+		}
+		c.previousUIDFieldName = name
+		return nil
+	}
+}
+
+// WithoutUIDFieldMigration makes UpsertUIDField leave an existing UID field
+// whose definition differs from the one it would create as it is, e.g. if
+// the field is managed outside of the controller.
+func WithoutUIDFieldMigration() ClientOption {
+	return func(c *client) error {
+		c.skipUIDFieldMigration = true
+		return nil
+	}
+}
+
+// uidFieldDefinition returns the UID custom field that UpsertUIDField creates.
+func (c *client) uidFieldDefinition() CustomField {
+	return CustomField{
+		ContentTypes:    []string{"ipam.ipaddress"},
+		Description:     "UID of the object the IP is assigned to.",
+		FilterLogic:     "exact",
+		Label:           "UID",
+		Name:            c.uidFieldName,
+		Required:        false,
+		Type:            "text",
+		ValidationRegex: uidRegexpStr,
+		Weight:          100,
+	}
+}
+
+// migrateUIDField updates the validation regex, label and content types of
+// the existing UID field in place if they differ from the ones of the field
+// that UpsertUIDField creates, e.g. because it was created by an older version
+// that did not allow UID prefixes. Content types that were added to the field
+// are kept.
+func (c *client) migrateUIDField(ctx context.Context, field *CustomField) error {
+	definition := c.uidFieldDefinition()
+	patch := make(map[string]interface{})
+	changes := make(map[string]AuditChange)
+
+	if field.ValidationRegex != definition.ValidationRegex {
+		patch["validation_regex"] = definition.ValidationRegex
+		changes["validation_regex"] = AuditChange{Old: field.ValidationRegex, New: definition.ValidationRegex}
+	}
+	if field.Label != definition.Label {
+		patch["label"] = definition.Label
+		changes["label"] = AuditChange{Old: field.Label, New: definition.Label}
+	}
+	contentTypes := append([]string{}, field.ContentTypes...)
+	for _, contentType := range definition.ContentTypes {
+		if !containsString(field.ContentTypes, contentType) {
+			contentTypes = append(contentTypes, contentType)
+		}
+	}
+	if len(contentTypes) != len(field.ContentTypes) {
+		patch["content_types"] = contentTypes
+		changes["content_types"] = AuditChange{Old: field.ContentTypes, New: contentTypes}
+	}
+
+	ll := c.logger.With(log.String("field", c.uidFieldName))
+	if len(patch) == 0 {
+		ll.Info("UID field already exists")
+		return nil
+	}
+	if c.skipUIDFieldMigration {
+		ll.Warn("UID field definition is outdated, but not migrating it", log.Any("changes", changes))
+		return nil
+	}
+
+	record := AuditRecord{
+		Operation: AuditOperationUpdate,
+		Object:    AuditObjectCustomField,
+		ID:        field.ID,
+		Name:      c.uidFieldName,
+		Changes:   changes,
+	}
+	url := fmt.Sprintf("%s/extras/custom-fields/%d/", c.baseURL, field.ID)
+	if _, err := c.executeRequest(ctx, url, http.MethodPatch, patch); err != nil {
+		c.recordFailedAudit(record, err)
+		return fmt.Errorf("migrating UID field definition: %w", err)
+	}
+
+	c.recordAudit(record)
+	ll.Info("migrated UID field definition", log.Any("changes", changes))
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalIP returns the body of a request writing ip, with its UID in the
+// UID field of the client, moving it from the previous UID field, if any.
+func (c *client) marshalIP(ip *IPAddress) (json.RawMessage, error) {
+	data, err := ip.marshalJSON(c.uidFieldName, c.previousUIDFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling IP: %w", err)
+	}
+	return data, nil
+}
+
+// unmarshalIP parses an IP in a response body, with its UID in the UID field
+// of the client, falling back to the previous UID field, if any.
+func (c *client) unmarshalIP(data []byte, ip *IPAddress) error {
+	return ip.unmarshalJSON(data, c.uidFieldName, c.previousUIDFieldName)
+}
+
+// unmarshalIPList parses a list of IPs in a response body like unmarshalIP.
+func (c *client) unmarshalIPList(data []byte, list *IPAddressList) error {
+	var rawList struct {
+		Count   uint              `json:"count"`
+		Results []json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(data, &rawList); err != nil {
+		return err
+	}
+	list.Count = rawList.Count
+	list.Results = make([]IPAddress, len(rawList.Results))
+	for i, result := range rawList.Results {
+		if err := c.unmarshalIP(result, &list.Results[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseUIDFields returns the custom fields of a request body that
+// clears the UID of an IP, in both the UID and the previous UID field.
+func (c *client) releaseUIDFields() map[string]interface{} {
+	fields := map[string]interface{}{c.uidFieldName: nil}
+	if c.previousUIDFieldName != "" {
+		fields[c.previousUIDFieldName] = nil
+	}
+	return fields
+}
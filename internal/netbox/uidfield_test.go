@@ -0,0 +1,211 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestUIDFieldName(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	tests := []struct {
+		name          string
+		previousField string
+		// custom fields of the IP with the UID, if any
+		storedFields string
+		// custom fields of another IP, with the UID in UIDCustomFieldName
+		otherFields     string
+		expectedQueries []string
+		expectedWritten map[string]interface{}
+	}{{
+		name:            "IP in the UID field",
+		storedFields:    fmt.Sprintf(`{"my_uid": %q, "netbox_ip_controller_uid": "d2b9a3f0-0b7c-4b6e-9f4a-1c2d3e4f5a6b"}`, uid),
+		expectedQueries: []string{"cf_my_uid=" + string(uid)},
+	}, {
+		name:            "UID of another tool in UIDCustomFieldName",
+		otherFields:     fmt.Sprintf(`{"netbox_ip_controller_uid": %q, "my_uid": null}`, uid),
+		expectedQueries: []string{"cf_my_uid=" + string(uid)},
+		expectedWritten: map[string]interface{}{"my_uid": string(uid)},
+	}, {
+		name:          "IP in the previous UID field",
+		previousField: UIDCustomFieldName,
+		otherFields:   fmt.Sprintf(`{"netbox_ip_controller_uid": %q, "my_uid": null}`, uid),
+		expectedQueries: []string{
+			"cf_my_uid=" + string(uid),
+			"cf_netbox_ip_controller_uid=" + string(uid),
+		},
+		expectedWritten: map[string]interface{}{"my_uid": string(uid), "netbox_ip_controller_uid": nil},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var queries []string
+			var written map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					queries = append(queries, r.URL.RawQuery)
+					switch {
+					case r.URL.Query().Get("cf_my_uid") == string(uid) && test.storedFields != "":
+						fmt.Fprintf(w, `{"count": 1, "results": [{"id": 1, "address": "192.168.0.1/32", "custom_fields": %s}]}`, test.storedFields)
+					case r.URL.Query().Get("cf_"+UIDCustomFieldName) == string(uid) && test.otherFields != "":
+						fmt.Fprintf(w, `{"count": 1, "results": [{"id": 2, "address": "192.168.0.1/32", "custom_fields": %s}]}`, test.otherFields)
+					default:
+						w.Write([]byte(`{"count": 0, "results": []}`))
+					}
+				default:
+					var body struct {
+						CustomFields map[string]interface{} `json:"custom_fields"`
+					}
+					json.NewDecoder(r.Body).Decode(&body)
+					written = body.CustomFields
+					w.Write([]byte(`{"id": 3, "address": "192.168.0.1/32", "custom_fields": {"my_uid": "` + string(uid) + `"}}`))
+				}
+			}))
+			defer server.Close()
+
+			opts := []ClientOption{WithUIDFieldName("my_uid")}
+			if test.previousField != "" {
+				opts = append(opts, WithPreviousUIDFieldName(test.previousField))
+			}
+			c, err := NewClient(server.URL, "foo", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ip, _, err := c.UpsertIP(context.Background(), &IPAddress{
+				UID:     uid,
+				Address: IP(netip.MustParseAddr("192.168.0.1")),
+			})
+			if err != nil {
+				t.Fatalf("upserting IP: %s", err)
+			}
+
+			if fmt.Sprint(queries) != fmt.Sprint(test.expectedQueries) {
+				t.Errorf("want queries %v, got %v", test.expectedQueries, queries)
+			}
+			if fmt.Sprint(written) != fmt.Sprint(test.expectedWritten) {
+				t.Errorf("want custom fields %v written, got %v", test.expectedWritten, written)
+			}
+			if test.expectedWritten != nil && ip.UID != uid {
+				t.Errorf("want UID %q, got %q", uid, ip.UID)
+			}
+		})
+	}
+}
+
+func TestUIDFieldNameKeepsFieldOfAnotherTool(t *testing.T) {
+	uid := UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+	otherUID := "d2b9a3f0-0b7c-4b6e-9f4a-1c2d3e4f5a6b"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"count": 1, "results": [{"id": 1, "address": "192.168.0.1/32", "custom_fields": {"my_uid": %q, %q: %q}}]}`,
+			uid, UIDCustomFieldName, otherUID)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "foo", WithUIDFieldName("my_uid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, err := c.GetIP(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("getting IP: %s", err)
+	}
+	if ip == nil || ip.UID != uid {
+		t.Fatalf("want IP with UID %q, got %v", uid, ip)
+	}
+	if ip.CustomFields[UIDCustomFieldName] != otherUID {
+		t.Errorf("want custom field %s of another tool kept, got %v", UIDCustomFieldName, ip.CustomFields)
+	}
+}
+
+func TestSameUIDFieldNames(t *testing.T) {
+	_, err := NewClient("http://netbox.example.com", "foo", WithUIDFieldName("my_uid"), WithPreviousUIDFieldName("my_uid"))
+	if err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+func TestMigrateUIDField(t *testing.T) {
+	tests := []struct {
+		name          string
+		existingField string
+		skipMigration bool
+		expectedPatch map[string]interface{}
+	}{{
+		name:          "up to date",
+		existingField: fmt.Sprintf(`{"id": 1, "name": %q, "label": "UID", "content_types": ["ipam.ipaddress"], "validation_regex": %q}`, UIDCustomFieldName, uidRegexpStr),
+	}, {
+		name:          "outdated validation regex",
+		existingField: fmt.Sprintf(`{"id": 1, "name": %q, "label": "UID", "content_types": ["ipam.ipaddress"], "validation_regex": "^[a-f0-9-]+$"}`, UIDCustomFieldName),
+		expectedPatch: map[string]interface{}{"validation_regex": uidRegexpStr},
+	}, {
+		name:          "outdated label and content types",
+		existingField: fmt.Sprintf(`{"id": 1, "name": %q, "label": "", "content_types": ["dcim.device"], "validation_regex": %q}`, UIDCustomFieldName, uidRegexpStr),
+		expectedPatch: map[string]interface{}{"label": "UID", "content_types": []interface{}{"dcim.device", "ipam.ipaddress"}},
+	}, {
+		name:          "migration skipped",
+		existingField: fmt.Sprintf(`{"id": 1, "name": %q, "label": "", "content_types": ["ipam.ipaddress"], "validation_regex": "^[a-f0-9-]+$"}`, UIDCustomFieldName),
+		skipMigration: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var patch map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					fmt.Fprintf(w, `{"count": 1, "results": [%s]}`, test.existingField)
+				case http.MethodPatch:
+					if r.URL.Path != "/extras/custom-fields/1/" {
+						t.Errorf("want field 1 patched, got %s", r.URL.Path)
+					}
+					json.NewDecoder(r.Body).Decode(&patch)
+					w.Write([]byte(test.existingField))
+				default:
+					t.Errorf("unexpected %s request", r.Method)
+				}
+			}))
+			defer server.Close()
+
+			var opts []ClientOption
+			if test.skipMigration {
+				opts = append(opts, WithoutUIDFieldMigration())
+			}
+			c, err := NewClient(server.URL, "foo", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.UpsertUIDField(context.Background()); err != nil {
+				t.Fatalf("upserting UID field: %s", err)
+			}
+			if fmt.Sprint(patch) != fmt.Sprint(test.expectedPatch) {
+				t.Errorf("want patch %v, got %v", test.expectedPatch, patch)
+			}
+		})
+	}
+}
@@ -0,0 +1,566 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package phpipam implements the IPAM client interface on top of phpIPAM API.
+package phpipam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/metrics"
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+	"github.com/digitalocean/netbox-ip-controller/internal/ratelimit"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	log "go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// UIDCustomField is the name of the phpIPAM address custom field
+	// containing the UID of the resource that an IP is assigned to.
+	UIDCustomField = "custom_netbox_ip_controller_uid"
+	// TagsCustomField is the name of the phpIPAM address custom field
+	// containing the comma-separated names of the tags of an IP.
+	TagsCustomField = "custom_netbox_ip_controller_tags"
+
+	// ID of the built-in phpIPAM "Offline" address tag
+	addressTagOffline = 1
+
+	// max size of response body that we ever expect to get, in bytes
+	responseBodySizeLimit = 1 << 20
+)
+
+var errNotFound = errors.New("not found")
+
+type client struct {
+	httpClient  *retryablehttp.Client
+	baseURL     string
+	token       string
+	subnetIDs   []int64
+	rateLimiter *rate.Limiter
+	logger      *log.Logger
+
+	mu      sync.Mutex
+	subnets []netip.Prefix
+}
+
+// ClientOption is a function type to pass options to NewClient
+type ClientOption func(*client) error
+
+// NewClient returns a client that stores IPs in phpIPAM, within the subnets
+// with the given IDs. Requests are authenticated with an app code token of the app
+// with the given ID. Tags of IPs are stored as comma-separated names in TagsCustomField,
+// and UIDs in UIDCustomField: both custom fields must be created in phpIPAM beforehand.
+func NewClient(apiURL, appID, token string, subnetIDs []int64, opts ...ClientOption) (netbox.Client, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse phpIPAM URL: %w", err)
+	} else if !u.IsAbs() || u.Hostname() == "" {
+		return nil, errors.New("phpIPAM URL must be in scheme://host:port format")
+	}
+	if appID == "" {
+		return nil, errors.New("phpIPAM app ID is required")
+	}
+	if len(subnetIDs) == 0 {
+		return nil, errors.New("at least one phpIPAM subnet ID is required")
+	}
+
+	c := &client{
+		httpClient: retryablehttp.NewClient(),
+		baseURL:    fmt.Sprintf("%s/%s", strings.TrimSuffix(u.String(), "/"), url.PathEscape(appID)),
+		token:      token,
+		subnetIDs:  subnetIDs,
+		logger:     log.L(),
+	}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	c.httpClient.RetryMax = 5
+	c.httpClient.Logger = nil
+
+	if c.rateLimiter == nil {
+		c.rateLimiter = rate.NewLimiter(rate.Inf, 1)
+	}
+
+	return c, nil
+}
+
+// WithLogger sets the logger to be used by the client.
+func WithLogger(logger *log.Logger) ClientOption {
+	return func(c *client) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithRateLimiter attaches a token bucket style rate limiter to the client.
+func WithRateLimiter(refillRate rate.Limit, bucketSize int) ClientOption {
+	return func(c *client) error {
+		c.rateLimiter = rate.NewLimiter(refillRate, bucketSize)
+		return nil
+	}
+}
+
+// WithSharedRateLimiter attaches the given rate limiter to the client.
+// Its limit and burst may be changed while the client is in use.
+func WithSharedRateLimiter(limiter *rate.Limiter) ClientOption {
+	return func(c *client) error {
+		c.rateLimiter = limiter
+		return nil
+	}
+}
+
+// flexInt is an integer that phpIPAM may return either as a number or as a string.
+type flexInt int64
+
+// UnmarshalJSON implements the json.Unmarshaler interface for flexInt.
+func (i *flexInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*i = 0
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parsing integer: %w", err)
+	}
+	*i = flexInt(v)
+	return nil
+}
+
+// response is the envelope of all phpIPAM API responses.
+type response struct {
+	Code    int             `json:"code"`
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	ID      flexInt         `json:"id"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type address struct {
+	ID          flexInt `json:"id,omitempty"`
+	SubnetID    flexInt `json:"subnetId,omitempty"`
+	IP          string  `json:"ip,omitempty"`
+	Hostname    string  `json:"hostname"`
+	Description string  `json:"description"`
+	UID         string  `json:"custom_netbox_ip_controller_uid"`
+	Tags        string  `json:"custom_netbox_ip_controller_tags"`
+}
+
+type subnet struct {
+	Subnet string  `json:"subnet"`
+	Mask   flexInt `json:"mask"`
+}
+
+// UpsertUIDField checks that the custom fields used by the controller exist.
+// phpIPAM API does not allow creating custom fields, so they must be
+// created by an administrator.
+func (c *client) UpsertUIDField(ctx context.Context) error {
+	var fields map[string]interface{}
+	if err := c.executeRequest(ctx, http.MethodGet, "/addresses/custom_fields/", nil, &fields); err != nil && !errors.Is(err, errNotFound) {
+		return fmt.Errorf("retrieving custom fields: %w", err)
+	}
+
+	for _, name := range []string{UIDCustomField, TagsCustomField} {
+		if _, ok := fields[name]; !ok {
+			return fmt.Errorf("custom field %q of IP addresses does not exist in phpIPAM and must be created by an administrator", strings.TrimPrefix(name, "custom_"))
+		}
+	}
+	return nil
+}
+
+// GetTag returns a tag with the given name. Tags are stored in phpIPAM as names
+// in a custom field, so every tag exists.
+func (c *client) GetTag(_ context.Context, tag string) (*netbox.Tag, error) {
+	return &netbox.Tag{Name: tag, Slug: tag}, nil
+}
+
+// CreateTag returns a tag with the given name, see GetTag.
+func (c *client) CreateTag(_ context.Context, tag string) (*netbox.Tag, error) {
+	return &netbox.Tag{Name: tag, Slug: tag}, nil
+}
+
+// GetIP returns an IP address with the given UID.
+func (c *client) GetIP(ctx context.Context, uid netbox.UID) (*netbox.IPAddress, error) {
+	addr, err := c.getAddress(ctx, uid)
+	if err != nil || addr == nil {
+		return nil, err
+	}
+	return toIPAddress(addr)
+}
+
+// getOwnedAddress returns the address with the given UID, like getAddress,
+// before it is deleted or released. Since looking it up by its UID and
+// changing it are separate requests, the address is read again by its ID,
+// and nil is returned if its UID no longer matches. The re-read and the
+// change are still separate requests, so this narrows the window in which
+// a re-used address may be changed, but does not close it.
+func (c *client) getOwnedAddress(ctx context.Context, uid netbox.UID) (*address, error) {
+	addr, err := c.getAddress(ctx, uid)
+	if err != nil || addr == nil {
+		return addr, err
+	}
+
+	var current address
+	path := fmt.Sprintf("/addresses/%d/", addr.ID)
+	if err := c.executeRequest(ctx, http.MethodGet, path, nil, &current); errors.Is(err, errNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("retrieving address: %w", err)
+	}
+	if current.UID != string(uid) {
+		c.logger.Warn("not changing IP: its UID no longer matches",
+			log.String("uid", string(uid)), log.Int64("id", int64(addr.ID)), log.String("currentUID", current.UID))
+		metrics.IncrementUIDMismatches()
+		return nil, nil
+	}
+	return &current, nil
+}
+
+func (c *client) getAddress(ctx context.Context, uid netbox.UID) (*address, error) {
+	var found []address
+	for _, id := range c.subnetIDs {
+		query := url.Values{}
+		query.Set("filter_by", UIDCustomField)
+		query.Set("filter_value", string(uid))
+
+		var addrs []address
+		path := fmt.Sprintf("/subnets/%d/addresses/?%s", id, query.Encode())
+		if err := c.executeRequest(ctx, http.MethodGet, path, nil, &addrs); errors.Is(err, errNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("retrieving addresses of subnet %d: %w", id, err)
+		}
+		found = append(found, addrs...)
+	}
+
+	if len(found) > 1 {
+		return nil, fmt.Errorf("more than one IP with UID %q found", uid)
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	return &found[0], nil
+}
+
+// ListIPs returns the IPs in the subnets of the client that match filter.
+// Filtering is done by the client, and custom fields are not supported.
+func (c *client) ListIPs(ctx context.Context, filter netbox.IPFilter) ([]netbox.IPAddress, error) {
+	if filter.CustomField != "" {
+		return nil, errors.New("filtering IPs by custom fields is not supported by phpIPAM")
+	}
+
+	var ips []netbox.IPAddress
+	for _, id := range c.subnetIDs {
+		var addrs []address
+		path := fmt.Sprintf("/subnets/%d/addresses/", id)
+		if err := c.executeRequest(ctx, http.MethodGet, path, nil, &addrs); errors.Is(err, errNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("retrieving addresses of subnet %d: %w", id, err)
+		}
+		for i := range addrs {
+			ip, err := toIPAddress(&addrs[i])
+			if err != nil {
+				return nil, err
+			}
+			if filter.Matches(ip) {
+				ips = append(ips, *ip)
+			}
+		}
+	}
+	return ips, nil
+}
+
+// UpsertIP creates an IP address or updates one, if an IP with the same
+// UID already exists. phpIPAM does not allow changing the address of
+// an existing IP, so if it has changed, the IP is recreated.
+func (c *client) UpsertIP(ctx context.Context, ip *netbox.IPAddress) (*netbox.IPAddress, netbox.UpsertResult, error) {
+	existing, err := c.getAddress(ctx, ip.UID)
+	if err != nil {
+		return nil, netbox.UpsertUnchanged, fmt.Errorf("checking for existing IP: %w", err)
+	}
+	if existing == nil && ip.TakeOverUID != "" {
+		if existing, err = c.getAddress(ctx, ip.TakeOverUID); err != nil {
+			return nil, netbox.UpsertUnchanged, fmt.Errorf("checking for IP to take over: %w", err)
+		}
+	}
+
+	desired := address{
+		Hostname:    ip.DNSName,
+		Description: ip.Description,
+		UID:         string(ip.UID),
+		Tags:        tagsString(ip.Tags),
+	}
+
+	if existing != nil {
+		existingAddr, err := netip.ParseAddr(existing.IP)
+		if err == nil && existingAddr == netip.Addr(ip.Address) {
+			if existing.Hostname == desired.Hostname && existing.Description == desired.Description &&
+				existing.UID == desired.UID && existing.Tags == desired.Tags {
+				c.logger.Info("IP has not changed - not updating")
+				unchanged, err := toIPAddress(existing)
+				return unchanged, netbox.UpsertUnchanged, err
+			}
+
+			path := fmt.Sprintf("/addresses/%d/", existing.ID)
+			if err := c.executeRequest(ctx, http.MethodPatch, path, desired, nil); err != nil {
+				return nil, netbox.UpsertUnchanged, fmt.Errorf("updating IP: %w", err)
+			}
+			desired.ID = existing.ID
+			desired.IP = existing.IP
+			upserted, err := toIPAddress(&desired)
+			return upserted, netbox.UpsertUpdated, err
+		}
+
+		if err := c.deleteAddress(ctx, existing); err != nil {
+			return nil, netbox.UpsertUnchanged, fmt.Errorf("deleting IP with outdated address: %w", err)
+		}
+	}
+
+	subnetID, err := c.subnetFor(ctx, netip.Addr(ip.Address))
+	if err != nil {
+		return nil, netbox.UpsertUnchanged, err
+	}
+	desired.SubnetID = flexInt(subnetID)
+	desired.IP = netip.Addr(ip.Address).String()
+
+	res, err := c.do(ctx, http.MethodPost, "/addresses/", desired)
+	if err != nil {
+		return nil, netbox.UpsertUnchanged, fmt.Errorf("creating IP: %w", err)
+	}
+	desired.ID = res.ID
+	// an IP recreated with a new address is still an update of the same UID
+	upserted, err := toIPAddress(&desired)
+	if existing != nil {
+		return upserted, netbox.UpsertUpdated, err
+	}
+	return upserted, netbox.UpsertCreated, err
+}
+
+// DeleteIP deletes an IP with the given UID from phpIPAM.
+func (c *client) DeleteIP(ctx context.Context, uid netbox.UID) error {
+	existing, err := c.getOwnedAddress(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("checking if IP exists: %w", err)
+	}
+	if existing == nil {
+		return nil
+	}
+	return c.deleteAddress(ctx, existing)
+}
+
+// ReleaseIP clears the UID of an IP with the given UID in phpIPAM.
+func (c *client) ReleaseIP(ctx context.Context, uid netbox.UID) error {
+	existing, err := c.getOwnedAddress(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("checking if IP exists: %w", err)
+	}
+	if existing == nil {
+		return nil
+	}
+
+	path := fmt.Sprintf("/addresses/%d/", existing.ID)
+	body := map[string]string{"custom_netbox_ip_controller_uid": ""}
+	if err := c.executeRequest(ctx, http.MethodPatch, path, body, nil); err != nil {
+		return fmt.Errorf("releasing IP: %w", err)
+	}
+	return nil
+}
+
+// DeprecateIP marks an IP with the given UID in phpIPAM as offline,
+// which is the closest phpIPAM has to a deprecated status, appends
+// note to its description, and clears its UID.
+func (c *client) DeprecateIP(ctx context.Context, uid netbox.UID, note string) error {
+	existing, err := c.getOwnedAddress(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("checking if IP exists: %w", err)
+	}
+	if existing == nil {
+		return nil
+	}
+
+	path := fmt.Sprintf("/addresses/%d/", existing.ID)
+	body := map[string]interface{}{
+		"tag":                             addressTagOffline,
+		"description":                     netbox.AppendNote(existing.Description, note),
+		"custom_netbox_ip_controller_uid": "",
+	}
+	if err := c.executeRequest(ctx, http.MethodPatch, path, body, nil); err != nil {
+		return fmt.Errorf("deprecating IP: %w", err)
+	}
+	return nil
+}
+
+func (c *client) deleteAddress(ctx context.Context, addr *address) error {
+	path := fmt.Sprintf("/addresses/%d/", addr.ID)
+	if err := c.executeRequest(ctx, http.MethodDelete, path, nil, nil); err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	return nil
+}
+
+// subnetFor returns the ID of the configured subnet containing the given IP.
+func (c *client) subnetFor(ctx context.Context, ip netip.Addr) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subnets == nil {
+		var prefixes []netip.Prefix
+		for _, id := range c.subnetIDs {
+			var s subnet
+			if err := c.executeRequest(ctx, http.MethodGet, fmt.Sprintf("/subnets/%d/", id), nil, &s); err != nil {
+				return 0, fmt.Errorf("retrieving subnet %d: %w", id, err)
+			}
+			prefix, err := netip.ParsePrefix(fmt.Sprintf("%s/%d", s.Subnet, s.Mask))
+			if err != nil {
+				return 0, fmt.Errorf("parsing subnet %d: %w", id, err)
+			}
+			prefixes = append(prefixes, prefix)
+		}
+		c.subnets = prefixes
+	}
+
+	for i, prefix := range c.subnets {
+		if prefix.Contains(ip) {
+			return c.subnetIDs[i], nil
+		}
+	}
+	return 0, fmt.Errorf("IP %s is not within any of the configured phpIPAM subnets", ip)
+}
+
+// executeRequest sends a request to phpIPAM, and unmarshals the data
+// of the response into out, unless it is nil.
+func (c *client) executeRequest(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	res, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(res.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(res.Data, out); err != nil {
+		return fmt.Errorf("unmarshaling response data: %w", err)
+	}
+	return nil
+}
+
+func (c *client) do(ctx context.Context, method, path string, body interface{}) (*response, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("marshaling body: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("token", c.token)
+
+	if err := ratelimit.Limiter(ctx, c.rateLimiter).Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	var httpRes *http.Response
+	if method == http.MethodPost || method == http.MethodPatch {
+		// non-idempotent method - we should not retry it
+		httpRes, err = c.httpClient.HTTPClient.Do(req)
+	} else {
+		var retryableReq *retryablehttp.Request
+		if retryableReq, err = retryablehttp.FromRequest(req); err != nil {
+			return nil, fmt.Errorf("creating retryable request: %w", err)
+		}
+		httpRes, err = c.httpClient.Do(retryableReq)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(httpRes.Body, responseBodySizeLimit))
+	if err != nil {
+		return nil, errors.New("reading response data")
+	}
+
+	var res response
+	if err := json.Unmarshal(data, &res); err != nil {
+		if httpRes.StatusCode == http.StatusNotFound {
+			return nil, errNotFound
+		}
+		return nil, fmt.Errorf("%s: unmarshaling response: %w", httpRes.Status, err)
+	}
+	if httpRes.StatusCode == http.StatusNotFound || res.Code == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if httpRes.StatusCode < 200 || httpRes.StatusCode > 299 || !res.Success {
+		return nil, fmt.Errorf("%s: %s", httpRes.Status, res.Message)
+	}
+	return &res, nil
+}
+
+func toIPAddress(addr *address) (*netbox.IPAddress, error) {
+	ip, err := netip.ParseAddr(addr.IP)
+	if err != nil {
+		return nil, fmt.Errorf("parsing address: %w", err)
+	}
+
+	var tags []netbox.Tag
+	for _, name := range strings.Split(addr.Tags, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			tags = append(tags, netbox.Tag{Name: name, Slug: name})
+		}
+	}
+
+	return &netbox.IPAddress{
+		ID:          int64(addr.ID),
+		UID:         netbox.UID(addr.UID),
+		DNSName:     addr.Hostname,
+		Address:     netbox.IP(ip),
+		Tags:        tags,
+		Description: addr.Description,
+	}, nil
+}
+
+func tagsString(tags []netbox.Tag) string {
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,376 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package phpipam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/digitalocean/netbox-ip-controller/internal/netbox"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// fakeServer is a minimal in-memory implementation of phpIPAM API
+// with a single subnet 10.0.0.0/24 of ID 7.
+type fakeServer struct {
+	mu           sync.Mutex
+	customFields bool
+	addresses    map[int64]address
+	nextID       int64
+	// reuseAfterLookup, if not empty, is the UID the addresses found
+	// by their UID are given after the lookup, as if they were re-used
+	reuseAfterLookup string
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reply := func(code int, data interface{}, id int64) {
+		w.WriteHeader(code)
+		res := map[string]interface{}{"code": code, "success": code < 300, "data": data}
+		if id != 0 {
+			res["id"] = strconv.FormatInt(id, 10)
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}
+
+	if r.Header.Get("token") != "secret" {
+		reply(http.StatusUnauthorized, nil, 0)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/k8s")
+	switch {
+	case r.Method == http.MethodGet && path == "/addresses/custom_fields/":
+		if !s.customFields {
+			reply(http.StatusOK, nil, 0)
+			return
+		}
+		reply(http.StatusOK, map[string]interface{}{
+			UIDCustomField:  map[string]string{"type": "varchar(255)"},
+			TagsCustomField: map[string]string{"type": "varchar(255)"},
+		}, 0)
+	case r.Method == http.MethodGet && path == "/subnets/7/":
+		reply(http.StatusOK, map[string]string{"id": "7", "subnet": "10.0.0.0", "mask": "24"}, 0)
+	case r.Method == http.MethodGet && path == "/subnets/7/addresses/":
+		var found []address
+		for _, a := range s.addresses {
+			if !r.URL.Query().Has("filter_by") || (r.URL.Query().Get("filter_by") == UIDCustomField && a.UID == r.URL.Query().Get("filter_value")) {
+				found = append(found, a)
+				if s.reuseAfterLookup != "" {
+					a.UID = s.reuseAfterLookup
+					s.addresses[int64(a.ID)] = a
+				}
+			}
+		}
+		if len(found) == 0 {
+			reply(http.StatusNotFound, nil, 0)
+			return
+		}
+		reply(http.StatusOK, found, 0)
+	case r.Method == http.MethodPost && path == "/addresses/":
+		var a address
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			reply(http.StatusBadRequest, nil, 0)
+			return
+		}
+		s.nextID++
+		a.ID = flexInt(s.nextID)
+		s.addresses[s.nextID] = a
+		reply(http.StatusCreated, a.IP, s.nextID)
+	case strings.HasPrefix(path, "/addresses/"):
+		id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(path, "/addresses/"), "/"), 10, 64)
+		existing, ok := s.addresses[id]
+		if err != nil || !ok {
+			reply(http.StatusNotFound, nil, 0)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			reply(http.StatusOK, existing, 0)
+		case http.MethodPatch:
+			var a address
+			if err := json.NewDecoder(r.Body).Decode(&a); err != nil || a.IP != "" {
+				reply(http.StatusBadRequest, nil, 0)
+				return
+			}
+			a.ID, a.SubnetID, a.IP = existing.ID, existing.SubnetID, existing.IP
+			s.addresses[id] = a
+			reply(http.StatusOK, nil, 0)
+		case http.MethodDelete:
+			delete(s.addresses, id)
+			reply(http.StatusOK, nil, 0)
+		default:
+			reply(http.StatusMethodNotAllowed, nil, 0)
+		}
+	default:
+		reply(http.StatusNotFound, nil, 0)
+	}
+}
+
+func newTestClient(t *testing.T, s *fakeServer) netbox.Client {
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient(srv.URL+"/api", "k8s", "secret", []int64{7})
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+	return c
+}
+
+func TestUpsertUIDField(t *testing.T) {
+	tests := []struct {
+		name          string
+		customFields  bool
+		errorExpected bool
+	}{{
+		name:          "custom fields missing",
+		errorExpected: true,
+	}, {
+		name:         "custom fields exist",
+		customFields: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestClient(t, &fakeServer{customFields: test.customFields})
+
+			err := c.UpsertUIDField(context.Background())
+			if err != nil && !test.errorExpected {
+				t.Errorf("want no error, got: %q", err)
+			} else if err == nil && test.errorExpected {
+				t.Error("want an error, got nil")
+			}
+		})
+	}
+}
+
+func TestIPLifecycle(t *testing.T) {
+	ctx := context.Background()
+	s := &fakeServer{addresses: make(map[int64]address)}
+	c := newTestClient(t, s)
+
+	ip := &netbox.IPAddress{
+		UID:         "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4",
+		DNSName:     "pod.default.cluster.local",
+		Address:     netbox.IP(netip.MustParseAddr("10.0.0.5")),
+		Tags:        []netbox.Tag{{Name: "k8s-pod", Slug: "k8s-pod"}, {Name: "kubernetes", Slug: "kubernetes"}},
+		Description: "app: foo",
+	}
+
+	steps := []struct {
+		name string
+		// modifies the IP before it is upserted
+		modify       func(ip *netbox.IPAddress)
+		expectResult netbox.UpsertResult
+		expectStored address
+	}{{
+		name:         "create",
+		modify:       func(*netbox.IPAddress) {},
+		expectResult: netbox.UpsertCreated,
+		expectStored: address{
+			ID: 1, SubnetID: 7, IP: "10.0.0.5", Hostname: "pod.default.cluster.local",
+			Description: "app: foo", UID: string(ip.UID), Tags: "k8s-pod,kubernetes",
+		},
+	}, {
+		name:         "unchanged",
+		modify:       func(*netbox.IPAddress) {},
+		expectResult: netbox.UpsertUnchanged,
+		expectStored: address{
+			ID: 1, SubnetID: 7, IP: "10.0.0.5", Hostname: "pod.default.cluster.local",
+			Description: "app: foo", UID: string(ip.UID), Tags: "k8s-pod,kubernetes",
+		},
+	}, {
+		name:         "description changed",
+		modify:       func(ip *netbox.IPAddress) { ip.Description = "app: bar" },
+		expectResult: netbox.UpsertUpdated,
+		expectStored: address{
+			ID: 1, SubnetID: 7, IP: "10.0.0.5", Hostname: "pod.default.cluster.local",
+			Description: "app: bar", UID: string(ip.UID), Tags: "k8s-pod,kubernetes",
+		},
+	}, {
+		name:         "address changed",
+		modify:       func(ip *netbox.IPAddress) { ip.Address = netbox.IP(netip.MustParseAddr("10.0.0.6")) },
+		expectResult: netbox.UpsertUpdated,
+		expectStored: address{
+			ID: 2, SubnetID: 7, IP: "10.0.0.6", Hostname: "pod.default.cluster.local",
+			Description: "app: bar", UID: string(ip.UID), Tags: "k8s-pod,kubernetes",
+		},
+	}}
+
+	for _, step := range steps {
+		step.modify(ip)
+		upserted, result, err := c.UpsertIP(ctx, ip)
+		if err != nil {
+			t.Fatalf("%s: upserting IP: %s", step.name, err)
+		}
+		if upserted == nil || upserted.UID != ip.UID {
+			t.Errorf("%s: want upserted IP with UID %q, got %v", step.name, ip.UID, upserted)
+		}
+		if result != step.expectResult {
+			t.Errorf("%s: want result %d, got %d", step.name, step.expectResult, result)
+		}
+		if len(s.addresses) != 1 {
+			t.Fatalf("%s: want 1 stored address, got %d", step.name, len(s.addresses))
+		}
+		if diff := cmp.Diff(step.expectStored, s.addresses[int64(step.expectStored.ID)]); diff != "" {
+			t.Errorf("%s: stored address (-want, +got)\n%s", step.name, diff)
+		}
+	}
+
+	got, err := c.GetIP(ctx, ip.UID)
+	if err != nil {
+		t.Fatalf("getting IP: %s", err)
+	}
+	want := *ip
+	want.ID = 2
+	if diff := cmp.Diff(&want, got,
+		cmp.Comparer(func(x, y netbox.IP) bool { return netip.Addr(x) == netip.Addr(y) }),
+		cmpopts.IgnoreUnexported(netbox.Tag{}),
+	); diff != "" {
+		t.Errorf("IP (-want, +got)\n%s", diff)
+	}
+
+	if err := c.DeleteIP(ctx, ip.UID); err != nil {
+		t.Fatalf("deleting IP: %s", err)
+	}
+	if len(s.addresses) != 0 {
+		t.Errorf("want no stored addresses, got %v", s.addresses)
+	}
+	if got, err := c.GetIP(ctx, ip.UID); err != nil || got != nil {
+		t.Errorf("want no IP after deletion, got %v, %v", got, err)
+	}
+}
+
+func TestOwnedIPChecksUID(t *testing.T) {
+	uid := netbox.UID("6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4")
+
+	ops := map[string]func(c netbox.Client) error{
+		"delete":    func(c netbox.Client) error { return c.DeleteIP(context.Background(), uid) },
+		"release":   func(c netbox.Client) error { return c.ReleaseIP(context.Background(), uid) },
+		"deprecate": func(c netbox.Client) error { return c.DeprecateIP(context.Background(), uid, "deprecated") },
+	}
+
+	for name, op := range ops {
+		t.Run(name, func(t *testing.T) {
+			s := &fakeServer{addresses: make(map[int64]address)}
+			c := newTestClient(t, s)
+
+			ip := &netbox.IPAddress{UID: uid, Address: netbox.IP(netip.MustParseAddr("10.0.0.5"))}
+			if _, _, err := c.UpsertIP(context.Background(), ip); err != nil {
+				t.Fatalf("creating IP: %s", err)
+			}
+			want := s.addresses[1]
+
+			// the address is re-used by another object right after it is looked up
+			s.reuseAfterLookup = "other-uid"
+			if err := op(c); err != nil {
+				t.Fatal(err)
+			}
+
+			want.UID = "other-uid"
+			if diff := cmp.Diff(want, s.addresses[1]); diff != "" {
+				t.Errorf("want re-used address to be unchanged (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpsertIPOutsideOfSubnets(t *testing.T) {
+	c := newTestClient(t, &fakeServer{addresses: make(map[int64]address)})
+
+	_, _, err := c.UpsertIP(context.Background(), &netbox.IPAddress{
+		UID:     "6bcb4ab2-9b6b-4a1b-a4e3-e7e6e3b8f0e4",
+		Address: netbox.IP(netip.MustParseAddr("192.168.0.1")),
+	})
+	if err == nil || !strings.Contains(err.Error(), "not within any of the configured phpIPAM subnets") {
+		t.Errorf("want an error about subnets, got %v", err)
+	}
+}
+
+func TestFlexInt(t *testing.T) {
+	for _, in := range []string{`12`, `"12"`} {
+		var i flexInt
+		if err := json.Unmarshal([]byte(in), &i); err != nil || i != 12 {
+			t.Errorf("unmarshaling %s: want 12, got %d, %v", in, i, err)
+		}
+	}
+}
+
+func TestListIPs(t *testing.T) {
+	s := &fakeServer{customFields: true, addresses: make(map[int64]address)}
+	c := newTestClient(t, s)
+
+	if ips, err := c.ListIPs(context.Background(), netbox.IPFilter{}); err != nil || len(ips) != 0 {
+		t.Fatalf("want no IPs in an empty subnet, got %v, %v", ips, err)
+	}
+
+	s.addresses[1] = address{ID: 1, SubnetID: 7, IP: "10.0.0.1", UID: "a", Tags: "pod"}
+	s.addresses[2] = address{ID: 2, SubnetID: 7, IP: "10.0.0.2", UID: "b"}
+	s.addresses[3] = address{ID: 3, SubnetID: 7, IP: "10.0.0.3", Tags: "pod"}
+
+	tests := []struct {
+		name        string
+		filter      netbox.IPFilter
+		expectedIDs []int64
+	}{{
+		name:        "all",
+		expectedIDs: []int64{1, 2, 3},
+	}, {
+		name:        "managed",
+		filter:      netbox.IPFilter{Managed: true},
+		expectedIDs: []int64{1, 2},
+	}, {
+		name:        "managed with tag",
+		filter:      netbox.IPFilter{Managed: true, Tag: "pod"},
+		expectedIDs: []int64{1},
+	}, {
+		name:        "by prefix",
+		filter:      netbox.IPFilter{Prefix: netip.MustParsePrefix("10.0.0.2/31")},
+		expectedIDs: []int64{2, 3},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ips, err := c.ListIPs(context.Background(), test.filter)
+			if err != nil {
+				t.Fatalf("listing IPs: %s", err)
+			}
+			var ids []int64
+			for _, ip := range ips {
+				ids = append(ids, ip.ID)
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+			if fmt.Sprint(ids) != fmt.Sprint(test.expectedIDs) {
+				t.Errorf("want IPs %v, got %v", test.expectedIDs, ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ratelimit lets reconciliations limit their requests to the
+// IPAM backend with the rate budget of their controller, instead of
+// the rate limiter that the client shares with all controllers.
+package ratelimit
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+type limiterKey struct{}
+
+// WithLimiter returns a context whose requests to the IPAM
+// backend wait for the given limiter, if it is not nil,
+// rather than for the rate limiter of the client.
+func WithLimiter(ctx context.Context, limiter *rate.Limiter) context.Context {
+	if limiter == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, limiterKey{}, limiter)
+}
+
+// Limiter returns the limiter of the context, if it was
+// returned by WithLimiter, and the given fallback otherwise.
+func Limiter(ctx context.Context, fallback *rate.Limiter) *rate.Limiter {
+	if limiter, ok := ctx.Value(limiterKey{}).(*rate.Limiter); ok {
+		return limiter
+	}
+	return fallback
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestLimiter(t *testing.T) {
+	shared := rate.NewLimiter(10, 1)
+	own := rate.NewLimiter(5, 1)
+
+	if got := Limiter(context.Background(), shared); got != shared {
+		t.Error("want shared limiter without limiter in context")
+	}
+	if got := Limiter(WithLimiter(context.Background(), nil), shared); got != shared {
+		t.Error("want shared limiter with nil limiter in context")
+	}
+	if got := Limiter(WithLimiter(context.Background(), own), shared); got != own {
+		t.Error("want limiter of context")
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timing
+
+import (
+	"context"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// KubeClient returns a client that records the time spent
+// by the calls of the given client as time spent waiting
+// for Kubernetes. Reads served from a cache are included.
+func KubeClient(c client.Client) client.Client {
+	return &kubeClient{Client: c}
+}
+
+type kubeClient struct {
+	client.Client
+}
+
+func (c *kubeClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	defer Record(ctx, Kubernetes, time.Now())
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *kubeClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	defer Record(ctx, Kubernetes, time.Now())
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *kubeClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	defer Record(ctx, Kubernetes, time.Now())
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *kubeClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	defer Record(ctx, Kubernetes, time.Now())
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *kubeClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	defer Record(ctx, Kubernetes, time.Now())
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *kubeClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	defer Record(ctx, Kubernetes, time.Now())
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *kubeClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	defer Record(ctx, Kubernetes, time.Now())
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *kubeClient) Status() client.SubResourceWriter {
+	return &subResourceClient{writer: c.Client.Status()}
+}
+
+func (c *kubeClient) SubResource(subResource string) client.SubResourceClient {
+	sc := c.Client.SubResource(subResource)
+	return &subResourceClient{reader: sc, writer: sc}
+}
+
+type subResourceClient struct {
+	reader client.SubResourceReader
+	writer client.SubResourceWriter
+}
+
+func (c *subResourceClient) Get(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceGetOption) error {
+	defer Record(ctx, Kubernetes, time.Now())
+	return c.reader.Get(ctx, obj, subResource, opts...)
+}
+
+func (c *subResourceClient) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	defer Record(ctx, Kubernetes, time.Now())
+	return c.writer.Create(ctx, obj, subResource, opts...)
+}
+
+func (c *subResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	defer Record(ctx, Kubernetes, time.Now())
+	return c.writer.Update(ctx, obj, opts...)
+}
+
+func (c *subResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	defer Record(ctx, Kubernetes, time.Now())
+	return c.writer.Patch(ctx, obj, patch, opts...)
+}
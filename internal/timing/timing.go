@@ -0,0 +1,88 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package timing breaks down the time spent by reconciliations
+// by the dependencies that they wait for.
+package timing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+// Dependencies that reconciliations wait for.
+const (
+	// RateLimiter is the rate limiter of requests to NetBox.
+	RateLimiter = "rateLimiter"
+	NetBox      = "netbox"
+	Kubernetes  = "kubernetes"
+)
+
+var dependencies = []string{RateLimiter, NetBox, Kubernetes}
+
+type breakdownKey struct{}
+
+// Breakdown is the time spent by a reconciliation waiting for each dependency.
+type Breakdown struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+}
+
+// WithBreakdown returns a context that the time
+// spent waiting for dependencies is recorded to.
+func WithBreakdown(ctx context.Context) (context.Context, *Breakdown) {
+	b := &Breakdown{durations: make(map[string]time.Duration)}
+	return context.WithValue(ctx, breakdownKey{}, b), b
+}
+
+// Record adds the time since start to the time spent waiting for the
+// dependency. It does nothing if ctx was not returned by WithBreakdown.
+func Record(ctx context.Context, dependency string, start time.Time) {
+	b, ok := ctx.Value(breakdownKey{}).(*Breakdown)
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.durations[dependency] += time.Since(start)
+}
+
+// Get returns the time spent waiting for the dependency.
+func (b *Breakdown) Get(dependency string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.durations[dependency]
+}
+
+// Fields returns the time spent waiting for each dependency, and the
+// rest of the given total time, as log fields.
+func (b *Breakdown) Fields(total time.Duration) []log.Field {
+	fields := []log.Field{log.Duration("total", total)}
+	other := total
+	for _, dependency := range dependencies {
+		d := b.Get(dependency)
+		fields = append(fields, log.Duration(dependency, d))
+		other -= d
+	}
+	if other < 0 {
+		// dependencies may have been waited for concurrently
+		other = 0
+	}
+	return append(fields, log.Duration("other", other))
+}
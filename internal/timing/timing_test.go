@@ -0,0 +1,49 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timing
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	ctx, breakdown := WithBreakdown(context.Background())
+
+	Record(ctx, NetBox, time.Now().Add(-2*time.Second))
+	Record(ctx, NetBox, time.Now().Add(-time.Second))
+	Record(ctx, RateLimiter, time.Now().Add(-time.Second))
+	// not recorded anywhere, must not panic
+	Record(context.Background(), Kubernetes, time.Now())
+
+	if d := breakdown.Get(NetBox); d < 3*time.Second || d > 4*time.Second {
+		t.Errorf("want about 3s waiting for NetBox, got %s", d)
+	}
+	if d := breakdown.Get(Kubernetes); d != 0 {
+		t.Errorf("want no time waiting for Kubernetes, got %s", d)
+	}
+
+	fields := breakdown.Fields(10 * time.Second)
+	if len(fields) != 5 {
+		t.Fatalf("want 5 fields, got %d", len(fields))
+	}
+	other := time.Duration(fields[4].Integer)
+	if fields[4].Key != "other" || other < 5*time.Second || other > 6*time.Second {
+		t.Errorf("want about 6s of other time, got %s=%s", fields[4].Key, other)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook notifies external systems about the lifecycle of published IPs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+)
+
+// Types of IP lifecycle events.
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+	// EventReleased is sent when an IP is kept in the IPAM
+	// system, but is no longer managed by the controller.
+	EventReleased = "released"
+	// EventDeprecated is sent when an IP is kept in the IPAM system
+	// with a deprecated status, and is no longer managed by the controller.
+	EventDeprecated = "deprecated"
+)
+
+// Event describes a change of an IP that was synced to the IPAM system.
+type Event struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	UID         string    `json:"uid"`
+	Address     string    `json:"address"`
+	DNSName     string    `json:"dnsName,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	// Source is the Kubernetes object that the IP belongs to, if known.
+	Source *Source `json:"source,omitempty"`
+}
+
+// Source identifies a Kubernetes object.
+type Source struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+}
+
+// Sink receives IP lifecycle events.
+type Sink interface {
+	Send(ctx context.Context, e Event) error
+}
+
+type httpSink struct {
+	url        string
+	httpClient *retryablehttp.Client
+}
+
+// NewHTTPSink returns a Sink that POSTs every event as JSON to the given URL.
+// Failed requests are retried a few times, each attempt limited by timeout.
+func NewHTTPSink(sinkURL string, timeout time.Duration) (Sink, error) {
+	u, err := url.Parse(sinkURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webhook URL: %w", err)
+	} else if !u.IsAbs() || u.Hostname() == "" {
+		return nil, errors.New("webhook URL must be in scheme://host:port/path format")
+	}
+
+	httpClient := retryablehttp.NewClient()
+	httpClient.RetryMax = 3
+	httpClient.Logger = nil
+	httpClient.HTTPClient.Timeout = timeout
+
+	return &httpSink{url: u.String(), httpClient: httpClient}, nil
+}
+
+// Send posts the event to the webhook URL.
+func (s *httpSink) Send(ctx context.Context, e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshaling event: %w", err)
+	}
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<20))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2022 DigitalOcean
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at:
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHTTPSink(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		errorExpected bool
+	}{{
+		name:   "delivered",
+		status: http.StatusNoContent,
+	}, {
+		name:          "rejected",
+		status:        http.StatusBadRequest,
+		errorExpected: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var received []Event
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var e Event
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("unexpected request: %s %s", r.Method, r.Header.Get("Content-Type"))
+				}
+				if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+					t.Errorf("decoding event: %s", err)
+				}
+				received = append(received, e)
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+
+			sink, err := NewHTTPSink(server.URL+"/events", time.Second)
+			if err != nil {
+				t.Fatalf("creating sink: %s", err)
+			}
+
+			e := Event{
+				Type:    EventCreated,
+				Time:    time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+				UID:     "123abc",
+				Address: "192.168.0.1",
+				Tags:    []string{"kubernetes"},
+				Source:  &Source{APIVersion: "v1", Kind: "Service", Namespace: "default", Name: "foo", UID: "456def"},
+			}
+			err = sink.Send(context.Background(), e)
+			if err != nil && !test.errorExpected {
+				t.Errorf("want no error, got: %q", err)
+			} else if err == nil && test.errorExpected {
+				t.Error("want an error, got nil")
+			}
+
+			// client errors are not retried
+			if diff := cmp.Diff([]Event{e}, received); diff != "" {
+				t.Errorf("received events (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
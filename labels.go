@@ -19,3 +19,49 @@ package netboxipcontroller
 // NameLabel stores the name of the k8s object associated
 // with the given NetBoxIP.
 const NameLabel = "netbox.digitalocean.com/name"
+
+// ManualLabel set to "true" on a NetBoxIP marks it as created by hand (or by
+// another tool) rather than for a pod or service. The pod and service
+// reconcilers never update or delete such NetBoxIPs, even if they have the
+// names or the name label of their own, and neither do any other sweepers of
+// stale NetBoxIPs. Their IPs are still published by the NetBoxIP reconciler.
+const ManualLabel = "netbox.digitalocean.com/manual"
+
+// TenantAnnotation on a pod overrides the NetBox tenant of its IPs,
+// if annotation overrides are enabled.
+const TenantAnnotation = "netbox.digitalocean.com/tenant"
+
+// VRFAnnotation on a pod sets the name of the NetBox VRF of its IPs,
+// if annotation overrides are enabled.
+const VRFAnnotation = "netbox.digitalocean.com/vrf"
+
+// ClusterDomainAnnotation on a namespace overrides the cluster domain
+// in the DNS names of IPs of services in the namespace, if namespace
+// cluster domains are enabled.
+const ClusterDomainAnnotation = "netbox.digitalocean.com/cluster-domain"
+
+// ResyncAnnotation on a NetBoxIP is set to the current time by the resync
+// command, so that the IP is upserted in NetBox again even if the NetBoxIP
+// has not changed, e.g. after NetBox was restored from a backup.
+const ResyncAnnotation = "netbox.digitalocean.com/resync"
+
+// AllocatePrefixAnnotation on a namespace requests a prefix to be allocated
+// for it in NetBox, if namespace prefixes are enabled. Its value is either
+// "true", for a prefix of the configured length, or a prefix length.
+const AllocatePrefixAnnotation = "netbox.digitalocean.com/allocate-prefix"
+
+// PrefixAnnotation on a namespace is set to the prefix allocated
+// for it in NetBox in response to AllocatePrefixAnnotation.
+const PrefixAnnotation = "netbox.digitalocean.com/prefix"
+
+// AllocateLoadBalancerIPAnnotation set to "true" on a LoadBalancer service
+// requests an IP to be allocated for it in NetBox, if load balancer IP
+// allocation is enabled, which is then written to the VIP annotation
+// of the load balancer implementation, e.g. MetalLB.
+const AllocateLoadBalancerIPAnnotation = "netbox.digitalocean.com/allocate-load-balancer-ip"
+
+// TakeOverAnnotation on a NetBoxIP is the UID of an IP in NetBox that is
+// taken over when the IP of the NetBoxIP is first published, rather than
+// creating another one, e.g. an IP allocated for a service before the
+// NetBoxIP of its address was created.
+const TakeOverAnnotation = "netbox.digitalocean.com/take-over-uid"